@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/gitutil"
+)
+
+type agentOptions struct {
+	configPath    string
+	provider      string
+	model         string
+	effort        string
+	repoPath      string
+	transcriptDir string
+	resume        bool
+	maxTurns      int
+	prompt        string
+}
+
+func parseAgentArgs(args []string) (*agentOptions, error) {
+	opts := &agentOptions{}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		flagValue := func() (string, error) {
+			i++
+			if i >= len(args) {
+				return "", fmt.Errorf("%s requires a value", args[i-1])
+			}
+			return args[i], nil
+		}
+		var err error
+		switch args[i] {
+		case "--config":
+			opts.configPath, err = flagValue()
+		case "--provider":
+			opts.provider, err = flagValue()
+		case "--model":
+			opts.model, err = flagValue()
+		case "--effort":
+			opts.effort, err = flagValue()
+		case "--repo":
+			opts.repoPath, err = flagValue()
+		case "--transcript-dir":
+			opts.transcriptDir, err = flagValue()
+		case "--resume":
+			opts.transcriptDir, err = flagValue()
+			opts.resume = true
+		case "--max-turns":
+			var v string
+			if v, err = flagValue(); err == nil {
+				n, convErr := strconv.Atoi(v)
+				if convErr != nil || n < 1 {
+					return nil, fmt.Errorf("--max-turns must be a positive integer")
+				}
+				opts.maxTurns = n
+			}
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return nil, fmt.Errorf("unknown flag: %s", args[i])
+			}
+			positional = append(positional, args[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.configPath == "" {
+		return nil, fmt.Errorf("--config is required")
+	}
+	if opts.provider == "" && strings.Contains(opts.model, "/") {
+		opts.provider, opts.model, _ = strings.Cut(opts.model, "/")
+	}
+	if opts.model == "" {
+		return nil, fmt.Errorf("--model is required")
+	}
+	if err := validAgentEffort(opts.effort); err != nil {
+		return nil, err
+	}
+	opts.prompt = strings.Join(positional, " ")
+	return opts, nil
+}
+
+func validAgentEffort(effort string) error {
+	switch effort {
+	case "", "low", "medium", "high":
+		return nil
+	}
+	return fmt.Errorf("reasoning effort must be low, medium or high (got %q)", effort)
+}
+
+func agentCommand(args []string) {
+	opts, err := parseAgentArgs(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "usage: kilroy agent --config <run.yaml> --model [<provider>/]<model> [flags] [prompt]")
+		fmt.Fprintln(os.Stderr, "  --provider        API provider from the run config (default: the only one with a key)")
+		fmt.Fprintln(os.Stderr, "  --effort          Reasoning effort: low|medium|high")
+		fmt.Fprintln(os.Stderr, "  --repo            Working directory (default: cwd)")
+		fmt.Fprintln(os.Stderr, "  --transcript-dir  Where to save the transcript (default: $XDG_STATE_HOME/kilroy/agent/sessions/<id>)")
+		fmt.Fprintln(os.Stderr, "  --resume          Continue the session saved in a transcript dir")
+		fmt.Fprintln(os.Stderr, "  --max-turns       Max model turns per input")
+		fmt.Fprintln(os.Stderr, "  prompt            Run this one input and exit instead of starting a prompt")
+		os.Exit(1)
+	}
+	// Ctrl-C interrupts the running input (or quits at the prompt) instead
+	// of cancelling the whole session.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+	interrupts := make(chan struct{}, 1)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	go func() {
+		for range sigCh {
+			select {
+			case interrupts <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	if err := runAgent(ctx, opts, os.Stdin, os.Stdout, interrupts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func defaultAgentTranscriptDir() (string, error) {
+	stateHome := strings.TrimSpace(os.Getenv("XDG_STATE_HOME"))
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateHome, "kilroy", "agent", "sessions", ulid.Make().String()), nil
+}
+
+// runAgent runs an interactive agent session: inputs come from in, events
+// and replies go to out, and the transcript is saved as it goes.
+func runAgent(ctx context.Context, opts *agentOptions, in io.Reader, out io.Writer, interrupts <-chan struct{}) error {
+	cfg, err := engine.LoadRunConfigFile(opts.configPath)
+	if err != nil {
+		return err
+	}
+	repo := opts.repoPath
+	if repo == "" {
+		if repo, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	if repo, err = filepath.Abs(repo); err != nil {
+		return err
+	}
+	dir := opts.transcriptDir
+	if dir == "" {
+		if dir, err = defaultAgentTranscriptDir(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	rt, err := engine.NewAgentRuntime(ctx, cfg, repo, dir)
+	if err != nil {
+		return err
+	}
+	defer rt.Close()
+	provider := opts.provider
+	if provider == "" {
+		ps := rt.Providers()
+		if len(ps) != 1 {
+			return fmt.Errorf("--provider is required (API providers with keys: %s)", strings.Join(ps, ", "))
+		}
+		provider = ps[0]
+	}
+	profile, err := rt.Profile(provider, opts.model)
+	if err != nil {
+		return err
+	}
+
+	w := &syncWriter{w: out}
+	scfg := rt.SessionConfig(provider, opts.model)
+	scfg.ReasoningEffort = opts.effort
+	scfg.MaxTurns = opts.maxTurns
+	scfg.StatePath = filepath.Join(dir, "session.ndjson")
+	if scfg.LLMRetryPolicy != nil {
+		scfg.LLMRetryPolicy.OnRetry = func(err error, attempt int, delay time.Duration) {
+			fmt.Fprintf(w, "  [retry %d in %s] %v\n", attempt, delay.Round(time.Second), err)
+		}
+	}
+	if opts.resume {
+		st, err := agent.LoadSessionState(scfg.StatePath)
+		if err != nil {
+			return fmt.Errorf("resume %s: %w", dir, err)
+		}
+		scfg.Resume = st
+	}
+	eventsLog, err := os.OpenFile(filepath.Join(dir, "events.ndjson"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = eventsLog.Close() }()
+	transcript, err := os.OpenFile(filepath.Join(dir, "transcript.md"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = transcript.Close() }()
+
+	sess, err := agent.NewSession(rt.Client, profile, agent.NewLocalExecutionEnvironment(repo), scfg)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	_, gitErr := gitutil.WorktreeTree(repo)
+	r := &agentREPL{
+		rt:         rt,
+		sess:       sess,
+		events:     sess.Events(),
+		provider:   provider,
+		model:      opts.model,
+		repo:       repo,
+		git:        gitErr == nil,
+		dir:        dir,
+		out:        w,
+		eventsLog:  eventsLog,
+		transcript: transcript,
+		interrupts: interrupts,
+	}
+	if opts.prompt != "" {
+		r.run(ctx, opts.prompt)
+		r.drainEvents()
+		return r.err
+	}
+	lines := make(chan string)
+	go readAgentInputs(in, lines)
+	r.lines = lines
+	fmt.Fprintf(w, "kilroy agent: %s/%s in %s (transcript: %s). /help for commands.\n", provider, opts.model, repo, dir)
+	r.loop(ctx)
+	return nil
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// readAgentInputs sends one input per message: a line, lines joined by a
+// trailing backslash, or the lines between two `"""` lines. It closes out at
+// EOF.
+func readAgentInputs(in io.Reader, out chan<- string) {
+	defer close(out)
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	var buf []string
+	block := false
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		switch {
+		case block:
+			if strings.TrimSpace(line) == `"""` {
+				block = false
+				out <- strings.Join(buf, "\n")
+				buf = nil
+				continue
+			}
+			buf = append(buf, line)
+		case len(buf) == 0 && strings.TrimSpace(line) == `"""`:
+			block = true
+		case strings.HasSuffix(line, `\`):
+			buf = append(buf, strings.TrimSuffix(line, `\`))
+		default:
+			buf = append(buf, line)
+			if text := strings.Join(buf, "\n"); strings.TrimSpace(text) != "" {
+				out <- text
+			}
+			buf = nil
+		}
+	}
+	if text := strings.Join(buf, "\n"); strings.TrimSpace(text) != "" {
+		out <- text
+	}
+}
+
+// agentREPL drives one session. Everything it prints happens on the loop's
+// goroutine, so events, replies and prompts stay in order.
+type agentREPL struct {
+	rt         *engine.AgentRuntime
+	sess       *agent.Session
+	events     <-chan agent.SessionEvent
+	lines      <-chan string
+	interrupts <-chan struct{}
+
+	provider, model string
+	repo            string
+	git             bool
+	dir             string
+	out             io.Writer
+	eventsLog       io.Writer
+	transcript      io.Writer
+
+	snapshots []string // worktree before each input, for /undo
+	note      string   // prepended to the next input
+	pending   []string // inputs typed while the agent was busy
+	eof       bool
+	err       error
+}
+
+func (r *agentREPL) loop(ctx context.Context) {
+	for {
+		var line string
+		if len(r.pending) > 0 {
+			line, r.pending = r.pending[0], r.pending[1:]
+		} else {
+			if r.eof {
+				return
+			}
+			fmt.Fprint(r.out, "> ")
+			got := false
+			for !got {
+				select {
+				case l, ok := <-r.lines:
+					if !ok {
+						r.eof = true
+						fmt.Fprintln(r.out)
+						return
+					}
+					line, got = l, true
+				case ev := <-r.events:
+					r.handleEvent(ev)
+				case <-r.interrupts:
+					fmt.Fprintln(r.out)
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "/") {
+			if quit := r.command(strings.TrimSpace(line)); quit {
+				return
+			}
+			continue
+		}
+		r.run(ctx, line)
+		if r.err != nil && ctx.Err() == nil {
+			fmt.Fprintf(r.out, "error: %v\n", r.err)
+		}
+	}
+}
+
+// run processes one input, applying /steer, /effort and /stop as they are
+// typed and queueing anything else until it finishes.
+func (r *agentREPL) run(ctx context.Context, input string) {
+	r.err = nil
+	if r.git {
+		if snap, err := gitutil.WorktreeTree(r.repo); err == nil {
+			r.snapshots = append(r.snapshots, snap)
+		}
+	}
+	if r.note != "" {
+		input = r.note + "\n\n" + input
+		r.note = ""
+	}
+	ictx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.sess.ProcessInput(ictx, input)
+		done <- err
+	}()
+	for {
+		select {
+		case ev := <-r.events:
+			r.handleEvent(ev)
+		case l, ok := <-r.lines:
+			if !ok {
+				r.lines, r.eof = nil, true
+				continue
+			}
+			cmd, arg := splitAgentCommand(l)
+			switch cmd {
+			case "/steer":
+				r.sess.Steer(arg)
+				fmt.Fprintln(r.out, "  (steering queued)")
+			case "/effort":
+				r.command(strings.TrimSpace(l))
+			case "/stop":
+				cancel()
+				fmt.Fprintln(r.out, "  (stopping)")
+			default:
+				r.pending = append(r.pending, l)
+				fmt.Fprintln(r.out, "  (queued until the agent finishes)")
+			}
+		case <-r.interrupts:
+			cancel()
+			fmt.Fprintln(r.out, "  (interrupted)")
+		case err := <-done:
+			r.drainEvents()
+			if err != nil && ictx.Err() == nil {
+				r.err = err
+			}
+			return
+		}
+	}
+}
+
+func (r *agentREPL) drainEvents() {
+	for {
+		select {
+		case ev, ok := <-r.events:
+			if !ok {
+				return
+			}
+			r.handleEvent(ev)
+		default:
+			return
+		}
+	}
+}
+
+func splitAgentCommand(line string) (string, string) {
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	return cmd, strings.TrimSpace(arg)
+}
+
+// command handles a /command typed while idle and reports whether to quit.
+func (r *agentREPL) command(line string) bool {
+	cmd, arg := splitAgentCommand(line)
+	switch cmd {
+	case "/exit", "/quit":
+		return true
+	case "/help":
+		fmt.Fprint(r.out, `commands:
+  /steer <text>             nudge the agent after its current tool round
+  /stop                     stop the running input (Ctrl-C does the same)
+  /model [<provider>/]<m>   switch model for the next inputs
+  /effort low|medium|high   set reasoning effort
+  /undo                     revert the files changed by the last input
+  /transcript               show where the transcript is saved
+  /exit                     quit
+multi-line input: end lines with \ or wrap them in """ lines
+`)
+	case "/steer":
+		fmt.Fprintln(r.out, "nothing is running; send it as a normal message")
+	case "/stop":
+		fmt.Fprintln(r.out, "nothing is running")
+	case "/transcript":
+		fmt.Fprintln(r.out, r.dir)
+	case "/effort":
+		if arg == "default" {
+			arg = ""
+		}
+		if err := validAgentEffort(arg); err != nil {
+			fmt.Fprintln(r.out, err)
+			break
+		}
+		r.sess.SetReasoningEffort(arg)
+		fmt.Fprintf(r.out, "reasoning effort: %s\n", valueOr(arg, "default"))
+	case "/model":
+		if arg == "" {
+			fmt.Fprintf(r.out, "model: %s/%s\n", r.provider, r.model)
+			break
+		}
+		provider, model := r.provider, arg
+		if p, m, ok := strings.Cut(arg, "/"); ok {
+			provider, model = p, m
+		}
+		profile, err := r.rt.Profile(provider, model)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			break
+		}
+		r.sess.SetProfile(profile)
+		r.provider, r.model = provider, model
+		fmt.Fprintf(r.out, "model: %s/%s\n", provider, model)
+	case "/undo":
+		r.undo()
+	default:
+		fmt.Fprintf(r.out, "unknown command %s (/help lists them)\n", cmd)
+	}
+	return false
+}
+
+func (r *agentREPL) undo() {
+	if !r.git {
+		fmt.Fprintln(r.out, "/undo needs a git repository")
+		return
+	}
+	if len(r.snapshots) == 0 {
+		fmt.Fprintln(r.out, "nothing to undo")
+		return
+	}
+	snap := r.snapshots[len(r.snapshots)-1]
+	r.snapshots = r.snapshots[:len(r.snapshots)-1]
+	paths, err := gitutil.RevertWorktreeChanges(r.repo, snap)
+	if err != nil {
+		fmt.Fprintf(r.out, "undo failed: %v\n", err)
+		return
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(r.out, "the last input changed no files")
+		return
+	}
+	fmt.Fprintf(r.out, "reverted %d file(s): %s\n", len(paths), strings.Join(paths, ", "))
+	r.note = "[The user reverted the file changes from your previous turn: " + strings.Join(paths, ", ") + ". Re-read files before editing them.]"
+	fmt.Fprintf(r.transcript, "_undo: reverted %s_\n\n", strings.Join(paths, ", "))
+}
+
+func (r *agentREPL) handleEvent(ev agent.SessionEvent) {
+	if b, err := json.Marshal(ev); err == nil {
+		_, _ = r.eventsLog.Write(append(b, '\n'))
+	}
+	str := func(k string) string { s, _ := ev.Data[k].(string); return s }
+	switch ev.Kind {
+	case agent.EventUserInput:
+		fmt.Fprintf(r.transcript, "## user\n\n%s\n\n", str("text"))
+	case agent.EventAssistantTextEnd:
+		if text := strings.TrimSpace(str("text")); text != "" {
+			fmt.Fprintf(r.out, "\n%s\n\n", text)
+			fmt.Fprintf(r.transcript, "## assistant\n\n%s\n\n", text)
+		}
+	case agent.EventToolCallStart:
+		args := str("arguments_json")
+		fmt.Fprintf(r.out, "  -> %s %s\n", str("tool_name"), truncateOneLine(args, 160))
+		fmt.Fprintf(r.transcript, "### %s\n\n```json\n%s\n```\n\n", str("tool_name"), args)
+	case agent.EventToolCallEnd:
+		output := str("full_output")
+		if isErr, _ := ev.Data["is_error"].(bool); isErr {
+			fmt.Fprintf(r.out, "  !! %s: %s\n", str("tool_name"), truncateOneLine(output, 200))
+		}
+		fmt.Fprintf(r.transcript, "```\n%s\n```\n\n", truncateRunes(output, 4000))
+	case agent.EventToolCallDenied:
+		fmt.Fprintf(r.out, "  !! %s denied by tool policy (%s)\n", str("tool_name"), valueOr(str("reason"), str("rule")))
+	case agent.EventSteeringInjected:
+		fmt.Fprintf(r.out, "  [steering] %s\n", truncateOneLine(str("text"), 160))
+		fmt.Fprintf(r.transcript, "_steering: %s_\n\n", str("text"))
+	case agent.EventWarning:
+		fmt.Fprintf(r.out, "  [warning] %s\n", str("message"))
+	case agent.EventError:
+		fmt.Fprintf(r.out, "  [error] %s\n", str("error"))
+	case agent.EventTurnLimit:
+		fmt.Fprintln(r.out, "  [turn limit reached]")
+	case agent.EventLoopDetection:
+		fmt.Fprintln(r.out, "  [loop detected; steering the agent]")
+	case agent.EventContextCompaction:
+		fmt.Fprintln(r.out, "  [context compacted]")
+	}
+}
+
+func truncateOneLine(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return truncateRunes(s, n)
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "..."
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
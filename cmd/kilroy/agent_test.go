@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAgentArgs(t *testing.T) {
+	opts, err := parseAgentArgs([]string{"--config", "run.yaml", "--model", "openai/gpt-5.2", "--effort", "high", "fix", "the", "bug"})
+	if err != nil {
+		t.Fatalf("parseAgentArgs: %v", err)
+	}
+	if opts.provider != "openai" || opts.model != "gpt-5.2" || opts.effort != "high" || opts.prompt != "fix the bug" {
+		t.Fatalf("opts: %+v", opts)
+	}
+	for _, args := range [][]string{
+		{"--model", "gpt-5.2"},
+		{"--config", "run.yaml"},
+		{"--config", "run.yaml", "--model", "m", "--effort", "max"},
+		{"--config", "run.yaml", "--model", "m", "--max-turns", "0"},
+	} {
+		if _, err := parseAgentArgs(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestReadAgentInputs_JoinsMultiLineInput(t *testing.T) {
+	in := "one\ntwo \\\nlines\n\"\"\"\nblock\n\n  kept\n\"\"\"\n\nlast"
+	ch := make(chan string)
+	go readAgentInputs(strings.NewReader(in), ch)
+	var got []string
+	for s := range ch {
+		got = append(got, s)
+	}
+	want := []string{"one", "two \nlines", "block\n\n  kept", "last"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("inputs = %q, want %q", got, want)
+	}
+}
+
+func TestRunAgent_ToolCallsModelSwitchUndoAndTranscript(t *testing.T) {
+	repo := initTestRepo(t)
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{"id":"r1","model":"gpt-5.2","output":[{"type":"function_call","id":"c1","call_id":"c1","name":"write_file","arguments":"{\"file_path\":\"made.txt\",\"content\":\"hi\\n\"}"}],"usage":{"input_tokens":1,"output_tokens":1,"total_tokens":2}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"r2","model":"gpt-5.2","output":[{"type":"message","content":[{"type":"output_text","text":"all done"}]}],"usage":{"input_tokens":1,"output_tokens":1,"total_tokens":2}}`))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	for _, k := range []string{"ANTHROPIC_API_KEY", "GEMINI_API_KEY", "GOOGLE_API_KEY"} {
+		t.Setenv(k, "")
+	}
+
+	cfgPath := writeRunConfig(t, repo, "http://127.0.0.1:9", "127.0.0.1:9", writePinnedCatalog(t))
+	f, err := os.OpenFile(cfgPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString("llm:\n  providers:\n    openai:\n      backend: api\n")
+	_ = f.Close()
+
+	dir := filepath.Join(t.TempDir(), "transcript")
+	opts := &agentOptions{configPath: cfgPath, model: "gpt-5.2", repoPath: repo, transcriptDir: dir}
+	in := strings.Join([]string{
+		"write made.txt",
+		"/undo",
+		"/model gpt-5.2-mini",
+		"/effort high",
+		"and again",
+		"/bogus",
+	}, "\n") + "\n"
+	var out strings.Builder
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	if err := runAgent(ctx, opts, strings.NewReader(in), &out, nil); err != nil {
+		t.Fatalf("runAgent: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"-> write_file", "all done", "reverted 1 file(s): made.txt", "model: openai/gpt-5.2-mini", "reasoning effort: high", "unknown command /bogus"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("output lacks %q:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo, "made.txt")); !os.IsNotExist(err) {
+		t.Fatalf("made.txt should be reverted: %v", err)
+	}
+
+	mu.Lock()
+	last := bodies[len(bodies)-1]
+	mu.Unlock()
+	if !strings.Contains(last, `"gpt-5.2-mini"`) || !strings.Contains(last, `"high"`) || !strings.Contains(last, "reverted the file changes") {
+		t.Fatalf("last request lacks model/effort/undo note: %s", last)
+	}
+	md, _ := os.ReadFile(filepath.Join(dir, "transcript.md"))
+	if !strings.Contains(string(md), "## user\n\nwrite made.txt") || !strings.Contains(string(md), "### write_file") || !strings.Contains(string(md), "## assistant\n\nall done") {
+		t.Fatalf("transcript.md:\n%s", md)
+	}
+	for _, name := range []string{"events.ndjson", "session.ndjson"} {
+		if st, err := os.Stat(filepath.Join(dir, name)); err != nil || st.Size() == 0 {
+			t.Fatalf("%s missing or empty: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/diff"
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// attractorDiff compares two versions of a pipeline by behaviour. With
+// --logs-root the old version is the graph a run kept, so a changed file
+// can be reviewed before resuming the run with it.
+func attractorDiff(args []string) {
+	var logsRoot string
+	var files []string
+	format := diff.FormatText
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--format":
+			i++
+			if i >= len(args) {
+				fmt.Fprintln(os.Stderr, "--format requires a value")
+				os.Exit(1)
+			}
+			format = args[i]
+		case "--logs-root":
+			i++
+			if i >= len(args) {
+				fmt.Fprintln(os.Stderr, "--logs-root requires a value")
+				os.Exit(1)
+			}
+			logsRoot = args[i]
+		default:
+			if v, ok := strings.CutPrefix(args[i], "--format="); ok {
+				format = v
+				continue
+			}
+			if len(args[i]) > 1 && args[i][0] == '-' {
+				fmt.Fprintf(os.Stderr, "unknown arg: %s\n", args[i])
+				os.Exit(1)
+			}
+			files = append(files, args[i])
+		}
+	}
+
+	if format != diff.FormatText && format != diff.FormatJSON {
+		fmt.Fprintf(os.Stderr, "unknown format %q (want text|json)\n", format)
+		os.Exit(1)
+	}
+
+	repoPath := ""
+	if logsRoot != "" {
+		repoPath = manifestRepoPath(logsRoot)
+		files = append([]string{filepath.Join(logsRoot, "graph.dot")}, files...)
+	}
+	if len(files) != 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	var graphs [2]*model.Graph
+	for i, path := range files {
+		g, err := prepareForDiff(path, repoPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		graphs[i] = g
+	}
+	if err := diff.Write(os.Stdout, format, diff.Graphs(graphs[0], graphs[1])); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// prepareForDiff parses and prepares the graph at path. A graph that fails
+// validation can still be compared; one that does not parse cannot.
+func prepareForDiff(path, repoPath string) (*model.Graph, error) {
+	dotSource, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g, diags, err := engine.PrepareWithOptions(dotSource, engine.PrepareOptions{Filename: path, RepoPath: repoPath})
+	if err != nil {
+		if g == nil || len(diags) == 0 {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s: %v\n", path, err)
+	}
+	return g, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/style"
+)
+
+// attractorExplain shows, for one node, the final value of every attribute
+// the model stylesheet can set and where it came from: a stylesheet rule,
+// the node's own attribute or a graph default, followed by the values it
+// overrode.
+func attractorExplain(args []string) {
+	var graphPath string
+	var nodeID string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--graph", "--node":
+			flag := args[i]
+			i++
+			if i >= len(args) {
+				fmt.Fprintf(os.Stderr, "%s requires a value\n", flag)
+				os.Exit(1)
+			}
+			if flag == "--graph" {
+				graphPath = args[i]
+			} else {
+				nodeID = args[i]
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown arg: %s\n", args[i])
+			os.Exit(1)
+		}
+	}
+	if graphPath == "" || nodeID == "" {
+		usage()
+		os.Exit(1)
+	}
+
+	dotSource, err := os.ReadFile(graphPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	g, rules, err := engine.ExplainStylesheet(dotSource, engine.PrepareOptions{Filename: graphPath})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	n := g.Nodes[nodeID]
+	if n == nil {
+		fmt.Fprintf(os.Stderr, "node %q not found in %s\n", nodeID, graphPath)
+		os.Exit(1)
+	}
+	writeExplanation(os.Stdout, g, n, rules)
+}
+
+func writeExplanation(w io.Writer, g *model.Graph, n *model.Node, rules []style.Rule) {
+	fmt.Fprintf(w, "node %s (%s)\n", n.ID, n.Span.String())
+	cascade := style.Cascade(g, n, rules)
+	if len(cascade) == 0 {
+		fmt.Fprintln(w, "  no stylesheet rule, node attribute or graph default sets any stylesheet property")
+		return
+	}
+	width := 0
+	for prop := range cascade {
+		width = max(width, len(prop))
+	}
+	for _, prop := range style.KnownProperties {
+		cands, ok := cascade[prop]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "  %-*s = %s  [%s]\n", width, prop, quoteIfNeeded(cands[0].Value), describeSource(g, n, prop, cands[0]))
+		for _, c := range cands[1:] {
+			fmt.Fprintf(w, "  %-*s   overrides %s  [%s]\n", width, "", quoteIfNeeded(c.Value), describeSource(g, n, prop, c))
+		}
+	}
+}
+
+func describeSource(g *model.Graph, n *model.Node, prop string, c style.Candidate) string {
+	switch c.Source {
+	case style.SourceNode:
+		return "node attribute at " + n.AttrSpan(prop).String()
+	case style.SourceGraph:
+		return "graph default at " + g.AttrSpan(prop).String()
+	default:
+		s := fmt.Sprintf("stylesheet rule %d %s", c.Rule.Order+1, c.Rule.Selector)
+		if c.Important {
+			s += " !important"
+		}
+		return s
+	}
+}
+
+func quoteIfNeeded(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
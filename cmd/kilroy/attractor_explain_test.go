@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+)
+
+func TestWriteExplanation_NamesTheRuleOrAttributeBehindEachValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.dot")
+	src := `digraph G {
+  graph [llm_provider=openai, model_stylesheet="* { timeout: 300s; } [type=tool] { timeout: 60s; max_retries: 0 !important; }"]
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  build [shape=parallelogram, tool_command="make", max_retries=3]
+  start -> build -> exit
+}
+`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, rules, err := engine.ExplainStylesheet([]byte(src), engine.PrepareOptions{Filename: path})
+	if err != nil {
+		t.Fatalf("ExplainStylesheet: %v", err)
+	}
+	var b strings.Builder
+	writeExplanation(&b, g, g.Nodes["build"], rules)
+	out := b.String()
+	for _, want := range []string{
+		"node build (" + path + ":5:3)",
+		"llm_provider = openai  [graph default at " + path + ":2:10]",
+		"timeout      = 60s  [stylesheet rule 2 [type=tool]]",
+		"overrides 300s  [stylesheet rule 1 *]",
+		"max_retries  = 0  [stylesheet rule 2 [type=tool] !important]",
+		"overrides 3  [node attribute at " + path + ":5:52]",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("explanation missing %q:\n%s", want, out)
+		}
+	}
+}
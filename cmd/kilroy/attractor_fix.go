@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/validate"
+)
+
+// maxFixPasses bounds how often fixGraph re-validates; a fix can expose
+// another (guarding a loop_restart edge then asks for its companion edge).
+const maxFixPasses = 5
+
+// fixGraph applies the machine-applicable fixes of the graph's diagnostics,
+// re-validating after each pass until none are left. A diagnostic that
+// survives its own fix is not applied again; a pass that leaves the source
+// unchanged is an error. It returns the fixed source and the diagnostics it
+// fixed.
+func fixGraph(src []byte, path string) ([]byte, []validate.Diagnostic, error) {
+	var applied []validate.Diagnostic
+	seen := map[string]bool{}
+	for pass := 0; pass < maxFixPasses; pass++ {
+		_, diags, _ := engine.PrepareWithOptions(src, engine.PrepareOptions{Filename: path})
+		var fresh []validate.Diagnostic
+		for _, d := range diags {
+			if len(d.Edits) > 0 && seen[fixKey(d)] {
+				continue
+			}
+			fresh = append(fresh, d)
+		}
+		out, fixed, err := validate.ApplyFixes(src, path, fresh)
+		if err != nil {
+			return src, applied, err
+		}
+		if len(fixed) == 0 {
+			break
+		}
+		if bytes.Equal(out, src) {
+			return src, applied, fmt.Errorf("fixes made no progress: %s (%s)", fixed[0].Fix, fixed[0].Rule)
+		}
+		if _, err := dot.ParseFile(path, out); err != nil {
+			return src, applied, fmt.Errorf("fixes produced an invalid graph: %w", err)
+		}
+		src = out
+		for _, d := range fixed {
+			seen[fixKey(d)] = true
+		}
+		applied = append(applied, fixed...)
+	}
+	return src, applied, nil
+}
+
+func fixKey(d validate.Diagnostic) string {
+	return d.Rule + "\x00" + d.Span.String() + "\x00" + d.Fix
+}
+
+func writeFixed(w io.Writer, applied []validate.Diagnostic, dryRun bool) {
+	verb := "fixed"
+	if dryRun {
+		verb = "would fix"
+	}
+	for _, d := range applied {
+		fmt.Fprintf(w, "%s %s: %s (%s)\n", verb, d.Span, d.Fix, d.Rule)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFixGraph_RevalidatesUntilNoFixesRemain(t *testing.T) {
+	src := `digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl  [shape=box, llm_provider=openai, llm_model=gpt-5.2, label="Implement"]
+  check [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="Check", goal_gate=true, retry_target=impl]
+  start -> impl -> check
+  check -> exit [condition="outcome=success"]
+  check -> impl [condition="outcome=fail"]
+}
+`
+	fixed, applied, err := fixGraph([]byte(src), "p.dot")
+	if err != nil {
+		t.Fatalf("fixGraph: %v", err)
+	}
+	if len(applied) == 0 {
+		t.Fatal("expected fixes")
+	}
+	if !strings.Contains(string(fixed), `label="Implement", prompt=Implement]`) {
+		t.Fatalf("prompt not added from label:\n%s", fixed)
+	}
+	again, more, err := fixGraph(fixed, "p.dot")
+	if err != nil || len(more) != 0 || string(again) != string(fixed) {
+		t.Fatalf("second pass changed the graph (%v): %+v\n%s", err, more, again)
+	}
+
+	var b strings.Builder
+	writeFixed(&b, applied[:1], true)
+	if !strings.HasPrefix(b.String(), "would fix p.dot:") {
+		t.Fatalf("dry-run summary: %q", b.String())
+	}
+}
+
+func TestFixGraph_RedeclaredNodeIsFixedOnce(t *testing.T) {
+	src := `digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  work  [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="Work"]
+  check [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="Check"]
+  work  [retry_target=chekc]
+  start -> work -> check -> exit
+}
+`
+	fixed, applied, err := fixGraph([]byte(src), "p.dot")
+	if err != nil {
+		t.Fatalf("fixGraph: %v", err)
+	}
+	if len(applied) != 1 || applied[0].Fix != "set retry_target=check" {
+		t.Fatalf("applied: %+v", applied)
+	}
+	if strings.Count(string(fixed), "retry_target") != 1 || !strings.Contains(string(fixed), "work  [retry_target=check]") {
+		t.Fatalf("fixed graph:\n%s", fixed)
+	}
+}
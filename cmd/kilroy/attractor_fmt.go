@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+)
+
+// attractorFmt rewrites DOT files in the canonical layout. With --check it
+// only lists the files that are not formatted and exits 1 if there are any.
+// With no files it formats stdin to stdout.
+func attractorFmt(args []string) {
+	var check bool
+	var files []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--check":
+			check = true
+		default:
+			if len(args[i]) > 1 && args[i][0] == '-' {
+				fmt.Fprintf(os.Stderr, "unknown arg: %s\n", args[i])
+				os.Exit(1)
+			}
+			files = append(files, args[i])
+		}
+	}
+
+	if len(files) == 0 || (len(files) == 1 && files[0] == "-") {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		out, err := dot.Format(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<stdin>: %v\n", err)
+			os.Exit(1)
+		}
+		if check {
+			if !bytes.Equal(src, out) {
+				fmt.Println("<stdin>")
+				os.Exit(1)
+			}
+			return
+		}
+		_, _ = os.Stdout.Write(out)
+		return
+	}
+
+	failed, unformatted := false, false
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		out, err := dot.Format(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		if bytes.Equal(src, out) {
+			continue
+		}
+		if check {
+			fmt.Println(path)
+			unformatted = true
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		if err := os.WriteFile(path, out, info.Mode().Perm()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed || unformatted {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/lspserver"
+)
+
+func attractorLSP(args []string) {
+	var root string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--root":
+			i++
+			if i >= len(args) {
+				fmt.Fprintln(os.Stderr, "--root requires a value")
+				os.Exit(1)
+			}
+			root = args[i]
+		case "--stdio":
+			// Editors commonly pass --stdio; stdio is the only transport.
+		default:
+			fmt.Fprintf(os.Stderr, "unknown arg: %s\n", args[i])
+			os.Exit(1)
+		}
+	}
+
+	err := lspserver.Serve(os.Stdin, os.Stdout, lspserver.Options{
+		RootDir:    root,
+		KnownTypes: engine.NewDefaultRegistry().KnownTypes(),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/render"
+	"github.com/danshapiro/kilroy/internal/attractor/runstate"
+)
+
+func attractorRender(args []string) {
+	var graphPath string
+	var logsRoot string
+	var format string
+	var output string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--graph", "--logs-root", "--format", "--output", "-o":
+			flag := args[i]
+			i++
+			if i >= len(args) {
+				fmt.Fprintf(os.Stderr, "%s requires a value\n", flag)
+				os.Exit(1)
+			}
+			switch flag {
+			case "--graph":
+				graphPath = args[i]
+			case "--logs-root":
+				logsRoot = args[i]
+			case "--format":
+				format = args[i]
+			default:
+				output = args[i]
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "unknown arg: %s\n", args[i])
+			os.Exit(1)
+		}
+	}
+
+	// A run keeps a copy of its graph, so --logs-root alone is enough.
+	repoPath := ""
+	if logsRoot != "" {
+		if fi, err := os.Stat(logsRoot); err != nil || !fi.IsDir() {
+			fmt.Fprintf(os.Stderr, "--logs-root %s is not a run directory\n", logsRoot)
+			os.Exit(1)
+		}
+		repoPath = manifestRepoPath(logsRoot)
+		if graphPath == "" {
+			graphPath = filepath.Join(logsRoot, "graph.dot")
+			if _, err := os.Stat(graphPath); err != nil {
+				fmt.Fprintf(os.Stderr, "%s has no graph.dot; pass --graph\n", logsRoot)
+				os.Exit(1)
+			}
+		}
+	}
+	if graphPath == "" {
+		usage()
+		os.Exit(1)
+	}
+	if format == "" {
+		// Default from the output file name.
+		switch strings.ToLower(filepath.Ext(output)) {
+		case ".html", ".htm":
+			format = string(render.FormatHTML)
+		case ".md", ".mmd":
+			format = string(render.FormatMermaid)
+		default:
+			format = string(render.FormatSVG)
+		}
+	}
+	write := map[render.Format]func(io.Writer, *model.Graph, *runstate.History) error{
+		render.FormatSVG:     render.SVG,
+		render.FormatMermaid: render.Mermaid,
+		render.FormatHTML:    render.HTML,
+	}[render.Format(format)]
+	if write == nil {
+		fmt.Fprintf(os.Stderr, "--format must be svg, mermaid or html (got %q)\n", format)
+		os.Exit(1)
+	}
+
+	dotSource, err := os.ReadFile(graphPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	g, diags, err := engine.PrepareWithOptions(dotSource, engine.PrepareOptions{Filename: graphPath, RepoPath: repoPath})
+	if err != nil {
+		// An invalid graph can still be drawn; anything earlier cannot.
+		if g == nil || len(diags) == 0 {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+
+	var run *runstate.History
+	if logsRoot != "" {
+		if run, err = runstate.LoadHistory(logsRoot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if output == "" {
+		err = write(os.Stdout, g, run)
+	} else {
+		var f *os.File
+		if f, err = os.Create(output); err == nil {
+			err = write(f, g, run)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// manifestRepoPath returns the repo a run used, so the graph's imports
+// resolve as they did for the run; "" if the manifest is missing.
+func manifestRepoPath(logsRoot string) string {
+	b, err := os.ReadFile(filepath.Join(logsRoot, "manifest.json"))
+	if err != nil {
+		return ""
+	}
+	var m struct {
+		RepoPath string `json:"repo_path"`
+	}
+	_ = json.Unmarshal(b, &m)
+	return strings.TrimSpace(m.RepoPath)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttractorRender_RejectsMissingLogsRoot(t *testing.T) {
+	bin := buildKilroyBinary(t)
+	graph := filepath.Join(t.TempDir(), "g.dot")
+	if err := os.WriteFile(graph, []byte("digraph G {\n  start [shape=Mdiamond]\n  exit [shape=Msquare]\n  start -> exit\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "nonexistent")
+	code, out := runKilroy(t, bin, "attractor", "render", "--graph", graph, "--logs-root", missing)
+	if code != 1 || !strings.Contains(out, "is not a run directory") {
+		t.Fatalf("missing logs root: exit %d\n%s", code, out)
+	}
+
+	empty := t.TempDir()
+	code, out = runKilroy(t, bin, "attractor", "render", "--logs-root", empty)
+	if code != 1 || !strings.Contains(out, "has no graph.dot; pass --graph") {
+		t.Fatalf("logs root without graph.dot: exit %d\n%s", code, out)
+	}
+}
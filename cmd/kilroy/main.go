@@ -14,6 +14,7 @@ import (
 	"syscall"
 
 	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/validate"
 	"github.com/danshapiro/kilroy/internal/providerspec"
 	"github.com/danshapiro/kilroy/internal/version"
 )
@@ -57,6 +58,8 @@ func main() {
 		os.Exit(0)
 	case "attractor":
 		attractor(os.Args[2:])
+	case "agent":
+		agentCommand(os.Args[2:])
 	default:
 		usage()
 		os.Exit(1)
@@ -72,9 +75,15 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "  kilroy attractor resume --run-branch <attractor/run/...> [--repo <path>]")
 	fmt.Fprintln(os.Stderr, "  kilroy attractor status [--logs-root <dir> | --latest] [--json] [--follow|-f] [--cxdb] [--raw] [--watch] [--interval <sec>]")
 	fmt.Fprintln(os.Stderr, "  kilroy attractor stop --logs-root <dir> [--grace-ms <ms>] [--force]")
-	fmt.Fprintln(os.Stderr, "  kilroy attractor validate --graph <file.dot>")
+	fmt.Fprintln(os.Stderr, "  kilroy attractor validate [--format text|json|sarif] [--fix [--dry-run]] --graph <file.dot>")
+	fmt.Fprintln(os.Stderr, "  kilroy attractor fmt [--check] [<file.dot>...]")
+	fmt.Fprintln(os.Stderr, "  kilroy attractor render [--graph <file.dot>] [--logs-root <dir>] [--format svg|mermaid|html] [--output <file>]")
+	fmt.Fprintln(os.Stderr, "  kilroy attractor explain --graph <file.dot> --node <id>")
+	fmt.Fprintln(os.Stderr, "  kilroy attractor diff [--format text|json] (<old.dot> | --logs-root <dir>) <new.dot>")
 	fmt.Fprintln(os.Stderr, "  kilroy attractor ingest [--output <file.dot>] [--model <model>] [--skill <skill.md>] [--repo <path>] [--max-turns <n>] <requirements>")
 	fmt.Fprintln(os.Stderr, "  kilroy attractor serve [--addr <host:port>]")
+	fmt.Fprintln(os.Stderr, "  kilroy attractor lsp [--root <dir>]")
+	fmt.Fprintln(os.Stderr, "  kilroy agent --config <run.yaml> --model [<provider>/]<model> [--provider <p>] [--effort low|medium|high] [--repo <path>] [--transcript-dir <dir> | --resume <dir>] [--max-turns <n>] [prompt]")
 }
 
 func attractor(args []string) {
@@ -93,10 +102,20 @@ func attractor(args []string) {
 		attractorStop(args[1:])
 	case "validate":
 		attractorValidate(args[1:])
+	case "fmt":
+		attractorFmt(args[1:])
+	case "render":
+		attractorRender(args[1:])
+	case "explain":
+		attractorExplain(args[1:])
+	case "diff":
+		attractorDiff(args[1:])
 	case "ingest":
 		attractorIngest(args[1:])
 	case "serve":
 		attractorServe(args[1:])
+	case "lsp":
+		attractorLSP(args[1:])
 	default:
 		usage()
 		os.Exit(1)
@@ -401,8 +420,14 @@ func supportedForceModelProvidersCSV() string {
 
 func attractorValidate(args []string) {
 	var graphPath string
+	var fix, dryRun bool
+	format := validate.FormatText
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
+		case "--fix":
+			fix = true
+		case "--dry-run":
+			dryRun = true
 		case "--graph":
 			i++
 			if i >= len(args) {
@@ -410,7 +435,18 @@ func attractorValidate(args []string) {
 				os.Exit(1)
 			}
 			graphPath = args[i]
+		case "--format":
+			i++
+			if i >= len(args) {
+				fmt.Fprintln(os.Stderr, "--format requires a value")
+				os.Exit(1)
+			}
+			format = args[i]
 		default:
+			if v, ok := strings.CutPrefix(args[i], "--format="); ok {
+				format = v
+				continue
+			}
 			fmt.Fprintf(os.Stderr, "unknown arg: %s\n", args[i])
 			os.Exit(1)
 		}
@@ -424,18 +460,60 @@ func attractorValidate(args []string) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	_, diags, err := engine.Prepare(dotSource)
-	if err != nil {
-		for _, d := range diags {
-			fmt.Fprintf(os.Stderr, "%s: %s (%s)\n", d.Severity, d.Message, d.Rule)
+	switch format {
+	case validate.FormatText, validate.FormatJSON, validate.FormatSARIF:
+	default:
+		fmt.Fprintf(os.Stderr, "--format must be text, json or sarif (got %q)\n", format)
+		os.Exit(1)
+	}
+	if dryRun && !fix {
+		fmt.Fprintln(os.Stderr, "--dry-run requires --fix")
+		os.Exit(1)
+	}
+	if fix {
+		// Fix, then report what is left. With --dry-run the file is not
+		// written and the report is for the fixed text.
+		fixed, applied, err := fixGraph(dotSource, graphPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		writeFixed(os.Stderr, applied, dryRun)
+		if len(applied) > 0 && !dryRun {
+			info, err := os.Stat(graphPath)
+			if err == nil {
+				err = os.WriteFile(graphPath, fixed, info.Mode().Perm())
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		dotSource = fixed
+	}
+	_, diags, err := engine.PrepareWithOptions(dotSource, engine.PrepareOptions{Filename: graphPath})
+	if format != validate.FormatText {
+		if err != nil && len(diags) == 0 {
+			// Parse and transform failures come back as a bare error; report
+			// them as a diagnostic so json/sarif consumers still see them.
+			diags = []validate.Diagnostic{validate.ParseErrorDiagnostic(err)}
+		}
+		if werr := validate.WriteReport(os.Stdout, format, graphPath, diags); werr != nil {
+			fmt.Fprintln(os.Stderr, werr)
+			os.Exit(1)
 		}
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err != nil {
+		_ = validate.WriteReport(os.Stderr, format, graphPath, diags)
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	fmt.Printf("ok: %s\n", filepath.Base(graphPath))
-	for _, d := range diags {
-		fmt.Printf("%s: %s (%s)\n", d.Severity, d.Message, d.Rule)
-	}
+	_ = validate.WriteReport(os.Stdout, format, graphPath, diags)
 	os.Exit(0)
 }
 
@@ -1,162 +1,544 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// ApplyPatch applies a codex-rs-style apply_patch v4a patch to files under rootDir.
-// This is a best-effort implementation intended for local agent loops.
+// DefaultPatchFuzz is the number of context lines ApplyPatch may ignore at
+// each end of a hunk that does not otherwise match (like patch(1)'s fuzz).
+const DefaultPatchFuzz = 2
+
+// ApplyPatchOptions tunes ApplyPatchWithOptions.
+type ApplyPatchOptions struct {
+	// Fuzz is the number of leading/trailing context lines a hunk may drop to
+	// find a match. 0 requires all context to match (modulo whitespace).
+	Fuzz int
+}
+
+// ApplyPatch applies a codex-rs-style apply_patch v4a patch, or a unified
+// diff (git diff style, including renames and mode changes), to files under
+// rootDir using DefaultPatchFuzz.
 func ApplyPatch(rootDir string, patch string) (string, error) {
-	ops, err := parseV4APatch(patch)
+	return ApplyPatchWithOptions(rootDir, patch, ApplyPatchOptions{Fuzz: DefaultPatchFuzz})
+}
+
+// ApplyPatchWithOptions is ApplyPatch with explicit options.
+//
+// Hunks are located at their stated position first and then at the nearest
+// offset, matching exactly, then ignoring whitespace differences, then with up
+// to opts.Fuzz context lines dropped. Every hunk of every file is resolved in
+// memory before anything is written; if any hunk fails, nothing is changed and
+// the error describes each failed hunk and the closest text found. A write
+// failure part way through rolls back the files already written.
+func ApplyPatchWithOptions(rootDir string, patch string, opts ApplyPatchOptions) (string, error) {
+	out, _, err := applyPatch(rootDir, patch, opts)
+	return out, err
+}
+
+// applyPatch is ApplyPatchWithOptions, also returning the committed patchFS
+// (nil when nothing was written) so callers can see each file's prior state.
+func applyPatch(rootDir string, patch string, opts ApplyPatchOptions) (string, *patchFS, error) {
+	ops, err := parsePatch(patch)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	var touched []string
+	pfs := newPatchFS(rootDir)
+	var touched, notes []string
+	var failures []string
 	for _, op := range ops {
-		paths, err := op.apply(rootDir)
+		paths, opNotes, err := op.apply(pfs, opts)
 		if err != nil {
-			return "", err
+			failures = append(failures, err.Error())
+			continue
 		}
 		touched = append(touched, paths...)
+		notes = append(notes, opNotes...)
+	}
+	if len(failures) > 0 {
+		return "", nil, fmt.Errorf("apply_patch: %d of %d file operations failed; no files were changed\n%s", len(failures), len(ops), strings.Join(failures, "\n"))
+	}
+	if err := pfs.commit(); err != nil {
+		return "", nil, fmt.Errorf("apply_patch: %w (changes rolled back)", err)
 	}
 	if len(touched) == 0 {
-		return "no changes", nil
+		return "no changes", pfs, nil
+	}
+	out := "applied patch to:\n" + strings.Join(touched, "\n")
+	if len(notes) > 0 {
+		out += "\n\nnotes:\n" + strings.Join(notes, "\n")
 	}
-	return "applied patch to:\n" + strings.Join(touched, "\n"), nil
+	return out, pfs, nil
+}
+
+// parsePatch parses a v4a patch or, failing the "*** Begin Patch" marker, a
+// unified diff.
+func parsePatch(patch string) ([]patchOp, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if strings.TrimSpace(l) == "*** Begin Patch" {
+			return parseV4APatchLines(lines)
+		}
+		break
+	}
+	if isUnifiedDiff(lines) {
+		return parseUnifiedDiff(lines)
+	}
+	return parseV4APatchLines(lines)
 }
 
 type patchOp interface {
-	apply(rootDir string) ([]string, error)
+	apply(pfs *patchFS, opts ApplyPatchOptions) (paths []string, notes []string, err error)
+	paths() []string
+}
+
+// patchHunk is one hunk of an update. lines keep their ' ', '-' or '+' prefix.
+type patchHunk struct {
+	header   string // v4a "@@ <line>" scope line, located before the hunk
+	label    string // how the hunk is named in errors
+	oldStart int    // unified diff: 1-based start line in the old file; 0 if unknown
+	lines    []string
+	eof      bool // must end at end of file (v4a "*** End of File")
+	noEOLOld bool // "\ No newline at end of file" after the old side's last line
+	noEOLNew bool // same, for the new side
+}
+
+func (h patchHunk) oldLines() []string {
+	var out []string
+	for _, l := range h.lines {
+		if l[0] == ' ' || l[0] == '-' {
+			out = append(out, l[1:])
+		}
+	}
+	return out
 }
 
 type addFileOp struct {
-	path  string
-	lines []string
+	path    string
+	lines   []string
+	mode    os.FileMode
+	noEOL   bool
+	unified bool
 }
 
-func (o addFileOp) apply(rootDir string) ([]string, error) {
-	p, err := safeJoin(rootDir, o.path)
+func (o addFileOp) paths() []string { return []string{o.path} }
+
+func (o addFileOp) apply(pfs *patchFS, _ ApplyPatchOptions) ([]string, []string, error) {
+	p, err := safeJoin(pfs.root, o.path)
 	if err != nil {
-		return nil, err
-	}
-	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	content := strings.Join(o.lines, "\n")
-	if !strings.HasSuffix(content, "\n") {
+	if !o.noEOL && (len(o.lines) > 0 || !o.unified) && !strings.HasSuffix(content, "\n") {
 		content += "\n"
 	}
-	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
-		return nil, err
+	mode := o.mode
+	if mode == 0 {
+		mode = 0o644
 	}
-	return []string{o.path}, nil
+	pfs.write(p, []byte(content), mode)
+	return []string{o.path}, nil, nil
 }
 
 type deleteFileOp struct {
 	path string
 }
 
-func (o deleteFileOp) apply(rootDir string) ([]string, error) {
-	p, err := safeJoin(rootDir, o.path)
+func (o deleteFileOp) paths() []string { return []string{o.path} }
+
+func (o deleteFileOp) apply(pfs *patchFS, _ ApplyPatchOptions) ([]string, []string, error) {
+	p, err := safeJoin(pfs.root, o.path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	_ = os.Remove(p)
-	return []string{o.path}, nil
+	pfs.remove(p)
+	return []string{o.path}, nil, nil
 }
 
 type updateFileOp struct {
 	path   string
 	moveTo string
-	hunks  [][]string // diff lines without @@ separators
+	copy   bool        // unified "copy from/to": keep path, write moveTo
+	mode   os.FileMode // new permission bits; 0 keeps the current ones
+	hunks  []patchHunk
+}
+
+func (o updateFileOp) paths() []string {
+	if strings.TrimSpace(o.moveTo) != "" && o.moveTo != o.path {
+		return []string{o.path, o.moveTo}
+	}
+	return []string{o.path}
 }
 
-func (o updateFileOp) apply(rootDir string) ([]string, error) {
-	p, err := safeJoin(rootDir, o.path)
+func (o updateFileOp) apply(pfs *patchFS, opts ApplyPatchOptions) ([]string, []string, error) {
+	p, err := safeJoin(pfs.root, o.path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	origBytes, err := os.ReadFile(p)
+	f, err := pfs.read(p)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("%s: %v", o.path, err)
+	}
+	if !f.exists {
+		return nil, nil, fmt.Errorf("%s: file not found", o.path)
+	}
+	origText := string(f.data)
+	crlf := strings.Contains(origText, "\r\n")
+	origText = strings.ReplaceAll(origText, "\r\n", "\n")
+	hasFinalNL := origText == "" || strings.HasSuffix(origText, "\n")
+	var origLines []string
+	if origText != "" {
+		origLines = strings.Split(strings.TrimSuffix(origText, "\n"), "\n")
 	}
-	origText := strings.ReplaceAll(string(origBytes), "\r\n", "\n")
-	hasFinalNL := strings.HasSuffix(origText, "\n")
-	origLines := strings.Split(strings.TrimSuffix(origText, "\n"), "\n")
 
 	out := make([]string, 0, len(origLines))
 	pos := 0
-
-	for _, h := range o.hunks {
-		anchor := firstAnchor(h)
-		if anchor != "" {
-			k := indexOfLine(origLines, anchor, pos)
-			if k < 0 {
-				return nil, fmt.Errorf("apply_patch: anchor not found in %s: %q", o.path, anchor)
-			}
-			out = append(out, origLines[pos:k]...)
-			pos = k
+	drift := 0
+	var failures, notes []string
+	for i, h := range o.hunks {
+		minStart, hint := pos, pos
+		if h.oldStart > 0 {
+			hint = max(h.oldStart-1+drift, pos)
 		}
-
-		for _, l := range h {
-			if strings.HasPrefix(l, "@@") {
-				continue
-			}
-			if l == "" {
-				// Diff lines always have a prefix; ignore empty (best-effort).
-				continue
-			}
-			prefix := l[0]
-			body := ""
-			if len(l) > 1 {
-				body = l[1:]
+		if h.header != "" {
+			if k := indexOfLineLoose(origLines, h.header, pos); k >= 0 {
+				minStart, hint = k+1, k+1
 			}
-			switch prefix {
+		}
+		if h.eof {
+			hint = len(origLines)
+		}
+		m, ok := locateHunk(origLines, h, minStart, hint, max(opts.Fuzz, 0))
+		if !ok {
+			failures = append(failures, describeHunkFailure(o.path, i+1, h, origLines, minStart, hint))
+			continue
+		}
+		if h.oldStart > 0 {
+			drift = m.pos - m.lead - (h.oldStart - 1)
+		}
+		if note := m.note(o.path, i+1, h); note != "" {
+			notes = append(notes, note)
+		}
+		out = append(out, origLines[pos:m.pos]...)
+		cur := m.pos
+		for _, l := range m.body {
+			switch l[0] {
 			case ' ':
-				if pos >= len(origLines) || origLines[pos] != body {
-					return nil, fmt.Errorf("apply_patch: context mismatch in %s: want %q at line %d", o.path, body, pos+1)
-				}
-				out = append(out, body)
-				pos++
+				// Keep the file's own line: it may differ in whitespace.
+				out = append(out, origLines[cur])
+				cur++
 			case '-':
-				if pos >= len(origLines) || origLines[pos] != body {
-					return nil, fmt.Errorf("apply_patch: delete mismatch in %s: want %q at line %d", o.path, body, pos+1)
-				}
-				pos++
+				cur++
 			case '+':
-				out = append(out, body)
-			default:
-				// ignore
+				out = append(out, l[1:])
 			}
 		}
+		pos = cur
+		if pos == len(origLines) {
+			if h.noEOLNew {
+				hasFinalNL = false
+			} else if h.noEOLOld {
+				hasFinalNL = true
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return nil, nil, errors.New(strings.Join(failures, "\n"))
 	}
 
 	out = append(out, origLines[pos:]...)
 	newText := strings.Join(out, "\n")
-	if hasFinalNL {
+	if hasFinalNL && len(out) > 0 {
 		newText += "\n"
 	}
-	if err := os.WriteFile(p, []byte(newText), 0o644); err != nil {
-		return nil, err
+	if crlf {
+		newText = strings.ReplaceAll(newText, "\n", "\r\n")
+	}
+	mode := f.mode
+	if o.mode != 0 {
+		mode = o.mode
 	}
 	paths := []string{o.path}
+	dst := p
 	if strings.TrimSpace(o.moveTo) != "" && o.moveTo != o.path {
-		dst, err := safeJoin(rootDir, o.moveTo)
+		if dst, err = safeJoin(pfs.root, o.moveTo); err != nil {
+			return nil, nil, err
+		}
+		if !o.copy {
+			pfs.remove(p)
+		}
+		paths = append(paths, o.moveTo)
+	}
+	pfs.write(dst, []byte(newText), mode)
+	return paths, notes, nil
+}
+
+// hunkMatch is where a hunk applies: body (the hunk minus dropped context) is
+// matched against the original lines starting at pos.
+type hunkMatch struct {
+	pos   int
+	body  []string
+	lead  int // leading context lines dropped
+	fuzz  int
+	loose bool // matched ignoring whitespace differences
+}
+
+func (m hunkMatch) note(path string, n int, h patchHunk) string {
+	var how []string
+	if h.oldStart > 0 && m.pos-m.lead != h.oldStart-1 {
+		how = append(how, fmt.Sprintf("offset %+d lines", m.pos-m.lead-(h.oldStart-1)))
+	}
+	if m.loose {
+		how = append(how, "ignoring whitespace")
+	}
+	if m.fuzz > 0 {
+		how = append(how, fmt.Sprintf("fuzz %d", m.fuzz))
+	}
+	if len(how) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s: hunk %d applied at line %d (%s)", path, n, m.pos+1, strings.Join(how, ", "))
+}
+
+// Line comparisons tried in order: exact, trailing whitespace ignored, all
+// whitespace runs collapsed.
+var hunkLineNormalizers = []func(string) string{
+	func(s string) string { return s },
+	func(s string) string { return strings.TrimRight(s, " \t") },
+	func(s string) string { return strings.Join(strings.Fields(s), " ") },
+}
+
+// locateHunk finds where h applies in lines at or after minStart, preferring
+// the position nearest hint.
+func locateHunk(lines []string, h patchHunk, minStart, hint, fuzz int) (hunkMatch, bool) {
+	lead, trail := 0, 0
+	for lead < len(h.lines) && h.lines[lead][0] == ' ' {
+		lead++
+	}
+	for trail < len(h.lines)-lead && h.lines[len(h.lines)-1-trail][0] == ' ' {
+		trail++
+	}
+	for f := 0; f <= fuzz; f++ {
+		dl, dt := min(f, lead), min(f, trail)
+		if f > 0 && dl < f && dt < f {
+			break // nothing more to drop
+		}
+		body := h.lines[dl : len(h.lines)-dt]
+		old := patchHunk{lines: body}.oldLines()
+		if len(old) == 0 && len(h.oldLines()) > 0 {
+			continue // fuzz must leave something to anchor on
+		}
+		for li, norm := range hunkLineNormalizers {
+			pos, ok := findLines(lines, old, minStart, hint+dl, h.eof && dt == 0, norm)
+			if ok {
+				return hunkMatch{pos: pos, body: body, lead: dl, fuzz: max(dl, dt), loose: li > 0}, true
+			}
+		}
+	}
+	return hunkMatch{}, false
+}
+
+// findLines returns the position >= minStart nearest hint where want occurs
+// in lines under norm. atEOF requires the match to end at the last line.
+func findLines(lines, want []string, minStart, hint int, atEOF bool, norm func(string) string) (int, bool) {
+	best, bestDist := -1, 0
+	last := len(lines) - len(want)
+	first := minStart
+	if atEOF {
+		first = max(last, minStart)
+	}
+	for p := first; p <= last; p++ {
+		ok := true
+		for i, w := range want {
+			if norm(lines[p+i]) != norm(w) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		d := p - hint
+		if d < 0 {
+			d = -d
+		}
+		if best < 0 || d < bestDist {
+			best, bestDist = p, d
+		}
+	}
+	return best, best >= 0
+}
+
+// describeHunkFailure explains a hunk that did not apply: what it expected and
+// the closest region of the file.
+func describeHunkFailure(path string, n int, h patchHunk, lines []string, minStart, hint int) string {
+	old := h.oldLines()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: hunk %d (%s) did not match", path, n, h.label)
+	if h.header != "" && indexOfLineLoose(lines, h.header, 0) < 0 {
+		fmt.Fprintf(&b, "; scope line %q not found", h.header)
+	}
+	if len(old) == 0 {
+		return b.String()
+	}
+	b.WriteString("; expected:\n")
+	writeQuotedLines(&b, old)
+	norm := hunkLineNormalizers[len(hunkLineNormalizers)-1]
+	best, bestScore := -1, 0
+	for p := 0; p < len(lines); p++ {
+		score := 0
+		for i, w := range old {
+			if p+i < len(lines) && norm(lines[p+i]) == norm(w) {
+				score++
+			}
+		}
+		if score > bestScore || (score == bestScore && score > 0 && absInt(p-hint) < absInt(best-hint)) {
+			best, bestScore = p, score
+		}
+	}
+	if best < 0 {
+		b.WriteString("  no similar lines found in the file")
+		return b.String()
+	}
+	end := min(best+len(old), len(lines))
+	fmt.Fprintf(&b, "  closest match at line %d (%d of %d lines match)", best+1, bestScore, len(old))
+	if best < minStart {
+		b.WriteString(", before the previous hunk")
+	}
+	b.WriteString("; file has:\n")
+	writeQuotedLines(&b, lines[best:end])
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func writeQuotedLines(b *strings.Builder, lines []string) {
+	const maxLines = 12
+	for i, l := range lines {
+		if i == maxLines {
+			fmt.Fprintf(b, "  | ... (%d more)\n", len(lines)-maxLines)
+			break
+		}
+		b.WriteString("  | " + l + "\n")
+	}
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// patchFS stages file changes in memory so a patch is applied all or nothing.
+type patchFS struct {
+	root   string
+	files  map[string]*patchFile
+	order  []string
+	before map[string]*patchFile // on-disk state replaced by commit
+}
+
+type patchFile struct {
+	exists bool
+	data   []byte
+	mode   os.FileMode
+}
+
+func newPatchFS(root string) *patchFS {
+	return &patchFS{root: root, files: map[string]*patchFile{}}
+}
+
+func readPatchFile(p string) (*patchFile, error) {
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &patchFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("is a directory")
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return &patchFile{exists: true, data: data, mode: info.Mode().Perm()}, nil
+}
+
+// read returns p's staged state, falling back to disk.
+func (pfs *patchFS) read(p string) (*patchFile, error) {
+	if f, ok := pfs.files[p]; ok {
+		return f, nil
+	}
+	return readPatchFile(p)
+}
+
+func (pfs *patchFS) stage(p string, f *patchFile) {
+	if _, ok := pfs.files[p]; !ok {
+		pfs.order = append(pfs.order, p)
+	}
+	pfs.files[p] = f
+}
+
+func (pfs *patchFS) write(p string, data []byte, mode os.FileMode) {
+	pfs.stage(p, &patchFile{exists: true, data: data, mode: mode})
+}
+
+func (pfs *patchFS) remove(p string) {
+	pfs.stage(p, &patchFile{})
+}
+
+// commit writes the staged files, restoring the originals if any write fails.
+func (pfs *patchFS) commit() error {
+	type backup struct {
+		path string
+		orig *patchFile
+	}
+	var done []backup
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			_ = writePatchFile(done[i].path, done[i].orig)
+		}
+	}
+	for _, p := range pfs.order {
+		orig, err := readPatchFile(p)
 		if err != nil {
-			return nil, err
+			rollback()
+			return err
 		}
-		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-			return nil, err
+		done = append(done, backup{path: p, orig: orig})
+		if err := writePatchFile(p, pfs.files[p]); err != nil {
+			rollback()
+			return err
 		}
-		if err := os.Rename(p, dst); err != nil {
-			return nil, err
+	}
+	pfs.before = map[string]*patchFile{}
+	for _, b := range done {
+		pfs.before[b.path] = b.orig
+	}
+	return nil
+}
+
+func writePatchFile(p string, f *patchFile) error {
+	if !f.exists {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
 		}
-		paths = append(paths, o.moveTo)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, f.data, f.mode); err != nil {
+		return err
 	}
-	return paths, nil
+	return os.Chmod(p, f.mode)
 }
 
 func parseV4APatch(patch string) ([]patchOp, error) {
@@ -166,8 +548,11 @@ func parseV4APatch(patch string) ([]patchOp, error) {
 
 func parseV4APatchLines(lines []string) ([]patchOp, error) {
 	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
 	if i >= len(lines) || strings.TrimSpace(lines[i]) != "*** Begin Patch" {
-		return nil, fmt.Errorf("apply_patch: expected '*** Begin Patch'")
+		return nil, fmt.Errorf("apply_patch: expected '*** Begin Patch' (or a unified diff)")
 	}
 	i++
 
@@ -209,24 +594,54 @@ func parseV4APatchLines(lines []string) ([]patchOp, error) {
 				moveTo = strings.TrimSpace(strings.TrimPrefix(lines[i], "*** Move to: "))
 				i++
 			}
-			var hunks [][]string
-			var cur []string
+			var hunks []patchHunk
+			cur := patchHunk{}
+			started := false
+			flush := func() {
+				// Blank lines trailing a hunk separate sections; they are not context.
+				for len(cur.lines) > 0 && cur.lines[len(cur.lines)-1] == " " {
+					cur.lines = cur.lines[:len(cur.lines)-1]
+				}
+				if len(cur.lines) > 0 {
+					cur.label = "@@"
+					if cur.header != "" {
+						cur.label = "@@ " + cur.header
+					}
+					hunks = append(hunks, cur)
+				}
+				cur = patchHunk{}
+			}
 			for i < len(lines) {
+				if strings.TrimSpace(lines[i]) == "*** End of File" {
+					cur.eof = true
+					i++
+					continue
+				}
 				if strings.HasPrefix(lines[i], "*** ") || strings.TrimSpace(lines[i]) == "*** End Patch" {
 					break
 				}
-				if strings.HasPrefix(lines[i], "@@") && len(cur) > 0 {
-					hunks = append(hunks, cur)
-					cur = nil
+				if strings.HasPrefix(lines[i], "@@") {
+					if started {
+						flush()
+					}
+					started = true
+					cur.header = strings.TrimSpace(strings.TrimPrefix(lines[i], "@@"))
 					i++
 					continue
 				}
-				cur = append(cur, lines[i])
+				started = true
+				switch l := lines[i]; {
+				case l == "":
+					// Models often drop the space prefix of blank context lines.
+					cur.lines = append(cur.lines, " ")
+				case l[0] == ' ' || l[0] == '-' || l[0] == '+':
+					cur.lines = append(cur.lines, l)
+				default:
+					return nil, fmt.Errorf("apply_patch: update file %s: unexpected line %q (hunk lines start with ' ', '-' or '+')", path, l)
+				}
 				i++
 			}
-			if len(cur) > 0 {
-				hunks = append(hunks, cur)
-			}
+			flush()
 			ops = append(ops, updateFileOp{path: path, moveTo: moveTo, hunks: hunks})
 		default:
 			return nil, fmt.Errorf("apply_patch: unexpected line: %q", l)
@@ -250,24 +665,12 @@ func safeJoin(rootDir, rel string) (string, error) {
 	return filepath.Join(rootDir, clean), nil
 }
 
-func firstAnchor(hunk []string) string {
-	for _, l := range hunk {
-		if l == "" {
-			continue
-		}
-		if strings.HasPrefix(l, "@@") {
-			continue
-		}
-		if l[0] == ' ' || l[0] == '-' {
-			return l[1:]
-		}
-	}
-	return ""
-}
-
-func indexOfLine(lines []string, want string, start int) int {
-	for i := start; i < len(lines); i++ {
-		if lines[i] == want {
+// indexOfLineLoose finds want at or after start, ignoring surrounding
+// whitespace.
+func indexOfLineLoose(lines []string, want string, start int) int {
+	want = strings.TrimSpace(want)
+	for i := max(start, 0); i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == want {
 			return i
 		}
 	}
@@ -3,6 +3,7 @@ package agent
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -84,3 +85,197 @@ func TestApplyPatch_RejectsPathTraversalAndAbsolutePaths(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyPatch_UnifiedDiff_UpdateAddDeleteRenameMode(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "old.txt"), []byte("keep\nme\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "gone.txt"), []byte("bye\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "run.sh"), []byte("echo hi\n"), 0o644)
+
+	patch := `diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++TWO
+ three
+@@ -5,3 +5,4 @@ four
+ five
+ six
++six and a half
+ seven
+diff --git a/new.txt b/new.txt
+new file mode 100644
+--- /dev/null
++++ b/new.txt
+@@ -0,0 +1,2 @@
++hello
++world
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/old.txt b/dir/renamed.txt
+similarity index 80%
+rename from old.txt
+rename to dir/renamed.txt
+--- a/old.txt
++++ b/dir/renamed.txt
+@@ -1,2 +1,2 @@
+ keep
+-me
++you
+diff --git a/run.sh b/run.sh
+old mode 100644
+new mode 100755
+`
+	out, err := ApplyPatch(dir, patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	for _, want := range []string{"a.txt", "new.txt", "gone.txt", "dir/renamed.txt", "run.sh"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output lacks %s: %q", want, out)
+		}
+	}
+	read := func(name string) string {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return string(b)
+	}
+	if got := read("a.txt"); got != "one\nTWO\nthree\nfour\nfive\nsix\nsix and a half\nseven\n" {
+		t.Fatalf("a.txt: %q", got)
+	}
+	if got := read("new.txt"); got != "hello\nworld\n" {
+		t.Fatalf("new.txt: %q", got)
+	}
+	if got := read("dir/renamed.txt"); got != "keep\nyou\n" {
+		t.Fatalf("renamed.txt: %q", got)
+	}
+	for _, name := range []string{"gone.txt", "old.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Fatalf("expected %s to be gone", name)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if info, _ := os.Stat(filepath.Join(dir, "run.sh")); info.Mode().Perm() != 0o755 {
+			t.Fatalf("run.sh mode: %v", info.Mode())
+		}
+	}
+}
+
+func TestApplyPatch_FuzzyMatching_OffsetWhitespaceAndFuzz(t *testing.T) {
+	dir := t.TempDir()
+	orig := "header\nextra\nfunc main() {\n\tx := 1\n\ty := 2\n\treturn\n}\n"
+	_ = os.WriteFile(filepath.Join(dir, "m.go"), []byte(orig), 0o644)
+
+	// Stated at line 1 but the code moved down two lines, the context uses
+	// spaces where the file has tabs, and the first context line is stale.
+	patch := `--- a/m.go
++++ b/m.go
+@@ -1,4 +1,4 @@
+ func main() { // stale comment
+     x := 1
+-    y := 2
++    y := 3
+     return
+`
+	if _, err := ApplyPatchWithOptions(dir, patch, ApplyPatchOptions{Fuzz: 0}); err == nil {
+		t.Fatalf("expected fuzz 0 to reject the stale context line")
+	}
+	out, err := ApplyPatchWithOptions(dir, patch, ApplyPatchOptions{Fuzz: 1})
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	if !strings.Contains(out, "offset +2 lines") || !strings.Contains(out, "ignoring whitespace") || !strings.Contains(out, "fuzz 1") {
+		t.Fatalf("output lacks match notes: %q", out)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "m.go"))
+	if got := string(b); got != "header\nextra\nfunc main() {\n\tx := 1\n    y := 3\n\treturn\n}\n" {
+		t.Fatalf("m.go: %q", got)
+	}
+}
+
+func TestApplyPatch_V4A_BlankContextScopeAndEndOfFile(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "p.py"), []byte("def a():\n    return 1\n\ndef b():\n    return 1\n\nx = 1\n"), 0o644)
+	patch := `*** Begin Patch
+*** Update File: p.py
+@@ def b():
+-    return 1
++    return 2
+
+@@
+-x = 1
++x = 2
+*** End of File
+*** End Patch`
+	if _, err := ApplyPatch(dir, patch); err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "p.py"))
+	if got := string(b); got != "def a():\n    return 1\n\ndef b():\n    return 2\n\nx = 2\n" {
+		t.Fatalf("p.py: %q", got)
+	}
+}
+
+func TestApplyPatch_FailedHunk_ReportsAndChangesNothing(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\ntwo\nthree\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "b.txt"), []byte("alpha\nbeta\ngamma\n"), 0o644)
+	patch := `--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++TWO
+ three
+--- a/b.txt
++++ b/b.txt
+@@ -1,3 +1,3 @@
+ alpha
+-delta
++DELTA
+ gamma
+`
+	_, err := ApplyPatchWithOptions(dir, patch, ApplyPatchOptions{})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	msg := err.Error()
+	for _, want := range []string{"no files were changed", "b.txt: hunk 1 (@@ -1,3 +1,3 @@) did not match", "| delta", "closest match at line 1 (2 of 3 lines match)", "| beta"} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("error lacks %q:\n%s", want, msg)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "one\ntwo\nthree\n" {
+		t.Fatalf("a.txt changed despite the failed patch: %q", b)
+	}
+}
+
+func TestApplyPatch_WriteFailure_RollsBack(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "a.txt"), []byte("one\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(dir, "blocker"), []byte("a file, not a dir\n"), 0o644)
+	patch := `*** Begin Patch
+*** Update File: a.txt
+-one
++ONE
+*** Add File: blocker/new.txt
++x
+*** End Patch`
+	_, err := ApplyPatch(dir, patch)
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("expected rolled back error, got %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "one\n" {
+		t.Fatalf("a.txt not rolled back: %q", b)
+	}
+}
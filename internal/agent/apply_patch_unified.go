@@ -0,0 +1,359 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// isUnifiedDiff reports whether lines look like a unified diff.
+func isUnifiedDiff(lines []string) bool {
+	for i, l := range lines {
+		if strings.HasPrefix(l, "diff --git ") || strings.HasPrefix(l, "@@ -") {
+			return true
+		}
+		if strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ") {
+			return true
+		}
+	}
+	return false
+}
+
+// unifiedFile collects the headers and hunks of one file in a unified diff.
+type unifiedFile struct {
+	git              bool
+	oldPath, newPath string
+	hasOld           bool // saw ---/+++ paths
+	created, deleted bool
+	renamed, copied  bool
+	newMode          os.FileMode
+	hunks            []patchHunk
+}
+
+// parseUnifiedDiff parses `diff -u` / `git diff` output. Text outside file
+// sections (commit messages, "Index:" lines) is ignored. Hunk line counts are
+// trusted when they are right and ignored when a model got them wrong.
+func parseUnifiedDiff(lines []string) ([]patchOp, error) {
+	var ops []patchOp
+	var cur *unifiedFile
+	flush := func() error {
+		if cur == nil {
+			return nil
+		}
+		op, err := cur.op()
+		if err != nil {
+			return err
+		}
+		if op != nil {
+			ops = append(ops, op)
+		}
+		cur = nil
+		return nil
+	}
+	fileHeaderAt := func(i int) bool {
+		return strings.HasPrefix(lines[i], "diff --git ") ||
+			(strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "))
+	}
+
+	for i := 0; i < len(lines); {
+		l := lines[i]
+		switch {
+		case strings.HasPrefix(l, "diff --git "):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			a, b := splitGitDiffPaths(strings.TrimPrefix(l, "diff --git "))
+			cur = &unifiedFile{git: true, oldPath: a, newPath: b}
+			i++
+		case strings.HasPrefix(l, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if cur == nil || cur.hasOld || len(cur.hunks) > 0 {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				cur = &unifiedFile{}
+			}
+			oldPath, newPath := parseDiffPath(l[4:]), parseDiffPath(lines[i+1][4:])
+			if oldPath == "/dev/null" {
+				cur.created = true
+			} else {
+				cur.oldPath = oldPath
+			}
+			if newPath == "/dev/null" {
+				cur.deleted = true
+			} else {
+				cur.newPath = newPath
+			}
+			cur.hasOld = true
+			i += 2
+		case strings.HasPrefix(l, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("apply_patch: hunk %q has no file header (--- / +++)", l)
+			}
+			h, next, err := parseUnifiedHunk(lines, i, fileHeaderAt)
+			if err != nil {
+				return nil, err
+			}
+			cur.hunks = append(cur.hunks, h)
+			i = next
+		case cur != nil && cur.git && len(cur.hunks) == 0 && !cur.hasOld:
+			if err := cur.extendedHeader(l); err != nil {
+				return nil, err
+			}
+			i++
+		default:
+			i++
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("apply_patch: no file changes found in diff")
+	}
+	return ops, nil
+}
+
+// extendedHeader applies a git extended header line (mode, rename, copy...).
+func (f *unifiedFile) extendedHeader(l string) error {
+	field := func(prefix string) (string, bool) {
+		if v, ok := strings.CutPrefix(l, prefix); ok {
+			return parseDiffPath(v), true
+		}
+		return "", false
+	}
+	if v, ok := field("rename from "); ok {
+		f.oldPath, f.renamed = v, true
+	} else if v, ok := field("rename to "); ok {
+		f.newPath, f.renamed = v, true
+	} else if v, ok := field("copy from "); ok {
+		f.oldPath, f.copied = v, true
+	} else if v, ok := field("copy to "); ok {
+		f.newPath, f.copied = v, true
+	} else if v, ok := strings.CutPrefix(l, "new file mode "); ok {
+		f.created = true
+		return f.setMode(v)
+	} else if _, ok := strings.CutPrefix(l, "deleted file mode "); ok {
+		f.deleted = true
+	} else if v, ok := strings.CutPrefix(l, "new mode "); ok {
+		return f.setMode(v)
+	} else if strings.HasPrefix(l, "GIT binary patch") || (strings.HasPrefix(l, "Binary files ") && strings.HasSuffix(l, " differ")) {
+		return fmt.Errorf("apply_patch: %s: binary diffs are not supported", f.newPath)
+	}
+	return nil
+}
+
+func (f *unifiedFile) setMode(v string) error {
+	n, err := strconv.ParseUint(strings.TrimSpace(v), 8, 32)
+	if err != nil {
+		return fmt.Errorf("apply_patch: %s: bad mode %q", f.newPath, v)
+	}
+	if n&0o170000 != 0o100000 && n&0o170000 != 0 {
+		return fmt.Errorf("apply_patch: %s: mode %s is not a regular file (symlinks and submodules are not supported)", f.newPath, v)
+	}
+	f.newMode = os.FileMode(n & 0o777)
+	return nil
+}
+
+func (f *unifiedFile) op() (patchOp, error) {
+	oldPath, newPath := f.oldPath, f.newPath
+	// git prefixes ---/+++ and "diff --git" paths with a/ and b/, but not
+	// rename/copy headers. Plain diffs get them stripped only when both
+	// sides carry them.
+	strip := f.hasOld || !(f.renamed || f.copied)
+	if !f.git {
+		strip = (oldPath == "" || strings.HasPrefix(oldPath, "a/")) && (newPath == "" || strings.HasPrefix(newPath, "b/"))
+	}
+	if strip {
+		oldPath = strings.TrimPrefix(oldPath, "a/")
+		newPath = strings.TrimPrefix(newPath, "b/")
+	}
+	switch {
+	case f.deleted:
+		if oldPath == "" {
+			oldPath = newPath
+		}
+		return deleteFileOp{path: oldPath}, nil
+	case f.created:
+		if newPath == "" {
+			newPath = oldPath
+		}
+		var content []string
+		noEOL := false
+		for _, h := range f.hunks {
+			for _, l := range h.lines {
+				if l[0] == '+' {
+					content = append(content, l[1:])
+				}
+			}
+			noEOL = noEOL || h.noEOLNew
+		}
+		return addFileOp{path: newPath, lines: content, mode: f.newMode, noEOL: noEOL, unified: true}, nil
+	}
+	if oldPath == "" {
+		return nil, fmt.Errorf("apply_patch: diff section without a file path")
+	}
+	if newPath == "" {
+		newPath = oldPath
+	}
+	if len(f.hunks) == 0 && f.newMode == 0 && oldPath == newPath {
+		return nil, nil
+	}
+	op := updateFileOp{path: oldPath, mode: f.newMode, hunks: f.hunks, copy: f.copied}
+	if newPath != oldPath {
+		op.moveTo = newPath
+	}
+	return op, nil
+}
+
+// parseUnifiedHunk parses the hunk whose "@@" header is lines[start] and
+// returns the index of the line after it.
+func parseUnifiedHunk(lines []string, start int, fileHeaderAt func(int) bool) (patchHunk, int, error) {
+	header := lines[start]
+	h := patchHunk{label: header}
+	oldCount, newCount := -1, -1
+	if m := strings.SplitN(header, "@@", 3); len(m) == 3 {
+		fields := strings.Fields(m[1])
+		if len(fields) == 2 && strings.HasPrefix(fields[0], "-") && strings.HasPrefix(fields[1], "+") {
+			h.oldStart, oldCount = parseHunkRange(fields[0][1:])
+			_, newCount = parseHunkRange(fields[1][1:])
+			if oldCount == 0 {
+				// "-N,0" means the insertion goes after line N.
+				h.oldStart++
+			}
+		}
+		h.label = strings.TrimSpace("@@ " + strings.Join(fields, " ") + " @@")
+	}
+	counted := oldCount >= 0 && newCount >= 0
+	i := start + 1
+	last := byte(0)
+	for i < len(lines) {
+		l := lines[i]
+		done := counted && oldCount <= 0 && newCount <= 0
+		if strings.HasPrefix(l, "\\") {
+			switch last {
+			case '-':
+				h.noEOLOld = true
+			case '+':
+				h.noEOLNew = true
+			case ' ':
+				h.noEOLOld, h.noEOLNew = true, true
+			}
+			i++
+			continue
+		}
+		if strings.HasPrefix(l, "@@") || strings.HasPrefix(l, "diff --git ") {
+			break
+		}
+		// While the counts say the hunk continues, "--- x" is a removed line
+		// unless it really starts the next file.
+		if fileHeaderAt(i) && (done || !counted || i+2 >= len(lines) || strings.HasPrefix(lines[i+2], "@@")) {
+			break
+		}
+		if done && l == "-- " {
+			break // format-patch signature
+		}
+		if l == "" {
+			// A blank line is an empty context line while the counts say
+			// the hunk continues (models drop the leading space).
+			if done || !counted {
+				break
+			}
+			l = " "
+		}
+		switch l[0] {
+		case ' ':
+			oldCount--
+			newCount--
+		case '-':
+			oldCount--
+		case '+':
+			newCount--
+		default:
+			if !done && counted {
+				return h, i, fmt.Errorf("apply_patch: %s: unexpected line %q inside hunk", h.label, l)
+			}
+			return h, i, nil
+		}
+		h.lines = append(h.lines, l)
+		last = l[0]
+		i++
+	}
+	return h, i, nil
+}
+
+// parseHunkRange parses "start[,count]"; count defaults to 1. Malformed
+// ranges yield (0, -1) so the hunk is located by content alone.
+func parseHunkRange(s string) (int, int) {
+	startStr, countStr, hasCount := strings.Cut(s, ",")
+	start, err := strconv.Atoi(startStr)
+	if err != nil || start < 0 {
+		return 0, -1
+	}
+	count := 1
+	if hasCount {
+		if count, err = strconv.Atoi(countStr); err != nil || count < 0 {
+			return start, -1
+		}
+	}
+	return start, count
+}
+
+// parseDiffPath extracts the path from a ---/+++ or rename header value,
+// dropping a trailing timestamp and unquoting C-style quoted names.
+func parseDiffPath(s string) string {
+	s = strings.TrimRight(s, "\r")
+	if strings.HasPrefix(s, `"`) {
+		if end := closingQuote(s); end > 0 {
+			if v, err := strconv.Unquote(s[:end+1]); err == nil {
+				return v
+			}
+		}
+	}
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	return strings.TrimSpace(s)
+}
+
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// splitGitDiffPaths splits the "a/x b/y" of a diff --git line. Unquoted
+// names may contain spaces, so prefer the split where both names match.
+func splitGitDiffPaths(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		if end := closingQuote(s); end > 0 {
+			return parseDiffPath(s[:end+1]), parseDiffPath(strings.TrimSpace(s[end+1:]))
+		}
+	}
+	var candidates []int
+	for i := 0; i+3 <= len(s); i++ {
+		if strings.HasPrefix(s[i:], " b/") {
+			candidates = append(candidates, i)
+		}
+	}
+	for _, i := range candidates {
+		if strings.TrimPrefix(s[:i], "a/") == s[i+3:] {
+			return s[:i], s[i+1:]
+		}
+	}
+	if len(candidates) > 0 {
+		i := candidates[len(candidates)-1]
+		return s[:i], s[i+1:]
+	}
+	if a, b, ok := strings.Cut(s, " "); ok {
+		return a, b
+	}
+	return s, s
+}
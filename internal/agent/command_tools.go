@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+// CommandToolSpec declares a tool backed by a shell command, so projects can
+// give the agent tools (run a package's tests, dump a DB schema) without Go.
+//
+// Command is a bash template: {{param}} expands to the shell-quoted value of
+// a top-level argument ("" when absent; objects and arrays as JSON). The
+// command also receives the arguments as JSON on stdin and in
+// KILROY_TOOL_ARGS, each scalar argument as KILROY_ARG_<NAME>, and the tool
+// name as KILROY_TOOL_NAME. A non-zero exit or timeout is a tool error.
+type CommandToolSpec struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the arguments object (default: no
+	// arguments).
+	Parameters map[string]any
+	Command    string
+	// TimeoutMS defaults to 10s.
+	TimeoutMS  int
+	WorkingDir string
+	Env        map[string]string
+	// Limit overrides the default output truncation (20k chars, head/tail).
+	Limit ToolOutputLimit
+}
+
+const defaultCommandToolTimeoutMS = 10_000
+
+var commandTemplateParam = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// NewCommandTool validates spec and returns a registry entry for it.
+func NewCommandTool(spec CommandToolSpec) (RegisteredTool, error) {
+	if err := llm.ValidateToolName(spec.Name); err != nil {
+		return RegisteredTool{}, err
+	}
+	if strings.TrimSpace(spec.Command) == "" {
+		return RegisteredTool{}, fmt.Errorf("tool %s: command is required", spec.Name)
+	}
+	if spec.TimeoutMS < 0 {
+		return RegisteredTool{}, fmt.Errorf("tool %s: timeout_ms must be >= 0", spec.Name)
+	}
+	switch spec.Limit.Strategy {
+	case "", TruncHeadTail, TruncTail:
+	default:
+		return RegisteredTool{}, fmt.Errorf("tool %s: invalid truncation strategy %q (want head_tail|tail)", spec.Name, spec.Limit.Strategy)
+	}
+	params := spec.Parameters
+	if params == nil {
+		params = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	schema, err := compileSchema(params)
+	if err != nil {
+		return RegisteredTool{}, fmt.Errorf("tool %s schema: %w", spec.Name, err)
+	}
+	limit := spec.Limit
+	if limit.MaxChars <= 0 {
+		limit.MaxChars = defaultToolLimit(spec.Name).MaxChars
+	}
+	if limit.Strategy == "" {
+		limit.Strategy = TruncHeadTail
+	}
+	return RegisteredTool{
+		Definition: llm.ToolDefinition{Name: spec.Name, Description: spec.Description, Parameters: params},
+		Schema:     schema,
+		Limit:      limit,
+		Exec: func(ctx context.Context, env ExecutionEnvironment, args map[string]any) (any, error) {
+			return runCommandTool(ctx, env, spec, args)
+		},
+	}, nil
+}
+
+func runCommandTool(ctx context.Context, env ExecutionEnvironment, spec CommandToolSpec, args map[string]any) (any, error) {
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	for k, v := range spec.Env {
+		vars[k] = v
+	}
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if s, ok := commandArgString(args[k]); ok {
+			vars["KILROY_ARG_"+strings.ToUpper(sanitizeToolNamePart(k))] = s
+		}
+	}
+	vars["KILROY_TOOL_NAME"] = spec.Name
+	vars["KILROY_TOOL_ARGS"] = string(argsJSON)
+
+	cmd := commandTemplateParam.ReplaceAllStringFunc(spec.Command, func(m string) string {
+		name := commandTemplateParam.FindStringSubmatch(m)[1]
+		v, ok := args[name]
+		if !ok || v == nil {
+			return "''"
+		}
+		s, ok := commandArgString(v)
+		if !ok {
+			b, _ := json.Marshal(v)
+			s = string(b)
+		}
+		return shellEscape(s)
+	})
+	// ExecCommand has no stdin; feed the arguments through a pipe.
+	cmd = "printf '%s' \"$KILROY_TOOL_ARGS\" | (\n" + cmd + "\n)"
+
+	timeout := spec.TimeoutMS
+	if timeout <= 0 {
+		timeout = defaultCommandToolTimeoutMS
+	}
+	res, err := env.ExecCommand(ctx, cmd, timeout, spec.WorkingDir, vars)
+	out := formatExecResult(res, timeout, fmt.Sprintf("This is the %s tool's configured timeout and cannot be raised from a call; retry with narrower arguments or use another tool.", spec.Name))
+	if err != nil {
+		return out, err
+	}
+	if res.ExitCode != 0 || res.TimedOut {
+		return out, fmt.Errorf("tool %s exited with code %d", spec.Name, res.ExitCode)
+	}
+	return out, nil
+}
+
+// commandArgString renders scalar arguments; objects and arrays report false.
+func commandArgString(v any) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case bool:
+		return fmt.Sprint(x), true
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case nil:
+		return "", true
+	default:
+		return "", false
+	}
+}
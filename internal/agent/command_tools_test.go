@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func execCommandTool(t *testing.T, spec CommandToolSpec, args string) ToolExecResult {
+	t.Helper()
+	tool, err := NewCommandTool(spec)
+	if err != nil {
+		t.Fatalf("NewCommandTool: %v", err)
+	}
+	reg := NewToolRegistry()
+	if err := reg.Register(tool); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	env := NewLocalExecutionEnvironment(t.TempDir())
+	return reg.ExecuteCall(context.Background(), env, llm.ToolCallData{ID: "c1", Name: spec.Name, Arguments: json.RawMessage(args)})
+}
+
+func TestCommandTool_TemplateQuotesArguments(t *testing.T) {
+	res := execCommandTool(t, CommandToolSpec{
+		Name:    "say",
+		Command: "echo {{ msg }} {{missing}}end",
+		Parameters: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"msg": map[string]any{"type": "string"}},
+			"required":   []any{"msg"},
+		},
+	}, `{"msg":"hi; echo INJECTED"}`)
+	if res.IsError || !strings.Contains(res.Output, "hi; echo INJECTED end") {
+		t.Fatalf("output: %+v", res)
+	}
+	if strings.Contains(res.Output, "\nINJECTED") {
+		t.Fatalf("argument was not quoted: %q", res.Output)
+	}
+}
+
+func TestCommandTool_PassesArgumentsOnStdinAndEnv(t *testing.T) {
+	res := execCommandTool(t, CommandToolSpec{
+		Name:    "inspect",
+		Command: `cat; echo; echo "name=$KILROY_TOOL_NAME pkg=$KILROY_ARG_PKG n=$KILROY_ARG_COUNT extra=$EXTRA"`,
+		Env:     map[string]string{"EXTRA": "x"},
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"pkg":   map[string]any{"type": "string"},
+				"count": map[string]any{"type": "integer"},
+			},
+		},
+	}, `{"pkg":"./internal/agent","count":3}`)
+	if res.IsError {
+		t.Fatalf("unexpected error: %+v", res)
+	}
+	if !strings.Contains(res.Output, `{"count":3,"pkg":"./internal/agent"}`) {
+		t.Fatalf("stdin JSON missing: %q", res.Output)
+	}
+	if !strings.Contains(res.Output, "name=inspect pkg=./internal/agent n=3 extra=x") {
+		t.Fatalf("env missing: %q", res.Output)
+	}
+}
+
+func TestCommandTool_NonZeroExitIsToolError(t *testing.T) {
+	res := execCommandTool(t, CommandToolSpec{Name: "boom", Command: "echo nope >&2; exit 4"}, `{}`)
+	if !res.IsError || !strings.Contains(res.Output, "nope") || !strings.Contains(res.Output, "exit_code=4") {
+		t.Fatalf("result: %+v", res)
+	}
+}
+
+func TestCommandTool_TimeoutHintPointsAtConfiguredTimeout(t *testing.T) {
+	res := execCommandTool(t, CommandToolSpec{Name: "slow", Command: "sleep 5", TimeoutMS: 100}, `{}`)
+	if !res.IsError || !strings.Contains(res.Output, "timed out after 100ms") || !strings.Contains(res.Output, "slow tool's configured timeout") {
+		t.Fatalf("result: %+v", res)
+	}
+	if strings.Contains(res.Output, "timeout_ms parameter") {
+		t.Fatalf("hint suggests a parameter the tool does not have: %q", res.Output)
+	}
+}
+
+func TestCommandTool_AppliesOutputLimit(t *testing.T) {
+	res := execCommandTool(t, CommandToolSpec{
+		Name:    "big",
+		Command: "head -c 5000 /dev/zero | tr '\\0' a",
+		Limit:   ToolOutputLimit{MaxChars: 100, Strategy: TruncTail},
+	}, `{}`)
+	if res.IsError || !strings.Contains(res.Output, "[WARNING: Tool output was truncated.") || len(res.FullOutput) < 5000 {
+		t.Fatalf("result: output=%d full=%d err=%v", len(res.Output), len(res.FullOutput), res.IsError)
+	}
+}
+
+func TestNewCommandTool_ValidatesSpec(t *testing.T) {
+	cases := []CommandToolSpec{
+		{Name: "bad-name", Command: "true"},
+		{Name: "no_command"},
+		{Name: "bad_schema", Command: "true", Parameters: map[string]any{"type": 7}},
+		{Name: "bad_strategy", Command: "true", Limit: ToolOutputLimit{Strategy: "middle"}},
+	}
+	for _, spec := range cases {
+		if _, err := NewCommandTool(spec); err == nil {
+			t.Fatalf("expected error for %+v", spec)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+// Tokenizer counts the input tokens a request would consume.
+type Tokenizer interface {
+	CountTokens(ctx context.Context, req llm.Request) (int, error)
+}
+
+// ApproxTokenizer estimates tokens from character counts. It never fails and
+// makes no network calls.
+type ApproxTokenizer struct {
+	CharsPerToken      float64
+	PerMessageOverhead int
+}
+
+// ApproxTokenizerForProvider returns a character-ratio estimate tuned for the
+// provider family's tokenizer. Ratios are deliberately conservative (they
+// over-count slightly) so budgeting errs toward compacting early. Unknown
+// families use the plain chars/4 heuristic.
+func ApproxTokenizerForProvider(provider string) ApproxTokenizer {
+	switch strings.ToLower(strings.TrimSpace(provider)) {
+	case "anthropic", "kimi":
+		return ApproxTokenizer{CharsPerToken: 3.5, PerMessageOverhead: 4}
+	case "google", "gemini":
+		return ApproxTokenizer{CharsPerToken: 4.0, PerMessageOverhead: 4}
+	case "openai", "cerebras", "zai", "minimax", "ollama":
+		return ApproxTokenizer{CharsPerToken: 3.8, PerMessageOverhead: 4}
+	default:
+		return ApproxTokenizer{CharsPerToken: 4.0}
+	}
+}
+
+func (t ApproxTokenizer) CountTokens(_ context.Context, req llm.Request) (int, error) {
+	return t.count(req), nil
+}
+
+func (t ApproxTokenizer) count(req llm.Request) int {
+	cpt := t.CharsPerToken
+	if cpt <= 0 {
+		cpt = 4.0
+	}
+	chars := 0
+	for _, m := range req.Messages {
+		chars += messageCharCount(m)
+	}
+	for _, td := range req.Tools {
+		chars += len(td.Name) + len(td.Description)
+		if b, err := json.Marshal(td.Parameters); err == nil {
+			chars += len(b)
+		}
+	}
+	return int(math.Ceil(float64(chars)/cpt)) + t.PerMessageOverhead*len(req.Messages)
+}
+
+// clientTokenizer is the default session tokenizer: the family estimate, refined
+// with the provider's exact count endpoint once the estimate gets close to the
+// budget (exact counts cost a round-trip, so they are not worth paying early).
+type clientTokenizer struct {
+	client     *llm.Client
+	approx     ApproxTokenizer
+	exactAbove int
+}
+
+func (t *clientTokenizer) CountTokens(ctx context.Context, req llm.Request) (int, error) {
+	est := t.approx.count(req)
+	if t.client == nil || est < t.exactAbove {
+		return est, nil
+	}
+	tc, ok := t.client.TokenCounter(req.Provider)
+	if !ok {
+		return est, nil
+	}
+	n, err := tc.CountTokens(ctx, req)
+	if err != nil || n <= 0 {
+		return est, nil
+	}
+	return n, nil
+}
+
+const (
+	defaultContextBudgetFraction = 0.85
+	// compactionTargetFraction leaves headroom after compaction so the session
+	// does not compact again on the very next round.
+	compactionTargetFraction = 0.6
+	// forcedCompactionTargetFraction is used after the provider has already
+	// rejected the request as too long.
+	forcedCompactionTargetFraction = 0.45
+	// compactionKeepRecentTurns is never compacted: the model needs its latest
+	// tool results to make progress.
+	compactionKeepRecentTurns = 6
+	elideToolOutputMinChars   = 512
+	compactionSummaryPrefix   = "[Context compacted:"
+)
+
+// contextBudget returns the token budget for requests, or 0 when the profile
+// does not declare a context window or compaction is disabled.
+func (s *Session) contextBudget() int {
+	if s.cfg.EnableAutoCompaction != nil && !*s.cfg.EnableAutoCompaction {
+		return 0
+	}
+	cw := s.profile.ContextWindowSize()
+	if cw <= 0 {
+		return 0
+	}
+	return int(float64(cw) * s.cfg.ContextBudgetFraction)
+}
+
+func (s *Session) tokenizer() Tokenizer {
+	if s.cfg.Tokenizer != nil {
+		return s.cfg.Tokenizer
+	}
+	exactAbove := 0
+	if cw := s.profile.ContextWindowSize(); cw > 0 {
+		exactAbove = int(float64(cw) * compactionTargetFraction)
+	}
+	return &clientTokenizer{client: s.client, approx: ApproxTokenizerForProvider(s.profile.ID()), exactAbove: exactAbove}
+}
+
+func (s *Session) countRequestTokens(ctx context.Context, req llm.Request) int {
+	n, err := s.tokenizer().CountTokens(ctx, req)
+	if err != nil || n <= 0 {
+		return ApproxTokenizerForProvider(s.profile.ID()).count(req)
+	}
+	return n
+}
+
+// compactHistoryToFit shrinks the session history until build() fits within
+// target tokens: first by eliding old tool outputs (oldest first), then by
+// replacing the oldest turns with a summary. before is the current request's
+// token count. The latest turns are always kept intact, so compaction may stop
+// short of the target.
+func (s *Session) compactHistoryToFit(ctx context.Context, build func([]Turn) llm.Request, before, target int) (after int, changed bool) {
+	if before <= target {
+		return before, false
+	}
+	turns := s.historySnapshot()
+	snapshotLen := len(turns)
+
+	// Trim against the cheap estimate scaled to the measured count, so an exact
+	// provider count still drives how much is removed.
+	approx := ApproxTokenizerForProvider(s.profile.ID())
+	scale := 1.0
+	if est := approx.count(build(turns)); est > 0 {
+		scale = float64(before) / float64(est)
+	}
+	fits := func(ts []Turn) bool { return float64(approx.count(build(ts)))*scale <= float64(target) }
+
+	protected := len(turns) - compactionKeepRecentTurns
+	elided := 0
+	for i := 0; i < protected && !fits(turns); i++ {
+		if t, ok := elideToolTurn(turns[i]); ok {
+			turns[i] = t
+			elided++
+		}
+	}
+	dropped := 0
+	if !fits(turns) {
+		turns, dropped = dropOldestTurns(turns, fits)
+	}
+	if elided == 0 && dropped == 0 {
+		return before, false
+	}
+
+	s.mu.Lock()
+	// Keep anything appended since the snapshot (nothing today: compaction runs
+	// between rounds) after the compacted history.
+	turns = append(turns, s.history[snapshotLen:]...)
+	s.history = turns
+	s.journalWrite(sessionJournalRecord{Op: "reset", Turns: turns})
+	s.mu.Unlock()
+
+	after = s.countRequestTokens(ctx, build(turns))
+	s.emit(EventContextCompaction, map[string]any{
+		"tokens_before":       before,
+		"tokens_after":        after,
+		"target_tokens":       target,
+		"context_window_size": s.profile.ContextWindowSize(),
+		"elided_tool_outputs": elided,
+		"dropped_turns":       dropped,
+	})
+	return after, true
+}
+
+func elideToolTurn(t Turn) (Turn, bool) {
+	if t.Kind != TurnTool {
+		return t, false
+	}
+	var parts []llm.ContentPart
+	changed := false
+	for _, p := range t.Message.Content {
+		if p.Kind == llm.ContentToolResult && p.ToolResult != nil {
+			if s, ok := p.ToolResult.Content.(string); ok && len(s) >= elideToolOutputMinChars {
+				tr := *p.ToolResult
+				tr.Content = fmt.Sprintf("[tool output elided to fit the context window: %d chars; re-run the tool if you still need it]", len(s))
+				p.ToolResult = &tr
+				changed = true
+			}
+		}
+		parts = append(parts, p)
+	}
+	if !changed {
+		return t, false
+	}
+	t.Message.Content = parts
+	return t, true
+}
+
+// dropOldestTurns removes whole rounds from the front of the history (after
+// the first user input) until fits reports true or only the protected tail
+// remains, replacing them with a single summary turn. A cut never lands on a
+// tool turn, so tool results are never separated from their call. A summary
+// left by an earlier compaction is folded into the new one.
+func dropOldestTurns(turns []Turn, fits func([]Turn) bool) ([]Turn, int) {
+	start := 0
+	if len(turns) > 0 && turns[0].Kind == TurnUserInput {
+		start = 1
+	}
+	first, prior := start, 0
+	if start < len(turns) && isCompactionSummary(turns[start]) {
+		prior = parseCompactionSummaryCount(turns[start].Message.Text())
+		first = start + 1
+	}
+	limit := len(turns) - compactionKeepRecentTurns
+	var best []Turn
+	dropped := 0
+	for cut := first + 1; cut <= limit; cut++ {
+		if cut < len(turns) && turns[cut].Kind == TurnTool {
+			continue
+		}
+		candidate := make([]Turn, 0, start+1+len(turns)-cut)
+		candidate = append(candidate, turns[:start]...)
+		candidate = append(candidate, compactionSummaryTurn(turns[first:cut], prior+cut-first))
+		candidate = append(candidate, turns[cut:]...)
+		best, dropped = candidate, cut-first
+		if fits(candidate) {
+			break
+		}
+	}
+	if best == nil {
+		return turns, 0
+	}
+	return best, dropped
+}
+
+func compactionSummaryTurn(removed []Turn, totalRemoved int) Turn {
+	counts := map[string]int{}
+	for _, t := range removed {
+		for _, p := range t.Message.Content {
+			if p.Kind == llm.ContentToolCall && p.ToolCall != nil {
+				counts[p.ToolCall.Name]++
+			}
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for n := range counts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	tools := make([]string, 0, len(names))
+	for _, n := range names {
+		tools = append(tools, fmt.Sprintf("%s x%d", n, counts[n]))
+	}
+	summary := fmt.Sprintf("%s %d earlier turns were removed to stay within the model's context window.", compactionSummaryPrefix, totalRemoved)
+	if len(tools) > 0 {
+		summary += " Tool calls in the turns removed this time: " + strings.Join(tools, ", ") + "."
+	}
+	summary += " Re-read files or re-run commands if you need their current state.]"
+	return Turn{Kind: TurnSteering, Message: llm.User(summary)}
+}
+
+func isCompactionSummary(t Turn) bool {
+	return t.Kind == TurnSteering && strings.HasPrefix(t.Message.Text(), compactionSummaryPrefix)
+}
+
+func parseCompactionSummaryCount(text string) int {
+	var n int
+	_, _ = fmt.Sscanf(strings.TrimPrefix(text, compactionSummaryPrefix), "%d", &n)
+	return n
+}
@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestApproxTokenizerForProvider_UnknownFamilyUsesCharsOverFour(t *testing.T) {
+	req := llm.Request{Messages: []llm.Message{llm.User(strings.Repeat("a", 40))}}
+	n, err := ApproxTokenizerForProvider("tiny").CountTokens(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+	if n != 10 {
+		t.Fatalf("tokens: got %d want 10", n)
+	}
+	if a := ApproxTokenizerForProvider("anthropic").count(req); a <= n {
+		t.Fatalf("anthropic estimate should be more conservative: got %d (generic %d)", a, n)
+	}
+}
+
+func toolRoundTurns(id, output string) []Turn {
+	call := llm.ToolCallData{ID: id, Name: "read_file", Arguments: json.RawMessage(`{}`), Type: "function"}
+	return []Turn{
+		{Kind: TurnAssistant, Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &call}}}},
+		{Kind: TurnTool, Message: llm.ToolResultNamed(id, "read_file", output, false)},
+	}
+}
+
+func TestDropOldestTurns_KeepsToolResultsWithCallsAndFoldsSummary(t *testing.T) {
+	turns := []Turn{{Kind: TurnUserInput, Message: llm.User("task")}}
+	for i := 0; i < 6; i++ {
+		turns = append(turns, toolRoundTurns(fmt.Sprintf("c%d", i), "out")...)
+	}
+	fitsAfter := func(maxLen int) func([]Turn) bool {
+		return func(ts []Turn) bool { return len(ts) <= maxLen }
+	}
+
+	out, dropped := dropOldestTurns(turns, fitsAfter(10))
+	if dropped != 4 {
+		t.Fatalf("dropped: got %d want 4", dropped)
+	}
+	if out[0].Kind != TurnUserInput || !isCompactionSummary(out[1]) {
+		t.Fatalf("expected user input then summary, got %v / %v", out[0].Kind, out[1].Kind)
+	}
+	if out[2].Kind != TurnAssistant {
+		t.Fatalf("cut must not orphan a tool result: turn after summary is %v", out[2].Kind)
+	}
+	if !strings.Contains(out[1].Message.Text(), "read_file x2") {
+		t.Fatalf("summary: %q", out[1].Message.Text())
+	}
+
+	again, dropped := dropOldestTurns(out, fitsAfter(8))
+	if dropped != 2 {
+		t.Fatalf("second drop: got %d want 2", dropped)
+	}
+	if got := parseCompactionSummaryCount(again[1].Message.Text()); got != 6 {
+		t.Fatalf("folded summary count: got %d want 6 (%q)", got, again[1].Message.Text())
+	}
+}
+
+func TestElideToolTurn_OnlyLargeOutputs(t *testing.T) {
+	small := toolRoundTurns("a", "short")[1]
+	if _, ok := elideToolTurn(small); ok {
+		t.Fatalf("small output should be kept")
+	}
+	big := toolRoundTurns("b", strings.Repeat("x", elideToolOutputMinChars))[1]
+	got, ok := elideToolTurn(big)
+	if !ok {
+		t.Fatalf("large output should be elided")
+	}
+	if !strings.Contains(got.Message.Content[0].ToolResult.Content.(string), "elided") {
+		t.Fatalf("elided content: %#v", got.Message.Content[0].ToolResult.Content)
+	}
+	if got.Message.Content[0].ToolResult.ToolCallID != "b" {
+		t.Fatalf("tool call id must be preserved")
+	}
+	if !strings.Contains(big.Message.Content[0].ToolResult.Content.(string), "xxx") {
+		t.Fatalf("original turn must not be mutated")
+	}
+}
+
+// contextLimitAdapter issues read_file calls for `rounds` rounds and rejects any
+// request larger than maxChars with a context-length error.
+type contextLimitAdapter struct {
+	name     string
+	maxChars int
+	rounds   int
+
+	mu       sync.Mutex
+	calls    int
+	rejected int
+	sizes    []int
+}
+
+func (a *contextLimitAdapter) Name() string { return a.name }
+
+func (a *contextLimitAdapter) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	_ = ctx
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	total := 0
+	for _, m := range req.Messages {
+		total += messageCharCount(m)
+	}
+	a.sizes = append(a.sizes, total)
+	if total > a.maxChars {
+		a.rejected++
+		return llm.Response{}, llm.ErrorFromHTTPStatus(a.name, 413, "prompt is too long", nil, nil)
+	}
+	a.calls++
+	if a.calls > a.rounds {
+		return llm.Response{Provider: a.name, Model: req.Model, Message: llm.Assistant("done")}, nil
+	}
+	call := llm.ToolCallData{
+		ID:        fmt.Sprintf("c%d", a.calls),
+		Name:      "read_file",
+		Arguments: json.RawMessage(`{"file_path":"big.txt"}`),
+		Type:      "function",
+	}
+	return llm.Response{
+		Provider: a.name,
+		Model:    req.Model,
+		Message:  llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &call}}},
+	}, nil
+}
+
+func (a *contextLimitAdapter) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
+	_ = ctx
+	_ = req
+	return nil, fmt.Errorf("stream not implemented in contextLimitAdapter")
+}
+
+func writeBigFile(t *testing.T, dir string, lines int) {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		b.WriteString(strings.Repeat("z", 60) + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "big.txt"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func collectCompactions(sess *Session) []SessionEvent {
+	var out []SessionEvent
+	for ev := range sess.Events() {
+		if ev.Kind == EventContextCompaction {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestSession_CompactsHistoryBeforeExceedingBudget(t *testing.T) {
+	dir := t.TempDir()
+	writeBigFile(t, dir, 20)
+	c := llm.NewClient()
+	a := &contextLimitAdapter{name: "tiny", maxChars: 1 << 30, rounds: 10}
+	c.Register(a)
+
+	// cw=2000 tokens (~8000 chars): ten ~1.5k-char tool outputs cannot all fit.
+	sess, err := NewSession(c, tinyProfile{id: "tiny", mod: "m", cw: 2000}, NewLocalExecutionEnvironment(dir), SessionConfig{})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := sess.ProcessInput(ctx, "read it a lot")
+	if err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	if strings.TrimSpace(out) != "done" {
+		t.Fatalf("out: %q", out)
+	}
+	sess.Close()
+
+	if len(collectCompactions(sess)) == 0 {
+		t.Fatalf("expected CONTEXT_COMPACTION event")
+	}
+	budget := int(2000 * defaultContextBudgetFraction * 4)
+	for i, n := range a.sizes {
+		if n > budget {
+			t.Fatalf("request %d: %d chars exceeds budget of %d chars", i, n, budget)
+		}
+	}
+}
+
+func TestSession_ContextLengthError_CompactsAndRetries(t *testing.T) {
+	dir := t.TempDir()
+	writeBigFile(t, dir, 15)
+	c := llm.NewClient()
+	// The profile claims a large window, so only the provider's rejection can
+	// trigger compaction.
+	a := &contextLimitAdapter{name: "tiny", maxChars: 8000, rounds: 8}
+	c.Register(a)
+
+	sess, err := NewSession(c, tinyProfile{id: "tiny", mod: "m", cw: 1_000_000}, NewLocalExecutionEnvironment(dir), SessionConfig{})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := sess.ProcessInput(ctx, "read it a lot")
+	if err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	if strings.TrimSpace(out) != "done" {
+		t.Fatalf("out: %q", out)
+	}
+	sess.Close()
+
+	if a.rejected == 0 {
+		t.Fatalf("expected at least one context-length rejection")
+	}
+	if len(collectCompactions(sess)) == 0 {
+		t.Fatalf("expected CONTEXT_COMPACTION event")
+	}
+}
+
+func TestSession_AutoCompactionDisabled_FailsOnContextLengthError(t *testing.T) {
+	dir := t.TempDir()
+	writeBigFile(t, dir, 15)
+	c := llm.NewClient()
+	a := &contextLimitAdapter{name: "tiny", maxChars: 8000, rounds: 8}
+	c.Register(a)
+
+	off := false
+	sess, err := NewSession(c, tinyProfile{id: "tiny", mod: "m", cw: 1_000_000}, NewLocalExecutionEnvironment(dir), SessionConfig{
+		EnableAutoCompaction: &off,
+	})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := sess.ProcessInput(ctx, "read it a lot"); err == nil {
+		t.Fatalf("expected context length error")
+	}
+	if got := len(collectCompactions(sess)); got != 0 {
+		t.Fatalf("compaction events: got %d want 0", got)
+	}
+}
@@ -19,6 +19,13 @@ type LocalExecutionEnvironment struct {
 	RootDir      string
 	BaseEnv      map[string]string
 	StripEnvKeys []string
+
+	// Journal, when set, records every file mutation made through
+	// WriteFile, EditFile and ApplyPatch and enables the undo_last_edit and
+	// revert_file tools. nil (the constructors' default) disables it.
+	Journal *FileJournal
+
+	call toolCallRef // tool call this view runs on behalf of (see forToolCall)
 }
 
 func NewLocalExecutionEnvironmentWithPolicy(rootDir string, baseEnv map[string]string, stripKeys []string) *LocalExecutionEnvironment {
@@ -100,12 +107,14 @@ func (e *LocalExecutionEnvironment) ReadFile(path string, offsetLine *int, limit
 
 func (e *LocalExecutionEnvironment) WriteFile(path string, content string) (string, error) {
 	abs := e.resolve(path)
+	before := e.journalBefore(abs)
 	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
 		return "", err
 	}
 	if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
 		return "", err
 	}
+	e.journalAfter(abs, before)
 	return fmt.Sprintf("wrote %d bytes to %s", len(content), path), nil
 }
 
@@ -129,9 +138,11 @@ func (e *LocalExecutionEnvironment) EditFile(path string, oldString string, newS
 		s = strings.Replace(s, oldString, newString, 1)
 		n = 1
 	}
+	before := e.journalBefore(abs)
 	if err := os.WriteFile(abs, []byte(s), 0o644); err != nil {
 		return "", err
 	}
+	e.journalAfter(abs, before)
 	return fmt.Sprintf("edited %s: %d replacement(s)", path, n), nil
 }
 
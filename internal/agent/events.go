@@ -14,9 +14,11 @@ const (
 	EventToolCallStart      EventKind = "TOOL_CALL_START"
 	EventToolCallOutputDelta EventKind = "TOOL_CALL_OUTPUT_DELTA"
 	EventToolCallEnd        EventKind = "TOOL_CALL_END"
+	EventToolCallDenied     EventKind = "TOOL_CALL_DENIED"
 	EventSteeringInjected   EventKind = "STEERING_INJECTED"
 	EventTurnLimit          EventKind = "TURN_LIMIT"
 	EventLoopDetection      EventKind = "LOOP_DETECTION"
+	EventContextCompaction  EventKind = "CONTEXT_COMPACTION"
 	EventWarning            EventKind = "WARNING"
 	EventError              EventKind = "ERROR"
 )
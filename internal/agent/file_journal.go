@@ -0,0 +1,610 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+// FileChange is one file mutation made through a LocalExecutionEnvironment:
+// which tool call made it, the file's content hashes before and after, and
+// the exact unified diff. An empty hash means the file did not exist.
+type FileChange struct {
+	Seq          int    `json:"seq"`
+	Tool         string `json:"tool,omitempty"`
+	CallID       string `json:"call_id,omitempty"`
+	Path         string `json:"path"`
+	Op           string `json:"op"` // create, modify or delete
+	BeforeSHA256 string `json:"before_sha256,omitempty"`
+	AfterSHA256  string `json:"after_sha256,omitempty"`
+	Diff         string `json:"diff,omitempty"`
+	Timestamp    string `json:"timestamp"`
+
+	before *patchFile
+	undone bool
+}
+
+// maxJournalRetainedBytes caps the prior contents and diffs a journal keeps
+// in memory. Past it the oldest are dropped: those changes keep their
+// hashes (and, with a path, their NDJSON record) but can no longer be
+// undone.
+const maxJournalRetainedBytes = 32 << 20
+
+// FileJournal records the file changes made through an environment. With a
+// path, each change is also appended to that file as NDJSON. The recorded
+// prior contents back the undo_last_edit and revert_file tools.
+type FileJournal struct {
+	path string
+
+	mu       sync.Mutex
+	changes  []*FileChange
+	retained int // bytes of before contents and diffs held in changes
+	dropped  int // changes[:dropped] no longer hold them
+}
+
+// NewFileJournal returns a journal that appends to path (NDJSON); an empty
+// path keeps the journal in memory only.
+func NewFileJournal(path string) *FileJournal {
+	return &FileJournal{path: path}
+}
+
+// Changes returns every recorded change in order.
+func (j *FileJournal) Changes() []FileChange {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]FileChange, 0, len(j.changes))
+	for _, c := range j.changes {
+		out = append(out, *c)
+	}
+	return out
+}
+
+// ChangesForCall returns the changes made by one tool call.
+func (j *FileJournal) ChangesForCall(callID string) []FileChange {
+	if j == nil || callID == "" {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []FileChange
+	for _, c := range j.changes {
+		if c.CallID == callID {
+			out = append(out, *c)
+		}
+	}
+	return out
+}
+
+func (j *FileJournal) record(call toolCallRef, rel string, before, after *patchFile) {
+	if j == nil {
+		return
+	}
+	c := &FileChange{
+		Tool:         call.tool,
+		CallID:       call.id,
+		Path:         rel,
+		BeforeSHA256: before.sha256(),
+		AfterSHA256:  after.sha256(),
+		Timestamp:    time.Now().UTC().Format(time.RFC3339Nano),
+		before:       before,
+	}
+	if c.BeforeSHA256 == c.AfterSHA256 {
+		return
+	}
+	switch {
+	case !before.exists:
+		c.Op = "create"
+	case !after.exists:
+		c.Op = "delete"
+	default:
+		c.Op = "modify"
+	}
+	c.Diff = unifiedFileDiff(rel, before, after)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c.Seq = len(j.changes) + 1
+	j.changes = append(j.changes, c)
+	j.retained += c.retainedBytes()
+	for j.retained > maxJournalRetainedBytes && j.dropped < len(j.changes)-1 {
+		old := j.changes[j.dropped]
+		j.retained -= old.retainedBytes()
+		old.before, old.Diff = nil, ""
+		j.dropped++
+	}
+	if j.path == "" {
+		return
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return
+	}
+	_ = os.MkdirAll(filepath.Dir(j.path), 0o755)
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return
+	}
+	_, _ = f.Write(append(b, '\n'))
+	_ = f.Close()
+}
+
+func (c *FileChange) retainedBytes() int {
+	n := len(c.Diff)
+	if c.before != nil {
+		n += len(c.before.data)
+	}
+	return n
+}
+
+// lastEdit returns the not-yet-undone changes of the most recent tool call
+// that edited files (undo and revert calls excluded), oldest first.
+func (j *FileJournal) lastEdit() []*FileChange {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		last := j.changes[i]
+		if last.undone || last.Tool == "undo_last_edit" || last.Tool == "revert_file" {
+			continue
+		}
+		if last.CallID == "" {
+			return []*FileChange{last}
+		}
+		var out []*FileChange
+		for _, c := range j.changes[:i+1] {
+			if c.CallID == last.CallID && !c.undone {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// fileHistory returns the recorded changes to rel, oldest first.
+func (j *FileJournal) fileHistory(rel string) []*FileChange {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []*FileChange
+	for _, c := range j.changes {
+		if c.Path == rel {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (j *FileJournal) markUndone(cs []*FileChange) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cs {
+		c.undone = true
+	}
+}
+
+// toolCallRef names the tool call an environment method runs on behalf of.
+type toolCallRef struct {
+	tool string
+	id   string
+}
+
+// toolCallEnvironment is implemented by environments that attribute their
+// side effects to a tool call; the session hands each call its own view.
+type toolCallEnvironment interface {
+	forToolCall(tool, callID string) ExecutionEnvironment
+}
+
+// journaledEnvironment is implemented by environments that record file
+// changes; sessions offer the undo tools only for those.
+type journaledEnvironment interface {
+	FileJournal() *FileJournal
+}
+
+func (f *patchFile) sha256() string {
+	if f == nil || !f.exists {
+		return ""
+	}
+	sum := sha256.Sum256(f.data)
+	return hex.EncodeToString(sum[:])
+}
+
+// unifiedFileDiff renders the change from before to after as a git-style
+// unified diff with three lines of context.
+func unifiedFileDiff(rel string, before, after *patchFile) string {
+	from, to := "a/"+rel, "b/"+rel
+	if !before.exists {
+		from = "/dev/null"
+	}
+	if !after.exists {
+		to = "/dev/null"
+	}
+	header := fmt.Sprintf("--- %s\n+++ %s\n", from, to)
+	if bytes.IndexByte(before.data, 0) >= 0 || bytes.IndexByte(after.data, 0) >= 0 {
+		return fmt.Sprintf("Binary files %s and %s differ\n", from, to)
+	}
+	ops := diffLines(splitLinesKeepEOL(string(before.data)), splitLinesKeepEOL(string(after.data)))
+	if len(ops) == 0 {
+		return header
+	}
+	// oldAt[i]/newAt[i]: lines of each side before ops[i].
+	oldAt := make([]int, len(ops)+1)
+	newAt := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldAt[i+1], newAt[i+1] = oldAt[i], newAt[i]
+		if op.kind != '+' {
+			oldAt[i+1]++
+		}
+		if op.kind != '-' {
+			newAt[i+1]++
+		}
+	}
+
+	const contextLines = 3
+	var b strings.Builder
+	b.WriteString(header)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := max(i-contextLines, 0)
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			run := 0
+			for end+run < len(ops) && ops[end+run].kind == ' ' {
+				run++
+			}
+			if end+run < len(ops) && run <= 2*contextLines {
+				end += run
+				continue
+			}
+			end += min(run, contextLines)
+			break
+		}
+		oldStart, oldCount := oldAt[start]+1, oldAt[end]-oldAt[start]
+		newStart, newCount := newAt[start]+1, newAt[end]-newAt[start]
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.text)
+			if !strings.HasSuffix(op.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+func splitLinesKeepEOL(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a line diff: common prefix and suffix are trimmed and
+// the middle is aligned by longest common subsequence, falling back to a
+// plain replace when it is too large to align cheaply.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	var ops []diffOp
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(am)*len(bm) > 1<<22 {
+		for _, l := range am {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range bm {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		ops = append(ops, lcsDiff(am, bm)...)
+	}
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+func lcsDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// sortedChangePaths lists the distinct paths in cs.
+func sortedChangePaths(cs []*FileChange) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, c := range cs {
+		if !seen[c.Path] {
+			seen[c.Path] = true
+			out = append(out, c.Path)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// patchEnvironment is implemented by environments that apply patches
+// themselves (so the changes are journaled).
+type patchEnvironment interface {
+	ApplyPatch(patch string, opts ApplyPatchOptions) (string, error)
+}
+
+// editUndoer is implemented by environments that can undo journaled edits.
+type editUndoer interface {
+	UndoLastEdit() (string, error)
+	RevertFile(path string) (string, error)
+}
+
+// FileJournal returns the environment's file-change journal (nil when off).
+func (e *LocalExecutionEnvironment) FileJournal() *FileJournal { return e.Journal }
+
+// forToolCall returns a view of e whose file changes are attributed to the
+// given tool call. Views share the journal.
+func (e *LocalExecutionEnvironment) forToolCall(tool, callID string) ExecutionEnvironment {
+	view := *e
+	view.call = toolCallRef{tool: tool, id: callID}
+	return &view
+}
+
+// ApplyPatch is ApplyPatchWithOptions rooted at e.RootDir, journaling each
+// file the patch changes.
+func (e *LocalExecutionEnvironment) ApplyPatch(patch string, opts ApplyPatchOptions) (string, error) {
+	out, pfs, err := applyPatch(e.RootDir, patch, opts)
+	if err != nil || pfs == nil || e.Journal == nil {
+		return out, err
+	}
+	for _, p := range pfs.order {
+		e.Journal.record(e.call, e.relPath(p), pfs.before[p], pfs.files[p])
+	}
+	return out, nil
+}
+
+// UndoLastEdit restores the files changed by the most recent tool call that
+// has not been undone yet. It refuses, changing nothing, when one of them
+// was modified afterwards.
+func (e *LocalExecutionEnvironment) UndoLastEdit() (string, error) {
+	if e.Journal == nil {
+		return "", fmt.Errorf("file changes are not journaled in this environment")
+	}
+	group := e.Journal.lastEdit()
+	if len(group) == 0 {
+		return "", fmt.Errorf("no file edits to undo")
+	}
+	first, last := map[string]*FileChange{}, map[string]*FileChange{}
+	for _, c := range group {
+		if first[c.Path] == nil {
+			first[c.Path] = c
+		}
+		last[c.Path] = c
+	}
+	paths := sortedChangePaths(group)
+	for _, p := range paths {
+		if first[p].before == nil {
+			return "", fmt.Errorf("%s: the content before %s is no longer retained; nothing was undone", p, describeFileChange(first[p]))
+		}
+		cur, err := readPatchFile(e.resolve(p))
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", p, err)
+		}
+		if cur.sha256() != last[p].AfterSHA256 {
+			return "", fmt.Errorf("%s was changed after %s; nothing was undone (use revert_file, or edit it directly)", p, describeFileChange(last[p]))
+		}
+	}
+	for _, p := range paths {
+		if err := e.restoreFile(p, first[p].before); err != nil {
+			return "", fmt.Errorf("%s: %v", p, err)
+		}
+	}
+	e.Journal.markUndone(group)
+	return fmt.Sprintf("undid %s: restored %s", describeFileChange(group[0]), strings.Join(paths, ", ")), nil
+}
+
+// RevertFile restores path to its content before the first journaled change
+// to it (removing it if it did not exist then).
+func (e *LocalExecutionEnvironment) RevertFile(path string) (string, error) {
+	if e.Journal == nil {
+		return "", fmt.Errorf("file changes are not journaled in this environment")
+	}
+	rel := e.relPath(e.resolve(path))
+	history := e.Journal.fileHistory(rel)
+	if len(history) == 0 {
+		return "", fmt.Errorf("no recorded changes to %s", rel)
+	}
+	if history[0].before == nil {
+		return "", fmt.Errorf("the content of %s before %s is no longer retained", rel, describeFileChange(history[0]))
+	}
+	if err := e.restoreFile(rel, history[0].before); err != nil {
+		return "", err
+	}
+	e.Journal.markUndone(history)
+	return fmt.Sprintf("reverted %s to its state before %s (%d recorded change(s) discarded)", rel, describeFileChange(history[0]), len(history)), nil
+}
+
+func (e *LocalExecutionEnvironment) restoreFile(rel string, state *patchFile) error {
+	abs := e.resolve(rel)
+	before := e.journalBefore(abs)
+	if err := writePatchFile(abs, state); err != nil {
+		return err
+	}
+	e.journalAfter(abs, before)
+	return nil
+}
+
+func (e *LocalExecutionEnvironment) journalBefore(abs string) *patchFile {
+	if e.Journal == nil {
+		return nil
+	}
+	f, err := readPatchFile(abs)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+func (e *LocalExecutionEnvironment) journalAfter(abs string, before *patchFile) {
+	if e.Journal == nil || before == nil {
+		return
+	}
+	after, err := readPatchFile(abs)
+	if err != nil {
+		return
+	}
+	e.Journal.record(e.call, e.relPath(abs), before, after)
+}
+
+// relPath names abs relative to the root (slash-separated), or absolutely
+// when it lies outside.
+func (e *LocalExecutionEnvironment) relPath(abs string) string {
+	rel, err := filepath.Rel(e.RootDir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(abs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func describeFileChange(c *FileChange) string {
+	switch {
+	case c.Tool != "" && c.CallID != "":
+		return fmt.Sprintf("%s (call %s)", c.Tool, c.CallID)
+	case c.Tool != "":
+		return c.Tool
+	default:
+		return fmt.Sprintf("change #%d", c.Seq)
+	}
+}
+
+// registerJournalTools registers undo_last_edit and revert_file.
+func registerJournalTools(reg *ToolRegistry) error {
+	if err := reg.Register(RegisteredTool{
+		Definition: defUndoLastEdit(),
+		Exec: func(ctx context.Context, env ExecutionEnvironment, args map[string]any) (any, error) {
+			u, ok := env.(editUndoer)
+			if !ok {
+				return nil, fmt.Errorf("undo is not supported in this environment")
+			}
+			return u.UndoLastEdit()
+		},
+	}); err != nil {
+		return err
+	}
+	return reg.Register(RegisteredTool{
+		Definition: defRevertFile(),
+		Exec: func(ctx context.Context, env ExecutionEnvironment, args map[string]any) (any, error) {
+			u, ok := env.(editUndoer)
+			if !ok {
+				return nil, fmt.Errorf("revert is not supported in this environment")
+			}
+			return u.RevertFile(argStr(args, "file_path"))
+		},
+	})
+}
+
+func journalToolDefinitions() []llm.ToolDefinition {
+	return []llm.ToolDefinition{defUndoLastEdit(), defRevertFile()}
+}
+
+func defUndoLastEdit() llm.ToolDefinition {
+	return llm.ToolDefinition{
+		Name:        "undo_last_edit",
+		Description: "Undo the most recent file-editing tool call (write_file, edit_file or apply_patch) that has not been undone, restoring every file it changed. Repeat to step further back. Refuses if a file was changed since by other means.",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties":           map[string]any{},
+		},
+	}
+}
+
+func defRevertFile() llm.ToolDefinition {
+	return llm.ToolDefinition{
+		Name:        "revert_file",
+		Description: "Restore a file to its content before this session first changed it through the file tools (deleting it if the session created it).",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]any{
+				"file_path": map[string]any{"type": "string"},
+			},
+			"required": []string{"file_path"},
+		},
+	}
+}
@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestLocalExecutionEnvironment_JournalsWriteEditAndPatch(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(t.TempDir(), "file_changes.ndjson")
+	env := NewLocalExecutionEnvironment(dir)
+	env.Journal = NewFileJournal(journalPath)
+	call := func(tool, id string) *LocalExecutionEnvironment {
+		return env.forToolCall(tool, id).(*LocalExecutionEnvironment)
+	}
+
+	if _, err := call("write_file", "c1").WriteFile("a.txt", "one\ntwo\nthree\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call("edit_file", "c2").EditFile("a.txt", "two", "TWO", false); err != nil {
+		t.Fatal(err)
+	}
+	patch := "*** Begin Patch\n*** Update File: a.txt\n@@\n one\n-TWO\n+2\n three\n*** Add File: b.txt\n+bee\n*** End Patch\n"
+	if _, err := call("apply_patch", "c3").ApplyPatch(patch, ApplyPatchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Rewriting identical content is not a change.
+	if _, err := call("write_file", "c4").WriteFile("b.txt", "bee\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := env.Journal.Changes()
+	if len(changes) != 4 {
+		t.Fatalf("changes: %+v", changes)
+	}
+	if c := changes[0]; c.Tool != "write_file" || c.CallID != "c1" || c.Op != "create" || c.BeforeSHA256 != "" || c.AfterSHA256 == "" {
+		t.Fatalf("write change: %+v", c)
+	}
+	edit := changes[1]
+	if edit.Op != "modify" || edit.BeforeSHA256 != changes[0].AfterSHA256 {
+		t.Fatalf("edit change: %+v", edit)
+	}
+	wantDiff := "--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+TWO\n three\n"
+	if edit.Diff != wantDiff {
+		t.Fatalf("edit diff:\n%s\nwant:\n%s", edit.Diff, wantDiff)
+	}
+	patched := env.Journal.ChangesForCall("c3")
+	if len(patched) != 2 || patched[0].Path != "a.txt" || patched[1].Path != "b.txt" || patched[1].Op != "create" {
+		t.Fatalf("patch changes: %+v", patched)
+	}
+	if !strings.Contains(patched[1].Diff, "--- /dev/null\n+++ b/b.txt\n@@ -0,0 +1,1 @@\n+bee\n") {
+		t.Fatalf("add diff:\n%s", patched[1].Diff)
+	}
+
+	b, err := os.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("ndjson lines: %d\n%s", len(lines), b)
+	}
+	var last FileChange
+	if err := json.Unmarshal([]byte(lines[3]), &last); err != nil || last.Seq != 4 || last.CallID != "c3" {
+		t.Fatalf("ndjson record: %+v err=%v", last, err)
+	}
+}
+
+func TestUnifiedFileDiff_SplitsHunksAndMarksMissingNewline(t *testing.T) {
+	var old []string
+	for i := 1; i <= 20; i++ {
+		old = append(old, "line"+string(rune('a'+i)))
+	}
+	before := strings.Join(old, "\n") + "\n"
+	after := strings.Replace(strings.Replace(before, "lineb\n", "LINEB\n", 1), "lineu\n", "lineu", 1)
+	got := unifiedFileDiff("f", &patchFile{exists: true, data: []byte(before)}, &patchFile{exists: true, data: []byte(after)})
+	if strings.Count(got, "@@ ") != 2 || !strings.Contains(got, "@@ -1,4 +1,4 @@\n-lineb\n+LINEB\n") {
+		t.Fatalf("hunks:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "-lineu\n+lineu\n\\ No newline at end of file\n") {
+		t.Fatalf("missing newline marker:\n%s", got)
+	}
+}
+
+func TestSession_UndoLastEditAndRevertFile(t *testing.T) {
+	dir := t.TempDir()
+	call := func(id, name, args string) func(llm.Request) llm.Response {
+		return func(llm.Request) llm.Response {
+			tc := llm.ToolCallData{ID: id, Name: name, Arguments: json.RawMessage(args)}
+			return llm.Response{Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &tc}}}}
+		}
+	}
+	readA := func() string {
+		b, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(b)
+	}
+	var seen []string
+	observe := func(id, name, args string) func(llm.Request) llm.Response {
+		return func(req llm.Request) llm.Response {
+			seen = append(seen, readA())
+			return call(id, name, args)(req)
+		}
+	}
+	f := &fakeAdapter{name: "anthropic", steps: []func(llm.Request) llm.Response{
+		call("w", "write_file", `{"file_path":"a.txt","content":"one\n"}`),
+		call("e", "edit_file", `{"file_path":"a.txt","old_string":"one","new_string":"two"}`),
+		observe("u1", "undo_last_edit", `{}`),
+		observe("u2", "undo_last_edit", `{}`),
+		observe("u3", "undo_last_edit", `{}`),
+		call("w2", "write_file", `{"file_path":"a.txt","content":"three\n"}`),
+		call("e2", "edit_file", `{"file_path":"a.txt","old_string":"three","new_string":"four"}`),
+		observe("r", "revert_file", `{"file_path":"a.txt"}`),
+		observe("done", "read_file", `{"file_path":"a.txt"}`),
+	}}
+	c := llm.NewClient()
+	c.Register(f)
+	env := NewLocalExecutionEnvironment(dir)
+	env.Journal = NewFileJournal("")
+	sess, err := NewSession(c, NewAnthropicProfile("claude-sonnet-4-5"), env, SessionConfig{})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := sess.ProcessInput(ctx, "edit"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	sess.Close()
+
+	// a.txt before u1, u2, u3, the revert and the final read: undo steps
+	// back through the edit and the create; the revert removes the file
+	// the session re-created.
+	want := []string{"two\n", "one\n", "<", "four\n", "<"}
+	if len(seen) != 5 {
+		t.Fatalf("observed: %q", seen)
+	}
+	for i := range want {
+		if !strings.HasPrefix(seen[i], want[i]) {
+			t.Fatalf("step %d: got %q want %q (all %q)", i, seen[i], want[i], seen)
+		}
+	}
+	results := map[string]llm.ToolResultData{}
+	for _, m := range f.Requests()[len(f.Requests())-1].Messages {
+		for _, p := range m.Content {
+			if p.Kind == llm.ContentToolResult && p.ToolResult != nil {
+				results[p.ToolResult.ToolCallID] = *p.ToolResult
+			}
+		}
+	}
+	if r := results["u1"]; r.IsError || !strings.Contains(toolResultText(r), "undid edit_file (call e): restored a.txt") {
+		t.Fatalf("u1: %+v", r)
+	}
+	if r := results["u3"]; !r.IsError || !strings.Contains(toolResultText(r), "no file edits to undo") {
+		t.Fatalf("u3: %+v", r)
+	}
+	if r := results["r"]; r.IsError || !strings.Contains(toolResultText(r), "reverted a.txt to its state before write_file (call w)") {
+		t.Fatalf("revert: %+v", r)
+	}
+
+	var editEnd map[string]any
+	for ev := range sess.Events() {
+		if ev.Kind == EventToolCallEnd && ev.Data["call_id"] == "e" {
+			editEnd = ev.Data
+		}
+	}
+	changes, _ := editEnd["file_changes"].([]FileChange)
+	if len(changes) != 1 || !strings.Contains(changes[0].Diff, "-one\n+two\n") {
+		t.Fatalf("tool end file_changes: %+v", editEnd["file_changes"])
+	}
+}
+
+func TestSession_UndoLastEdit_RefusesWhenFileChangedSince(t *testing.T) {
+	dir := t.TempDir()
+	env := NewLocalExecutionEnvironment(dir)
+	env.Journal = NewFileJournal("")
+	if _, err := env.forToolCall("write_file", "w").WriteFile("a.txt", "one\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("edited by shell\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.UndoLastEdit(); err == nil || !strings.Contains(err.Error(), "a.txt was changed after write_file (call w); nothing was undone") {
+		t.Fatalf("expected refusal, got %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "edited by shell\n" {
+		t.Fatalf("file touched: %q", b)
+	}
+}
+
+func TestSession_JournalToolsOnlyWhenJournaling(t *testing.T) {
+	env := NewLocalExecutionEnvironment(t.TempDir())
+	sess, err := NewSession(llm.NewClient(), NewAnthropicProfile("claude-sonnet-4-5"), env, SessionConfig{})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer sess.Close()
+	if sess.offersJournalTools() {
+		t.Fatal("undo tools offered without a journal")
+	}
+	env.Journal = NewFileJournal("")
+	if !sess.offersJournalTools() {
+		t.Fatal("undo tools not offered with a journal")
+	}
+}
+
+func TestFileJournal_DropsOldestContentsPastCap(t *testing.T) {
+	dir := t.TempDir()
+	env := NewLocalExecutionEnvironment(dir)
+	env.Journal = NewFileJournal("")
+	big := strings.Repeat("x", maxJournalRetainedBytes/2)
+	for i, id := range []string{"w1", "w2", "w3"} {
+		if _, err := env.forToolCall("write_file", id).WriteFile(fmt.Sprintf("f%d.txt", i), big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if env.Journal.retained > maxJournalRetainedBytes {
+		t.Fatalf("retained %d bytes, cap %d", env.Journal.retained, maxJournalRetainedBytes)
+	}
+	if len(env.Journal.Changes()) != 3 {
+		t.Fatalf("changes: %d", len(env.Journal.Changes()))
+	}
+	if _, err := env.RevertFile("f0.txt"); err == nil || !strings.Contains(err.Error(), "no longer retained") {
+		t.Fatalf("revert of dropped change: %v", err)
+	}
+	if _, err := env.UndoLastEdit(); err != nil {
+		t.Fatalf("undo of retained change: %v", err)
+	}
+}
@@ -0,0 +1,502 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/lsp"
+)
+
+// DefaultDiagnosticsWait bounds how long the diagnostics tool waits for a
+// language server to publish after a file changed.
+const DefaultDiagnosticsWait = 10 * time.Second
+
+// LanguageServerConfig describes a language server backing the code
+// intelligence tools (go_to_definition, find_references, diagnostics,
+// document_symbols) for files with the given extensions. Servers start on
+// first use, rooted at the session's working directory, and stop when the
+// session closes.
+type LanguageServerConfig struct {
+	Name    string
+	Command string
+	Args    []string
+	Env     map[string]string
+	// Extensions lists the file extensions the server handles (".go").
+	Extensions []string
+	// LanguageID overrides the document language id guessed from the
+	// extension.
+	LanguageID string
+	// RequestTimeout bounds each request (default 60s).
+	RequestTimeout time.Duration
+	// DiagnosticsWait overrides DefaultDiagnosticsWait.
+	DiagnosticsWait time.Duration
+}
+
+func (c LanguageServerConfig) validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("language server: name is required")
+	}
+	if strings.TrimSpace(c.Command) == "" {
+		return fmt.Errorf("language server %q: command is required", c.Name)
+	}
+	if len(c.Extensions) == 0 {
+		return fmt.Errorf("language server %q: extensions are required", c.Name)
+	}
+	for _, ext := range c.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 {
+			return fmt.Errorf("language server %q: extension %q must look like \".go\"", c.Name, ext)
+		}
+	}
+	return nil
+}
+
+func (c LanguageServerConfig) handles(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range c.Extensions {
+		if strings.ToLower(e) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// lspManager starts a session's language servers lazily and remembers
+// servers that failed to start so every call does not pay for it again.
+type lspManager struct {
+	root    string
+	servers []LanguageServerConfig
+
+	mu      sync.Mutex
+	clients map[string]*lsp.Client
+	failed  map[string]error
+	closed  bool
+}
+
+func newLSPManager(root string, servers []LanguageServerConfig) (*lspManager, error) {
+	seen := map[string]bool{}
+	for _, s := range servers {
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("language server %q is configured twice", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	return &lspManager{root: root, servers: servers, clients: map[string]*lsp.Client{}, failed: map[string]error{}}, nil
+}
+
+// clientFor returns the running server for path, starting it if needed.
+func (m *lspManager) clientFor(ctx context.Context, path string) (*lsp.Client, LanguageServerConfig, error) {
+	var cfg LanguageServerConfig
+	found := false
+	for _, s := range m.servers {
+		if s.handles(path) {
+			cfg, found = s, true
+			break
+		}
+	}
+	if !found {
+		var exts []string
+		for _, s := range m.servers {
+			exts = append(exts, s.Extensions...)
+		}
+		sort.Strings(exts)
+		return nil, cfg, fmt.Errorf("no language server handles %s files (configured: %s)", filepath.Ext(path), strings.Join(exts, ", "))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, cfg, fmt.Errorf("session is closed")
+	}
+	if c := m.clients[cfg.Name]; c != nil {
+		return c, cfg, nil
+	}
+	if err := m.failed[cfg.Name]; err != nil {
+		return nil, cfg, err
+	}
+	c, err := lsp.Start(ctx, lsp.ServerConfig{
+		Name:           cfg.Name,
+		Command:        cfg.Command,
+		Args:           cfg.Args,
+		Env:            cfg.Env,
+		RootDir:        m.root,
+		RequestTimeout: cfg.RequestTimeout,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			m.failed[cfg.Name] = err
+		}
+		return nil, cfg, err
+	}
+	m.clients[cfg.Name] = c
+	return c, cfg, nil
+}
+
+func (m *lspManager) close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.closed = true
+	clients := m.clients
+	m.clients = map[string]*lsp.Client{}
+	m.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *lsp.Client) {
+			defer wg.Done()
+			_ = c.Close()
+		}(c)
+	}
+	wg.Wait()
+}
+
+// lspTarget is the file (and position) a code intelligence call is about.
+type lspTarget struct {
+	client *lsp.Client
+	cfg    LanguageServerConfig
+	path   string
+	text   string
+	pos    lsp.Position
+	symbol string
+}
+
+func (m *lspManager) target(ctx context.Context, args map[string]any, needPos bool) (*lspTarget, error) {
+	p := strings.TrimSpace(argStr(args, "file_path"))
+	if p == "" {
+		return nil, fmt.Errorf("file_path is required")
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(m.root, p)
+	}
+	if _, err := os.Stat(p); err != nil {
+		return nil, err
+	}
+	c, cfg, err := m.clientFor(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	langID := cfg.LanguageID
+	if langID == "" {
+		langID = lsp.LanguageID(p)
+	}
+	text, err := c.Sync(p, langID)
+	if err != nil {
+		return nil, err
+	}
+	t := &lspTarget{client: c, cfg: cfg, path: p, text: text}
+	if !needPos {
+		return t, nil
+	}
+	line := argInt(args, "line")
+	if line < 1 || line > strings.Count(text, "\n")+1 {
+		return nil, fmt.Errorf("line %d is outside %s (1-%d)", line, m.display(p), strings.Count(text, "\n")+1)
+	}
+	col := argInt(args, "column")
+	t.symbol = strings.TrimSpace(argStr(args, "symbol"))
+	lineText := lsp.LineText(text, line-1)
+	if t.symbol != "" {
+		at := indexWord(lineText, t.symbol)
+		if at < 0 {
+			return nil, fmt.Errorf("symbol %q not found on line %d: %s", t.symbol, line, strings.TrimSpace(lineText))
+		}
+		col = len([]rune(lineText[:at])) + 1
+	}
+	if col < 1 {
+		return nil, fmt.Errorf("column or symbol is required")
+	}
+	t.pos = lsp.PositionFor(text, line, col)
+	if t.symbol == "" {
+		t.symbol = wordAtColumn(lineText, col)
+	}
+	return t, nil
+}
+
+func argInt(args map[string]any, key string) int {
+	if n, ok := args[key].(float64); ok {
+		return int(n)
+	}
+	return 0
+}
+
+// indexWord finds word in line, preferring a whole-word match.
+func indexWord(line, word string) int {
+	for from := 0; ; {
+		i := strings.Index(line[from:], word)
+		if i < 0 {
+			break
+		}
+		at := from + i
+		end := at + len(word)
+		if (at == 0 || !isIdentByte(line[at-1])) && (end == len(line) || !isIdentByte(line[end])) {
+			return at
+		}
+		from = at + 1
+	}
+	return strings.Index(line, word)
+}
+
+func wordAtColumn(line string, col int) string {
+	r := []rune(line)
+	i := col - 1
+	if i < 0 || i >= len(r) {
+		return ""
+	}
+	start, end := i, i
+	for start > 0 && isIdentRune(r[start-1]) {
+		start--
+	}
+	for end < len(r) && isIdentRune(r[end]) {
+		end++
+	}
+	return string(r[start:end])
+}
+
+func isIdentByte(b byte) bool { return b == '_' || b >= 0x80 || isAlnum(rune(b)) }
+
+func isIdentRune(r rune) bool { return r == '_' || r >= 0x80 || isAlnum(r) }
+
+func isAlnum(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// display shows path relative to the session root when it is inside it.
+func (m *lspManager) display(path string) string {
+	if rel, err := filepath.Rel(m.root, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// formatLocations renders locations as "path:line:col: source line", reading
+// each file once.
+func (m *lspManager) formatLocations(locs []lsp.Location, self *lspTarget) string {
+	texts := map[string]string{self.path: self.text}
+	var b strings.Builder
+	for _, l := range locs {
+		p := l.Path()
+		text, ok := texts[p]
+		if !ok {
+			raw, _ := os.ReadFile(p)
+			text = string(raw)
+			texts[p] = text
+		}
+		line, col := lsp.LineCol(text, l.Range.Start)
+		fmt.Fprintf(&b, "%s:%d:%d: %s\n", m.display(p), line, col, strings.TrimSpace(lsp.LineText(text, l.Range.Start.Line)))
+	}
+	return b.String()
+}
+
+func registerLSPTools(reg *ToolRegistry, m *lspManager) error {
+	tools := []RegisteredTool{
+		{
+			Definition: defGoToDefinition(),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, args map[string]any) (any, error) {
+				t, err := m.target(ctx, args, true)
+				if err != nil {
+					return nil, err
+				}
+				locs, err := t.client.Definition(ctx, t.path, t.pos)
+				if err != nil {
+					return nil, err
+				}
+				if len(locs) == 0 {
+					return fmt.Sprintf("no definition found for %q", t.symbol), nil
+				}
+				return m.formatLocations(locs, t), nil
+			},
+		},
+		{
+			Definition: defFindReferences(),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, args map[string]any) (any, error) {
+				t, err := m.target(ctx, args, true)
+				if err != nil {
+					return nil, err
+				}
+				includeDecl := true
+				if v, ok := args["include_declaration"].(bool); ok {
+					includeDecl = v
+				}
+				locs, err := t.client.References(ctx, t.path, t.pos, includeDecl)
+				if err != nil {
+					return nil, err
+				}
+				if len(locs) == 0 {
+					return fmt.Sprintf("no references found for %q", t.symbol), nil
+				}
+				sort.SliceStable(locs, func(i, j int) bool {
+					if locs[i].URI != locs[j].URI {
+						return locs[i].URI < locs[j].URI
+					}
+					return locs[i].Range.Start.Line < locs[j].Range.Start.Line
+				})
+				return fmt.Sprintf("%d references to %q\n", len(locs), t.symbol) + m.formatLocations(locs, t), nil
+			},
+		},
+		{
+			Definition: defDocumentSymbols(),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, args map[string]any) (any, error) {
+				t, err := m.target(ctx, args, false)
+				if err != nil {
+					return nil, err
+				}
+				syms, err := t.client.DocumentSymbols(ctx, t.path)
+				if err != nil {
+					return nil, err
+				}
+				if len(syms) == 0 {
+					return fmt.Sprintf("no symbols in %s", m.display(t.path)), nil
+				}
+				var b strings.Builder
+				writeSymbols(&b, t.text, syms, 0)
+				return b.String(), nil
+			},
+		},
+		{
+			Definition: defDiagnostics(),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, args map[string]any) (any, error) {
+				t, err := m.target(ctx, args, false)
+				if err != nil {
+					return nil, err
+				}
+				wait := t.cfg.DiagnosticsWait
+				if wait <= 0 {
+					wait = DefaultDiagnosticsWait
+				}
+				diags, fresh, err := t.client.Diagnostics(ctx, t.path, wait)
+				if err != nil {
+					return nil, err
+				}
+				var b strings.Builder
+				if !fresh {
+					fmt.Fprintf(&b, "[%s has not reported diagnostics for the current content yet; results may be stale]\n", t.cfg.Name)
+				}
+				if len(diags) == 0 {
+					fmt.Fprintf(&b, "no diagnostics in %s\n", m.display(t.path))
+					return b.String(), nil
+				}
+				sort.SliceStable(diags, func(i, j int) bool { return diags[i].Range.Start.Line < diags[j].Range.Start.Line })
+				for _, d := range diags {
+					line, col := lsp.LineCol(t.text, d.Range.Start)
+					fmt.Fprintf(&b, "%s:%d:%d: %s: %s", m.display(t.path), line, col, d.Severity, d.Message)
+					if src := strings.TrimSpace(d.Source); src != "" {
+						fmt.Fprintf(&b, " (%s)", src)
+					}
+					b.WriteString("\n")
+				}
+				return b.String(), nil
+			},
+		},
+	}
+	for _, t := range tools {
+		if err := reg.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSymbols(b *strings.Builder, text string, syms []lsp.DocumentSymbol, depth int) {
+	for _, s := range syms {
+		line, _ := lsp.LineCol(text, s.SelectionRange.Start)
+		endLine, _ := lsp.LineCol(text, s.Range.End)
+		fmt.Fprintf(b, "%s%s %s", strings.Repeat("  ", depth), s.Kind, s.Name)
+		if d := strings.TrimSpace(s.Detail); d != "" {
+			fmt.Fprintf(b, " %s", truncateDetail(d))
+		}
+		if endLine > line {
+			fmt.Fprintf(b, " (lines %d-%d)\n", line, endLine)
+		} else {
+			fmt.Fprintf(b, " (line %d)\n", line)
+		}
+		writeSymbols(b, text, s.Children, depth+1)
+	}
+}
+
+func truncateDetail(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > 80 {
+		return string(r[:79]) + "…"
+	}
+	return s
+}
+
+func lspToolDefinitions() []llm.ToolDefinition {
+	return []llm.ToolDefinition{defGoToDefinition(), defFindReferences(), defDocumentSymbols(), defDiagnostics()}
+}
+
+func lspPositionProperties() map[string]any {
+	return map[string]any{
+		"file_path": map[string]any{"type": "string"},
+		"line":      map[string]any{"type": "integer", "description": "1-based line of the symbol."},
+		"symbol":    map[string]any{"type": "string", "description": "The identifier on that line to look up."},
+		"column":    map[string]any{"type": "integer", "description": "1-based column of the symbol, instead of symbol."},
+	}
+}
+
+func defGoToDefinition() llm.ToolDefinition {
+	return llm.ToolDefinition{
+		Name:        "go_to_definition",
+		Description: "Find where the symbol at a position is defined, using the language server. Give the line and either the symbol name or its column.",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties":           lspPositionProperties(),
+			"required":             []string{"file_path", "line"},
+		},
+	}
+}
+
+func defFindReferences() llm.ToolDefinition {
+	props := lspPositionProperties()
+	props["include_declaration"] = map[string]any{"type": "boolean", "description": "Include the declaration itself (default true)."}
+	return llm.ToolDefinition{
+		Name:        "find_references",
+		Description: "List every use of the symbol at a position across the workspace, using the language server.",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties":           props,
+			"required":             []string{"file_path", "line"},
+		},
+	}
+}
+
+func defDocumentSymbols() llm.ToolDefinition {
+	return llm.ToolDefinition{
+		Name:        "document_symbols",
+		Description: "Outline a file: its types, functions, methods and fields with their line ranges.",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]any{
+				"file_path": map[string]any{"type": "string"},
+			},
+			"required": []string{"file_path"},
+		},
+	}
+}
+
+func defDiagnostics() llm.ToolDefinition {
+	return llm.ToolDefinition{
+		Name:        "diagnostics",
+		Description: "Report the language server's errors and warnings for a file's current content (compile errors, type errors, lint findings).",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]any{
+				"file_path": map[string]any{"type": "string"},
+			},
+			"required": []string{"file_path"},
+		},
+	}
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/lsp/lsptest"
+)
+
+func TestLSPFakeServerHelper(t *testing.T) {
+	lsptest.ServeIfHelper()
+	t.Skip("helper process for LSP tests")
+}
+
+func fakeLanguageServer(t *testing.T) LanguageServerConfig {
+	t.Helper()
+	cmd, args, env := lsptest.HelperCommand("TestLSPFakeServerHelper")
+	return LanguageServerConfig{Name: "toy", Command: cmd, Args: args, Env: env, Extensions: []string{".toy"}, DiagnosticsWait: 5 * time.Second}
+}
+
+func TestSession_LSPTools_NavigateAndDiagnose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("main.toy", "func main\n  helper()\n  BUG\n")
+	write("lib.toy", "type Thing\nfunc helper\n  helper()\n")
+	write("notes.txt", "plain\n")
+
+	call := func(id, name, args string) func(llm.Request) llm.Response {
+		return func(llm.Request) llm.Response {
+			tc := llm.ToolCallData{ID: id, Name: name, Arguments: json.RawMessage(args)}
+			return llm.Response{Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &tc}}}}
+		}
+	}
+	f := &fakeAdapter{name: "openai", steps: []func(llm.Request) llm.Response{
+		call("def", "go_to_definition", `{"file_path":"main.toy","line":2,"symbol":"helper"}`),
+		call("refs", "find_references", `{"file_path":"main.toy","line":2,"column":4}`),
+		call("syms", "document_symbols", `{"file_path":"lib.toy"}`),
+		call("diag", "diagnostics", `{"file_path":"main.toy"}`),
+		call("nosym", "go_to_definition", `{"file_path":"main.toy","line":2,"symbol":"missing"}`),
+		call("noserver", "document_symbols", `{"file_path":"notes.txt"}`),
+	}}
+	c := llm.NewClient()
+	c.Register(f)
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(dir), SessionConfig{
+		LanguageServers:  []LanguageServerConfig{fakeLanguageServer(t)},
+		ToolOutputLimits: map[string]ToolOutputLimit{"find_references": {MaxLines: 3}},
+	})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	if _, err := sess.ProcessInput(ctx, "navigate"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	sess.Close()
+
+	reqs := f.Requests()
+	offered := map[string]bool{}
+	for _, d := range reqs[0].Tools {
+		offered[d.Name] = true
+	}
+	for _, name := range []string{"go_to_definition", "find_references", "diagnostics", "document_symbols", "read_file"} {
+		if !offered[name] {
+			t.Fatalf("tool %s not offered to the model", name)
+		}
+	}
+	results := map[string]llm.ToolResultData{}
+	for _, m := range reqs[len(reqs)-1].Messages {
+		for _, p := range m.Content {
+			if p.Kind == llm.ContentToolResult && p.ToolResult != nil {
+				results[p.ToolResult.ToolCallID] = *p.ToolResult
+			}
+		}
+	}
+	expect := func(id string, isErr bool, wants ...string) {
+		t.Helper()
+		r := results[id]
+		text := toolResultText(r)
+		if r.IsError != isErr {
+			t.Fatalf("%s: is_error=%v: %s", id, r.IsError, text)
+		}
+		for _, w := range wants {
+			if !strings.Contains(text, w) {
+				t.Fatalf("%s: missing %q in:\n%s", id, w, text)
+			}
+		}
+	}
+	expect("def", false, "lib.toy:2:6: func helper")
+	// Three references, bounded to three lines by ToolOutputLimits.
+	expect("refs", false, `3 references to "helper"`, "lines omitted")
+	expect("syms", false, "struct Thing (line 1)", "function helper (line 2)")
+	expect("diag", false, "main.toy:3:3: error: found a bug (lsptest)")
+	expect("nosym", true, `symbol "missing" not found on line 2`)
+	expect("noserver", true, "no language server handles .txt files")
+}
+
+func TestSession_LSPTools_OnlyOfferedWhenConfigured(t *testing.T) {
+	c := llm.NewClient()
+	f := &fakeAdapter{name: "openai"}
+	c.Register(f)
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(t.TempDir()), SessionConfig{})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer sess.Close()
+	for _, d := range sess.buildRequest("", nil).Tools {
+		if d.Name == "go_to_definition" {
+			t.Fatalf("LSP tools offered without language servers")
+		}
+	}
+
+	_, err = NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(t.TempDir()), SessionConfig{
+		LanguageServers: []LanguageServerConfig{{Name: "x", Command: "x", Extensions: []string{"go"}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), `must look like ".go"`) {
+		t.Fatalf("expected extension error, got %v", err)
+	}
+}
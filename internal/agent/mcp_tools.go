@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/mcp"
+)
+
+// MCPToolName is the registry name of an MCP server's tool:
+// mcp__<server>__<tool>, restricted to the characters providers accept and
+// shortened with a hash suffix when it would exceed 64 characters.
+func MCPToolName(server, tool string) string {
+	name := "mcp__" + sanitizeToolNamePart(server) + "__" + sanitizeToolNamePart(tool)
+	if len(name) <= 64 {
+		return name
+	}
+	sum := sha256.Sum256([]byte(server + "\x00" + tool))
+	return name[:55] + "_" + hex.EncodeToString(sum[:4])
+}
+
+func sanitizeToolNamePart(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// MCPTools lists a connected server's tools and adapts them for a
+// ToolRegistry (see SessionConfig.ExtraTools). When the server offers
+// resources, mcp__<server>__list_resources and mcp__<server>__read_resource
+// are added. Calls go through the session's normal tool path, so tool hooks,
+// events and truncation apply as for built-in tools.
+func MCPTools(ctx context.Context, c *mcp.Client) ([]RegisteredTool, error) {
+	server := c.Name()
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("mcp server %q: list tools: %w", server, err)
+	}
+	out := make([]RegisteredTool, 0, len(tools)+2)
+	for _, t := range tools {
+		toolName := t.Name
+		desc := strings.TrimSpace(t.Description)
+		if desc == "" {
+			desc = t.Title
+		}
+		rt := RegisteredTool{
+			Definition: mcpToolDefinition(MCPToolName(server, toolName), server, desc, t.InputSchema),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, args map[string]any) (any, error) {
+				res, err := c.CallTool(ctx, toolName, args)
+				if err != nil {
+					return nil, err
+				}
+				if res.IsError {
+					return res.Text(), fmt.Errorf("mcp tool %s/%s failed", server, toolName)
+				}
+				return res.Text(), nil
+			},
+		}
+		if _, err := compileSchema(rt.Definition.Parameters); err != nil {
+			// Keep the tool usable: let the server validate arguments.
+			rt.Definition.Parameters = map[string]any{"type": "object"}
+		}
+		out = append(out, rt)
+	}
+	if c.Capabilities().Resources != nil {
+		out = append(out, mcpResourceTools(c)...)
+	}
+	return out, nil
+}
+
+func mcpToolDefinition(name, server, desc string, schema map[string]any) llm.ToolDefinition {
+	if schema == nil {
+		schema = map[string]any{"type": "object", "properties": map[string]any{}}
+	} else if _, ok := schema["type"]; !ok {
+		cp := make(map[string]any, len(schema)+1)
+		for k, v := range schema {
+			cp[k] = v
+		}
+		cp["type"] = "object"
+		schema = cp
+	}
+	return llm.ToolDefinition{
+		Name:        name,
+		Description: fmt.Sprintf("[MCP server %s] %s", server, desc),
+		Parameters:  schema,
+	}
+}
+
+func mcpResourceTools(c *mcp.Client) []RegisteredTool {
+	server := c.Name()
+	return []RegisteredTool{
+		{
+			Definition: mcpToolDefinition(MCPToolName(server, "list_resources"), server,
+				"List the resources (URI, name, MIME type) this server exposes.", nil),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, _ map[string]any) (any, error) {
+				rs, err := c.ListResources(ctx)
+				if err != nil {
+					return nil, err
+				}
+				b, err := json.MarshalIndent(rs, "", "  ")
+				if err != nil {
+					return nil, err
+				}
+				return string(b), nil
+			},
+		},
+		{
+			Definition: mcpToolDefinition(MCPToolName(server, "read_resource"), server,
+				"Read a resource by URI.", map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"uri": map[string]any{"type": "string"}},
+					"required":   []string{"uri"},
+				}),
+			Exec: func(ctx context.Context, _ ExecutionEnvironment, args map[string]any) (any, error) {
+				uri, _ := args["uri"].(string)
+				contents, err := c.ReadResource(ctx, uri)
+				if err != nil {
+					return nil, err
+				}
+				parts := make([]string, 0, len(contents))
+				for _, rc := range contents {
+					if rc.Text != "" || rc.Blob == "" {
+						parts = append(parts, rc.Text)
+						continue
+					}
+					parts = append(parts, fmt.Sprintf("[%s (%s), %d base64 bytes]", rc.URI, rc.MimeType, len(rc.Blob)))
+				}
+				return strings.Join(parts, "\n"), nil
+			},
+		},
+	}
+}
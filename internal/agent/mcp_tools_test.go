@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/mcp"
+	"github.com/danshapiro/kilroy/internal/mcp/mcptest"
+)
+
+func TestMCPStubServerHelper(t *testing.T) {
+	mcptest.ServeIfHelper()
+	t.Skip("helper process for MCP tests")
+}
+
+func TestMCPToolName_SanitizesAndBoundsLength(t *testing.T) {
+	if got := MCPToolName("code-search", "find.symbol"); got != "mcp__code_search__find_symbol" {
+		t.Fatalf("got %q", got)
+	}
+	long := MCPToolName("server", strings.Repeat("x", 80))
+	if len(long) != 64 || llm.ValidateToolName(long) != nil {
+		t.Fatalf("long name %q (len %d) invalid", long, len(long))
+	}
+	if long == MCPToolName("server", strings.Repeat("x", 81)) {
+		t.Fatalf("distinct long names collided")
+	}
+}
+
+func TestSession_MCPTools_RegisteredAndCallable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mc, err := mcp.Connect(ctx, mcptest.HelperConfig("stub", "TestMCPStubServerHelper"))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer func() { _ = mc.Close() }()
+	tools, err := MCPTools(ctx, mc)
+	if err != nil {
+		t.Fatalf("MCPTools: %v", err)
+	}
+
+	call := func(id, name, args string) func(llm.Request) llm.Response {
+		return func(llm.Request) llm.Response {
+			tc := llm.ToolCallData{ID: id, Name: name, Arguments: json.RawMessage(args)}
+			return llm.Response{Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &tc}}}}
+		}
+	}
+	f := &fakeAdapter{name: "openai", steps: []func(llm.Request) llm.Response{
+		call("1", "mcp__stub__echo", `{"text":"hello"}`),
+		call("2", "mcp__stub__fail", `{}`),
+		call("3", "mcp__stub__read_resource", `{"uri":"stub://readme"}`),
+	}}
+	c := llm.NewClient()
+	c.Register(f)
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(t.TempDir()), SessionConfig{ExtraTools: tools})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	if _, err := sess.ProcessInput(ctx, "use the tools"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	sess.Close()
+
+	reqs := f.Requests()
+	defs := map[string]llm.ToolDefinition{}
+	for _, d := range reqs[0].Tools {
+		defs[d.Name] = d
+	}
+	for _, name := range []string{"mcp__stub__echo", "mcp__stub__fail", "mcp__stub__cwd", "mcp__stub__list_resources", "mcp__stub__read_resource", "shell"} {
+		if _, ok := defs[name]; !ok {
+			t.Fatalf("tool %s not offered to the model", name)
+		}
+	}
+	if !strings.HasPrefix(defs["mcp__stub__echo"].Description, "[MCP server stub]") {
+		t.Fatalf("description: %q", defs["mcp__stub__echo"].Description)
+	}
+
+	results := map[string]llm.ToolResultData{}
+	for _, m := range reqs[len(reqs)-1].Messages {
+		for _, p := range m.Content {
+			if p.Kind == llm.ContentToolResult && p.ToolResult != nil {
+				results[p.ToolResult.ToolCallID] = *p.ToolResult
+			}
+		}
+	}
+	if r := results["1"]; r.IsError || !strings.Contains(toolResultText(r), "echo: hello") {
+		t.Fatalf("echo result: %+v", r)
+	}
+	if r := results["2"]; !r.IsError || !strings.Contains(toolResultText(r), "stub failure") {
+		t.Fatalf("fail result: %+v", r)
+	}
+	if r := results["3"]; r.IsError || !strings.Contains(toolResultText(r), mcptest.ReadmeText) {
+		t.Fatalf("resource result: %+v", r)
+	}
+}
+
+func TestSession_ExtraTools_RejectsNameCollision(t *testing.T) {
+	c := llm.NewClient()
+	c.Register(&fakeAdapter{name: "openai"})
+	_, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(t.TempDir()), SessionConfig{
+		ExtraTools: []RegisteredTool{{
+			Definition: llm.ToolDefinition{Name: "shell"},
+			Exec:       func(context.Context, ExecutionEnvironment, map[string]any) (any, error) { return "", nil },
+		}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+}
+
+func toolResultText(r llm.ToolResultData) string {
+	switch v := r.Content.(type) {
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
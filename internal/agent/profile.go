@@ -135,6 +135,7 @@ func NewOpenAIProfile(model string) ProviderProfile {
 			defSendInput(),
 			defWait(),
 			defCloseAgent(),
+			defMergeAgent(),
 		},
 	}
 }
@@ -158,6 +159,7 @@ func NewAnthropicProfile(model string) ProviderProfile {
 			defSendInput(),
 			defWait(),
 			defCloseAgent(),
+			defMergeAgent(),
 		},
 	}
 }
@@ -183,6 +185,7 @@ func NewGeminiProfile(model string) ProviderProfile {
 			defSendInput(),
 			defWait(),
 			defCloseAgent(),
+			defMergeAgent(),
 		},
 	}
 }
@@ -343,12 +346,12 @@ func defGlob() llm.ToolDefinition {
 func defApplyPatch() llm.ToolDefinition {
 	return llm.ToolDefinition{
 		Name:        "apply_patch",
-		Description: "Apply code changes using the v4a patch format.",
+		Description: "Apply code changes using the v4a patch format or a unified diff (git diff style, including renames and mode changes). All files are changed or none are; failed hunks are reported with the closest matching text.",
 		Parameters: map[string]any{
 			"type":                 "object",
 			"additionalProperties": false,
 			"properties": map[string]any{
-				"patch": map[string]any{"type": "string"},
+				"patch": map[string]any{"type": "string", "description": "A '*** Begin Patch' v4a patch or a unified diff."},
 			},
 			"required": []string{"patch"},
 		},
@@ -358,18 +361,36 @@ func defApplyPatch() llm.ToolDefinition {
 func defSpawnAgent() llm.ToolDefinition {
 	return llm.ToolDefinition{
 		Name:        "spawn_agent",
-		Description: "Spawn a sub-agent to work on a scoped task.",
+		Description: "Spawn a sub-agent to work on a scoped task. With isolation=worktree it works in its own git worktree (a copy of your current files) so several sub-agents can edit in parallel; bring its changes back with merge_agent.",
 		Parameters: map[string]any{
 			"type":                 "object",
 			"additionalProperties": false,
 			"properties": map[string]any{
-				"task": map[string]any{"type": "string"},
+				"task":      map[string]any{"type": "string"},
+				"model":     map[string]any{"type": "string", "description": "Model for the sub-agent (default: yours)."},
+				"provider":  map[string]any{"type": "string", "description": "Provider for the sub-agent (default: yours)."},
+				"isolation": map[string]any{"type": "string", "enum": []string{SubagentIsolationShared, SubagentIsolationWorktree}},
 			},
 			"required": []string{"task"},
 		},
 	}
 }
 
+func defMergeAgent() llm.ToolDefinition {
+	return llm.ToolDefinition{
+		Name:        "merge_agent",
+		Description: "Three-way merge the changes of a finished worktree-isolated sub-agent into your working directory. Conflicting files are left with conflict markers and listed in the result.",
+		Parameters: map[string]any{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]any{
+				"agent_id": map[string]any{"type": "string"},
+			},
+			"required": []string{"agent_id"},
+		},
+	}
+}
+
 func defSendInput() llm.ToolDefinition {
 	return llm.ToolDefinition{
 		Name:        "send_input",
@@ -13,6 +13,7 @@ import (
 	"github.com/oklog/ulid/v2"
 
 	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/tracing"
 )
 
 type SessionConfig struct {
@@ -44,6 +45,27 @@ type SessionConfig struct {
 	// veto tool calls.
 	ToolCallFilter func(toolName, callID, argsJSON string) (skipReason string)
 
+	// ToolPolicy, when non-nil, is evaluated before ToolCallFilter. Denied
+	// calls return a structured error to the model and emit
+	// EventToolCallDenied. Subagents share the policy (and its write budget).
+	ToolPolicy *ToolPolicy
+
+	// PatchFuzz is how many context lines apply_patch may drop from each end
+	// of a hunk that does not match (see ApplyPatchOptions). 0 uses
+	// DefaultPatchFuzz; a negative value disables fuzz.
+	PatchFuzz int
+
+	// ExtraTools are registered after the built-in tools (e.g. tools from MCP
+	// servers, see MCPTools). A name that collides with an existing tool is an
+	// error. Subagents inherit them.
+	ExtraTools []RegisteredTool
+
+	// LanguageServers back the go_to_definition, find_references,
+	// diagnostics and document_symbols tools, which are offered only when at
+	// least one is configured. Each session (subagents included) starts its
+	// own servers on first use and stops them on Close.
+	LanguageServers []LanguageServerConfig
+
 	EnableLoopDetection *bool
 	LoopDetectionWindow int
 
@@ -51,6 +73,48 @@ type SessionConfig struct {
 	// Nil means use llm.DefaultRetryPolicy().
 	LLMRetryPolicy *llm.RetryPolicy
 	LLMSleep       llm.SleepFunc
+
+	// Tokenizer counts request tokens for context budgeting. Nil means a
+	// per-provider-family estimate refined by the provider's count-tokens
+	// endpoint (when it has one) near the budget.
+	Tokenizer Tokenizer
+	// ContextBudgetFraction is the share of the profile's context window a
+	// request may use before history is compacted (default 0.85).
+	ContextBudgetFraction float64
+	// EnableAutoCompaction compacts history (eliding old tool outputs, then
+	// summarizing the oldest turns) when a request exceeds the budget or the
+	// provider reports a context-length error. Nil means enabled.
+	EnableAutoCompaction *bool
+
+	// StatePath, when set, journals the session (history, queued steering
+	// and follow-ups, open subagents) to this file with a checkpoint before
+	// every model call, so LoadSessionState can rebuild it after the process
+	// dies. Subagents are not journaled.
+	StatePath string
+	// StateFingerprint, when set, is recorded with each checkpoint (e.g. a
+	// hash of the working tree) so a resumer can check that the environment
+	// still matches the conversation.
+	StateFingerprint func() (string, error)
+	// Resume restores history, queues and turn count from a journaled state
+	// instead of starting empty.
+	Resume *SessionState
+
+	// SubagentIsolation is the default for spawn_agent's isolation argument:
+	// "shared" (default) runs subagents in this session's environment;
+	// "worktree" gives each its own git worktree holding the parent's current
+	// state, whose changes come back through merge_agent.
+	SubagentIsolation string
+	// SubagentWorktreeRoot is where isolated worktrees are created (default:
+	// the system temp dir).
+	SubagentWorktreeRoot string
+	// SubagentProfile builds the profile for a subagent that asks for another
+	// provider or model (provider may be empty: same as the parent). Nil
+	// means NewProfileForFamily.
+	SubagentProfile func(provider, model string) (ProviderProfile, error)
+
+	// sessionID, when set, is the new session's ID (spawnAgent names the
+	// subagent's worktree after it before the session exists).
+	sessionID string
 }
 
 // ErrTurnLimit indicates the session exceeded its configured MaxTurns budget.
@@ -79,6 +143,9 @@ func (c *SessionConfig) applyDefaults() {
 	if c.LoopDetectionWindow <= 0 {
 		c.LoopDetectionWindow = 10
 	}
+	if c.ContextBudgetFraction <= 0 || c.ContextBudgetFraction > 1 {
+		c.ContextBudgetFraction = defaultContextBudgetFraction
+	}
 }
 
 type Session struct {
@@ -97,10 +164,13 @@ type Session struct {
 	history []Turn
 
 	reg *ToolRegistry
+	lsp *lspManager
 
 	steeringQueue []string
 	followups     []string
 
+	journal *sessionJournal
+
 	// subagents
 	depth     int
 	subagents map[string]*subagent
@@ -116,10 +186,22 @@ func NewSession(client *llm.Client, profile ProviderProfile, env ExecutionEnviro
 	if env == nil {
 		return nil, fmt.Errorf("execution environment is nil")
 	}
+	if err := cfg.ToolPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.SubagentIsolation {
+	case "", SubagentIsolationShared, SubagentIsolationWorktree:
+	default:
+		return nil, fmt.Errorf("invalid subagent isolation %q (want shared|worktree)", cfg.SubagentIsolation)
+	}
 	cfg.applyDefaults()
 
+	id := cfg.sessionID
+	if id == "" {
+		id = ulid.Make().String()
+	}
 	s := &Session{
-		id:        ulid.Make().String(),
+		id:        id,
 		cfg:       cfg,
 		client:    client,
 		profile:   profile,
@@ -144,6 +226,29 @@ func NewSession(client *llm.Client, profile ProviderProfile, env ExecutionEnviro
 	if err := registerCoreTools(reg, s); err != nil {
 		return nil, err
 	}
+	if len(cfg.LanguageServers) > 0 {
+		m, err := newLSPManager(env.WorkingDirectory(), cfg.LanguageServers)
+		if err != nil {
+			return nil, err
+		}
+		if err := registerLSPTools(reg, m); err != nil {
+			return nil, err
+		}
+		s.lsp = m
+	}
+	if s.fileJournal() != nil {
+		if err := registerJournalTools(reg); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range cfg.ExtraTools {
+		if _, dup := reg.tools[t.Definition.Name]; dup {
+			return nil, fmt.Errorf("tool %s is already registered", t.Definition.Name)
+		}
+		if err := reg.Register(t); err != nil {
+			return nil, err
+		}
+	}
 	// Allow SessionConfig to override default tool output limits (spec).
 	if len(cfg.ToolOutputLimits) > 0 {
 		reg.mu.Lock()
@@ -168,9 +273,32 @@ func NewSession(client *llm.Client, profile ProviderProfile, env ExecutionEnviro
 	}
 	s.reg = reg
 
+	if st := cfg.Resume; st != nil {
+		if st.SessionID != "" {
+			s.id = st.SessionID
+		}
+		s.history = append([]Turn{}, st.History...)
+		s.steeringQueue = append([]string(nil), st.Steering...)
+		s.followups = append([]string(nil), st.FollowUps...)
+		s.turns = st.Turns
+	}
+	if strings.TrimSpace(cfg.StatePath) != "" {
+		j, err := openSessionJournal(cfg.StatePath)
+		if err != nil {
+			return nil, err
+		}
+		s.journal = j
+		s.journalWrite(sessionJournalRecord{Op: "start", SessionID: s.id, Profile: profile.ID(), Model: profile.Model()})
+		if len(s.history) > 0 {
+			s.journalWrite(sessionJournalRecord{Op: "reset", Turns: s.history})
+		}
+		s.checkpoint()
+	}
+
 	s.emit(EventSessionStart, map[string]any{
 		"profile": profile.ID(),
 		"model":   profile.Model(),
+		"resumed": cfg.Resume != nil,
 	})
 	return s, nil
 }
@@ -188,6 +316,21 @@ func (s *Session) SetReasoningEffort(effort string) {
 	s.cfg.ReasoningEffort = strings.TrimSpace(effort)
 }
 
+// SetProfile switches the provider profile (model, system prompt and tool
+// set) used for future LLM calls, keeping the history. Call it between
+// inputs, not while ProcessInput runs.
+func (s *Session) SetProfile(p ProviderProfile) {
+	if p == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.profile = p
+}
+
 // Steer queues a message to inject after the current tool round completes.
 func (s *Session) Steer(msg string) {
 	s.mu.Lock()
@@ -199,6 +342,7 @@ func (s *Session) Steer(msg string) {
 		return
 	}
 	s.steeringQueue = append(s.steeringQueue, msg)
+	s.journalQueues()
 }
 
 // FollowUp queues a message to process after the current input completes.
@@ -212,6 +356,7 @@ func (s *Session) FollowUp(msg string) {
 		return
 	}
 	s.followups = append(s.followups, msg)
+	s.journalQueues()
 }
 
 func (s *Session) Close() {
@@ -223,6 +368,9 @@ func (s *Session) Close() {
 	s.closed = true
 	s.mu.Unlock()
 
+	s.closeSubagents()
+	s.lsp.close()
+	s.journal.close()
 	s.emit(EventSessionEnd, map[string]any{})
 	close(s.events)
 }
@@ -231,7 +379,14 @@ func (s *Session) ProcessInput(ctx context.Context, input string) (string, error
 	outputs := []string{}
 	next := input
 	for {
-		out, err := s.processOneInput(ctx, next)
+		ictx, span := tracing.Start(ctx, "agent.input",
+			tracing.String("agent.session_id", s.id),
+			tracing.String("gen_ai.system", s.profile.ID()),
+			tracing.String("gen_ai.request.model", s.profile.Model()),
+		)
+		out, err := s.processOneInput(ictx, next)
+		span.RecordError(err)
+		span.End()
 		if strings.TrimSpace(out) != "" {
 			outputs = append(outputs, out)
 		}
@@ -258,6 +413,39 @@ func (s *Session) execTool(ctx context.Context, call llm.ToolCallData) ToolExecR
 		"arguments_json": string(argsJSON),
 	})
 
+	if d := s.cfg.ToolPolicy.Evaluate(call.Name, argsJSON, s.env.WorkingDirectory()); !d.Allowed {
+		out, _ := json.Marshal(map[string]any{
+			"error":  "tool_call_denied",
+			"tool":   call.Name,
+			"rule":   d.Rule,
+			"reason": d.Reason,
+		})
+		res := ToolExecResult{
+			ToolName:   call.Name,
+			CallID:     call.ID,
+			Output:     string(out),
+			FullOutput: string(out),
+			IsError:    true,
+		}
+		s.emit(EventToolCallDenied, map[string]any{
+			"tool_name":      call.Name,
+			"call_id":        call.ID,
+			"arguments_json": string(argsJSON),
+			"rule":           d.Rule,
+			"reason":         d.Reason,
+		})
+		s.emit(EventToolCallEnd, map[string]any{
+			"tool_name":   res.ToolName,
+			"call_id":     res.CallID,
+			"is_error":    res.IsError,
+			"full_output": res.FullOutput,
+			"denied":      true,
+		})
+		return res
+	}
+	wrote := false
+	defer func() { s.cfg.ToolPolicy.settleWrite(call.Name, wrote) }()
+
 	// Spec §9.7: ToolCallFilter allows pre-hooks to veto tool calls.
 	if s.cfg.ToolCallFilter != nil {
 		if skipReason := s.cfg.ToolCallFilter(call.Name, call.ID, string(argsJSON)); skipReason != "" {
@@ -280,7 +468,14 @@ func (s *Session) execTool(ctx context.Context, call llm.ToolCallData) ToolExecR
 	}
 
 	// Session-level tools (subagents) are registered in the registry with closures.
-	res := s.reg.ExecuteCall(ctx, s.env, call)
+	// Environments that journal file changes get a per-call view so each change
+	// is attributed to this call.
+	env := s.env
+	if tc, ok := env.(toolCallEnvironment); ok {
+		env = tc.forToolCall(call.Name, call.ID)
+	}
+	res := s.reg.ExecuteCall(ctx, env, call)
+	wrote = !res.IsError
 
 	// Emit output deltas (best-effort). Even for non-streaming tools, this gives consumers a uniform
 	// incremental event pattern that mirrors provider LLM streaming.
@@ -298,22 +493,52 @@ func (s *Session) execTool(ctx context.Context, call llm.ToolCallData) ToolExecR
 		})
 	}
 
-	s.emit(EventToolCallEnd, map[string]any{
+	end := map[string]any{
 		"tool_name":   res.ToolName,
 		"call_id":     res.CallID,
 		"is_error":    res.IsError,
 		"full_output": res.FullOutput,
-	})
+	}
+	if changes := s.fileJournal().ChangesForCall(call.ID); len(changes) > 0 {
+		end["file_changes"] = changes
+	}
+	s.emit(EventToolCallEnd, end)
 	return res
 }
 
+// fileJournal returns the environment's file-change journal, if it keeps one.
+func (s *Session) fileJournal() *FileJournal {
+	if je, ok := s.env.(journaledEnvironment); ok {
+		return je.FileJournal()
+	}
+	return nil
+}
+
+// offersJournalTools reports whether undo_last_edit and revert_file are
+// offered: the environment journals file changes and the profile edits files.
+func (s *Session) offersJournalTools() bool {
+	if s.fileJournal() == nil || s.profile == nil {
+		return false
+	}
+	for _, d := range s.profile.ToolDefinitions() {
+		switch d.Name {
+		case "write_file", "edit_file", "apply_patch":
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Session) appendTurn(kind TurnKind, m llm.Message) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.history = append(s.history, Turn{Kind: kind, Message: m})
+	t := Turn{Kind: kind, Message: m}
+	s.history = append(s.history, t)
+	// Journal under the lock so records keep history order.
+	s.journalTurn(t)
 }
 
-func (s *Session) maybeWarnContextUsage(msgs []llm.Message) bool {
+func (s *Session) maybeWarnContextUsage(tokens int) bool {
 	if s == nil || s.profile == nil {
 		return false
 	}
@@ -322,11 +547,7 @@ func (s *Session) maybeWarnContextUsage(msgs []llm.Message) bool {
 		return false
 	}
 
-	totalChars := 0
-	for _, m := range msgs {
-		totalChars += messageCharCount(m)
-	}
-	approxTokens := float64(totalChars) / 4.0
+	approxTokens := float64(tokens)
 	threshold := float64(cw) * 0.8
 	if approxTokens <= threshold {
 		return false
@@ -436,58 +657,69 @@ func (s *Session) processOneInput(ctx context.Context, input string) (string, er
 	loopWarned := false
 	ctxWarned := false
 
+	var turnSpan *tracing.Span
+	defer func() { turnSpan.End() }()
 	for round := 0; round < s.cfg.MaxToolRoundsPerInput; round++ {
+		turnSpan.End()
+		var turnCtx context.Context
+		turnCtx, turnSpan = tracing.Start(ctx, "agent.turn", tracing.Int("agent.round", round))
 		select {
 		case <-ctx.Done():
 			s.emit(EventError, map[string]any{"error": ctx.Err().Error()})
 			return "", ctx.Err()
 		default:
 		}
+		s.checkpoint()
 		s.mu.Lock()
 		s.turns++
 		turns := s.turns
 		historyTurns := append([]Turn{}, s.history...)
 		s.mu.Unlock()
 
-		history := make([]llm.Message, 0, len(historyTurns))
-		for _, t := range historyTurns {
-			if t.Kind == TurnSteering {
-				history = append(history, llm.User(t.Message.Text()))
-				continue
-			}
-			history = append(history, t.Message)
-		}
-
 		if s.cfg.MaxTurns > 0 && turns > s.cfg.MaxTurns {
 			s.emit(EventTurnLimit, map[string]any{"max_turns": s.cfg.MaxTurns})
 			return "", fmt.Errorf("%w (max_turns=%d)", ErrTurnLimit, s.cfg.MaxTurns)
 		}
 
-		req := llm.Request{
-			Model:    s.profile.Model(),
-			Provider: s.profile.ID(),
-			Messages: append([]llm.Message{llm.System(sys)}, history...),
-			Tools:    s.profile.ToolDefinitions(),
-		}
-		if strings.TrimSpace(s.cfg.ReasoningEffort) != "" {
-			v := strings.TrimSpace(s.cfg.ReasoningEffort)
-			req.ReasoningEffort = &v
-		}
-		if len(s.cfg.ProviderOptions) > 0 {
-			req.ProviderOptions = s.cfg.ProviderOptions
+		build := func(ts []Turn) llm.Request { return s.buildRequest(sys, ts) }
+		req := build(historyTurns)
+
+		// Context budget: compact before the provider rejects the request.
+		tokens := s.countRequestTokens(turnCtx, req)
+		if budget := s.contextBudget(); budget > 0 && tokens > budget {
+			target := int(float64(s.profile.ContextWindowSize()) * compactionTargetFraction)
+			if after, changed := s.compactHistoryToFit(turnCtx, build, tokens, target); changed {
+				req = build(s.historySnapshot())
+				tokens = after
+			}
 		}
 
 		policy := llm.DefaultRetryPolicy()
 		if s.cfg.LLMRetryPolicy != nil {
 			policy = *s.cfg.LLMRetryPolicy
 		}
-		resp, err := llm.Retry(ctx, policy, s.cfg.LLMSleep, nil, func() (llm.Response, error) {
-			return s.client.Complete(ctx, req)
-		})
+		complete := func() (llm.Response, error) {
+			return llm.Retry(turnCtx, policy, s.cfg.LLMSleep, nil, func() (llm.Response, error) {
+				return s.client.Complete(turnCtx, req)
+			})
+		}
+		resp, err := complete()
+		var cle *llm.ContextLengthError
+		if err != nil && errors.As(err, &cle) && s.contextBudget() > 0 {
+			// The estimate was off (or the window is smaller than the profile
+			// claims): compact harder and retry once instead of failing.
+			// Our count already passed the budget check, so it cannot be trusted:
+			// aim for the forced fraction of the window or half the count,
+			// whichever is smaller.
+			target := min(int(float64(s.profile.ContextWindowSize())*forcedCompactionTargetFraction), tokens/2)
+			if after, changed := s.compactHistoryToFit(turnCtx, build, tokens, target); changed {
+				req = build(s.historySnapshot())
+				tokens = after
+				resp, err = complete()
+			}
+		}
 		if err != nil {
 			s.emit(EventError, map[string]any{"error": err.Error()})
-			// Spec: context overflow should emit a warning (no automatic compaction).
-			var cle *llm.ContextLengthError
 			if errors.As(err, &cle) {
 				s.emit(EventWarning, map[string]any{"message": "Context length exceeded"})
 			}
@@ -501,7 +733,7 @@ func (s *Session) processOneInput(ctx context.Context, input string) (string, er
 
 		// Context window awareness: emit a warning when we exceed ~80% of the profile's context window.
 		if !ctxWarned {
-			if s.maybeWarnContextUsage(req.Messages) {
+			if s.maybeWarnContextUsage(tokens) {
 				ctxWarned = true
 			}
 		}
@@ -515,7 +747,9 @@ func (s *Session) processOneInput(ctx context.Context, input string) (string, er
 		s.emit(EventAssistantTextEnd, map[string]any{"text": txt})
 
 		calls := resp.ToolCalls()
+		turnSpan.SetAttributes(tracing.Int("agent.tool_calls", len(calls)))
 		if len(calls) == 0 {
+			s.checkpoint()
 			return txt, nil
 		}
 
@@ -545,13 +779,13 @@ func (s *Session) processOneInput(ctx context.Context, input string) (string, er
 				i := i
 				go func() {
 					defer wg.Done()
-					results[i] = s.execTool(ctx, calls[i])
+					results[i] = s.execTool(turnCtx, calls[i])
 				}()
 			}
 			wg.Wait()
 		} else {
 			for i := range calls {
-				results[i] = s.execTool(ctx, calls[i])
+				results[i] = s.execTool(turnCtx, calls[i])
 			}
 		}
 
@@ -592,6 +826,48 @@ func (s *Session) processOneInput(ctx context.Context, input string) (string, er
 	return "", fmt.Errorf("max tool rounds reached")
 }
 
+// buildRequest assembles the LLM request for the given history. Steering turns
+// become user-role messages.
+func (s *Session) buildRequest(sys string, turns []Turn) llm.Request {
+	history := make([]llm.Message, 0, len(turns))
+	for _, t := range turns {
+		if t.Kind == TurnSteering {
+			history = append(history, llm.User(t.Message.Text()))
+			continue
+		}
+		history = append(history, t.Message)
+	}
+	req := llm.Request{
+		Model:    s.profile.Model(),
+		Provider: s.profile.ID(),
+		Messages: append([]llm.Message{llm.System(sys)}, history...),
+		Tools:    s.profile.ToolDefinitions(),
+	}
+	if s.lsp != nil {
+		req.Tools = append(req.Tools, lspToolDefinitions()...)
+	}
+	if s.offersJournalTools() {
+		req.Tools = append(req.Tools, journalToolDefinitions()...)
+	}
+	for _, t := range s.cfg.ExtraTools {
+		req.Tools = append(req.Tools, t.Definition)
+	}
+	if strings.TrimSpace(s.cfg.ReasoningEffort) != "" {
+		v := strings.TrimSpace(s.cfg.ReasoningEffort)
+		req.ReasoningEffort = &v
+	}
+	if len(s.cfg.ProviderOptions) > 0 {
+		req.ProviderOptions = s.cfg.ProviderOptions
+	}
+	return req
+}
+
+func (s *Session) historySnapshot() []Turn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Turn{}, s.history...)
+}
+
 func (s *Session) drainSteering() []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -658,6 +934,30 @@ func argStr(args map[string]any, key string) string {
 	return fmt.Sprint(v)
 }
 
+// formatExecResult renders command output line-oriented so line truncation
+// works as intended for shell output. timeoutHint tells the model how to get
+// more time when the command timed out.
+func formatExecResult(res ExecResult, timeoutMS int, timeoutHint string) string {
+	var b strings.Builder
+	if strings.TrimSpace(res.Stdout) != "" {
+		b.WriteString(res.Stdout)
+		if !strings.HasSuffix(res.Stdout, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	if strings.TrimSpace(res.Stderr) != "" {
+		b.WriteString(res.Stderr)
+		if !strings.HasSuffix(res.Stderr, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	if res.TimedOut {
+		b.WriteString(fmt.Sprintf("[ERROR: Command timed out after %dms. Partial output is shown above.\n%s]\n", timeoutMS, timeoutHint))
+	}
+	b.WriteString(fmt.Sprintf("exit_code=%d duration_ms=%d timed_out=%t\n", res.ExitCode, res.DurationMS, res.TimedOut))
+	return b.String()
+}
+
 func registerCoreTools(reg *ToolRegistry, s *Session) error {
 	// read_file
 	if err := reg.Register(RegisteredTool{
@@ -774,26 +1074,7 @@ func registerCoreTools(reg *ToolRegistry, s *Session) error {
 				timeout = s.cfg.MaxCommandTimeoutMS
 			}
 			res, err := env.ExecCommand(ctx, cmd, timeout, "", nil)
-
-			// Return a line-oriented tool output so line truncation works as intended for shell output.
-			var b strings.Builder
-			if strings.TrimSpace(res.Stdout) != "" {
-				b.WriteString(res.Stdout)
-				if !strings.HasSuffix(res.Stdout, "\n") {
-					b.WriteString("\n")
-				}
-			}
-			if strings.TrimSpace(res.Stderr) != "" {
-				b.WriteString(res.Stderr)
-				if !strings.HasSuffix(res.Stderr, "\n") {
-					b.WriteString("\n")
-				}
-			}
-			if res.TimedOut {
-				b.WriteString(fmt.Sprintf("[ERROR: Command timed out after %dms. Partial output is shown above.\nYou can retry with a longer timeout by setting the timeout_ms parameter.]\n", timeout))
-			}
-			b.WriteString(fmt.Sprintf("exit_code=%d duration_ms=%d timed_out=%t\n", res.ExitCode, res.DurationMS, res.TimedOut))
-			return b.String(), err
+			return formatExecResult(res, timeout, "You can retry with a longer timeout by setting the timeout_ms parameter."), err
 		},
 	}); err != nil {
 		return err
@@ -852,13 +1133,20 @@ func registerCoreTools(reg *ToolRegistry, s *Session) error {
 		return err
 	}
 
-	// apply_patch (OpenAI-specific; best-effort implementation lives in this repo)
+	// apply_patch (OpenAI-specific; accepts v4a patches and unified diffs)
 	_ = reg.Register(RegisteredTool{
 		Definition: defApplyPatch(),
 		Exec: func(ctx context.Context, env ExecutionEnvironment, args map[string]any) (any, error) {
 			_ = ctx
 			patch := argStr(args, "patch")
-			return ApplyPatch(env.WorkingDirectory(), patch)
+			fuzz := s.cfg.PatchFuzz
+			if fuzz == 0 {
+				fuzz = DefaultPatchFuzz
+			}
+			if pe, ok := env.(patchEnvironment); ok {
+				return pe.ApplyPatch(patch, ApplyPatchOptions{Fuzz: fuzz})
+			}
+			return ApplyPatchWithOptions(env.WorkingDirectory(), patch, ApplyPatchOptions{Fuzz: fuzz})
 		},
 	})
 
@@ -868,7 +1156,11 @@ func registerCoreTools(reg *ToolRegistry, s *Session) error {
 		Exec: func(ctx context.Context, env ExecutionEnvironment, args map[string]any) (any, error) {
 			_ = env
 			task := argStr(args, "task")
-			return s.spawnAgent(ctx, task)
+			return s.spawnAgent(ctx, task, spawnOptions{
+				Provider:  strings.TrimSpace(argStr(args, "provider")),
+				Model:     strings.TrimSpace(argStr(args, "model")),
+				Isolation: strings.TrimSpace(argStr(args, "isolation")),
+			})
 		},
 	})
 	_ = reg.Register(RegisteredTool{
@@ -896,6 +1188,13 @@ func registerCoreTools(reg *ToolRegistry, s *Session) error {
 			return s.closeAgent(argStr(args, "agent_id"))
 		},
 	})
+	_ = reg.Register(RegisteredTool{
+		Definition: defMergeAgent(),
+		Exec: func(ctx context.Context, env ExecutionEnvironment, args map[string]any) (any, error) {
+			_ = env
+			return s.mergeAgent(ctx, argStr(args, "agent_id"))
+		},
+	})
 
 	return nil
 }
@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoSessionCheckpoint is returned by LoadSessionState when the journal
+// holds no complete checkpoint to resume from.
+var ErrNoSessionCheckpoint = errors.New("session journal has no checkpoint")
+
+// SessionState is a session as of its last checkpoint, rebuilt from the
+// journal written when SessionConfig.StatePath is set. Pass it as
+// SessionConfig.Resume to continue the conversation.
+type SessionState struct {
+	SessionID string
+	Profile   string
+	Model     string
+	History   []Turn
+	Steering  []string
+	FollowUps []string
+	// Subagents lists subagents that were open at the checkpoint. They are
+	// not restored.
+	Subagents []string
+	// Fingerprint is the SessionConfig.StateFingerprint value recorded with
+	// the checkpoint.
+	Fingerprint string
+	Turns       int
+	SavedAt     time.Time
+}
+
+// Journal records, one JSON object per line. "turn" appends to the history,
+// "reset" replaces it (after compaction or on resume), "queues" snapshots
+// queued steering/follow-ups, and "checkpoint" marks a point where the
+// history is consistent (no tool calls awaiting results).
+type sessionJournalRecord struct {
+	Op          string    `json:"op"`
+	Timestamp   time.Time `json:"ts"`
+	SessionID   string    `json:"session_id,omitempty"`
+	Profile     string    `json:"profile,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Turn        *Turn     `json:"turn,omitempty"`
+	Turns       []Turn    `json:"turns,omitempty"`
+	Steering    []string  `json:"steering,omitempty"`
+	FollowUps   []string  `json:"follow_ups,omitempty"`
+	Subagents   []string  `json:"subagents,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	HistoryLen  int       `json:"history_len,omitempty"`
+	TurnCount   int       `json:"turn_count,omitempty"`
+}
+
+type sessionJournal struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error
+}
+
+func openSessionJournal(path string) (*sessionJournal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open session journal: %w", err)
+	}
+	return &sessionJournal{f: f}, nil
+}
+
+// write appends one record. Failures are sticky and reported once through
+// the session's warning event; the session keeps running without a journal.
+func (j *sessionJournal) write(rec sessionJournalRecord) error {
+	if j == nil {
+		return nil
+	}
+	rec.Timestamp = time.Now().UTC()
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil || j.f == nil {
+		return nil
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		j.err = err
+		return err
+	}
+	return nil
+}
+
+func (j *sessionJournal) close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f != nil {
+		_ = j.f.Close()
+		j.f = nil
+	}
+}
+
+// LoadSessionState replays a session journal up to its last checkpoint. A
+// torn final line (the process died mid-write) is ignored.
+func LoadSessionState(path string) (*SessionState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var (
+		cur      SessionState
+		history  []Turn
+		steering []string
+		follow   []string
+		last     *SessionState
+	)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 1<<20), 256<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var rec sessionJournalRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			break
+		}
+		switch rec.Op {
+		case "start":
+			cur.SessionID, cur.Profile, cur.Model = rec.SessionID, rec.Profile, rec.Model
+		case "turn":
+			if rec.Turn != nil {
+				history = append(history, *rec.Turn)
+			}
+		case "reset":
+			history = append([]Turn{}, rec.Turns...)
+		case "queues":
+			steering, follow = rec.Steering, rec.FollowUps
+		case "checkpoint":
+			steering, follow = rec.Steering, rec.FollowUps
+			st := cur
+			n := min(rec.HistoryLen, len(history))
+			st.History = append([]Turn{}, history[:n]...)
+			st.Subagents = append([]string{}, rec.Subagents...)
+			st.Fingerprint = rec.Fingerprint
+			st.Turns = rec.TurnCount
+			st.SavedAt = rec.Timestamp
+			last = &st
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, ErrNoSessionCheckpoint
+	}
+	last.Steering = append([]string{}, steering...)
+	last.FollowUps = append([]string{}, follow...)
+	return last, nil
+}
+
+// InterruptedPrompt is the input to send a resumed session.
+func (st *SessionState) InterruptedPrompt() string {
+	var b strings.Builder
+	b.WriteString("You were interrupted, and this session has been restored from its last checkpoint. ")
+	b.WriteString("The working directory is as it was at that checkpoint; anything you were doing after it did not happen. ")
+	b.WriteString("Continue the task from where you left off without redoing completed work.")
+	if st != nil && len(st.Subagents) > 0 {
+		fmt.Fprintf(&b, " Subagents %s were lost in the interruption; spawn new ones if you still need them.", strings.Join(st.Subagents, ", "))
+	}
+	return b.String()
+}
+
+// journalTurn records an appended turn; callers hold s.mu.
+func (s *Session) journalTurn(t Turn) {
+	s.journalWrite(sessionJournalRecord{Op: "turn", Turn: &t})
+}
+
+// journalQueues records the queued steering and follow-ups; callers hold s.mu.
+func (s *Session) journalQueues() {
+	s.journalWrite(sessionJournalRecord{
+		Op:        "queues",
+		Steering:  s.steeringQueue,
+		FollowUps: s.followups,
+	})
+}
+
+// checkpoint records that the history is consistent and can be resumed from.
+func (s *Session) checkpoint() {
+	if s.journal == nil {
+		return
+	}
+	fp := ""
+	if s.cfg.StateFingerprint != nil {
+		v, err := s.cfg.StateFingerprint()
+		if err != nil {
+			s.emit(EventWarning, map[string]any{"message": fmt.Sprintf("session checkpoint fingerprint: %v", err)})
+			return
+		}
+		fp = v
+	}
+	s.mu.Lock()
+	rec := sessionJournalRecord{
+		Op:          "checkpoint",
+		Steering:    append([]string{}, s.steeringQueue...),
+		FollowUps:   append([]string{}, s.followups...),
+		Fingerprint: fp,
+		HistoryLen:  len(s.history),
+		TurnCount:   s.turns,
+	}
+	for id := range s.subagents {
+		rec.Subagents = append(rec.Subagents, id)
+	}
+	s.mu.Unlock()
+	sort.Strings(rec.Subagents)
+	s.journalWrite(rec)
+}
+
+func (s *Session) journalWrite(rec sessionJournalRecord) {
+	if err := s.journal.write(rec); err != nil {
+		s.emit(EventWarning, map[string]any{"message": fmt.Sprintf("session journal disabled: %v", err)})
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestSession_StatePath_JournalsAndResumes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "session.ndjson")
+
+	tc := llm.ToolCallData{ID: "1", Name: "write_file", Arguments: json.RawMessage(`{"file_path":"a.txt","content":"one"}`)}
+	f := &fakeAdapter{name: "openai", steps: []func(llm.Request) llm.Response{
+		func(llm.Request) llm.Response {
+			return llm.Response{Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &tc}}}}
+		},
+	}}
+	c := llm.NewClient()
+	c.Register(f)
+	fp := "tree-1"
+	cfg := SessionConfig{StatePath: statePath, StateFingerprint: func() (string, error) { return fp, nil }}
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(dir), cfg)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	sess.FollowUp("then summarize")
+	// Run one input without draining follow-ups, as if the process died there.
+	if _, err := sess.processOneInput(ctx, "write a.txt"); err != nil {
+		t.Fatalf("processOneInput: %v", err)
+	}
+	sess.Close()
+
+	st, err := LoadSessionState(statePath)
+	if err != nil {
+		t.Fatalf("LoadSessionState: %v", err)
+	}
+	if st.SessionID != sess.id || st.Fingerprint != "tree-1" || st.Model != "gpt-5.2" {
+		t.Fatalf("state: %+v", st)
+	}
+	var kinds []string
+	for _, turn := range st.History {
+		kinds = append(kinds, string(turn.Kind))
+	}
+	if got := strings.Join(kinds, ","); got != "USER_INPUT,ASSISTANT,TOOL,ASSISTANT" {
+		t.Fatalf("history kinds: %s", got)
+	}
+	if len(st.FollowUps) != 1 || st.FollowUps[0] != "then summarize" {
+		t.Fatalf("follow-ups: %v", st.FollowUps)
+	}
+
+	f2 := &fakeAdapter{name: "openai"}
+	c2 := llm.NewClient()
+	c2.Register(f2)
+	resumed, err := NewSession(c2, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(dir), SessionConfig{StatePath: statePath, Resume: st})
+	if err != nil {
+		t.Fatalf("NewSession(resume): %v", err)
+	}
+	if _, err := resumed.ProcessInput(ctx, st.InterruptedPrompt()); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	resumed.Close()
+	reqs := f2.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected interrupted prompt then follow-up, got %d requests", len(reqs))
+	}
+	msgs := reqs[0].Messages
+	if len(msgs) != 6 || msgs[1].Text() != "write a.txt" || !strings.Contains(msgs[5].Text(), "You were interrupted") {
+		t.Fatalf("resumed request messages: %+v", msgs)
+	}
+	if last := reqs[1].Messages[len(reqs[1].Messages)-1]; last.Text() != "then summarize" {
+		t.Fatalf("follow-up not restored: %+v", last)
+	}
+
+	// The rewritten journal resumes to the resumed session's state.
+	st2, err := LoadSessionState(statePath)
+	if err != nil || st2.SessionID != st.SessionID || len(st2.History) != 8 || len(st2.FollowUps) != 0 {
+		t.Fatalf("journal after resume: %+v %v", st2, err)
+	}
+}
+
+func TestLoadSessionState_StopsAtLastCheckpointAndTornLine(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "session.ndjson")
+	lines := []string{
+		`{"op":"start","session_id":"s1","model":"m"}`,
+		`{"op":"turn","turn":{"kind":"USER_INPUT","message":{"role":"user","content":[{"kind":"text","text":"hi"}]}}}`,
+		`{"op":"checkpoint","history_len":1,"fingerprint":"a","turn_count":0}`,
+		`{"op":"turn","turn":{"kind":"ASSISTANT","message":{"role":"assistant","content":[{"kind":"tool_call","tool_call":{"id":"1","name":"shell","arguments":{}}}]}}}`,
+		`{"op":"queues","steering":["look at tests"]}`,
+		`{"op":"turn","turn":{"kind":"TO`,
+	}
+	if err := os.WriteFile(p, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := LoadSessionState(p)
+	if err != nil {
+		t.Fatalf("LoadSessionState: %v", err)
+	}
+	if len(st.History) != 1 || st.Fingerprint != "a" || st.SessionID != "s1" {
+		t.Fatalf("state: %+v", st)
+	}
+	if len(st.Steering) != 1 || st.Steering[0] != "look at tests" {
+		t.Fatalf("steering queued after the checkpoint was lost: %v", st.Steering)
+	}
+
+	if err := os.WriteFile(p, []byte(lines[0]+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSessionState(p); !errors.Is(err, ErrNoSessionCheckpoint) {
+		t.Fatalf("expected ErrNoSessionCheckpoint, got %v", err)
+	}
+}
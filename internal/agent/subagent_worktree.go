@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/danshapiro/kilroy/internal/attractor/gitutil"
+)
+
+// Subagent isolation modes (SessionConfig.SubagentIsolation and the
+// spawn_agent "isolation" argument).
+const (
+	SubagentIsolationShared   = "shared"
+	SubagentIsolationWorktree = "worktree"
+)
+
+// rerootableEnvironment is implemented by environments that can run the same
+// way in another directory; worktree-isolated subagents need it.
+type rerootableEnvironment interface {
+	WithWorkingDirectory(dir string) ExecutionEnvironment
+}
+
+// WithWorkingDirectory returns a copy of e rooted at dir.
+func (e *LocalExecutionEnvironment) WithWorkingDirectory(dir string) ExecutionEnvironment {
+	return NewLocalExecutionEnvironmentWithPolicy(dir, e.BaseEnv, e.StripEnvKeys)
+}
+
+// subagentWorktree is a git worktree a subagent works in. base is the commit
+// its changes are measured against; it advances after each merge so the next
+// merge only brings newer changes.
+type subagentWorktree struct {
+	parentDir string // parent's working directory
+	dir       string // worktree root
+	workDir   string // subagent's working directory inside it
+	base      string
+}
+
+func gitIn(ctx context.Context, dir string, env []string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir, "-c", "maintenance.auto=0", "-c", "gc.auto=0"}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// newSubagentWorktree creates a detached worktree holding parentDir's current
+// state, uncommitted changes included.
+func newSubagentWorktree(ctx context.Context, parentDir, root, id string) (*subagentWorktree, error) {
+	prefix, err := gitIn(ctx, parentDir, nil, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("worktree isolation requires a git repository: %w", err)
+	}
+	head, _ := gitIn(ctx, parentDir, nil, nil, "rev-parse", "--verify", "-q", "HEAD")
+	base, err := gitutil.WorktreeSnapshotCommit(parentDir, strings.TrimSpace(head), "kilroy subagent "+id+" base")
+	if err != nil {
+		return nil, err
+	}
+	if root != "" {
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := os.MkdirTemp(root, "kilroy-subagent-"+id+"-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gitIn(ctx, parentDir, nil, nil, "worktree", "add", "--detach", dir, base); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return &subagentWorktree{
+		parentDir: parentDir,
+		dir:       dir,
+		workDir:   filepath.Join(dir, filepath.FromSlash(strings.TrimSpace(prefix))),
+		base:      base,
+	}, nil
+}
+
+// subagentMergeResult is returned to the model by merge_agent.
+type subagentMergeResult struct {
+	AgentID   string   `json:"agent_id"`
+	Status    string   `json:"status"` // merged | conflicts | no_changes
+	Files     []string `json:"files,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// merge three-way merges the subagent's changes since base into the parent's
+// working tree. Conflicting files are written with conflict markers. Only the
+// working tree is modified; the parent's index and HEAD are left alone.
+func (w *subagentWorktree) merge(ctx context.Context, agentID string) (subagentMergeResult, error) {
+	res := subagentMergeResult{AgentID: agentID}
+	if err := checkMergeTreeSupport(); err != nil {
+		return res, err
+	}
+	theirs, err := gitutil.WorktreeSnapshotCommit(w.dir, w.base, "kilroy subagent "+agentID)
+	if err != nil {
+		return res, err
+	}
+	changed, err := gitIn(ctx, w.parentDir, nil, nil, "diff", "--name-only", w.base, theirs)
+	if err != nil {
+		return res, err
+	}
+	if strings.TrimSpace(changed) == "" {
+		res.Status = "no_changes"
+		return res, nil
+	}
+	ours, err := gitutil.WorktreeSnapshotCommit(w.parentDir, w.base, "kilroy subagent "+agentID+" parent")
+	if err != nil {
+		return res, err
+	}
+	// Both snapshots descend from base, so merge-tree uses it as the merge base.
+	out, mergeErr := gitIn(ctx, w.parentDir, nil, nil, "merge-tree", "--write-tree", "--name-only", "--no-messages", ours, theirs)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) == "" {
+		return res, fmt.Errorf("merge_agent: %v", mergeErr)
+	}
+	merged := strings.TrimSpace(lines[0])
+	seen := map[string]bool{}
+	for _, l := range lines[1:] {
+		if l = strings.TrimSpace(l); l != "" && !seen[l] {
+			seen[l] = true
+			res.Conflicts = append(res.Conflicts, l)
+		}
+	}
+	if mergeErr != nil && len(res.Conflicts) == 0 {
+		return res, mergeErr
+	}
+
+	top, err := gitIn(ctx, w.parentDir, nil, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return res, err
+	}
+	top = strings.TrimSpace(top)
+	patch, err := gitIn(ctx, top, nil, nil, "diff", "--binary", "--no-renames", ours, merged)
+	if err != nil {
+		return res, err
+	}
+	if strings.TrimSpace(patch) != "" {
+		if _, err := gitIn(ctx, top, nil, strings.NewReader(patch), "apply", "--whitespace=nowarn"); err != nil {
+			return res, err
+		}
+	}
+	files, err := gitIn(ctx, top, nil, nil, "diff", "--name-only", "--no-renames", ours, merged)
+	if err != nil {
+		return res, err
+	}
+	for _, f := range strings.Split(strings.TrimSpace(files), "\n") {
+		if f = strings.TrimSpace(f); f != "" {
+			res.Files = append(res.Files, f)
+		}
+	}
+	w.base = theirs
+	res.Status = "merged"
+	if len(res.Conflicts) > 0 {
+		res.Status = "conflicts"
+	}
+	return res, nil
+}
+
+// mergeTreeMinMinor is the git 2.x release that added merge-tree --write-tree.
+const mergeTreeMinMinor = 38
+
+var mergeTreeSupport struct {
+	once sync.Once
+	err  error
+}
+
+// checkMergeTreeSupport reports, once per process, whether the git on PATH
+// can run the merge-tree --write-tree that merge uses.
+func checkMergeTreeSupport() error {
+	mergeTreeSupport.once.Do(func() {
+		major, minor, err := gitutil.Version()
+		if err != nil {
+			mergeTreeSupport.err = fmt.Errorf("merge_agent: %w", err)
+			return
+		}
+		if major < 2 || (major == 2 && minor < mergeTreeMinMinor) {
+			mergeTreeSupport.err = fmt.Errorf("merge_agent requires git 2.%d or newer (found %d.%d)", mergeTreeMinMinor, major, minor)
+		}
+	})
+	return mergeTreeSupport.err
+}
+
+// remove deletes the worktree; unmerged changes are discarded.
+func (w *subagentWorktree) remove() {
+	_, _ = gitIn(context.Background(), w.parentDir, nil, nil, "worktree", "remove", "--force", w.dir)
+	_ = os.RemoveAll(w.dir)
+}
@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+// writerAdapter answers "write <file> <content>" with a write_file call and
+// anything else with "done", so concurrent subagents get deterministic replies.
+type writerAdapter struct {
+	mu     sync.Mutex
+	models []string
+}
+
+func (a *writerAdapter) Name() string { return "openai" }
+
+func (a *writerAdapter) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	a.mu.Lock()
+	a.models = append(a.models, req.Model)
+	a.mu.Unlock()
+	last := req.Messages[len(req.Messages)-1]
+	if f := strings.Fields(last.Text()); last.Role == llm.RoleUser && len(f) == 3 && f[0] == "write" {
+		args, _ := json.Marshal(map[string]string{"file_path": f[1], "content": f[2] + "\n"})
+		tc := llm.ToolCallData{ID: "w", Name: "write_file", Arguments: args}
+		return llm.Response{Provider: "openai", Model: req.Model, Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &tc}}}}, nil
+	}
+	return llm.Response{Provider: "openai", Model: req.Model, Message: llm.Assistant("done")}, nil
+}
+
+func (a *writerAdapter) Stream(context.Context, llm.Request) (llm.Stream, error) {
+	return nil, errors.New("not implemented")
+}
+
+func gitTestRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"config", "user.name", "t"},
+		{"config", "user.email", "t@t"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("base\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", "init"}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestSession_WorktreeSubagents_RunIsolatedAndMergeBack(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	repo := gitTestRepo(t)
+	// Uncommitted parent work is part of the state subagents start from.
+	if err := os.WriteFile(filepath.Join(repo, "wip.txt"), []byte("parent\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	adapter := &writerAdapter{}
+	c := llm.NewClient()
+	c.Register(adapter)
+	wtRoot := t.TempDir()
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(repo), SessionConfig{
+		SubagentIsolation:    SubagentIsolationWorktree,
+		SubagentWorktreeRoot: wtRoot,
+	})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer sess.Close()
+
+	spawn := func(task string, opts spawnOptions) (string, string) {
+		t.Helper()
+		out, err := sess.spawnAgent(ctx, task, opts)
+		if err != nil {
+			t.Fatalf("spawn %q: %v", task, err)
+		}
+		var r map[string]string
+		_ = json.Unmarshal([]byte(out.(string)), &r)
+		return r["agent_id"], r["worktree"]
+	}
+	idOne, wtOne := spawn("write one.txt 1", spawnOptions{Model: "gpt-mini"})
+	idTwo, _ := spawn("write two.txt 2", spawnOptions{})
+	idA, _ := spawn("write a.txt theirs", spawnOptions{})
+	for _, id := range []string{idOne, idTwo, idA} {
+		if _, err := sess.waitAgent(ctx, id, 0); err != nil {
+			t.Fatalf("wait %s: %v", id, err)
+		}
+	}
+	if !strings.Contains(wtOne, "kilroy-subagent-"+idOne+"-") {
+		t.Fatalf("worktree %s is not named after subagent %s", wtOne, idOne)
+	}
+	if b, _ := os.ReadFile(filepath.Join(wtOne, "wip.txt")); string(b) != "parent\n" {
+		t.Fatalf("subagent worktree lacks the parent's uncommitted work: %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "one.txt")); err == nil {
+		t.Fatalf("subagent wrote into the parent's directory")
+	}
+
+	merge := func(id string) subagentMergeResult {
+		t.Helper()
+		out, err := sess.mergeAgent(ctx, id)
+		if err != nil {
+			t.Fatalf("merge %s: %v", id, err)
+		}
+		var r subagentMergeResult
+		_ = json.Unmarshal([]byte(out.(string)), &r)
+		return r
+	}
+	if r := merge(idOne); r.Status != "merged" || strings.Join(r.Files, ",") != "one.txt" {
+		t.Fatalf("merge one: %+v", r)
+	}
+	if r := merge(idOne); r.Status != "no_changes" {
+		t.Fatalf("second merge should bring nothing: %+v", r)
+	}
+	if r := merge(idTwo); r.Status != "merged" {
+		t.Fatalf("merge two: %+v", r)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "a.txt"), []byte("ours\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if r := merge(idA); r.Status != "conflicts" || strings.Join(r.Conflicts, ",") != "a.txt" {
+		t.Fatalf("merge a: %+v", r)
+	}
+	for name, want := range map[string]string{"one.txt": "1\n", "two.txt": "2\n", "wip.txt": "parent\n"} {
+		if b, _ := os.ReadFile(filepath.Join(repo, name)); string(b) != want {
+			t.Fatalf("%s = %q, want %q", name, b, want)
+		}
+	}
+	if b, _ := os.ReadFile(filepath.Join(repo, "a.txt")); !strings.Contains(string(b), "<<<<<<<") || !strings.Contains(string(b), "theirs") {
+		t.Fatalf("a.txt lacks conflict markers: %q", b)
+	}
+	if out, _ := exec.Command("git", "-C", repo, "diff", "--cached", "--name-only").Output(); len(out) != 0 {
+		t.Fatalf("merge staged files in the parent: %s", out)
+	}
+
+	adapter.mu.Lock()
+	models := strings.Join(adapter.models, ",")
+	adapter.mu.Unlock()
+	if !strings.Contains(models, "gpt-mini") {
+		t.Fatalf("subagent model not used: %s", models)
+	}
+
+	sess.Close()
+	if _, err := os.Stat(wtOne); !os.IsNotExist(err) {
+		t.Fatalf("worktree not removed on close: %v", err)
+	}
+}
+
+func TestSession_SpawnAgent_RejectsUnknownIsolation(t *testing.T) {
+	c := llm.NewClient()
+	c.Register(&writerAdapter{})
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(t.TempDir()), SessionConfig{})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer sess.Close()
+	if _, err := sess.spawnAgent(context.Background(), "x", spawnOptions{Isolation: "container"}); err == nil {
+		t.Fatalf("expected isolation error")
+	}
+	if _, err := sess.spawnAgent(context.Background(), "x", spawnOptions{Isolation: SubagentIsolationWorktree}); err == nil || !strings.Contains(err.Error(), "git repository") {
+		t.Fatalf("expected git repository error, got %v", err)
+	}
+}
+
+func TestCheckMergeTreeSupport_RejectsOldGit(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "git"), []byte("#!/bin/sh\necho 'git version 2.34.1'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	mergeTreeSupport.once = sync.Once{}
+	mergeTreeSupport.err = nil
+	t.Cleanup(func() {
+		mergeTreeSupport.once = sync.Once{}
+		mergeTreeSupport.err = nil
+	})
+	err := checkMergeTreeSupport()
+	if err == nil || !strings.Contains(err.Error(), "requires git 2.38 or newer (found 2.34)") {
+		t.Fatalf("expected git version error, got %v", err)
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/oklog/ulid/v2"
 )
 
 type subagent struct {
-	id   string
-	sess *Session
+	id       string
+	sess     *Session
+	worktree *subagentWorktree // nil when sharing the parent's environment
 
 	mu      sync.Mutex
 	running bool
@@ -19,7 +22,14 @@ type subagent struct {
 	err     error
 }
 
-func (s *Session) spawnAgent(ctx context.Context, task string) (any, error) {
+// spawnOptions are the optional spawn_agent arguments.
+type spawnOptions struct {
+	Provider  string
+	Model     string
+	Isolation string
+}
+
+func (s *Session) spawnAgent(ctx context.Context, task string, opts spawnOptions) (any, error) {
 	s.mu.Lock()
 	depth := s.depth
 	maxDepth := s.cfg.MaxSubagentDepth
@@ -29,16 +39,54 @@ func (s *Session) spawnAgent(ctx context.Context, task string) (any, error) {
 	}
 
 	subProfile := s.profile
-	subSess, err := NewSession(s.client, subProfile, s.env, s.cfg)
+	if opts.Provider != "" || (opts.Model != "" && opts.Model != s.profile.Model()) {
+		p, err := s.subagentProfile(opts.Provider, opts.Model)
+		if err != nil {
+			return "", err
+		}
+		subProfile = p
+	}
+	isolation := opts.Isolation
+	if isolation == "" {
+		isolation = s.cfg.SubagentIsolation
+	}
+	subID := ulid.Make().String()
+	subEnv := s.env
+	var wt *subagentWorktree
+	switch isolation {
+	case "", SubagentIsolationShared:
+	case SubagentIsolationWorktree:
+		re, ok := s.env.(rerootableEnvironment)
+		if !ok {
+			return "", fmt.Errorf("worktree isolation is not supported by this execution environment")
+		}
+		var err error
+		wt, err = newSubagentWorktree(ctx, s.env.WorkingDirectory(), s.cfg.SubagentWorktreeRoot, subID)
+		if err != nil {
+			return "", err
+		}
+		subEnv = re.WithWorkingDirectory(wt.workDir)
+	default:
+		return "", fmt.Errorf("invalid isolation %q (want shared|worktree)", isolation)
+	}
+
+	subCfg := s.cfg
+	subCfg.StatePath, subCfg.StateFingerprint, subCfg.Resume = "", nil, nil
+	subCfg.sessionID = subID
+	subSess, err := NewSession(s.client, subProfile, subEnv, subCfg)
 	if err != nil {
+		if wt != nil {
+			wt.remove()
+		}
 		return "", err
 	}
 	subSess.depth = depth + 1
 
 	sub := &subagent{
-		id:   subSess.id,
-		sess: subSess,
-		done: make(chan struct{}),
+		id:       subSess.id,
+		sess:     subSess,
+		worktree: wt,
+		done:     make(chan struct{}),
 	}
 
 	s.mu.Lock()
@@ -47,7 +95,50 @@ func (s *Session) spawnAgent(ctx context.Context, task string) (any, error) {
 
 	go sub.run(ctx, task)
 
-	b, _ := json.Marshal(map[string]any{"agent_id": sub.id})
+	out := map[string]any{"agent_id": sub.id, "model": subProfile.Model()}
+	if wt != nil {
+		out["worktree"] = wt.workDir
+	}
+	b, _ := json.Marshal(out)
+	return string(b), nil
+}
+
+// subagentProfile builds the profile for a subagent that asked for another
+// provider or model.
+func (s *Session) subagentProfile(provider, model string) (ProviderProfile, error) {
+	if model == "" {
+		model = s.profile.Model()
+	}
+	if s.cfg.SubagentProfile != nil {
+		return s.cfg.SubagentProfile(provider, model)
+	}
+	if provider == "" {
+		provider = s.profile.ID()
+	}
+	return NewProfileForFamily(provider, model)
+}
+
+// mergeAgent three-way merges a worktree-isolated subagent's changes into the
+// parent's working tree.
+func (s *Session) mergeAgent(ctx context.Context, agentID string) (any, error) {
+	sub := s.getSub(agentID)
+	if sub == nil {
+		return "", fmt.Errorf("unknown agent_id: %s", agentID)
+	}
+	if sub.worktree == nil {
+		return "", fmt.Errorf("agent %s shares your working directory; there is nothing to merge", agentID)
+	}
+	sub.mu.Lock()
+	running := sub.running
+	sub.mu.Unlock()
+	if running {
+		return "", fmt.Errorf("agent %s is still running; wait for it first", agentID)
+	}
+	res, err := sub.worktree.merge(ctx, agentID)
+	if err != nil {
+		return "", err
+	}
+	b, _ := json.Marshal(res)
 	return string(b), nil
 }
 
@@ -112,9 +203,30 @@ func (s *Session) closeAgent(agentID string) (any, error) {
 		return "", fmt.Errorf("unknown agent_id: %s", agentID)
 	}
 	sub.sess.Close()
+	if sub.worktree != nil {
+		// Unmerged changes are discarded with the worktree.
+		sub.worktree.remove()
+	}
 	return "closed", nil
 }
 
+// closeSubagents closes every open subagent and removes their worktrees.
+func (s *Session) closeSubagents() {
+	s.mu.Lock()
+	subs := make([]*subagent, 0, len(s.subagents))
+	for id, sub := range s.subagents {
+		subs = append(subs, sub)
+		delete(s.subagents, id)
+	}
+	s.mu.Unlock()
+	for _, sub := range subs {
+		sub.sess.Close()
+		if sub.worktree != nil {
+			sub.worktree.remove()
+		}
+	}
+}
+
 func (s *Session) getSub(agentID string) *subagent {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,584 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ToolPolicy is a declarative allow/deny policy checked before every tool
+// call. A call is denied when any deny rule matches it; otherwise it is
+// allowed when an allow rule matches, else Default applies. Denials are
+// returned to the model as a structured tool error and reported as
+// EventToolCallDenied.
+//
+// A ToolPolicy carries the write budget counter, so use one value per
+// session tree (subagents share their parent's).
+type ToolPolicy struct {
+	// Default is "allow" (when empty) or "deny".
+	Default string
+	Rules   []ToolPolicyRule
+	// MaxWrites caps successful write_file, edit_file and apply_patch calls;
+	// 0 means unlimited. Denied, vetoed and failed calls do not count.
+	MaxWrites int
+
+	mu     sync.Mutex
+	writes int
+	// pending counts writes allowed by Evaluate that have not settled yet,
+	// so parallel calls cannot overrun the budget.
+	pending int
+}
+
+// ToolPolicyRule matches a call when every criterion it sets matches.
+type ToolPolicyRule struct {
+	// Name labels the rule in denials.
+	Name string
+	// Effect is "allow" or "deny".
+	Effect string
+	// Tools are globs on the tool name (e.g. "shell", "mcp__*").
+	Tools []string
+	// Commands are argv patterns for shell calls. The command line is split
+	// into simple commands (;, &&, ||, |, subshells, $(...) and backticks,
+	// also inside double quotes), wrappers such as env/sudo/xargs, "bash -c"
+	// and find -exec are unwrapped, git global options (-C, -c, --git-dir,
+	// ...) are stripped, and each pattern token is a glob on one argument;
+	// "**" spans any number of arguments. A pattern matches a prefix of the
+	// argv: "git push" matches "git push origin main" and
+	// "git -c k=v push".
+	Commands []string
+	// Paths are doublestar globs, relative to the working directory, on the
+	// files a file tool touches (read_file, write_file, edit_file,
+	// apply_patch, list_dir, glob, grep).
+	Paths []string
+	// Access limits Paths to "read" or "write" tools; empty means both.
+	Access string
+	Reason string
+}
+
+// ToolPolicyDecision is the outcome of evaluating one call.
+type ToolPolicyDecision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Validate reports malformed rules.
+func (p *ToolPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Default {
+	case "", "allow", "deny":
+	default:
+		return fmt.Errorf("tool policy: invalid default %q (want allow|deny)", p.Default)
+	}
+	if p.MaxWrites < 0 {
+		return fmt.Errorf("tool policy: max_writes must be >= 0")
+	}
+	for i, r := range p.Rules {
+		label := r.Name
+		if label == "" {
+			label = fmt.Sprintf("rules[%d]", i)
+		}
+		if r.Effect != "allow" && r.Effect != "deny" {
+			return fmt.Errorf("tool policy %s: invalid effect %q (want allow|deny)", label, r.Effect)
+		}
+		if len(r.Tools) == 0 && len(r.Commands) == 0 && len(r.Paths) == 0 {
+			return fmt.Errorf("tool policy %s: set at least one of tools, commands, paths", label)
+		}
+		switch r.Access {
+		case "", "read", "write":
+		default:
+			return fmt.Errorf("tool policy %s: invalid access %q (want read|write)", label, r.Access)
+		}
+		for _, g := range r.Tools {
+			if _, err := path.Match(g, ""); err != nil {
+				return fmt.Errorf("tool policy %s: bad tool glob %q: %v", label, g, err)
+			}
+		}
+		for _, g := range r.Paths {
+			if !doublestar.ValidatePattern(g) {
+				return fmt.Errorf("tool policy %s: bad path glob %q", label, g)
+			}
+		}
+		for _, c := range r.Commands {
+			if len(splitShellCommands(c)) != 1 {
+				return fmt.Errorf("tool policy %s: command pattern %q must be a single command", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Evaluate decides whether a call may run. argsJSON is the call's argument
+// object; workingDir resolves relative paths.
+func (p *ToolPolicy) Evaluate(toolName string, argsJSON []byte, workingDir string) ToolPolicyDecision {
+	if p == nil {
+		return ToolPolicyDecision{Allowed: true}
+	}
+	var args map[string]any
+	_ = json.Unmarshal(argsJSON, &args)
+	c := policyCall{tool: toolName}
+	if toolName == "shell" {
+		c.commands = shellArgvs(argStr(args, "command"))
+	}
+	c.paths, c.write = toolPaths(toolName, args, workingDir)
+
+	var allowRule *ToolPolicyRule
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !r.matches(c) {
+			continue
+		}
+		if r.Effect == "deny" {
+			return ToolPolicyDecision{Rule: ruleLabel(r, i), Reason: ruleReason(r)}
+		}
+		if allowRule == nil {
+			allowRule = r
+		}
+	}
+	if allowRule == nil && p.Default == "deny" {
+		return ToolPolicyDecision{Rule: "default", Reason: "tool call is not allowed by any policy rule"}
+	}
+	if isWriteTool(toolName) && p.MaxWrites > 0 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.writes+p.pending >= p.MaxWrites {
+			return ToolPolicyDecision{Rule: "max_writes", Reason: fmt.Sprintf("write budget of %d file modifications is exhausted", p.MaxWrites)}
+		}
+		p.pending++
+	}
+	return ToolPolicyDecision{Allowed: true}
+}
+
+// settleWrite releases the write reserved by an allowed Evaluate and counts
+// it against MaxWrites when the call executed successfully.
+func (p *ToolPolicy) settleWrite(toolName string, wrote bool) {
+	if p == nil || !isWriteTool(toolName) || p.MaxWrites <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending > 0 {
+		p.pending--
+	}
+	if wrote {
+		p.writes++
+	}
+}
+
+type policyCall struct {
+	tool     string
+	commands [][]string
+	paths    []string
+	write    bool
+}
+
+func (r *ToolPolicyRule) matches(c policyCall) bool {
+	if len(r.Tools) > 0 && !matchAnyGlob(r.Tools, c.tool) {
+		return false
+	}
+	if len(r.Commands) > 0 {
+		hit := false
+		for _, pat := range r.Commands {
+			pargv := shellArgvs(pat)
+			if len(pargv) != 1 {
+				continue
+			}
+			for _, argv := range c.commands {
+				if matchArgvPrefix(pargv[0], argv) {
+					hit = true
+				}
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+	if len(r.Paths) > 0 {
+		if (r.Access == "read" && c.write) || (r.Access == "write" && !c.write) {
+			return false
+		}
+		hit := false
+		for _, p := range c.paths {
+			for _, g := range r.Paths {
+				if ok, _ := doublestar.Match(g, p); ok {
+					hit = true
+				}
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleLabel(r *ToolPolicyRule, i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rules[%d]", i)
+}
+
+func ruleReason(r *ToolPolicyRule) string {
+	if r.Reason != "" {
+		return r.Reason
+	}
+	return "denied by tool policy"
+}
+
+func matchAnyGlob(globs []string, s string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchArgvPrefix matches pattern tokens against a prefix of argv; "**"
+// matches zero or more arguments.
+func matchArgvPrefix(pat, argv []string) bool {
+	if len(pat) == 0 {
+		return true
+	}
+	if pat[0] == "**" {
+		for i := 0; i <= len(argv); i++ {
+			if matchArgvPrefix(pat[1:], argv[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(argv) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pat[0], argv[0]); !ok {
+		return false
+	}
+	return matchArgvPrefix(pat[1:], argv[1:])
+}
+
+func isWriteTool(name string) bool {
+	switch name {
+	case "write_file", "edit_file", "apply_patch":
+		return true
+	}
+	return false
+}
+
+// toolPaths lists the paths a file tool touches, relative to workingDir when
+// inside it.
+func toolPaths(tool string, args map[string]any, workingDir string) (paths []string, write bool) {
+	var raw []string
+	switch tool {
+	case "read_file", "go_to_definition", "find_references", "diagnostics", "document_symbols":
+		raw = []string{argStr(args, "file_path")}
+	case "write_file", "edit_file", "revert_file":
+		raw, write = []string{argStr(args, "file_path")}, true
+	case "apply_patch":
+		raw, write = patchPaths(argStr(args, "patch")), true
+	case "list_dir", "glob", "grep":
+		p := argStr(args, "path")
+		if p == "" {
+			p = "."
+		}
+		raw = []string{p}
+	default:
+		return nil, false
+	}
+	for _, p := range raw {
+		if p = strings.TrimSpace(p); p != "" {
+			paths = append(paths, normalizePolicyPath(p, workingDir))
+		}
+	}
+	return paths, write
+}
+
+func normalizePolicyPath(p, workingDir string) string {
+	if filepath.IsAbs(p) {
+		if workingDir != "" {
+			if rel, err := filepath.Rel(workingDir, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return filepath.ToSlash(rel)
+			}
+		}
+		return filepath.ToSlash(filepath.Clean(p))
+	}
+	return filepath.ToSlash(filepath.Clean(p))
+}
+
+// patchPaths extracts file paths from a v4a patch or unified diff. Patches
+// that do not parse fall back to scanning the file headers.
+func patchPaths(patch string) []string {
+	var out []string
+	if ops, err := parsePatch(patch); err == nil {
+		for _, op := range ops {
+			out = append(out, op.paths()...)
+		}
+		return out
+	}
+	for _, l := range strings.Split(patch, "\n") {
+		l = strings.TrimRight(l, "\r")
+		for _, prefix := range []string{"*** Add File: ", "*** Delete File: ", "*** Update File: ", "*** Move to: ", "--- ", "+++ ", "rename from ", "rename to ", "copy to "} {
+			if v, ok := strings.CutPrefix(l, prefix); ok {
+				if v = parseDiffPath(v); v != "/dev/null" {
+					if prefix == "--- " || prefix == "+++ " {
+						v = strings.TrimPrefix(strings.TrimPrefix(v, "a/"), "b/")
+					}
+					out = append(out, v)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// shellArgvs splits a command line into the argv of each simple command,
+// unwrapping env assignments, wrappers (env, sudo, command, exec, nohup,
+// time) and "sh|bash|zsh -c <script>" / eval.
+func shellArgvs(cmdline string) [][]string {
+	var out [][]string
+	for _, argv := range splitShellCommands(cmdline) {
+		out = append(out, unwrapArgv(argv, 0)...)
+	}
+	return out
+}
+
+func unwrapArgv(argv []string, depth int) [][]string {
+	for len(argv) > 0 {
+		a := argv[0]
+		if isEnvAssignment(a) {
+			argv = argv[1:]
+			continue
+		}
+		switch path.Base(a) {
+		case "env", "sudo", "command", "exec", "nohup", "time":
+			argv = argv[1:]
+			for len(argv) > 0 && strings.HasPrefix(argv[0], "-") {
+				argv = argv[1:]
+			}
+			continue
+		case "xargs":
+			argv = argv[1:]
+			for len(argv) > 0 && strings.HasPrefix(argv[0], "-") {
+				switch argv[0] {
+				case "-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s":
+					if len(argv) > 1 {
+						argv = argv[1:]
+					}
+				}
+				argv = argv[1:]
+			}
+			continue
+		}
+		break
+	}
+	if len(argv) == 0 {
+		return nil
+	}
+	argv = append([]string{path.Base(argv[0])}, argv[1:]...)
+	if depth < 4 {
+		switch argv[0] {
+		case "sh", "bash", "zsh", "dash":
+			for i := 1; i < len(argv)-1; i++ {
+				if strings.HasPrefix(argv[i], "-") && strings.Contains(argv[i], "c") {
+					var out [][]string
+					for _, inner := range splitShellCommands(argv[i+1]) {
+						out = append(out, unwrapArgv(inner, depth+1)...)
+					}
+					return out
+				}
+			}
+		case "eval":
+			var out [][]string
+			for _, inner := range splitShellCommands(strings.Join(argv[1:], " ")) {
+				out = append(out, unwrapArgv(inner, depth+1)...)
+			}
+			return out
+		case "find":
+			out := [][]string{argv}
+			for i := 1; i < len(argv); i++ {
+				switch argv[i] {
+				case "-exec", "-execdir", "-ok", "-okdir":
+					j := i + 1
+					for j < len(argv) && argv[j] != ";" && argv[j] != "+" {
+						j++
+					}
+					out = append(out, unwrapArgv(argv[i+1:j], depth+1)...)
+					i = j
+				}
+			}
+			return out
+		}
+	}
+	if argv[0] == "git" {
+		argv = stripGitGlobalOptions(argv)
+	}
+	return [][]string{argv}
+}
+
+// stripGitGlobalOptions drops the options between "git" and its subcommand
+// so "git -C repo -c k=v push" matches "git push".
+func stripGitGlobalOptions(argv []string) []string {
+	i := 1
+	for i < len(argv) && strings.HasPrefix(argv[i], "-") {
+		switch argv[i] {
+		case "-C", "-c", "--git-dir", "--work-tree", "--namespace", "--config-env", "--super-prefix":
+			i++
+		}
+		i++
+	}
+	if i > len(argv) {
+		i = len(argv)
+	}
+	return append([]string{argv[0]}, argv[i:]...)
+}
+
+func isEnvAssignment(s string) bool {
+	eq := strings.IndexByte(s, '=')
+	if eq <= 0 {
+		return false
+	}
+	for i, r := range s[:eq] {
+		if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (i > 0 && r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitShellCommands tokenizes a command line with shell quoting rules and
+// splits it into simple commands at control operators, parentheses, $( and
+// backticks. Command substitutions inside double quotes are split out as
+// commands of their own.
+func splitShellCommands(s string) [][]string {
+	var (
+		cmds    [][]string
+		argv    []string
+		tok     strings.Builder
+		inTok   bool
+		quote   rune
+		escaped bool
+	)
+	endTok := func() {
+		if inTok {
+			argv = append(argv, tok.String())
+			tok.Reset()
+			inTok = false
+		}
+	}
+	endCmd := func() {
+		endTok()
+		if len(argv) > 0 {
+			cmds = append(cmds, argv)
+			argv = nil
+		}
+	}
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case escaped:
+			if r != '\n' {
+				tok.WriteRune(r)
+				inTok = true
+			}
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				tok.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '`':
+				end := substitutionEnd(rs, i+1, '`')
+				cmds = append(cmds, splitShellCommands(string(rs[i+1:end]))...)
+				tok.WriteString(string(rs[i:min(end+1, len(rs))]))
+				i = end
+			case '$':
+				if i+1 < len(rs) && rs[i+1] == '(' {
+					end := substitutionEnd(rs, i+2, ')')
+					cmds = append(cmds, splitShellCommands(string(rs[i+2:end]))...)
+					tok.WriteString(string(rs[i:min(end+1, len(rs))]))
+					i = end
+				} else {
+					tok.WriteRune(r)
+				}
+			case '\\':
+				if i+1 < len(rs) && strings.ContainsRune("\"\\$`", rs[i+1]) {
+					i++
+					tok.WriteRune(rs[i])
+				} else {
+					tok.WriteRune(r)
+				}
+			default:
+				tok.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case r == '\'' || r == '"':
+			quote = r
+			inTok = true
+		case r == '#' && !inTok:
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			endCmd()
+		case r == '$' && i+1 < len(rs) && rs[i+1] == '(':
+			i++
+			endCmd()
+		case strings.ContainsRune(";&|()\n`", r):
+			endCmd()
+		case r == ' ' || r == '\t' || r == '\r':
+			endTok()
+		default:
+			tok.WriteRune(r)
+			inTok = true
+		}
+	}
+	endCmd()
+	return cmds
+}
+
+// substitutionEnd returns the index of the rune closing a command
+// substitution whose body starts at start: the matching ')' for $( or the
+// next unescaped '`'. It returns len(rs) when the substitution is unclosed.
+func substitutionEnd(rs []rune, start int, closer rune) int {
+	depth := 0
+	var quote rune
+	for i := start; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\\':
+			i++
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case closer == '`':
+			if r == '`' {
+				return i
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(rs)
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestShellArgvs_SplitsAndUnwraps(t *testing.T) {
+	got := shellArgvs(`cd repo && FOO=1 /usr/bin/git -C . push origin 'main' | tee log; sudo -E bash -c "make test; echo 'a;b'" $(git rev-parse HEAD)`)
+	var lines []string
+	for _, argv := range got {
+		lines = append(lines, strings.Join(argv, " "))
+	}
+	want := []string{
+		"cd repo",
+		"git push origin main",
+		"tee log",
+		"make test",
+		"echo a;b",
+		"git rev-parse HEAD",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("argvs:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestToolPolicy_DenyRulesOverrideAllow(t *testing.T) {
+	p := &ToolPolicy{Rules: []ToolPolicyRule{
+		{Effect: "allow", Tools: []string{"shell"}, Commands: []string{"git *"}},
+		{Name: "no-push", Effect: "deny", Commands: []string{"git ** push"}, Reason: "pushing is not allowed"},
+		{Name: "no-ci", Effect: "deny", Paths: []string{".github/**", "/etc/**"}, Access: "write"},
+	}}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	wd := "/work/repo"
+	cases := []struct {
+		tool, args string
+		rule       string
+	}{
+		{"shell", `{"command":"git status && git commit -m 'push it'"}`, ""},
+		{"shell", `{"command":"echo ok; git -C sub push --force"}`, "no-push"},
+		{"shell", `{"command":"bash -lc 'git push'"}`, "no-push"},
+		{"shell", `{"command":"echo git push"}`, ""},
+		{"write_file", `{"file_path":".github/workflows/ci.yml","content":"x"}`, "no-ci"},
+		{"edit_file", `{"file_path":"/work/repo/.github/workflows/ci.yml"}`, "no-ci"},
+		{"read_file", `{"file_path":".github/workflows/ci.yml"}`, ""},
+		{"apply_patch", `{"patch":"*** Begin Patch\n*** Update File: main.go\n*** Move to: .github/x.yml\n*** End Patch"}`, "no-ci"},
+		{"apply_patch", `{"patch":"diff --git a/main.go b/.github/x.yml\nrename from main.go\nrename to .github/x.yml\n"}`, "no-ci"},
+		{"apply_patch", `{"patch":"--- a/.github/ci.yml\n+++ b/.github/ci.yml\n@@ -1 +1 @@\n-a\n+b\n"}`, "no-ci"},
+		{"write_file", `{"file_path":"main.go","content":"x"}`, ""},
+		{"shell", `{"command":"echo \"$(git push origin main)\""}`, "no-push"},
+		{"shell", "{\"command\":\"echo \\\"`git push`\\\"\"}", "no-push"},
+		{"shell", `{"command":"echo \"sha $(git rev-parse HEAD)\""}`, ""},
+		{"shell", `{"command":"echo origin | xargs -n 1 git push"}`, "no-push"},
+		{"shell", `{"command":"find . -name x -exec git push \\;"}`, "no-push"},
+		{"shell", `{"command":"find . -execdir git push {} +"}`, "no-push"},
+		{"shell", `{"command":"git -c k=v push"}`, "no-push"},
+		{"shell", `{"command":"git --git-dir .git --no-pager push"}`, "no-push"},
+	}
+	for _, c := range cases {
+		d := p.Evaluate(c.tool, []byte(c.args), wd)
+		if d.Allowed != (c.rule == "") || d.Rule != c.rule {
+			t.Fatalf("%s %s: got %+v, want rule %q", c.tool, c.args, d, c.rule)
+		}
+	}
+}
+
+func TestToolPolicy_DefaultDenyAndWriteBudget(t *testing.T) {
+	p := &ToolPolicy{
+		Default:   "deny",
+		MaxWrites: 2,
+		Rules:     []ToolPolicyRule{{Effect: "allow", Tools: []string{"read_file", "write_file"}}},
+	}
+	if d := p.Evaluate("shell", []byte(`{"command":"ls"}`), ""); d.Allowed || d.Rule != "default" {
+		t.Fatalf("shell: %+v", d)
+	}
+	// Failed writes release their reservation without using up the budget.
+	for i := 0; i < 3; i++ {
+		if d := p.Evaluate("write_file", []byte(`{"file_path":"a"}`), ""); !d.Allowed {
+			t.Fatalf("failed write %d: %+v", i, d)
+		}
+		p.settleWrite("write_file", false)
+	}
+	for i := 0; i < 2; i++ {
+		if d := p.Evaluate("write_file", []byte(`{"file_path":"a"}`), ""); !d.Allowed {
+			t.Fatalf("write %d: %+v", i, d)
+		}
+	}
+	if d := p.Evaluate("write_file", []byte(`{"file_path":"a"}`), ""); d.Allowed || d.Rule != "max_writes" {
+		t.Fatalf("third write: %+v", d)
+	}
+	if d := p.Evaluate("read_file", []byte(`{"file_path":"a"}`), ""); !d.Allowed {
+		t.Fatalf("read after budget: %+v", d)
+	}
+	p.settleWrite("write_file", true)
+	p.settleWrite("write_file", false)
+	if d := p.Evaluate("write_file", []byte(`{"file_path":"a"}`), ""); !d.Allowed {
+		t.Fatalf("write after a failed reservation settled: %+v", d)
+	}
+	p.settleWrite("write_file", true)
+	if d := p.Evaluate("write_file", []byte(`{"file_path":"a"}`), ""); d.Allowed || d.Rule != "max_writes" {
+		t.Fatalf("write after budget used: %+v", d)
+	}
+}
+
+func TestToolPolicy_Validate(t *testing.T) {
+	bad := []*ToolPolicy{
+		{Default: "maybe"},
+		{MaxWrites: -1},
+		{Rules: []ToolPolicyRule{{Effect: "block", Tools: []string{"shell"}}}},
+		{Rules: []ToolPolicyRule{{Effect: "deny"}}},
+		{Rules: []ToolPolicyRule{{Effect: "deny", Paths: []string{"a/[b"}}}},
+		{Rules: []ToolPolicyRule{{Effect: "deny", Commands: []string{"git push; rm -rf /"}}}},
+		{Rules: []ToolPolicyRule{{Effect: "deny", Paths: []string{"x"}, Access: "exec"}}},
+	}
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", p)
+		}
+	}
+}
+
+func TestSession_ToolPolicy_DeniesCallWithStructuredError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	call := func(id, name, args string) func(llm.Request) llm.Response {
+		return func(llm.Request) llm.Response {
+			tc := llm.ToolCallData{ID: id, Name: name, Arguments: json.RawMessage(args)}
+			return llm.Response{Message: llm.Message{Role: llm.RoleAssistant, Content: []llm.ContentPart{{Kind: llm.ContentToolCall, ToolCall: &tc}}}}
+		}
+	}
+	f := &fakeAdapter{name: "openai", steps: []func(llm.Request) llm.Response{
+		call("1", "write_file", `{"file_path":".github/workflows/ci.yml","content":"x"}`),
+		call("2", "write_file", `{"file_path":"ok.txt","content":"x"}`),
+	}}
+	c := llm.NewClient()
+	c.Register(f)
+	policy := &ToolPolicy{Rules: []ToolPolicyRule{{Name: "no-ci", Effect: "deny", Paths: []string{".github/**"}, Reason: "CI config is protected"}}}
+	sess, err := NewSession(c, NewOpenAIProfile("gpt-5.2"), NewLocalExecutionEnvironment(dir), SessionConfig{ToolPolicy: policy})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	if _, err := sess.ProcessInput(ctx, "edit files"); err != nil {
+		t.Fatalf("ProcessInput: %v", err)
+	}
+	sess.Close()
+
+	var denied []SessionEvent
+	for ev := range sess.Events() {
+		if ev.Kind == EventToolCallDenied {
+			denied = append(denied, ev)
+		}
+	}
+	if len(denied) != 1 || denied[0].Data["rule"] != "no-ci" || denied[0].Data["call_id"] != "1" {
+		t.Fatalf("denied events: %+v", denied)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".github", "workflows", "ci.yml")); err == nil {
+		t.Fatalf("denied write was executed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ok.txt")); err != nil {
+		t.Fatalf("allowed write missing: %v", err)
+	}
+
+	reqs := f.Requests()
+	var r llm.ToolResultData
+	for _, m := range reqs[len(reqs)-1].Messages {
+		for _, p := range m.Content {
+			if p.Kind == llm.ContentToolResult && p.ToolResult != nil && p.ToolResult.ToolCallID == "1" {
+				r = *p.ToolResult
+			}
+		}
+	}
+	var body map[string]any
+	if err := json.Unmarshal([]byte(toolResultText(r)), &body); err != nil || !r.IsError {
+		t.Fatalf("denial result %+v: %v", r, err)
+	}
+	if body["error"] != "tool_call_denied" || body["rule"] != "no-ci" || body["reason"] != "CI config is protected" {
+		t.Fatalf("denial body: %v", body)
+	}
+}
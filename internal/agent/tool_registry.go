@@ -13,6 +13,7 @@ import (
 
 	"github.com/danshapiro/kilroy/internal/jsonschemautil"
 	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/tracing"
 )
 
 type TruncationStrategy string
@@ -95,6 +96,21 @@ func (r *ToolRegistry) Definitions() []llm.ToolDefinition {
 }
 
 func (r *ToolRegistry) ExecuteCall(ctx context.Context, env ExecutionEnvironment, call llm.ToolCallData) ToolExecResult {
+	ctx, span := tracing.Start(ctx, "agent.tool_call", tracing.String("agent.tool.name", call.Name))
+	res := r.executeCall(ctx, env, call)
+	span.SetAttributes(
+		tracing.String("agent.tool.call_id", res.CallID),
+		tracing.Bool("agent.tool.is_error", res.IsError),
+		tracing.Int("agent.tool.output_chars", len(res.FullOutput)),
+	)
+	if res.IsError {
+		span.SetStatus(tracing.StatusError, strings.SplitN(res.Output, "\n", 2)[0])
+	}
+	span.End()
+	return res
+}
+
+func (r *ToolRegistry) executeCall(ctx context.Context, env ExecutionEnvironment, call llm.ToolCallData) ToolExecResult {
 	name := call.Name
 	callID := call.ID
 	if strings.TrimSpace(callID) == "" {
@@ -210,6 +226,10 @@ func defaultToolLimit(toolName string) ToolOutputLimit {
 		return ToolOutputLimit{MaxChars: 1_000, Strategy: TruncTail}
 	case "spawn_agent":
 		return ToolOutputLimit{MaxChars: 20_000, Strategy: TruncHeadTail}
+	case "find_references", "diagnostics":
+		return ToolOutputLimit{MaxChars: 20_000, MaxLines: 300, Strategy: TruncHeadTail}
+	case "document_symbols":
+		return ToolOutputLimit{MaxChars: 20_000, MaxLines: 500, Strategy: TruncHeadTail}
 	default:
 		return ToolOutputLimit{MaxChars: 20_000, Strategy: TruncHeadTail}
 	}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/tracing"
 )
 
 func TestToolRegistry_UnknownTool_ReturnsErrorResult(t *testing.T) {
@@ -336,3 +338,38 @@ func TestCompileSchema_DoesNotDependOnGetwd(t *testing.T) {
 		t.Fatalf("validate: %v", err)
 	}
 }
+
+func TestToolRegistry_ExecuteCall_RecordsSpanWhenTracing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	tr := tracing.NewTracer(tracing.NewFileExporter(path), tracing.Options{})
+	ctx, parent := tracing.Start(tracing.WithTracer(context.Background(), tr), "agent.turn")
+
+	r := NewToolRegistry()
+	res := r.ExecuteCall(ctx, NewLocalExecutionEnvironment(t.TempDir()), llm.ToolCallData{
+		ID:        "c1",
+		Name:      "does_not_exist",
+		Arguments: json.RawMessage(`{}`),
+	})
+	parent.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	spans, err := tracing.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(spans) != 2 || spans[0].Name != "agent.tool_call" {
+		t.Fatalf("spans: %+v", spans)
+	}
+	s := spans[0]
+	if s.ParentSpanID != parent.SpanID().String() {
+		t.Fatalf("tool span not parented to turn span: %+v", s)
+	}
+	if s.Attr("agent.tool.name") != "does_not_exist" || s.Attr("agent.tool.call_id") != res.CallID || s.Attr("agent.tool.is_error") != "true" {
+		t.Fatalf("tool span attrs: %+v", s.Attributes)
+	}
+	if s.Status.Code != int(tracing.StatusError) || !strings.Contains(s.Status.Message, "unknown tool") {
+		t.Fatalf("tool span status: %+v", s.Status)
+	}
+}
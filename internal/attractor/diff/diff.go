@@ -0,0 +1,371 @@
+// Package diff compares two versions of a pipeline by what they do rather
+// than how their DOT text reads: stages added, removed or renamed, effective
+// attributes that changed (after imports, prompt files and the model
+// stylesheet are applied), edges whose conditions, weights or other
+// attributes changed, and where each way a stage can end now routes,
+// failure classes included.
+package diff
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/validate"
+)
+
+// Report is the difference between two prepared graphs. Nodes and edges
+// are named by their IDs in the new graph, except removed ones.
+type Report struct {
+	Old          string        `json:"old"`
+	New          string        `json:"new"`
+	Graph        []AttrChange  `json:"graph,omitempty"`
+	AddedNodes   []string      `json:"added_nodes,omitempty"`
+	RemovedNodes []string      `json:"removed_nodes,omitempty"`
+	RenamedNodes []Rename      `json:"renamed_nodes,omitempty"`
+	ChangedNodes []NodeChange  `json:"changed_nodes,omitempty"`
+	AddedEdges   []Edge        `json:"added_edges,omitempty"`
+	RemovedEdges []Edge        `json:"removed_edges,omitempty"`
+	ChangedEdges []EdgeChange  `json:"changed_edges,omitempty"`
+	Routes       []RouteChange `json:"changed_routes,omitempty"`
+}
+
+// AttrChange is one attribute whose value changed. An unset attribute has
+// the value "".
+type AttrChange struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type NodeChange struct {
+	ID    string       `json:"id"`
+	Attrs []AttrChange `json:"attrs"`
+}
+
+type Edge struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Condition string `json:"condition,omitempty"`
+}
+
+type EdgeChange struct {
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Attrs []AttrChange `json:"attrs"`
+}
+
+// RouteChange is a way a stage can end (Outcome, written as the condition
+// that selects it) that now leads somewhere else. Old and New describe the
+// destinations, as Describe renders them.
+type RouteChange struct {
+	Node    string `json:"node"`
+	Outcome string `json:"outcome"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// Empty reports whether the graphs behave the same.
+func (r Report) Empty() bool {
+	return len(r.Graph) == 0 && len(r.AddedNodes) == 0 && len(r.RemovedNodes) == 0 &&
+		len(r.RenamedNodes) == 0 && len(r.ChangedNodes) == 0 && len(r.AddedEdges) == 0 &&
+		len(r.RemovedEdges) == 0 && len(r.ChangedEdges) == 0 && len(r.Routes) == 0
+}
+
+// Graphs compares old with new. Both should come from
+// engine.PrepareWithOptions so that attributes are the effective ones.
+func Graphs(old, new *model.Graph) Report {
+	r := Report{Old: old.Span.File, New: new.Span.File}
+	r.Graph = attrChanges(old.Attrs, new.Attrs, nil)
+
+	oldIDs, newIDs := nodeIDs(old), nodeIDs(new)
+	var removed, added []string
+	for _, id := range oldIDs {
+		if new.Nodes[id] == nil {
+			removed = append(removed, id)
+		}
+	}
+	for _, id := range newIDs {
+		if old.Nodes[id] == nil {
+			added = append(added, id)
+		}
+	}
+	renamed := matchRenames(old, new, removed, added)
+	toNew := func(id string) string {
+		if to, ok := renamed[id]; ok {
+			return to
+		}
+		return id
+	}
+	fromNew := map[string]string{}
+	for from, to := range renamed {
+		fromNew[to] = from
+	}
+	for _, id := range removed {
+		if _, ok := renamed[id]; !ok {
+			r.RemovedNodes = append(r.RemovedNodes, id)
+		}
+	}
+	for _, id := range added {
+		if from, ok := fromNew[id]; ok {
+			r.RenamedNodes = append(r.RenamedNodes, Rename{From: from, To: id})
+		} else {
+			r.AddedNodes = append(r.AddedNodes, id)
+		}
+	}
+
+	// Nodes present in both, in new declaration order.
+	oldIDOf := func(id string) string {
+		if from, ok := fromNew[id]; ok {
+			return from
+		}
+		return id
+	}
+	var common []string
+	for _, id := range newIDs {
+		if old.Nodes[oldIDOf(id)] != nil {
+			common = append(common, id)
+		}
+	}
+	for _, id := range common {
+		if cs := attrChanges(old.Nodes[oldIDOf(id)].Attrs, new.Nodes[id].Attrs, toNew); len(cs) > 0 {
+			r.ChangedNodes = append(r.ChangedNodes, NodeChange{ID: id, Attrs: cs})
+		}
+	}
+
+	r.diffEdges(old, new, toNew)
+
+	oldRoutes, newRoutes := validate.Routes(old), validate.Routes(new)
+	for _, id := range common {
+		r.diffRoutes(id, oldRoutes[oldIDOf(id)], newRoutes[id], toNew)
+	}
+	return r
+}
+
+// diffEdges pairs the edges between the same two stages, first by equal
+// condition, then in declaration order; edges left over were added or
+// removed.
+func (r *Report) diffEdges(old, new *model.Graph, toNew func(string) string) {
+	key := func(from, to string) string { return from + "\x00" + to }
+	oldBy := map[string][]*model.Edge{}
+	for _, e := range old.Edges {
+		if e != nil {
+			k := key(toNew(e.From), toNew(e.To))
+			oldBy[k] = append(oldBy[k], e)
+		}
+	}
+	matched := map[*model.Edge]bool{}
+	var newEdges []*model.Edge
+	for _, e := range new.Edges {
+		if e != nil {
+			newEdges = append(newEdges, e)
+		}
+	}
+	pair := func(e *model.Edge, same func(o *model.Edge) bool) *model.Edge {
+		for _, o := range oldBy[key(e.From, e.To)] {
+			if !matched[o] && same(o) {
+				matched[o] = true
+				return o
+			}
+		}
+		return nil
+	}
+	partner := map[*model.Edge]*model.Edge{}
+	for _, e := range newEdges {
+		if o := pair(e, func(o *model.Edge) bool { return o.Condition() == e.Condition() }); o != nil {
+			partner[e] = o
+		}
+	}
+	for _, e := range newEdges {
+		if partner[e] == nil {
+			if o := pair(e, func(*model.Edge) bool { return true }); o != nil {
+				partner[e] = o
+			}
+		}
+	}
+	for _, e := range newEdges {
+		o := partner[e]
+		if o == nil {
+			r.AddedEdges = append(r.AddedEdges, Edge{From: e.From, To: e.To, Condition: e.Condition()})
+			continue
+		}
+		if cs := attrChanges(o.Attrs, e.Attrs, nil); len(cs) > 0 {
+			r.ChangedEdges = append(r.ChangedEdges, EdgeChange{From: e.From, To: e.To, Attrs: cs})
+		}
+	}
+	for _, o := range old.Edges {
+		if o != nil && !matched[o] {
+			r.RemovedEdges = append(r.RemovedEdges, Edge{From: o.From, To: o.To, Condition: o.Condition()})
+		}
+	}
+}
+
+// diffRoutes compares where each way stage id can end leads. When only one
+// version splits fail by failure_class, each class is compared with the
+// other version's single fail route.
+func (r *Report) diffRoutes(id string, old, new []validate.Route, toNew func(string) string) {
+	find := func(rs []validate.Route, want validate.Route) *validate.Route {
+		var unsplit *validate.Route
+		for i, rt := range rs {
+			if rt.Status != want.Status || rt.Label != want.Label {
+				continue
+			}
+			if rt.FailureClass == want.FailureClass {
+				return &rs[i]
+			}
+			if rt.FailureClass == "" {
+				unsplit = &rs[i]
+			}
+		}
+		if want.FailureClass != "" {
+			return unsplit
+		}
+		return nil
+	}
+	split := func(rs []validate.Route, want validate.Route) bool {
+		for _, rt := range rs {
+			if rt.Status == want.Status && rt.Label == want.Label && rt.FailureClass != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var outcomes []validate.Route
+	seen := map[string]bool{}
+	for _, rt := range append(append([]validate.Route{}, new...), old...) {
+		if seen[rt.Outcome()] {
+			continue
+		}
+		seen[rt.Outcome()] = true
+		if rt.FailureClass == "" && (split(old, rt) || split(new, rt)) {
+			continue // compared class by class
+		}
+		outcomes = append(outcomes, rt)
+	}
+	for _, want := range outcomes {
+		before := Describe(find(old, want), toNew)
+		after := Describe(find(new, want), nil)
+		if before != after {
+			r.Routes = append(r.Routes, RouteChange{Node: id, Outcome: want.Outcome(), Old: before, New: after})
+		}
+	}
+}
+
+// Describe renders where a route leads: its targets separated by " | ",
+// the retry_target it jumps to, "end of run" when it leads nowhere, and
+// "(fallback)" when the engine only gets there by its last-resort edge
+// choice. A nil route is "no route". rename maps node IDs, if set.
+func Describe(rt *validate.Route, rename func(string) string) string {
+	if rt == nil {
+		return "no route"
+	}
+	if rename == nil {
+		rename = func(id string) string { return id }
+	}
+	var parts []string
+	for _, t := range rt.Targets {
+		parts = append(parts, rename(t))
+	}
+	if rt.Jump != "" {
+		parts = append(parts, "retry_target "+rename(rt.Jump))
+	}
+	if len(parts) == 0 {
+		return "end of run"
+	}
+	s := strings.Join(parts, " | ")
+	if rt.Fallback {
+		s += " (fallback)"
+	}
+	return s
+}
+
+// nodeRefAttrs name other nodes; a renamed node's new ID is the same
+// value.
+var nodeRefAttrs = map[string]bool{"retry_target": true, "fallback_retry_target": true}
+
+// attrChanges lists the keys of old and new whose values differ, sorted.
+// Old values of nodeRefAttrs go through rename when it is set.
+func attrChanges(old, new map[string]string, rename func(string) string) []AttrChange {
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	var out []AttrChange
+	for k := range keys {
+		o, n := old[k], new[k]
+		if rename != nil && nodeRefAttrs[k] && o != "" {
+			o = rename(o)
+		}
+		if o != n {
+			out = append(out, AttrChange{Key: k, Old: old[k], New: n})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// matchRenames pairs removed and added nodes that are the same stage under
+// a new ID: same shape and type, and either the same attributes apart from
+// the label or the same non-empty prompt or tool_command. Only pairs that
+// match each other alone count.
+func matchRenames(old, new *model.Graph, removed, added []string) map[string]string {
+	cands := map[string][]string{}
+	back := map[string][]string{}
+	for _, r := range removed {
+		for _, a := range added {
+			if sameStage(old.Nodes[r], new.Nodes[a]) {
+				cands[r] = append(cands[r], a)
+				back[a] = append(back[a], r)
+			}
+		}
+	}
+	out := map[string]string{}
+	for _, r := range removed {
+		if len(cands[r]) == 1 && len(back[cands[r][0]]) == 1 {
+			out[r] = cands[r][0]
+		}
+	}
+	return out
+}
+
+func sameStage(a, b *model.Node) bool {
+	for _, k := range []string{"shape", "type"} {
+		if a.Attr(k, "") != b.Attr(k, "") {
+			return false
+		}
+	}
+	for _, k := range []string{"prompt", "tool_command"} {
+		if v := a.Attr(k, ""); v != "" && v == b.Attr(k, "") {
+			return true
+		}
+	}
+	cs := attrChanges(a.Attrs, b.Attrs, nil)
+	return !slices.ContainsFunc(cs, func(c AttrChange) bool { return c.Key != "label" })
+}
+
+func nodeIDs(g *model.Graph) []string {
+	var ids []string
+	for id, n := range g.Nodes {
+		if n != nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := g.Nodes[ids[i]], g.Nodes[ids[j]]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.ID < b.ID
+	})
+	return ids
+}
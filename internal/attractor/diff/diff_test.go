@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+const oldDot = `digraph G {
+  graph [model_stylesheet="* { llm_provider: openai; llm_model: gpt-5.2; timeout: 300s; }"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl  [shape=box, prompt="Implement the feature"]
+  check [shape=box, prompt="Check it", goal_gate=true, retry_target=impl]
+  lint  [shape=parallelogram, tool_command="make lint"]
+  start -> impl -> lint -> check
+  check -> exit [condition="outcome=success"]
+  check -> impl [condition="outcome=fail"]
+}
+`
+
+func prepare(t *testing.T, name, src string) *model.Graph {
+	t.Helper()
+	g, _, err := engine.PrepareWithOptions([]byte(src), engine.PrepareOptions{Filename: name})
+	if g == nil {
+		t.Fatalf("prepare %s: %v", name, err)
+	}
+	return g
+}
+
+func TestGraphs_ReportsBehaviouralChanges(t *testing.T) {
+	newDot := strings.NewReplacer(
+		"impl ", "implement ",
+		"impl\n", "implement\n",
+		"retry_target=impl]", "retry_target=implement]",
+		"timeout: 300s; }\"", "timeout: 300s; } #check { timeout: 600s; }\"",
+		`lint  [shape=parallelogram, tool_command="make lint"]`, `review [shape=box, prompt="Review"]`,
+		"-> lint -> check", "-> review -> check",
+		`check -> impl [condition="outcome=fail"]`, `check -> implement [condition="outcome=fail && context.failure_class=transient_infra", loop_restart=true]`+"\n  check -> exit [condition=\"outcome=fail\", weight=2]",
+	).Replace(oldDot)
+	r := Graphs(prepare(t, "old.dot", oldDot), prepare(t, "new.dot", newDot))
+
+	if len(r.RenamedNodes) != 1 || r.RenamedNodes[0] != (Rename{From: "impl", To: "implement"}) {
+		t.Fatalf("renamed: %+v", r.RenamedNodes)
+	}
+	if len(r.AddedNodes) != 1 || r.AddedNodes[0] != "review" || len(r.RemovedNodes) != 1 || r.RemovedNodes[0] != "lint" {
+		t.Fatalf("added %v removed %v", r.AddedNodes, r.RemovedNodes)
+	}
+	// The stylesheet change is seen as the effective timeout; retry_target
+	// follows the rename.
+	if len(r.ChangedNodes) != 1 || r.ChangedNodes[0].ID != "check" ||
+		len(r.ChangedNodes[0].Attrs) != 1 || r.ChangedNodes[0].Attrs[0] != (AttrChange{Key: "timeout", Old: "300s", New: "600s"}) {
+		t.Fatalf("changed nodes: %+v", r.ChangedNodes)
+	}
+	var condition bool
+	for _, e := range r.ChangedEdges {
+		for _, c := range e.Attrs {
+			if e.From == "check" && e.To == "implement" && c.Key == "condition" && c.Old == "outcome=fail" {
+				condition = true
+			}
+		}
+	}
+	if !condition {
+		t.Fatalf("changed edges: %+v", r.ChangedEdges)
+	}
+	var deterministic bool
+	for _, rc := range r.Routes {
+		if rc.Node == "check" && rc.Outcome == "outcome=fail && context.failure_class=deterministic" {
+			deterministic = rc.Old == "implement" && rc.New == "exit"
+		}
+	}
+	if !deterministic {
+		t.Fatalf("routes: %+v", r.Routes)
+	}
+
+	var text bytes.Buffer
+	if err := Write(&text, FormatText, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"~ node impl renamed to implement\n",
+		"~ node check: timeout = 600s (was 300s)\n",
+		"- edge lint -> check\n",
+		"~ route check on outcome=fail && context.failure_class=deterministic: exit (was implement)\n",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Fatalf("text missing %q:\n%s", want, text.String())
+		}
+	}
+	var js bytes.Buffer
+	if err := Write(&js, FormatJSON, r); err != nil {
+		t.Fatal(err)
+	}
+	var back Report
+	if err := json.Unmarshal(js.Bytes(), &back); err != nil || back.Old != "old.dot" || len(back.Routes) != len(r.Routes) {
+		t.Fatalf("json (%v):\n%s", err, js.String())
+	}
+}
+
+func TestGraphs_SameBehaviourIsEmpty(t *testing.T) {
+	// Layout, comments and where an attribute is set do not matter.
+	restyled := strings.Replace(oldDot, `impl  [shape=box, prompt="Implement the feature"]`,
+		"// the work\n  impl [prompt=\"Implement the feature\", shape=box, timeout=300s]", 1)
+	r := Graphs(prepare(t, "old.dot", oldDot), prepare(t, "new.dot", restyled))
+	if !r.Empty() {
+		t.Fatalf("expected no changes, got %+v", r)
+	}
+	var text bytes.Buffer
+	if err := Write(&text, "", r); err != nil {
+		t.Fatal(err)
+	}
+	if text.String() != "no changes between old.dot and new.dot\n" {
+		t.Fatalf("text: %q", text.String())
+	}
+}
@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Report formats accepted by Write.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// maxValueRunes bounds attribute values in text output; prompts are long.
+const maxValueRunes = 60
+
+// Write writes r using format (text or json). An empty format means text.
+func Write(w io.Writer, format string, r Report) error {
+	switch format {
+	case "", FormatText:
+		return writeText(w, r)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	default:
+		return fmt.Errorf("unknown format %q (want text|json)", format)
+	}
+}
+
+// writeText prints one line per change: "+" added, "-" removed, "~"
+// changed, with old values in parentheses.
+func writeText(w io.Writer, r Report) error {
+	var b strings.Builder
+	if r.Empty() {
+		fmt.Fprintf(&b, "no changes between %s and %s\n", r.Old, r.New)
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	for _, c := range r.Graph {
+		fmt.Fprintf(&b, "~ graph: %s\n", attrText(c))
+	}
+	for _, id := range r.AddedNodes {
+		fmt.Fprintf(&b, "+ node %s\n", id)
+	}
+	for _, id := range r.RemovedNodes {
+		fmt.Fprintf(&b, "- node %s\n", id)
+	}
+	for _, rn := range r.RenamedNodes {
+		fmt.Fprintf(&b, "~ node %s renamed to %s\n", rn.From, rn.To)
+	}
+	for _, n := range r.ChangedNodes {
+		for _, c := range n.Attrs {
+			fmt.Fprintf(&b, "~ node %s: %s\n", n.ID, attrText(c))
+		}
+	}
+	for _, e := range r.AddedEdges {
+		fmt.Fprintf(&b, "+ edge %s\n", edgeText(e))
+	}
+	for _, e := range r.RemovedEdges {
+		fmt.Fprintf(&b, "- edge %s\n", edgeText(e))
+	}
+	for _, e := range r.ChangedEdges {
+		for _, c := range e.Attrs {
+			fmt.Fprintf(&b, "~ edge %s -> %s: %s\n", e.From, e.To, attrText(c))
+		}
+	}
+	for _, rc := range r.Routes {
+		fmt.Fprintf(&b, "~ route %s on %s: %s (was %s)\n", rc.Node, rc.Outcome, rc.New, rc.Old)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func attrText(c AttrChange) string {
+	o, n := []rune(c.Old), []rune(c.New)
+	from := 0
+	if len(o) > maxValueRunes || len(n) > maxValueRunes {
+		// Show both from just before where they differ.
+		for from < len(o) && from < len(n) && o[from] == n[from] {
+			from++
+		}
+		from = max(from-maxValueRunes/4, 0)
+	}
+	return fmt.Sprintf("%s = %s (was %s)", c.Key, valueText(c.New, from), valueText(c.Old, from))
+}
+
+func edgeText(e Edge) string {
+	s := e.From + " -> " + e.To
+	if e.Condition != "" {
+		s += " [condition=" + strconv.Quote(e.Condition) + "]"
+	}
+	return s
+}
+
+// valueText shows v on one line from rune from, quoted when it is not a
+// plain word and cut short when long.
+func valueText(v string, from int) string {
+	if v == "" {
+		return "unset"
+	}
+	r := []rune(v)
+	prefix, suffix := "", ""
+	if from > 0 && from < len(r) {
+		r, prefix = r[from:], "..."
+	}
+	if len(r) > maxValueRunes {
+		r, suffix = r[:maxValueRunes], "..."
+	}
+	v = prefix + string(r) + suffix
+	if strings.ContainsAny(v, " \t\n\"\\") || v == "unset" {
+		return strconv.Quote(v)
+	}
+	return v
+}
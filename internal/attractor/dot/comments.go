@@ -3,9 +3,23 @@ package dot
 import "fmt"
 
 // stripComments removes // and /* */ comments from DOT source, while preserving comment-like
-// sequences inside double-quoted strings.
+// sequences inside double-quoted strings. Comments are blanked to spaces (newlines kept) so
+// byte offsets, and therefore line/column positions, still match the original source.
 func stripComments(src []byte) ([]byte, error) {
+	out, _, err := scanComments(src)
+	return out, err
+}
+
+// comment is a // or /* */ comment at src[start:end].
+type comment struct {
+	start, end int
+	text       string
+}
+
+// scanComments is stripComments that also returns the comments it removed, in source order.
+func scanComments(src []byte) ([]byte, []comment, error) {
 	out := make([]byte, 0, len(src))
+	var comments []comment
 	inString := false
 	escaped := false
 
@@ -39,23 +53,33 @@ func stripComments(src []byte) ([]byte, error) {
 		if ch == '/' && i+1 < len(src) {
 			next := src[i+1]
 			if next == '/' {
-				// Line comment: skip until newline (but keep the newline).
-				i += 2
+				// Line comment: blank until newline (but keep the newline).
+				start := i
 				for i < len(src) && src[i] != '\n' {
+					out = append(out, ' ')
 					i++
 				}
+				comments = append(comments, comment{start: start, end: i, text: string(src[start:i])})
 				continue
 			}
 			if next == '*' {
-				// Block comment: skip until closing */.
+				// Block comment: blank until closing */, keeping newlines.
+				start := i
 				i += 2
 				for i+1 < len(src) && !(src[i] == '*' && src[i+1] == '/') {
 					i++
 				}
 				if i+1 >= len(src) {
-					return nil, fmt.Errorf("dot: unterminated block comment")
+					return nil, nil, fmt.Errorf("dot: unterminated block comment")
 				}
 				i += 2
+				comments = append(comments, comment{start: start, end: i, text: string(src[start:i])})
+				for _, c := range src[start:i] {
+					if c != '\n' {
+						c = ' '
+					}
+					out = append(out, c)
+				}
 				continue
 			}
 		}
@@ -64,7 +88,7 @@ func stripComments(src []byte) ([]byte, error) {
 		i++
 	}
 	if inString {
-		return nil, fmt.Errorf("dot: unterminated string (while stripping comments)")
+		return nil, nil, fmt.Errorf("dot: unterminated string (while stripping comments)")
 	}
-	return out, nil
+	return out, comments, nil
 }
@@ -0,0 +1,109 @@
+package dot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// Edit replaces the source text in Span with NewText. A Span whose End is
+// its Start inserts NewText there.
+type Edit struct {
+	Span    model.Span `json:"span"`
+	NewText string     `json:"new_text"`
+}
+
+// Insert returns the edit that inserts text at pos in file.
+func Insert(file string, pos model.Position, text string) Edit {
+	return Edit{Span: model.Span{File: file, Start: pos, End: pos}, NewText: text}
+}
+
+// Overlaps reports whether a and b change overlapping text. Insertions at
+// the same position do not overlap; they apply in order.
+func (a Edit) Overlaps(b Edit) bool {
+	if a.Span.File != b.Span.File {
+		return false
+	}
+	return before(a.Span.Start, b.Span.End) && before(b.Span.Start, a.Span.End)
+}
+
+func before(a, b model.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// ApplyEdits applies edits to src, which must be the text their spans were
+// taken from. Edits must not overlap; insertions at the same position keep
+// their order. Edit files are not checked; callers pick the edits for src.
+func ApplyEdits(src []byte, edits []Edit) ([]byte, error) {
+	lineStarts := []int{0}
+	for i, b := range src {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	offset := func(p model.Position) (int, error) {
+		if p.Line < 1 || p.Line > len(lineStarts) {
+			return 0, fmt.Errorf("edit position %d:%d is outside the source", p.Line, p.Column)
+		}
+		off := lineStarts[p.Line-1] + p.Column - 1
+		end := len(src)
+		if p.Line < len(lineStarts) {
+			end = lineStarts[p.Line]
+		}
+		if p.Column < 1 || off > end {
+			return 0, fmt.Errorf("edit position %d:%d is outside the source", p.Line, p.Column)
+		}
+		return off, nil
+	}
+
+	type resolved struct {
+		start, end int
+		text       string
+	}
+	rs := make([]resolved, 0, len(edits))
+	for _, e := range edits {
+		start, err := offset(e.Span.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := offset(e.Span.End)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("edit at %s ends before it starts", e.Span)
+		}
+		rs = append(rs, resolved{start, end, e.NewText})
+	}
+	// Insertions go before a replacement starting at the same offset.
+	sort.SliceStable(rs, func(i, j int) bool {
+		if rs[i].start != rs[j].start {
+			return rs[i].start < rs[j].start
+		}
+		return rs[i].end == rs[i].start && rs[j].end != rs[j].start
+	})
+
+	var b strings.Builder
+	at := 0
+	for _, r := range rs {
+		if r.start < at {
+			return nil, fmt.Errorf("overlapping edits at offset %d", r.start)
+		}
+		b.Write(src[at:r.start])
+		b.WriteString(r.text)
+		at = r.end
+	}
+	b.Write(src[at:])
+	return []byte(b.String()), nil
+}
+
+// Value renders v as a DOT attribute value or node ID: bare when it is an
+// identifier or number, quoted otherwise.
+func Value(v string) string {
+	if bareIdentRe.MatchString(v) || bareNumberRe.MatchString(v) {
+		return v
+	}
+	return quote(v)
+}
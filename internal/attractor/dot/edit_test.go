@@ -0,0 +1,48 @@
+package dot
+
+import (
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+func TestApplyEdits_ReplacesAndInsertsByPosition(t *testing.T) {
+	src := []byte("digraph G {\n  a [x=1] // keep\n}\n")
+	pos := func(line, col int) model.Position { return model.Position{Line: line, Column: col} }
+	out, err := ApplyEdits(src, []Edit{
+		{Span: model.Span{Start: pos(2, 6), End: pos(2, 9)}, NewText: "x=2"},
+		Insert("", pos(2, 9), ", y=3"),
+		Insert("", pos(3, 1), "  a -> b\n"),
+	})
+	if err != nil {
+		t.Fatalf("ApplyEdits: %v", err)
+	}
+	want := "digraph G {\n  a [x=2, y=3] // keep\n  a -> b\n}\n"
+	if string(out) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	_, err = ApplyEdits(src, []Edit{
+		{Span: model.Span{Start: pos(2, 3), End: pos(2, 9)}, NewText: "b"},
+		{Span: model.Span{Start: pos(2, 6), End: pos(2, 10)}, NewText: "c"},
+	})
+	if err == nil {
+		t.Fatal("expected overlapping edits to be rejected")
+	}
+	if _, err := ApplyEdits(src, []Edit{Insert("", pos(9, 1), "x")}); err == nil {
+		t.Fatal("expected an edit outside the source to be rejected")
+	}
+}
+
+func TestValue_QuotesOnlyWhenNeeded(t *testing.T) {
+	for in, want := range map[string]string{
+		"impl":         "impl",
+		"42":           "42",
+		"outcome=fail": `"outcome=fail"`,
+		"say \"hi\"":   `"say \"hi\""`,
+	} {
+		if got := Value(in); got != want {
+			t.Fatalf("Value(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
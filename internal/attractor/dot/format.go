@@ -0,0 +1,731 @@
+package dot
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// maxLineWidth is the widest an attr list may get before Format breaks it
+// onto one line per attr.
+const maxLineWidth = 100
+
+// Format rewrites DOT source in the canonical Attractor layout:
+//
+//   - graph attrs (graph [...] and key = value at the top level) are merged
+//     into one graph [...] block at the top of the digraph;
+//   - attrs are ordered by kind: shape, type, import, label and class first
+//     for nodes, label, condition and weight first for edges, the rest
+//     alphabetically, with long text (prompts, commands, the stylesheet) last;
+//   - values are bare when they are identifiers or numbers, and quoted
+//     otherwise; multi-line strings are split after each \n into "..." + "..."
+//     pieces, one per line;
+//   - indentation is two spaces, semicolons are dropped and runs of blank
+//     lines collapse to one.
+//
+// Comments are kept next to the statement or attr they annotate, and
+// statements stay in source order, since node and edge defaults only apply
+// to what follows them. Format re-parses its output and fails rather than
+// return a file whose graph differs from the input's.
+func Format(src []byte) ([]byte, error) {
+	want, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	clean, comments, err := scanComments(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &cstParser{parser: &parser{lx: newLexer("", clean)}}
+	file, err := p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+	f := &formatter{lx: p.lx}
+	f.attachBody(file, comments)
+
+	pr := &printer{}
+	pr.body(file, 0, false)
+	out := pr.buf.Bytes()
+
+	got, err := Parse(out)
+	if err != nil {
+		return nil, fmt.Errorf("dot format: output does not parse: %w", err)
+	}
+	if err := sameGraph(want, got); err != nil {
+		return nil, fmt.Errorf("dot format: output changes the graph: %w", err)
+	}
+	return out, nil
+}
+
+type stmtKind int
+
+const (
+	stmtGraph        stmtKind = iota // digraph name { ... }
+	stmtGraphAttrs                   // graph [...]
+	stmtNodeDefaults                 // node [...]
+	stmtEdgeDefaults                 // edge [...]
+	stmtAssign                       // key = value
+	stmtSubgraph                     // subgraph name { ... }
+	stmtNode                         // id [...]
+	stmtEdge                         // a -> b -> c [...]
+)
+
+// fmtStmt is one statement as written, with the comments around it.
+type fmtStmt struct {
+	kind stmtKind
+	ids  []string // graph/subgraph name, node ID, edge chain or assigned key
+	val  string   // assigned value
+	list *fmtAttrList
+	body *fmtBody
+
+	start, end int
+	blank      bool // preceded by an empty line
+	lead       []fmtComment
+	leadGap    bool // an empty line between the lead comments and the statement
+	trail      string
+}
+
+type fmtBody struct {
+	stmts       []*fmtStmt
+	open, close int // offsets just past "{" and of "}"
+	dangling    []fmtComment
+}
+
+type fmtAttrList struct {
+	attrs       []*fmtAttr
+	open, close int // offsets just past "[" and of "]"
+	dangling    []fmtComment
+}
+
+type fmtAttr struct {
+	key, val   string
+	start, end int
+	blank      bool
+	lead       []fmtComment
+	leadGap    bool
+	trail      string
+}
+
+type fmtComment struct {
+	text  string
+	blank bool
+}
+
+// cstParser parses the same grammar as parser, but keeps statements and
+// attrs in source order with their offsets instead of building a graph.
+type cstParser struct {
+	*parser
+}
+
+func (p *cstParser) parseFile() (*fmtBody, error) {
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	start := p.peek.pos
+	if err := p.expectIdent("digraph"); err != nil {
+		return nil, err
+	}
+	name, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("{"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("}"); err != nil {
+		return nil, err
+	}
+	g := &fmtStmt{kind: stmtGraph, ids: []string{name.lit}, body: body, start: start, end: p.lastEnd}
+	_ = p.consumeOptionalSemicolon()
+	return &fmtBody{stmts: []*fmtStmt{g}, close: len(p.lx.src)}, nil
+}
+
+func (p *cstParser) parseBody() (*fmtBody, error) {
+	b := &fmtBody{open: p.lastEnd}
+	for {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		if p.peek.typ == tokenEOF {
+			return nil, p.lx.errorAt(p.peek.pos, "dot parse: unexpected EOF (missing '}')")
+		}
+		if p.peek.typ == tokenSymbol && p.peek.lit == "}" {
+			b.close = p.peek.pos
+			return b, nil
+		}
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		s := &fmtStmt{start: tok.pos}
+		switch tok.lit {
+		case "graph", "node", "edge":
+			s.kind = map[string]stmtKind{"graph": stmtGraphAttrs, "node": stmtNodeDefaults, "edge": stmtEdgeDefaults}[tok.lit]
+			if s.list, err = p.parseList(); err != nil {
+				return nil, err
+			}
+		case "subgraph":
+			s.kind = stmtSubgraph
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			if p.peek.typ == tokenIdent {
+				name, _ := p.next()
+				s.ids = []string{name.lit}
+			}
+			if err := p.expectSymbol("{"); err != nil {
+				return nil, err
+			}
+			if s.body, err = p.parseBody(); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol("}"); err != nil {
+				return nil, err
+			}
+		default:
+			s.ids = []string{tok.lit}
+			if err := p.read(); err != nil {
+				return nil, err
+			}
+			switch {
+			case p.peek.typ == tokenSymbol && p.peek.lit == "=":
+				s.kind = stmtAssign
+				_, _ = p.next()
+				if s.val, err = p.parseTopLevelValue(); err != nil {
+					return nil, err
+				}
+			case p.peek.typ == tokenSymbol && p.peek.lit == "->":
+				s.kind = stmtEdge
+				for p.peek.typ == tokenSymbol && p.peek.lit == "->" {
+					_, _ = p.next()
+					to, err := p.next()
+					if err != nil {
+						return nil, err
+					}
+					s.ids = append(s.ids, to.lit)
+					if err := p.read(); err != nil {
+						return nil, err
+					}
+				}
+			default:
+				s.kind = stmtNode
+			}
+			if s.kind != stmtAssign && p.peek.typ == tokenSymbol && p.peek.lit == "[" {
+				if s.list, err = p.parseList(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		s.end = p.lastEnd
+		b.stmts = append(b.stmts, s)
+		_ = p.consumeOptionalSemicolon()
+	}
+}
+
+func (p *cstParser) parseList() (*fmtAttrList, error) {
+	if err := p.expectSymbol("["); err != nil {
+		return nil, err
+	}
+	l := &fmtAttrList{open: p.lastEnd}
+	for {
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		if p.peek.typ == tokenSymbol && p.peek.lit == "]" {
+			l.close = p.peek.pos
+			_, _ = p.next()
+			return l, nil
+		}
+		a := &fmtAttr{start: p.peek.pos}
+		var err error
+		if a.key, err = p.parseQualifiedKey(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		if a.val, err = p.parseAttrValue(); err != nil {
+			return nil, err
+		}
+		a.end = p.lastEnd
+		l.attrs = append(l.attrs, a)
+		if err := p.read(); err != nil {
+			return nil, err
+		}
+		if p.peek.typ == tokenSymbol && p.peek.lit == "," {
+			_, _ = p.next()
+		}
+	}
+}
+
+// formatter attaches comments to the statements and attrs they annotate.
+type formatter struct {
+	lx *lexer
+}
+
+func (f *formatter) line(off int) int {
+	return f.lx.position(off).Line
+}
+
+// placement says where each comment in a container goes: before an item
+// (lead), after it on the same line (trail), inside it, or before the
+// container's closing bracket (dangling).
+type placement struct {
+	lead     [][]fmtComment
+	leadGap  []bool
+	trail    []string
+	inside   [][]comment
+	blank    []bool
+	dangling []fmtComment
+}
+
+func (f *formatter) place(cs []comment, open int, starts, ends []int) placement {
+	n := len(starts)
+	pl := placement{
+		lead:    make([][]fmtComment, n),
+		leadGap: make([]bool, n),
+		trail:   make([]string, n),
+		inside:  make([][]comment, n),
+		blank:   make([]bool, n),
+	}
+	last := f.line(open)
+	k := 0
+	done := func() {
+		gap := f.line(starts[k])-last > 1
+		if len(pl.lead[k]) == 0 {
+			pl.blank[k] = gap
+		} else {
+			pl.leadGap[k] = gap
+		}
+		last = f.line(ends[k] - 1)
+		k++
+	}
+	for _, c := range cs {
+		for k < n && ends[k] <= c.start {
+			done()
+		}
+		if k < n && starts[k] <= c.start {
+			pl.inside[k] = append(pl.inside[k], c)
+			continue
+		}
+		first, lastLine := f.line(c.start), f.line(c.end-1)
+		pending := len(pl.dangling) > 0
+		if k < n {
+			pending = len(pl.lead[k]) > 0
+		}
+		if k > 0 && !pending && pl.trail[k-1] == "" && first == last && first == lastLine {
+			pl.trail[k-1] = c.text
+			continue
+		}
+		fc := fmtComment{text: c.text, blank: first-last > 1}
+		last = lastLine
+		if k == n {
+			pl.dangling = append(pl.dangling, fc)
+			continue
+		}
+		if len(pl.lead[k]) == 0 {
+			pl.blank[k], fc.blank = fc.blank, false
+		}
+		pl.lead[k] = append(pl.lead[k], fc)
+	}
+	for k < n {
+		done()
+	}
+	return pl
+}
+
+func (f *formatter) attachBody(b *fmtBody, cs []comment) {
+	starts, ends := make([]int, len(b.stmts)), make([]int, len(b.stmts))
+	for i, s := range b.stmts {
+		starts[i], ends[i] = s.start, s.end
+	}
+	pl := f.place(cs, b.open, starts, ends)
+	b.dangling = pl.dangling
+	for i, s := range b.stmts {
+		s.lead, s.leadGap, s.trail, s.blank = pl.lead[i], pl.leadGap[i], pl.trail[i], pl.blank[i]
+		var inList, inBody []comment
+		for _, c := range pl.inside[i] {
+			switch {
+			case s.list != nil && c.start >= s.list.open && c.start < s.list.close:
+				inList = append(inList, c)
+			case s.body != nil && c.start >= s.body.open && c.start < s.body.close:
+				inBody = append(inBody, c)
+			default:
+				// Between the tokens of the statement itself.
+				s.lead = append(s.lead, fmtComment{text: c.text})
+			}
+		}
+		if s.list != nil {
+			f.attachList(s.list, inList)
+		}
+		if s.body != nil {
+			f.attachBody(s.body, inBody)
+		}
+	}
+}
+
+func (f *formatter) attachList(l *fmtAttrList, cs []comment) {
+	starts, ends := make([]int, len(l.attrs)), make([]int, len(l.attrs))
+	for i, a := range l.attrs {
+		starts[i], ends[i] = a.start, a.end
+	}
+	pl := f.place(cs, l.open, starts, ends)
+	l.dangling = pl.dangling
+	for i, a := range l.attrs {
+		a.lead, a.leadGap, a.trail, a.blank = pl.lead[i], pl.leadGap[i], pl.trail[i], pl.blank[i]
+		for _, c := range pl.inside[i] {
+			a.lead = append(a.lead, fmtComment{text: c.text})
+		}
+	}
+}
+
+// Attr ordering: these keys come first, in this order, then the rest
+// alphabetically, then the long-text keys.
+var (
+	nodeFirstKeys  = []string{"shape", "type", "import", "label", "class"}
+	edgeFirstKeys  = []string{"label", "condition", "weight"}
+	graphFirstKeys = []string{"goal", "label"}
+	lastKeys       = []string{"prompt", "llm_prompt", "tool_command", "model_stylesheet"}
+)
+
+func firstKeysFor(kind stmtKind) []string {
+	switch kind {
+	case stmtNode, stmtNodeDefaults:
+		return nodeFirstKeys
+	case stmtEdge, stmtEdgeDefaults:
+		return edgeFirstKeys
+	default:
+		return graphFirstKeys
+	}
+}
+
+// canonicalAttrs drops all but the last of each repeated key (the one that
+// takes effect), keeping the comments of the dropped ones, and sorts.
+func canonicalAttrs(attrs []*fmtAttr, kind stmtKind) []*fmtAttr {
+	byKey := map[string]*fmtAttr{}
+	var out []*fmtAttr
+	for _, a := range attrs {
+		if prev := byKey[a.key]; prev != nil {
+			prev.val = a.val
+			prev.lead = append(prev.lead, a.lead...)
+			if a.trail != "" {
+				prev.trail = strings.TrimSpace(prev.trail + " " + a.trail)
+			}
+			continue
+		}
+		cp := *a
+		byKey[a.key] = &cp
+		out = append(out, &cp)
+	}
+	first := firstKeysFor(kind)
+	rank := func(key string) int {
+		for i, k := range first {
+			if k == key {
+				return i
+			}
+		}
+		for i, k := range lastKeys {
+			if k == key {
+				return len(first) + 1 + i
+			}
+		}
+		return len(first)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		ri, rj := rank(out[i].key), rank(out[j].key)
+		if ri != rj {
+			return ri < rj
+		}
+		return out[i].key < out[j].key
+	})
+	return out
+}
+
+var (
+	bareIdentRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	bareNumberRe = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?[A-Za-z]*$`)
+)
+
+// valuePieces renders v as one bare or quoted token, or, for multi-line
+// strings, as quoted pieces split after each newline (joined with "+").
+func valuePieces(v string) []string {
+	if bareIdentRe.MatchString(v) || bareNumberRe.MatchString(v) {
+		return []string{v}
+	}
+	var pieces []string
+	for v != "" {
+		i := strings.IndexByte(v, '\n')
+		if i < 0 {
+			i = len(v) - 1
+		}
+		pieces = append(pieces, quote(v[:i+1]))
+		v = v[i+1:]
+	}
+	if len(pieces) == 0 {
+		pieces = []string{`""`}
+	}
+	return pieces
+}
+
+// quote writes s as a DOT string. A backslash the lexer would keep verbatim
+// (as in Graphviz's \l) is left alone; every other one is escaped.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '"':
+			b.WriteString(`\"`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\\':
+			if i+1 < len(s) && !strings.ContainsRune("\"\\nt\n\t", rune(s[i+1])) {
+				b.WriteByte('\\')
+			} else {
+				b.WriteString(`\\`)
+			}
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+type printer struct {
+	buf bytes.Buffer
+}
+
+func (pr *printer) writeLine(depth int, s string) {
+	if s != "" {
+		pr.buf.WriteString(strings.Repeat("  ", depth))
+	}
+	pr.buf.WriteString(s)
+	pr.buf.WriteByte('\n')
+}
+
+// comments writes comments on their own lines. Block comments spanning lines
+// are written as-is after the first line; re-indenting them could change
+// their meaning (e.g. a commented-out prompt).
+func (pr *printer) comments(depth int, cs []fmtComment, first bool) {
+	for i, c := range cs {
+		if c.blank && !(first && i == 0) {
+			pr.writeLine(0, "")
+		}
+		lines := strings.Split(c.text, "\n")
+		pr.writeLine(depth, strings.TrimRight(lines[0], " \t\r"))
+		for _, l := range lines[1:] {
+			pr.writeLine(0, strings.TrimRight(l, " \t\r"))
+		}
+	}
+}
+
+// lines writes statement lines, adding the trailing comment to the last.
+func (pr *printer) lines(depth int, ls []string, trail string) {
+	if trail != "" {
+		ls[len(ls)-1] += " " + trail
+	}
+	for _, l := range ls {
+		pr.writeLine(depth, l)
+	}
+}
+
+func (pr *printer) body(b *fmtBody, depth int, inGraph bool) {
+	stmts := b.stmts
+	if inGraph {
+		stmts = hoistGraphAttrs(stmts)
+	}
+	for i, s := range stmts {
+		if s.blank && i > 0 {
+			pr.writeLine(0, "")
+		}
+		pr.comments(depth, s.lead, i == 0)
+		if s.leadGap {
+			pr.writeLine(0, "")
+		}
+		pr.stmt(s, depth)
+	}
+	if len(b.dangling) > 0 {
+		pr.comments(depth, b.dangling, len(stmts) == 0)
+	}
+}
+
+// hoistGraphAttrs merges the top-level graph attr statements into one
+// graph [...] statement at the front, followed by a blank line.
+func hoistGraphAttrs(stmts []*fmtStmt) []*fmtStmt {
+	hoisted := &fmtStmt{kind: stmtGraphAttrs, list: &fmtAttrList{}}
+	var rest []*fmtStmt
+	for _, s := range stmts {
+		switch s.kind {
+		case stmtAssign:
+			hoisted.list.attrs = append(hoisted.list.attrs, &fmtAttr{key: s.ids[0], val: s.val, lead: s.lead, trail: s.trail})
+		case stmtGraphAttrs:
+			if len(s.list.attrs) == 0 {
+				hoisted.list.dangling = append(hoisted.list.dangling, s.lead...)
+				hoisted.list.dangling = append(hoisted.list.dangling, s.list.dangling...)
+				if s.trail != "" {
+					hoisted.list.dangling = append(hoisted.list.dangling, fmtComment{text: s.trail})
+				}
+				continue
+			}
+			attrs := s.list.attrs
+			attrs[0].lead = append(append([]fmtComment{}, s.lead...), attrs[0].lead...)
+			last := attrs[len(attrs)-1]
+			if s.trail != "" && last.trail == "" {
+				last.trail = s.trail
+			} else if s.trail != "" {
+				hoisted.list.dangling = append(hoisted.list.dangling, fmtComment{text: s.trail})
+			}
+			hoisted.list.attrs = append(hoisted.list.attrs, attrs...)
+			hoisted.list.dangling = append(hoisted.list.dangling, s.list.dangling...)
+		default:
+			rest = append(rest, s)
+		}
+	}
+	if len(hoisted.list.attrs) == 0 && len(hoisted.list.dangling) == 0 {
+		return rest
+	}
+	for _, a := range hoisted.list.attrs {
+		a.blank = false
+	}
+	if len(rest) > 0 {
+		rest[0].blank = true
+	}
+	return append([]*fmtStmt{hoisted}, rest...)
+}
+
+func (pr *printer) stmt(s *fmtStmt, depth int) {
+	switch s.kind {
+	case stmtGraph, stmtSubgraph:
+		head := "digraph " + s.ids[0]
+		if s.kind == stmtSubgraph {
+			head = strings.TrimSpace("subgraph " + strings.Join(s.ids, ""))
+		}
+		if len(s.body.stmts) == 0 && len(s.body.dangling) == 0 {
+			pr.lines(depth, []string{head + " {}"}, s.trail)
+			return
+		}
+		pr.writeLine(depth, head+" {")
+		pr.body(s.body, depth+1, s.kind == stmtGraph)
+		pr.lines(depth, []string{"}"}, s.trail)
+	case stmtGraphAttrs, stmtNodeDefaults, stmtEdgeDefaults:
+		head := map[stmtKind]string{stmtGraphAttrs: "graph", stmtNodeDefaults: "node", stmtEdgeDefaults: "edge"}[s.kind]
+		pr.attrStmt(depth, head, s.list, s.kind, true, s.trail)
+	case stmtAssign:
+		pr.lines(depth, assignLines(s.ids[0], valuePieces(s.val), ""), s.trail)
+	case stmtNode:
+		pr.attrStmt(depth, s.ids[0], s.list, s.kind, false, s.trail)
+	case stmtEdge:
+		pr.attrStmt(depth, strings.Join(s.ids, " -> "), s.list, s.kind, false, s.trail)
+	}
+}
+
+// assignLines renders key=value, continuing multi-line values on following
+// lines indented one level further.
+func assignLines(key string, pieces []string, suffix string) []string {
+	ls := []string{key + "=" + pieces[0]}
+	for _, p := range pieces[1:] {
+		ls = append(ls, "  + "+p)
+	}
+	ls[len(ls)-1] += suffix
+	return ls
+}
+
+func (pr *printer) attrStmt(depth int, head string, l *fmtAttrList, kind stmtKind, keepEmpty bool, trail string) {
+	if l == nil {
+		l = &fmtAttrList{}
+	}
+	attrs := canonicalAttrs(l.attrs, kind)
+	if len(attrs) == 0 && len(l.dangling) == 0 {
+		if keepEmpty {
+			head += " []"
+		}
+		pr.lines(depth, []string{head}, trail)
+		return
+	}
+
+	oneLine := len(l.dangling) == 0
+	var parts []string
+	for _, a := range attrs {
+		pieces := valuePieces(a.val)
+		if len(pieces) > 1 || len(a.lead) > 0 || a.trail != "" {
+			oneLine = false
+			break
+		}
+		parts = append(parts, a.key+"="+pieces[0])
+	}
+	if oneLine {
+		line := head + " [" + strings.Join(parts, ", ") + "]"
+		if 2*depth+len(line)+len(trail) <= maxLineWidth {
+			pr.lines(depth, []string{line}, trail)
+			return
+		}
+	}
+
+	pr.writeLine(depth, head+" [")
+	for i, a := range attrs {
+		if a.blank && i > 0 {
+			pr.writeLine(0, "")
+		}
+		pr.comments(depth+1, a.lead, i == 0)
+		if a.leadGap {
+			pr.writeLine(0, "")
+		}
+		suffix := ","
+		if i == len(attrs)-1 {
+			suffix = ""
+		}
+		pr.lines(depth+1, assignLines(a.key, valuePieces(a.val), suffix), a.trail)
+	}
+	pr.comments(depth+1, l.dangling, len(attrs) == 0)
+	pr.lines(depth, []string{"]"}, trail)
+}
+
+// sameGraph reports the first difference between two parsed graphs,
+// ignoring source spans.
+func sameGraph(a, b *model.Graph) error {
+	if a.Name != b.Name {
+		return fmt.Errorf("graph name %q != %q", a.Name, b.Name)
+	}
+	if !reflect.DeepEqual(a.Attrs, b.Attrs) {
+		return fmt.Errorf("graph attrs differ")
+	}
+	if len(a.Nodes) != len(b.Nodes) {
+		return fmt.Errorf("%d nodes != %d nodes", len(a.Nodes), len(b.Nodes))
+	}
+	for id, an := range a.Nodes {
+		bn := b.Nodes[id]
+		switch {
+		case bn == nil:
+			return fmt.Errorf("node %q missing", id)
+		case an.Order != bn.Order:
+			return fmt.Errorf("node %q moved", id)
+		case !reflect.DeepEqual(an.Attrs, bn.Attrs):
+			return fmt.Errorf("node %q attrs differ", id)
+		case !reflect.DeepEqual(an.ClassList(), bn.ClassList()):
+			return fmt.Errorf("node %q classes differ", id)
+		}
+	}
+	if len(a.Edges) != len(b.Edges) {
+		return fmt.Errorf("%d edges != %d edges", len(a.Edges), len(b.Edges))
+	}
+	for i, ae := range a.Edges {
+		be := b.Edges[i]
+		if ae.From != be.From || ae.To != be.To || !reflect.DeepEqual(ae.Attrs, be.Attrs) {
+			return fmt.Errorf("edge %d (%s -> %s) differs", i, ae.From, ae.To)
+		}
+	}
+	return nil
+}
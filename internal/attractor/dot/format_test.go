@@ -0,0 +1,113 @@
+package dot
+
+import "testing"
+
+func TestFormat_CanonicalLayoutKeepsComments(t *testing.T) {
+	src := []byte(`// Release pipeline.
+
+digraph Release {
+  rankdir=LR
+  graph [model_stylesheet="* { llm_model: gpt-5.2; }", goal="Ship it", label=Release]   // top
+  node [llm_provider=openai, shape=box]
+
+  start [shape=Mdiamond];
+  exit [shape=Msquare]
+  /* the implementation stage */
+  impl [prompt="Do the thing.\nThen test it.\n", llm_model=gpt-5.2, label="Implement", max_retries=2]
+  review [
+    // what to check
+    prompt="Review \"it\"",
+    shape=box,   // always a box
+  ]
+  subgraph cluster_loop { label = "Loop"
+    t [tool_command="make test", shape=parallelogram]
+  }
+  start -> impl -> review
+  review -> t [condition="outcome=success", label=ok]
+  t -> exit
+  // trailing note
+}
+`)
+	want := `// Release pipeline.
+
+digraph Release {
+  graph [
+    goal="Ship it",
+    label=Release, // top
+    rankdir=LR,
+    model_stylesheet="* { llm_model: gpt-5.2; }"
+  ]
+
+  node [shape=box, llm_provider=openai]
+
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  /* the implementation stage */
+  impl [
+    label=Implement,
+    llm_model="gpt-5.2",
+    max_retries=2,
+    prompt="Do the thing.\n"
+      + "Then test it.\n"
+  ]
+  review [
+    shape=box, // always a box
+    // what to check
+    prompt="Review \"it\""
+  ]
+  subgraph cluster_loop {
+    label=Loop
+    t [shape=parallelogram, tool_command="make test"]
+  }
+  start -> impl -> review
+  review -> t [label=ok, condition="outcome=success"]
+  t -> exit
+  // trailing note
+}
+`
+	got, err := Format(src)
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("Format output:\n%s\nwant:\n%s", got, want)
+	}
+	again, err := Format(got)
+	if err != nil || string(again) != string(got) {
+		t.Fatalf("Format is not idempotent (err=%v):\n%s", err, again)
+	}
+
+	g, err := Parse(got)
+	if err != nil {
+		t.Fatalf("Parse(formatted): %v", err)
+	}
+	if p := g.Nodes["impl"].Attrs["prompt"]; p != "Do the thing.\nThen test it.\n" {
+		t.Fatalf("concatenated prompt = %q", p)
+	}
+}
+
+func TestFormat_EscapesRoundTrip(t *testing.T) {
+	for _, val := range []string{
+		`plain`, `-3`, `900s`, `gpt-5.2`, ``, `a "quoted" word`, `tab	here`,
+		`back\slash`, `trailing\`, `\l left-justified`, `a\"b`, `C:\\dir\n`, "two\nlines", "ends\n\n",
+	} {
+		src := "digraph G {\n  a [prompt=" + quote(val) + "]\n}\n"
+		out, err := Format([]byte(src))
+		if err != nil {
+			t.Fatalf("%q: Format: %v\n%s", val, err, src)
+		}
+		g, err := Parse(out)
+		if err != nil {
+			t.Fatalf("%q: Parse: %v", val, err)
+		}
+		if got := g.Nodes["a"].Attrs["prompt"]; got != val {
+			t.Fatalf("round trip: got %q want %q\n%s", got, val, out)
+		}
+	}
+}
+
+func TestFormat_SyntaxErrorIsReported(t *testing.T) {
+	if _, err := Format([]byte(`digraph G { a -> }`)); err == nil {
+		t.Fatalf("expected syntax error")
+	}
+}
@@ -2,7 +2,10 @@ package dot
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
 )
 
 type tokenType int
@@ -18,40 +21,78 @@ type token struct {
 	typ tokenType
 	lit string
 	pos int // byte offset in source (for diagnostics)
+	end int // byte offset just past the token
+}
+
+// SyntaxError is returned by Parse for malformed DOT input. Span locates the
+// offending token so editors and CI annotations can point at it.
+type SyntaxError struct {
+	Span model.Span
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at %s", e.Msg, e.Span)
 }
 
 type lexer struct {
-	src []byte
-	i   int
+	src  []byte
+	i    int
+	file string
+
+	lineStarts []int // byte offset of the first byte of each line
+}
+
+func newLexer(file string, src []byte) *lexer {
+	l := &lexer{src: src, file: file, lineStarts: []int{0}}
+	for i, b := range src {
+		if b == '\n' {
+			l.lineStarts = append(l.lineStarts, i+1)
+		}
+	}
+	return l
+}
+
+// position converts a byte offset into a 1-based line and column.
+func (l *lexer) position(off int) model.Position {
+	line := sort.Search(len(l.lineStarts), func(i int) bool { return l.lineStarts[i] > off }) - 1
+	if line < 0 {
+		line = 0
+	}
+	return model.Position{Line: line + 1, Column: off - l.lineStarts[line] + 1}
+}
+
+func (l *lexer) span(start, end int) model.Span {
+	return model.Span{File: l.file, Start: l.position(start), End: l.position(end)}
 }
 
-func newLexer(src []byte) *lexer {
-	return &lexer{src: src}
+func (l *lexer) errorAt(off int, format string, args ...any) error {
+	return &SyntaxError{Span: l.span(off, off), Msg: fmt.Sprintf(format, args...)}
 }
 
 func (l *lexer) next() (token, error) {
 	l.skipSpace()
 	if l.i >= len(l.src) {
-		return token{typ: tokenEOF, pos: l.i}, nil
+		return token{typ: tokenEOF, pos: l.i, end: l.i}, nil
 	}
 
 	ch := l.src[l.i]
 
 	// Symbols and operators.
 	switch ch {
-	case '{', '}', '[', ']', ',', ';', '=', '.', ':', '/':
+	case '{', '}', '[', ']', ',', ';', '=', '.', ':', '/', '+':
 		l.i++
-		return token{typ: tokenSymbol, lit: string(ch), pos: l.i - 1}, nil
+		return token{typ: tokenSymbol, lit: string(ch), pos: l.i - 1, end: l.i}, nil
 	case '-':
 		// Could be "->" or a negative number/duration.
 		if l.i+1 < len(l.src) && l.src[l.i+1] == '>' {
 			l.i += 2
-			return token{typ: tokenSymbol, lit: "->", pos: l.i - 2}, nil
+			return token{typ: tokenSymbol, lit: "->", pos: l.i - 2, end: l.i}, nil
 		}
 		// Treat '-' as a symbol so we can accept unquoted values like "claude-opus-4-6"
 		// and also negative numbers (assembled by the parser).
 		l.i++
-		return token{typ: tokenSymbol, lit: "-", pos: l.i - 1}, nil
+		return token{typ: tokenSymbol, lit: "-", pos: l.i - 1, end: l.i}, nil
 	case '"':
 		return l.lexString()
 	}
@@ -64,7 +105,7 @@ func (l *lexer) next() (token, error) {
 		return l.lexBareNumberish()
 	}
 
-	return token{}, fmt.Errorf("dot lexer: unexpected character %q at %d", ch, l.i)
+	return token{}, l.errorAt(l.i, "dot lexer: unexpected character %q", ch)
 }
 
 func (l *lexer) skipSpace() {
@@ -89,7 +130,7 @@ func (l *lexer) lexIdent() (token, error) {
 		}
 		break
 	}
-	return token{typ: tokenIdent, lit: string(l.src[start:l.i]), pos: start}, nil
+	return token{typ: tokenIdent, lit: string(l.src[start:l.i]), pos: start, end: l.i}, nil
 }
 
 func (l *lexer) lexBareNumberish() (token, error) {
@@ -101,7 +142,7 @@ func (l *lexer) lexBareNumberish() (token, error) {
 	if l.i < len(l.src) && l.src[l.i] == '.' {
 		l.i++
 		if l.i >= len(l.src) || !isDigit(l.src[l.i]) {
-			return token{}, fmt.Errorf("dot lexer: malformed float at %d", start)
+			return token{}, l.errorAt(start, "dot lexer: malformed float")
 		}
 		for l.i < len(l.src) && isDigit(l.src[l.i]) {
 			l.i++
@@ -110,7 +151,7 @@ func (l *lexer) lexBareNumberish() (token, error) {
 	for l.i < len(l.src) && isAlpha(l.src[l.i]) {
 		l.i++
 	}
-	return token{typ: tokenIdent, lit: string(l.src[start:l.i]), pos: start}, nil
+	return token{typ: tokenIdent, lit: string(l.src[start:l.i]), pos: start, end: l.i}, nil
 }
 
 func (l *lexer) lexString() (token, error) {
@@ -122,11 +163,11 @@ func (l *lexer) lexString() (token, error) {
 		ch := l.src[l.i]
 		l.i++
 		if ch == '"' {
-			return token{typ: tokenString, lit: sb.String(), pos: start}, nil
+			return token{typ: tokenString, lit: sb.String(), pos: start, end: l.i}, nil
 		}
 		if ch == '\\' {
 			if l.i >= len(l.src) {
-				return token{}, fmt.Errorf("dot lexer: unterminated escape at %d", l.i)
+				return token{}, l.errorAt(l.i, "dot lexer: unterminated escape")
 			}
 			esc := l.src[l.i]
 			l.i++
@@ -148,7 +189,7 @@ func (l *lexer) lexString() (token, error) {
 		}
 		sb.WriteByte(ch)
 	}
-	return token{}, fmt.Errorf("dot lexer: unterminated string starting at %d", start)
+	return token{}, l.errorAt(start, "dot lexer: unterminated string")
 }
 
 func isIdentStart(r rune) bool {
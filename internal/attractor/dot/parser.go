@@ -11,12 +11,21 @@ import (
 // It strips comments, flattens subgraphs, applies scoped node/edge defaults,
 // expands chained edges, and derives CSS-like classes from subgraph labels.
 func Parse(dotSource []byte) (*model.Graph, error) {
+	return ParseFile("", dotSource)
+}
+
+// ParseFile is Parse for source read from filename. The name is recorded in the
+// Span of every graph element and attribute and in syntax errors.
+func ParseFile(filename string, dotSource []byte) (*model.Graph, error) {
 	clean, err := stripComments(dotSource)
 	if err != nil {
+		if filename != "" {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
 		return nil, err
 	}
 	p := &parser{
-		lx: newLexer(clean),
+		lx: newLexer(filename, clean),
 	}
 	if err := p.read(); err != nil {
 		return nil, err
@@ -28,6 +37,8 @@ type parser struct {
 	lx   *lexer
 	peek token
 	has  bool
+
+	lastEnd int // end offset of the most recently consumed token
 }
 
 func (p *parser) read() error {
@@ -49,6 +60,7 @@ func (p *parser) next() (token, error) {
 	}
 	tok := p.peek
 	p.has = false
+	p.lastEnd = tok.end
 	return tok, nil
 }
 
@@ -58,7 +70,7 @@ func (p *parser) expectSymbol(sym string) error {
 		return err
 	}
 	if tok.typ != tokenSymbol || tok.lit != sym {
-		return fmt.Errorf("dot parse: expected %q, got %q at %d", sym, tok.lit, tok.pos)
+		return p.lx.errorAt(tok.pos, "dot parse: expected %q, got %q", sym, tok.lit)
 	}
 	return nil
 }
@@ -69,13 +81,17 @@ func (p *parser) expectIdent(lit string) error {
 		return err
 	}
 	if tok.typ != tokenIdent || tok.lit != lit {
-		return fmt.Errorf("dot parse: expected %q, got %q at %d", lit, tok.lit, tok.pos)
+		return p.lx.errorAt(tok.pos, "dot parse: expected %q, got %q", lit, tok.lit)
 	}
 	return nil
 }
 
 func (p *parser) parseGraph() (*model.Graph, error) {
 	// digraph <Identifier> { ... }
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	start := p.peek.pos
 	if err := p.expectIdent("digraph"); err != nil {
 		return nil, err
 	}
@@ -84,7 +100,7 @@ func (p *parser) parseGraph() (*model.Graph, error) {
 		return nil, err
 	}
 	if nameTok.typ != tokenIdent {
-		return nil, fmt.Errorf("dot parse: expected graph identifier, got %q at %d", nameTok.lit, nameTok.pos)
+		return nil, p.lx.errorAt(nameTok.pos, "dot parse: expected graph identifier, got %q", nameTok.lit)
 	}
 	g := model.NewGraph(nameTok.lit)
 	if err := p.expectSymbol("{"); err != nil {
@@ -98,6 +114,7 @@ func (p *parser) parseGraph() (*model.Graph, error) {
 	if err := p.expectSymbol("}"); err != nil {
 		return nil, err
 	}
+	g.Span = p.lx.span(start, p.lastEnd)
 	// Spec constraint: one digraph per file. Allow an optional trailing semicolon,
 	// then require EOF.
 	_ = p.consumeOptionalSemicolon()
@@ -105,7 +122,7 @@ func (p *parser) parseGraph() (*model.Graph, error) {
 		return nil, err
 	}
 	if p.peek.typ != tokenEOF {
-		return nil, fmt.Errorf("dot parse: trailing tokens after graph end at %d", p.peek.pos)
+		return nil, p.lx.errorAt(p.peek.pos, "dot parse: trailing tokens after graph end")
 	}
 	return g, nil
 }
@@ -115,16 +132,22 @@ type scope struct {
 	nodeDefaults map[string]string
 	edgeDefaults map[string]string
 
+	// Where each default was declared, so inherited attrs keep their source.
+	nodeDefaultSpans map[string]model.Span
+	edgeDefaultSpans map[string]model.Span
+
 	subgraphLabel string
 	nodeIDs       map[string]struct{} // nodes declared within this subgraph (including nested)
 }
 
 func newScope(parent *scope) *scope {
 	s := &scope{
-		parent:       parent,
-		nodeDefaults: map[string]string{},
-		edgeDefaults: map[string]string{},
-		nodeIDs:      map[string]struct{}{},
+		parent:           parent,
+		nodeDefaults:     map[string]string{},
+		edgeDefaults:     map[string]string{},
+		nodeDefaultSpans: map[string]model.Span{},
+		edgeDefaultSpans: map[string]model.Span{},
+		nodeIDs:          map[string]struct{}{},
 	}
 	if parent != nil {
 		for k, v := range parent.nodeDefaults {
@@ -133,6 +156,12 @@ func newScope(parent *scope) *scope {
 		for k, v := range parent.edgeDefaults {
 			s.edgeDefaults[k] = v
 		}
+		for k, v := range parent.nodeDefaultSpans {
+			s.nodeDefaultSpans[k] = v
+		}
+		for k, v := range parent.edgeDefaultSpans {
+			s.edgeDefaultSpans[k] = v
+		}
 	}
 	return s
 }
@@ -149,7 +178,7 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 			return err
 		}
 		if p.peek.typ == tokenEOF {
-			return fmt.Errorf("dot parse: unexpected EOF (missing '}')")
+			return p.lx.errorAt(p.peek.pos, "dot parse: unexpected EOF (missing '}')")
 		}
 		if p.peek.typ == tokenSymbol && p.peek.lit == "}" {
 			// end of this scope
@@ -165,37 +194,40 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 		}
 
 		if tok.typ != tokenIdent {
-			return fmt.Errorf("dot parse: expected identifier, got %q at %d", tok.lit, tok.pos)
+			return p.lx.errorAt(tok.pos, "dot parse: expected identifier, got %q", tok.lit)
 		}
 
 		switch tok.lit {
 		case "graph":
-			attrs, err := p.parseAttrBlock()
+			attrs, spans, err := p.parseAttrBlock()
 			if err != nil {
 				return err
 			}
 			for k, v := range attrs {
 				g.Attrs[k] = v
+				g.AttrSpans[k] = spans[k]
 			}
 			_ = p.consumeOptionalSemicolon()
 			continue
 		case "node":
-			attrs, err := p.parseAttrBlock()
+			attrs, spans, err := p.parseAttrBlock()
 			if err != nil {
 				return err
 			}
 			for k, v := range attrs {
 				sc.nodeDefaults[k] = v
+				sc.nodeDefaultSpans[k] = spans[k]
 			}
 			_ = p.consumeOptionalSemicolon()
 			continue
 		case "edge":
-			attrs, err := p.parseAttrBlock()
+			attrs, spans, err := p.parseAttrBlock()
 			if err != nil {
 				return err
 			}
 			for k, v := range attrs {
 				sc.edgeDefaults[k] = v
+				sc.edgeDefaultSpans[k] = spans[k]
 			}
 			_ = p.consumeOptionalSemicolon()
 			continue
@@ -244,6 +276,7 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 					sc.subgraphLabel = val
 				} else {
 					g.Attrs[tok.lit] = val
+					g.AttrSpans[tok.lit] = p.lx.span(tok.pos, p.lastEnd)
 				}
 				_ = p.consumeOptionalSemicolon()
 				continue
@@ -251,8 +284,7 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 
 			if p.peek.typ == tokenSymbol && p.peek.lit == "->" {
 				// Edge statement.
-				from := tok.lit
-				chain := []string{from}
+				chain := []token{tok}
 				for {
 					// consume ->
 					if _, err := p.next(); err != nil {
@@ -263,9 +295,9 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 						return err
 					}
 					if toTok.typ != tokenIdent {
-						return fmt.Errorf("dot parse: expected edge target identifier, got %q at %d", toTok.lit, toTok.pos)
+						return p.lx.errorAt(toTok.pos, "dot parse: expected edge target identifier, got %q", toTok.lit)
 					}
-					chain = append(chain, toTok.lit)
+					chain = append(chain, toTok)
 
 					if err := p.read(); err != nil {
 						return err
@@ -276,25 +308,33 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 				}
 
 				attrs := map[string]string{}
+				spans := map[string]model.Span{}
+				var list model.Span
 				if err := p.read(); err != nil {
 					return err
 				}
 				if p.peek.typ == tokenSymbol && p.peek.lit == "[" {
 					var err error
-					attrs, err = p.parseAttrBlock()
+					listStart := p.peek.pos
+					attrs, spans, err = p.parseAttrBlock()
 					if err != nil {
 						return err
 					}
+					list = p.lx.span(listStart, p.lastEnd)
 				}
 
 				for i := 0; i+1 < len(chain); i++ {
-					e := model.NewEdge(chain[i], chain[i+1])
+					e := model.NewEdge(chain[i].lit, chain[i+1].lit)
+					e.Span = p.lx.span(chain[i].pos, chain[i+1].end)
+					e.AttrList = list
 					// Defaults first, then explicit attrs.
 					for k, v := range sc.edgeDefaults {
 						e.Attrs[k] = v
+						e.AttrSpans[k] = sc.edgeDefaultSpans[k]
 					}
 					for k, v := range attrs {
 						e.Attrs[k] = v
+						e.AttrSpans[k] = spans[k]
 					}
 					if err := g.AddEdge(e); err != nil {
 						return err
@@ -307,21 +347,29 @@ func (p *parser) parseStatements(g *model.Graph, sc *scope) error {
 
 			// Node statement.
 			nodeAttrs := map[string]string{}
+			nodeSpans := map[string]model.Span{}
+			var list model.Span
 			if p.peek.typ == tokenSymbol && p.peek.lit == "[" {
 				var err error
-				nodeAttrs, err = p.parseAttrBlock()
+				listStart := p.peek.pos
+				nodeAttrs, nodeSpans, err = p.parseAttrBlock()
 				if err != nil {
 					return err
 				}
+				list = p.lx.span(listStart, p.lastEnd)
 			}
 
 			n := model.NewNode(tok.lit)
 			n.Order = len(g.Nodes)
+			n.Span = p.lx.span(tok.pos, p.lastEnd)
+			n.AttrList = list
 			for k, v := range sc.nodeDefaults {
 				n.Attrs[k] = v
+				n.AttrSpans[k] = sc.nodeDefaultSpans[k]
 			}
 			for k, v := range nodeAttrs {
 				n.Attrs[k] = v
+				n.AttrSpans[k] = nodeSpans[k]
 			}
 			if err := g.AddNode(n); err != nil {
 				return err
@@ -343,36 +391,41 @@ func (p *parser) consumeOptionalSemicolon() error {
 	return nil
 }
 
-func (p *parser) parseAttrBlock() (map[string]string, error) {
+// parseAttrBlock parses "[k=v, ...]" and returns the attrs with the span of
+// each "k=v" pair.
+func (p *parser) parseAttrBlock() (map[string]string, map[string]model.Span, error) {
 	if err := p.expectSymbol("["); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	attrs := map[string]string{}
+	spans := map[string]model.Span{}
 	for {
 		if err := p.read(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if p.peek.typ == tokenSymbol && p.peek.lit == "]" {
 			_, _ = p.next()
-			return attrs, nil
+			return attrs, spans, nil
 		}
 
+		start := p.peek.pos
 		key, err := p.parseQualifiedKey()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if err := p.expectSymbol("="); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		val, err := p.parseAttrValue()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		attrs[key] = val
+		spans[key] = p.lx.span(start, p.lastEnd)
 
 		// Next: ',' or ']'
 		if err := p.read(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if p.peek.typ == tokenSymbol && p.peek.lit == "," {
 			_, _ = p.next()
@@ -383,7 +436,7 @@ func (p *parser) parseAttrBlock() (map[string]string, error) {
 			continue
 		}
 		// Anything else is a syntax error.
-		return nil, fmt.Errorf("dot parse: expected ',' or ']', got %q at %d", p.peek.lit, p.peek.pos)
+		return nil, nil, p.lx.errorAt(p.peek.pos, "dot parse: expected ',' or ']', got %q", p.peek.lit)
 	}
 }
 
@@ -393,11 +446,7 @@ func (p *parser) parseAttrValue() (string, error) {
 		return "", err
 	}
 	if p.peek.typ == tokenString {
-		tok, err := p.next()
-		if err != nil {
-			return "", err
-		}
-		return tok.lit, nil
+		return p.parseString()
 	}
 	var parts []string
 	for {
@@ -419,15 +468,15 @@ func (p *parser) parseAttrValue() (string, error) {
 			case "-", ".", ":", "/":
 				parts = append(parts, tok.lit)
 			default:
-				return "", fmt.Errorf("dot parse: unexpected token in value: %q at %d", tok.lit, tok.pos)
+				return "", p.lx.errorAt(tok.pos, "dot parse: unexpected token in value: %q", tok.lit)
 			}
 		default:
-			return "", fmt.Errorf("dot parse: unexpected token in value: %q at %d", tok.lit, tok.pos)
+			return "", p.lx.errorAt(tok.pos, "dot parse: unexpected token in value: %q", tok.lit)
 		}
 	}
 	val := strings.TrimSpace(strings.Join(parts, ""))
 	if val == "" {
-		return "", fmt.Errorf("dot parse: empty attr value")
+		return "", p.lx.errorAt(p.peek.pos, "dot parse: empty attr value")
 	}
 	return val, nil
 }
@@ -440,11 +489,7 @@ func (p *parser) parseTopLevelValue() (string, error) {
 		return "", err
 	}
 	if p.peek.typ == tokenString {
-		tok, err := p.next()
-		if err != nil {
-			return "", err
-		}
-		return tok.lit, nil
+		return p.parseString()
 	}
 	if p.peek.typ == tokenSymbol && p.peek.lit == "-" {
 		// Negative number: consume '-' then the numeric ident.
@@ -457,7 +502,7 @@ func (p *parser) parseTopLevelValue() (string, error) {
 			return "", err
 		}
 		if numTok.typ != tokenIdent {
-			return "", fmt.Errorf("dot parse: expected number after '-', got %q at %d", numTok.lit, numTok.pos)
+			return "", p.lx.errorAt(numTok.pos, "dot parse: expected number after '-', got %q", numTok.lit)
 		}
 		return neg.lit + numTok.lit, nil
 	}
@@ -468,7 +513,37 @@ func (p *parser) parseTopLevelValue() (string, error) {
 		}
 		return tok.lit, nil
 	}
-	return "", fmt.Errorf("dot parse: expected value after '=', got %q at %d", p.peek.lit, p.peek.pos)
+	return "", p.lx.errorAt(p.peek.pos, "dot parse: expected value after '=', got %q", p.peek.lit)
+}
+
+// parseString parses a quoted string, joining any "a" + "b" continuations the
+// way Graphviz does. Long prompts are written this way, one line per piece.
+func (p *parser) parseString() (string, error) {
+	tok, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(tok.lit)
+	for {
+		if err := p.read(); err != nil {
+			return "", err
+		}
+		if !(p.peek.typ == tokenSymbol && p.peek.lit == "+") {
+			return b.String(), nil
+		}
+		if _, err := p.next(); err != nil {
+			return "", err
+		}
+		part, err := p.next()
+		if err != nil {
+			return "", err
+		}
+		if part.typ != tokenString {
+			return "", p.lx.errorAt(part.pos, "dot parse: expected string after '+', got %q", part.lit)
+		}
+		b.WriteString(part.lit)
+	}
 }
 
 func (p *parser) parseQualifiedKey() (string, error) {
@@ -478,7 +553,7 @@ func (p *parser) parseQualifiedKey() (string, error) {
 		return "", err
 	}
 	if first.typ != tokenIdent {
-		return "", fmt.Errorf("dot parse: expected identifier key, got %q at %d", first.lit, first.pos)
+		return "", p.lx.errorAt(first.pos, "dot parse: expected identifier key, got %q", first.lit)
 	}
 	key := first.lit
 	for {
@@ -493,7 +568,7 @@ func (p *parser) parseQualifiedKey() (string, error) {
 				return "", err
 			}
 			if part.typ != tokenIdent {
-				return "", fmt.Errorf("dot parse: expected identifier after '.', got %q at %d", part.lit, part.pos)
+				return "", p.lx.errorAt(part.pos, "dot parse: expected identifier after '.', got %q", part.lit)
 			}
 			key += "." + part.lit
 			continue
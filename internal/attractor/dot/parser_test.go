@@ -1,6 +1,8 @@
 package dot
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/danshapiro/kilroy/internal/attractor/model"
@@ -155,3 +157,57 @@ func contains(xs []string, want string) bool {
 }
 
 var _ = model.Graph{} // keep the import honest as the package evolves
+
+func TestParseFile_RecordsSourceSpans(t *testing.T) {
+	src := []byte(`/* header
+   comment */
+digraph G {
+  goal = "ship"
+  node [timeout=900s]
+  a [prompt="do it", shape=box]
+  a -> b -> c [condition="outcome=success"]
+}
+`)
+	g, err := ParseFile("p.dot", src)
+	if err != nil {
+		t.Fatalf("ParseFile() error: %v", err)
+	}
+	pos := func(line, col int) model.Position { return model.Position{Line: line, Column: col} }
+	if g.Span.File != "p.dot" || g.Span.Start != pos(3, 1) || g.Span.End != pos(8, 2) {
+		t.Fatalf("graph span: %+v", g.Span)
+	}
+	if s := g.AttrSpan("goal"); s.Start != pos(4, 3) || s.End != pos(4, 16) {
+		t.Fatalf("goal span: %+v", s)
+	}
+	a := g.Nodes["a"]
+	if a.Span.Start != pos(6, 3) || a.Span.End != pos(6, 32) {
+		t.Fatalf("node span: %+v", a.Span)
+	}
+	if s := a.AttrSpan("shape"); s.Start != pos(6, 22) || s.End != pos(6, 31) {
+		t.Fatalf("shape span: %+v", s)
+	}
+	// Inherited defaults point at the node default statement.
+	if s := a.AttrSpan("timeout"); s.Start != pos(5, 9) {
+		t.Fatalf("default attr span: %+v", s)
+	}
+	if len(g.Edges) != 2 {
+		t.Fatalf("edges: %d", len(g.Edges))
+	}
+	if s := g.Edges[1].Span; s.Start != pos(7, 8) || s.End != pos(7, 14) {
+		t.Fatalf("chained edge span: %+v", s)
+	}
+	if s := g.Edges[1].AttrSpan("condition"); s.Start != pos(7, 16) || s.String() != "p.dot:7:16" {
+		t.Fatalf("condition span: %+v", s)
+	}
+}
+
+func TestParseFile_SyntaxErrorHasPosition(t *testing.T) {
+	_, err := ParseFile("bad.dot", []byte("digraph G {\n  a [label=\"x\" prompt=\"y\"]\n}\n"))
+	var se *SyntaxError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected *SyntaxError, got %v", err)
+	}
+	if se.Span.Start != (model.Position{Line: 2, Column: 16}) || !strings.HasPrefix(err.Error(), "dot parse: expected ',' or ']'") || !strings.HasSuffix(err.Error(), " at bad.dot:2:16") {
+		t.Fatalf("error: %v (%+v)", err, se.Span)
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+// AgentRuntime runs agent sessions outside a graph (kilroy agent) with the
+// API providers, agent tools, MCP servers and tool policy of a run config.
+type AgentRuntime struct {
+	Client *llm.Client
+
+	cfg      *RunConfigFile
+	runtimes map[string]ProviderRuntime
+	tools    []agent.RegisteredTool
+	servers  []agent.LanguageServerConfig
+	policy   *ToolPolicyConfig
+	closeMCP func()
+}
+
+// NewAgentRuntime builds the API client for cfg's providers and connects the
+// run config's MCP servers. Commands run in workDir; MCP server logs go to
+// logDir when it is set. Close releases the MCP connections.
+func NewAgentRuntime(ctx context.Context, cfg *RunConfigFile, workDir, logDir string) (*AgentRuntime, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("run config is nil")
+	}
+	runtimes, err := resolveProviderRuntimes(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newAPIClientFromProviderRuntimes(runtimes)
+	if err != nil {
+		return nil, err
+	}
+	// The resolvers read the run config through an execution; there is no
+	// graph or node to layer on top of it.
+	execCtx := &Execution{Engine: &Engine{RunConfig: cfg}, WorktreeDir: workDir}
+	tools, err := resolveAgentTools(execCtx, nil)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := resolveToolPolicy(execCtx, nil)
+	if err != nil {
+		return nil, err
+	}
+	languageServers, err := resolveLanguageServers(execCtx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := resolveMCPServers(execCtx, nil, workDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	mcpTools, closeMCP, err := connectMCPServers(ctx, servers, logDir)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentRuntime{
+		Client:   client,
+		cfg:      cfg,
+		runtimes: runtimes,
+		tools:    append(tools, mcpTools...),
+		servers:  languageServers,
+		policy:   policy,
+		closeMCP: closeMCP,
+	}, nil
+}
+
+// Close disconnects the MCP servers.
+func (a *AgentRuntime) Close() {
+	if a != nil && a.closeMCP != nil {
+		a.closeMCP()
+	}
+}
+
+// Providers returns the API-backed providers that have a client (their API
+// key is set), sorted.
+func (a *AgentRuntime) Providers() []string {
+	if a == nil || a.Client == nil {
+		return nil
+	}
+	names := a.Client.ProviderNames()
+	sort.Strings(names)
+	return names
+}
+
+// Profile returns the agent profile for provider/model. provider must be an
+// API-backed provider of the run config whose API key is set.
+func (a *AgentRuntime) Profile(provider, model string) (agent.ProviderProfile, error) {
+	key := normalizeProviderKey(provider)
+	rt, ok := a.runtimes[key]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not configured in the run config", provider)
+	}
+	if rt.Backend != BackendAPI {
+		return nil, fmt.Errorf("provider %q uses the %s backend; kilroy agent needs backend: api", provider, rt.Backend)
+	}
+	if !a.hasClient(key) {
+		return nil, fmt.Errorf("provider %q has no API key (set %s)", provider, rt.API.DefaultAPIKeyEnv)
+	}
+	if strings.TrimSpace(model) == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	return profileForRuntimeProvider(rt, model)
+}
+
+func (a *AgentRuntime) hasClient(key string) bool {
+	for _, n := range a.Providers() {
+		if n == key {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionConfig returns the session settings an agent_loop stage would get
+// from the run config alone: tools, language servers, tool policy, LLM
+// retries and subagent profiles. Callers may set LLMRetryPolicy.OnRetry to surface retries.
+func (a *AgentRuntime) SessionConfig(provider, model string) agent.SessionConfig {
+	cfg := agent.SessionConfig{}
+	if normalizeProviderKey(provider) == "cerebras" {
+		cfg.ProviderOptions = map[string]any{
+			"cerebras": map[string]any{"clear_thinking": false},
+		}
+	}
+	policy := attractorLLMRetryPolicy(nil, "agent", provider, model)
+	cfg.LLMRetryPolicy = &policy
+	cfg.ExtraTools = a.tools
+	cfg.LanguageServers = a.servers
+	cfg.ToolPolicy = a.policy.build()
+	cfg.SubagentProfile = func(subProv, subModel string) (agent.ProviderProfile, error) {
+		if subProv == "" {
+			subProv = provider
+		}
+		return a.Profile(subProv, subModel)
+	}
+	return cfg
+}
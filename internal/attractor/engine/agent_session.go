@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/gitutil"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// agentSessionStateFile is the API agent_loop session journal in the stage
+// dir (see agent.SessionConfig.StatePath).
+const agentSessionStateFile = "agent_session.ndjson"
+
+// fileChangesFile is the per-stage journal of file mutations made by API
+// agent_loop tools (see agent.FileJournal).
+const fileChangesFile = "file_changes.ndjson"
+
+// resumeSnapshotFor returns the worktree snapshot taken before resume reset
+// the worktree, but only to the first node executed after the resume: any
+// other node starting drops it, and take consumes it.
+func (e *Engine) resumeSnapshotFor(nodeID string, take bool) string {
+	if e == nil {
+		return ""
+	}
+	e.resumeWorktreeMu.Lock()
+	defer e.resumeWorktreeMu.Unlock()
+	if e.resumeWorktreeNode == "" {
+		e.resumeWorktreeNode = nodeID
+	}
+	if e.resumeWorktreeNode != nodeID {
+		e.resumeWorktreeTree = ""
+	}
+	tree := e.resumeWorktreeTree
+	if take {
+		e.resumeWorktreeTree = ""
+	}
+	return tree
+}
+
+// resumableAgentSession returns the journaled session of an agent_loop stage
+// that was interrupted mid-flight, after restoring the worktree it had at its
+// last checkpoint. It returns nil (start the stage fresh) unless the run was
+// just resumed at this node and the interrupted worktree matches that
+// checkpoint exactly.
+func resumableAgentSession(execCtx *Execution, node *model.Node, stageDir string) *agent.SessionState {
+	if execCtx == nil || execCtx.Engine == nil || node == nil {
+		return nil
+	}
+	eng := execCtx.Engine
+	tree := eng.resumeSnapshotFor(node.ID, true)
+	if tree == "" {
+		return nil
+	}
+	st, err := agent.LoadSessionState(filepath.Join(stageDir, agentSessionStateFile))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			eng.Warn("agent session resume: " + err.Error())
+		}
+		return nil
+	}
+	skip := func(reason string) *agent.SessionState {
+		eng.appendProgress(map[string]any{
+			"event":   "agent_session_resume_skipped",
+			"node_id": node.ID,
+			"reason":  reason,
+		})
+		return nil
+	}
+	if len(st.History) == 0 {
+		return skip("no_history")
+	}
+	if st.Fingerprint != tree {
+		return skip("worktree_mismatch")
+	}
+	if err := gitutil.RestoreWorktreeTree(execCtx.WorktreeDir, tree); err != nil {
+		eng.Warn("agent session resume: restore worktree: " + err.Error())
+		return nil
+	}
+	eng.appendProgress(map[string]any{
+		"event":      "agent_session_resumed",
+		"node_id":    node.ID,
+		"session_id": st.SessionID,
+		"turns":      len(st.History),
+		"saved_at":   st.SavedAt,
+	})
+	return st
+}
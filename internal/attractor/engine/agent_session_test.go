@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+)
+
+func TestResume_APIAgentLoop_ContinuesInterruptedSession(t *testing.T) {
+	repo := initTestRepo(t)
+	logsRoot := t.TempDir()
+	pinned := writePinnedCatalog(t)
+	cxdbSrv := newCXDBTestServer(t)
+
+	var mu sync.Mutex
+	var bodies []string
+	openaiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/responses" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{
+  "id": "resp_1",
+  "model": "gpt-5.2",
+  "output": [{"type":"function_call","id":"call_1","call_id":"call_1","name":"shell","arguments":"{\"command\":\"echo wip > wip.txt && echo wrote-wip\"}"}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{
+  "id": "resp_2",
+  "model": "gpt-5.2",
+  "output": [{"type":"message","content":[{"type":"output_text","text":"done"}]}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+	}))
+	t.Cleanup(openaiSrv.Close)
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_BASE_URL", openaiSrv.URL)
+
+	cfg := &RunConfigFile{Version: 1}
+	cfg.Repo.Path = repo
+	cfg.CXDB.BinaryAddr = cxdbSrv.BinaryAddr()
+	cfg.CXDB.HTTPBaseURL = cxdbSrv.URL()
+	cfg.LLM.Providers = map[string]ProviderConfig{
+		"openai": {Backend: BackendAPI, Failover: []string{}},
+	}
+	cfg.ModelDB.OpenRouterModelInfoPath = pinned
+	cfg.ModelDB.OpenRouterModelInfoUpdatePolicy = "pinned"
+	cfg.Git.RunBranchPrefix = "attractor/run"
+	disableProbe := false
+	cfg.Preflight.PromptProbes.Enabled = &disableProbe
+
+	dot := []byte(`
+digraph G {
+  graph [goal="write wip"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, auto_status=true, prompt="write wip.txt"]
+  start -> a -> exit
+}
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	res, err := RunWithConfig(ctx, dot, cfg, RunOptions{RunID: "api-session-resume-test", LogsRoot: logsRoot})
+	if err != nil {
+		t.Fatalf("RunWithConfig: %v", err)
+	}
+
+	// Simulate the process dying inside stage a, right after its first tool
+	// round: cut the journal at that checkpoint, rewind the checkpoint to
+	// start, and leave the worktree as the tool round left it.
+	journal := filepath.Join(res.LogsRoot, "a", agentSessionStateFile)
+	b, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatalf("read journal: %v", err)
+	}
+	var kept []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		kept = append(kept, line)
+		if strings.Contains(line, `"op":"checkpoint"`) && strings.Contains(line, `"history_len":3`) {
+			break
+		}
+	}
+	if err := os.WriteFile(journal, []byte(strings.Join(kept, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cpPath := filepath.Join(res.LogsRoot, "checkpoint.json")
+	cp, err := runtime.LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	startSHA := strings.TrimSpace(runCmdOut(t, res.WorktreeDir, "git", "rev-parse", "HEAD~1"))
+	cp.CurrentNode = "start"
+	cp.CompletedNodes = []string{"start"}
+	cp.GitCommitSHA = startSHA
+	if err := cp.Save(cpPath); err != nil {
+		t.Fatalf("Save checkpoint: %v", err)
+	}
+	runCmd(t, res.WorktreeDir, "git", "reset", "-q", "--hard", startSHA)
+	if err := os.WriteFile(filepath.Join(res.WorktreeDir, "wip.txt"), []byte("wip\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	bodies = bodies[:1] // the next request is answered with the final message
+	mu.Unlock()
+	res2, err := Resume(ctx, res.LogsRoot)
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if res2.FinalStatus != runtime.FinalSuccess {
+		t.Fatalf("final status: %q", res2.FinalStatus)
+	}
+
+	mu.Lock()
+	resumed := bodies[len(bodies)-1]
+	mu.Unlock()
+	if !strings.Contains(resumed, "You were interrupted") || !strings.Contains(resumed, "wrote-wip") || !strings.Contains(resumed, "write wip.txt") {
+		t.Fatalf("resumed request lacks the restored conversation: %s", resumed)
+	}
+	progress, _ := os.ReadFile(filepath.Join(res.LogsRoot, "progress.ndjson"))
+	if !strings.Contains(string(progress), `"agent_session_resumed"`) {
+		t.Fatalf("progress lacks agent_session_resumed")
+	}
+	if got := strings.TrimSpace(runCmdOut(t, res.WorktreeDir, "git", "show", "HEAD:wip.txt")); got != "wip" {
+		t.Fatalf("restored worktree change not committed: %q", got)
+	}
+}
@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+const agentToolAttrPrefix = "agent_tool."
+
+func (t AgentToolConfig) spec() agent.CommandToolSpec {
+	return agent.CommandToolSpec{
+		Name:        t.Name,
+		Description: t.Description,
+		Parameters:  t.Parameters,
+		Command:     t.Command,
+		TimeoutMS:   t.TimeoutMS,
+		Env:         t.Env,
+		Limit: agent.ToolOutputLimit{
+			MaxChars: t.MaxChars,
+			MaxLines: t.MaxLines,
+			Strategy: agent.TruncationStrategy(t.Truncation),
+		},
+	}
+}
+
+// agentToolsFromAttrs reads agent_tool.<name>.<field> attributes: command,
+// description, parameters (JSON schema), timeout_ms, max_chars, max_lines and
+// truncation.
+func agentToolsFromAttrs(attrs map[string]string) ([]AgentToolConfig, error) {
+	byName := map[string]*AgentToolConfig{}
+	for key, val := range attrs {
+		if !strings.HasPrefix(key, agentToolAttrPrefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, agentToolAttrPrefix)
+		dot := strings.LastIndex(rest, ".")
+		if dot <= 0 {
+			return nil, fmt.Errorf("%s: want %s<name>.<field>", key, agentToolAttrPrefix)
+		}
+		name, field := rest[:dot], rest[dot+1:]
+		t := byName[name]
+		if t == nil {
+			t = &AgentToolConfig{Name: name}
+			byName[name] = t
+		}
+		var err error
+		switch field {
+		case "command":
+			t.Command = val
+		case "description":
+			t.Description = val
+		case "parameters":
+			err = json.Unmarshal([]byte(val), &t.Parameters)
+		case "timeout_ms":
+			t.TimeoutMS, err = strconv.Atoi(strings.TrimSpace(val))
+		case "max_chars":
+			t.MaxChars, err = strconv.Atoi(strings.TrimSpace(val))
+		case "max_lines":
+			t.MaxLines, err = strconv.Atoi(strings.TrimSpace(val))
+		case "truncation":
+			t.Truncation = strings.ToLower(strings.TrimSpace(val))
+		default:
+			return nil, fmt.Errorf("%s: unknown field %q", key, field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]AgentToolConfig, 0, len(names))
+	for _, name := range names {
+		out = append(out, *byName[name])
+	}
+	return out, nil
+}
+
+// resolveAgentTools builds the custom tools for an agent_loop stage from the
+// run config, then graph attrs, then node attrs; a later declaration replaces
+// an earlier one with the same name.
+func resolveAgentTools(execCtx *Execution, node *model.Node) ([]agent.RegisteredTool, error) {
+	var layers [][]AgentToolConfig
+	if execCtx != nil && execCtx.Engine != nil {
+		if execCtx.Engine.RunConfig != nil {
+			layers = append(layers, execCtx.Engine.RunConfig.AgentTools)
+		}
+		if execCtx.Engine.Graph != nil {
+			ts, err := agentToolsFromAttrs(execCtx.Engine.Graph.Attrs)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, ts)
+		}
+	}
+	if node != nil {
+		ts, err := agentToolsFromAttrs(node.Attrs)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, ts)
+	}
+	var order []string
+	merged := map[string]AgentToolConfig{}
+	for _, layer := range layers {
+		for _, t := range layer {
+			if _, ok := merged[t.Name]; !ok {
+				order = append(order, t.Name)
+			}
+			merged[t.Name] = t
+		}
+	}
+	out := make([]agent.RegisteredTool, 0, len(order))
+	for _, name := range order {
+		rt, err := agent.NewCommandTool(merged[name].spec())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rt)
+	}
+	return out, nil
+}
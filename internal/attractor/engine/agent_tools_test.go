@@ -0,0 +1,185 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+func TestLoadRunConfigFile_AgentTools(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+version: 1
+repo:
+  path: /tmp/repo
+cxdb:
+  binary_addr: 127.0.0.1:9009
+  http_base_url: http://127.0.0.1:9010
+modeldb:
+  openrouter_model_info_path: /tmp/catalog.json
+`
+	load := func(name, y string) (*RunConfigFile, error) {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(base+y), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadRunConfigFile(p)
+	}
+
+	cfg, err := load("ok.yaml", `agent_tools:
+  - name: run_tests
+    description: Run the tests for one package.
+    command: go test ./{{pkg}}/...
+    parameters:
+      type: object
+      properties:
+        pkg: {type: string}
+      required: [pkg]
+    timeout_ms: 600000
+    max_lines: 200
+    truncation: Tail
+`)
+	if err != nil {
+		t.Fatalf("LoadRunConfigFile: %v", err)
+	}
+	if got := cfg.AgentTools[0]; got.Name != "run_tests" || got.Truncation != "tail" || got.Parameters["type"] != "object" {
+		t.Fatalf("tool: %+v", got)
+	}
+
+	bad := map[string]string{
+		"name.yaml":   "agent_tools:\n  - name: run-tests\n    command: make\n",
+		"cmd.yaml":    "agent_tools:\n  - name: run_tests\n",
+		"schema.yaml": "agent_tools:\n  - name: run_tests\n    command: make\n    parameters: {type: 7}\n",
+		"dup.yaml":    "agent_tools:\n  - name: a\n    command: x\n  - name: a\n    command: y\n",
+	}
+	for name, y := range bad {
+		if _, err := load(name, y); err == nil || !strings.Contains(err.Error(), "agent_tools[") {
+			t.Fatalf("%s: expected agent_tools error, got %v", name, err)
+		}
+	}
+}
+
+func TestResolveAgentTools_NodeOverridesGraphOverridesRunConfig(t *testing.T) {
+	g := model.NewGraph("g")
+	g.Attrs["agent_tool.lint.command"] = "graph-lint"
+	g.Attrs["agent_tool.schema.command"] = "dump-schema"
+	n := model.NewNode("a")
+	n.Attrs["agent_tool.lint.command"] = "node-lint"
+	n.Attrs["agent_tool.lint.max_chars"] = "500"
+	eng := &Engine{Graph: g, RunConfig: &RunConfigFile{AgentTools: []AgentToolConfig{
+		{Name: "lint", Command: "cfg-lint"},
+		{Name: "render", Command: "render"},
+	}}}
+
+	tools, err := resolveAgentTools(&Execution{Engine: eng}, n)
+	if err != nil {
+		t.Fatalf("resolveAgentTools: %v", err)
+	}
+	var names []string
+	for _, rt := range tools {
+		names = append(names, rt.Definition.Name)
+	}
+	if strings.Join(names, ",") != "lint,render,schema" {
+		t.Fatalf("names: %v", names)
+	}
+	if tools[0].Limit.MaxChars != 500 {
+		t.Fatalf("node override not applied: %+v", tools[0].Limit)
+	}
+
+	n.Attrs["agent_tool.lint.colour"] = "red"
+	if _, err := resolveAgentTools(&Execution{Engine: eng}, n); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestRunWithConfig_APIAgentLoop_CallsDeclaredTool(t *testing.T) {
+	repo := initTestRepo(t)
+	logsRoot := t.TempDir()
+	pinned := writePinnedCatalog(t)
+	cxdbSrv := newCXDBTestServer(t)
+
+	var mu sync.Mutex
+	var bodies []string
+	openaiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/responses" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{
+  "id": "resp_1",
+  "model": "gpt-5.2",
+  "output": [{"type":"function_call","id":"call_1","call_id":"call_1","name":"greet","arguments":"{\"who\":\"kilroy\"}"}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{
+  "id": "resp_2",
+  "model": "gpt-5.2",
+  "output": [{"type":"message","content":[{"type":"output_text","text":"done"}]}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+	}))
+	t.Cleanup(openaiSrv.Close)
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_BASE_URL", openaiSrv.URL)
+
+	cfg := &RunConfigFile{Version: 1}
+	cfg.Repo.Path = repo
+	cfg.CXDB.BinaryAddr = cxdbSrv.BinaryAddr()
+	cfg.CXDB.HTTPBaseURL = cxdbSrv.URL()
+	cfg.LLM.Providers = map[string]ProviderConfig{
+		"openai": {Backend: BackendAPI, Failover: []string{}},
+	}
+	cfg.ModelDB.OpenRouterModelInfoPath = pinned
+	cfg.ModelDB.OpenRouterModelInfoUpdatePolicy = "pinned"
+	cfg.Git.RunBranchPrefix = "attractor/run"
+	disableProbe := false
+	cfg.Preflight.PromptProbes.Enabled = &disableProbe
+
+	dot := []byte(`
+digraph G {
+  graph [goal="use a declared tool"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, auto_status=true, prompt="greet",
+     agent_tool.greet.description="Greet someone.",
+     agent_tool.greet.parameters="{\"type\":\"object\",\"properties\":{\"who\":{\"type\":\"string\"}},\"required\":[\"who\"]}",
+     agent_tool.greet.command="echo hello-{{who}} from $(basename $PWD)"]
+  start -> a -> exit
+}
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := RunWithConfig(ctx, dot, cfg, RunOptions{RunID: "api-agent-tool-test", LogsRoot: logsRoot}); err != nil {
+		t.Fatalf("RunWithConfig: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) < 2 {
+		t.Fatalf("expected tool round trip, got %d requests", len(bodies))
+	}
+	if !strings.Contains(bodies[0], `"name":"greet"`) || !strings.Contains(bodies[0], "Greet someone.") {
+		t.Fatalf("first request does not offer the declared tool: %s", bodies[0])
+	}
+	if !strings.Contains(bodies[1], "hello-kilroy from worktree") {
+		t.Fatalf("tool output not returned to the model: %s", bodies[1])
+	}
+}
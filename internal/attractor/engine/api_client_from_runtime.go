@@ -9,6 +9,7 @@ import (
 	"github.com/danshapiro/kilroy/internal/llm"
 	"github.com/danshapiro/kilroy/internal/llm/providers/anthropic"
 	"github.com/danshapiro/kilroy/internal/llm/providers/google"
+	"github.com/danshapiro/kilroy/internal/llm/providers/ollama"
 	"github.com/danshapiro/kilroy/internal/llm/providers/openai"
 	"github.com/danshapiro/kilroy/internal/llm/providers/openaicompat"
 	"github.com/danshapiro/kilroy/internal/providerspec"
@@ -22,7 +23,7 @@ func newAPIClientFromProviderRuntimes(runtimes map[string]ProviderRuntime) (*llm
 			continue
 		}
 		apiKey := strings.TrimSpace(os.Getenv(rt.API.DefaultAPIKeyEnv))
-		if apiKey == "" {
+		if apiKey == "" && !rt.API.APIKeyOptional {
 			continue
 		}
 		switch rt.API.Protocol {
@@ -41,6 +42,8 @@ func newAPIClientFromProviderRuntimes(runtimes map[string]ProviderRuntime) (*llm
 				OptionsKey:   rt.API.ProviderOptionsKey,
 				ExtraHeaders: rt.APIHeaders(),
 			}))
+		case providerspec.ProtocolOllamaChat:
+			c.Register(newOllamaAdapterFromRuntime(key, rt, apiKey))
 		default:
 			return nil, fmt.Errorf("unsupported api protocol %q for provider %s", rt.API.Protocol, key)
 		}
@@ -49,6 +52,18 @@ func newAPIClientFromProviderRuntimes(runtimes map[string]ProviderRuntime) (*llm
 	return c, nil
 }
 
+func newOllamaAdapterFromRuntime(key string, rt ProviderRuntime, apiKey string) *ollama.Adapter {
+	return ollama.NewAdapter(ollama.Config{
+		Provider:     key,
+		APIKey:       apiKey,
+		BaseURL:      resolveBuiltInBaseURLOverride(key, rt.API.DefaultBaseURL),
+		Path:         rt.API.DefaultPath,
+		OptionsKey:   rt.API.ProviderOptionsKey,
+		KeepAlive:    os.Getenv("OLLAMA_KEEP_ALIVE"),
+		ExtraHeaders: rt.APIHeaders(),
+	})
+}
+
 func resolveBuiltInBaseURLOverride(providerKey, defaultBaseURL string) string {
 	normalized := strings.TrimSpace(defaultBaseURL)
 	switch providerspec.CanonicalProviderKey(providerKey) {
@@ -76,6 +91,12 @@ func resolveBuiltInBaseURLOverride(providerKey, defaultBaseURL string) string {
 				return env
 			}
 		}
+	case "ollama":
+		if env := ollama.NormalizeHost(os.Getenv("OLLAMA_HOST")); env != "" {
+			if normalized == "" || normalized == "http://localhost:11434" {
+				return env
+			}
+		}
 	}
 	return normalized
 }
@@ -145,3 +145,38 @@ func TestResolveBuiltInBaseURLOverride_DoesNotOverrideExplicitCustomBaseURL(t *t
 		t.Fatalf("explicit base url should win, got %q", got)
 	}
 }
+
+func TestNewAPIClientFromProviderRuntimes_RegistersKeylessOllama(t *testing.T) {
+	runtimes := map[string]ProviderRuntime{
+		"ollama": {
+			Key:     "ollama",
+			Backend: BackendAPI,
+			API: providerspec.APISpec{
+				Protocol:           providerspec.ProtocolOllamaChat,
+				DefaultBaseURL:     "http://127.0.0.1:0",
+				DefaultPath:        "/api/chat",
+				DefaultAPIKeyEnv:   "OLLAMA_API_KEY",
+				ProviderOptionsKey: "ollama",
+				APIKeyOptional:     true,
+			},
+		},
+	}
+	t.Setenv("OLLAMA_API_KEY", "")
+	c, err := newAPIClientFromProviderRuntimes(runtimes)
+	if err != nil {
+		t.Fatalf("newAPIClientFromProviderRuntimes: %v", err)
+	}
+	if got := c.ProviderNames(); len(got) != 1 || got[0] != "ollama" {
+		t.Fatalf("expected ollama adapter without api key, got %v", got)
+	}
+}
+
+func TestResolveBuiltInBaseURLOverride_OllamaHostWithoutScheme(t *testing.T) {
+	t.Setenv("OLLAMA_HOST", "10.0.0.5:11434")
+	if got := resolveBuiltInBaseURLOverride("ollama", "http://localhost:11434"); got != "http://10.0.0.5:11434" {
+		t.Fatalf("ollama base url override: got %q", got)
+	}
+	if got := resolveBuiltInBaseURLOverride("ollama", "http://gpu-box:11434"); got != "http://gpu-box:11434" {
+		t.Fatalf("explicit base url should win over OLLAMA_HOST, got %q", got)
+	}
+}
@@ -0,0 +1,274 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+const (
+	batchStateFile           = "batch.json"
+	pendingBatchesExtraKey   = "pending_batches"
+	defaultBatchPollInterval = 5 * time.Second
+	defaultBatchPollMax      = 5 * time.Minute
+)
+
+// batchStageState is the persisted handle for an in-flight provider batch.
+// It is written to <stage>/batch.json and mirrored into the checkpoint so a
+// stopped run re-attaches to the same batch on resume instead of paying for
+// a second submission.
+type batchStageState struct {
+	Job           llm.BatchJob `json:"job"`
+	Provider      string       `json:"provider"`
+	Model         string       `json:"model"`
+	CustomID      string       `json:"custom_id"`
+	RequestSHA256 string       `json:"request_sha256"`
+	SubmittedAt   string       `json:"submitted_at"`
+}
+
+// llmModeForNode returns the node's llm_mode ("sync" or "batch").
+func llmModeForNode(node *model.Node) (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(node.Attr("llm_mode", "")))
+	switch mode {
+	case "", "sync":
+		return "sync", nil
+	case "batch":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid llm_mode: %q (want sync|batch)", mode)
+	}
+}
+
+func batchRequestSHA256(req llm.Request) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// completeViaBatch runs req through the provider batch endpoint: it submits
+// (or re-attaches to a matching persisted batch), polls with backoff until the
+// job is terminal, and returns the response for this stage's custom ID.
+func (r *CodergenRouter) completeViaBatch(ctx context.Context, execCtx *Execution, node *model.Node, ba llm.BatchAdapter, req llm.Request, stageDir string) (llm.Response, error) {
+	sha, err := batchRequestSHA256(req)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	eng := execCtx.Engine
+	statePath := filepath.Join(stageDir, batchStateFile)
+
+	state, ok := loadBatchStageState(statePath)
+	if !ok && eng != nil {
+		state, ok = eng.pendingBatch(node.ID)
+	}
+	if ok && (state.RequestSHA256 != sha || state.Provider != req.Provider || state.Model != req.Model || state.Job.Status == llm.BatchStatusFailed) {
+		ok = false
+	}
+	if ok {
+		warnEngine(execCtx, fmt.Sprintf("batch: node=%s re-attaching to %s batch %s", node.ID, state.Provider, state.Job.ID))
+	} else {
+		customID := node.ID
+		policy := attractorLLMRetryPolicy(execCtx, node.ID, req.Provider, req.Model)
+		job, err := llm.Retry(ctx, policy, nil, nil, func() (llm.BatchJob, error) {
+			return ba.SubmitBatch(ctx, []llm.BatchRequest{{CustomID: customID, Request: req}})
+		})
+		if err != nil {
+			return llm.Response{}, err
+		}
+		state = batchStageState{
+			Job:           job,
+			Provider:      req.Provider,
+			Model:         req.Model,
+			CustomID:      customID,
+			RequestSHA256: sha,
+			SubmittedAt:   time.Now().UTC().Format(time.RFC3339Nano),
+		}
+		if eng != nil {
+			eng.appendProgress(map[string]any{
+				"event":    "batch_submitted",
+				"node_id":  node.ID,
+				"provider": req.Provider,
+				"model":    req.Model,
+				"batch_id": job.ID,
+			})
+		}
+	}
+	if err := writeJSON(statePath, state); err != nil {
+		warnEngine(execCtx, fmt.Sprintf("write %s: %v", batchStateFile, err))
+	}
+	if eng != nil {
+		eng.setPendingBatch(node.ID, &state)
+	}
+
+	job, err := r.pollBatch(ctx, execCtx, node, ba, state)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	state.Job = job
+	if err := writeJSON(statePath, state); err != nil {
+		warnEngine(execCtx, fmt.Sprintf("write %s: %v", batchStateFile, err))
+	}
+	if eng != nil {
+		eng.setPendingBatch(node.ID, nil)
+	}
+	if job.Status == llm.BatchStatusFailed {
+		return llm.Response{}, llm.ErrorFromHTTPStatus(state.Provider, 500, fmt.Sprintf("batch %s ended with status %s", job.ID, job.RawStatus), job.Raw, nil)
+	}
+	results, err := ba.BatchResults(ctx, job)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	return llm.FindBatchResult(results, state.CustomID)
+}
+
+func (r *CodergenRouter) pollBatch(ctx context.Context, execCtx *Execution, node *model.Node, ba llm.BatchAdapter, state batchStageState) (llm.BatchJob, error) {
+	cfg := BackoffConfig{
+		InitialDelayMS: int(parseDuration(node.Attr("batch_poll_interval", ""), defaultBatchPollInterval) / time.Millisecond),
+		BackoffFactor:  2.0,
+		MaxDelayMS:     int(parseDuration(node.Attr("batch_poll_max_interval", ""), defaultBatchPollMax) / time.Millisecond),
+		Jitter:         true,
+	}
+	runID := ""
+	if execCtx.Engine != nil {
+		runID = execCtx.Engine.Options.RunID
+	}
+	job := state.Job
+	for attempt := 1; ; attempt++ {
+		if job.Done() {
+			return job, nil
+		}
+		delay := DelayForAttempt(attempt, cfg, fmt.Sprintf("%s:%s:batch:%d", runID, node.ID, attempt))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return job, ctx.Err()
+		case <-timer.C:
+		}
+		next, err := ba.GetBatch(ctx, job.ID)
+		if err != nil {
+			var le llm.Error
+			if errors.As(err, &le) && !le.Retryable() {
+				return job, err
+			}
+			warnEngine(execCtx, fmt.Sprintf("batch: node=%s poll %s failed (will retry): %v", node.ID, job.ID, err))
+		} else {
+			job = next
+		}
+		if execCtx.Engine != nil {
+			execCtx.Engine.appendProgress(map[string]any{
+				"event":      "batch_poll",
+				"node_id":    node.ID,
+				"provider":   state.Provider,
+				"batch_id":   job.ID,
+				"status":     string(job.Status),
+				"raw_status": job.RawStatus,
+				"attempt":    attempt,
+			})
+		}
+	}
+}
+
+func loadBatchStageState(path string) (batchStageState, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return batchStageState{}, false
+	}
+	var st batchStageState
+	if err := json.Unmarshal(b, &st); err != nil || strings.TrimSpace(st.Job.ID) == "" {
+		return batchStageState{}, false
+	}
+	return st, true
+}
+
+func (e *Engine) pendingBatch(nodeID string) (batchStageState, bool) {
+	e.pendingBatchesMu.Lock()
+	defer e.pendingBatchesMu.Unlock()
+	st, ok := e.pendingBatches[nodeID]
+	return st, ok
+}
+
+// setPendingBatch records (or clears, when st is nil) the in-flight batch for
+// nodeID and rewrites it into the existing checkpoint immediately: batches can
+// outlive the process by hours, so waiting for the next node checkpoint would
+// lose the handle on a stop.
+func (e *Engine) setPendingBatch(nodeID string, st *batchStageState) {
+	e.pendingBatchesMu.Lock()
+	if st == nil {
+		delete(e.pendingBatches, nodeID)
+	} else {
+		if e.pendingBatches == nil {
+			e.pendingBatches = map[string]batchStageState{}
+		}
+		e.pendingBatches[nodeID] = *st
+	}
+	e.pendingBatchesMu.Unlock()
+
+	// Snapshot under the checkpoint lock so concurrent updates land in order.
+	err := e.updateCheckpoint(func(cp *runtime.Checkpoint) {
+		if snapshot := e.pendingBatchesSnapshot(); len(snapshot) == 0 {
+			delete(cp.Extra, pendingBatchesExtraKey)
+		} else {
+			cp.Extra[pendingBatchesExtraKey] = snapshot
+		}
+	})
+	if err != nil {
+		e.Warn(fmt.Sprintf("batch: update checkpoint: %v", err))
+	}
+}
+
+func (e *Engine) pendingBatchesSnapshot() map[string]batchStageState {
+	e.pendingBatchesMu.Lock()
+	defer e.pendingBatchesMu.Unlock()
+	return e.pendingBatchesSnapshotLocked()
+}
+
+func (e *Engine) pendingBatchesSnapshotLocked() map[string]batchStageState {
+	if len(e.pendingBatches) == 0 {
+		return nil
+	}
+	out := make(map[string]batchStageState, len(e.pendingBatches))
+	for k, v := range e.pendingBatches {
+		out[k] = v
+	}
+	return out
+}
+
+func restorePendingBatches(cp *runtime.Checkpoint) map[string]batchStageState {
+	out := map[string]batchStageState{}
+	if cp == nil || cp.Extra == nil {
+		return out
+	}
+	raw, ok := cp.Extra[pendingBatchesExtraKey]
+	if !ok || raw == nil {
+		return out
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return out
+	}
+	var m map[string]batchStageState
+	if err := json.Unmarshal(b, &m); err != nil {
+		return out
+	}
+	for k, v := range m {
+		if strings.TrimSpace(k) == "" || strings.TrimSpace(v.Job.ID) == "" {
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
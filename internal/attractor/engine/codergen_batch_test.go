@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+type fakeBatchAdapter struct {
+	submits   int
+	pollsLeft int
+	text      string
+}
+
+func (f *fakeBatchAdapter) BatchSupported() bool { return true }
+
+func (f *fakeBatchAdapter) SubmitBatch(ctx context.Context, reqs []llm.BatchRequest) (llm.BatchJob, error) {
+	f.submits++
+	return llm.BatchJob{ID: fmt.Sprintf("batch_%d", f.submits), Provider: "openai", Status: llm.BatchStatusPending}, nil
+}
+
+func (f *fakeBatchAdapter) GetBatch(ctx context.Context, id string) (llm.BatchJob, error) {
+	if f.pollsLeft > 0 {
+		f.pollsLeft--
+		return llm.BatchJob{ID: id, Provider: "openai", Status: llm.BatchStatusPending}, nil
+	}
+	return llm.BatchJob{ID: id, Provider: "openai", Status: llm.BatchStatusCompleted}, nil
+}
+
+func (f *fakeBatchAdapter) BatchResults(ctx context.Context, job llm.BatchJob) ([]llm.BatchResult, error) {
+	resp := llm.Response{Provider: "openai", Message: llm.Assistant(f.text + ":" + job.ID)}
+	return []llm.BatchResult{{CustomID: "n1", Response: &resp}}, nil
+}
+
+func newBatchTestExecution(t *testing.T) (*Execution, *model.Node, string) {
+	t.Helper()
+	logsRoot := t.TempDir()
+	eng := &Engine{LogsRoot: logsRoot}
+	node := &model.Node{ID: "n1", Attrs: map[string]string{
+		"llm_mode":                "batch",
+		"batch_poll_interval":     "1ms",
+		"batch_poll_max_interval": "2ms",
+	}}
+	return &Execution{LogsRoot: logsRoot, Engine: eng}, node, filepath.Join(logsRoot, "n1")
+}
+
+func TestCompleteViaBatch_PollsUntilCompletedAndClearsPending(t *testing.T) {
+	execCtx, node, stageDir := newBatchTestExecution(t)
+	if err := runtime.NewCheckpoint().Save(filepath.Join(execCtx.LogsRoot, "checkpoint.json")); err != nil {
+		t.Fatal(err)
+	}
+	fa := &fakeBatchAdapter{pollsLeft: 2, text: "ok"}
+	req := llm.Request{Provider: "openai", Model: "gpt-5.2", Messages: []llm.Message{llm.User("hi")}}
+
+	resp, err := (&CodergenRouter{}).completeViaBatch(context.Background(), execCtx, node, fa, req, stageDir)
+	if err != nil {
+		t.Fatalf("completeViaBatch: %v", err)
+	}
+	if got := resp.Text(); got != "ok:batch_1" {
+		t.Fatalf("text: got %q", got)
+	}
+	if fa.submits != 1 || fa.pollsLeft != 0 {
+		t.Fatalf("submits=%d pollsLeft=%d", fa.submits, fa.pollsLeft)
+	}
+	st, ok := loadBatchStageState(filepath.Join(stageDir, batchStateFile))
+	if !ok || st.Job.Status != llm.BatchStatusCompleted || st.CustomID != "n1" {
+		t.Fatalf("batch.json: ok=%v state=%+v", ok, st)
+	}
+	cp, err := runtime.LoadCheckpoint(filepath.Join(execCtx.LogsRoot, "checkpoint.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cp.Extra[pendingBatchesExtraKey]; ok {
+		t.Fatalf("pending_batches should be cleared after completion: %#v", cp.Extra)
+	}
+}
+
+func TestCompleteViaBatch_ReattachesToMatchingPersistedBatch(t *testing.T) {
+	execCtx, node, stageDir := newBatchTestExecution(t)
+	req := llm.Request{Provider: "openai", Model: "gpt-5.2", Messages: []llm.Message{llm.User("hi")}}
+	sha, err := batchRequestSHA256(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSON(filepath.Join(stageDir, batchStateFile), batchStageState{
+		Job:           llm.BatchJob{ID: "batch_prev", Provider: "openai", Status: llm.BatchStatusPending},
+		Provider:      "openai",
+		Model:         "gpt-5.2",
+		CustomID:      "n1",
+		RequestSHA256: sha,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	fa := &fakeBatchAdapter{text: "ok"}
+	resp, err := (&CodergenRouter{}).completeViaBatch(context.Background(), execCtx, node, fa, req, stageDir)
+	if err != nil {
+		t.Fatalf("completeViaBatch: %v", err)
+	}
+	if fa.submits != 0 || resp.Text() != "ok:batch_prev" {
+		t.Fatalf("expected re-attach without submit: submits=%d text=%q", fa.submits, resp.Text())
+	}
+
+	// A changed prompt must not reuse the persisted batch.
+	req.Messages = []llm.Message{llm.User("different")}
+	resp, err = (&CodergenRouter{}).completeViaBatch(context.Background(), execCtx, node, fa, req, stageDir)
+	if err != nil {
+		t.Fatalf("completeViaBatch (changed request): %v", err)
+	}
+	if fa.submits != 1 || resp.Text() != "ok:batch_1" {
+		t.Fatalf("expected resubmit: submits=%d text=%q", fa.submits, resp.Text())
+	}
+}
+
+func TestSetPendingBatch_PersistsIntoCheckpointForResume(t *testing.T) {
+	logsRoot := t.TempDir()
+	cpPath := filepath.Join(logsRoot, "checkpoint.json")
+	if err := runtime.NewCheckpoint().Save(cpPath); err != nil {
+		t.Fatal(err)
+	}
+	eng := &Engine{LogsRoot: logsRoot}
+	eng.setPendingBatch("n1", &batchStageState{
+		Job:      llm.BatchJob{ID: "msgbatch_1", Provider: "anthropic", Status: llm.BatchStatusPending},
+		Provider: "anthropic",
+		Model:    "claude-sonnet-4-5",
+		CustomID: "n1",
+	})
+
+	cp, err := runtime.LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := restorePendingBatches(cp)
+	if got := restored["n1"].Job.ID; got != "msgbatch_1" {
+		t.Fatalf("restored pending batch: %#v", restored)
+	}
+}
+
+func TestLLMModeForNode(t *testing.T) {
+	for attr, want := range map[string]string{"": "sync", "sync": "sync", "BATCH": "batch"} {
+		got, err := llmModeForNode(&model.Node{ID: "n", Attrs: map[string]string{"llm_mode": attr}})
+		if err != nil || got != want {
+			t.Fatalf("llm_mode=%q: got %q err=%v", attr, got, err)
+		}
+	}
+	if _, err := llmModeForNode(&model.Node{ID: "n", Attrs: map[string]string{"llm_mode": "async"}}); err == nil {
+		t.Fatalf("expected error for invalid llm_mode")
+	}
+}
@@ -165,6 +165,35 @@ func TestProfileForRuntimeProvider_RoutesByRuntimeProviderAndKeepsFamilyBehavior
 	}
 }
 
+func TestAgentProfile_UsesDiscoveredContextWindow(t *testing.T) {
+	catalog := &modeldb.Catalog{}
+	modeldb.MergeDiscoveredModels(catalog, []modeldb.DiscoveredModel{
+		{Provider: "ollama", ID: "qwen3:8b", ContextWindow: 8192, SupportsTools: true},
+	})
+	r := NewCodergenRouterWithRuntimes(nil, catalog, map[string]ProviderRuntime{
+		"ollama": {Key: "ollama", Backend: BackendAPI, ProfileFamily: "openai"},
+	})
+	p, err := r.agentProfile("ollama", "qwen3:8b")
+	if err != nil {
+		t.Fatalf("agentProfile: %v", err)
+	}
+	if got := p.ContextWindowSize(); got != 8192 {
+		t.Fatalf("context window: got %d want 8192", got)
+	}
+	if p.ID() != "ollama" {
+		t.Fatalf("profile id: got %q want ollama", p.ID())
+	}
+
+	// Models the catalog does not know keep the family default.
+	p, err = r.agentProfile("ollama", "unlisted:1b")
+	if err != nil {
+		t.Fatalf("agentProfile: %v", err)
+	}
+	if got := p.ContextWindowSize(); got != 128_000 {
+		t.Fatalf("default context window: got %d want 128000", got)
+	}
+}
+
 func TestFailoverOrder_UsesRuntimeProviderPolicy(t *testing.T) {
 	rt := map[string]ProviderRuntime{
 		"kimi": {Key: "kimi", Failover: []string{"zai", "openai"}, FailoverExplicit: true},
@@ -19,11 +19,13 @@ import (
 	"time"
 
 	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/gitutil"
 	"github.com/danshapiro/kilroy/internal/attractor/model"
 	"github.com/danshapiro/kilroy/internal/attractor/modeldb"
 	"github.com/danshapiro/kilroy/internal/attractor/runtime"
 	"github.com/danshapiro/kilroy/internal/llm"
 	"github.com/danshapiro/kilroy/internal/llmclient"
+	"github.com/danshapiro/kilroy/internal/tracing"
 )
 
 // anthropicVersionDotRe matches dots between digits in model version numbers
@@ -37,9 +39,10 @@ type CodergenRouter struct {
 	providerRuntimes map[string]ProviderRuntime
 	apiClientFactory func(map[string]ProviderRuntime) (*llm.Client, error)
 
-	apiOnce   sync.Once
-	apiClient *llm.Client
-	apiErr    error
+	apiOnce      sync.Once
+	apiTraceOnce sync.Once
+	apiClient    *llm.Client
+	apiErr       error
 }
 
 func NewCodergenRouter(cfg *RunConfigFile, catalog *modeldb.Catalog) *CodergenRouter {
@@ -109,6 +112,9 @@ func (r *CodergenRouter) Run(ctx context.Context, exec *Execution, node *model.N
 	case BackendAPI:
 		return r.runAPI(ctx, exec, node, prov, modelID, prompt)
 	case BackendCLI:
+		if strings.EqualFold(strings.TrimSpace(node.Attr("llm_mode", "")), "batch") {
+			warnEngine(exec, fmt.Sprintf("batch: node=%s provider=%s runs on the cli backend; ignoring llm_mode=batch", node.ID, prov))
+		}
 		return r.runCLI(ctx, exec, node, prov, modelID, prompt)
 	default:
 		return "", nil, fmt.Errorf("invalid backend for provider %s: %q", prov, backend)
@@ -150,6 +156,12 @@ func (r *CodergenRouter) ensureAPIClient() (*llm.Client, error) {
 		}
 		r.apiClient, r.apiErr = llmclient.NewFromEnv()
 	})
+	r.apiTraceOnce.Do(func() {
+		// Spans are recorded only when the run context carries a tracer.
+		if r.apiClient != nil {
+			r.apiClient.Use(tracing.LLMMiddleware())
+		}
+	})
 	return r.apiClient, r.apiErr
 }
 
@@ -159,9 +171,19 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 		return "", nil, err
 	}
 	contract := buildStageStatusContract(execCtx.WorktreeDir)
+	llmMode, err := llmModeForNode(node)
+	if err != nil {
+		return "", nil, err
+	}
 	mode := strings.ToLower(strings.TrimSpace(node.Attr("codergen_mode", "")))
 	if mode == "" {
 		mode = "agent_loop" // metaspec default for API backend
+		if llmMode == "batch" {
+			mode = "one_shot"
+		}
+	}
+	if llmMode == "batch" && mode != "one_shot" {
+		return "", nil, fmt.Errorf("llm_mode=batch requires codergen_mode=one_shot (got %q)", mode)
 	}
 
 	stageDir := filepath.Join(execCtx.LogsRoot, node.ID)
@@ -187,10 +209,19 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 			if err := writeJSON(filepath.Join(stageDir, "api_request.json"), req); err != nil {
 				warnEngine(execCtx, fmt.Sprintf("write api_request.json: %v", err))
 			}
-			policy := attractorLLMRetryPolicy(execCtx, node.ID, prov, mid)
-			resp, err := llm.Retry(ctx, policy, nil, nil, func() (llm.Response, error) {
-				return client.Complete(ctx, req)
-			})
+			var resp llm.Response
+			var err error
+			if ba, ok := client.BatchAdapter(prov); ok && llmMode == "batch" {
+				resp, err = r.completeViaBatch(ctx, execCtx, node, ba, req, stageDir)
+			} else {
+				if llmMode == "batch" {
+					warnEngine(execCtx, fmt.Sprintf("batch: node=%s provider=%s has no batch endpoint; using synchronous completion", node.ID, prov))
+				}
+				policy := attractorLLMRetryPolicy(execCtx, node.ID, prov, mid)
+				resp, err = llm.Retry(ctx, policy, nil, nil, func() (llm.Response, error) {
+					return client.Complete(ctx, req)
+				})
+			}
 			if err != nil {
 				return "", err
 			}
@@ -205,6 +236,7 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 		_ = writeJSON(filepath.Join(stageDir, "provider_used.json"), map[string]any{
 			"backend":  "api",
 			"mode":     mode,
+			"llm_mode": llmMode,
 			"provider": used.Provider,
 			"model":    used.Model,
 		})
@@ -219,14 +251,36 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 		}
 		overrides := buildAgentLoopOverrides(artifactPolicyFromExecution(execCtx), stageEnv)
 		env := agent.NewLocalExecutionEnvironmentWithPolicy(execCtx.WorktreeDir, overrides, []string{"CLAUDECODE"})
+		env.Journal = agent.NewFileJournal(filepath.Join(stageDir, fileChangesFile))
+		extraTools, err := resolveAgentTools(execCtx, node)
+		if err != nil {
+			return "", nil, err
+		}
+		extraTools = append(extraTools, memoryTools(execCtx, node)...)
+		mcpServers, err := resolveMCPServers(execCtx, node, execCtx.WorktreeDir, stageEnv)
+		if err != nil {
+			return "", nil, err
+		}
+		mcpTools, closeMCP, err := connectMCPServers(ctx, mcpServers, stageDir)
+		if err != nil {
+			return "", nil, err
+		}
+		defer closeMCP()
+		extraTools = append(extraTools, mcpTools...)
+		languageServers, err := resolveLanguageServers(execCtx, node, stageEnv)
+		if err != nil {
+			return "", nil, err
+		}
+		toolPolicyCfg, err := resolveToolPolicy(execCtx, node)
+		if err != nil {
+			return "", nil, err
+		}
+		// Built once so failover attempts share the stage's write budget.
+		toolPolicy := toolPolicyCfg.build()
+		denials := newToolPolicyDenialLog(stageDir)
+		resumeState := resumableAgentSession(execCtx, node, stageDir)
 		text, used, err := r.withFailoverText(ctx, execCtx, node, client, provider, modelID, func(prov string, mid string) (string, error) {
-			var profile agent.ProviderProfile
-			var profileErr error
-			if rt, ok := r.providerRuntimes[normalizeProviderKey(prov)]; ok {
-				profile, profileErr = profileForRuntimeProvider(rt, mid)
-			} else {
-				profile, profileErr = profileForProvider(prov, mid)
-			}
+			profile, profileErr := r.agentProfile(prov, mid)
 			if profileErr != nil {
 				return "", profileErr
 			}
@@ -245,6 +299,11 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 			if v := parseInt(node.Attr("max_agent_turns", ""), 0); v > 0 {
 				sessCfg.MaxTurns = v
 			}
+			if v := parseInt(node.Attr("patch_fuzz", ""), -1); v > 0 {
+				sessCfg.PatchFuzz = v
+			} else if v == 0 {
+				sessCfg.PatchFuzz = -1 // the session reads 0 as "default"
+			}
 			defaultCommandTimeoutMS, maxCommandTimeoutMS := resolveAgentLoopCommandTimeouts(execCtx, node)
 			if defaultCommandTimeoutMS > 0 {
 				sessCfg.DefaultCommandTimeoutMS = defaultCommandTimeoutMS
@@ -260,6 +319,29 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 			sessCfg.ToolCallFilter = func(toolName, callID, argsJSON string) string {
 				return runPreToolHook(ctx, execCtx, node, stageDir, toolName, callID, argsJSON)
 			}
+			sessCfg.ExtraTools = extraTools
+			sessCfg.LanguageServers = languageServers
+			sessCfg.ToolPolicy = toolPolicy
+			sessCfg.StatePath = filepath.Join(stageDir, agentSessionStateFile)
+			sessCfg.StateFingerprint = func() (string, error) { return gitutil.WorktreeTree(execCtx.WorktreeDir) }
+			sessCfg.SubagentIsolation, sessCfg.MaxSubagentDepth = resolveSubagentSettings(execCtx, node)
+			sessCfg.SubagentWorktreeRoot = filepath.Join(stageDir, "subagents")
+			sessCfg.SubagentProfile = func(subProv, subModel string) (agent.ProviderProfile, error) {
+				if subProv == "" {
+					subProv = prov
+				}
+				return r.agentProfile(subProv, subModel)
+			}
+			input := prompt
+			eventsFlags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+			// Only the first attempt continues an interrupted session, and only
+			// with the provider that produced its history.
+			if resumeState != nil && resumeState.Profile == profile.ID() {
+				sessCfg.Resume = resumeState
+				input = resumeState.InterruptedPrompt()
+				eventsFlags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+			}
+			resumeState = nil
 			sess, err := agent.NewSession(client, profile, env, sessCfg)
 			if err != nil {
 				return "", err
@@ -267,7 +349,7 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 
 			eventsPath := filepath.Join(stageDir, "events.ndjson")
 			eventsJSONPath := filepath.Join(stageDir, "events.json")
-			eventsFile, err := os.Create(eventsPath)
+			eventsFile, err := os.OpenFile(eventsPath, eventsFlags, 0o644)
 			if err != nil {
 				return "", err
 			}
@@ -290,6 +372,9 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 					if execCtx != nil && execCtx.Engine != nil && execCtx.Engine.CXDB != nil {
 						emitCXDBToolTurns(ctx, execCtx.Engine, node.ID, ev)
 					}
+					if err := denials.record(ev); err != nil {
+						warnEngine(execCtx, fmt.Sprintf("write tool policy denial: %v", err))
+					}
 					// Spec §9.7: execute tool hooks around tool calls.
 					if execCtx != nil && execCtx.Engine != nil {
 						executeToolHookForEvent(ctx, execCtx, node, ev, stageDir)
@@ -340,7 +425,7 @@ func (r *CodergenRouter) runAPI(ctx context.Context, execCtx *Execution, node *m
 				}
 			}()
 
-			text, runErr := sess.ProcessInput(ctx, prompt)
+			text, runErr := sess.ProcessInput(ctx, input)
 			sess.Close()
 			<-done
 			close(heartbeatStop)
@@ -866,6 +951,26 @@ func resolveAgentLoopCommandTimeouts(execCtx *Execution, node *model.Node) (int,
 	return defaultCommandTimeoutMS, maxCommandTimeoutMS
 }
 
+// resolveSubagentSettings returns the subagent isolation mode and depth limit
+// from the node's subagent_isolation / max_subagent_depth attributes, falling
+// back to the graph's.
+func resolveSubagentSettings(execCtx *Execution, node *model.Node) (string, int) {
+	isolation := ""
+	if node != nil {
+		isolation = strings.TrimSpace(node.Attr("subagent_isolation", ""))
+	}
+	depth := parsePositiveIntAttr(node, "max_subagent_depth")
+	if execCtx != nil && execCtx.Graph != nil {
+		if isolation == "" {
+			isolation = strings.TrimSpace(execCtx.Graph.Attrs["subagent_isolation"])
+		}
+		if depth <= 0 {
+			depth = max(parseInt(execCtx.Graph.Attrs["max_subagent_depth"], 0), 0)
+		}
+	}
+	return isolation, depth
+}
+
 func parsePositiveIntAttr(node *model.Node, key string) int {
 	if node == nil {
 		return 0
@@ -898,6 +1003,35 @@ func profileForRuntimeProvider(rt ProviderRuntime, model string) (agent.Provider
 	}, nil
 }
 
+// agentProfile returns the agent-loop profile for provider and model, with
+// the context window taken from the model catalog when it knows one (e.g.
+// the context_length an Ollama server reports for a local model).
+func (r *CodergenRouter) agentProfile(provider, model string) (agent.ProviderProfile, error) {
+	var profile agent.ProviderProfile
+	var err error
+	if rt, ok := r.providerRuntimes[normalizeProviderKey(provider)]; ok {
+		profile, err = profileForRuntimeProvider(rt, model)
+	} else {
+		profile, err = profileForProvider(provider, model)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry, ok := modeldb.CatalogProviderModel(r.catalog, provider, model); ok && entry.ContextWindow > 0 {
+		return catalogWindowProfile{ProviderProfile: profile, contextWindow: entry.ContextWindow}, nil
+	}
+	return profile, nil
+}
+
+type catalogWindowProfile struct {
+	agent.ProviderProfile
+	contextWindow int
+}
+
+func (p catalogWindowProfile) ContextWindowSize() int {
+	return p.contextWindow
+}
+
 type providerRoutedProfile struct {
 	agent.ProviderProfile
 	providerID string
@@ -1072,6 +1206,20 @@ func (r *CodergenRouter) runCLI(ctx context.Context, execCtx *Execution, node *m
 	stdoutPath := filepath.Join(stageDir, "stdout.log")
 
 	runOnce := func(args []string) (runErr error, exitCode int, dur time.Duration, err error) {
+		_, span := tracing.Start(ctx, "cli.subprocess",
+			tracing.String("process.executable.name", filepath.Base(exe)),
+			tracing.String("gen_ai.system", provider),
+			tracing.String("gen_ai.request.model", modelID),
+		)
+		defer func() {
+			span.SetAttributes(tracing.Int("process.exit_code", exitCode))
+			if err != nil {
+				span.RecordError(err)
+			} else {
+				span.RecordError(runErr)
+			}
+			span.End()
+		}()
 		runCtx := ctx
 		if codexSemantics {
 			totalTimeout := codexTotalTimeout()
@@ -1090,6 +1238,10 @@ func (r *CodergenRouter) runCLI(ctx context.Context, execCtx *Execution, node *m
 			scrubbed := scrubConflictingProviderEnvKeys(baseEnv, providerKey)
 			cmd.Env = mergeEnvWithOverrides(scrubbed, stageEnv)
 		}
+		if tp := span.Traceparent(); tp != "" {
+			// W3C trace context for CLIs that export their own telemetry.
+			cmd.Env = append(cmd.Env, "TRACEPARENT="+tp)
+		}
 		if promptMode == "stdin" {
 			cmd.Stdin = strings.NewReader(prompt)
 		} else {
@@ -1826,6 +1978,38 @@ func emitCXDBToolTurns(ctx context.Context, eng *Engine, nodeID string, ev agent
 		}); err != nil {
 			eng.Warn(fmt.Sprintf("cxdb append ToolResult failed (node=%s tool=%s call_id=%s): %v", nodeID, toolName, callID, err))
 		}
+		changes, _ := ev.Data["file_changes"].([]agent.FileChange)
+		for _, ch := range changes {
+			if _, _, err := eng.CXDB.Append(ctx, "com.kilroy.attractor.FileChanged", 1, map[string]any{
+				"run_id":        runID,
+				"node_id":       nodeID,
+				"tool_name":     toolName,
+				"call_id":       callID,
+				"path":          ch.Path,
+				"op":            ch.Op,
+				"before_sha256": ch.BeforeSHA256,
+				"after_sha256":  ch.AfterSHA256,
+				"diff":          ch.Diff,
+			}); err != nil {
+				eng.Warn(fmt.Sprintf("cxdb append FileChanged failed (node=%s tool=%s call_id=%s path=%s): %v", nodeID, toolName, callID, ch.Path, err))
+			}
+		}
+	case agent.EventToolCallDenied:
+		toolName := strings.TrimSpace(fmt.Sprint(ev.Data["tool_name"]))
+		callID := strings.TrimSpace(fmt.Sprint(ev.Data["call_id"]))
+		if toolName == "" || callID == "" {
+			return
+		}
+		if _, _, err := eng.CXDB.Append(ctx, "com.kilroy.attractor.ToolCallDenied", 1, map[string]any{
+			"run_id":    runID,
+			"node_id":   nodeID,
+			"tool_name": toolName,
+			"call_id":   callID,
+			"rule":      fmt.Sprint(ev.Data["rule"]),
+			"reason":    fmt.Sprint(ev.Data["reason"]),
+		}); err != nil {
+			eng.Warn(fmt.Sprintf("cxdb append ToolCallDenied failed (node=%s tool=%s call_id=%s): %v", nodeID, toolName, callID, err))
+		}
 	}
 }
 
@@ -26,6 +26,7 @@ func TestCLIOnlyModelOverride_SwitchesBackendAndWarns(t *testing.T) {
 	node.Attrs["llm_provider"] = "openai"
 	node.Attrs["llm_model"] = "gpt-5.3-codex-spark"
 	node.Attrs["shape"] = "box"
+	node.Attrs["llm_mode"] = "batch"
 
 	// Create an execution with temp dirs to isolate artifacts and an Engine
 	// to capture warnings.
@@ -57,6 +58,9 @@ func TestCLIOnlyModelOverride_SwitchesBackendAndWarns(t *testing.T) {
 	if !found {
 		t.Errorf("expected warning containing 'cli-only model override', got warnings: %v", eng.Warnings)
 	}
+	if !strings.Contains(strings.Join(eng.Warnings, "\n"), "ignoring llm_mode=batch") {
+		t.Errorf("expected llm_mode=batch warning for cli backend, got warnings: %v", eng.Warnings)
+	}
 }
 
 func TestCLIOnlyModelOverride_RegularModelNoOverride(t *testing.T) {
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/danshapiro/kilroy/internal/agent"
 	"github.com/danshapiro/kilroy/internal/providerspec"
 
 	"gopkg.in/yaml.v3"
@@ -58,6 +59,23 @@ type PreflightConfig struct {
 	PromptProbes PromptProbeConfig `json:"prompt_probes,omitempty" yaml:"prompt_probes,omitempty"`
 }
 
+// TracingConfig enables OpenTelemetry-compatible tracing for a run: one trace
+// per run with spans for node attempts, agent turns and tool calls, LLM
+// requests and CLI subprocesses.
+type TracingConfig struct {
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Exporter is "file" (OTLP/JSON lines, default) or "otlp_http".
+	Exporter string `json:"exporter,omitempty" yaml:"exporter,omitempty"`
+	// FilePath defaults to <logs_root>/traces.jsonl.
+	FilePath string `json:"file_path,omitempty" yaml:"file_path,omitempty"`
+	// Endpoint is the OTLP/HTTP collector URL; defaults to
+	// OTEL_EXPORTER_OTLP_ENDPOINT.
+	Endpoint    string            `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	TimeoutMS   int               `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`
+	ServiceName string            `json:"service_name,omitempty" yaml:"service_name,omitempty"`
+}
+
 type RunConfigFile struct {
 	Version int `json:"version" yaml:"version"`
 	// Graph and Task are optional operator metadata fields used by wrappers/UI.
@@ -114,6 +132,90 @@ type RunConfigFile struct {
 
 	RuntimePolicy RuntimePolicyConfig `json:"runtime_policy,omitempty" yaml:"runtime_policy,omitempty"`
 	Preflight     PreflightConfig     `json:"preflight,omitempty" yaml:"preflight,omitempty"`
+	Tracing       TracingConfig       `json:"tracing,omitempty" yaml:"tracing,omitempty"`
+	MCP           MCPConfig           `json:"mcp,omitempty" yaml:"mcp,omitempty"`
+	AgentTools    []AgentToolConfig   `json:"agent_tools,omitempty" yaml:"agent_tools,omitempty"`
+	// LanguageServers back the code intelligence tools (go_to_definition,
+	// find_references, diagnostics, document_symbols) of API agent_loop
+	// stages, keyed by server name.
+	LanguageServers map[string]LanguageServerConfig `json:"language_servers,omitempty" yaml:"language_servers,omitempty"`
+	// ToolPolicy applies to every API agent_loop stage; ToolPolicies are
+	// named alternatives a node (or graph) selects with tool_policy=<name>.
+	ToolPolicy   *ToolPolicyConfig           `json:"tool_policy,omitempty" yaml:"tool_policy,omitempty"`
+	ToolPolicies map[string]ToolPolicyConfig `json:"tool_policies,omitempty" yaml:"tool_policies,omitempty"`
+}
+
+// ToolPolicyConfig allows or denies agent tool calls before they run (see
+// agent.ToolPolicy for matching rules). Deny rules win over allow rules;
+// Default applies when no rule matches.
+type ToolPolicyConfig struct {
+	Default   string                 `json:"default,omitempty" yaml:"default,omitempty"`
+	MaxWrites int                    `json:"max_writes,omitempty" yaml:"max_writes,omitempty"`
+	Rules     []ToolPolicyRuleConfig `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+type ToolPolicyRuleConfig struct {
+	Name     string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Effect   string   `json:"effect" yaml:"effect"`
+	Tools    []string `json:"tools,omitempty" yaml:"tools,omitempty"`
+	Commands []string `json:"commands,omitempty" yaml:"commands,omitempty"`
+	Paths    []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	// Access is read or write; empty applies Paths to both.
+	Access string `json:"access,omitempty" yaml:"access,omitempty"`
+	Reason string `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+// MCPConfig declares Model Context Protocol tool servers for API agent_loop
+// stages. Every server is attached to every such stage unless the node (or
+// graph) sets mcp_servers to a comma-separated subset, or "none".
+type MCPConfig struct {
+	Servers map[string]MCPServerConfig `json:"servers,omitempty" yaml:"servers,omitempty"`
+}
+
+// MCPServerConfig is one server: Command (stdio, started in the stage
+// worktree) or URL (streamable HTTP), not both.
+type MCPServerConfig struct {
+	Command []string          `json:"command,omitempty" yaml:"command,omitempty"`
+	Env     map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	URL     string            `json:"url,omitempty" yaml:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// BearerTokenEnv names an environment variable whose value is sent as
+	// "Authorization: Bearer <token>" to an HTTP server.
+	BearerTokenEnv string `json:"bearer_token_env,omitempty" yaml:"bearer_token_env,omitempty"`
+	// TimeoutMS bounds each request to the server (default 120000).
+	TimeoutMS int `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`
+}
+
+// LanguageServerConfig is a language server started over stdio in the stage
+// worktree for files with the given extensions (".go"). A node (or graph)
+// selects a subset with language_servers=<names>, or "none".
+type LanguageServerConfig struct {
+	Command    []string          `json:"command" yaml:"command"`
+	Extensions []string          `json:"extensions" yaml:"extensions"`
+	LanguageID string            `json:"language_id,omitempty" yaml:"language_id,omitempty"`
+	Env        map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// TimeoutMS bounds each request to the server (default 60000).
+	TimeoutMS int `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`
+	// DiagnosticsWaitMS bounds how long the diagnostics tool waits for the
+	// server to analyze a changed file (default 10000).
+	DiagnosticsWaitMS int `json:"diagnostics_wait_ms,omitempty" yaml:"diagnostics_wait_ms,omitempty"`
+}
+
+// AgentToolConfig declares a command-backed tool offered to every API
+// agent_loop stage (see agent.CommandToolSpec for how arguments reach the
+// command). DOT graphs and nodes can declare more via agent_tool.<name>.*
+// attributes.
+type AgentToolConfig struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Parameters  map[string]any    `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Command     string            `json:"command" yaml:"command"`
+	TimeoutMS   int               `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`
+	Env         map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	MaxChars    int               `json:"max_chars,omitempty" yaml:"max_chars,omitempty"`
+	MaxLines    int               `json:"max_lines,omitempty" yaml:"max_lines,omitempty"`
+	// Truncation is head_tail (default) or tail.
+	Truncation string `json:"truncation,omitempty" yaml:"truncation,omitempty"`
 }
 
 func LoadRunConfigFile(path string) (*RunConfigFile, error) {
@@ -252,6 +354,43 @@ func applyConfigDefaults(cfg *RunConfigFile) {
 	}
 
 	cfg.Preflight.PromptProbes.Transports = trimNonEmpty(cfg.Preflight.PromptProbes.Transports)
+
+	cfg.Tracing.Exporter = strings.ToLower(strings.TrimSpace(cfg.Tracing.Exporter))
+	if cfg.Tracing.Enabled && cfg.Tracing.Exporter == "" {
+		cfg.Tracing.Exporter = "file"
+	}
+	cfg.Tracing.FilePath = strings.TrimSpace(cfg.Tracing.FilePath)
+	cfg.Tracing.Endpoint = strings.TrimSpace(cfg.Tracing.Endpoint)
+
+	for i := range cfg.AgentTools {
+		t := &cfg.AgentTools[i]
+		t.Name = strings.TrimSpace(t.Name)
+		t.Truncation = strings.ToLower(strings.TrimSpace(t.Truncation))
+	}
+	if cfg.ToolPolicy != nil {
+		cfg.ToolPolicy.normalize()
+	}
+	for name, p := range cfg.ToolPolicies {
+		p.normalize()
+		cfg.ToolPolicies[name] = p
+	}
+	for name, srv := range cfg.MCP.Servers {
+		srv.Command = trimExecutable(srv.Command)
+		srv.URL = strings.TrimSpace(srv.URL)
+		srv.BearerTokenEnv = strings.TrimSpace(srv.BearerTokenEnv)
+		cfg.MCP.Servers[name] = srv
+	}
+	for name, srv := range cfg.LanguageServers {
+		srv.Command = trimNonEmpty(srv.Command)
+		srv.Extensions = trimNonEmpty(srv.Extensions)
+		for i, ext := range srv.Extensions {
+			if !strings.HasPrefix(ext, ".") {
+				srv.Extensions[i] = "." + ext
+			}
+		}
+		srv.LanguageID = strings.TrimSpace(srv.LanguageID)
+		cfg.LanguageServers[name] = srv
+	}
 }
 
 func validateConfig(cfg *RunConfigFile) error {
@@ -362,6 +501,69 @@ func validateConfig(cfg *RunConfigFile) error {
 	if err := validateArtifactPolicyConfig(cfg); err != nil {
 		return err
 	}
+	if cfg.Tracing.Enabled {
+		switch cfg.Tracing.Exporter {
+		case "file":
+		case "otlp_http":
+			if cfg.Tracing.Endpoint == "" && strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == "" {
+				return fmt.Errorf("tracing.endpoint (or OTEL_EXPORTER_OTLP_ENDPOINT) is required when tracing.exporter=otlp_http")
+			}
+		default:
+			return fmt.Errorf("invalid tracing.exporter: %q (want file|otlp_http)", cfg.Tracing.Exporter)
+		}
+		if cfg.Tracing.TimeoutMS < 0 {
+			return fmt.Errorf("tracing.timeout_ms must be >= 0")
+		}
+	}
+	seenTools := map[string]bool{}
+	for i, t := range cfg.AgentTools {
+		if _, err := agent.NewCommandTool(t.spec()); err != nil {
+			return fmt.Errorf("agent_tools[%d]: %w", i, err)
+		}
+		if seenTools[t.Name] {
+			return fmt.Errorf("agent_tools[%d]: duplicate tool name %q", i, t.Name)
+		}
+		seenTools[t.Name] = true
+	}
+	if err := cfg.ToolPolicy.build().Validate(); err != nil {
+		return fmt.Errorf("tool_policy: %w", err)
+	}
+	for name, p := range cfg.ToolPolicies {
+		if name == "" || name == "none" || strings.ContainsAny(name, ", ") {
+			return fmt.Errorf("invalid tool_policies key %q", name)
+		}
+		if err := p.build().Validate(); err != nil {
+			return fmt.Errorf("tool_policies.%s: %w", name, err)
+		}
+	}
+	for name, srv := range cfg.MCP.Servers {
+		if !validMCPServerName(name) {
+			return fmt.Errorf("invalid mcp.servers key %q (want letters, digits, '_' or '-')", name)
+		}
+		if (len(srv.Command) > 0) == (srv.URL != "") {
+			return fmt.Errorf("mcp.servers.%s: exactly one of command or url is required", name)
+		}
+		if len(srv.Command) > 0 && srv.Command[0] == "" {
+			return fmt.Errorf("mcp.servers.%s: command must start with an executable", name)
+		}
+		if srv.TimeoutMS < 0 {
+			return fmt.Errorf("mcp.servers.%s.timeout_ms must be >= 0", name)
+		}
+	}
+	for name, srv := range cfg.LanguageServers {
+		if !validMCPServerName(name) || strings.EqualFold(name, "none") {
+			return fmt.Errorf("invalid language_servers key %q (want letters, digits, '_' or '-')", name)
+		}
+		if len(srv.Command) == 0 {
+			return fmt.Errorf("language_servers.%s: command is required", name)
+		}
+		if len(srv.Extensions) == 0 {
+			return fmt.Errorf("language_servers.%s: extensions are required", name)
+		}
+		if srv.TimeoutMS < 0 || srv.DiagnosticsWaitMS < 0 {
+			return fmt.Errorf("language_servers.%s: timeout_ms and diagnostics_wait_ms must be >= 0", name)
+		}
+	}
 	return nil
 }
 
@@ -382,6 +584,17 @@ func trimNonEmpty(parts []string) []string {
 	return out
 }
 
+// trimExecutable trims the executable of an argv; the arguments are kept
+// verbatim, including deliberately empty ones.
+func trimExecutable(argv []string) []string {
+	if len(argv) == 0 {
+		return nil
+	}
+	out := append([]string{}, argv...)
+	out[0] = strings.TrimSpace(out[0])
+	return out
+}
+
 // resolveRequireClean returns the effective require_clean value from the config,
 // defaulting to true when the config is nil or the field is unset.
 func resolveRequireClean(cfg *RunConfigFile) bool {
@@ -325,3 +325,23 @@ func (e *Engine) cxdbRunFailed(ctx context.Context, nodeID string, sha string, r
 	})
 	return turnID, err
 }
+
+// cxdbMemoryWritten mirrors a run memory write (or deletion) to CXDB.
+func (e *Engine) cxdbMemoryWritten(ctx context.Context, ent memoryEntry) {
+	if e == nil || e.CXDB == nil {
+		return
+	}
+	data := map[string]any{
+		"run_id":       e.Options.RunID,
+		"node_id":      ent.NodeID,
+		"timestamp_ms": nowMS(),
+		"namespace":    ent.Namespace,
+		"key":          ent.Key,
+	}
+	if ent.Value == "" {
+		data["deleted"] = true
+	} else {
+		data["value"] = ent.Value
+	}
+	_, _, _ = e.CXDB.Append(ctx, "com.kilroy.attractor.MemoryWritten", 1, data)
+}
@@ -2,7 +2,9 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -192,6 +194,28 @@ type Engine struct {
 	forceNextFidelityUsed bool        // true once the override has been consumed
 	lastResolvedFidelity  string      // last resolved LLM fidelity for checkpoint/resume
 	lastResolvedThreadKey string      // thread key when fidelity=full (best-effort)
+
+	// checkpointMu serializes writes of <logs_root>/checkpoint.json: the
+	// node checkpoint and the in-place updates of updateCheckpoint.
+	checkpointMu sync.Mutex
+
+	// In-flight provider batches (llm_mode=batch), keyed by node ID.
+	pendingBatchesMu sync.Mutex
+	pendingBatches   map[string]batchStageState
+
+	// Run-scoped memory behind the memory_* agent tools (see run_memory.go).
+	memory *runMemory
+
+	// Worktree snapshot taken before resume reset the worktree, offered to
+	// the first node executed afterwards (see resumeSnapshotFor).
+	resumeWorktreeMu   sync.Mutex
+	resumeWorktreeTree string
+	resumeWorktreeNode string
+
+	// Prompt template state (see prompt_template.go): the run loop's
+	// per-node outcomes, and why each node's last failed attempt failed.
+	nodeOutcomes        map[string]runtime.Outcome
+	retryFailureReasons map[string]string
 }
 
 func (e *Engine) Warn(msg string) {
@@ -233,13 +257,61 @@ type Result struct {
 
 type PrepareOptions struct {
 	Transforms []Transform
-	// RepoPath is the repository root directory. When set, prompt_file attributes
-	// on nodes are resolved relative to this path before other transforms run.
+	// RepoPath is the repository root directory. When set, import and
+	// prompt_file attributes on nodes are resolved relative to this path
+	// before other transforms run. Without it, imports resolve relative to
+	// the directory of Filename.
 	RepoPath string
 	// KnownTypes is an optional list of handler type strings. When non-empty,
 	// the TypeKnownRule lint rule is added to validation so that nodes with
 	// explicit type= attributes not in this set produce a warning.
 	KnownTypes []string
+	// Filename is the path the DOT source was read from. It is recorded in the
+	// source spans of graph elements and diagnostics.
+	Filename string
+}
+
+// parseAndExpand parses the DOT source and runs the built-in transforms
+// that come before the stylesheet: imports, then prompt_file resolution.
+// Imports run first so fragment stages get the rest; prompt_file runs next
+// so loaded content gets stylesheet defaults and $goal expansion.
+func parseAndExpand(dotSource []byte, opts PrepareOptions) (*model.Graph, error) {
+	g, err := dot.ParseFile(opts.Filename, dotSource)
+	if err != nil {
+		return nil, err
+	}
+	importBase := opts.RepoPath
+	if importBase == "" && opts.Filename != "" {
+		importBase = filepath.Dir(opts.Filename)
+	}
+	if err := (importTransform{baseDir: importBase, file: opts.Filename}).Apply(g); err != nil {
+		return g, fmt.Errorf("import expansion: %w", err)
+	}
+	if opts.RepoPath != "" {
+		if err := expandPromptFiles(g, opts.RepoPath); err != nil {
+			return g, fmt.Errorf("prompt_file expansion: %w", err)
+		}
+	}
+	return g, nil
+}
+
+// ExplainStylesheet returns the graph exactly as the model stylesheet sees
+// it during Prepare, together with the parsed stylesheet rules, so callers
+// can show which rule set each node attribute (see style.Cascade).
+func ExplainStylesheet(dotSource []byte, opts PrepareOptions) (*model.Graph, []style.Rule, error) {
+	g, err := parseAndExpand(dotSource, opts)
+	if err != nil {
+		return g, nil, err
+	}
+	raw := strings.TrimSpace(g.Attrs["model_stylesheet"])
+	if raw == "" {
+		return g, nil, nil
+	}
+	rules, err := style.ParseStylesheet(raw)
+	if err != nil {
+		return g, nil, fmt.Errorf("stylesheet parse: %w", err)
+	}
+	return g, rules, nil
 }
 
 // Prepare parses/transforms/validates a graph.
@@ -256,18 +328,12 @@ func PrepareWithRegistry(dotSource []byte, reg *TransformRegistry) (*model.Graph
 }
 
 func PrepareWithOptions(dotSource []byte, opts PrepareOptions) (*model.Graph, []validate.Diagnostic, error) {
-	g, err := dot.Parse(dotSource)
+	g, err := parseAndExpand(dotSource, opts)
 	if err != nil {
-		return nil, nil, err
+		return g, nil, err
 	}
 
-	// Built-in transforms: prompt_file resolution, stylesheet, $goal expansion.
-	// prompt_file runs first so loaded content gets stylesheet defaults and $goal expansion.
-	if opts.RepoPath != "" {
-		if err := expandPromptFiles(g, opts.RepoPath); err != nil {
-			return g, nil, fmt.Errorf("prompt_file expansion: %w", err)
-		}
-	}
+	// Remaining built-in transforms: stylesheet, then $goal expansion.
 	if raw := strings.TrimSpace(g.Attrs["model_stylesheet"]); raw != "" {
 		rules, err := style.ParseStylesheet(raw)
 		if err != nil {
@@ -275,6 +341,8 @@ func PrepareWithOptions(dotSource []byte, opts PrepareOptions) (*model.Graph, []
 				Rule:     "stylesheet_syntax",
 				Severity: validate.SeverityError,
 				Message:  err.Error(),
+				Attr:     "model_stylesheet",
+				Span:     g.AttrSpan("model_stylesheet"),
 			}}
 			return g, diags, fmt.Errorf("stylesheet parse: %w", err)
 		}
@@ -333,6 +401,8 @@ func Run(ctx context.Context, dotSource []byte, opts RunOptions) (*Result, error
 }
 
 func (e *Engine) run(ctx context.Context) (res *Result, err error) {
+	ctx, endTrace := e.startRunTracing(ctx, false)
+	defer func() { endTrace(err) }()
 	runCtx, cancelRun := context.WithCancelCause(ctx)
 	defer cancelRun(nil)
 
@@ -439,6 +509,7 @@ func (e *Engine) run(ctx context.Context) (res *Result, err error) {
 }
 
 func (e *Engine) runLoop(ctx context.Context, current string, completed []string, nodeRetries map[string]int, nodeOutcomes map[string]runtime.Outcome) (*Result, error) {
+	e.nodeOutcomes = nodeOutcomes
 	nodeVisits := map[string]int{}
 	visitLimit := maxNodeVisits(e.Graph)
 	for {
@@ -860,6 +931,7 @@ func (e *Engine) loopRestart(ctx context.Context, targetNodeID string, fromNodeI
 
 	// Switch to fresh logs; worktree stays the same.
 	e.LogsRoot = newLogsRoot
+	e.memory.relocate(newLogsRoot)
 
 	// Write run metadata into the restart directory so consumers find manifest.json.
 	if err := e.writeManifest(e.baseSHA); err != nil {
@@ -937,6 +1009,13 @@ func (e *Engine) snapshotPersistKeys() map[string]any {
 }
 
 func (e *Engine) executeNode(ctx context.Context, node *model.Node) (runtime.Outcome, error) {
+	ctx, span := startNodeSpan(ctx, node)
+	out, err := e.executeNodeAttempt(ctx, node)
+	endNodeSpan(span, out, err)
+	return out, err
+}
+
+func (e *Engine) executeNodeAttempt(ctx context.Context, node *model.Node) (runtime.Outcome, error) {
 	// Effective timeout uses the smaller positive timeout between node timeout
 	// and global StageTimeout.
 	if timeout := effectiveStageTimeout(node, e.Options.StageTimeout); timeout > 0 {
@@ -946,6 +1025,7 @@ func (e *Engine) executeNode(ctx context.Context, node *model.Node) (runtime.Out
 	}
 
 	h := e.Registry.Resolve(node)
+	e.resumeSnapshotFor(node.ID, false)
 	stageDir := filepath.Join(e.LogsRoot, node.ID)
 	if err := os.MkdirAll(stageDir, 0o755); err != nil {
 		return runtime.Outcome{Status: runtime.StatusFail, FailureReason: err.Error()}, err
@@ -1086,7 +1166,7 @@ func (e *Engine) executeWithRetry(ctx context.Context, node *model.Node, retries
 			"attempt": 1,
 			"max":     1,
 		})
-		out, _ := e.executeNode(ctx, node)
+		out, _ := e.executeNode(withStageAttempt(ctx, 1), node)
 		e.appendProgress(map[string]any{
 			"event":          "stage_attempt_end",
 			"node_id":        node.ID,
@@ -1133,7 +1213,7 @@ func (e *Engine) executeWithRetry(ctx context.Context, node *model.Node, retries
 			"attempt": attempt,
 			"max":     maxAttempts,
 		})
-		out, _ := e.executeNode(ctx, node)
+		out, _ := e.executeNode(withStageAttempt(ctx, attempt), node)
 		e.appendProgress(map[string]any{
 			"event":          "stage_attempt_end",
 			"node_id":        node.ID,
@@ -1210,6 +1290,7 @@ func (e *Engine) executeWithRetry(ctx context.Context, node *model.Node, retries
 		e.cxdbStageFailed(ctx, node, out.FailureReason, willRetry, attempt)
 		if canRetry {
 			retries[node.ID]++
+			e.recordAttemptFailure(node.ID, out)
 			// Spec §5.1: update built-in context key internal.retry_count.<node_id> on each retry.
 			e.Context.Set(fmt.Sprintf("internal.retry_count.%s", node.ID), retries[node.ID])
 			delay := backoffDelayForNode(e.Options.RunID, e.Graph, node, attempt)
@@ -1337,6 +1418,10 @@ func (e *Engine) checkpoint(nodeID string, out runtime.Outcome, completed []stri
 			return "", fmt.Errorf("handler-provided checkpoint sha does not match HEAD (head=%s meta=%s)", head, sha)
 		}
 	}
+	// Hold the lock while snapshotting so a concurrent updateCheckpoint
+	// cannot be overwritten by older batch or memory state.
+	e.checkpointMu.Lock()
+	defer e.checkpointMu.Unlock()
 	cp := runtime.NewCheckpoint()
 	cp.Timestamp = time.Now().UTC()
 	cp.CurrentNode = nodeID
@@ -1356,12 +1441,21 @@ func (e *Engine) checkpoint(nodeID string, out runtime.Outcome, completed []stri
 	if len(e.loopFailureSignatures) > 0 {
 		cp.Extra["loop_failure_signatures"] = copyStringIntMap(e.loopFailureSignatures)
 	}
+	if len(e.retryFailureReasons) > 0 {
+		cp.Extra["retry_failure_reasons"] = copyStringStringMap(e.retryFailureReasons)
+	}
 	if strings.TrimSpace(e.lastResolvedFidelity) != "" {
 		cp.Extra["last_fidelity"] = e.lastResolvedFidelity
 		if strings.TrimSpace(e.lastResolvedThreadKey) != "" {
 			cp.Extra["last_thread_key"] = e.lastResolvedThreadKey
 		}
 	}
+	if pending := e.pendingBatchesSnapshot(); len(pending) > 0 {
+		cp.Extra[pendingBatchesExtraKey] = pending
+	}
+	if mem := e.memory.snapshot(); len(mem) > 0 {
+		cp.Extra[memoryExtraKey] = mem
+	}
 	cp.Extra[artifactPolicyResolvedExtraKey] = artifactPolicyResolvedEnvelope{
 		Version: artifactPolicyResolvedVersion,
 		Policy:  normalizeResolvedArtifactPolicy(e.ArtifactPolicy),
@@ -1372,6 +1466,27 @@ func (e *Engine) checkpoint(nodeID string, out runtime.Outcome, completed []stri
 	return sha, nil
 }
 
+// updateCheckpoint rewrites the existing checkpoint with update applied, for
+// state that must survive a stop between node checkpoints. It does nothing
+// before the first checkpoint is written.
+func (e *Engine) updateCheckpoint(update func(cp *runtime.Checkpoint)) error {
+	e.checkpointMu.Lock()
+	defer e.checkpointMu.Unlock()
+	cpPath := filepath.Join(e.LogsRoot, "checkpoint.json")
+	cp, err := runtime.LoadCheckpoint(cpPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if cp.Extra == nil {
+		cp.Extra = map[string]any{}
+	}
+	update(cp)
+	return cp.Save(cpPath)
+}
+
 func (e *Engine) checkpointExcludeGlobs() []string {
 	if e == nil {
 		return nil
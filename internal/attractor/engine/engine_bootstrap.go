@@ -17,6 +17,7 @@ func newBaseEngine(g *model.Graph, dotSource []byte, opts RunOptions) *Engine {
 		Interviewer: &AutoApproveInterviewer{},
 		Artifacts:   NewArtifactStore(opts.LogsRoot, DefaultFileBackingThreshold),
 	}
+	e.memory = newRunMemory(opts.LogsRoot, e)
 	if opts.ProgressSink != nil {
 		e.progressSink = opts.ProgressSink
 	}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+)
+
+func TestRunWithConfig_APIAgentLoop_JournalsFileChanges(t *testing.T) {
+	repo := initTestRepo(t)
+	logsRoot := t.TempDir()
+	pinned := writePinnedCatalog(t)
+	cxdbSrv := newCXDBTestServer(t)
+	hookOut := filepath.Join(t.TempDir(), "post-hook.json")
+
+	var mu sync.Mutex
+	calls := 0
+	openaiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/responses" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{
+  "id": "resp_1",
+  "model": "gpt-5.2",
+  "output": [{"type":"function_call","id":"call_p","call_id":"call_p","name":"apply_patch","arguments":"{\"patch\":\"*** Begin Patch\\n*** Add File: notes.txt\\n+hello\\n*** End Patch\\n\"}"}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{
+  "id": "resp_2",
+  "model": "gpt-5.2",
+  "output": [{"type":"message","content":[{"type":"output_text","text":"done"}]}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+	}))
+	t.Cleanup(openaiSrv.Close)
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_BASE_URL", openaiSrv.URL)
+
+	cfg := &RunConfigFile{Version: 1}
+	cfg.Repo.Path = repo
+	cfg.CXDB.BinaryAddr = cxdbSrv.BinaryAddr()
+	cfg.CXDB.HTTPBaseURL = cxdbSrv.URL()
+	cfg.LLM.Providers = map[string]ProviderConfig{
+		"openai": {Backend: BackendAPI, Failover: []string{}},
+	}
+	cfg.ModelDB.OpenRouterModelInfoPath = pinned
+	cfg.ModelDB.OpenRouterModelInfoUpdatePolicy = "pinned"
+	cfg.Git.RunBranchPrefix = "attractor/run"
+	disableProbe := false
+	cfg.Preflight.PromptProbes.Enabled = &disableProbe
+
+	dot := []byte(`
+digraph G {
+  graph [goal="write notes"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, auto_status=true, prompt="write notes.txt", tool_hooks.post="cat > ` + hookOut + `"]
+  start -> a -> exit
+}
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	res, err := RunWithConfig(ctx, dot, cfg, RunOptions{RunID: "file-changes-test", LogsRoot: logsRoot})
+	if err != nil {
+		t.Fatalf("RunWithConfig: %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(res.LogsRoot, "a", fileChangesFile))
+	if err != nil {
+		t.Fatalf("read %s: %v", fileChangesFile, err)
+	}
+	var ch agent.FileChange
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &ch); err != nil {
+		t.Fatalf("decode journal %q: %v", b, err)
+	}
+	if ch.Tool != "apply_patch" || ch.CallID != "call_p" || ch.Path != "notes.txt" || ch.Op != "create" || !strings.Contains(ch.Diff, "+hello\n") {
+		t.Fatalf("journal record: %+v", ch)
+	}
+
+	hook, err := os.ReadFile(hookOut)
+	if err != nil {
+		t.Fatalf("read post-hook payload: %v", err)
+	}
+	if !strings.Contains(string(hook), `"file_changes"`) || !strings.Contains(string(hook), ch.AfterSHA256) {
+		t.Fatalf("post-hook payload lacks file changes: %s", hook)
+	}
+
+	found := false
+	for _, cid := range cxdbSrv.ContextIDs() {
+		for _, turn := range cxdbSrv.Turns(cid) {
+			if turn["type_id"] == "com.kilroy.attractor.FileChanged" {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("no FileChanged turn in CXDB")
+	}
+}
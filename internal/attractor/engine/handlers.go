@@ -270,6 +270,13 @@ func (h *CodergenHandler) Execute(ctx context.Context, exec *Execution, node *mo
 	if basePrompt == "" {
 		basePrompt = node.Label()
 	}
+	if promptTemplated(exec, node) {
+		rendered, err := renderPromptTemplate(exec, node, basePrompt)
+		if err != nil {
+			return runtime.Outcome{Status: runtime.StatusFail, FailureReason: "prompt template: " + err.Error()}, nil
+		}
+		basePrompt = strings.TrimSpace(rendered)
+	}
 
 	// Fidelity preamble (attractor-spec context fidelity): when fidelity is not `full`, synthesize
 	// a context carryover preamble at execution time.
@@ -278,6 +285,10 @@ func (h *CodergenHandler) Execute(ctx context.Context, exec *Execution, node *mo
 		fidelity = strings.TrimSpace(exec.Engine.lastResolvedFidelity)
 	}
 	promptText := basePrompt
+	// Run memory (memory_write notes from earlier stages), sized by fidelity.
+	if memory := buildMemoryPromptSection(exec, node, fidelity); memory != "" {
+		promptText = memory + "\n\n" + promptText
+	}
 	if fidelity != "full" {
 		runID := ""
 		if exec != nil && exec.Engine != nil {
@@ -295,7 +306,7 @@ func (h *CodergenHandler) Execute(ctx context.Context, exec *Execution, node *mo
 			prevNode = exec.Context.GetString("previous_node", "")
 		}
 		preamble := buildFidelityPreamble(exec.Context, runID, goal, fidelity, prevNode, decodeCompletedNodes(exec.Context))
-		promptText = strings.TrimSpace(preamble) + "\n\n" + basePrompt
+		promptText = strings.TrimSpace(preamble) + "\n\n" + promptText
 	}
 	if preamble := strings.TrimSpace(contract.PromptPreamble); preamble != "" {
 		if strings.TrimSpace(promptText) == "" {
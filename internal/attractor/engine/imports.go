@@ -0,0 +1,400 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// importTransform inlines DOT fragments into the graph. A node with an
+// import attribute is replaced by the fragment's stages:
+//
+//	review [import="pipelines/review_loop.dot", param.lang="go"]
+//	impl -> review -> deploy
+//
+// The fragment is an ordinary pipeline with a start and an exit node. Its
+// other nodes are added as "<import node>.<fragment node>" (review.impl,
+// review.test, ...). Edges into the import node are joined to the
+// fragment's start edges, and the fragment's edges into its exit are joined
+// to the import node's outgoing edges; joined edges AND their conditions,
+// with the outer edge's other attrs winning. The fragment runs inline, so
+// its stages share the run's context and checkpoint history.
+//
+// Fragments declare parameters as graph attrs (param.lang="python" gives a
+// default); the import node sets them with param.<name>=value, and $name is
+// substituted in every node and edge attr of the fragment, including prompts
+// loaded from prompt_file. A class on the import node is added to every
+// imported node so the stylesheet can target the whole fragment.
+//
+// Import paths, like prompt_file, are relative to the repo root. Fragments
+// may import other fragments.
+type importTransform struct {
+	baseDir string
+	// file is the graph's own path, so a fragment importing it is a cycle.
+	file string
+}
+
+func (t importTransform) ID() string { return "expand_imports" }
+
+func (t importTransform) Apply(g *model.Graph) error {
+	var stack []string
+	if t.file != "" {
+		if abs, err := filepath.Abs(t.file); err == nil {
+			stack = append(stack, abs)
+		}
+	}
+	return expandImports(g, t.baseDir, stack)
+}
+
+// importRefAttrs hold node IDs and are re-pointed when nodes are renamed.
+var importRefAttrs = []string{"retry_target", "fallback_retry_target", "human.default_choice"}
+
+// expandImports splices every import node of g. stack holds the fragment
+// files being expanded, to reject cycles.
+func expandImports(g *model.Graph, baseDir string, stack []string) error {
+	var imports []*model.Node
+	for _, n := range g.Nodes {
+		if n != nil && strings.TrimSpace(n.Attrs["import"]) != "" {
+			imports = append(imports, n)
+		}
+	}
+	if len(imports) == 0 {
+		return nil
+	}
+	if baseDir == "" {
+		return fmt.Errorf("node %q: import needs a repo path to resolve %q", imports[0].ID, imports[0].Attrs["import"])
+	}
+	sort.Slice(imports, func(i, j int) bool { return imports[i].Order < imports[j].Order })
+	for _, imp := range imports {
+		if err := spliceImport(g, imp, baseDir, stack); err != nil {
+			return fmt.Errorf("node %q: %w", imp.ID, err)
+		}
+	}
+	return nil
+}
+
+func loadFragment(imp *model.Node, baseDir string, stack []string) (*model.Graph, error) {
+	rel := strings.TrimSpace(imp.Attrs["import"])
+	path := rel
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	for _, p := range stack {
+		if p == path {
+			return nil, fmt.Errorf("import cycle: %s -> %s", strings.Join(stack, " -> "), path)
+		}
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("import %q: %w", rel, err)
+	}
+	frag, err := dot.ParseFile(path, src)
+	if err != nil {
+		return nil, fmt.Errorf("import %q: %w", rel, err)
+	}
+	if err := expandImports(frag, baseDir, append(stack, path)); err != nil {
+		return nil, fmt.Errorf("import %q: %w", rel, err)
+	}
+	if err := expandPromptFiles(frag, baseDir); err != nil {
+		return nil, fmt.Errorf("import %q: %w", rel, err)
+	}
+	params, err := importParams(frag, imp)
+	if err != nil {
+		return nil, fmt.Errorf("import %q: %w", rel, err)
+	}
+	for _, n := range frag.Nodes {
+		substituteParams(n.Attrs, params)
+	}
+	for _, e := range frag.Edges {
+		substituteParams(e.Attrs, params)
+	}
+	return frag, nil
+}
+
+// importParams merges the fragment's param.* defaults with the import
+// node's values. Setting a parameter the fragment does not declare is an
+// error, to catch typos.
+func importParams(frag *model.Graph, imp *model.Node) (map[string]string, error) {
+	params := map[string]string{}
+	for k, v := range frag.Attrs {
+		if name, ok := strings.CutPrefix(k, "param."); ok {
+			params[name] = v
+		}
+	}
+	for k, v := range imp.Attrs {
+		name, ok := strings.CutPrefix(k, "param.")
+		if !ok {
+			continue
+		}
+		if _, declared := params[name]; !declared {
+			return nil, fmt.Errorf("parameter %q is not declared by the fragment (declare it as graph attr param.%s)", name, name)
+		}
+		params[name] = v
+	}
+	return params, nil
+}
+
+// substituteParams replaces $name with the parameter's value in each attr.
+// Longer names are replaced first so $lang does not clobber $language, and a
+// name only matches when not followed by another identifier character.
+func substituteParams(attrs map[string]string, params map[string]string) {
+	if len(params) == 0 {
+		return
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for k, v := range attrs {
+		if !strings.Contains(v, "$") {
+			continue
+		}
+		var b strings.Builder
+		for i := 0; i < len(v); {
+			if v[i] == '$' {
+				if name := matchParam(v[i+1:], names); name != "" {
+					b.WriteString(params[name])
+					i += 1 + len(name)
+					continue
+				}
+			}
+			b.WriteByte(v[i])
+			i++
+		}
+		attrs[k] = b.String()
+	}
+}
+
+func matchParam(s string, names []string) string {
+	for _, name := range names {
+		if !strings.HasPrefix(s, name) {
+			continue
+		}
+		if rest := s[len(name):]; rest != "" && isParamChar(rest[0]) {
+			continue
+		}
+		return name
+	}
+	return ""
+}
+
+func isParamChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// spliceImport replaces imp in g with the fragment's stages and rebuilds g.
+func spliceImport(g *model.Graph, imp *model.Node, baseDir string, stack []string) error {
+	frag, err := loadFragment(imp, baseDir, stack)
+	if err != nil {
+		return err
+	}
+	startID := findStartNodeID(frag)
+	if startID == "" {
+		return fmt.Errorf("import %q: fragment has no start node", imp.Attrs["import"])
+	}
+	exits := map[string]bool{}
+	for id, n := range frag.Nodes {
+		if isTerminal(n) {
+			exits[id] = true
+		}
+	}
+	if len(exits) == 0 {
+		return fmt.Errorf("import %q: fragment has no exit node", imp.Attrs["import"])
+	}
+	entries := frag.Outgoing(startID)
+	if len(entries) == 0 {
+		return fmt.Errorf("import %q: fragment start node has no outgoing edges", imp.Attrs["import"])
+	}
+	for _, e := range entries {
+		if exits[e.To] {
+			return fmt.Errorf("import %q: fragment start node leads straight to its exit", imp.Attrs["import"])
+		}
+	}
+
+	prefix := imp.ID + "."
+	rename := func(id string) string { return prefix + id }
+
+	var inner []*model.Node
+	for id, n := range frag.Nodes {
+		if id == startID || exits[id] {
+			continue
+		}
+		if _, clash := g.Nodes[rename(id)]; clash {
+			return fmt.Errorf("imported node %q clashes with an existing node", rename(id))
+		}
+		inner = append(inner, n)
+	}
+	sort.Slice(inner, func(i, j int) bool { return inner[i].Order < inner[j].Order })
+
+	var inside, exitEdges []*model.Edge
+	for _, e := range frag.Edges {
+		switch {
+		case e.From == startID:
+		case exits[e.To]:
+			exitEdges = append(exitEdges, e)
+		case exits[e.From] || e.To == startID:
+			return fmt.Errorf("import %q: fragment edge %s -> %s leaves the exit or re-enters the start", imp.Attrs["import"], e.From, e.To)
+		default:
+			inside = append(inside, e)
+		}
+	}
+
+	out := model.NewGraph(g.Name)
+	out.Attrs = g.Attrs
+	out.AttrSpans = g.AttrSpans
+	out.Span = g.Span
+
+	var nodes []*model.Node
+	for _, n := range g.Nodes {
+		if n != nil && n != imp {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Order < nodes[j].Order })
+	order := 0
+	add := func(n *model.Node) {
+		n.Order = order
+		order++
+		_ = out.AddNode(n)
+	}
+	spliced := false
+	for _, n := range nodes {
+		if !spliced && n.Order > imp.Order {
+			for _, fn := range inner {
+				add(importedNode(fn, imp, rename, frag))
+			}
+			spliced = true
+		}
+		add(n)
+	}
+	if !spliced {
+		for _, fn := range inner {
+			add(importedNode(fn, imp, rename, frag))
+		}
+	}
+
+	// References to the import node now mean the fragment's first stage.
+	firstStage := rename(entries[0].To)
+	for _, n := range out.Nodes {
+		if !strings.HasPrefix(n.ID, prefix) {
+			repointRefs(n.Attrs, imp.ID, firstStage)
+		}
+	}
+	repointRefs(out.Attrs, imp.ID, firstStage)
+
+	addEdge := func(e *model.Edge) { _ = out.AddEdge(e) }
+	insideAdded := false
+	addInside := func() {
+		if insideAdded {
+			return
+		}
+		insideAdded = true
+		for _, e := range inside {
+			addEdge(copyEdge(e, rename(e.From), rename(e.To)))
+		}
+	}
+	for _, e := range g.Edges {
+		switch {
+		case e.From == imp.ID && e.To == imp.ID:
+			return fmt.Errorf("import node cannot have an edge to itself")
+		case e.To == imp.ID:
+			for _, entry := range entries {
+				addEdge(joinEdges(e, entry, e.From, rename(entry.To)))
+			}
+			addInside()
+		case e.From == imp.ID:
+			addInside()
+			for _, ex := range exitEdges {
+				addEdge(joinEdges(e, ex, rename(ex.From), e.To))
+			}
+		default:
+			addEdge(e)
+		}
+	}
+	addInside()
+
+	*g = *out
+	return nil
+}
+
+func importedNode(fn *model.Node, imp *model.Node, rename func(string) string, frag *model.Graph) *model.Node {
+	n := model.NewNode(rename(fn.ID))
+	for k, v := range fn.Attrs {
+		n.Attrs[k] = v
+	}
+	for k, v := range fn.AttrSpans {
+		n.AttrSpans[k] = v
+	}
+	n.Span = fn.Span
+	n.AttrList = fn.AttrList
+	n.LastSpan = fn.LastSpan
+	n.LastAttrList = fn.LastAttrList
+	n.Classes = append([]string{}, fn.Classes...)
+	for _, key := range importRefAttrs {
+		if ref := strings.TrimSpace(n.Attrs[key]); ref != "" {
+			if _, ok := frag.Nodes[ref]; ok {
+				n.Attrs[key] = rename(ref)
+			}
+		}
+	}
+	for _, c := range imp.ClassList() {
+		n.Classes = append(n.Classes, c)
+	}
+	return n
+}
+
+func repointRefs(attrs map[string]string, from, to string) {
+	for _, key := range importRefAttrs {
+		if strings.TrimSpace(attrs[key]) == from {
+			attrs[key] = to
+		}
+	}
+}
+
+func copyEdge(e *model.Edge, from, to string) *model.Edge {
+	c := model.NewEdge(from, to)
+	for k, v := range e.Attrs {
+		c.Attrs[k] = v
+	}
+	for k, v := range e.AttrSpans {
+		c.AttrSpans[k] = v
+	}
+	c.Span = e.Span
+	c.AttrList = e.AttrList
+	return c
+}
+
+// joinEdges combines an edge of the outer graph with the fragment edge it
+// is joined to: conditions are ANDed, other outer attrs win.
+func joinEdges(outer, frag *model.Edge, from, to string) *model.Edge {
+	j := copyEdge(frag, from, to)
+	for k, v := range outer.Attrs {
+		if k != "condition" {
+			j.Attrs[k] = v
+		}
+	}
+	for k, v := range outer.AttrSpans {
+		j.AttrSpans[k] = v
+	}
+	var conds []string
+	for _, c := range []string{frag.Attrs["condition"], outer.Attrs["condition"]} {
+		if c = strings.TrimSpace(c); c != "" {
+			conds = append(conds, c)
+		}
+	}
+	if len(conds) > 0 {
+		j.Attrs["condition"] = strings.Join(conds, " && ")
+	}
+	j.Span = outer.Span
+	j.AttrList = outer.AttrList
+	return j
+}
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reviewLoopFragment = `digraph review_loop {
+  graph [param.lang="python", param.focus=""]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl   [shape=box, prompt_file="prompts/impl.md", retry_target=impl]
+  test   [shape=parallelogram, tool_command="make test-$lang"]
+  review [shape=box, prompt="Review the $lang change. $focus"]
+  start -> impl -> test
+  test -> review [condition="outcome=success"]
+  test -> impl [condition="outcome=fail"]
+  review -> exit [condition="outcome=success"]
+  review -> impl [condition="outcome=fail"]
+}
+`
+
+func writeImportFixture(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	for path, content := range map[string]string{
+		"fragments/review_loop.dot": reviewLoopFragment,
+		"prompts/impl.md":           "Implement it in $lang for $goal.\n",
+	} {
+		full := filepath.Join(repo, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestPrepare_ImportsSpliceParameterisedFragments(t *testing.T) {
+	repo := writeImportFixture(t)
+	g, _, err := PrepareWithOptions([]byte(`digraph G {
+  graph [goal="ship", model_stylesheet=".cheap { llm_model: gpt-5.2-mini; } * { llm_provider: openai; llm_model: gpt-5.2; }"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  backend  [import="fragments/review_loop.dot", param.lang="go", param.focus="Check error handling."]
+  frontend [import="fragments/review_loop.dot", class="cheap"]
+  fix [shape=box, prompt="fix", retry_target=backend]
+  start -> backend
+  backend -> frontend [condition="context.skip_frontend!=true"]
+  backend -> exit [condition="context.skip_frontend=true"]
+  frontend -> exit
+  frontend -> fix [condition="outcome=fail"]
+  fix -> exit
+}
+`), PrepareOptions{RepoPath: repo})
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+
+	if _, ok := g.Nodes["backend"]; ok {
+		t.Fatalf("import node should be replaced")
+	}
+	be, fe := g.Nodes["backend.impl"], g.Nodes["frontend.impl"]
+	if be == nil || fe == nil || g.Nodes["backend.review"] == nil || g.Nodes["frontend.test"] == nil {
+		t.Fatalf("imported nodes missing: %v", g.AllNodeIDs())
+	}
+	if got := be.Attrs["prompt"]; got != "Implement it in go for ship.\n" {
+		t.Fatalf("backend prompt = %q", got)
+	}
+	if got := fe.Attrs["prompt"]; got != "Implement it in python for ship.\n" {
+		t.Fatalf("frontend prompt (default param) = %q", got)
+	}
+	if got := g.Nodes["backend.test"].Attrs["tool_command"]; got != "make test-go" {
+		t.Fatalf("tool_command = %q", got)
+	}
+	if got := g.Nodes["backend.review"].Attrs["prompt"]; got != "Review the go change. Check error handling." {
+		t.Fatalf("review prompt = %q", got)
+	}
+	if be.Attrs["retry_target"] != "backend.impl" || g.Nodes["fix"].Attrs["retry_target"] != "backend.impl" {
+		t.Fatalf("retry targets: %q %q", be.Attrs["retry_target"], g.Nodes["fix"].Attrs["retry_target"])
+	}
+	if be.Attrs["llm_model"] != "gpt-5.2" || fe.Attrs["llm_model"] != "gpt-5.2-mini" {
+		t.Fatalf("stylesheet on imported nodes: %q %q", be.Attrs["llm_model"], fe.Attrs["llm_model"])
+	}
+	if be.Span.File != filepath.Join(repo, "fragments", "review_loop.dot") {
+		t.Fatalf("imported node span = %+v", be.Span)
+	}
+
+	edges := map[string]string{}
+	for _, e := range g.Edges {
+		edges[e.From+" -> "+e.To] = e.Attrs["condition"]
+	}
+	want := map[string]string{
+		"start -> backend.impl":           "",
+		"backend.review -> frontend.impl": "outcome=success && context.skip_frontend!=true",
+		"backend.review -> exit":          "outcome=success && context.skip_frontend=true",
+		"backend.test -> backend.impl":    "outcome=fail",
+		"frontend.review -> exit":         "outcome=success",
+		"frontend.review -> fix":          "outcome=success && outcome=fail",
+	}
+	for edge, cond := range want {
+		got, ok := edges[edge]
+		if !ok || got != cond {
+			t.Fatalf("edge %s: got %q (present=%v) want %q\nall: %v", edge, got, ok, cond, edges)
+		}
+	}
+}
+
+func TestPrepare_ImportErrors(t *testing.T) {
+	repo := writeImportFixture(t)
+	_ = os.WriteFile(filepath.Join(repo, "fragments", "a.dot"), []byte(`digraph a {
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  b [import="fragments/b.dot"]
+  start -> b -> exit
+}`), 0o644)
+	_ = os.WriteFile(filepath.Join(repo, "fragments", "b.dot"), []byte(`digraph b {
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  a [import="fragments/a.dot"]
+  start -> a -> exit
+}`), 0o644)
+
+	cases := map[string]struct {
+		node string
+		want string
+	}{
+		"undeclared param": {`loop [import="fragments/review_loop.dot", param.langauge="go"]`, `parameter "langauge" is not declared`},
+		"missing file":     {`loop [import="fragments/nope.dot"]`, "nope.dot"},
+		"cycle":            {`loop [import="fragments/a.dot"]`, "import cycle"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			src := "digraph G {\n  start [shape=Mdiamond]\n  exit [shape=Msquare]\n  " + tc.node + "\n  start -> loop -> exit\n}\n"
+			_, _, err := PrepareWithOptions([]byte(src), PrepareOptions{RepoPath: repo})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+
+	// Without a repo path or filename there is nowhere to resolve from.
+	_, _, err := Prepare([]byte(`digraph G { start [shape=Mdiamond]; exit [shape=Msquare]; loop [import="x.dot"]; start -> loop -> exit }`))
+	if err == nil || !strings.Contains(err.Error(), "needs a repo path") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// resolveLanguageServers picks the language servers backing an agent_loop
+// stage's code intelligence tools. The language_servers attr (node, then
+// graph) lists run-config server names; "none" disables them. Without the
+// attr, every server in the run config is used.
+func resolveLanguageServers(execCtx *Execution, node *model.Node, stageEnv map[string]string) ([]agent.LanguageServerConfig, error) {
+	var declared map[string]LanguageServerConfig
+	var graph *model.Graph
+	if execCtx != nil && execCtx.Engine != nil {
+		graph = execCtx.Engine.Graph
+		if execCtx.Engine.RunConfig != nil {
+			declared = execCtx.Engine.RunConfig.LanguageServers
+		}
+	}
+	var names []string
+	if attr := resolveToolHook(node, graph, "language_servers"); attr != "" {
+		for _, f := range strings.FieldsFunc(attr, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+			if strings.EqualFold(f, "none") {
+				return nil, nil
+			}
+			names = append(names, f)
+		}
+	} else {
+		for name := range declared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	out := make([]agent.LanguageServerConfig, 0, len(names))
+	for _, name := range names {
+		srv, ok := declared[name]
+		if !ok {
+			return nil, fmt.Errorf("language_servers: unknown server %q (declare it under language_servers in the run config)", name)
+		}
+		cfg := agent.LanguageServerConfig{
+			Name:            name,
+			Command:         srv.Command[0],
+			Args:            srv.Command[1:],
+			Env:             map[string]string{},
+			Extensions:      srv.Extensions,
+			LanguageID:      srv.LanguageID,
+			RequestTimeout:  time.Duration(srv.TimeoutMS) * time.Millisecond,
+			DiagnosticsWait: time.Duration(srv.DiagnosticsWaitMS) * time.Millisecond,
+		}
+		for k, v := range stageEnv {
+			cfg.Env[k] = v
+		}
+		for k, v := range srv.Env {
+			cfg.Env[k] = v
+		}
+		out = append(out, cfg)
+	}
+	return out, nil
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+func TestLoadRunConfigFile_LanguageServers(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+version: 1
+repo:
+  path: /tmp/repo
+cxdb:
+  binary_addr: 127.0.0.1:9009
+  http_base_url: http://127.0.0.1:9010
+modeldb:
+  openrouter_model_info_path: /tmp/catalog.json
+`
+	load := func(name, y string) (*RunConfigFile, error) {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(base+y), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadRunConfigFile(p)
+	}
+
+	cfg, err := load("ok.yaml", "language_servers:\n  gopls:\n    command: [gopls, serve]\n    extensions: [go, .mod]\n")
+	if err != nil {
+		t.Fatalf("LoadRunConfigFile: %v", err)
+	}
+	if got := cfg.LanguageServers["gopls"].Extensions; len(got) != 2 || got[0] != ".go" || got[1] != ".mod" {
+		t.Fatalf("extensions: %v", got)
+	}
+	if _, err := load("nocmd.yaml", "language_servers:\n  x:\n    extensions: [.go]\n"); err == nil || !strings.Contains(err.Error(), "command is required") {
+		t.Fatalf("expected command error, got %v", err)
+	}
+	if _, err := load("noext.yaml", "language_servers:\n  x:\n    command: [x]\n"); err == nil || !strings.Contains(err.Error(), "extensions are required") {
+		t.Fatalf("expected extensions error, got %v", err)
+	}
+}
+
+func TestResolveLanguageServers_NodeSelection(t *testing.T) {
+	eng := &Engine{Graph: model.NewGraph("g"), RunConfig: &RunConfigFile{}}
+	eng.RunConfig.LanguageServers = map[string]LanguageServerConfig{
+		"pyright": {Command: []string{"pyright-langserver", "--stdio"}, Extensions: []string{".py"}},
+		"gopls":   {Command: []string{"gopls"}, Extensions: []string{".go"}, Env: map[string]string{"GOFLAGS": "-mod=mod"}, DiagnosticsWaitMS: 2000},
+	}
+	execCtx := &Execution{Engine: eng}
+
+	all, err := resolveLanguageServers(execCtx, model.NewNode("n"), map[string]string{"KILROY_NODE_ID": "n"})
+	if err != nil || len(all) != 2 || all[0].Name != "gopls" || all[1].Name != "pyright" {
+		t.Fatalf("default selection: %+v err=%v", all, err)
+	}
+	if all[0].Command != "gopls" || all[0].Env["GOFLAGS"] != "-mod=mod" || all[0].Env["KILROY_NODE_ID"] != "n" || all[0].DiagnosticsWait != 2*time.Second {
+		t.Fatalf("gopls config: %+v", all[0])
+	}
+	if all[1].Args[0] != "--stdio" {
+		t.Fatalf("pyright args: %+v", all[1])
+	}
+
+	eng.Graph.Attrs["language_servers"] = "pyright"
+	n := model.NewNode("n")
+	if sel, err := resolveLanguageServers(execCtx, n, nil); err != nil || len(sel) != 1 || sel[0].Name != "pyright" {
+		t.Fatalf("graph selection: %+v err=%v", sel, err)
+	}
+	n.Attrs["language_servers"] = "none"
+	if sel, err := resolveLanguageServers(execCtx, n, nil); err != nil || len(sel) != 0 {
+		t.Fatalf("none: %+v err=%v", sel, err)
+	}
+	n.Attrs["language_servers"] = "clangd"
+	if _, err := resolveLanguageServers(execCtx, n, nil); err == nil || !strings.Contains(err.Error(), "unknown server") {
+		t.Fatalf("expected unknown server error, got %v", err)
+	}
+}
@@ -245,6 +245,7 @@ func runChildPipeline(ctx context.Context, exec *Execution, childDotfile string,
 		ModelCatalogSHA:    exec.Engine.ModelCatalogSHA,
 		ModelCatalogSource: exec.Engine.ModelCatalogSource,
 		ModelCatalogPath:   exec.Engine.ModelCatalogPath,
+		memory:             exec.Engine.memory,
 	}
 
 	res, err := runSubgraphUntil(ctx, childEng, startID, exitID)
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/mcp"
+)
+
+const mcpConnectTimeout = 60 * time.Second
+
+func validMCPServerName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveMCPServers picks the MCP servers for an agent_loop stage. The
+// mcp_servers attr (node, then graph) lists run-config server names or
+// http(s) URLs of ad-hoc streamable-HTTP servers; "none" disables MCP. Without
+// the attr, every server in the run config is used.
+func resolveMCPServers(execCtx *Execution, node *model.Node, worktreeDir string, stageEnv map[string]string) ([]mcp.ServerConfig, error) {
+	var declared map[string]MCPServerConfig
+	var graph *model.Graph
+	if execCtx != nil && execCtx.Engine != nil {
+		graph = execCtx.Engine.Graph
+		if execCtx.Engine.RunConfig != nil {
+			declared = execCtx.Engine.RunConfig.MCP.Servers
+		}
+	}
+	var names []string
+	if attr := resolveToolHook(node, graph, "mcp_servers"); attr != "" {
+		for _, f := range strings.FieldsFunc(attr, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+			if strings.EqualFold(f, "none") {
+				return nil, nil
+			}
+			names = append(names, f)
+		}
+	} else {
+		for name := range declared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	taken := map[string]bool{}
+	for _, name := range names {
+		if adHocMCPURL(name) == nil {
+			taken[name] = true
+		}
+	}
+	out := make([]mcp.ServerConfig, 0, len(names))
+	for _, name := range names {
+		if u := adHocMCPURL(name); u != nil {
+			out = append(out, mcp.ServerConfig{Name: adHocMCPServerName(u, taken), URL: name})
+			continue
+		}
+		srv, ok := declared[name]
+		if !ok {
+			return nil, fmt.Errorf("mcp_servers: unknown server %q (declare it under mcp.servers in the run config)", name)
+		}
+		cfg := mcp.ServerConfig{
+			Name:           name,
+			URL:            srv.URL,
+			RequestTimeout: time.Duration(srv.TimeoutMS) * time.Millisecond,
+		}
+		if len(srv.Command) > 0 {
+			cfg.Command = srv.Command[0]
+			cfg.Args = srv.Command[1:]
+			cfg.Dir = worktreeDir
+			cfg.Env = map[string]string{}
+			for k, v := range stageEnv {
+				cfg.Env[k] = v
+			}
+			for k, v := range srv.Env {
+				cfg.Env[k] = v
+			}
+		}
+		if len(srv.Headers) > 0 || srv.BearerTokenEnv != "" {
+			cfg.Headers = map[string]string{}
+			for k, v := range srv.Headers {
+				cfg.Headers[k] = v
+			}
+			if srv.BearerTokenEnv != "" {
+				tok := strings.TrimSpace(os.Getenv(srv.BearerTokenEnv))
+				if tok == "" {
+					return nil, fmt.Errorf("mcp server %q: %s is not set", name, srv.BearerTokenEnv)
+				}
+				cfg.Headers["Authorization"] = "Bearer " + tok
+			}
+		}
+		out = append(out, cfg)
+	}
+	return out, nil
+}
+
+// adHocMCPURL returns the parsed URL when name is an http(s) server URL
+// rather than a run-config server name, else nil.
+func adHocMCPURL(name string) *url.URL {
+	u, err := url.Parse(name)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil
+	}
+	return u
+}
+
+// adHocMCPServerName names an ad-hoc server after the first label of its
+// host and its port ("localhost_8001"), adding a numeric suffix when the
+// name is already taken, and records the name in taken.
+func adHocMCPServerName(u *url.URL, taken map[string]bool) string {
+	base := strings.Split(u.Hostname(), ".")[0]
+	if port := u.Port(); port != "" {
+		base += "_" + port
+	}
+	name := base
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	taken[name] = true
+	return name
+}
+
+// connectMCPServers connects to each server and collects its tools for the
+// agent session. Server stderr goes to mcp_<name>.stderr.log and the tool
+// inventory to mcp_servers.json in the stage dir. The returned func closes
+// every connection.
+func connectMCPServers(ctx context.Context, cfgs []mcp.ServerConfig, stageDir string) ([]agent.RegisteredTool, func(), error) {
+	var clients []*mcp.Client
+	var logs []*os.File
+	closeAll := func() {
+		for _, c := range clients {
+			_ = c.Close()
+		}
+		for _, f := range logs {
+			_ = f.Close()
+		}
+	}
+	var tools []agent.RegisteredTool
+	inventory := map[string]any{}
+	for _, cfg := range cfgs {
+		if cfg.Command != "" && stageDir != "" {
+			if f, err := os.Create(filepath.Join(stageDir, "mcp_"+cfg.Name+".stderr.log")); err == nil {
+				logs = append(logs, f)
+				cfg.Stderr = f
+			}
+		}
+		cctx, cancel := context.WithTimeout(ctx, mcpConnectTimeout)
+		c, err := mcp.Connect(cctx, cfg)
+		if err == nil {
+			clients = append(clients, c)
+			var ts []agent.RegisteredTool
+			ts, err = agent.MCPTools(cctx, c)
+			tools = append(tools, ts...)
+			names := make([]string, 0, len(ts))
+			for _, t := range ts {
+				names = append(names, t.Definition.Name)
+			}
+			inventory[cfg.Name] = map[string]any{
+				"server_info": c.ServerInfo(),
+				"tools":       names,
+			}
+		}
+		cancel()
+		if err != nil {
+			closeAll()
+			return nil, func() {}, err
+		}
+	}
+	if stageDir != "" && len(cfgs) > 0 {
+		_ = writeJSON(filepath.Join(stageDir, "mcp_servers.json"), inventory)
+	}
+	return tools, closeAll, nil
+}
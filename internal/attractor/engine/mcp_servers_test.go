@@ -0,0 +1,218 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/mcp/mcptest"
+)
+
+func TestMCPStubServerHelper(t *testing.T) {
+	mcptest.ServeIfHelper()
+	t.Skip("helper process for MCP tests")
+}
+
+func stubMCPServerConfig() MCPServerConfig {
+	h := mcptest.HelperConfig("stub", "TestMCPStubServerHelper")
+	return MCPServerConfig{Command: append([]string{h.Command}, h.Args...), Env: h.Env}
+}
+
+func TestLoadRunConfigFile_MCPServersValidation(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+version: 1
+repo:
+  path: /tmp/repo
+cxdb:
+  binary_addr: 127.0.0.1:9009
+  http_base_url: http://127.0.0.1:9010
+modeldb:
+  openrouter_model_info_path: /tmp/catalog.json
+`
+	load := func(name, mcpYAML string) (*RunConfigFile, error) {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(base+mcpYAML), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadRunConfigFile(p)
+	}
+
+	cfg, err := load("ok.yaml", "mcp:\n  servers:\n    tickets:\n      command: [\" ticket-mcp \", --stdio, --prefix, \"\"]\n    search:\n      url: https://search.example/mcp\n      bearer_token_env: SEARCH_TOKEN\n")
+	if err != nil {
+		t.Fatalf("LoadRunConfigFile: %v", err)
+	}
+	if got := cfg.MCP.Servers["tickets"].Command; len(got) != 4 || got[0] != "ticket-mcp" || got[3] != "" {
+		t.Fatalf("command: %q", got)
+	}
+	if _, err := load("blank.yaml", "mcp:\n  servers:\n    x:\n      command: [\" \", --stdio]\n"); err == nil || !strings.Contains(err.Error(), "must start with an executable") {
+		t.Fatalf("expected blank executable error, got %v", err)
+	}
+	if _, err := load("both.yaml", "mcp:\n  servers:\n    x:\n      command: [a]\n      url: http://b\n"); err == nil || !strings.Contains(err.Error(), "exactly one of command or url") {
+		t.Fatalf("expected transport error, got %v", err)
+	}
+	if _, err := load("name.yaml", "mcp:\n  servers:\n    \"bad name\":\n      url: http://b\n"); err == nil || !strings.Contains(err.Error(), "invalid mcp.servers key") {
+		t.Fatalf("expected name error, got %v", err)
+	}
+}
+
+func TestResolveMCPServers_NodeSelection(t *testing.T) {
+	eng := &Engine{
+		Graph:     model.NewGraph("g"),
+		RunConfig: &RunConfigFile{},
+	}
+	eng.RunConfig.MCP.Servers = map[string]MCPServerConfig{
+		"b": {URL: "http://b.example/mcp"},
+		"a": {Command: []string{"a-mcp", "--stdio"}, Env: map[string]string{"A": "1"}},
+	}
+	execCtx := &Execution{Engine: eng}
+
+	all, err := resolveMCPServers(execCtx, model.NewNode("n"), "/wt", map[string]string{"KILROY_NODE_ID": "n"})
+	if err != nil || len(all) != 2 || all[0].Name != "a" || all[1].Name != "b" {
+		t.Fatalf("default selection: %+v err=%v", all, err)
+	}
+	if all[0].Command != "a-mcp" || all[0].Dir != "/wt" || all[0].Env["A"] != "1" || all[0].Env["KILROY_NODE_ID"] != "n" {
+		t.Fatalf("stdio config: %+v", all[0])
+	}
+
+	n := model.NewNode("n")
+	n.Attrs["mcp_servers"] = "b, https://adhoc.example.com/mcp"
+	sel, err := resolveMCPServers(execCtx, n, "/wt", nil)
+	if err != nil || len(sel) != 2 || sel[0].Name != "b" || sel[1].Name != "adhoc" || sel[1].URL != "https://adhoc.example.com/mcp" {
+		t.Fatalf("node selection: %+v err=%v", sel, err)
+	}
+
+	n.Attrs["mcp_servers"] = "none"
+	if sel, err := resolveMCPServers(execCtx, n, "/wt", nil); err != nil || len(sel) != 0 {
+		t.Fatalf("none: %+v err=%v", sel, err)
+	}
+	n.Attrs["mcp_servers"] = "missing"
+	if _, err := resolveMCPServers(execCtx, n, "/wt", nil); err == nil || !strings.Contains(err.Error(), "unknown server") {
+		t.Fatalf("expected unknown server error, got %v", err)
+	}
+}
+
+func TestResolveMCPServers_AdHocURLNamesAreUnique(t *testing.T) {
+	eng := &Engine{
+		Graph:     model.NewGraph("g"),
+		RunConfig: &RunConfigFile{},
+	}
+	eng.RunConfig.MCP.Servers = map[string]MCPServerConfig{
+		"localhost_8003": {URL: "http://127.0.0.1:9000/mcp"},
+	}
+	n := model.NewNode("n")
+	n.Attrs["mcp_servers"] = "http://localhost:8001/mcp, http://localhost:8002/mcp, http://localhost:8001/other, http://localhost:8003/mcp, localhost_8003"
+	sel, err := resolveMCPServers(&Execution{Engine: eng}, n, "/wt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range sel {
+		got = append(got, s.Name)
+	}
+	if want := "localhost_8001,localhost_8002,localhost_8001_2,localhost_8003_2,localhost_8003"; strings.Join(got, ",") != want {
+		t.Fatalf("names: got %v want %s", got, want)
+	}
+}
+
+func TestRunWithConfig_APIAgentLoop_CallsMCPToolThroughHooks(t *testing.T) {
+	repo := initTestRepo(t)
+	logsRoot := t.TempDir()
+	pinned := writePinnedCatalog(t)
+	cxdbSrv := newCXDBTestServer(t)
+
+	var mu sync.Mutex
+	var bodies []string
+	openaiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/responses" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{
+  "id": "resp_1",
+  "model": "gpt-5.2",
+  "output": [{"type":"function_call","id":"call_1","call_id":"call_1","name":"mcp__stub__echo","arguments":"{\"text\":\"hello\"}"}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{
+  "id": "resp_2",
+  "model": "gpt-5.2",
+  "output": [{"type":"message","content":[{"type":"output_text","text":"done"}]}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+	}))
+	t.Cleanup(openaiSrv.Close)
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_BASE_URL", openaiSrv.URL)
+
+	cfg := &RunConfigFile{Version: 1}
+	cfg.Repo.Path = repo
+	cfg.CXDB.BinaryAddr = cxdbSrv.BinaryAddr()
+	cfg.CXDB.HTTPBaseURL = cxdbSrv.URL()
+	cfg.LLM.Providers = map[string]ProviderConfig{
+		"openai": {Backend: BackendAPI, Failover: []string{}},
+	}
+	cfg.ModelDB.OpenRouterModelInfoPath = pinned
+	cfg.ModelDB.OpenRouterModelInfoUpdatePolicy = "pinned"
+	cfg.Git.RunBranchPrefix = "attractor/run"
+	cfg.MCP.Servers = map[string]MCPServerConfig{"stub": stubMCPServerConfig()}
+	disableProbe := false
+	cfg.Preflight.PromptProbes.Enabled = &disableProbe
+
+	dot := []byte(`
+digraph G {
+  graph [goal="use mcp", tool_hooks.pre="cat > /dev/null"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, auto_status=true, mcp_servers="stub", prompt="echo hello"]
+  start -> a -> exit
+}
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := RunWithConfig(ctx, dot, cfg, RunOptions{RunID: "api-mcp-test", LogsRoot: logsRoot})
+	if err != nil {
+		t.Fatalf("RunWithConfig: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) < 2 {
+		t.Fatalf("expected tool round trip, got %d requests", len(bodies))
+	}
+	if !strings.Contains(bodies[0], `"mcp__stub__echo"`) {
+		t.Fatalf("first request does not offer the MCP tool: %s", bodies[0])
+	}
+	if !strings.Contains(bodies[1], "echo: hello") {
+		t.Fatalf("tool output not returned to the model: %s", bodies[1])
+	}
+
+	stageDir := filepath.Join(res.LogsRoot, "a")
+	var inv map[string]map[string]any
+	b, err := os.ReadFile(filepath.Join(stageDir, "mcp_servers.json"))
+	if err != nil {
+		t.Fatalf("read mcp_servers.json: %v", err)
+	}
+	if err := json.Unmarshal(b, &inv); err != nil || inv["stub"] == nil {
+		t.Fatalf("inventory: %s err=%v", b, err)
+	}
+	assertExists(t, filepath.Join(stageDir, "tool_hook_pre_call_1.json"))
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/modeldb"
+	"github.com/danshapiro/kilroy/internal/providerspec"
+)
+
+func discoveredModelsSnapshotPath(logsRoot string) string {
+	return filepath.Join(logsRoot, "modeldb", "discovered_models.json")
+}
+
+// discoverProviderModels lists installed models for API providers whose spec
+// opts into discovery (local servers such as Ollama), merges them into the run
+// catalog, and snapshots them under logs_root/modeldb for resume. Discovery is
+// best-effort: an unreachable server yields a warning here and a hard failure
+// later from the prompt probe.
+func discoverProviderModels(ctx context.Context, runtimes map[string]ProviderRuntime, used map[string]bool, catalog *modeldb.Catalog, logsRoot string) []string {
+	if catalog == nil {
+		return nil
+	}
+	var warnings []string
+	var discovered []modeldb.DiscoveredModel
+	for _, key := range sortedKeys(runtimes) {
+		rt := runtimes[key]
+		if !used[key] || rt.Backend != BackendAPI || !rt.API.DiscoverModels {
+			continue
+		}
+		if rt.API.Protocol != providerspec.ProtocolOllamaChat {
+			continue
+		}
+		apiKey := strings.TrimSpace(os.Getenv(rt.API.DefaultAPIKeyEnv))
+		models, err := newOllamaAdapterFromRuntime(key, rt, apiKey).ListModels(ctx)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("model discovery for provider %s failed: %v", key, err))
+			continue
+		}
+		for _, m := range models {
+			discovered = append(discovered, modeldb.DiscoveredModel{
+				Provider:          key,
+				ID:                m.ID,
+				ContextWindow:     m.ContextWindow,
+				SupportsTools:     m.SupportsTools,
+				SupportsVision:    m.SupportsVision,
+				SupportsReasoning: m.SupportsReasoning,
+			})
+		}
+	}
+	if len(discovered) == 0 {
+		return warnings
+	}
+	modeldb.MergeDiscoveredModels(catalog, discovered)
+	if err := modeldb.WriteDiscoveredModelsSnapshot(discoveredModelsSnapshotPath(logsRoot), discovered); err != nil {
+		warnings = append(warnings, fmt.Sprintf("write discovered model snapshot: %v", err))
+	}
+	return warnings
+}
+
+// mergeDiscoveredModelsSnapshot restores models discovered at run start into a
+// resumed run's catalog.
+func mergeDiscoveredModelsSnapshot(catalog *modeldb.Catalog, logsRoot string) error {
+	models, err := modeldb.LoadDiscoveredModelsSnapshot(discoveredModelsSnapshotPath(logsRoot))
+	if err != nil {
+		return fmt.Errorf("resume: load discovered model snapshot: %w", err)
+	}
+	modeldb.MergeDiscoveredModels(catalog, models)
+	return nil
+}
@@ -397,6 +397,7 @@ func (h *ParallelHandler) runBranch(ctx context.Context, exec *Execution, parall
 		ModelCatalogSHA:    exec.Engine.ModelCatalogSHA,
 		ModelCatalogSource: exec.Engine.ModelCatalogSource,
 		ModelCatalogPath:   exec.Engine.ModelCatalogPath,
+		memory:             exec.Engine.memory,
 	}
 	if exec.Engine.CXDB != nil {
 		if fork, err := exec.Engine.CXDB.ForkFromHead(ctx); err == nil {
@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+	"github.com/danshapiro/kilroy/internal/attractor/tmpl"
+)
+
+// renderPromptTemplate evaluates a templated stage prompt against the run
+// state at the moment the stage starts (see package tmpl).
+func renderPromptTemplate(exec *Execution, node *model.Node, prompt string) (string, error) {
+	return tmpl.Render(prompt, promptTemplateVars(exec, node), exec.WorktreeDir)
+}
+
+// promptTemplated reports whether the node's prompt is a template: the node
+// or the graph sets prompt_template=true.
+func promptTemplated(exec *Execution, node *model.Node) bool {
+	graphValue := ""
+	if exec != nil && exec.Graph != nil {
+		graphValue = exec.Graph.Attrs[tmpl.Attr]
+	}
+	return tmpl.Enabled(node.Attr(tmpl.Attr, ""), graphValue)
+}
+
+func promptTemplateVars(exec *Execution, node *model.Node) tmpl.Vars {
+	v := tmpl.Vars{
+		Node:     map[string]string{"id": node.ID},
+		Graph:    map[string]string{},
+		Context:  map[string]string{},
+		Outcomes: map[string]map[string]string{},
+	}
+	for k, val := range node.Attrs {
+		v.Node[k] = val
+	}
+	if exec.Graph != nil {
+		for k, val := range exec.Graph.Attrs {
+			v.Graph[k] = val
+		}
+		v.Goal = exec.Graph.Attrs["goal"]
+	}
+	if exec.Context != nil {
+		for k, val := range exec.Context.SnapshotValues() {
+			v.Context[k] = templateString(val)
+		}
+		v.Outcome = v.Context["outcome"]
+		v.FailureReason = v.Context["failure_reason"]
+		v.FailureClass = v.Context["failure_class"]
+		v.PreferredLabel = v.Context["preferred_label"]
+		v.PreviousNode = v.Context["previous_node"]
+		v.BaseSHA = v.Context["base_sha"]
+		v.CompletedNodes = decodeCompletedNodes(exec.Context)
+		v.RetryCount, _ = strconv.Atoi(v.Context["internal.retry_count."+node.ID])
+		if g := v.Context["graph.goal"]; g != "" {
+			v.Goal = g
+		}
+	}
+	if e := exec.Engine; e != nil {
+		v.RunID = e.Options.RunID
+		v.LoopRestartCount = e.restartCount
+		if v.RetryCount > 0 {
+			v.RetryFailureReason = e.retryFailureReasons[node.ID]
+		}
+		for id, out := range e.nodeOutcomes {
+			v.Outcomes[id] = map[string]string{
+				"status":          string(out.Status),
+				"failure_reason":  out.FailureReason,
+				"preferred_label": out.PreferredLabel,
+				"notes":           out.Notes,
+			}
+		}
+	}
+	return v
+}
+
+// templateString formats a context value for templates: strings as-is,
+// everything else as JSON.
+func templateString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case fmt.Stringer:
+		return x.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// recordAttemptFailure remembers why a node's attempt failed, for the next
+// attempt's .retry_failure_reason.
+func (e *Engine) recordAttemptFailure(nodeID string, out runtime.Outcome) {
+	if e.retryFailureReasons == nil {
+		e.retryFailureReasons = map[string]string{}
+	}
+	e.retryFailureReasons[nodeID] = out.FailureReason
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+)
+
+func TestRun_PromptTemplate_RendersRunStateAtStageStart(t *testing.T) {
+	repo := t.TempDir()
+	runCmd(t, repo, "git", "init")
+	runCmd(t, repo, "git", "config", "user.name", "tester")
+	runCmd(t, repo, "git", "config", "user.email", "tester@example.com")
+	_ = os.WriteFile(filepath.Join(repo, "README.md"), []byte("hello\n"), 0o644)
+	_ = os.WriteFile(filepath.Join(repo, "SPEC.md"), []byte("the spec\n"), 0o644)
+	runCmd(t, repo, "git", "add", "-A")
+	runCmd(t, repo, "git", "commit", "-m", "init")
+
+	g, _, err := Prepare([]byte(`
+digraph G {
+  graph [goal="ship it", team="core"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  plan  [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="plan"]
+  impl  [shape=box, llm_provider=openai, llm_model=gpt-5.2, max_retries=1, fidelity=full, prompt_template=true,
+         prompt="Goal: {{.goal}} for {{.graph.team}} (node {{.node.id}}).\nplan={{.outcomes.plan.status}} stage={{ctx \"stage\"}}\n{{if gt .retry_count 0}}Previous attempt failed: {{.retry_failure_reason}}\n{{end}}{{include \"SPEC.md\"}}"]
+  start -> plan -> impl -> exit
+}
+`))
+	if err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+
+	logsRoot := t.TempDir()
+	opts := RunOptions{RepoPath: repo, RunID: "tmpl", LogsRoot: logsRoot}
+	if err := opts.applyDefaults(); err != nil {
+		t.Fatalf("applyDefaults: %v", err)
+	}
+	var prompts []string
+	backend := &countingBackend{fn: func(ctx context.Context, exec *Execution, node *model.Node, prompt string) (string, *runtime.Outcome, error) {
+		if node.ID == "plan" {
+			return "ok", &runtime.Outcome{Status: runtime.StatusSuccess, ContextUpdates: map[string]any{"stage": "planned"}}, nil
+		}
+		prompts = append(prompts, prompt)
+		if len(prompts) == 1 {
+			return "", &runtime.Outcome{Status: runtime.StatusRetry, FailureReason: "go test: FAIL TestParse (line 42)", Meta: map[string]any{"failure_class": failureClassTransientInfra}}, nil
+		}
+		return "ok", &runtime.Outcome{Status: runtime.StatusSuccess}, nil
+	}}
+	eng := &Engine{
+		Graph:           g,
+		Options:         opts,
+		DotSource:       []byte(""),
+		LogsRoot:        opts.LogsRoot,
+		WorktreeDir:     opts.WorktreeDir,
+		Context:         runtime.NewContext(),
+		Registry:        NewDefaultRegistry(),
+		Interviewer:     &AutoApproveInterviewer{},
+		CodergenBackend: backend,
+	}
+	eng.RunBranch = "attractor/run/" + opts.RunID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := eng.run(ctx); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if len(prompts) != 2 {
+		t.Fatalf("expected 2 impl attempts, got %d", len(prompts))
+	}
+	first := "Goal: ship it for core (node impl).\nplan=success stage=planned\nthe spec"
+	if !strings.Contains(prompts[0], first) || strings.Contains(prompts[0], "Previous attempt") {
+		t.Fatalf("first attempt prompt:\n%s", prompts[0])
+	}
+	if !strings.Contains(prompts[1], "Previous attempt failed: go test: FAIL TestParse (line 42)\nthe spec") {
+		t.Fatalf("retry prompt:\n%s", prompts[1])
+	}
+}
+
+func TestRun_PromptTemplate_IncludeOutsideWorktreeFailsStage(t *testing.T) {
+	exec := &Execution{WorktreeDir: t.TempDir(), Context: runtime.NewContext(), Graph: model.NewGraph("G")}
+	_, err := renderPromptTemplate(exec, model.NewNode("a"), `{{include "../secret"}}`)
+	if err == nil || !strings.Contains(err.Error(), "escapes the worktree") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestRetryFailureReasons_RoundTripThroughCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	cp := runtime.NewCheckpoint()
+	cp.Extra = map[string]any{"retry_failure_reasons": copyStringStringMap(map[string]string{"impl": "go test: FAIL TestParse"})}
+	if err := cp.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := runtime.LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint: %v", err)
+	}
+	eng := &Engine{retryFailureReasons: restoreRetryFailureReasons(loaded)}
+	ctx := runtime.NewContext()
+	ctx.Set("internal.retry_count.impl", 1)
+	v := promptTemplateVars(&Execution{Engine: eng, Context: ctx}, model.NewNode("impl"))
+	if v.RetryFailureReason != "go test: FAIL TestParse" {
+		t.Fatalf("retry_failure_reason after resume = %q", v.RetryFailureReason)
+	}
+}
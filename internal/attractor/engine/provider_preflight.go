@@ -180,6 +180,18 @@ func runProviderAPIPreflight(ctx context.Context, g *model.Graph, runtimes map[s
 			return fmt.Errorf("preflight: provider %s missing runtime definition", provider)
 		}
 		keyEnv := strings.TrimSpace(rt.API.DefaultAPIKeyEnv)
+		if rt.API.APIKeyOptional && (keyEnv == "" || strings.TrimSpace(os.Getenv(keyEnv)) == "") {
+			report.addCheck(providerPreflightCheck{
+				Name:     "provider_api_credentials",
+				Provider: provider,
+				Status:   preflightStatusPass,
+				Message:  "api key not required",
+				Details: map[string]any{
+					"base_url": resolveBuiltInBaseURLOverride(provider, rt.API.DefaultBaseURL),
+				},
+			})
+			continue
+		}
 		if keyEnv == "" {
 			report.addCheck(providerPreflightCheck{
 				Name:     "provider_api_credentials",
@@ -1279,13 +1291,24 @@ func usedAPIPromptProbeTargetsForProvider(g *model.Graph, runtimes map[string]Pr
 			continue
 		}
 
+		llmMode, err := llmModeForNode(n)
+		if err != nil {
+			return nil, err
+		}
 		mode := strings.ToLower(strings.TrimSpace(n.Attr("codergen_mode", "")))
 		if mode == "" {
 			mode = "agent_loop"
+			if llmMode == "batch" {
+				// Batch stages are one-shot; the probe stays synchronous.
+				mode = "one_shot"
+			}
 		}
 		if mode != "one_shot" && mode != "agent_loop" {
 			return nil, fmt.Errorf("invalid codergen_mode: %q (want one_shot|agent_loop)", mode)
 		}
+		if llmMode == "batch" && mode != "one_shot" {
+			return nil, fmt.Errorf("node %s: llm_mode=batch requires codergen_mode=one_shot (got %q)", n.ID, mode)
+		}
 		reasoning := strings.TrimSpace(n.Attr("reasoning_effort", ""))
 		req, err := preflightAPIPromptProbeRequest(provider, modelID, mode, reasoning, runtimes)
 		if err != nil {
@@ -112,7 +112,7 @@ func resumeFromLogsRoot(ctx context.Context, logsRoot string, ov ResumeOverrides
 	if err != nil {
 		return nil, err
 	}
-	g, _, err := Prepare(dotSource)
+	g, _, err := PrepareWithOptions(dotSource, PrepareOptions{RepoPath: strings.TrimSpace(m.RepoPath)})
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +151,9 @@ func resumeFromLogsRoot(ctx context.Context, logsRoot string, ov ResumeOverrides
 		if err != nil {
 			return nil, err
 		}
+		if err := mergeDiscoveredModelsSnapshot(cat, logsRoot); err != nil {
+			return nil, err
+		}
 		catalog = cat
 		backend, err = newResumeCodergenBackend(cfg, catalog)
 		if err != nil {
@@ -246,6 +249,9 @@ func resumeFromLogsRoot(ctx context.Context, logsRoot string, ov ResumeOverrides
 	eng.baseLogsRoot, eng.restartCount = restoreRestartState(logsRoot, cp)
 	eng.restartFailureSignatures = restoreRestartFailureSignatures(cp)
 	eng.loopFailureSignatures = restoreLoopFailureSignatures(cp)
+	eng.retryFailureReasons = restoreRetryFailureReasons(cp)
+	eng.pendingBatches = restorePendingBatches(cp)
+	eng.memory = restoreRunMemory(logsRoot, cp, eng)
 	eng.baseSHA = cp.GitCommitSHA
 	eng.lastCheckpointSHA = cp.GitCommitSHA
 	if cp != nil && cp.Extra != nil {
@@ -257,6 +263,10 @@ func resumeFromLogsRoot(ctx context.Context, logsRoot string, ov ResumeOverrides
 		}
 	}
 
+	var endTrace func(error)
+	ctx, endTrace = eng.startRunTracing(ctx, true)
+	defer func() { endTrace(err) }()
+
 	if !gitutil.IsRepo(m.RepoPath) {
 		return nil, fmt.Errorf("not a git repo: %s", m.RepoPath)
 	}
@@ -268,6 +278,15 @@ func resumeFromLogsRoot(ctx context.Context, logsRoot string, ov ResumeOverrides
 		return nil, fmt.Errorf("repo has uncommitted changes (resume requires clean repo)")
 	}
 
+	// Snapshot the interrupted worktree before it is reset, so an API
+	// agent_loop stage that was mid-flight can restore it and continue its
+	// session (see resumableAgentSession).
+	if gitutil.IsRepo(eng.WorktreeDir) {
+		if tree, err := gitutil.WorktreeTree(eng.WorktreeDir); err == nil {
+			eng.resumeWorktreeTree = tree
+		}
+	}
+
 	// Recreate branch pointer and worktree at the last checkpoint commit.
 	// The run branch may currently be checked out by the existing worktree at logs_root/worktree.
 	// Remove it first so we can safely force-move the branch pointer.
@@ -599,6 +618,26 @@ func restoreLoopFailureSignatures(cp *runtime.Checkpoint) map[string]int {
 	return out
 }
 
+// restoreRetryFailureReasons reads back the per-node reasons behind
+// .retry_failure_reason in prompt templates.
+func restoreRetryFailureReasons(cp *runtime.Checkpoint) map[string]string {
+	out := map[string]string{}
+	if cp == nil || cp.Extra == nil {
+		return out
+	}
+	switch m := cp.Extra["retry_failure_reasons"].(type) {
+	case map[string]string:
+		for k, v := range m {
+			out[k] = v
+		}
+	case map[string]any:
+		for k, v := range m {
+			out[k] = anyToStringValue(v)
+		}
+	}
+	return out
+}
+
 func anyToStringValue(v any) string {
 	if v == nil {
 		return ""
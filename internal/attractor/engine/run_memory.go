@@ -0,0 +1,435 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+const (
+	memoryStateFile       = "memory.json"
+	memoryExtraKey        = "memory"
+	memoryGlobalNamespace = "global"
+	defaultMemoryMaxBytes = 32_000
+)
+
+// memoryEntry is one note in the run memory. Namespace is the writing
+// stage's thread key, or "global" for notes meant for every stage.
+type memoryEntry struct {
+	Namespace string    `json:"namespace"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	NodeID    string    `json:"node_id,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (m memoryEntry) size() int { return len(m.Key) + len(m.Value) }
+
+// runMemory is the run-scoped scratchpad behind the memory_* agent tools.
+// It lives in <logs_root>/memory.json and is mirrored into the checkpoint, so
+// what one stage learns survives into later stages and across resume.
+// Parallel branches and manager children share their parent's store; owner
+// is the engine whose checkpoint mirrors it.
+type runMemory struct {
+	mu       sync.Mutex
+	logsRoot string
+	entries  map[string]memoryEntry // namespace + "\x00" + key
+
+	// persistMu orders persist calls; each writes the state current when it
+	// runs, so an older snapshot never lands after a newer one.
+	persistMu sync.Mutex
+	owner     *Engine
+}
+
+func newRunMemory(logsRoot string, owner *Engine) *runMemory {
+	return &runMemory{logsRoot: logsRoot, owner: owner, entries: map[string]memoryEntry{}}
+}
+
+func memoryEntryID(namespace, key string) string { return namespace + "\x00" + key }
+
+// put stores (or deletes, when value is empty) an entry, enforcing maxBytes
+// over the whole store. It returns the bytes in use afterwards.
+func (m *runMemory) put(ent memoryEntry, maxBytes int) (int, error) {
+	m.mu.Lock()
+	id := memoryEntryID(ent.Namespace, ent.Key)
+	used := 0
+	for k, e := range m.entries {
+		if k != id {
+			used += e.size()
+		}
+	}
+	if ent.Value == "" {
+		delete(m.entries, id)
+	} else {
+		if maxBytes > 0 && used+ent.size() > maxBytes {
+			m.mu.Unlock()
+			return used, fmt.Errorf("memory is full: storing %q needs %d bytes but only %d of %d remain; delete or shorten entries first", ent.Key, ent.size(), maxBytes-used, maxBytes)
+		}
+		m.entries[id] = ent
+		used += ent.size()
+	}
+	m.mu.Unlock()
+
+	m.persist()
+	return used, nil
+}
+
+// relocate moves the store to a new logs root (loop_restart); notes carry
+// over into the next iteration.
+func (m *runMemory) relocate(logsRoot string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.logsRoot = logsRoot
+	m.mu.Unlock()
+	m.persist()
+}
+
+func (m *runMemory) get(namespace, key string) (memoryEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ent, ok := m.entries[memoryEntryID(namespace, key)]
+	return ent, ok
+}
+
+// snapshot returns all entries, newest first.
+func (m *runMemory) snapshot() []memoryEntry {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshotLocked()
+}
+
+func (m *runMemory) snapshotLocked() []memoryEntry {
+	out := make([]memoryEntry, 0, len(m.entries))
+	for _, e := range m.entries {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].UpdatedAt.Equal(out[j].UpdatedAt) {
+			return out[i].UpdatedAt.After(out[j].UpdatedAt)
+		}
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func (m *runMemory) replace(entries []memoryEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = map[string]memoryEntry{}
+	for _, e := range entries {
+		if strings.TrimSpace(e.Namespace) == "" || strings.TrimSpace(e.Key) == "" || e.Value == "" {
+			continue
+		}
+		m.entries[memoryEntryID(e.Namespace, e.Key)] = e
+	}
+}
+
+// persist writes memory.json and rewrites the memory into the owner's
+// checkpoint immediately, so a stop between node checkpoints keeps it.
+func (m *runMemory) persist() {
+	m.persistMu.Lock()
+	defer m.persistMu.Unlock()
+	m.mu.Lock()
+	snapshot, logsRoot := m.snapshotLocked(), m.logsRoot
+	m.mu.Unlock()
+	if strings.TrimSpace(logsRoot) == "" {
+		return
+	}
+	if b, err := json.MarshalIndent(snapshot, "", "  "); err == nil {
+		_ = runtime.WriteFileAtomic(filepath.Join(logsRoot, memoryStateFile), b)
+	}
+	if m.owner == nil {
+		return
+	}
+	_ = m.owner.updateCheckpoint(func(cp *runtime.Checkpoint) {
+		if len(snapshot) == 0 {
+			delete(cp.Extra, memoryExtraKey)
+		} else {
+			cp.Extra[memoryExtraKey] = snapshot
+		}
+	})
+}
+
+// restoreRunMemory loads the memory recorded in cp, falling back to
+// <logs_root>/memory.json for checkpoints written before the first write.
+func restoreRunMemory(logsRoot string, cp *runtime.Checkpoint, owner *Engine) *runMemory {
+	m := newRunMemory(logsRoot, owner)
+	var raw any
+	if cp != nil && cp.Extra != nil {
+		raw = cp.Extra[memoryExtraKey]
+	}
+	var b []byte
+	if raw != nil {
+		b, _ = json.Marshal(raw)
+	} else {
+		b, _ = os.ReadFile(filepath.Join(logsRoot, memoryStateFile))
+	}
+	var entries []memoryEntry
+	if len(b) > 0 && json.Unmarshal(b, &entries) == nil {
+		m.replace(entries)
+	}
+	return m
+}
+
+// memoryNamespace is the stage's memory namespace: its graph thread key.
+func memoryNamespace(execCtx *Execution, node *model.Node) string {
+	var incoming *model.Edge
+	if execCtx != nil && execCtx.Engine != nil {
+		incoming = execCtx.Engine.incomingEdge
+	}
+	var g *model.Graph
+	if execCtx != nil {
+		g = execCtx.Graph
+	}
+	if ns := resolveThreadKey(g, incoming, node); ns != "" {
+		return ns
+	}
+	return memoryGlobalNamespace
+}
+
+func memoryMaxBytes(execCtx *Execution) int {
+	if execCtx != nil && execCtx.Graph != nil {
+		if v := parseInt(execCtx.Graph.Attrs["memory_max_bytes"], 0); v > 0 {
+			return v
+		}
+	}
+	return defaultMemoryMaxBytes
+}
+
+// memoryPromptBudget is how many bytes of memory are injected into a stage
+// prompt. Richer fidelity modes carry more; truncate carries none. The
+// memory_prompt_budget attr (node, then graph) overrides the default.
+func memoryPromptBudget(execCtx *Execution, node *model.Node, fidelity string) int {
+	var g *model.Graph
+	if execCtx != nil {
+		g = execCtx.Graph
+	}
+	if v := resolveToolHook(node, g, "memory_prompt_budget"); v != "" {
+		return parseInt(v, 0)
+	}
+	switch fidelity {
+	case "truncate":
+		return 0
+	case "summary:low":
+		return 4000
+	case "summary:medium":
+		return 6000
+	case "summary:high", "full":
+		return 8000
+	default:
+		return 2000
+	}
+}
+
+// buildMemoryPromptSection renders the stage's own-thread and global memory,
+// newest first, within the fidelity budget.
+func buildMemoryPromptSection(execCtx *Execution, node *model.Node, fidelity string) string {
+	if execCtx == nil || execCtx.Engine == nil || execCtx.Engine.memory == nil {
+		return ""
+	}
+	budget := memoryPromptBudget(execCtx, node, fidelity)
+	if budget <= 0 {
+		return ""
+	}
+	ns := memoryNamespace(execCtx, node)
+	var lines []string
+	used, omitted := 0, 0
+	for _, e := range execCtx.Engine.memory.snapshot() {
+		if e.Namespace != ns && e.Namespace != memoryGlobalNamespace {
+			continue
+		}
+		line := fmt.Sprintf("- [%s] %s: %s", e.Namespace, e.Key, strings.ReplaceAll(e.Value, "\n", "\n  "))
+		if used+len(line) > budget {
+			omitted++
+			continue
+		}
+		used += len(line)
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 && omitted == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("## Run memory\n")
+	fmt.Fprintf(&b, "Notes recorded by earlier stages (thread %q and global), newest first.\n", ns)
+	b.WriteString(strings.Join(lines, "\n"))
+	if omitted > 0 {
+		if len(lines) > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "- (%d more entries not shown; use memory_read or memory_search)", omitted)
+	}
+	return b.String()
+}
+
+// writeMemory stores a memory entry on behalf of node and mirrors it to CXDB.
+func (e *Engine) writeMemory(ctx context.Context, execCtx *Execution, node *model.Node, ent memoryEntry) (int, error) {
+	used, err := e.memory.put(ent, memoryMaxBytes(execCtx))
+	if err != nil {
+		return used, err
+	}
+	e.appendProgress(map[string]any{
+		"event":     "memory_written",
+		"node_id":   node.ID,
+		"namespace": ent.Namespace,
+		"key":       ent.Key,
+		"deleted":   ent.Value == "",
+	})
+	e.cxdbMemoryWritten(ctx, ent)
+	return used, nil
+}
+
+// memoryTools returns the memory_write/memory_read/memory_search tools for
+// an agent_loop stage.
+func memoryTools(execCtx *Execution, node *model.Node) []agent.RegisteredTool {
+	if execCtx == nil || execCtx.Engine == nil || execCtx.Engine.memory == nil {
+		return nil
+	}
+	eng := execCtx.Engine
+	ns := memoryNamespace(execCtx, node)
+	str := func(args map[string]any, key string) string {
+		s, _ := args[key].(string)
+		return s
+	}
+	format := func(e memoryEntry) string {
+		return fmt.Sprintf("[%s] %s = %s", e.Namespace, e.Key, e.Value)
+	}
+
+	write := agent.RegisteredTool{
+		Definition: llm.ToolDefinition{
+			Name:        "memory_write",
+			Description: fmt.Sprintf("Record a note in the run memory shared with later stages (build quirks, file locations, decisions). scope=thread (default) files it under this stage's thread %q; scope=global makes it visible to every stage. An empty value deletes the key.", ns),
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"key":   map[string]any{"type": "string"},
+					"value": map[string]any{"type": "string"},
+					"scope": map[string]any{"type": "string", "enum": []string{"thread", "global"}},
+				},
+				"required":             []string{"key", "value"},
+				"additionalProperties": false,
+			},
+		},
+		Exec: func(ctx context.Context, _ agent.ExecutionEnvironment, args map[string]any) (any, error) {
+			key := strings.TrimSpace(str(args, "key"))
+			if key == "" {
+				return nil, fmt.Errorf("key is required")
+			}
+			target := ns
+			if str(args, "scope") == "global" {
+				target = memoryGlobalNamespace
+			}
+			value := str(args, "value")
+			if strings.TrimSpace(value) == "" {
+				value = ""
+			}
+			used, err := eng.writeMemory(ctx, execCtx, node, memoryEntry{Namespace: target, Key: key, Value: value, NodeID: node.ID, UpdatedAt: time.Now().UTC()})
+			if err != nil {
+				return nil, err
+			}
+			if value == "" {
+				return fmt.Sprintf("deleted %q from %s (%d/%d bytes used)", key, target, used, memoryMaxBytes(execCtx)), nil
+			}
+			return fmt.Sprintf("stored %q in %s (%d/%d bytes used)", key, target, used, memoryMaxBytes(execCtx)), nil
+		},
+	}
+
+	read := agent.RegisteredTool{
+		Definition: llm.ToolDefinition{
+			Name:        "memory_read",
+			Description: "Read the run memory. With key, returns that entry from this stage's thread (falling back to global). Without key, lists every entry in the namespace (default: this stage's thread plus global).",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"key":       map[string]any{"type": "string"},
+					"namespace": map[string]any{"type": "string"},
+				},
+				"additionalProperties": false,
+			},
+		},
+		Exec: func(_ context.Context, _ agent.ExecutionEnvironment, args map[string]any) (any, error) {
+			key := strings.TrimSpace(str(args, "key"))
+			want := strings.TrimSpace(str(args, "namespace"))
+			namespaces := []string{ns, memoryGlobalNamespace}
+			if want != "" {
+				namespaces = []string{want}
+			}
+			if key != "" {
+				for _, n := range namespaces {
+					if ent, ok := eng.memory.get(n, key); ok {
+						return format(ent), nil
+					}
+				}
+				return nil, fmt.Errorf("no memory entry %q in %s", key, strings.Join(namespaces, ", "))
+			}
+			var lines []string
+			for _, e := range eng.memory.snapshot() {
+				for _, n := range namespaces {
+					if e.Namespace == n {
+						lines = append(lines, format(e))
+					}
+				}
+			}
+			if len(lines) == 0 {
+				return fmt.Sprintf("no memory entries in %s", strings.Join(namespaces, ", ")), nil
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+
+	search := agent.RegisteredTool{
+		Definition: llm.ToolDefinition{
+			Name:        "memory_search",
+			Description: "Search the run memory across all threads (or one namespace) for a case-insensitive substring of a key or value.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"query":     map[string]any{"type": "string"},
+					"namespace": map[string]any{"type": "string"},
+				},
+				"required":             []string{"query"},
+				"additionalProperties": false,
+			},
+		},
+		Exec: func(_ context.Context, _ agent.ExecutionEnvironment, args map[string]any) (any, error) {
+			query := strings.ToLower(strings.TrimSpace(str(args, "query")))
+			if query == "" {
+				return nil, fmt.Errorf("query is required")
+			}
+			want := strings.TrimSpace(str(args, "namespace"))
+			var lines []string
+			for _, e := range eng.memory.snapshot() {
+				if want != "" && e.Namespace != want {
+					continue
+				}
+				if strings.Contains(strings.ToLower(e.Key), query) || strings.Contains(strings.ToLower(e.Value), query) {
+					lines = append(lines, format(e))
+				}
+			}
+			if len(lines) == 0 {
+				return fmt.Sprintf("no memory entries match %q", query), nil
+			}
+			return strings.Join(lines, "\n"), nil
+		},
+	}
+	return []agent.RegisteredTool{write, read, search}
+}
@@ -0,0 +1,236 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+)
+
+func TestMemoryTools_WriteReadSearchAndBudget(t *testing.T) {
+	logsRoot := t.TempDir()
+	if err := runtime.NewCheckpoint().Save(filepath.Join(logsRoot, "checkpoint.json")); err != nil {
+		t.Fatal(err)
+	}
+	g := model.NewGraph("g")
+	g.Attrs["memory_max_bytes"] = "60"
+	eng := &Engine{Graph: g, LogsRoot: logsRoot}
+	eng.memory = newRunMemory(logsRoot, eng)
+	execCtx := &Execution{Engine: eng, Graph: g}
+	node := model.NewNode("impl")
+	node.Attrs["thread_id"] = "build"
+
+	tools := map[string]agent.RegisteredTool{}
+	for _, rt := range memoryTools(execCtx, node) {
+		tools[rt.Definition.Name] = rt
+	}
+	call := func(name string, args map[string]any) (string, error) {
+		t.Helper()
+		v, err := tools[name].Exec(context.Background(), nil, args)
+		s, _ := v.(string)
+		return s, err
+	}
+
+	if out, err := call("memory_write", map[string]any{"key": "test_cmd", "value": "go test ./..."}); err != nil || !strings.Contains(out, `stored "test_cmd" in build`) {
+		t.Fatalf("thread write: %q err=%v", out, err)
+	}
+	if _, err := call("memory_write", map[string]any{"key": "repo_layout", "value": "cmd/ and internal/", "scope": "global"}); err != nil {
+		t.Fatalf("global write: %v", err)
+	}
+	if _, err := call("memory_write", map[string]any{"key": "huge", "value": strings.Repeat("x", 40)}); err == nil || !strings.Contains(err.Error(), "memory is full") {
+		t.Fatalf("expected budget error, got %v", err)
+	}
+
+	if out, err := call("memory_read", map[string]any{"key": "repo_layout"}); err != nil || out != "[global] repo_layout = cmd/ and internal/" {
+		t.Fatalf("read falls back to global: %q err=%v", out, err)
+	}
+	if out, err := call("memory_read", map[string]any{}); err != nil || !strings.Contains(out, "test_cmd") || !strings.Contains(out, "repo_layout") {
+		t.Fatalf("list: %q err=%v", out, err)
+	}
+	if _, err := call("memory_read", map[string]any{"key": "test_cmd", "namespace": "other"}); err == nil {
+		t.Fatalf("expected miss in another namespace")
+	}
+	if out, err := call("memory_search", map[string]any{"query": "GO TEST"}); err != nil || out != "[build] test_cmd = go test ./..." {
+		t.Fatalf("search: %q err=%v", out, err)
+	}
+
+	cp, err := runtime.LoadCheckpoint(filepath.Join(logsRoot, "checkpoint.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := restoreRunMemory(logsRoot, cp, nil)
+	if ent, ok := restored.get("build", "test_cmd"); !ok || ent.Value != "go test ./..." || ent.NodeID != "impl" {
+		t.Fatalf("checkpoint restore: %+v ok=%v", ent, ok)
+	}
+	if _, err := os.Stat(filepath.Join(logsRoot, memoryStateFile)); err != nil {
+		t.Fatalf("memory.json: %v", err)
+	}
+
+	if out, err := call("memory_write", map[string]any{"key": "test_cmd", "value": ""}); err != nil || !strings.Contains(out, "deleted") {
+		t.Fatalf("delete: %q err=%v", out, err)
+	}
+	if _, ok := eng.memory.get("build", "test_cmd"); ok {
+		t.Fatalf("entry not deleted")
+	}
+}
+
+func TestRunMemory_ConcurrentWritesPersistLatestState(t *testing.T) {
+	logsRoot := t.TempDir()
+	cpPath := filepath.Join(logsRoot, "checkpoint.json")
+	if err := runtime.NewCheckpoint().Save(cpPath); err != nil {
+		t.Fatal(err)
+	}
+	eng := &Engine{LogsRoot: logsRoot}
+	eng.memory = newRunMemory(logsRoot, eng)
+	const writers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ent := memoryEntry{Namespace: "main", Key: fmt.Sprintf("k%d", i), Value: "v", UpdatedAt: time.Now().UTC()}
+			if _, err := eng.memory.put(ent, 0); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cp, err := runtime.LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(restoreRunMemory(logsRoot, cp, nil).snapshot()); got != writers {
+		t.Fatalf("checkpoint holds %d of %d entries", got, writers)
+	}
+	if got := len(restoreRunMemory(logsRoot, nil, nil).snapshot()); got != writers {
+		t.Fatalf("memory.json holds %d of %d entries", got, writers)
+	}
+}
+
+func TestUpdateCheckpoint_SkipsMissingButReportsUnreadableCheckpoint(t *testing.T) {
+	logsRoot := t.TempDir()
+	eng := &Engine{LogsRoot: logsRoot}
+	noop := func(cp *runtime.Checkpoint) {}
+	if err := eng.updateCheckpoint(noop); err != nil {
+		t.Fatalf("missing checkpoint: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(logsRoot, "checkpoint.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.updateCheckpoint(noop); err == nil {
+		t.Fatal("expected an error for a corrupt checkpoint")
+	}
+}
+
+func TestBuildMemoryPromptSection_BudgetFollowsFidelity(t *testing.T) {
+	g := model.NewGraph("g")
+	eng := &Engine{Graph: g, memory: newRunMemory("", nil)}
+	execCtx := &Execution{Engine: eng, Graph: g}
+	node := model.NewNode("review")
+	node.Attrs["thread_id"] = "build"
+	base := time.Now().UTC()
+	for i, e := range []memoryEntry{
+		{Namespace: "build", Key: "old", Value: strings.Repeat("o", 1500)},
+		{Namespace: "global", Key: "layout", Value: "cmd/ and internal/"},
+		{Namespace: "docs", Key: "elsewhere", Value: "not for this thread"},
+		{Namespace: "build", Key: "flaky", Value: "TestFoo is flaky"},
+	} {
+		e.UpdatedAt = base.Add(time.Duration(i) * time.Second)
+		if _, err := eng.memory.put(e, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := buildMemoryPromptSection(execCtx, node, "truncate"); got != "" {
+		t.Fatalf("truncate should carry no memory:\n%s", got)
+	}
+	high := buildMemoryPromptSection(execCtx, node, "summary:high")
+	if !strings.Contains(high, "## Run memory") || strings.Contains(high, "elsewhere") || !strings.Contains(high, "- [build] old:") {
+		t.Fatalf("summary:high:\n%s", high)
+	}
+	if strings.Index(high, "flaky") > strings.Index(high, "layout") {
+		t.Fatalf("expected newest first:\n%s", high)
+	}
+	node.Attrs["memory_prompt_budget"] = "100"
+	small := buildMemoryPromptSection(execCtx, node, "summary:high")
+	if strings.Contains(small, "- [build] old:") || !strings.Contains(small, "flaky") || !strings.Contains(small, "1 more entries not shown") {
+		t.Fatalf("budget override:\n%s", small)
+	}
+}
+
+func TestResume_RestoresRunMemoryIntoLaterPrompts(t *testing.T) {
+	repo := t.TempDir()
+	runCmd(t, repo, "git", "init")
+	runCmd(t, repo, "git", "config", "user.name", "tester")
+	runCmd(t, repo, "git", "config", "user.email", "tester@example.com")
+	_ = os.WriteFile(filepath.Join(repo, "README.md"), []byte("hello\n"), 0o644)
+	runCmd(t, repo, "git", "add", "-A")
+	runCmd(t, repo, "git", "commit", "-m", "init")
+
+	dot := []byte(`
+digraph G {
+  graph [goal="test", thread_id="main"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="a"]
+  b [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="b"]
+  start -> a -> b -> exit
+}
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	res, err := Run(ctx, dot, RunOptions{RepoPath: repo, RunID: "mem", LogsRoot: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	shaA := ""
+	for _, line := range strings.Split(strings.TrimSpace(runCmdOut(t, repo, "git", "log", "--format=%H:%s", res.RunBranch)), "\n") {
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && strings.Contains(parts[1], "): a (") {
+			shaA = parts[0]
+		}
+	}
+	if shaA == "" {
+		t.Fatalf("no checkpoint commit for a")
+	}
+
+	// Simulate a stop after a, which recorded a note before the checkpoint.
+	cpPath := filepath.Join(res.LogsRoot, "checkpoint.json")
+	cp, err := runtime.LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.CurrentNode = "a"
+	cp.CompletedNodes = []string{"start", "a"}
+	cp.GitCommitSHA = shaA
+	cp.Extra[memoryExtraKey] = []memoryEntry{{Namespace: "main", Key: "build_quirk", Value: "run make gen first", NodeID: "a", UpdatedAt: time.Now().UTC()}}
+	if err := cp.Save(cpPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resume(ctx, res.LogsRoot); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+
+	bPrompt, err := os.ReadFile(filepath.Join(res.LogsRoot, "b", "prompt.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bPrompt), "- [main] build_quirk: run make gen first") {
+		t.Fatalf("b prompt missing restored memory:\n%s", bPrompt)
+	}
+	cp, err = runtime.LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cp.Extra[memoryExtraKey]; !ok {
+		t.Fatalf("final checkpoint dropped memory: %+v", cp.Extra)
+	}
+}
@@ -178,6 +178,7 @@ func RunWithConfig(ctx context.Context, dotSource []byte, cfg *RunConfigFile, ov
 	if err != nil {
 		return nil, err
 	}
+	discoveryWarnings := discoverProviderModels(ctx, runtimes, usedProviders, catalog, opts.LogsRoot)
 	catalogChecks, catalogErr := validateProviderModelPairs(g, runtimes, catalog, opts)
 	if catalogErr != nil {
 		report := &providerPreflightReport{
@@ -243,6 +244,10 @@ func RunWithConfig(ctx context.Context, dotSource []byte, cfg *RunConfigFile, ov
 		eng.Warn(resolved.Warning)
 		eng.Context.AppendLog(resolved.Warning)
 	}
+	for _, w := range discoveryWarnings {
+		eng.Warn(w)
+		eng.Context.AppendLog(w)
+	}
 	if startup != nil {
 		for _, w := range startup.Warnings {
 			eng.Warn(w)
@@ -88,8 +88,9 @@ func runToolHook(ctx context.Context, hookCmd string, worktreeDir string, env []
 	return exitCode, nil
 }
 
-// buildToolHookStdinJSON creates the JSON payload for tool hook stdin.
-func buildToolHookStdinJSON(toolName, callID, argsJSON, resultOutput string, isError bool, hookType string) string {
+// buildToolHookStdinJSON creates the JSON payload for tool hook stdin. Post
+// hooks also get the file changes the call made, with exact diffs.
+func buildToolHookStdinJSON(toolName, callID, argsJSON, resultOutput string, isError bool, hookType string, fileChanges []agent.FileChange) string {
 	data := map[string]any{
 		"hook_type": hookType,
 		"tool_name": toolName,
@@ -101,6 +102,9 @@ func buildToolHookStdinJSON(toolName, callID, argsJSON, resultOutput string, isE
 	if hookType == "post" {
 		data["output"] = truncate(resultOutput, 8000)
 		data["is_error"] = isError
+		if len(fileChanges) > 0 {
+			data["file_changes"] = fileChanges
+		}
 	}
 	b, err := json.Marshal(data)
 	if err != nil {
@@ -124,7 +128,7 @@ func runPreToolHook(ctx context.Context, execCtx *Execution, node *model.Node, s
 	if toolName == "" || callID == "" {
 		return ""
 	}
-	stdinJSON := buildToolHookStdinJSON(toolName, callID, argsJSON, "", false, "pre")
+	stdinJSON := buildToolHookStdinJSON(toolName, callID, argsJSON, "", false, "pre", nil)
 	env := toolHookEnv(buildBaseNodeEnv(artifactPolicyFromExecution(execCtx)), node.ID, toolName, callID)
 	exitCode, err := runToolHook(ctx, hookCmd, execCtx.WorktreeDir, env, stdinJSON, stageDir, "pre", callID)
 	if exitCode != 0 {
@@ -164,7 +168,8 @@ func executeToolHookForEvent(ctx context.Context, execCtx *Execution, node *mode
 		}
 		isErr, _ := ev.Data["is_error"].(bool)
 		fullOutput := fmt.Sprint(ev.Data["full_output"])
-		stdinJSON := buildToolHookStdinJSON(toolName, callID, "", fullOutput, isErr, "post")
+		changes, _ := ev.Data["file_changes"].([]agent.FileChange)
+		stdinJSON := buildToolHookStdinJSON(toolName, callID, "", fullOutput, isErr, "post", changes)
 		env := toolHookEnv(buildBaseNodeEnv(artifactPolicyFromExecution(execCtx)), node.ID, toolName, callID)
 		exitCode, err := runToolHook(ctx, hookCmd, execCtx.WorktreeDir, env, stdinJSON, stageDir, "post", callID)
 		if err != nil {
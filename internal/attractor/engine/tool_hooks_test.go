@@ -2,10 +2,12 @@ package engine
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/danshapiro/kilroy/internal/agent"
 	"github.com/danshapiro/kilroy/internal/attractor/model"
 )
 
@@ -117,7 +119,7 @@ func TestRunToolHook_EmptyCommand_Noop(t *testing.T) {
 }
 
 func TestBuildToolHookStdinJSON_PreHook(t *testing.T) {
-	got := buildToolHookStdinJSON("bash", "call-1", `{"cmd":"ls"}`, "", false, "pre")
+	got := buildToolHookStdinJSON("bash", "call-1", `{"cmd":"ls"}`, "", false, "pre", nil)
 	if got == "" || got == "{}" {
 		t.Fatalf("expected non-empty JSON, got %q", got)
 	}
@@ -131,7 +133,7 @@ func TestBuildToolHookStdinJSON_PreHook(t *testing.T) {
 }
 
 func TestBuildToolHookStdinJSON_PostHook(t *testing.T) {
-	got := buildToolHookStdinJSON("bash", "call-1", "", "some output", true, "post")
+	got := buildToolHookStdinJSON("bash", "call-1", "", "some output", true, "post", nil)
 	if got == "" || got == "{}" {
 		t.Fatalf("expected non-empty JSON, got %q", got)
 	}
@@ -144,6 +146,20 @@ func TestBuildToolHookStdinJSON_PostHook(t *testing.T) {
 	}
 }
 
+func TestBuildToolHookStdinJSON_PostHookIncludesFileChanges(t *testing.T) {
+	changes := []agent.FileChange{{Seq: 1, Tool: "edit_file", CallID: "call-1", Path: "a.go", Op: "modify", Diff: "--- a/a.go\n+++ b/a.go\n"}}
+	got := buildToolHookStdinJSON("edit_file", "call-1", "", "edited", false, "post", changes)
+	var payload struct {
+		FileChanges []agent.FileChange `json:"file_changes"`
+	}
+	if err := json.Unmarshal([]byte(got), &payload); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(payload.FileChanges) != 1 || payload.FileChanges[0].Path != "a.go" || payload.FileChanges[0].Diff != changes[0].Diff {
+		t.Fatalf("file_changes: %s", got)
+	}
+}
+
 func TestToolHookEnv_AddsKilroyVars(t *testing.T) {
 	env := toolHookEnv([]string{"PATH=/usr/bin"}, "node-1", "bash", "call-1")
 	found := map[string]bool{}
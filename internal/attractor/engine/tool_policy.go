@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danshapiro/kilroy/internal/agent"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+const toolPolicyAttrPrefix = "tool_policy."
+
+func (c *ToolPolicyConfig) normalize() {
+	c.Default = strings.ToLower(strings.TrimSpace(c.Default))
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		r.Name = strings.TrimSpace(r.Name)
+		r.Effect = strings.ToLower(strings.TrimSpace(r.Effect))
+		r.Access = strings.ToLower(strings.TrimSpace(r.Access))
+		r.Tools = trimNonEmpty(r.Tools)
+		r.Commands = trimNonEmpty(r.Commands)
+		r.Paths = trimNonEmpty(r.Paths)
+	}
+}
+
+// build returns a fresh agent policy (with its own write budget), or nil.
+func (c *ToolPolicyConfig) build() *agent.ToolPolicy {
+	if c == nil {
+		return nil
+	}
+	p := &agent.ToolPolicy{Default: c.Default, MaxWrites: c.MaxWrites}
+	for _, r := range c.Rules {
+		p.Rules = append(p.Rules, agent.ToolPolicyRule{
+			Name:     r.Name,
+			Effect:   r.Effect,
+			Tools:    r.Tools,
+			Commands: r.Commands,
+			Paths:    r.Paths,
+			Access:   r.Access,
+			Reason:   r.Reason,
+		})
+	}
+	return p
+}
+
+// applyToolPolicyAttrs layers tool_policy.<field> attributes onto base:
+// deny_tools, deny_commands and deny_paths (comma-separated) append deny
+// rules, and max_writes replaces the write budget.
+func applyToolPolicyAttrs(base *ToolPolicyConfig, attrs map[string]string, scope string) (*ToolPolicyConfig, error) {
+	out := base
+	cloned := false
+	edit := func() *ToolPolicyConfig {
+		if !cloned {
+			c := ToolPolicyConfig{}
+			if out != nil {
+				c = *out
+				c.Rules = append([]ToolPolicyRuleConfig(nil), out.Rules...)
+			}
+			out, cloned = &c, true
+		}
+		return out
+	}
+	for _, field := range []string{"deny_tools", "deny_commands", "deny_paths", "max_writes"} {
+		val, ok := attrs[toolPolicyAttrPrefix+field]
+		if !ok {
+			continue
+		}
+		rule := ToolPolicyRuleConfig{Name: scope + "." + toolPolicyAttrPrefix + field, Effect: "deny"}
+		items := trimNonEmpty(strings.Split(val, ","))
+		switch field {
+		case "deny_tools":
+			rule.Tools = items
+		case "deny_commands":
+			rule.Commands = items
+		case "deny_paths":
+			rule.Paths, rule.Access = items, "write"
+		case "max_writes":
+			n, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s%s: want a non-negative integer, got %q", toolPolicyAttrPrefix, field, val)
+			}
+			edit().MaxWrites = n
+			continue
+		}
+		if len(items) > 0 {
+			edit().Rules = append(edit().Rules, rule)
+		}
+	}
+	for key := range attrs {
+		if strings.HasPrefix(key, toolPolicyAttrPrefix) {
+			switch strings.TrimPrefix(key, toolPolicyAttrPrefix) {
+			case "deny_tools", "deny_commands", "deny_paths", "max_writes":
+			default:
+				return nil, fmt.Errorf("%s: unknown field", key)
+			}
+		}
+	}
+	return out, nil
+}
+
+// resolveToolPolicy picks the policy for an agent_loop stage: run config
+// tool_policy, replaced by the named tool_policies entry (or "none") that the
+// node, else the graph, selects with tool_policy=<name>, then extended by
+// graph and node tool_policy.* attributes.
+func resolveToolPolicy(execCtx *Execution, node *model.Node) (*ToolPolicyConfig, error) {
+	var cfg *RunConfigFile
+	var graph *model.Graph
+	if execCtx != nil && execCtx.Engine != nil {
+		cfg, graph = execCtx.Engine.RunConfig, execCtx.Engine.Graph
+	}
+	var policy *ToolPolicyConfig
+	if cfg != nil {
+		policy = cfg.ToolPolicy
+	}
+	name := ""
+	if node != nil {
+		name = strings.TrimSpace(node.Attr("tool_policy", ""))
+	}
+	if name == "" && graph != nil {
+		name = strings.TrimSpace(graph.Attrs["tool_policy"])
+	}
+	switch name {
+	case "":
+	case "none":
+		policy = nil
+	default:
+		var named ToolPolicyConfig
+		ok := false
+		if cfg != nil {
+			named, ok = cfg.ToolPolicies[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("tool_policy %q is not declared in run config tool_policies", name)
+		}
+		policy = &named
+	}
+	var err error
+	if graph != nil {
+		if policy, err = applyToolPolicyAttrs(policy, graph.Attrs, "graph"); err != nil {
+			return nil, err
+		}
+	}
+	if node != nil {
+		if policy, err = applyToolPolicyAttrs(policy, node.Attrs, "node"); err != nil {
+			return nil, err
+		}
+	}
+	if err := policy.build().Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// toolPolicyDenialLog appends EventToolCallDenied events to
+// tool_policy_denials.ndjson in the stage dir.
+type toolPolicyDenialLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newToolPolicyDenialLog(stageDir string) *toolPolicyDenialLog {
+	return &toolPolicyDenialLog{path: filepath.Join(stageDir, "tool_policy_denials.ndjson")}
+}
+
+func (l *toolPolicyDenialLog) record(ev agent.SessionEvent) error {
+	if l == nil || ev.Kind != agent.EventToolCallDenied {
+		return nil
+	}
+	b, err := json.Marshal(map[string]any{
+		"timestamp":      ev.Timestamp,
+		"session_id":     ev.SessionID,
+		"tool_name":      ev.Data["tool_name"],
+		"call_id":        ev.Data["call_id"],
+		"arguments_json": ev.Data["arguments_json"],
+		"rule":           ev.Data["rule"],
+		"reason":         ev.Data["reason"],
+	})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
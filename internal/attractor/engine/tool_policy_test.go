@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+func TestLoadRunConfigFile_ToolPolicy(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+version: 1
+repo:
+  path: /tmp/repo
+cxdb:
+  binary_addr: 127.0.0.1:9009
+  http_base_url: http://127.0.0.1:9010
+modeldb:
+  openrouter_model_info_path: /tmp/catalog.json
+`
+	load := func(name, y string) (*RunConfigFile, error) {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(base+y), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadRunConfigFile(p)
+	}
+
+	cfg, err := load("ok.yaml", `tool_policy:
+  max_writes: 40
+  rules:
+    - name: no-push
+      effect: Deny
+      commands: ["git ** push"]
+      reason: pushing happens after the run
+    - effect: deny
+      paths: [".github/**"]
+      access: write
+tool_policies:
+  readonly:
+    default: deny
+    rules:
+      - effect: allow
+        tools: [read_file, grep, glob, list_dir]
+`)
+	if err != nil {
+		t.Fatalf("LoadRunConfigFile: %v", err)
+	}
+	if cfg.ToolPolicy.Rules[0].Effect != "deny" || cfg.ToolPolicies["readonly"].Default != "deny" {
+		t.Fatalf("policy: %+v %+v", cfg.ToolPolicy, cfg.ToolPolicies)
+	}
+
+	bad := map[string]string{
+		"effect.yaml":  "tool_policy:\n  rules:\n    - effect: block\n      tools: [shell]\n",
+		"empty.yaml":   "tool_policy:\n  rules:\n    - effect: deny\n",
+		"default.yaml": "tool_policies:\n  x:\n    default: maybe\n",
+		"none.yaml":    "tool_policies:\n  none:\n    default: deny\n",
+	}
+	for name, y := range bad {
+		if _, err := load(name, y); err == nil || !strings.Contains(err.Error(), "tool_polic") {
+			t.Fatalf("%s: expected tool_policy error, got %v", name, err)
+		}
+	}
+}
+
+func TestResolveToolPolicy_SelectsNamedPolicyAndAppliesAttrs(t *testing.T) {
+	g := model.NewGraph("g")
+	g.Attrs["tool_policy.deny_commands"] = "git push, npm publish"
+	n := model.NewNode("a")
+	n.Attrs["tool_policy"] = "strict"
+	n.Attrs["tool_policy.deny_paths"] = "ci/**"
+	n.Attrs["tool_policy.max_writes"] = "3"
+	cfg := &RunConfigFile{
+		ToolPolicy: &ToolPolicyConfig{Rules: []ToolPolicyRuleConfig{{Effect: "deny", Tools: []string{"shell"}}}},
+		ToolPolicies: map[string]ToolPolicyConfig{
+			"strict": {MaxWrites: 10, Rules: []ToolPolicyRuleConfig{{Name: "strict", Effect: "deny", Tools: []string{"mcp__*"}}}},
+		},
+	}
+	exec := &Execution{Engine: &Engine{Graph: g, RunConfig: cfg}}
+
+	p, err := resolveToolPolicy(exec, n)
+	if err != nil {
+		t.Fatalf("resolveToolPolicy: %v", err)
+	}
+	if p.MaxWrites != 3 || len(p.Rules) != 3 || p.Rules[0].Name != "strict" {
+		t.Fatalf("policy: %+v", p)
+	}
+	if got := strings.Join(p.Rules[1].Commands, "|"); got != "git push|npm publish" {
+		t.Fatalf("graph deny_commands: %q", got)
+	}
+	if p.Rules[2].Paths[0] != "ci/**" || p.Rules[2].Access != "write" {
+		t.Fatalf("node deny_paths: %+v", p.Rules[2])
+	}
+	if len(cfg.ToolPolicies["strict"].Rules) != 1 {
+		t.Fatalf("named policy was mutated: %+v", cfg.ToolPolicies["strict"])
+	}
+
+	n.Attrs["tool_policy"] = "none"
+	delete(n.Attrs, "tool_policy.deny_paths")
+	delete(n.Attrs, "tool_policy.max_writes")
+	if p, err = resolveToolPolicy(exec, n); err != nil || len(p.Rules) != 1 || p.Rules[0].Commands == nil {
+		t.Fatalf("none + graph attrs: %+v %v", p, err)
+	}
+
+	n.Attrs["tool_policy"] = "missing"
+	if _, err := resolveToolPolicy(exec, n); err == nil || !strings.Contains(err.Error(), "not declared") {
+		t.Fatalf("expected undeclared policy error, got %v", err)
+	}
+	n.Attrs["tool_policy"] = "strict"
+	n.Attrs["tool_policy.allow_tools"] = "shell"
+	if _, err := resolveToolPolicy(exec, n); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestRunWithConfig_APIAgentLoop_ToolPolicyDeniesGitPush(t *testing.T) {
+	repo := initTestRepo(t)
+	logsRoot := t.TempDir()
+	pinned := writePinnedCatalog(t)
+	cxdbSrv := newCXDBTestServer(t)
+
+	var mu sync.Mutex
+	var bodies []string
+	openaiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/responses" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n == 1 {
+			_, _ = w.Write([]byte(`{
+  "id": "resp_1",
+  "model": "gpt-5.2",
+  "output": [{"type":"function_call","id":"call_1","call_id":"call_1","name":"shell","arguments":"{\"command\":\"git status && git push origin HEAD\"}"}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{
+  "id": "resp_2",
+  "model": "gpt-5.2",
+  "output": [{"type":"message","content":[{"type":"output_text","text":"done"}]}],
+  "usage": {"input_tokens": 1, "output_tokens": 2, "total_tokens": 3}
+}`))
+	}))
+	t.Cleanup(openaiSrv.Close)
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_BASE_URL", openaiSrv.URL)
+
+	cfg := &RunConfigFile{Version: 1}
+	cfg.Repo.Path = repo
+	cfg.CXDB.BinaryAddr = cxdbSrv.BinaryAddr()
+	cfg.CXDB.HTTPBaseURL = cxdbSrv.URL()
+	cfg.LLM.Providers = map[string]ProviderConfig{
+		"openai": {Backend: BackendAPI, Failover: []string{}},
+	}
+	cfg.ModelDB.OpenRouterModelInfoPath = pinned
+	cfg.ModelDB.OpenRouterModelInfoUpdatePolicy = "pinned"
+	cfg.Git.RunBranchPrefix = "attractor/run"
+	disableProbe := false
+	cfg.Preflight.PromptProbes.Enabled = &disableProbe
+	cfg.ToolPolicy = &ToolPolicyConfig{Rules: []ToolPolicyRuleConfig{
+		{Name: "no-push", Effect: "deny", Commands: []string{"git ** push"}, Reason: "pushing happens after the run"},
+	}}
+
+	dot := []byte(`
+digraph G {
+  graph [goal="try to push"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, auto_status=true, prompt="push"]
+  start -> a -> exit
+}
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := RunWithConfig(ctx, dot, cfg, RunOptions{RunID: "api-tool-policy-test", LogsRoot: logsRoot})
+	if err != nil {
+		t.Fatalf("RunWithConfig: %v", err)
+	}
+
+	mu.Lock()
+	if len(bodies) < 2 || !strings.Contains(bodies[1], "tool_call_denied") || !strings.Contains(bodies[1], "no-push") {
+		mu.Unlock()
+		t.Fatalf("denial not returned to the model: %v", bodies)
+	}
+	mu.Unlock()
+
+	b, err := os.ReadFile(filepath.Join(res.LogsRoot, "a", "tool_policy_denials.ndjson"))
+	if err != nil {
+		t.Fatalf("read denials: %v", err)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal(b, &rec); err != nil {
+		t.Fatalf("denial record %q: %v", b, err)
+	}
+	if rec["tool_name"] != "shell" || rec["rule"] != "no-push" || rec["call_id"] != "call_1" {
+		t.Fatalf("denial record: %v", rec)
+	}
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+	"github.com/danshapiro/kilroy/internal/tracing"
+	"github.com/danshapiro/kilroy/internal/version"
+)
+
+const defaultTraceFileName = "traces.jsonl"
+
+// startRunTracing installs the run's tracer (tracing block of the run config)
+// on ctx and opens the root "attractor.run" span. The trace ID is derived from
+// the run ID, so a resumed run extends the original trace. The returned func
+// ends the span and flushes the exporter; it is a no-op when tracing is off.
+func (e *Engine) startRunTracing(ctx context.Context, resumed bool) (context.Context, func(error)) {
+	noop := func(error) {}
+	if e.RunConfig == nil || !e.RunConfig.Tracing.Enabled {
+		return ctx, noop
+	}
+	exp, err := newTraceExporter(e.RunConfig.Tracing, e.LogsRoot)
+	if err != nil {
+		e.Warn(fmt.Sprintf("tracing disabled: %v", err))
+		return ctx, noop
+	}
+	var warnOnce sync.Once
+	tracer := tracing.NewTracer(exp, tracing.Options{
+		ServiceName:    e.RunConfig.Tracing.ServiceName,
+		ServiceVersion: version.Version,
+		ResourceAttributes: []tracing.KeyValue{
+			tracing.String("kilroy.run_id", e.Options.RunID),
+		},
+		OnError: func(err error) {
+			warnOnce.Do(func() { e.Warn(fmt.Sprintf("trace export failed: %v", err)) })
+		},
+	})
+	ctx = tracing.WithTraceID(tracing.WithTracer(ctx, tracer), tracing.TraceIDFromSeed(e.Options.RunID))
+	graphName := ""
+	if e.Graph != nil {
+		graphName = e.Graph.Name
+	}
+	ctx, span := tracing.Start(ctx, "attractor.run",
+		tracing.String("attractor.run_id", e.Options.RunID),
+		tracing.String("attractor.graph", graphName),
+		tracing.Bool("attractor.resumed", resumed),
+	)
+	return ctx, func(err error) {
+		span.RecordError(err)
+		span.End()
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = tracer.Shutdown(sctx)
+	}
+}
+
+func newTraceExporter(cfg TracingConfig, logsRoot string) (tracing.Exporter, error) {
+	switch cfg.Exporter {
+	case "", "file":
+		path := cfg.FilePath
+		if path == "" {
+			path = filepath.Join(logsRoot, defaultTraceFileName)
+		}
+		return tracing.NewFileExporter(path), nil
+	case "otlp_http":
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = strings.TrimSpace(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"))
+		}
+		return tracing.NewOTLPHTTPExporter(tracing.OTLPHTTPConfig{
+			Endpoint: endpoint,
+			Headers:  cfg.Headers,
+			Timeout:  time.Duration(cfg.TimeoutMS) * time.Millisecond,
+		})
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+}
+
+type stageAttemptKey struct{}
+
+// withStageAttempt records the retry attempt number for the node span.
+func withStageAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, stageAttemptKey{}, attempt)
+}
+
+func startNodeSpan(ctx context.Context, node *model.Node) (context.Context, *tracing.Span) {
+	attempt, ok := ctx.Value(stageAttemptKey{}).(int)
+	if !ok {
+		attempt = 1
+	}
+	return tracing.Start(ctx, "attractor.node",
+		tracing.String("attractor.node.id", node.ID),
+		tracing.String("attractor.node.handler", resolvedHandlerType(node)),
+		tracing.Int("attractor.node.attempt", attempt),
+	)
+}
+
+func endNodeSpan(span *tracing.Span, out runtime.Outcome, err error) {
+	span.SetAttributes(tracing.String("attractor.outcome.status", string(out.Status)))
+	if fc, _ := out.Meta["failure_class"].(string); fc != "" {
+		span.SetAttributes(tracing.String("attractor.outcome.failure_class", fc))
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+	case out.Status == runtime.StatusFail || out.Status == runtime.StatusRetry:
+		span.SetStatus(tracing.StatusError, out.FailureReason)
+	}
+	span.End()
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/tracing"
+)
+
+func TestLoadRunConfigFile_TracingDefaultsAndValidation(t *testing.T) {
+	dir := t.TempDir()
+	base := `
+version: 1
+repo:
+  path: /tmp/repo
+cxdb:
+  binary_addr: 127.0.0.1:9009
+  http_base_url: http://127.0.0.1:9010
+modeldb:
+  openrouter_model_info_path: /tmp/catalog.json
+`
+	write := func(name, tracingYAML string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(base+tracingYAML), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	cfg, err := LoadRunConfigFile(write("file.yaml", "tracing:\n  enabled: true\n"))
+	if err != nil {
+		t.Fatalf("LoadRunConfigFile: %v", err)
+	}
+	if cfg.Tracing.Exporter != "file" {
+		t.Fatalf("default exporter: %q", cfg.Tracing.Exporter)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if _, err := LoadRunConfigFile(write("otlp.yaml", "tracing:\n  enabled: true\n  exporter: otlp_http\n")); err == nil || !strings.Contains(err.Error(), "tracing.endpoint") {
+		t.Fatalf("expected missing endpoint error, got %v", err)
+	}
+	if _, err := LoadRunConfigFile(write("bad.yaml", "tracing:\n  enabled: true\n  exporter: zipkin\n")); err == nil || !strings.Contains(err.Error(), "tracing.exporter") {
+		t.Fatalf("expected invalid exporter error, got %v", err)
+	}
+}
+
+func TestRun_TracingFileExporter_OneTracePerRunWithNodeSpans(t *testing.T) {
+	repo := t.TempDir()
+	runCmd(t, repo, "git", "init")
+	runCmd(t, repo, "git", "config", "user.name", "tester")
+	runCmd(t, repo, "git", "config", "user.email", "tester@example.com")
+	_ = os.WriteFile(filepath.Join(repo, "README.md"), []byte("hello\n"), 0o644)
+	runCmd(t, repo, "git", "add", "-A")
+	runCmd(t, repo, "git", "commit", "-m", "init")
+
+	dot := []byte(`
+digraph T {
+  graph [goal="test"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="do nothing"]
+  start -> a -> exit
+}
+`)
+	opts := RunOptions{RepoPath: repo}
+	if err := opts.applyDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewDefaultRegistry()
+	g, _, err := PrepareWithOptions(dot, PrepareOptions{RepoPath: repo, KnownTypes: reg.KnownTypes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := newBaseEngine(g, dot, opts)
+	eng.Registry = reg
+	eng.CodergenBackend = &SimulatedCodergenBackend{}
+	eng.RunConfig = &RunConfigFile{}
+	eng.RunConfig.Tracing.Enabled = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := eng.run(ctx)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	spans, err := tracing.ReadFile(filepath.Join(res.LogsRoot, defaultTraceFileName))
+	if err != nil {
+		t.Fatalf("read traces: %v", err)
+	}
+	wantTrace := tracing.TraceIDFromSeed(res.RunID).String()
+	var root tracing.OTLPSpan
+	nodes := map[string]tracing.OTLPSpan{}
+	for _, s := range spans {
+		if s.TraceID != wantTrace {
+			t.Fatalf("span %s in trace %s, want %s", s.Name, s.TraceID, wantTrace)
+		}
+		switch s.Name {
+		case "attractor.run":
+			root = s
+		case "attractor.node":
+			nodes[s.Attr("attractor.node.id")] = s
+		}
+	}
+	if root.SpanID == "" || root.Attr("attractor.run_id") != res.RunID {
+		t.Fatalf("missing root span: %+v", spans)
+	}
+	for _, id := range []string{"start", "a", "exit"} {
+		n, ok := nodes[id]
+		if !ok {
+			t.Fatalf("missing node span for %s", id)
+		}
+		if n.ParentSpanID != root.SpanID {
+			t.Fatalf("node %s not parented to run span", id)
+		}
+		if n.Attr("attractor.outcome.status") != "success" || n.Attr("attractor.node.attempt") != "1" {
+			t.Fatalf("node %s attrs: %+v", id, n.Attributes)
+		}
+	}
+	if nodes["a"].Attr("attractor.node.handler") != "codergen" {
+		t.Fatalf("handler attr: %+v", nodes["a"].Attributes)
+	}
+}
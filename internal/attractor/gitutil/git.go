@@ -3,7 +3,10 @@ package gitutil
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
@@ -23,6 +26,10 @@ func (e *CommandError) Error() string {
 }
 
 func runGit(dir string, args ...string) (string, string, error) {
+	return runGitEnv(dir, nil, args...)
+}
+
+func runGitEnv(dir string, env []string, args ...string) (string, string, error) {
 	// Disable Git's background auto-maintenance (introduced as a default in newer Git versions)
 	// to keep Attractor runs deterministic and to avoid spawning extra long-running helper
 	// processes during frequent checkpoint commits.
@@ -32,6 +39,9 @@ func runGit(dir string, args ...string) (string, string, error) {
 		"-c", "gc.auto=0",
 	}
 	cmd := exec.Command("git", append(base, args...)...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -212,3 +222,145 @@ func ensureUserIdentity(worktreeDir string) error {
 	}
 	return nil
 }
+
+// WorktreeTree writes the working tree (tracked changes and untracked,
+// non-ignored files) as a tree object and returns its hash, leaving the
+// index untouched. Equal hashes mean equal worktree contents.
+func WorktreeTree(dir string) (string, error) {
+	indexPath, _, err := runGit(dir, "rev-parse", "--git-path", "index")
+	if err != nil {
+		return "", err
+	}
+	indexPath = strings.TrimSpace(indexPath)
+	if !filepath.IsAbs(indexPath) {
+		indexPath = filepath.Join(dir, indexPath)
+	}
+	tmp, err := os.CreateTemp("", "kilroy-index-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	// Seed from the real index so unchanged files hit the stat cache.
+	if src, err := os.Open(indexPath); err == nil {
+		_, err = io.Copy(tmp, src)
+		_ = src.Close()
+		if err != nil {
+			_ = tmp.Close()
+			return "", err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+	if _, _, err := runGitEnv(dir, env, "add", "-A"); err != nil {
+		return "", err
+	}
+	out, _, err := runGitEnv(dir, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// WorktreeSnapshotCommit records the working tree, as WorktreeTree does, in
+// a commit on top of parent (a root commit when parent is empty) and returns
+// its hash. Neither HEAD nor the index moves.
+func WorktreeSnapshotCommit(dir, parent, message string) (string, error) {
+	tree, err := WorktreeTree(dir)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	env := []string{
+		"GIT_AUTHOR_NAME=kilroy-attractor", "GIT_AUTHOR_EMAIL=kilroy-attractor@local",
+		"GIT_COMMITTER_NAME=kilroy-attractor", "GIT_COMMITTER_EMAIL=kilroy-attractor@local",
+	}
+	out, _, err := runGitEnv(dir, env, args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Version returns the major and minor version of the git on PATH.
+func Version() (major, minor int, err error) {
+	out, _, err := runGit(".", "version")
+	if err != nil {
+		return 0, 0, err
+	}
+	// "git version 2.39.3 (Apple Git-145)", "git version 2.45.1.windows.1"
+	fields := strings.Fields(out)
+	if len(fields) < 3 {
+		return 0, 0, fmt.Errorf("unrecognized git version %q", strings.TrimSpace(out))
+	}
+	if _, err := fmt.Sscanf(fields[2], "%d.%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("unrecognized git version %q", strings.TrimSpace(out))
+	}
+	return major, minor, nil
+}
+
+// RestoreWorktreeTree makes the working tree match tree (as written by
+// WorktreeTree) while keeping HEAD and the index at HEAD, so the restored
+// changes show up as uncommitted edits.
+func RestoreWorktreeTree(dir, tree string) error {
+	if _, _, err := runGit(dir, "read-tree", "-u", "--reset", tree); err != nil {
+		return err
+	}
+	_, _, err := runGit(dir, "reset", "-q")
+	return err
+}
+
+// RevertWorktreeChanges undoes the working tree changes made since from (a
+// tree written by WorktreeTree): changed and deleted files get their old
+// content back and new files are removed. HEAD and the index are left alone.
+// It returns the reverted paths, relative to the repository root.
+func RevertWorktreeChanges(dir, from string) ([]string, error) {
+	to, err := WorktreeTree(dir)
+	if err != nil {
+		return nil, err
+	}
+	top, _, err := runGit(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	top = strings.TrimSpace(top)
+	out, _, err := runGit(top, "diff", "--name-status", "--no-renames", "-z", from, to)
+	if err != nil {
+		return nil, err
+	}
+	var paths, restore []string
+	fields := strings.Split(strings.TrimRight(out, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		paths = append(paths, path)
+		if status == "A" {
+			if err := os.Remove(filepath.Join(top, filepath.FromSlash(path))); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		restore = append(restore, path)
+	}
+	if len(restore) > 0 {
+		// Check the old versions out through a throwaway index.
+		tmp, err := os.CreateTemp("", "kilroy-index-*")
+		if err != nil {
+			return nil, err
+		}
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		defer func() { _ = os.Remove(tmp.Name()) }()
+		env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+		if _, _, err := runGitEnv(top, env, "read-tree", from); err != nil {
+			return nil, err
+		}
+		if _, _, err := runGitEnv(top, env, append([]string{"checkout-index", "-f", "--"}, restore...)...); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -89,3 +90,107 @@ func TestDiffNameOnly_NoChanges(t *testing.T) {
 		t.Errorf("DiffNameOnly with no changes = %v, want []", files)
 	}
 }
+
+func TestWorktreeTree_TracksChangesAndRestores(t *testing.T) {
+	dir := initTestRepo(t)
+	clean, err := WorktreeTree(dir)
+	if err != nil {
+		t.Fatalf("WorktreeTree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "initial.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dirty, err := WorktreeTree(dir)
+	if err != nil {
+		t.Fatalf("WorktreeTree: %v", err)
+	}
+	if dirty == clean {
+		t.Fatalf("tree hash did not change with worktree edits")
+	}
+	if out, _ := StatusPorcelain(dir); !strings.Contains(out, "?? new.txt") {
+		t.Fatalf("index was modified: %q", out)
+	}
+
+	if err := ResetHard(dir, "HEAD"); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(filepath.Join(dir, "new.txt"))
+	if err := RestoreWorktreeTree(dir, dirty); err != nil {
+		t.Fatalf("RestoreWorktreeTree: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "new.txt")); string(b) != "new" {
+		t.Fatalf("new.txt = %q", b)
+	}
+	if got, _ := WorktreeTree(dir); got != dirty {
+		t.Fatalf("restored tree %s, want %s", got, dirty)
+	}
+	if out, _ := StatusPorcelain(dir); !strings.Contains(out, " M initial.txt") || !strings.Contains(out, "?? new.txt") {
+		t.Fatalf("restored changes should be unstaged: %q", out)
+	}
+}
+
+func TestRevertWorktreeChanges_UndoesEditsSinceSnapshot(t *testing.T) {
+	dir := initTestRepo(t)
+	if err := os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("user edit"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := WorktreeTree(dir)
+	if err != nil {
+		t.Fatalf("WorktreeTree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "initial.txt"), []byte("agent edit"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "added.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := RevertWorktreeChanges(dir, before)
+	if err != nil {
+		t.Fatalf("RevertWorktreeChanges: %v", err)
+	}
+	if strings.Join(paths, ",") != "added.txt,initial.txt,keep.txt" {
+		t.Fatalf("paths: %v", paths)
+	}
+	if got, _ := WorktreeTree(dir); got != before {
+		t.Fatalf("worktree not back at the snapshot")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "keep.txt")); string(b) != "user edit" {
+		t.Fatalf("keep.txt = %q", b)
+	}
+	if out, _ := StatusPorcelain(dir); strings.Contains(out, "A ") {
+		t.Fatalf("index was modified: %q", out)
+	}
+}
+
+func TestWorktreeSnapshotCommit_RecordsWorktreeOnParent(t *testing.T) {
+	dir := initTestRepo(t)
+	head, err := HeadSHA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := WorktreeSnapshotCommit(dir, head, "snapshot")
+	if err != nil {
+		t.Fatalf("WorktreeSnapshotCommit: %v", err)
+	}
+	if parent, _, _ := runGit(dir, "rev-parse", snap+"^"); strings.TrimSpace(parent) != head {
+		t.Fatalf("parent = %q, want %s", parent, head)
+	}
+	if b, _, _ := runGit(dir, "show", snap+":new.txt"); b != "new" {
+		t.Fatalf("new.txt in snapshot = %q", b)
+	}
+	if got, _ := HeadSHA(dir); got != head {
+		t.Fatalf("HEAD moved to %s", got)
+	}
+	if out, _ := StatusPorcelain(dir); !strings.Contains(out, "?? new.txt") {
+		t.Fatalf("index was modified: %q", out)
+	}
+}
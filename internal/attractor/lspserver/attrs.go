@@ -0,0 +1,160 @@
+package lspserver
+
+import "strings"
+
+// Where an attribute may be set.
+const (
+	onGraph = 1 << iota
+	onNode
+	onEdge
+)
+
+type attrInfo struct {
+	Name   string
+	Where  int
+	Doc    string
+	Values []string // completion candidates for the value, if it is an enum
+	// NodeRef marks attributes whose value is a node ID (completion and
+	// go-to-definition offer the graph's nodes).
+	NodeRef bool
+}
+
+var boolValues = []string{"true", "false"}
+
+var fidelityValues = []string{"full", "truncate", "compact", "summary:low", "summary:medium", "summary:high"}
+
+// shapeTypes maps Graphviz shapes to the handler type they select.
+var shapeTypes = []struct{ Shape, Type string }{
+	{"Mdiamond", "start"},
+	{"Msquare", "exit"},
+	{"box", "codergen"},
+	{"hexagon", "wait.human"},
+	{"diamond", "conditional"},
+	{"component", "parallel"},
+	{"tripleoctagon", "parallel.fan_in"},
+	{"parallelogram", "tool"},
+	{"house", "stack.manager_loop"},
+	{"circle", "start"},
+	{"doublecircle", "exit"},
+}
+
+func shapeNames() []string {
+	out := make([]string, 0, len(shapeTypes))
+	for _, st := range shapeTypes {
+		out = append(out, st.Shape)
+	}
+	return out
+}
+
+// attrCatalog documents the attributes the engine reads. Keep it in step with
+// the attribute tables in docs/strongdm/attractor/attractor-spec.md.
+var attrCatalog = []attrInfo{
+	// Graph.
+	{Name: "goal", Where: onGraph, Doc: "Human-readable goal for the pipeline. Exposed as `$goal` in prompts and as `graph.goal` in the run context."},
+	{Name: "model_stylesheet", Where: onGraph, Doc: "CSS-like stylesheet setting per-node LLM provider/model defaults by `*`, `.class` or `#id`."},
+	{Name: "default_max_retry", Where: onGraph, Doc: "Retry ceiling for nodes that omit `max_retries` (default 3)."},
+	{Name: "default_fidelity", Where: onGraph, Doc: "Default context fidelity mode for LLM stages.", Values: fidelityValues},
+	{Name: "max_node_visits", Where: onGraph, Doc: "How many times one node may run before the run fails as a loop."},
+	{Name: "max_restarts", Where: onGraph, Doc: "Maximum `loop_restart` relaunches in one run (default 50)."},
+	{Name: "loop_restart_signature_limit", Where: onGraph, Doc: "Restarts allowed with the same failure signature before the run stops."},
+	{Name: "loop_restart_persist_keys", Where: onGraph, Doc: "Comma-separated context keys carried across a `loop_restart`."},
+	{Name: "retries_before_escalation", Where: onGraph, Doc: "Same-model retries before moving to the next `escalation_models` entry (default 2)."},
+	{Name: "default_command_timeout_ms", Where: onGraph | onNode, Doc: "Default timeout for agent shell commands, in milliseconds."},
+	{Name: "max_command_timeout_ms", Where: onGraph | onNode, Doc: "Upper bound on the timeout an agent may request for a shell command, in milliseconds."},
+	{Name: "memory_max_bytes", Where: onGraph, Doc: "Size budget for run memory written with `memory_write`."},
+	{Name: "stack.child_dotfile", Where: onGraph | onNode, Doc: "Path to the child DOT pipeline a `house` manager loop supervises, relative to the worktree."},
+	{Name: "rankdir", Where: onGraph, Doc: "Graphviz layout direction; ignored by the engine.", Values: []string{"TB", "LR", "BT", "RL"}},
+	{Name: "provenance_version", Where: onGraph, Doc: "Marks graphs generated from the reference template; enables template lint rules."},
+
+	// Graph or node.
+	{Name: "label", Where: onGraph | onNode | onEdge, Doc: "Display name. On edges it is also the routing key matched against a stage's preferred label."},
+	{Name: "retry_target", Where: onGraph | onNode, Doc: "Node to jump to when this node (or, on the graph, a goal gate) fails after its retries.", NodeRef: true},
+	{Name: "fallback_retry_target", Where: onGraph | onNode, Doc: "Secondary retry target, used when `retry_target` is missing or invalid.", NodeRef: true},
+	{Name: "tool_hooks.pre", Where: onGraph | onNode, Doc: "Shell command run before each agent tool call; a non-zero exit skips the call."},
+	{Name: "tool_hooks.post", Where: onGraph | onNode, Doc: "Shell command run after each agent tool call, with the call and its file changes as JSON on stdin."},
+	{Name: "tool_policy", Where: onGraph | onNode, Doc: "Name of a tool policy from the run config that allows or denies agent tool calls."},
+	{Name: "mcp_servers", Where: onGraph | onNode, Doc: "Comma-separated MCP servers (from the run config) attached to agent stages; `none` attaches none."},
+	{Name: "language_servers", Where: onGraph | onNode, Doc: "Comma-separated language servers (from the run config) backing the code intelligence tools; `none` disables them."},
+	{Name: "memory_prompt_budget", Where: onGraph | onNode, Doc: "Characters of run memory added to the stage prompt (default follows fidelity)."},
+	{Name: "subagent_isolation", Where: onGraph | onNode, Doc: "Whether subagents share the stage worktree or get their own.", Values: []string{"shared", "worktree"}},
+	{Name: "max_subagent_depth", Where: onGraph | onNode, Doc: "How deeply agents may spawn subagents (positive integer)."},
+
+	// Node.
+	{Name: "shape", Where: onNode, Doc: "Graphviz shape; selects the default handler type (box = codergen, diamond = conditional, parallelogram = tool, ...).", Values: shapeNames()},
+	{Name: "type", Where: onNode, Doc: "Explicit handler type; takes precedence over the shape."},
+	{Name: "prompt", Where: onNode, Doc: "Instruction for the stage. Supports `$goal` expansion; with `prompt_template=true` it is a template rendered when the stage starts (`.retry_failure_reason`, `.outcomes.<node>.status`, `include \"file\"`, ...)."},
+	{Name: "prompt_template", Where: onGraph | onNode, Doc: "Render prompts as Go templates over run state when the stage starts. A node's value overrides the graph's; other prompts are used verbatim.", Values: boolValues},
+	{Name: "llm_prompt", Where: onNode, Doc: "Alias of `prompt`."},
+	{Name: "prompt_file", Where: onNode, Doc: "File (relative to the repo root) whose content becomes the prompt. Mutually exclusive with `prompt`."},
+	{Name: "import", Where: onNode, Doc: "DOT fragment (relative to the repo root) whose stages replace this node, as `<node>.<stage>`. Set the fragment's parameters with `param.<name>`."},
+	{Name: "class", Where: onNode, Doc: "Comma-separated classes for `model_stylesheet` selectors."},
+	{Name: "llm_provider", Where: onNode, Doc: "LLM provider for the stage. Required on codergen nodes.", Values: []string{"openai", "anthropic", "google", "kimi", "zai", "minimax"}},
+	{Name: "llm_model", Where: onNode, Doc: "LLM model identifier. Overridable by the stylesheet."},
+	{Name: "reasoning_effort", Where: onNode, Doc: "LLM reasoning effort.", Values: []string{"low", "medium", "high"}},
+	{Name: "codergen_mode", Where: onNode, Doc: "`agent_loop` runs a tool-using agent (API default); `one_shot` makes a single request.", Values: []string{"agent_loop", "one_shot"}},
+	{Name: "llm_mode", Where: onNode, Doc: "`batch` submits one-shot requests through the provider batch API.", Values: []string{"sync", "batch"}},
+	{Name: "max_agent_turns", Where: onNode, Doc: "Turn budget for an `agent_loop` stage."},
+	{Name: "auto_status", Where: onNode, Doc: "If true and the stage writes no status, the engine records SUCCESS.", Values: boolValues},
+	{Name: "goal_gate", Where: onNode, Doc: "If true, this node must reach SUCCESS before the pipeline may exit.", Values: boolValues},
+	{Name: "allow_partial", Where: onNode, Doc: "Accept PARTIAL_SUCCESS when retries are exhausted instead of failing.", Values: boolValues},
+	{Name: "max_retries", Where: onNode, Doc: "Additional attempts beyond the first; `max_retries=3` means up to 4 executions."},
+	{Name: "timeout", Where: onNode, Doc: "Maximum execution time for the node (e.g. `900s`)."},
+	{Name: "fidelity", Where: onNode | onEdge, Doc: "Context fidelity mode for the stage's LLM session. On an edge it overrides the target node.", Values: fidelityValues},
+	{Name: "thread_id", Where: onNode | onEdge, Doc: "Thread key for session reuse under `full` fidelity and for run memory namespaces."},
+	{Name: "tool_command", Where: onNode, Doc: "Shell command run by a `parallelogram` (tool) node."},
+	{Name: "escalation_models", Where: onNode, Doc: "Comma-separated `provider:model` pairs tried in turn when the stage keeps failing."},
+	{Name: "patch_fuzz", Where: onNode, Doc: "Stale context lines `apply_patch` tolerates at either end of a hunk (default 2)."},
+	{Name: "batch_poll_interval", Where: onNode, Doc: "Initial poll interval for `llm_mode=batch`."},
+	{Name: "batch_poll_max_interval", Where: onNode, Doc: "Maximum poll interval for `llm_mode=batch`."},
+	{Name: "join_policy", Where: onNode, Doc: "How a `component` fan-out decides success.", Values: []string{"wait_all", "first_success", "k_of_n", "quorum"}},
+	{Name: "error_policy", Where: onNode, Doc: "What a fan-out does when a branch fails.", Values: []string{"continue", "fail_fast", "ignore"}},
+	{Name: "max_parallel", Where: onNode, Doc: "Concurrent branches in a fan-out (default 4)."},
+	{Name: "k", Where: onNode, Doc: "Branches that must succeed under `join_policy=k_of_n`."},
+	{Name: "quorum_fraction", Where: onNode, Doc: "Fraction of branches that must succeed under `join_policy=quorum` (default 0.5)."},
+	{Name: "question", Where: onNode, Doc: "Question shown at a `hexagon` human gate."},
+	{Name: "human.default_choice", Where: onNode, Doc: "Edge target chosen when a human gate times out.", NodeRef: true},
+	{Name: "manager.poll_interval", Where: onNode, Doc: "Time between manager loop observation cycles (default 45s)."},
+	{Name: "manager.max_cycles", Where: onNode, Doc: "Observation cycles before the manager loop fails (default 1000)."},
+	{Name: "manager.stop_condition", Where: onNode, Doc: "Condition checked each cycle; when true the manager loop succeeds."},
+	{Name: "manager.actions", Where: onNode, Doc: "Comma-separated actions per cycle: `observe`, `wait`, `steer`."},
+	{Name: "stack.child_autostart", Where: onNode, Doc: "Whether the manager loop starts the child pipeline itself (default true).", Values: boolValues},
+
+	// Edge.
+	{Name: "condition", Where: onEdge, Doc: "Guard evaluated against the outcome and context: `outcome=success`, `context.key!=value`, clauses joined by `&&`."},
+	{Name: "weight", Where: onEdge, Doc: "Priority among equally eligible edges; higher wins."},
+	{Name: "loop_restart", Where: onEdge, Doc: "If true, following this edge relaunches the run with a fresh log directory.", Values: boolValues},
+}
+
+func lookupAttr(name string) (attrInfo, bool) {
+	for _, a := range attrCatalog {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	// Families keyed by a user-chosen name.
+	switch {
+	case strings.HasPrefix(name, "agent_tool."):
+		return attrInfo{Name: name, Where: onGraph | onNode, Doc: "Declares a command-backed agent tool: `agent_tool.<name>.command`, `.description`, `.parameters`, `.timeout_ms`, `.max_chars`, `.max_lines`, `.truncation`."}, true
+	case strings.HasPrefix(name, "param."):
+		return attrInfo{Name: name, Where: onGraph | onNode, Doc: "Fragment parameter. On a fragment's graph it declares `$" + strings.TrimPrefix(name, "param.") + "` with a default; on an `import` node it sets the value."}, true
+	case strings.HasPrefix(name, "tool_policy."):
+		return attrInfo{Name: name, Where: onGraph | onNode, Doc: "Inline tool policy override: `deny_tools`, `deny_commands`, `deny_paths`, `max_writes`."}, true
+	}
+	return attrInfo{}, false
+}
+
+// conditionKeys are the context keys the engine itself sets; conditions may
+// also test keys a stage writes through context_updates.
+var conditionKeys = []string{
+	"context.failure_class",
+	"context.failure_reason",
+	"context.current_node",
+	"context.previous_node",
+	"context.graph.goal",
+	"context.loop_restart.iteration_count",
+	"context.loop_restart.from_node",
+	"context.last_stage",
+	"context.last_response",
+}
+
+var outcomeValues = []string{"success", "partial_success", "retry", "fail"}
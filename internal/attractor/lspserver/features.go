@@ -0,0 +1,445 @@
+package lspserver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/lsp"
+)
+
+// What the cursor is on.
+const (
+	atNothing   = iota
+	atAttrKey   // inside [...] before '='
+	atAttrValue // inside [...] after '=', or after "key =" at graph level
+	atEdgeEnd   // after "->"
+	atStatement // start of a statement: a node ID or keyword
+)
+
+type cursor struct {
+	kind  int
+	where int    // onGraph/onNode/onEdge for attribute positions
+	key   string // attribute being valued (atAttrValue)
+	// prefix is the partial word before the cursor that a completion
+	// replaces; value is the attribute value typed so far.
+	prefix string
+	value  string
+}
+
+var (
+	edgeEndRe   = regexp.MustCompile(`->\s*([A-Za-z0-9_]*)$`)
+	statementRe = regexp.MustCompile(`^\s*([A-Za-z0-9_]*)$`)
+	graphAttrRe = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_.]*)\s*=\s*"?([^"]*)$`)
+)
+
+// analyze works out the syntactic position at byte offset off by scanning
+// the text before it, skipping strings and comments. It is deliberately
+// forgiving: the text is usually mid-edit and does not parse.
+func analyze(text string, off int) cursor {
+	src := text[:off]
+	stmtStart, bracket, segStart, segEq := 0, -1, -1, -1
+	inString, escaped := false, false
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '/':
+			if i+1 < len(src) && src[i+1] == '/' {
+				nl := strings.IndexByte(src[i:], '\n')
+				if nl < 0 {
+					return cursor{} // in a line comment
+				}
+				i += nl - 1
+			} else if i+1 < len(src) && src[i+1] == '*' {
+				end := strings.Index(src[i+2:], "*/")
+				if end < 0 {
+					return cursor{} // in a block comment
+				}
+				i += end + 3
+			}
+		case '[':
+			bracket, segStart, segEq = i, i+1, -1
+		case ']':
+			bracket = -1
+			stmtStart = i + 1
+		case ',':
+			if bracket >= 0 {
+				segStart, segEq = i+1, -1
+			}
+		case '=':
+			if bracket >= 0 && segEq < 0 {
+				segEq = i
+			}
+		case ';', '{', '}', '\n':
+			if bracket < 0 {
+				stmtStart = i + 1
+			}
+		}
+	}
+
+	if bracket >= 0 {
+		c := cursor{where: headScope(text[stmtStart:bracket])}
+		if segEq < 0 {
+			if inString {
+				return cursor{}
+			}
+			c.kind = atAttrKey
+			c.prefix = strings.TrimSpace(src[segStart:])
+			return c
+		}
+		c.kind = atAttrValue
+		c.key = strings.TrimSpace(src[segStart:segEq])
+		c.value = strings.TrimPrefix(strings.TrimLeft(src[segEq+1:], " \t"), `"`)
+		c.prefix = valuePrefix(c.key, c.value)
+		return c
+	}
+	if inString {
+		line := src[stmtStart:]
+		if m := graphAttrRe.FindStringSubmatch(line); m != nil {
+			return cursor{kind: atAttrValue, where: onGraph, key: m[1], value: m[2], prefix: valuePrefix(m[1], m[2])}
+		}
+		return cursor{}
+	}
+	line := src[stmtStart:]
+	if m := edgeEndRe.FindStringSubmatch(line); m != nil {
+		return cursor{kind: atEdgeEnd, prefix: m[1]}
+	}
+	if m := statementRe.FindStringSubmatch(line); m != nil {
+		return cursor{kind: atStatement, prefix: m[1]}
+	}
+	if m := graphAttrRe.FindStringSubmatch(line); m != nil {
+		return cursor{kind: atAttrValue, where: onGraph, key: m[1], value: m[2], prefix: valuePrefix(m[1], m[2])}
+	}
+	return cursor{}
+}
+
+// headScope classifies the statement an attribute block belongs to.
+func headScope(head string) int {
+	head = strings.TrimSpace(head)
+	switch {
+	case strings.Contains(head, "->"):
+		return onEdge
+	case head == "graph":
+		return onGraph
+	case head == "edge":
+		return onEdge
+	default:
+		return onNode
+	}
+}
+
+// valuePrefix is the part of a value a completion replaces: the current
+// clause of a condition, else the whole value.
+func valuePrefix(key, value string) string {
+	if isConditionAttr(key) {
+		if i := strings.LastIndex(value, "&&"); i >= 0 {
+			value = value[i+2:]
+		}
+		return strings.TrimLeft(value, " ")
+	}
+	return value
+}
+
+func isConditionAttr(key string) bool {
+	return key == "condition" || key == "manager.stop_condition"
+}
+
+type completionItem struct {
+	Label         string         `json:"label"`
+	Kind          int            `json:"kind,omitempty"`
+	Detail        string         `json:"detail,omitempty"`
+	Documentation map[string]any `json:"documentation,omitempty"`
+	TextEdit      map[string]any `json:"textEdit,omitempty"`
+}
+
+// LSP CompletionItemKind values.
+const (
+	kindVariable   = 6
+	kindProperty   = 10
+	kindKeyword    = 14
+	kindReference  = 18
+	kindEnumMember = 20
+)
+
+func (s *server) complete(d *document, off int) []completionItem {
+	c := analyze(d.text, off)
+	items := []completionItem{}
+	edit := func(label, newText string, kind int, detail, doc string) {
+		if !strings.HasPrefix(label, c.prefix) {
+			return
+		}
+		it := completionItem{
+			Label:  label,
+			Kind:   kind,
+			Detail: detail,
+			TextEdit: map[string]any{
+				"range":   lsp.Range{Start: wireAt(d.text, off-len(c.prefix)), End: wireAt(d.text, off)},
+				"newText": newText,
+			},
+		}
+		if doc != "" {
+			it.Documentation = map[string]any{"kind": "markdown", "value": doc}
+		}
+		items = append(items, it)
+	}
+	nodes := func() {
+		for _, n := range sortedNodes(d.graph) {
+			edit(n.ID, n.ID, kindReference, nodeDetail(n), "")
+		}
+	}
+
+	switch c.kind {
+	case atAttrKey:
+		for _, a := range attrCatalog {
+			if a.Where&c.where != 0 {
+				edit(a.Name, a.Name+"=", kindProperty, scopeNames(a.Where)+" attribute", a.Doc)
+			}
+		}
+	case atAttrValue:
+		a, _ := lookupAttr(c.key)
+		switch {
+		case c.key == "type":
+			types := append([]string(nil), s.opts.KnownTypes...)
+			sort.Strings(types)
+			for _, t := range types {
+				edit(t, t, kindEnumMember, "handler type", "")
+			}
+		case a.NodeRef:
+			nodes()
+		case isConditionAttr(c.key):
+			s.completeCondition(d, c, edit)
+		default:
+			for _, v := range a.Values {
+				edit(v, v, kindEnumMember, c.key, "")
+			}
+		}
+	case atEdgeEnd:
+		nodes()
+	case atStatement:
+		nodes()
+		for _, kw := range []string{"graph", "node", "edge", "subgraph"} {
+			edit(kw, kw, kindKeyword, "", "")
+		}
+	}
+	return items
+}
+
+var contextKeyRe = regexp.MustCompile(`context\.[A-Za-z0-9_.]+`)
+
+func (s *server) completeCondition(d *document, c cursor, edit func(label, newText string, kind int, detail, doc string)) {
+	clause := c.prefix
+	if i := strings.Index(clause, "="); i >= 0 {
+		key := strings.TrimSpace(strings.TrimSuffix(clause[:i], "!"))
+		if key == "outcome" {
+			op := clause[:i+1]
+			for _, v := range outcomeValues {
+				edit(op+v, op+v, kindEnumMember, "stage status", "")
+			}
+		}
+		return
+	}
+	edit("outcome", "outcome=", kindVariable, "last stage status", "")
+	edit("preferred_label", "preferred_label=", kindVariable, "label the last stage asked to follow", "")
+	// Built-in keys, then keys other conditions in this file already test
+	// (less the one being typed).
+	seen := map[string]bool{clause: true}
+	for _, k := range conditionKeys {
+		seen[k] = true
+		edit(k, k, kindVariable, "context key", "")
+	}
+	for _, k := range contextKeyRe.FindAllString(d.text, -1) {
+		k = strings.TrimRight(k, ".")
+		if !seen[k] {
+			seen[k] = true
+			edit(k, k, kindVariable, "context key", "")
+		}
+	}
+}
+
+func sortedNodes(g *model.Graph) []*model.Node {
+	if g == nil {
+		return nil
+	}
+	out := make([]*model.Node, 0, len(g.Nodes))
+	for _, n := range g.Nodes {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Order < out[j].Order })
+	return out
+}
+
+func handlerType(n *model.Node) string {
+	if t := n.TypeOverride(); t != "" {
+		return t
+	}
+	for _, st := range shapeTypes {
+		if st.Shape == n.Shape() {
+			return st.Type
+		}
+	}
+	return "codergen"
+}
+
+func nodeDetail(n *model.Node) string {
+	if l := n.Attr("label", ""); l != "" {
+		return handlerType(n) + ": " + l
+	}
+	return handlerType(n)
+}
+
+func scopeNames(where int) string {
+	var parts []string
+	for _, s := range []struct {
+		bit  int
+		name string
+	}{{onGraph, "graph"}, {onNode, "node"}, {onEdge, "edge"}} {
+		if where&s.bit != 0 {
+			parts = append(parts, s.name)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b == '.' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
+
+// wordAt returns the identifier (dots allowed) around off and its start.
+func wordAt(text string, off int) (string, int) {
+	start, end := off, off
+	for start > 0 && isWordByte(text[start-1]) {
+		start--
+	}
+	for end < len(text) && isWordByte(text[end]) {
+		end++
+	}
+	return text[start:end], start
+}
+
+func (s *server) hover(d *document, off int) any {
+	word, start := wordAt(d.text, off)
+	if word == "" {
+		return nil
+	}
+	end := start + len(word)
+	rng := lsp.Range{Start: wireAt(d.text, start), End: wireAt(d.text, end)}
+	reply := func(md string) any {
+		return map[string]any{"contents": map[string]any{"kind": "markdown", "value": md}, "range": rng}
+	}
+	c := analyze(d.text, end)
+	if c.kind != atAttrKey && d.graph != nil {
+		if n := d.graph.Nodes[word]; n != nil {
+			return reply(nodeHover(n))
+		}
+	}
+	if a, ok := lookupAttr(word); ok && (c.kind == atAttrKey || c.kind == atNothing || c.kind == atStatement) {
+		return reply(attrHover(a))
+	}
+	return nil
+}
+
+func attrHover(a attrInfo) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** (%s attribute)\n\n%s", a.Name, scopeNames(a.Where), a.Doc)
+	if len(a.Values) > 0 {
+		b.WriteString("\n\nValues: `" + strings.Join(a.Values, "`, `") + "`")
+	}
+	return b.String()
+}
+
+func nodeHover(n *model.Node) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s** — `%s` (shape `%s`)", n.ID, handlerType(n), n.Shape())
+	if l := n.Attr("label", ""); l != "" {
+		fmt.Fprintf(&b, "\n\n%s", l)
+	}
+	if p, m := n.Attr("llm_provider", ""), n.Attr("llm_model", ""); p != "" || m != "" {
+		fmt.Fprintf(&b, "\n\nModel: `%s` `%s`", p, m)
+	}
+	if pr := n.Prompt(); pr != "" {
+		first, _, _ := strings.Cut(pr, "\n")
+		if len(first) > 200 {
+			first = first[:200] + "…"
+		}
+		fmt.Fprintf(&b, "\n\n> %s", first)
+	}
+	return b.String()
+}
+
+// definition resolves node references to the statement declaring the node
+// and prompt_file, import and stack.child_dotfile values to the file.
+func (s *server) definition(d *document, off int) any {
+	c := analyze(d.text, off)
+	if c.kind == atAttrValue && (c.key == "prompt_file" || c.key == "import" || c.key == "stack.child_dotfile") {
+		val := strings.TrimSpace(c.value + restOfValue(d.text[off:]))
+		if val == "" {
+			return nil
+		}
+		path := val
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(s.repoRoot(d), path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+		return []lsp.Location{{URI: lsp.URIFromPath(path)}}
+	}
+	word, _ := wordAt(d.text, off)
+	if word == "" || d.graph == nil {
+		return nil
+	}
+	n := d.graph.Nodes[word]
+	if n == nil || n.Span.IsZero() {
+		return nil
+	}
+	file := n.Span.File
+	if file == "" {
+		file = d.path
+	}
+	text := d.text
+	if file != d.path {
+		text = s.readText(file)
+	}
+	return []lsp.Location{{URI: lsp.URIFromPath(file), Range: wireRange(text, n.Span)}}
+}
+
+// format replaces the whole document with its canonical layout (see
+// dot.Format). A file that does not parse is left alone; its diagnostics
+// already say why.
+func (s *server) format(d *document) []map[string]any {
+	out, err := dot.Format([]byte(d.text))
+	if err != nil || string(out) == d.text {
+		return []map[string]any{}
+	}
+	return []map[string]any{{
+		"range":   lsp.Range{Start: wireAt(d.text, 0), End: wireAt(d.text, len(d.text))},
+		"newText": string(out),
+	}}
+}
+
+// restOfValue returns the rest of an attribute value after the cursor.
+func restOfValue(s string) string {
+	end := strings.IndexAny(s, "\",]\n")
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
@@ -0,0 +1,387 @@
+// Package lspserver is a Language Server Protocol server for Attractor DOT
+// pipelines. It speaks Content-Length framed JSON-RPC over stdio, publishes
+// the engine's parse and lint diagnostics as documents change, and answers
+// completion (attributes, handler types, node IDs, condition keys), hover
+// (attribute docs, node summaries) and go-to-definition (node references and
+// prompt_file paths) requests.
+package lspserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/validate"
+	"github.com/danshapiro/kilroy/internal/lsp"
+	"github.com/danshapiro/kilroy/internal/version"
+)
+
+// Options configures Serve.
+type Options struct {
+	// RootDir resolves prompt_file paths when the client sends no workspace
+	// root. Empty means the directory of each document.
+	RootDir string
+	// KnownTypes are the handler types offered for type= and checked by the
+	// type_known rule (engine.NewDefaultRegistry().KnownTypes()).
+	KnownTypes []string
+}
+
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *lsp.RPCError   `json:"error,omitempty"`
+}
+
+type document struct {
+	uri     string
+	path    string
+	text    string
+	version int
+	// graph is the last successful parse, kept while the text is mid-edit
+	// and does not parse so completion still knows the nodes.
+	graph *model.Graph
+}
+
+type server struct {
+	opts Options
+	root string
+
+	mu sync.Mutex // guards writes to w
+	w  io.Writer
+
+	docs map[string]*document
+}
+
+// Serve answers LSP requests framed on r, writing responses and
+// notifications to w, until r is closed or the client sends "exit".
+func Serve(r io.Reader, w io.Writer, opts Options) error {
+	s := &server{opts: opts, root: opts.RootDir, w: w, docs: map[string]*document{}}
+	br := bufio.NewReader(r)
+	for {
+		body, err := readMessage(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var msg rpcMessage
+		if json.Unmarshal(body, &msg) != nil || msg.Method == "" {
+			continue // malformed, or a reply to a request we never send
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rpcErr := s.handle(msg.Method, msg.Params)
+		if len(msg.ID) == 0 {
+			continue
+		}
+		resp := &rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr}
+		if rpcErr == nil {
+			if result == nil {
+				result = json.RawMessage("null")
+			}
+			resp.Result = result
+		}
+		if err := s.send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func readMessage(br *bufio.Reader) ([]byte, error) {
+	hdr, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(hdr.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", hdr.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *server) send(msg *rpcMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+func (s *server) notify(method string, params any) {
+	b, _ := json.Marshal(params)
+	_ = s.send(&rpcMessage{JSONRPC: "2.0", Method: method, Params: b})
+}
+
+type docParams struct {
+	TextDocument struct {
+		URI     string `json:"uri"`
+		Text    string `json:"text"`
+		Version int    `json:"version"`
+	} `json:"textDocument"`
+	Position       lsp.Position `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type initializeParams struct {
+	RootURI          string `json:"rootUri"`
+	RootPath         string `json:"rootPath"`
+	WorkspaceFolders []struct {
+		URI string `json:"uri"`
+	} `json:"workspaceFolders"`
+}
+
+func (s *server) handle(method string, raw json.RawMessage) (any, *lsp.RPCError) {
+	if method == "initialize" {
+		var p initializeParams
+		_ = json.Unmarshal(raw, &p)
+		switch {
+		case p.RootURI != "":
+			s.root = lsp.PathFromURI(p.RootURI)
+		case len(p.WorkspaceFolders) > 0:
+			s.root = lsp.PathFromURI(p.WorkspaceFolders[0].URI)
+		case p.RootPath != "":
+			s.root = p.RootPath
+		}
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{"openClose": true, "change": 1, "save": true},
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"[", ",", "=", ">", ".", "\"", " "},
+				},
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "kilroy-attractor", "version": version.Version},
+		}, nil
+	}
+
+	var p docParams
+	if err := json.Unmarshal(raw, &p); err != nil && len(raw) > 0 {
+		return nil, &lsp.RPCError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	uri := p.TextDocument.URI
+	switch method {
+	case "initialized", "shutdown", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "textDocument/didOpen":
+		s.setText(uri, p.TextDocument.Text, p.TextDocument.Version)
+		return nil, nil
+	case "textDocument/didChange":
+		// Full sync: the last change carries the whole text.
+		if len(p.ContentChanges) > 0 {
+			s.setText(uri, p.ContentChanges[len(p.ContentChanges)-1].Text, p.TextDocument.Version)
+		}
+		return nil, nil
+	case "textDocument/didSave":
+		// prompt_file targets may have changed on disk.
+		if d := s.docs[uri]; d != nil {
+			s.publish(d)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": []any{}})
+		return nil, nil
+	case "textDocument/completion":
+		d := s.docs[uri]
+		if d == nil {
+			return []any{}, nil
+		}
+		return s.complete(d, offsetAt(d.text, p.Position)), nil
+	case "textDocument/hover":
+		d := s.docs[uri]
+		if d == nil {
+			return nil, nil
+		}
+		return s.hover(d, offsetAt(d.text, p.Position)), nil
+	case "textDocument/definition":
+		d := s.docs[uri]
+		if d == nil {
+			return nil, nil
+		}
+		return s.definition(d, offsetAt(d.text, p.Position)), nil
+	case "textDocument/formatting":
+		d := s.docs[uri]
+		if d == nil {
+			return []any{}, nil
+		}
+		return s.format(d), nil
+	}
+	if strings.HasPrefix(method, "$/") {
+		return nil, nil
+	}
+	return nil, &lsp.RPCError{Code: codeMethodNotFound, Message: "method not supported: " + method}
+}
+
+func (s *server) setText(uri, text string, version int) {
+	d := s.docs[uri]
+	if d == nil {
+		d = &document{uri: uri, path: lsp.PathFromURI(uri)}
+		s.docs[uri] = d
+	}
+	d.text = text
+	d.version = version
+	s.publish(d)
+}
+
+// repoRoot is where prompt_file paths resolve: the workspace root, else the
+// document's directory.
+func (s *server) repoRoot(d *document) string {
+	if s.root != "" {
+		return s.root
+	}
+	return filepath.Dir(d.path)
+}
+
+func (s *server) publish(d *document) {
+	diags := s.diagnose(d)
+	out := make([]lsp.Diagnostic, 0, len(diags))
+	for _, vd := range diags {
+		if vd.Span.File != "" && vd.Span.File != d.path {
+			continue // reported against another file
+		}
+		code, _ := json.Marshal(vd.Rule)
+		msg := vd.Message
+		if vd.Fix != "" {
+			msg += " (fix: " + vd.Fix + ")"
+		}
+		out = append(out, lsp.Diagnostic{
+			Range:    wireRange(d.text, vd.Span),
+			Severity: wireSeverity(vd.Severity),
+			Code:     code,
+			Source:   "kilroy",
+			Message:  msg,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{"uri": d.uri, "version": d.version, "diagnostics": out})
+}
+
+// diagnose parses and prepares the document the way `attractor run` would,
+// so the editor shows the same errors the engine would stop on.
+func (s *server) diagnose(d *document) []validate.Diagnostic {
+	g, err := dot.ParseFile(d.path, []byte(d.text))
+	if err != nil {
+		return []validate.Diagnostic{validate.ParseErrorDiagnostic(err)}
+	}
+	d.graph = g
+	opts := engine.PrepareOptions{Filename: d.path, RepoPath: s.repoRoot(d), KnownTypes: s.opts.KnownTypes}
+	_, diags, err := engine.PrepareWithOptions([]byte(d.text), opts)
+	if err != nil && len(diags) == 0 {
+		// A transform (e.g. prompt_file loading) failed before lint ran.
+		// Lint without it so the rest of the file is still checked.
+		opts.RepoPath = ""
+		_, diags, _ = engine.PrepareWithOptions([]byte(d.text), opts)
+		diags = append([]validate.Diagnostic{{Rule: "prepare", Severity: validate.SeverityError, Message: err.Error(), Span: g.Span}}, diags...)
+	}
+	return diags
+}
+
+func wireSeverity(s validate.Severity) lsp.DiagnosticSeverity {
+	switch s {
+	case validate.SeverityError:
+		return 1
+	case validate.SeverityWarning:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// wirePosition converts a 1-based line and byte column to a zero-based line
+// and UTF-16 offset.
+func wirePosition(text string, p model.Position) lsp.Position {
+	if p.IsZero() {
+		return lsp.Position{}
+	}
+	line := lsp.LineText(text, p.Line-1)
+	b := min(max(p.Column-1, 0), len(line))
+	return lsp.Position{Line: p.Line - 1, Character: utf16Len(line[:b])}
+}
+
+func wireRange(text string, s model.Span) lsp.Range {
+	start := wirePosition(text, s.Start)
+	end := start
+	if !s.End.IsZero() {
+		end = wirePosition(text, s.End)
+	}
+	return lsp.Range{Start: start, End: end}
+}
+
+// wireAt converts a byte offset in text to a wire position.
+func wireAt(text string, off int) lsp.Position {
+	off = min(max(off, 0), len(text))
+	lineStart := strings.LastIndexByte(text[:off], '\n') + 1
+	return lsp.Position{Line: strings.Count(text[:lineStart], "\n"), Character: utf16Len(text[lineStart:off])}
+}
+
+// offsetAt converts a wire position to a byte offset in text, clamping to
+// the end of the line.
+func offsetAt(text string, pos lsp.Position) int {
+	off := 0
+	for i := 0; i < pos.Line; i++ {
+		nl := strings.IndexByte(text[off:], '\n')
+		if nl < 0 {
+			return len(text)
+		}
+		off += nl + 1
+	}
+	units := 0
+	for off < len(text) && text[off] != '\n' && units < pos.Character {
+		r, size := utf8.DecodeRuneInString(text[off:])
+		units += len(utf16.Encode([]rune{r}))
+		off += size
+	}
+	return off
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// readText returns the open document's text for path, else the file on disk.
+func (s *server) readText(path string) string {
+	for _, d := range s.docs {
+		if d.path == path {
+			return d.text
+		}
+	}
+	b, _ := os.ReadFile(path)
+	return string(b)
+}
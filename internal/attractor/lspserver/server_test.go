@@ -0,0 +1,307 @@
+package lspserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/engine"
+	"github.com/danshapiro/kilroy/internal/lsp"
+)
+
+type testClient struct {
+	t    *testing.T
+	w    io.WriteCloser
+	msgs chan rpcMessage
+	next int
+	done chan error
+}
+
+func startServer(t *testing.T, root string) *testClient {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &testClient{t: t, w: inW, msgs: make(chan rpcMessage, 64), done: make(chan error, 1)}
+	go func() {
+		c.done <- Serve(inR, outW, Options{KnownTypes: engine.NewDefaultRegistry().KnownTypes()})
+		_ = outW.Close()
+	}()
+	go func() {
+		br := bufio.NewReader(outR)
+		for {
+			body, err := readMessage(br)
+			if err != nil {
+				close(c.msgs)
+				return
+			}
+			var m rpcMessage
+			_ = json.Unmarshal(body, &m)
+			c.msgs <- m
+		}
+	}()
+	t.Cleanup(func() { _ = inW.Close() })
+	c.call("initialize", map[string]any{"rootUri": lsp.URIFromPath(root)})
+	c.notify("initialized", map[string]any{})
+	return c
+}
+
+func (c *testClient) write(m map[string]any) {
+	m["jsonrpc"] = "2.0"
+	b, _ := json.Marshal(m)
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+func (c *testClient) notify(method string, params any) {
+	c.write(map[string]any{"method": method, "params": params})
+}
+
+// call sends a request and returns its raw result.
+func (c *testClient) call(method string, params any) json.RawMessage {
+	c.t.Helper()
+	c.next++
+	c.write(map[string]any{"id": c.next, "method": method, "params": params})
+	for {
+		m := c.recv()
+		if string(m.ID) == fmt.Sprint(c.next) {
+			if m.Error != nil {
+				c.t.Fatalf("%s: %v", method, m.Error.Message)
+			}
+			b, _ := json.Marshal(m.Result)
+			return b
+		}
+	}
+}
+
+func (c *testClient) recv() rpcMessage {
+	c.t.Helper()
+	select {
+	case m, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("server closed the stream")
+		}
+		return m
+	case <-time.After(10 * time.Second):
+		c.t.Fatal("timed out waiting for the server")
+	}
+	return rpcMessage{}
+}
+
+func (c *testClient) diagnostics() []lsp.Diagnostic {
+	c.t.Helper()
+	for {
+		m := c.recv()
+		if m.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var p struct {
+			Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+		}
+		_ = json.Unmarshal(m.Params, &p)
+		return p.Diagnostics
+	}
+}
+
+func posOf(t *testing.T, text, marker string) lsp.Position {
+	t.Helper()
+	off := strings.Index(text, marker)
+	if off < 0 {
+		t.Fatalf("marker %q not found", marker)
+	}
+	return wireAt(text, off+len(marker))
+}
+
+func labels(t *testing.T, raw json.RawMessage) []string {
+	t.Helper()
+	var items []completionItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		t.Fatalf("completion result %s: %v", raw, err)
+	}
+	var out []string
+	for _, it := range items {
+		out = append(out, it.Label)
+	}
+	return out
+}
+
+func hasAll(got []string, want ...string) bool {
+	set := map[string]bool{}
+	for _, g := range got {
+		set[g] = true
+	}
+	for _, w := range want {
+		if !set[w] {
+			return false
+		}
+	}
+	return true
+}
+
+const pipeline = `digraph G {
+  graph [goal="ship", max_node_visits=5]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  plan [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt_file="prompts/plan.md"]
+  impl [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="build it", retry_target=plan]
+  start -> plan -> impl
+  impl -> exit [condition="outcome=success"]
+  impl -> plan [condition="context.tests_failed=true"]
+  impl -> plan
+}
+`
+
+func TestServer_PublishesDiagnosticsAsYouType(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "prompts"), 0o755)
+	_ = os.WriteFile(filepath.Join(root, "prompts", "plan.md"), []byte("plan the work\n"), 0o644)
+	c := startServer(t, root)
+	uri := lsp.URIFromPath(filepath.Join(root, "p.dot"))
+
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "version": 1, "text": pipeline}})
+	if d := c.diagnostics(); len(d) != 0 {
+		t.Fatalf("clean pipeline: %+v", d)
+	}
+
+	bad := strings.Replace(pipeline, "retry_target=plan", "retry_target=plna, fidelity=bogus", 1)
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": bad}},
+	})
+	diags := c.diagnostics()
+	byCode := map[string]lsp.Diagnostic{}
+	for _, d := range diags {
+		var code string
+		_ = json.Unmarshal(d.Code, &code)
+		byCode[code] = d
+	}
+	rt, ok := byCode["retry_target_exists"]
+	if !ok || rt.Severity != 2 || rt.Range.Start != posOf(t, bad, `prompt="build it", `) {
+		t.Fatalf("retry_target diagnostic: %+v", diags)
+	}
+	if _, ok := byCode["fidelity_valid"]; !ok {
+		t.Fatalf("fidelity diagnostic missing: %+v", diags)
+	}
+
+	broken := strings.Replace(pipeline, "start -> plan", "start -- plan", 1)
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 3},
+		"contentChanges": []any{map[string]any{"text": broken}},
+	})
+	diags = c.diagnostics()
+	if len(diags) != 1 || diags[0].Severity != 1 || diags[0].Range.Start != wireAt(broken, strings.Index(broken, "-- plan")) {
+		t.Fatalf("syntax error diagnostic: %+v", diags)
+	}
+}
+
+func TestServer_CompletionHoverAndDefinition(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "prompts"), 0o755)
+	_ = os.WriteFile(filepath.Join(root, "prompts", "plan.md"), []byte("plan the work\n"), 0o644)
+	c := startServer(t, root)
+	uri := lsp.URIFromPath(filepath.Join(root, "p.dot"))
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "version": 1, "text": pipeline}})
+	c.diagnostics()
+
+	// Mid-edit text that no longer parses: completion still knows the nodes.
+	text := strings.Replace(pipeline, "  start -> plan -> impl\n", "  start -> plan -> impl\n  review [shape=box, ty]\n  impl -> \n", 1)
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"text": text}},
+	})
+	c.diagnostics()
+	at := func(marker string) map[string]any {
+		return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": posOf(t, text, marker)}
+	}
+
+	if got := labels(t, c.call("textDocument/completion", at("review [shape=box, ty"))); !hasAll(got, "type") || hasAll(got, "condition") {
+		t.Fatalf("attribute completion: %v", got)
+	}
+	if got := labels(t, c.call("textDocument/completion", at("impl -> "))); !hasAll(got, "start", "plan", "impl", "exit") {
+		t.Fatalf("edge target completion: %v", got)
+	}
+	if got := labels(t, c.call("textDocument/completion", at("retry_target=pl"))); len(got) != 1 || got[0] != "plan" {
+		t.Fatalf("retry_target completion: %v", got)
+	}
+	if got := labels(t, c.call("textDocument/completion", at(`impl -> exit [`))); !hasAll(got, "condition", "weight", "loop_restart") || hasAll(got, "prompt") {
+		t.Fatalf("edge attribute completion: %v", got)
+	}
+	if got := labels(t, c.call("textDocument/completion", at(`condition="context.`))); !hasAll(got, "context.failure_class", "context.tests_failed") {
+		t.Fatalf("context key completion: %v", got)
+	}
+	if got := labels(t, c.call("textDocument/completion", at(`condition="outcome=`))); !hasAll(got, "outcome=success", "outcome=fail") {
+		t.Fatalf("outcome completion: %v", got)
+	}
+
+	typeText := "digraph G {\n  a [type=\"wait.\n"
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 3},
+		"contentChanges": []any{map[string]any{"text": typeText}},
+	})
+	c.diagnostics()
+	pos := map[string]any{"textDocument": map[string]any{"uri": uri}, "position": posOf(t, typeText, `"wait.`)}
+	if got := labels(t, c.call("textDocument/completion", pos)); len(got) != 1 || got[0] != "wait.human" {
+		t.Fatalf("handler type completion: %v", got)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 4},
+		"contentChanges": []any{map[string]any{"text": pipeline}},
+	})
+	c.diagnostics()
+	at = func(marker string) map[string]any {
+		return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": posOf(t, pipeline, marker)}
+	}
+
+	var hover struct {
+		Contents struct {
+			Value string `json:"value"`
+		} `json:"contents"`
+	}
+	_ = json.Unmarshal(c.call("textDocument/hover", at("retry_ta")), &hover)
+	if !strings.Contains(hover.Contents.Value, "**retry_target**") {
+		t.Fatalf("attribute hover: %+v", hover)
+	}
+	_ = json.Unmarshal(c.call("textDocument/hover", at("start -> pl")), &hover)
+	if !strings.Contains(hover.Contents.Value, "**plan** — `codergen`") {
+		t.Fatalf("node hover: %+v", hover)
+	}
+
+	var locs []lsp.Location
+	_ = json.Unmarshal(c.call("textDocument/definition", at("retry_target=pl")), &locs)
+	if len(locs) != 1 || locs[0].Range.Start != (lsp.Position{Line: 4, Character: 2}) {
+		t.Fatalf("node definition: %+v", locs)
+	}
+	locs = nil
+	_ = json.Unmarshal(c.call("textDocument/definition", at(`prompt_file="prompts/pl`)), &locs)
+	if len(locs) != 1 || locs[0].Path() != filepath.Join(root, "prompts", "plan.md") {
+		t.Fatalf("prompt_file definition: %+v", locs)
+	}
+
+	var edits []struct {
+		Range   lsp.Range `json:"range"`
+		NewText string    `json:"newText"`
+	}
+	_ = json.Unmarshal(c.call("textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}}), &edits)
+	if want, err := dot.Format([]byte(pipeline)); err != nil || len(edits) != 1 || edits[0].NewText != string(want) || edits[0].Range.Start != (lsp.Position{}) {
+		t.Fatalf("formatting: %+v (err=%v)", edits, err)
+	}
+
+	c.call("shutdown", nil)
+	c.notify("exit", nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not exit")
+	}
+}
@@ -12,6 +12,10 @@ type Graph struct {
 	Name  string
 	Attrs map[string]string
 
+	// Span covers the digraph statement; AttrSpans locates each graph attr.
+	Span      Span
+	AttrSpans map[string]Span
+
 	Nodes map[string]*Node
 	Edges []*Edge // declaration order (expanded for chained edges)
 
@@ -21,12 +25,13 @@ type Graph struct {
 
 func NewGraph(name string) *Graph {
 	return &Graph{
-		Name:     name,
-		Attrs:    map[string]string{},
-		Nodes:    map[string]*Node{},
-		Edges:    []*Edge{},
-		outgoing: map[string][]*Edge{},
-		incoming: map[string][]*Edge{},
+		Name:      name,
+		Attrs:     map[string]string{},
+		AttrSpans: map[string]Span{},
+		Nodes:     map[string]*Node{},
+		Edges:     []*Edge{},
+		outgoing:  map[string][]*Edge{},
+		incoming:  map[string][]*Edge{},
 	}
 }
 
@@ -44,6 +49,19 @@ func (g *Graph) AddNode(n *Node) error {
 		for k, v := range n.Attrs {
 			existing.Attrs[k] = v
 		}
+		if len(n.AttrSpans) > 0 && existing.AttrSpans == nil {
+			existing.AttrSpans = map[string]Span{}
+		}
+		for k, v := range n.AttrSpans {
+			existing.AttrSpans[k] = v
+		}
+		if existing.Span.IsZero() {
+			existing.Span = n.Span
+			existing.AttrList = n.AttrList
+		} else if !n.Span.IsZero() {
+			existing.LastSpan = n.Span
+			existing.LastAttrList = n.AttrList
+		}
 		existing.Classes = mergeClasses(existing.Classes, n.Classes)
 		return nil
 	}
@@ -88,12 +106,24 @@ type Node struct {
 	Attrs   map[string]string
 	Classes []string
 	Order   int // first-seen declaration order (stable)
+
+	// Span is the first statement declaring the node; AttrSpans locates the
+	// statement (or node default) that set each attr. AttrList is the
+	// "[...]" list of that statement, zero when it has none. LastSpan and
+	// LastAttrList are the same for the last statement redeclaring the
+	// node, zero when it is declared once.
+	Span         Span
+	AttrSpans    map[string]Span
+	AttrList     Span
+	LastSpan     Span
+	LastAttrList Span
 }
 
 func NewNode(id string) *Node {
 	return &Node{
-		ID:    id,
-		Attrs: map[string]string{},
+		ID:        id,
+		Attrs:     map[string]string{},
+		AttrSpans: map[string]Span{},
 	}
 }
 
@@ -156,14 +186,22 @@ type Edge struct {
 	To    string
 	Attrs map[string]string
 	Order int // declaration order (stable)
+
+	// Span covers "from -> to" in the edge statement; AttrSpans locates each
+	// attr. AttrList is the statement's "[...]" list, shared by every edge of
+	// a chain (a -> b -> c [...]); zero when it has none.
+	Span      Span
+	AttrSpans map[string]Span
+	AttrList  Span
 }
 
 func NewEdge(from, to string) *Edge {
 	return &Edge{
-		From:  from,
-		To:    to,
-		Attrs: map[string]string{},
-		Order: -1,
+		From:      from,
+		To:        to,
+		Attrs:     map[string]string{},
+		AttrSpans: map[string]Span{},
+		Order:     -1,
 	}
 }
 
@@ -203,4 +241,3 @@ func dedupeStable(in []string) []string {
 	}
 	return out
 }
-
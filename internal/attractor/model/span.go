@@ -0,0 +1,67 @@
+package model
+
+import "fmt"
+
+// Position is a 1-based line and column in a DOT source file. Columns count
+// bytes, matching what editors and CI annotators expect for ASCII sources.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) IsZero() bool { return p.Line == 0 }
+
+// Span is the source range a graph element or attribute was declared at.
+// End is exclusive. The zero Span means "unknown" (e.g. graphs built in code).
+type Span struct {
+	File  string   `json:"file,omitempty"`
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+func (s Span) IsZero() bool { return s.Start.IsZero() }
+
+// String renders the span start as file:line:col (or line:col without a file).
+func (s Span) String() string {
+	if s.IsZero() {
+		return s.File
+	}
+	if s.File == "" {
+		return fmt.Sprintf("%d:%d", s.Start.Line, s.Start.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Start.Line, s.Start.Column)
+}
+
+// AttrSpan returns where key was set on the graph, falling back to the graph span.
+func (g *Graph) AttrSpan(key string) Span {
+	if g == nil {
+		return Span{}
+	}
+	if s, ok := g.AttrSpans[key]; ok {
+		return s
+	}
+	return g.Span
+}
+
+// AttrSpan returns where key was set for the node (possibly a node default),
+// falling back to the node statement.
+func (n *Node) AttrSpan(key string) Span {
+	if n == nil {
+		return Span{}
+	}
+	if s, ok := n.AttrSpans[key]; ok {
+		return s
+	}
+	return n.Span
+}
+
+// AttrSpan returns where key was set for the edge, falling back to the edge.
+func (e *Edge) AttrSpan(key string) Span {
+	if e == nil {
+		return Span{}
+	}
+	if s, ok := e.AttrSpans[key]; ok {
+		return s
+	}
+	return e.Span
+}
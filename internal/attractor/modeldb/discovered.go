@@ -0,0 +1,89 @@
+package modeldb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/modelmeta"
+)
+
+// DiscoveredModel is model metadata reported by a live provider endpoint (for
+// example a local Ollama server) instead of the OpenRouter snapshot.
+type DiscoveredModel struct {
+	Provider          string `json:"provider"`
+	ID                string `json:"id"`
+	ContextWindow     int    `json:"context_window,omitempty"`
+	SupportsTools     bool   `json:"supports_tools,omitempty"`
+	SupportsVision    bool   `json:"supports_vision,omitempty"`
+	SupportsReasoning bool   `json:"supports_reasoning,omitempty"`
+}
+
+// MergeDiscoveredModels adds discovered models to the catalog under
+// "<provider>/<id>" keys and marks their providers as covered. Existing
+// entries are replaced: the live endpoint is authoritative for what it serves.
+// It returns the number of models merged.
+func MergeDiscoveredModels(c *Catalog, models []DiscoveredModel) int {
+	if c == nil {
+		return 0
+	}
+	if c.Models == nil {
+		c.Models = map[string]ModelEntry{}
+	}
+	if c.CoveredProviders == nil {
+		c.CoveredProviders = map[string]bool{}
+	}
+	n := 0
+	for _, m := range models {
+		provider := modelmeta.NormalizeProvider(m.Provider)
+		id := strings.TrimSpace(m.ID)
+		if provider == "" || id == "" {
+			continue
+		}
+		zero := 0.0
+		c.Models[provider+"/"+id] = ModelEntry{
+			Provider:           provider,
+			Mode:               "chat",
+			ContextWindow:      m.ContextWindow,
+			SupportsTools:      m.SupportsTools,
+			SupportsVision:     m.SupportsVision,
+			SupportsReasoning:  m.SupportsReasoning,
+			InputCostPerToken:  &zero,
+			OutputCostPerToken: &zero,
+		}
+		c.CoveredProviders[provider] = true
+		n++
+	}
+	return n
+}
+
+// WriteDiscoveredModelsSnapshot persists discovered models so resumed runs use
+// the same catalog as the original run.
+func WriteDiscoveredModelsSnapshot(path string, models []DiscoveredModel) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(models, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// LoadDiscoveredModelsSnapshot reads a snapshot written by
+// WriteDiscoveredModelsSnapshot. A missing file yields no models and no error.
+func LoadDiscoveredModelsSnapshot(path string) ([]DiscoveredModel, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var models []DiscoveredModel
+	if err := json.Unmarshal(b, &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
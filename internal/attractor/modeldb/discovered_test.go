@@ -0,0 +1,48 @@
+package modeldb
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeDiscoveredModels_CoversProviderAndResolvesRelativeIDs(t *testing.T) {
+	c := &Catalog{Models: map[string]ModelEntry{}}
+	n := MergeDiscoveredModels(c, []DiscoveredModel{
+		{Provider: "ollama", ID: "qwen3:8b", ContextWindow: 40960, SupportsTools: true},
+		{Provider: "ollama", ID: "hf.co/org/model:Q4_K_M"},
+		{Provider: "", ID: "ignored"},
+	})
+	if n != 2 {
+		t.Fatalf("merged: got %d want 2", n)
+	}
+	if !CatalogCoversProvider(c, "ollama") {
+		t.Fatalf("expected ollama to be covered")
+	}
+	if !CatalogHasProviderModel(c, "ollama", "qwen3:8b") {
+		t.Fatalf("expected qwen3:8b to resolve")
+	}
+	if !CatalogHasProviderModel(c, "ollama", "hf.co/org/model:Q4_K_M") {
+		t.Fatalf("expected slash-containing model name to resolve")
+	}
+	if got := c.Models["ollama/qwen3:8b"].ContextWindow; got != 40960 {
+		t.Fatalf("context window: got %d want 40960", got)
+	}
+}
+
+func TestDiscoveredModelsSnapshot_RoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "modeldb", "discovered_models.json")
+	if got, err := LoadDiscoveredModelsSnapshot(p); err != nil || got != nil {
+		t.Fatalf("missing snapshot: got %v, %v", got, err)
+	}
+	in := []DiscoveredModel{{Provider: "ollama", ID: "llama3.2", ContextWindow: 131072}}
+	if err := WriteDiscoveredModelsSnapshot(p, in); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	out, err := LoadDiscoveredModelsSnapshot(p)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(out) != 1 || out[0] != in[0] {
+		t.Fatalf("round trip: got %+v want %+v", out, in)
+	}
+}
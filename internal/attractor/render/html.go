@@ -0,0 +1,90 @@
+package render
+
+import (
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runstate"
+)
+
+// HTML writes a self-contained report page: the SVG drawing, the goal, and a
+// table of stages. With a run it adds the run's outcome and, per stage, its
+// status, visits, retries, time and failure reason.
+func HTML(w io.Writer, g *model.Graph, run *runstate.History) error {
+	var svg strings.Builder
+	if err := newDrawing(g, run).svg(&svg); err != nil {
+		return err
+	}
+	type row struct {
+		ID, Type, Label, Model       string
+		Ran, Running                 bool
+		Status, Color, FailureReason string
+		Visits, Retries              int
+		Duration                     string
+	}
+	page := struct {
+		Title, Goal string
+		Run         *runstate.History
+		SVG         template.HTML
+		Rows        []row
+	}{
+		Title: graphTitle(g),
+		Goal:  g.Attrs["goal"],
+		Run:   run,
+		SVG:   template.HTML(svg.String()),
+	}
+	for _, id := range nodeIDs(g) {
+		n := g.Nodes[id]
+		r := row{ID: id, Type: handlerType(n), Label: n.Label(), Model: n.Attr("llm_model", "")}
+		if run != nil {
+			if h := run.Nodes[id]; h != nil {
+				r.Ran, r.Running = true, h.Running
+				r.Status, r.Color, r.FailureReason = h.Status, statusColor(h), h.FailureReason
+				r.Visits, r.Retries = h.Visits, h.Retries()
+				if h.Duration > 0 {
+					r.Duration = formatDuration(h.Duration)
+				}
+			}
+		}
+		page.Rows = append(page.Rows, r)
+	}
+	return htmlPage.Execute(w, page)
+}
+
+var htmlPage = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font: 14px/1.5 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #111827; margin: 24px; }
+h1 { font-size: 20px; margin: 0 0 4px; }
+.goal { color: #4b5563; white-space: pre-wrap; margin: 0 0 12px; }
+.run { margin: 0 0 16px; }
+.state { font-weight: 600; }
+.state-success { color: #16a34a; } .state-fail { color: #dc2626; } .state-running { color: #2563eb; }
+.graph { overflow-x: auto; border: 1px solid #e5e7eb; border-radius: 6px; margin: 0 0 16px; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 4px 12px 4px 0; border-bottom: 1px solid #f3f4f6; vertical-align: top; }
+th { font-weight: 600; color: #4b5563; }
+td.num { text-align: right; }
+.muted { color: #9ca3af; }
+.reason { white-space: pre-wrap; max-width: 48em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{with .Goal}}<p class="goal">{{.}}</p>{{end}}
+{{with .Run}}<p class="run">Run {{with .RunID}}<code>{{.}}</code> {{end}}<span class="state state-{{.State}}">{{.State}}</span>{{with .CurrentNodeID}} at <code>{{.}}</code>{{end}}{{with .FailureReason}}: {{.}}{{end}}</p>{{end}}
+<div class="graph">{{.SVG}}</div>
+<table>
+<tr><th>Stage</th><th>Type</th><th>Model</th>{{if .Run}}<th>Status</th><th>Visits</th><th>Retries</th><th>Time</th><th>Failure</th>{{end}}</tr>
+{{- $run := .Run}}
+{{range .Rows}}<tr{{if and $run (not .Ran)}} class="muted"{{end}}><td><code>{{.ID}}</code>{{if ne .Label .ID}} {{.Label}}{{end}}</td><td>{{.Type}}</td><td>{{.Model}}</td>
+{{- if $run}}{{if .Ran}}<td style="color: {{.Color}}">{{if .Running}}running{{else}}{{.Status}}{{end}}</td><td class="num">{{.Visits}}</td><td class="num">{{.Retries}}</td><td class="num">{{.Duration}}</td><td class="reason">{{.FailureReason}}</td>{{else}}<td>not run</td><td></td><td></td><td></td><td></td>{{end}}{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
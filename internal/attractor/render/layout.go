@@ -0,0 +1,455 @@
+package render
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// Layout spacing, in SVG user units (px).
+const (
+	nodeSep = 28.0 // between neighbours in a layer
+	rankSep = 56.0 // between layers
+	margin  = 24.0
+	loopOut = 32.0 // how far a self-loop bulges out of its node
+)
+
+type point struct{ X, Y float64 }
+
+// vertex is a node, or a dummy point on an edge that spans several layers.
+type vertex struct {
+	id    string
+	node  *model.Node // nil for dummies
+	layer int
+	pos   int // index within the layer
+	w, h  float64
+	x, y  float64 // centre
+}
+
+// route is how one edge is drawn: a polyline from source to target, or a
+// loop for an edge back to the same node.
+type route struct {
+	edge   *model.Edge
+	points []point
+	self   bool
+}
+
+// layout places a graph with the layered (Sugiyama) method: break cycles by
+// reversing back edges, assign layers by longest path, add dummy vertices to
+// edges that skip layers, order each layer to reduce crossings (barycenter
+// sweeps), then assign coordinates that pull nodes toward their neighbours.
+// Layers run top to bottom, or left to right when rankdir=LR.
+type layout struct {
+	lr       bool
+	vertices map[string]*vertex
+	layers   [][]*vertex
+	up, down map[*vertex][]*vertex // neighbours in the previous and next layer
+	routes   []*route
+
+	width, height float64
+}
+
+func newLayout(g *model.Graph, size func(*model.Node) (w, h float64)) *layout {
+	l := &layout{
+		lr:       strings.EqualFold(strings.TrimSpace(g.Attrs["rankdir"]), "LR"),
+		vertices: map[string]*vertex{},
+		up:       map[*vertex][]*vertex{},
+		down:     map[*vertex][]*vertex{},
+	}
+	ids := nodeIDs(g)
+	for _, id := range ids {
+		n := g.Nodes[id]
+		w, h := size(n)
+		l.vertices[id] = &vertex{id: id, node: n, w: w, h: h}
+	}
+
+	back, discovery := l.breakCycles(g, ids)
+	chains := l.assignLayers(g, back, discovery)
+	l.orderLayers()
+	l.assignCoordinates()
+	l.routeEdges(g, back, chains)
+	return l
+}
+
+// nodeIDs returns the node IDs in declaration order.
+func nodeIDs(g *model.Graph) []string {
+	ids := make([]string, 0, len(g.Nodes))
+	for id := range g.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := g.Nodes[ids[i]], g.Nodes[ids[j]]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.ID < b.ID
+	})
+	return ids
+}
+
+func (l *layout) drawable(e *model.Edge) bool {
+	return l.vertices[e.From] != nil && l.vertices[e.To] != nil
+}
+
+// breakCycles runs a depth-first search from the start nodes and marks the
+// edges that close a cycle (retry and loop-back edges). It also returns the
+// nodes in discovery order, the initial order within each layer.
+func (l *layout) breakCycles(g *model.Graph, ids []string) (map[int]bool, []string) {
+	out := map[string][]int{}
+	for i, e := range g.Edges {
+		if l.drawable(e) {
+			out[e.From] = append(out[e.From], i)
+		}
+	}
+	const (
+		unseen = iota
+		onStack
+		done
+	)
+	state := map[string]int{}
+	back := map[int]bool{}
+	var discovery []string
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = onStack
+		discovery = append(discovery, id)
+		for _, i := range out[id] {
+			switch to := g.Edges[i].To; state[to] {
+			case unseen:
+				visit(to)
+			case onStack:
+				back[i] = true
+			}
+		}
+		state[id] = done
+	}
+	roots := append([]string{}, ids...)
+	sort.SliceStable(roots, func(i, j int) bool {
+		return isStart(g.Nodes[roots[i]]) && !isStart(g.Nodes[roots[j]])
+	})
+	for _, id := range roots {
+		if state[id] == unseen {
+			visit(id)
+		}
+	}
+	return back, discovery
+}
+
+// assignLayers gives each node the length of the longest path to it, puts
+// exit nodes on the last layer, and threads dummy vertices through edges that
+// span more than one layer. It returns each edge's vertex chain in layer
+// order (target first for reversed edges).
+func (l *layout) assignLayers(g *model.Graph, back map[int]bool, discovery []string) map[int][]*vertex {
+	type arc struct {
+		from, to string
+		edge     int
+	}
+	var arcs []arc
+	indeg := map[string]int{}
+	succ := map[string][]arc{}
+	for i, e := range g.Edges {
+		if !l.drawable(e) || e.From == e.To {
+			continue
+		}
+		a := arc{from: e.From, to: e.To, edge: i}
+		if back[i] {
+			a.from, a.to = e.To, e.From
+		}
+		arcs = append(arcs, a)
+		succ[a.from] = append(succ[a.from], a)
+		indeg[a.to]++
+	}
+
+	layer := map[string]int{}
+	var queue []string
+	for _, id := range discovery {
+		if indeg[id] == 0 {
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, a := range succ[id] {
+			if layer[id]+1 > layer[a.to] {
+				layer[a.to] = layer[id] + 1
+			}
+			if indeg[a.to]--; indeg[a.to] == 0 {
+				queue = append(queue, a.to)
+			}
+		}
+	}
+	last := 0
+	for _, n := range layer {
+		last = max(last, n)
+	}
+	for _, id := range discovery {
+		if isExit(l.vertices[id].node) && len(succ[id]) == 0 {
+			layer[id] = last
+		}
+	}
+
+	l.layers = make([][]*vertex, last+1)
+	for _, id := range discovery {
+		v := l.vertices[id]
+		v.layer = layer[id]
+		l.layers[v.layer] = append(l.layers[v.layer], v)
+	}
+
+	chains := map[int][]*vertex{}
+	for _, a := range arcs {
+		from, to := l.vertices[a.from], l.vertices[a.to]
+		chain := []*vertex{from}
+		for li := from.layer + 1; li < to.layer; li++ {
+			d := &vertex{id: "\x00" + strconv.Itoa(a.edge) + "." + strconv.Itoa(li), layer: li, w: 8, h: 8}
+			l.layers[li] = append(l.layers[li], d)
+			chain = append(chain, d)
+		}
+		chain = append(chain, to)
+		for i := 0; i+1 < len(chain); i++ {
+			l.down[chain[i]] = append(l.down[chain[i]], chain[i+1])
+			l.up[chain[i+1]] = append(l.up[chain[i+1]], chain[i])
+		}
+		chains[a.edge] = chain
+	}
+	return chains
+}
+
+// orderLayers reorders each layer by the mean position of its neighbours,
+// sweeping down and up, and keeps the ordering with the fewest crossings.
+func (l *layout) orderLayers() {
+	l.numberLayers()
+	best, bestCross := l.snapshotOrder(), l.crossings()
+	for iter := 0; iter < 24 && bestCross > 0; iter++ {
+		if iter%2 == 0 {
+			for li := 1; li < len(l.layers); li++ {
+				l.sortByBarycenter(l.layers[li], l.up)
+			}
+		} else {
+			for li := len(l.layers) - 2; li >= 0; li-- {
+				l.sortByBarycenter(l.layers[li], l.down)
+			}
+		}
+		if c := l.crossings(); c < bestCross {
+			best, bestCross = l.snapshotOrder(), c
+		}
+	}
+	l.layers = best
+	l.numberLayers()
+}
+
+func (l *layout) numberLayers() {
+	for _, layer := range l.layers {
+		for i, v := range layer {
+			v.pos = i
+		}
+	}
+}
+
+func (l *layout) snapshotOrder() [][]*vertex {
+	out := make([][]*vertex, len(l.layers))
+	for i, layer := range l.layers {
+		out[i] = append([]*vertex{}, layer...)
+	}
+	return out
+}
+
+func (l *layout) sortByBarycenter(layer []*vertex, adj map[*vertex][]*vertex) {
+	bary := map[*vertex]float64{}
+	for _, v := range layer {
+		bary[v] = float64(v.pos)
+		if ns := adj[v]; len(ns) > 0 {
+			sum := 0.0
+			for _, n := range ns {
+				sum += float64(n.pos)
+			}
+			bary[v] = sum / float64(len(ns))
+		}
+	}
+	sort.SliceStable(layer, func(i, j int) bool { return bary[layer[i]] < bary[layer[j]] })
+	for i, v := range layer {
+		v.pos = i
+	}
+}
+
+func (l *layout) crossings() int {
+	n := 0
+	for _, layer := range l.layers {
+		type seg struct{ a, b int }
+		var segs []seg
+		for _, v := range layer {
+			for _, w := range l.down[v] {
+				segs = append(segs, seg{v.pos, w.pos})
+			}
+		}
+		for i := range segs {
+			for j := i + 1; j < len(segs); j++ {
+				if (segs[i].a-segs[j].a)*(segs[i].b-segs[j].b) < 0 {
+					n++
+				}
+			}
+		}
+	}
+	return n
+}
+
+// breadth and depth are a vertex's extent across and along the layer axis.
+func (l *layout) breadth(v *vertex) float64 {
+	if l.lr {
+		return v.h
+	}
+	return v.w
+}
+
+func (l *layout) depth(v *vertex) float64 {
+	if l.lr {
+		return v.w
+	}
+	return v.h
+}
+
+func (l *layout) assignCoordinates() {
+	cross := map[*vertex]float64{}
+	place := func(layer []*vertex, want func(*vertex) float64) {
+		if len(layer) == 0 {
+			return
+		}
+		pos := make([]float64, len(layer))
+		drift := 0.0
+		for i, v := range layer {
+			pos[i] = want(v)
+			if i > 0 {
+				pos[i] = math.Max(pos[i], pos[i-1]+(l.breadth(layer[i-1])+l.breadth(v))/2+nodeSep)
+			}
+			drift += pos[i] - want(v)
+		}
+		// Shift the layer back so that, on average, nodes sit where their
+		// neighbours want them.
+		drift /= float64(len(layer))
+		for i, v := range layer {
+			cross[v] = pos[i] - drift
+		}
+	}
+	for _, layer := range l.layers {
+		place(layer, func(*vertex) float64 { return 0 })
+	}
+	for iter := 0; iter < 8; iter++ {
+		adj, order := l.up, l.layers
+		if iter%2 == 1 {
+			adj = l.down
+			order = make([][]*vertex, len(l.layers))
+			for i, layer := range l.layers {
+				order[len(l.layers)-1-i] = layer
+			}
+		}
+		for _, layer := range order {
+			place(layer, func(v *vertex) float64 {
+				ns := adj[v]
+				if len(ns) == 0 {
+					return cross[v]
+				}
+				sum := 0.0
+				for _, n := range ns {
+					sum += cross[n]
+				}
+				return sum / float64(len(ns))
+			})
+		}
+	}
+
+	minCross := math.Inf(1)
+	for v, c := range cross {
+		minCross = math.Min(minCross, c-l.breadth(v)/2)
+	}
+	rank := margin
+	for _, layer := range l.layers {
+		d := 0.0
+		for _, v := range layer {
+			d = math.Max(d, l.depth(v))
+		}
+		for _, v := range layer {
+			c := cross[v] - minCross + margin
+			if l.lr {
+				v.x, v.y = rank+d/2, c
+			} else {
+				v.x, v.y = c, rank+d/2
+			}
+		}
+		rank += d + rankSep
+	}
+	for _, v := range l.vertices {
+		l.width = math.Max(l.width, v.x+v.w/2+margin)
+		l.height = math.Max(l.height, v.y+v.h/2+margin)
+	}
+}
+
+func (l *layout) routeEdges(g *model.Graph, back map[int]bool, chains map[int][]*vertex) {
+	seen := map[[2]string]int{}
+	for i, e := range g.Edges {
+		if !l.drawable(e) {
+			continue
+		}
+		if e.From == e.To {
+			v := l.vertices[e.From]
+			l.routes = append(l.routes, &route{edge: e, self: true, points: []point{{v.x, v.y}}})
+			if l.lr {
+				l.height = math.Max(l.height, v.y+v.h/2+loopOut+margin)
+			} else {
+				l.width = math.Max(l.width, v.x+v.w/2+loopOut+margin)
+			}
+			continue
+		}
+		chain := chains[i]
+		pts := make([]point, len(chain))
+		for k, v := range chain {
+			pts[k] = point{v.x, v.y}
+		}
+		if back[i] {
+			for a, b := 0, len(pts)-1; a < b; a, b = a+1, b-1 {
+				pts[a], pts[b] = pts[b], pts[a]
+			}
+		}
+		// Bend edges that would overlap another edge between the same two
+		// nodes: back edges beside their forward twin, repeats beside the first.
+		pair := [2]string{e.From, e.To}
+		if e.To < e.From {
+			pair = [2]string{e.To, e.From}
+		}
+		bend := float64(seen[pair]) * 18
+		seen[pair]++
+		if back[i] && len(pts) == 2 {
+			bend += 24
+		}
+		if bend > 0 && len(pts) == 2 {
+			a, b := pts[0], pts[1]
+			dx, dy := b.X-a.X, b.Y-a.Y
+			n := math.Hypot(dx, dy)
+			mid := point{(a.X+b.X)/2 - dy/n*bend, (a.Y+b.Y)/2 + dx/n*bend}
+			pts = []point{a, mid, b}
+		}
+		from, to := l.vertices[e.From], l.vertices[e.To]
+		pts[0] = clip(from, pts[1])
+		pts[len(pts)-1] = clip(to, pts[len(pts)-2])
+		l.routes = append(l.routes, &route{edge: e, points: pts})
+	}
+}
+
+// clip moves from a vertex's centre to where the line toward p leaves its
+// bounding box.
+func clip(v *vertex, p point) point {
+	dx, dy := p.X-v.x, p.Y-v.y
+	if dx == 0 && dy == 0 {
+		return point{v.x, v.y}
+	}
+	t := math.Inf(1)
+	if dx != 0 {
+		t = math.Min(t, v.w/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		t = math.Min(t, v.h/2/math.Abs(dy))
+	}
+	return point{v.x + dx*t, v.y + dy*t}
+}
@@ -0,0 +1,185 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runstate"
+)
+
+// Mermaid writes the graph as a Mermaid flowchart, which GitHub and most
+// Markdown viewers render inline. Mermaid does its own layout; nodes get
+// Mermaid shapes and classes close to the SVG's, and run state is shown with
+// status classes and dark links for the path taken.
+func Mermaid(w io.Writer, g *model.Graph, run *runstate.History) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "---\ntitle: %s\n---\n", strconv.Quote(graphTitle(g)))
+	dir := "TD"
+	if strings.EqualFold(strings.TrimSpace(g.Attrs["rankdir"]), "LR") {
+		dir = "LR"
+	}
+	fmt.Fprintf(&b, "flowchart %s\n", dir)
+
+	ids := nodeIDs(g)
+	names := mermaidIDs(ids)
+	byType := map[string][]string{}
+	byStatus := map[string][]string{}
+	for _, id := range ids {
+		n := g.Nodes[id]
+		var lines []string
+		for _, l := range labelLines(n) {
+			lines = append(lines, mermaidText(l))
+		}
+		label := strings.Join(lines, "<br/>")
+		var hist *runstate.NodeHistory
+		if run != nil {
+			hist = run.Nodes[id]
+		}
+		if hist != nil {
+			label += "<br/><small>" + mermaidText(summary(hist)) + "</small>"
+		}
+		lb, rb := mermaidShape(n.Shape())
+		fmt.Fprintf(&b, "  %s%s\"%s\"%s\n", names[id], lb, label, rb)
+
+		t := handlerType(n)
+		byType[t] = append(byType[t], names[id])
+		switch {
+		case run == nil:
+		case hist == nil:
+			byStatus["unvisited"] = append(byStatus["unvisited"], names[id])
+		case hist.Running:
+			byStatus["running"] = append(byStatus["running"], names[id])
+		case hist.Status != "":
+			byStatus[hist.Status] = append(byStatus[hist.Status], names[id])
+		}
+	}
+
+	taken := map[[2]string]bool{}
+	if run != nil {
+		for _, hop := range run.Path {
+			taken[[2]string{hop.From, hop.To}] = true
+		}
+	}
+	var takenLinks []string
+	link := 0
+	for _, e := range g.Edges {
+		from, to := names[e.From], names[e.To]
+		if from == "" || to == "" {
+			continue
+		}
+		label := e.Label()
+		if label == "" {
+			label = e.Condition()
+		}
+		if label != "" {
+			fmt.Fprintf(&b, "  %s -->|\"%s\"| %s\n", from, mermaidText(label), to)
+		} else {
+			fmt.Fprintf(&b, "  %s --> %s\n", from, to)
+		}
+		if taken[[2]string{e.From, e.To}] {
+			takenLinks = append(takenLinks, strconv.Itoa(link))
+		}
+		link++
+	}
+
+	for _, t := range sortedKeys(byType) {
+		p := typeStyles[t]
+		if p == (palette{}) {
+			p = typeStyles["codergen"]
+		}
+		class := mermaidClass(t)
+		fmt.Fprintf(&b, "  classDef %s fill:%s,stroke:%s\n", class, p.fill, p.stroke)
+		fmt.Fprintf(&b, "  class %s %s\n", strings.Join(byType[t], ","), class)
+	}
+	if run != nil {
+		statusStyles := map[string]string{
+			"success":         "stroke:" + colorSuccess + ",stroke-width:3px",
+			"partial_success": "stroke:" + colorSuccess + ",stroke-width:3px",
+			"fail":            "fill:#fee2e2,stroke:" + colorFail + ",stroke-width:3px",
+			"retry":           "stroke:" + colorRetry + ",stroke-width:3px",
+			"running":         "stroke:" + colorRunning + ",stroke-width:3px,stroke-dasharray:5 3",
+			"unvisited":       "opacity:0.45",
+		}
+		for _, status := range sortedKeys(byStatus) {
+			style, ok := statusStyles[status]
+			if !ok {
+				style = "stroke:" + colorSkipped + ",stroke-width:3px"
+			}
+			class := "status_" + mermaidClass(status)
+			fmt.Fprintf(&b, "  classDef %s %s\n", class, style)
+			fmt.Fprintf(&b, "  class %s %s\n", strings.Join(byStatus[status], ","), class)
+		}
+		if len(takenLinks) > 0 {
+			fmt.Fprintf(&b, "  linkStyle %s stroke:%s,stroke-width:3px\n", strings.Join(takenLinks, ","), colorTaken)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidShape returns the brackets for a node's shape.
+func mermaidShape(shape string) (string, string) {
+	switch shape {
+	case "Mdiamond", "circle":
+		return "((", "))"
+	case "Msquare", "doublecircle":
+		return "(((", ")))"
+	case "diamond":
+		return "{", "}"
+	case "hexagon":
+		return "{{", "}}"
+	case "parallelogram":
+		return "[/", "/]"
+	case "component", "tripleoctagon":
+		return "[[", "]]"
+	case "house":
+		return "[/", "\\]"
+	default:
+		return "[", "]"
+	}
+}
+
+var nonMermaidID = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// mermaidIDs maps node IDs to Mermaid-safe ones: imported node IDs contain
+// dots, and "end" and a few other words are Mermaid keywords.
+func mermaidIDs(ids []string) map[string]string {
+	reserved := map[string]bool{"end": true, "graph": true, "subgraph": true, "flowchart": true, "style": true, "class": true, "classDef": true, "click": true, "linkStyle": true, "direction": true}
+	out := map[string]string{}
+	used := map[string]bool{}
+	for _, id := range ids {
+		name := nonMermaidID.ReplaceAllString(id, "_")
+		if reserved[name] {
+			name += "_"
+		}
+		for base, i := name, 2; used[name]; i++ {
+			name = base + "_" + strconv.Itoa(i)
+		}
+		used[name] = true
+		out[id] = name
+	}
+	return out
+}
+
+func mermaidClass(s string) string {
+	return nonMermaidID.ReplaceAllString(s, "_")
+}
+
+// mermaidText escapes text for a quoted Mermaid label, which may hold HTML.
+func mermaidText(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,212 @@
+// Package render draws Attractor pipelines as SVG, Mermaid or a standalone
+// HTML report, with no Graphviz install. Nodes are laid out in layers (see
+// layout) and styled by handler type. Given a run's history, it also shows
+// which nodes ran, how each ended, retries and durations, and the path the
+// run took.
+package render
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runstate"
+)
+
+// Format is an output format.
+type Format string
+
+const (
+	FormatSVG     Format = "svg"
+	FormatMermaid Format = "mermaid"
+	FormatHTML    Format = "html"
+)
+
+// Label font metrics, approximated so text fits without measuring glyphs.
+const (
+	fontSize     = 12.0
+	smallSize    = 10.0
+	charWidth    = 7.0 // average advance of fontSize text
+	smallWidth   = 5.8
+	lineHeight   = 16.0
+	maxLabelRune = 32 // longer label lines are cut with an ellipsis
+)
+
+// handlerType is the handler a node runs: its type attr, else the one its
+// shape selects.
+func handlerType(n *model.Node) string {
+	if t := strings.TrimSpace(n.TypeOverride()); t != "" {
+		return t
+	}
+	switch n.Shape() {
+	case "Mdiamond", "circle":
+		return "start"
+	case "Msquare", "doublecircle":
+		return "exit"
+	case "hexagon":
+		return "wait.human"
+	case "diamond":
+		return "conditional"
+	case "component":
+		return "parallel"
+	case "tripleoctagon":
+		return "parallel.fan_in"
+	case "parallelogram":
+		return "tool"
+	case "house":
+		return "stack.manager_loop"
+	default:
+		return "codergen"
+	}
+}
+
+func isStart(n *model.Node) bool { return n != nil && handlerType(n) == "start" }
+func isExit(n *model.Node) bool  { return n != nil && handlerType(n) == "exit" }
+
+type palette struct{ fill, stroke string }
+
+// typeStyles colours nodes by handler type.
+var typeStyles = map[string]palette{
+	"start":              {"#d1fae5", "#059669"},
+	"exit":               {"#e0e7ff", "#4f46e5"},
+	"codergen":           {"#eff6ff", "#3b82f6"},
+	"tool":               {"#fef3c7", "#d97706"},
+	"wait.human":         {"#fce7f3", "#db2777"},
+	"conditional":        {"#f3f4f6", "#6b7280"},
+	"parallel":           {"#ede9fe", "#7c3aed"},
+	"parallel.fan_in":    {"#ede9fe", "#7c3aed"},
+	"stack.manager_loop": {"#ecfeff", "#0891b2"},
+}
+
+func styleFor(n *model.Node) palette {
+	if p, ok := typeStyles[handlerType(n)]; ok {
+		return p
+	}
+	return typeStyles["codergen"]
+}
+
+// Run-state colours.
+const (
+	colorSuccess = "#16a34a"
+	colorFail    = "#dc2626"
+	colorRetry   = "#d97706"
+	colorRunning = "#2563eb"
+	colorSkipped = "#9ca3af"
+	colorEdge    = "#64748b"
+	colorTaken   = "#111827"
+	colorIdle    = "#cbd5e1"
+)
+
+// statusColor is the outline colour for a node's last outcome.
+func statusColor(h *runstate.NodeHistory) string {
+	switch {
+	case h.Running:
+		return colorRunning
+	case h.Status == "success" || h.Status == "partial_success":
+		return colorSuccess
+	case h.Status == "fail":
+		return colorFail
+	case h.Status == "retry":
+		return colorRetry
+	default:
+		return colorSkipped
+	}
+}
+
+// summary is the one-line run state shown under a node's label.
+func summary(h *runstate.NodeHistory) string {
+	status := h.Status
+	if h.Running {
+		status = "running"
+	}
+	parts := []string{status}
+	if h.Visits > 1 {
+		parts = append(parts, fmt.Sprintf("%d visits", h.Visits))
+	}
+	if r := h.Retries(); r > 0 {
+		parts = append(parts, fmt.Sprintf("%d %s", r, plural(r, "retry", "retries")))
+	}
+	if h.Duration > 0 {
+		parts = append(parts, formatDuration(h.Duration))
+	}
+	return strings.Join(parts, " · ")
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+// labelLines splits a node label on newlines and shortens long lines.
+func labelLines(n *model.Node) []string {
+	lines := strings.Split(strings.ReplaceAll(n.Label(), `\n`, "\n"), "\n")
+	for i, l := range lines {
+		if r := []rune(l); len(r) > maxLabelRune {
+			lines[i] = string(r[:maxLabelRune-1]) + "…"
+		}
+	}
+	return lines
+}
+
+// drawing is a laid-out graph with the run state to overlay, shared by the
+// SVG and HTML writers.
+type drawing struct {
+	g     *model.Graph
+	run   *runstate.History
+	l     *layout
+	taken map[[2]string]int // times each edge was followed
+}
+
+func newDrawing(g *model.Graph, run *runstate.History) *drawing {
+	d := &drawing{g: g, run: run, taken: map[[2]string]int{}}
+	if run != nil {
+		for _, hop := range run.Path {
+			d.taken[[2]string{hop.From, hop.To}]++
+		}
+	}
+	d.l = newLayout(g, d.nodeSize)
+	return d
+}
+
+// history returns what the run did at a node, or nil if it never ran there
+// (or there is no run).
+func (d *drawing) history(id string) *runstate.NodeHistory {
+	if d.run == nil {
+		return nil
+	}
+	return d.run.Nodes[id]
+}
+
+func (d *drawing) nodeSize(n *model.Node) (float64, float64) {
+	lines := labelLines(n)
+	w := 0.0
+	for _, l := range lines {
+		w = max(w, float64(len([]rune(l)))*charWidth)
+	}
+	h := float64(len(lines)) * lineHeight
+	if hist := d.history(n.ID); hist != nil {
+		w = max(w, float64(len([]rune(summary(hist))))*smallWidth)
+		h += lineHeight
+	}
+	w, h = max(w+28, 72), h+20
+	switch handlerType(n) {
+	case "conditional":
+		// Text must fit inside the diamond.
+		w, h = w*1.5, h*1.6
+	case "start", "exit":
+		w, h = max(w, h*1.6), h+8
+	case "wait.human", "tool", "stack.manager_loop":
+		w += 24
+	}
+	return w, h
+}
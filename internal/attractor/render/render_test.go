@@ -0,0 +1,197 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runstate"
+)
+
+const testGraph = `
+digraph review_loop {
+  graph [goal="Ship <it>", label="Review loop"]
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  plan [label="Plan the change"]
+  impl [label="Implement\nand test"]
+  check [shape=diamond]
+  ask [shape=hexagon, label="Approve \"this\"?"]
+  start -> plan -> impl -> check
+  check -> exit [condition="outcome=success"]
+  check -> impl [label="retry", condition="outcome=fail"]
+  check -> ask
+  ask -> plan
+  impl -> impl
+  plan -> exit
+}
+`
+
+func parseTestGraph(t *testing.T) *model.Graph {
+	t.Helper()
+	g, err := dot.Parse([]byte(testGraph))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return g
+}
+
+func testRun() *runstate.History {
+	return &runstate.History{
+		Snapshot: &runstate.Snapshot{RunID: "r1", State: runstate.StateFail, FailureReason: "gave up"},
+		Nodes: map[string]*runstate.NodeHistory{
+			"start": {Visits: 1, Attempts: 1, Status: "success"},
+			"plan":  {Visits: 1, Attempts: 1, Status: "success", Duration: 3 * time.Second},
+			"impl":  {Visits: 2, Attempts: 3, Status: "fail", FailureReason: "tests failed"},
+			"check": {Visits: 2, Attempts: 2, Status: "success"},
+		},
+		Path: []runstate.Hop{{From: "start", To: "plan"}, {From: "plan", To: "impl"}, {From: "impl", To: "check"}, {From: "check", To: "impl"}, {From: "impl", To: "check"}},
+	}
+}
+
+func TestLayout_LayersFollowFlowAndNodesDoNotOverlap(t *testing.T) {
+	for _, rankdir := range []string{"TB", "LR"} {
+		g := parseTestGraph(t)
+		g.Attrs["rankdir"] = rankdir
+		l := newLayout(g, newDrawing(g, nil).nodeSize)
+
+		v := l.vertices
+		if !(v["start"].layer < v["plan"].layer && v["plan"].layer < v["impl"].layer && v["impl"].layer < v["check"].layer) {
+			t.Fatalf("%s: layers start=%d plan=%d impl=%d check=%d", rankdir, v["start"].layer, v["plan"].layer, v["impl"].layer, v["check"].layer)
+		}
+		if v["exit"].layer != len(l.layers)-1 {
+			t.Fatalf("%s: exit on layer %d of %d", rankdir, v["exit"].layer, len(l.layers))
+		}
+		along := func(v *vertex) float64 { return v.y }
+		if rankdir == "LR" {
+			along = func(v *vertex) float64 { return v.x }
+		}
+		if !(along(v["start"]) < along(v["plan"]) && along(v["check"]) < along(v["exit"])) {
+			t.Fatalf("%s: flow does not run along the rank axis", rankdir)
+		}
+
+		for a, va := range v {
+			if va.x-va.w/2 < 0 || va.y-va.h/2 < 0 || va.x+va.w/2 > l.width || va.y+va.h/2 > l.height {
+				t.Fatalf("%s: %s lies outside the %vx%v canvas", rankdir, a, l.width, l.height)
+			}
+			for b, vb := range v {
+				if a < b && abs(va.x-vb.x) < (va.w+vb.w)/2 && abs(va.y-vb.y) < (va.h+vb.h)/2 {
+					t.Fatalf("%s: %s and %s overlap", rankdir, a, b)
+				}
+			}
+		}
+		if len(l.routes) != len(g.Edges) {
+			t.Fatalf("%s: %d routes for %d edges", rankdir, len(l.routes), len(g.Edges))
+		}
+	}
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+func TestSVG_StylesByTypeAndOverlaysRunState(t *testing.T) {
+	g := parseTestGraph(t)
+
+	var plain strings.Builder
+	if err := SVG(&plain, g, nil); err != nil {
+		t.Fatalf("SVG: %v", err)
+	}
+	for _, want := range []string{
+		`<title>Review loop</title>`,
+		`class="node type-start" id="node-start"`,
+		`class="node type-conditional" id="node-check"`,
+		`class="node type-wait-human" id="node-ask"`,
+		`<polygon points=`,
+		`>Implement</text>`,
+		`>Approve &#34;this&#34;?</text>`,
+		`>retry</text>`,
+	} {
+		if !strings.Contains(plain.String(), want) {
+			t.Fatalf("SVG missing %q:\n%s", want, plain.String())
+		}
+	}
+	if strings.Contains(plain.String(), "status-") || strings.Contains(plain.String(), `opacity="0.45"`) {
+		t.Fatal("SVG without a run shows run state")
+	}
+
+	var run strings.Builder
+	if err := SVG(&run, g, testRun()); err != nil {
+		t.Fatalf("SVG: %v", err)
+	}
+	out := run.String()
+	for _, want := range []string{
+		`class="node type-codergen status-fail" id="node-impl"`,
+		`fill="#fee2e2"`,
+		`fail · 2 visits · 1 retry`,
+		`success · 3s`,
+		`class="node type-exit" id="node-exit" opacity="0.45"`,
+		`>outcome=success</text>`, // no label: the condition is shown
+		`impl -&gt; check (taken 2×)`,
+		`stroke="` + colorTaken + `"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("SVG with run missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMermaid_EscapesLabelsAndMarksPathTaken(t *testing.T) {
+	g := parseTestGraph(t)
+	var b strings.Builder
+	if err := Mermaid(&b, g, testRun()); err != nil {
+		t.Fatalf("Mermaid: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"title: \"Review loop\"\n",
+		"flowchart TD\n",
+		`  start(("start<br/><small>success</small>"))`,
+		`  exit((("exit")))`,
+		`  check{"check<br/><small>success · 2 visits</small>"}`,
+		`  ask{{"Approve #quot;this#quot;?"}}`,
+		`  impl["Implement<br/>and test<br/><small>fail · 2 visits · 1 retry</small>"]`,
+		`  check -->|"retry"| impl`,
+		`  check -->|"outcome=success"| exit`,
+		"  class start start\n",
+		"  class impl status_fail\n",
+		"  linkStyle 0,1,2,4 stroke:" + colorTaken,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("Mermaid missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMermaidIDs_AvoidKeywordsAndCollisions(t *testing.T) {
+	got := mermaidIDs([]string{"end", "lib.build", "lib_build"})
+	if got["end"] != "end_" || got["lib.build"] != "lib_build" || got["lib_build"] != "lib_build_2" {
+		t.Fatalf("mermaidIDs=%v", got)
+	}
+}
+
+func TestHTML_ReportsRunAndStages(t *testing.T) {
+	g := parseTestGraph(t)
+	var b strings.Builder
+	if err := HTML(&b, g, testRun()); err != nil {
+		t.Fatalf("HTML: %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`<title>Review loop</title>`,
+		`<p class="goal">Ship &lt;it&gt;</p>`,
+		`<code>r1</code> <span class="state state-fail">fail</span>: gave up`,
+		`<svg xmlns=`,
+		`<td class="reason">tests failed</td>`,
+		`<tr class="muted"><td><code>exit</code></td>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("HTML missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package render
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runstate"
+)
+
+// SVG writes the graph as a standalone SVG image. run may be nil; otherwise
+// nodes are outlined by outcome, nodes the run never reached are faded, and
+// the edges it followed are drawn dark, with a count when taken repeatedly.
+func SVG(w io.Writer, g *model.Graph, run *runstate.History) error {
+	return newDrawing(g, run).svg(w)
+}
+
+func (d *drawing) svg(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s" font-family="-apple-system, 'Segoe UI', Helvetica, Arial, sans-serif" font-size="%s">`+"\n",
+		num(d.l.width), num(d.l.height), num(d.l.width), num(d.l.height), num(fontSize))
+	fmt.Fprintf(&b, "<title>%s</title>\n", esc(graphTitle(d.g)))
+	b.WriteString("<defs>\n")
+	for _, c := range []string{colorEdge, colorTaken, colorIdle} {
+		fmt.Fprintf(&b, `<marker id="%s" viewBox="0 0 10 10" refX="9" refY="5" markerWidth="7" markerHeight="7" orient="auto-start-reverse"><path d="M0,0 L10,5 L0,10 z" fill="%s"/></marker>`+"\n", markerID(c), c)
+	}
+	b.WriteString("</defs>\n")
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+
+	b.WriteString(`<g class="edges">` + "\n")
+	for _, r := range d.l.routes {
+		d.edge(&b, r)
+	}
+	b.WriteString("</g>\n")
+	b.WriteString(`<g class="nodes">` + "\n")
+	for _, id := range nodeIDs(d.g) {
+		d.node(&b, d.l.vertices[id])
+	}
+	b.WriteString("</g>\n</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func graphTitle(g *model.Graph) string {
+	if l := strings.TrimSpace(g.Attrs["label"]); l != "" {
+		return l
+	}
+	return g.Name
+}
+
+func markerID(color string) string {
+	return "arrow-" + strings.TrimPrefix(color, "#")
+}
+
+func (d *drawing) edge(b *strings.Builder, r *route) {
+	e := r.edge
+	color, width := colorEdge, 1.4
+	count := 0
+	if d.run != nil {
+		count = d.taken[[2]string{e.From, e.To}]
+		color, width = colorIdle, 1.2
+		if count > 0 {
+			color, width = colorTaken, 2.2
+		}
+	}
+	label := e.Label()
+	if label == "" {
+		label = e.Condition()
+	}
+	if count > 1 {
+		label = strings.TrimSpace(label + " ×" + strconv.Itoa(count))
+	}
+
+	var path string
+	var at point
+	if r.self {
+		v := d.l.vertices[e.From]
+		if d.l.lr {
+			y := v.y + v.h/2
+			path = fmt.Sprintf("M%s,%s C%s,%s %s,%s %s,%s", num(v.x-8), num(y), num(v.x-16), num(y+loopOut), num(v.x+16), num(y+loopOut), num(v.x+8), num(y))
+			at = point{v.x, y + loopOut}
+		} else {
+			x := v.x + v.w/2
+			path = fmt.Sprintf("M%s,%s C%s,%s %s,%s %s,%s", num(x), num(v.y-8), num(x+loopOut), num(v.y-16), num(x+loopOut), num(v.y+16), num(x), num(v.y+8))
+			at = point{x + loopOut, v.y}
+		}
+	} else {
+		path = smoothPath(r.points)
+		at = midpoint(r.points)
+	}
+	fmt.Fprintf(b, `<g class="edge" data-from="%s" data-to="%s"><title>%s</title>`, esc(e.From), esc(e.To), esc(edgeTitle(e, count)))
+	fmt.Fprintf(b, `<path d="%s" fill="none" stroke="%s" stroke-width="%s" marker-end="url(#%s)"/>`, path, color, num(width), markerID(color))
+	if label != "" {
+		lw := float64(len([]rune(label)))*smallWidth + 8
+		fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="14" rx="3" fill="#ffffff" fill-opacity="0.9"/>`, num(at.X-lw/2), num(at.Y-7), num(lw))
+		fmt.Fprintf(b, `<text x="%s" y="%s" text-anchor="middle" font-size="%s" fill="%s">%s</text>`, num(at.X), num(at.Y+3.5), num(smallSize), color, esc(label))
+	}
+	b.WriteString("</g>\n")
+}
+
+func edgeTitle(e *model.Edge, count int) string {
+	t := e.From + " -> " + e.To
+	if c := e.Condition(); c != "" {
+		t += " [" + c + "]"
+	}
+	if count > 0 {
+		t += fmt.Sprintf(" (taken %d×)", count)
+	}
+	return t
+}
+
+func (d *drawing) node(b *strings.Builder, v *vertex) {
+	n := v.node
+	p := styleFor(n)
+	stroke, strokeWidth, dash, opacity := p.stroke, 1.4, "", "1"
+	hist := d.history(n.ID)
+	if d.run != nil {
+		if hist == nil {
+			opacity = "0.45"
+		} else {
+			stroke, strokeWidth = statusColor(hist), 2.6
+			if hist.Running {
+				dash = ` stroke-dasharray="5 3"`
+			}
+		}
+	}
+	if hist != nil && hist.Status == "fail" {
+		p.fill = "#fee2e2"
+	}
+
+	class := "node type-" + strings.ReplaceAll(handlerType(n), ".", "-")
+	if hist != nil {
+		class += " status-" + hist.Status
+	}
+	fmt.Fprintf(b, `<g class="%s" id="node-%s" opacity="%s"><title>%s</title>`, esc(class), esc(n.ID), opacity, esc(d.nodeTitle(n, hist)))
+	outline := fmt.Sprintf(`stroke="%s" stroke-width="%s"%s`, stroke, num(strokeWidth), dash)
+	b.WriteString(shapeSVG(n.Shape(), v, p.fill, outline))
+
+	lines := labelLines(n)
+	total := float64(len(lines)) * lineHeight
+	if hist != nil {
+		total += lineHeight
+	}
+	y := v.y - total/2 + lineHeight*0.75
+	for _, l := range lines {
+		fmt.Fprintf(b, `<text x="%s" y="%s" text-anchor="middle" fill="#111827">%s</text>`, num(v.x), num(y), esc(l))
+		y += lineHeight
+	}
+	if hist != nil {
+		fmt.Fprintf(b, `<text x="%s" y="%s" text-anchor="middle" font-size="%s" fill="%s">%s</text>`, num(v.x), num(y), num(smallSize), statusColor(hist), esc(summary(hist)))
+	}
+	b.WriteString("</g>\n")
+}
+
+func (d *drawing) nodeTitle(n *model.Node, hist *runstate.NodeHistory) string {
+	t := n.ID + " (" + handlerType(n) + ")"
+	if m := n.Attr("llm_model", ""); m != "" {
+		t += "\nmodel: " + m
+	}
+	if hist != nil {
+		t += "\n" + summary(hist) + fmt.Sprintf("\nattempts: %d", hist.Attempts)
+		if hist.FailureReason != "" {
+			t += "\nfailure: " + hist.FailureReason
+		}
+	}
+	return t
+}
+
+// shapeSVG draws a node's Graphviz shape inside its box. Inner outlines
+// (Msquare, doublecircle, tripleoctagon) are drawn unfilled.
+func shapeSVG(shape string, v *vertex, fill, outline string) string {
+	attrs := `fill="` + fill + `" ` + outline
+	noFill := `fill="none" ` + outline
+	x0, y0, x1, y1 := v.x-v.w/2, v.y-v.h/2, v.x+v.w/2, v.y+v.h/2
+	poly := func(a string, pts ...float64) string {
+		s := make([]string, 0, len(pts)/2)
+		for i := 0; i+1 < len(pts); i += 2 {
+			s = append(s, num(pts[i])+","+num(pts[i+1]))
+		}
+		return fmt.Sprintf(`<polygon points="%s" %s/>`, strings.Join(s, " "), a)
+	}
+	rect := func(inset, rx float64, a string) string {
+		return fmt.Sprintf(`<rect x="%s" y="%s" width="%s" height="%s" rx="%s" %s/>`,
+			num(x0+inset), num(y0+inset), num(v.w-2*inset), num(v.h-2*inset), num(rx), a)
+	}
+	ellipse := func(inset float64, a string) string {
+		return fmt.Sprintf(`<ellipse cx="%s" cy="%s" rx="%s" ry="%s" %s/>`, num(v.x), num(v.y), num(v.w/2-inset), num(v.h/2-inset), a)
+	}
+	switch shape {
+	case "Mdiamond", "circle":
+		return ellipse(0, attrs)
+	case "doublecircle":
+		return ellipse(0, attrs) + ellipse(4, noFill)
+	case "Msquare":
+		return rect(0, 2, attrs) + rect(4, 1, noFill)
+	case "diamond":
+		return poly(attrs, v.x, y0, x1, v.y, v.x, y1, x0, v.y)
+	case "hexagon":
+		return poly(attrs, x0+12, y0, x1-12, y0, x1, v.y, x1-12, y1, x0+12, y1, x0, v.y)
+	case "parallelogram":
+		return poly(attrs, x0+12, y0, x1, y0, x1-12, y1, x0, y1)
+	case "house":
+		return poly(attrs, v.x, y0, x1, y0+v.h*0.35, x1, y1, x0, y1, x0, y0+v.h*0.35)
+	case "tripleoctagon":
+		c := math.Min(12, v.h/3)
+		oct := func(in float64, a string) string {
+			return poly(a, x0+c+in, y0+in, x1-c-in, y0+in, x1-in, y0+c+in, x1-in, y1-c-in,
+				x1-c-in, y1-in, x0+c+in, y1-in, x0+in, y1-c-in, x0+in, y0+c+in)
+		}
+		return oct(0, attrs) + oct(3, noFill) + oct(6, noFill)
+	case "component":
+		tab := func(y float64) string {
+			return fmt.Sprintf(`<rect x="%s" y="%s" width="10" height="6" %s/>`, num(x0-5), num(y), attrs)
+		}
+		return rect(0, 2, attrs) + tab(y0+v.h*0.25-3) + tab(y0+v.h*0.75-3)
+	default:
+		return rect(0, 6, attrs)
+	}
+}
+
+// smoothPath draws a polyline as a curve through its points (Catmull-Rom
+// converted to cubic Béziers).
+func smoothPath(pts []point) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "M%s,%s", num(pts[0].X), num(pts[0].Y))
+	if len(pts) == 2 {
+		fmt.Fprintf(&b, " L%s,%s", num(pts[1].X), num(pts[1].Y))
+		return b.String()
+	}
+	at := func(i int) point { return pts[max(0, min(len(pts)-1, i))] }
+	for i := 0; i+1 < len(pts); i++ {
+		p0, p1, p2, p3 := at(i-1), at(i), at(i+1), at(i+2)
+		c1 := point{p1.X + (p2.X-p0.X)/6, p1.Y + (p2.Y-p0.Y)/6}
+		c2 := point{p2.X - (p3.X-p1.X)/6, p2.Y - (p3.Y-p1.Y)/6}
+		fmt.Fprintf(&b, " C%s,%s %s,%s %s,%s", num(c1.X), num(c1.Y), num(c2.X), num(c2.Y), num(p2.X), num(p2.Y))
+	}
+	return b.String()
+}
+
+// midpoint is where an edge's label goes: its middle point, or the middle of
+// its middle segment.
+func midpoint(pts []point) point {
+	if len(pts)%2 == 1 {
+		return pts[len(pts)/2]
+	}
+	a, b := pts[len(pts)/2-1], pts[len(pts)/2]
+	return point{(a.X + b.X) / 2, (a.Y + b.Y) / 2}
+}
+
+func num(f float64) string {
+	return strconv.FormatFloat(math.Round(f*10)/10, 'f', -1, 64)
+}
+
+func esc(s string) string {
+	return html.EscapeString(s)
+}
@@ -0,0 +1,113 @@
+package runstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NodeHistory summarizes what a run did at one node.
+type NodeHistory struct {
+	Visits        int           `json:"visits"`   // times the node was entered
+	Attempts      int           `json:"attempts"` // attempts across all visits
+	Status        string        `json:"status,omitempty"`
+	FailureReason string        `json:"failure_reason,omitempty"`
+	Running       bool          `json:"running,omitempty"` // an attempt has started and not ended
+	Duration      time.Duration `json:"duration"`          // total time in finished attempts
+}
+
+// Retries is the number of attempts beyond the first of each visit.
+func (n *NodeHistory) Retries() int {
+	return n.Attempts - n.Visits
+}
+
+// Hop is one edge the run took.
+type Hop struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// History is the node-by-node record of a run, read from progress.ndjson.
+type History struct {
+	*Snapshot
+	Nodes map[string]*NodeHistory `json:"nodes"`
+	Path  []Hop                   `json:"path"`
+}
+
+// LoadHistory reads the run snapshot and replays progress.ndjson into
+// per-node visits, attempts, outcomes and durations, and the path taken.
+func LoadHistory(logsRoot string) (*History, error) {
+	s, err := LoadSnapshot(logsRoot)
+	if err != nil {
+		return nil, err
+	}
+	h := &History{Snapshot: s, Nodes: map[string]*NodeHistory{}, Path: []Hop{}}
+
+	f, err := os.Open(filepath.Join(s.LogsRoot, "progress.ndjson"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return h, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	started := map[string]time.Time{}
+	node := func(id string) *NodeHistory {
+		n := h.Nodes[id]
+		if n == nil {
+			n = &NodeHistory{}
+			h.Nodes[id] = n
+		}
+		return n
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var ev map[string]any
+		if json.Unmarshal([]byte(line), &ev) != nil {
+			// A torn last line from a live run; skip it.
+			continue
+		}
+		id := eventString(ev["node_id"])
+		switch eventString(ev["event"]) {
+		case "stage_attempt_start":
+			n := node(id)
+			n.Attempts++
+			if eventString(ev["attempt"]) == "1" {
+				n.Visits++
+			}
+			n.Running = true
+			started[id] = parseEventTime(ev["ts"])
+		case "stage_attempt_end":
+			n := node(id)
+			n.Status = eventString(ev["status"])
+			n.FailureReason = eventString(ev["failure_reason"])
+			n.Running = false
+			if start, end := started[id], parseEventTime(ev["ts"]); !start.IsZero() && end.After(start) {
+				n.Duration += end.Sub(start)
+			}
+			delete(started, id)
+		case "edge_selected":
+			h.Path = append(h.Path, Hop{From: eventString(ev["from_node"]), To: eventString(ev["to_node"])})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if s.State == StateSuccess || s.State == StateFail {
+		// A stage cut short by the end of the run is not still running.
+		for _, n := range h.Nodes {
+			n.Running = false
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,53 @@
+package runstate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadHistory_ReplaysVisitsRetriesDurationsAndPath(t *testing.T) {
+	root := t.TempDir()
+	progress := strings.Join([]string{
+		`{"event":"stage_attempt_start","node_id":"impl","attempt":1,"max":3,"ts":"2026-01-01T00:00:00Z"}`,
+		`{"event":"stage_attempt_end","node_id":"impl","attempt":1,"status":"fail","failure_reason":"tests failed","ts":"2026-01-01T00:00:02Z"}`,
+		`{"event":"stage_attempt_start","node_id":"impl","attempt":2,"max":3,"ts":"2026-01-01T00:00:03Z"}`,
+		`{"event":"stage_attempt_end","node_id":"impl","attempt":2,"status":"success","ts":"2026-01-01T00:00:04Z"}`,
+		`{"event":"edge_selected","from_node":"impl","to_node":"review"}`,
+		`{"event":"stage_attempt_start","node_id":"review","attempt":1,"max":1,"ts":"2026-01-01T00:00:05Z"}`,
+	}, "\n")
+	_ = os.WriteFile(filepath.Join(root, "progress.ndjson"), []byte(progress), 0o644)
+
+	h, err := LoadHistory(root)
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	impl := h.Nodes["impl"]
+	if impl == nil || impl.Visits != 1 || impl.Attempts != 2 || impl.Retries() != 1 {
+		t.Fatalf("impl=%+v want 1 visit, 2 attempts", impl)
+	}
+	if impl.Status != "success" || impl.FailureReason != "" || impl.Running {
+		t.Fatalf("impl=%+v want finished with success", impl)
+	}
+	if impl.Duration != 3*time.Second {
+		t.Fatalf("impl duration=%v want 3s", impl.Duration)
+	}
+	if review := h.Nodes["review"]; review == nil || !review.Running {
+		t.Fatalf("review=%+v want running", review)
+	}
+	if len(h.Path) != 1 || h.Path[0] != (Hop{From: "impl", To: "review"}) {
+		t.Fatalf("path=%+v", h.Path)
+	}
+
+	// Once the run has ended, nothing is still running.
+	_ = os.WriteFile(filepath.Join(root, "final.json"), []byte(`{"status":"fail","failure_reason":"killed"}`), 0o644)
+	h, err = LoadHistory(root)
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	if h.Nodes["review"].Running {
+		t.Fatal("review still running after final.json")
+	}
+}
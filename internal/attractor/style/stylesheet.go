@@ -2,6 +2,7 @@ package style
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/danshapiro/kilroy/internal/attractor/model"
@@ -14,14 +15,74 @@ const (
 	SelectorShape
 	SelectorClass
 	SelectorID
+	// SelectorCompound is any selector that is not a single simple one:
+	// attribute tests, :not(), or several parts such as box.code[type=tool].
+	SelectorCompound
+	// SelectorAttribute is an [attr], [attr=value] or [attr!=value] part.
+	SelectorAttribute
 )
 
 type Rule struct {
 	Kind        SelectorKind
-	Value       string // id/class/shape; empty for universal
-	Specificity int    // universal(0) < shape(1) < class(2) < id(3)
-	Order       int    // source order (0..n-1)
+	Value       string // id/class/shape; empty for universal and compound
+	Selector    string // selector source text, for diagnostics and explain
+	Parts       []SelectorPart
+	Specificity int // ids×10000 + (classes+attributes)×100 + shapes
+	Order       int // source order (0..n-1)
 	Decls       map[string]string
+	Important   map[string]bool // declarations marked !important
+}
+
+// SelectorPart is one simple selector within a (possibly compound) selector.
+// A node matches a rule when it matches every part.
+type SelectorPart struct {
+	Kind  SelectorKind // SelectorShape, SelectorClass, SelectorID or SelectorAttribute
+	Attr  string       // attribute tests only
+	Op    string       // attribute tests only: "" (present), "=" or "!="
+	Value string
+	Not   bool
+}
+
+// KnownProperties lists the node attributes a stylesheet may set.
+var KnownProperties = []string{
+	"llm_model",
+	"llm_provider",
+	"reasoning_effort",
+	"timeout",
+	"max_retries",
+	"fidelity",
+	"thread_id",
+	"allow_partial",
+	"retry_target",
+	"fallback_retry_target",
+	"retry.backoff.initial_delay_ms",
+	"retry.backoff.max_delay_ms",
+	"retry.backoff.backoff_factor",
+	"retry.backoff.jitter",
+	"escalation_models",
+	"retries_before_escalation",
+	"tool_hooks.pre",
+	"tool_hooks.post",
+}
+
+// graphDefaultProperties fall back to the graph attribute of the same name
+// when neither the node nor the stylesheet sets them.
+var graphDefaultProperties = map[string]bool{
+	"llm_model":        true,
+	"llm_provider":     true,
+	"reasoning_effort": true,
+	"tool_hooks.pre":   true,
+	"tool_hooks.post":  true,
+}
+
+// IsKnownProperty reports whether prop is one of KnownProperties.
+func IsKnownProperty(prop string) bool {
+	for _, k := range KnownProperties {
+		if k == prop {
+			return true
+		}
+	}
+	return false
 }
 
 func ParseStylesheet(src string) ([]Rule, error) {
@@ -29,6 +90,9 @@ func ParseStylesheet(src string) ([]Rule, error) {
 	return p.parse()
 }
 
+// ApplyStylesheet sets each node's stylesheet properties to the winner of
+// its cascade (see Cascade). Unknown properties are ignored; validate
+// reports them.
 func ApplyStylesheet(g *model.Graph, rules []Rule) error {
 	if g == nil {
 		return fmt.Errorf("graph is nil")
@@ -41,68 +105,159 @@ func ApplyStylesheet(g *model.Graph, rules []Rule) error {
 		if n == nil {
 			continue
 		}
-		applyToNode(g, n, rules)
+		// Resolve everything before writing so rules match the node's own
+		// attributes, not values an earlier property just filled in.
+		for prop, cands := range Cascade(g, n, rules) {
+			if len(cands) > 0 && cands[0].Source != SourceNode {
+				n.Attrs[prop] = cands[0].Value
+			}
+		}
 	}
 	return nil
 }
 
-func applyToNode(g *model.Graph, n *model.Node, rules []Rule) {
-	// Only set properties that are missing.
-	props := []string{"llm_model", "llm_provider", "reasoning_effort"}
-	for _, prop := range props {
-		if _, ok := n.Attrs[prop]; ok {
-			continue
+// Source says where a property value comes from.
+type Source int
+
+const (
+	SourceRule Source = iota
+	SourceNode
+	SourceGraph
+)
+
+// Candidate is one value competing for a node property.
+type Candidate struct {
+	Value     string
+	Source    Source
+	Rule      *Rule // SourceRule only
+	Important bool
+}
+
+// Cascade returns, for every known property that has at least one
+// candidate on n, the candidates in precedence order, winner first:
+// !important declarations, then the node's explicit attribute, then
+// ordinary declarations, then the graph default. Declarations of one kind
+// rank by specificity and then by later source order.
+func Cascade(g *model.Graph, n *model.Node, rules []Rule) map[string][]Candidate {
+	var matched []*Rule
+	for i := range rules {
+		if ruleMatchesNode(rules[i], n) {
+			matched = append(matched, &rules[i])
 		}
-		bestSpec := -1
-		bestOrder := -1
-		bestVal := ""
-		for _, r := range rules {
-			if !ruleMatchesNode(r, n) {
-				continue
-			}
-			v, ok := r.Decls[prop]
-			if !ok {
-				continue
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Specificity != matched[j].Specificity {
+			return matched[i].Specificity > matched[j].Specificity
+		}
+		return matched[i].Order > matched[j].Order
+	})
+
+	out := map[string][]Candidate{}
+	for _, prop := range KnownProperties {
+		var cands []Candidate
+		for _, important := range []bool{true, false} {
+			if !important {
+				if v, ok := n.Attrs[prop]; ok {
+					cands = append(cands, Candidate{Value: v, Source: SourceNode})
+				}
 			}
-			if r.Specificity > bestSpec || (r.Specificity == bestSpec && r.Order > bestOrder) {
-				bestSpec = r.Specificity
-				bestOrder = r.Order
-				bestVal = v
+			for _, r := range matched {
+				if v, ok := r.Decls[prop]; ok && r.Important[prop] == important {
+					cands = append(cands, Candidate{Value: v, Source: SourceRule, Rule: r, Important: important})
+				}
 			}
 		}
-		if bestSpec >= 0 {
-			n.Attrs[prop] = bestVal
-			continue
-		}
-		// Graph-level defaults (optional / best-effort).
-		if g != nil {
+		if graphDefaultProperties[prop] && g != nil {
 			if v, ok := g.Attrs[prop]; ok && strings.TrimSpace(v) != "" {
-				n.Attrs[prop] = v
+				cands = append(cands, Candidate{Value: v, Source: SourceGraph})
 			}
 		}
+		if len(cands) > 0 {
+			out[prop] = cands
+		}
 	}
+	return out
 }
 
 func ruleMatchesNode(r Rule, n *model.Node) bool {
-	switch r.Kind {
-	case SelectorUniversal:
-		return true
+	for _, part := range r.Parts {
+		if partMatchesNode(part, n) == part.Not {
+			return false
+		}
+	}
+	return true
+}
+
+func partMatchesNode(p SelectorPart, n *model.Node) bool {
+	switch p.Kind {
 	case SelectorID:
-		return n.ID == r.Value
+		return n.ID == p.Value
 	case SelectorClass:
 		for _, c := range n.ClassList() {
-			if c == r.Value {
+			if c == p.Value {
 				return true
 			}
 		}
 		return false
 	case SelectorShape:
-		return n.Shape() == r.Value
+		return n.Shape() == p.Value
+	case SelectorAttribute:
+		v := nodeAttr(n, p.Attr)
+		switch p.Op {
+		case "=":
+			return v == p.Value
+		case "!=":
+			return v != p.Value
+		default:
+			return v != ""
+		}
 	default:
 		return false
 	}
 }
 
+// nodeAttr is the value an attribute selector tests. shape and type are
+// effective values, so [type=tool] matches parallelograms without an
+// explicit type.
+func nodeAttr(n *model.Node, attr string) string {
+	switch attr {
+	case "id":
+		return n.ID
+	case "shape":
+		return n.Shape()
+	case "type":
+		return handlerType(n)
+	default:
+		return strings.TrimSpace(n.Attrs[attr])
+	}
+}
+
+func handlerType(n *model.Node) string {
+	if t := strings.TrimSpace(n.TypeOverride()); t != "" {
+		return t
+	}
+	switch n.Shape() {
+	case "Mdiamond", "circle":
+		return "start"
+	case "Msquare", "doublecircle":
+		return "exit"
+	case "hexagon":
+		return "wait.human"
+	case "diamond":
+		return "conditional"
+	case "component":
+		return "parallel"
+	case "tripleoctagon":
+		return "parallel.fan_in"
+	case "parallelogram":
+		return "tool"
+	case "house":
+		return "stack.manager_loop"
+	default:
+		return "codergen"
+	}
+}
+
 type ssParser struct {
 	s    string
 	i    int
@@ -127,66 +282,163 @@ func (p *ssParser) parse() ([]Rule, error) {
 }
 
 func (p *ssParser) parseRule() (Rule, error) {
-	kind, val, spec, err := p.parseSelector()
+	start := p.i
+	r, err := p.parseSelector()
 	if err != nil {
 		return Rule{}, err
 	}
+	r.Selector = strings.TrimSpace(p.s[start:p.i])
+	if r.Selector == "" {
+		return Rule{}, p.errf("expected selector")
+	}
 	p.skipSpace()
 	if !p.consume("{") {
 		return Rule{}, p.errf("expected '{' after selector")
 	}
-	decls := map[string]string{}
+	r.Decls = map[string]string{}
+	r.Important = map[string]bool{}
 	for {
 		p.skipSpace()
 		if p.consume("}") {
 			break
 		}
-		prop, err := p.parseIdent()
+		prop, err := p.parseProperty()
 		if err != nil {
 			return Rule{}, err
 		}
-		if prop != "llm_model" && prop != "llm_provider" && prop != "reasoning_effort" {
-			return Rule{}, p.errf("unknown property %q", prop)
-		}
 		p.skipSpace()
 		if !p.consume(":") {
 			return Rule{}, p.errf("expected ':' after property")
 		}
 		p.skipSpace()
-		val, err := p.parseValue()
+		val, important, err := p.parseValue()
 		if err != nil {
 			return Rule{}, err
 		}
-		decls[prop] = val
+		r.Decls[prop] = val
+		r.Important[prop] = important
 		p.skipSpace()
 		_ = p.consume(";") // optional (including trailing before '}')
 	}
-	return Rule{Kind: kind, Value: val, Specificity: spec, Decls: decls}, nil
+	return r, nil
 }
 
-func (p *ssParser) parseSelector() (SelectorKind, string, int, error) {
-	if p.consume("*") {
-		return SelectorUniversal, "", 0, nil
+// parseSelector reads an optional '*' or shape name followed by any number
+// of #id, .class, [attr], [attr=value], [attr!=value] and :not(simple).
+func (p *ssParser) parseSelector() (Rule, error) {
+	var r Rule
+	var spec int
+	if !p.consume("*") && !p.eof() && isIdentStart(rune(p.s[p.i])) {
+		shape, err := p.parseShapeName()
+		if err != nil {
+			return Rule{}, err
+		}
+		r.Parts = append(r.Parts, SelectorPart{Kind: SelectorShape, Value: shape})
+		spec++
 	}
-	if p.consume("#") {
-		id, err := p.parseIdent()
+	for !p.eof() {
+		not := p.consume(":not(")
+		if !not && p.s[p.i] == ':' {
+			return Rule{}, p.errf("expected ':not(' in selector")
+		}
+		part, err := p.parseSimpleSelector(not)
 		if err != nil {
-			return 0, "", 0, err
+			return Rule{}, err
+		}
+		if part == nil {
+			break
 		}
-		return SelectorID, id, 3, nil
+		if not {
+			part.Not = true
+			if !p.consume(")") {
+				return Rule{}, p.errf("expected ')' after :not selector")
+			}
+		}
+		r.Parts = append(r.Parts, *part)
+		switch part.Kind {
+		case SelectorID:
+			spec += 10000
+		case SelectorShape:
+			spec++
+		default:
+			spec += 100
+		}
+	}
+	r.Specificity = spec
+	switch {
+	case len(r.Parts) == 0:
+		r.Kind = SelectorUniversal
+	case len(r.Parts) == 1 && !r.Parts[0].Not && r.Parts[0].Kind != SelectorAttribute:
+		r.Kind, r.Value = r.Parts[0].Kind, r.Parts[0].Value
+	default:
+		r.Kind = SelectorCompound
 	}
-	if p.consume(".") {
+	return r, nil
+}
+
+// parseSimpleSelector reads one #id, .class or [attribute] test; inside
+// :not() a shape name is allowed too. It returns nil at the end of the
+// selector.
+func (p *ssParser) parseSimpleSelector(inNot bool) (*SelectorPart, error) {
+	switch {
+	case p.consume("#"):
+		id, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &SelectorPart{Kind: SelectorID, Value: id}, nil
+	case p.consume("."):
 		class, err := p.parseClassName()
 		if err != nil {
-			return 0, "", 0, err
+			return nil, err
+		}
+		return &SelectorPart{Kind: SelectorClass, Value: class}, nil
+	case p.consume("["):
+		return p.parseAttributeTest()
+	case inNot && !p.eof() && isIdentStart(rune(p.s[p.i])):
+		shape, err := p.parseShapeName()
+		if err != nil {
+			return nil, err
 		}
-		return SelectorClass, class, 2, nil
+		return &SelectorPart{Kind: SelectorShape, Value: shape}, nil
+	case inNot:
+		return nil, p.errf("expected selector inside :not()")
+	default:
+		return nil, nil
+	}
+}
+
+func (p *ssParser) parseAttributeTest() (*SelectorPart, error) {
+	attr, err := p.parseProperty()
+	if err != nil {
+		return nil, err
+	}
+	part := &SelectorPart{Kind: SelectorAttribute, Attr: attr}
+	p.skipSpace()
+	switch {
+	case p.consume("]"):
+		return part, nil
+	case p.consume("!="):
+		part.Op = "!="
+	case p.consume("="):
+		part.Op = "="
+	default:
+		return nil, p.errf("expected '=', '!=' or ']' in attribute selector")
+	}
+	p.skipSpace()
+	if !p.eof() && p.s[p.i] == '"' {
+		part.Value, err = p.parseString()
+	} else {
+		part.Value, err = p.parseIdentLike()
 	}
-	shape, err := p.parseIdentLike()
 	if err != nil {
-		return 0, "", 0, err
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.consume("]") {
+		return nil, p.errf("expected ']' after attribute selector")
 	}
-	return SelectorShape, shape, 1, nil
+	return part, nil
 }
 
 func (p *ssParser) parseIdent() (string, error) {
@@ -202,6 +454,21 @@ func (p *ssParser) parseIdent() (string, error) {
 	return p.s[start:p.i], nil
 }
 
+// parseProperty reads a property or attribute name: an identifier that may
+// contain dots, such as retry.backoff.jitter.
+func (p *ssParser) parseProperty() (string, error) {
+	p.skipSpace()
+	start := p.i
+	for {
+		if _, err := p.parseIdent(); err != nil {
+			return "", err
+		}
+		if !p.consume(".") {
+			return p.s[start:p.i], nil
+		}
+	}
+}
+
 func (p *ssParser) parseClassName() (string, error) {
 	p.skipSpace()
 	start := p.i
@@ -222,8 +489,17 @@ func (p *ssParser) parseClassName() (string, error) {
 	return p.s[start:p.i], nil
 }
 
+func (p *ssParser) parseShapeName() (string, error) {
+	// Shape names are [A-Za-z0-9_-]+; a '.' starts a class part (box.code).
+	return p.parseWord(false)
+}
+
 func (p *ssParser) parseIdentLike() (string, error) {
-	// For shape selectors and bare values, accept [A-Za-z0-9_-.]+
+	// For bare attribute values, accept [A-Za-z0-9_-.]+ (type=wait.human).
+	return p.parseWord(true)
+}
+
+func (p *ssParser) parseWord(dots bool) (string, error) {
 	p.skipSpace()
 	start := p.i
 	if p.eof() {
@@ -231,7 +507,7 @@ func (p *ssParser) parseIdentLike() (string, error) {
 	}
 	for !p.eof() {
 		r := rune(p.s[p.i])
-		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.' {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || (dots && r == '.') {
 			p.i++
 			continue
 		}
@@ -240,15 +516,22 @@ func (p *ssParser) parseIdentLike() (string, error) {
 	if start == p.i {
 		return "", p.errf("expected identifier")
 	}
-	return strings.TrimSpace(p.s[start:p.i]), nil
+	return p.s[start:p.i], nil
 }
 
-func (p *ssParser) parseValue() (string, error) {
+// parseValue reads a declaration value and its optional !important flag.
+func (p *ssParser) parseValue() (string, bool, error) {
 	if p.eof() {
-		return "", p.errf("expected value")
+		return "", false, p.errf("expected value")
 	}
-	if p.s[p.i] == '"' {
-		return p.parseString()
+	var quoted string
+	isQuoted := p.s[p.i] == '"'
+	if isQuoted {
+		s, err := p.parseString()
+		if err != nil {
+			return "", false, err
+		}
+		quoted = s
 	}
 	// Read until ';' or '}'.
 	start := p.i
@@ -258,7 +541,18 @@ func (p *ssParser) parseValue() (string, error) {
 		}
 		p.i++
 	}
-	return strings.TrimSpace(p.s[start:p.i]), nil
+	rest := strings.TrimSpace(p.s[start:p.i])
+	important := strings.HasSuffix(rest, "!important")
+	if important {
+		rest = strings.TrimSpace(strings.TrimSuffix(rest, "!important"))
+	}
+	if !isQuoted {
+		return rest, important, nil
+	}
+	if rest != "" {
+		return "", false, p.errf("unexpected %q after quoted value", rest)
+	}
+	return quoted, important, nil
 }
 
 func (p *ssParser) parseString() (string, error) {
@@ -183,3 +183,111 @@ func TestParseStylesheet_UppercaseClassNameFailsAtBoundary(t *testing.T) {
 		t.Fatalf("error should mention 'class name': %v", err)
 	}
 }
+
+func TestParseStylesheet_CompoundSelectorsAndSpecificity(t *testing.T) {
+	rules, err := ParseStylesheet(`
+[type=tool] { timeout: 600s; }
+box.code:not(#review) { max_retries: 2; }
+*[llm_provider!="openai"] { fidelity: compact; }
+[goal_gate] { retry.backoff.jitter: false; }
+`)
+	if err != nil {
+		t.Fatalf("ParseStylesheet: %v", err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("rules=%d", len(rules))
+	}
+	want := []struct {
+		selector string
+		spec     int
+	}{
+		{"[type=tool]", 100},
+		{"box.code:not(#review)", 10101},
+		{`*[llm_provider!="openai"]`, 100},
+		{"[goal_gate]", 100},
+	}
+	for i, w := range want {
+		if rules[i].Kind != SelectorCompound || rules[i].Selector != w.selector || rules[i].Specificity != w.spec {
+			t.Fatalf("rule %d: kind=%d selector=%q spec=%d, want %q/%d", i, rules[i].Kind, rules[i].Selector, rules[i].Specificity, w.selector, w.spec)
+		}
+	}
+	if got := rules[3].Decls["retry.backoff.jitter"]; got != "false" {
+		t.Fatalf("dotted property: %q", got)
+	}
+
+	for _, bad := range []string{`{ timeout: 1s; }`, `[type=tool { timeout: 1s; }`, `:hover { timeout: 1s; }`, `:not(#a { timeout: 1s; }`} {
+		if _, err := ParseStylesheet(bad); err == nil {
+			t.Fatalf("expected parse error for %q", bad)
+		}
+	}
+}
+
+func TestApplyStylesheet_AttributeSelectorsNegationAndImportant(t *testing.T) {
+	rules, err := ParseStylesheet(`
+* { timeout: 300s; max_retries: 1; }
+[type=tool] { timeout: 60s; }
+:not(parallelogram) { tool_hooks.pre: "./lint.sh"; }
+#impl { max_retries: 5; }
+* { llm_provider: anthropic !important; }
+`)
+	if err != nil {
+		t.Fatalf("ParseStylesheet: %v", err)
+	}
+	g := model.NewGraph("G")
+	build := model.NewNode("build")
+	build.Attrs["shape"] = "parallelogram"
+	impl := model.NewNode("impl")
+	impl.Attrs["shape"] = "box"
+	impl.Attrs["llm_provider"] = "openai"
+	impl.Attrs["timeout"] = "900s"
+	for _, n := range []*model.Node{build, impl} {
+		if err := g.AddNode(n); err != nil {
+			t.Fatalf("AddNode: %v", err)
+		}
+	}
+	if err := ApplyStylesheet(g, rules); err != nil {
+		t.Fatalf("ApplyStylesheet: %v", err)
+	}
+
+	for _, tc := range []struct{ node, prop, want string }{
+		{"build", "timeout", "60s"},
+		{"build", "max_retries", "1"},
+		{"build", "tool_hooks.pre", ""},
+		{"impl", "timeout", "900s"}, // explicit beats ordinary rules
+		{"impl", "max_retries", "5"},
+		{"impl", "tool_hooks.pre", "./lint.sh"},
+		{"impl", "llm_provider", "anthropic"}, // !important beats explicit
+	} {
+		if got := g.Nodes[tc.node].Attrs[tc.prop]; got != tc.want {
+			t.Errorf("%s %s=%q want %q", tc.node, tc.prop, got, tc.want)
+		}
+	}
+}
+
+func TestCascade_OrdersCandidatesWinnerFirst(t *testing.T) {
+	rules, err := ParseStylesheet(`
+* { llm_model: a; }
+.code { llm_model: b; }
+* { llm_model: c; }
+box { llm_model: d !important; }
+`)
+	if err != nil {
+		t.Fatalf("ParseStylesheet: %v", err)
+	}
+	g := model.NewGraph("G")
+	g.Attrs["llm_model"] = "graph-default"
+	n := model.NewNode("n")
+	n.Attrs["class"] = "code"
+	n.Attrs["llm_model"] = "explicit"
+
+	var got []string
+	for _, c := range Cascade(g, n, rules)["llm_model"] {
+		got = append(got, c.Value)
+	}
+	if want := "d explicit b c a graph-default"; strings.Join(got, " ") != want {
+		t.Fatalf("cascade=%v want %s", got, want)
+	}
+	if _, ok := Cascade(g, n, rules)["timeout"]; ok {
+		t.Fatal("unset property has candidates")
+	}
+}
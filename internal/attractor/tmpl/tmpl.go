@@ -0,0 +1,316 @@
+// Package tmpl renders Attractor prompt templates. A prompt of a node or
+// graph that sets prompt_template=true is a Go text/template evaluated when
+// the stage runs, over a fixed set of
+// run variables (Vars) and a few functions: include (a worktree file), ctx
+// (a context value by key), default and trim. Templates cannot run commands
+// or read outside the worktree.
+//
+//	{{if gt .retry_count 0}}
+//	The previous attempt failed:
+//	{{.retry_failure_reason}}
+//	{{end}}
+//	{{range $id, $o := .outcomes}}- {{$id}}: {{$o.status}}
+//	{{end}}
+//	{{include "docs/spec.md"}}
+package tmpl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// maxIncludeBytes caps what a single include can add to a prompt.
+const maxIncludeBytes = 256 << 10
+
+// Vars are the values a template can reference. Field names are lowercased
+// with underscores in templates (.failure_reason, .outcomes.impl.status).
+type Vars struct {
+	Goal    string
+	RunID   string
+	BaseSHA string
+
+	// Node holds the current node's attrs, plus "id".
+	Node map[string]string
+	// Graph holds the graph attrs.
+	Graph map[string]string
+	// Context holds every run context value, formatted as text.
+	Context map[string]string
+	// Outcomes maps completed node IDs to their last outcome: status,
+	// failure_reason, preferred_label and notes.
+	Outcomes map[string]map[string]string
+
+	// The previous stage's outcome, as seen by edge conditions.
+	Outcome        string
+	FailureReason  string
+	FailureClass   string
+	PreferredLabel string
+	PreviousNode   string
+	CompletedNodes []string
+
+	// RetryCount is the number of earlier attempts of this node in the
+	// current visit; RetryFailureReason is why the last one failed.
+	RetryCount         int
+	RetryFailureReason string
+	// LoopRestartCount is the number of loop_restart relaunches so far.
+	LoopRestartCount int
+}
+
+// Names lists the top-level variables, for docs and the unknown-variable
+// lint.
+var Names = []string{
+	"base_sha", "completed_nodes", "context", "failure_class", "failure_reason",
+	"goal", "graph", "loop_restart_count", "node", "outcome", "outcomes",
+	"preferred_label", "previous_node", "retry_count", "retry_failure_reason", "run_id",
+}
+
+func (v Vars) data() map[string]any {
+	return map[string]any{
+		"base_sha":             v.BaseSHA,
+		"completed_nodes":      nonNilStrings(v.CompletedNodes),
+		"context":              nonNilMap(v.Context),
+		"failure_class":        v.FailureClass,
+		"failure_reason":       v.FailureReason,
+		"goal":                 v.Goal,
+		"graph":                nonNilMap(v.Graph),
+		"loop_restart_count":   v.LoopRestartCount,
+		"node":                 nonNilMap(v.Node),
+		"outcome":              v.Outcome,
+		"outcomes":             nonNilOutcomes(v.Outcomes),
+		"preferred_label":      v.PreferredLabel,
+		"previous_node":        v.PreviousNode,
+		"retry_count":          v.RetryCount,
+		"retry_failure_reason": v.RetryFailureReason,
+		"run_id":               v.RunID,
+	}
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+func nonNilOutcomes(m map[string]map[string]string) map[string]map[string]string {
+	if m == nil {
+		return map[string]map[string]string{}
+	}
+	return m
+}
+
+// Attr is the node or graph attribute that opts prompts into templating;
+// a node's value overrides the graph's. Other prompts are used verbatim, so
+// "{{" in them (GitHub Actions, Helm, Jinja) needs no escaping.
+const Attr = "prompt_template"
+
+// Enabled reports whether prompts are templates, given the node's and the
+// graph's prompt_template values.
+func Enabled(nodeValue, graphValue string) bool {
+	v := strings.TrimSpace(nodeValue)
+	if v == "" {
+		v = strings.TrimSpace(graphValue)
+	}
+	return strings.EqualFold(v, "true")
+}
+
+// Render evaluates text against v. include paths resolve inside includeRoot
+// (the stage worktree); an empty includeRoot disables include.
+func Render(text string, v Vars, includeRoot string) (string, error) {
+	t, err := newTemplate(funcs(v, includeRoot)).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, v.data()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func newTemplate(fm template.FuncMap) *template.Template {
+	// Maps hold strings, so missing keys render as "" rather than failing:
+	// {{if .context.tests_failed}} works before any stage sets the key.
+	return template.New("prompt").Option("missingkey=zero").Funcs(fm)
+}
+
+func funcs(v Vars, includeRoot string) template.FuncMap {
+	return template.FuncMap{
+		"include": func(path string) (string, error) { return include(includeRoot, path) },
+		"ctx": func(key string) string {
+			return v.Context[key]
+		},
+		"default": func(def string, val any) string {
+			s := fmt.Sprint(val)
+			if val == nil || s == "" {
+				return def
+			}
+			return s
+		},
+		"trim": strings.TrimSpace,
+	}
+}
+
+func include(root, path string) (string, error) {
+	if root == "" {
+		return "", errors.New("include: no worktree")
+	}
+	if path == "" || filepath.IsAbs(path) {
+		return "", fmt.Errorf("include %q: path must be relative to the worktree", path)
+	}
+	if clean := filepath.Clean(path); clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("include %q: path escapes the worktree", path)
+	}
+	full := filepath.Join(root, path)
+	real, err := filepath.EvalSymlinks(full)
+	if err != nil {
+		return "", fmt.Errorf("include %q: %w", path, err)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("include %q: %w", path, err)
+	}
+	if rel, err := filepath.Rel(realRoot, real); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("include %q: path escapes the worktree", path)
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", fmt.Errorf("include %q: %w", path, err)
+	}
+	if info.Size() > maxIncludeBytes {
+		return "", fmt.Errorf("include %q: file is %d bytes (limit %d)", path, info.Size(), maxIncludeBytes)
+	}
+	b, err := os.ReadFile(real)
+	if err != nil {
+		return "", fmt.Errorf("include %q: %w", path, err)
+	}
+	return string(b), nil
+}
+
+// Reference is a variable a template uses that Check could not resolve.
+type Reference struct {
+	Path string // as written, e.g. ".graph.gaol"
+	// Unknown is true when the top-level name does not exist; such a
+	// reference always renders empty. Otherwise the name exists but the key
+	// under it (a graph attr or node ID) was not found in the graph.
+	Unknown bool
+}
+
+// Check parses text and reports the references it cannot resolve against
+// the top-level names, the graph attrs and the node IDs. Fields under
+// context and node are not checked: stages set context keys at run time.
+func Check(text string, graphAttrs map[string]string, nodeIDs map[string]bool) ([]Reference, error) {
+	t, err := newTemplate(funcs(Vars{}, "")).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{graphAttrs: graphAttrs, nodeIDs: nodeIDs, seen: map[string]bool{}}
+	c.walk(t.Tree.Root, true)
+	sort.Slice(c.refs, func(i, j int) bool { return c.refs[i].Path < c.refs[j].Path })
+	return c.refs, nil
+}
+
+type checker struct {
+	graphAttrs map[string]string
+	nodeIDs    map[string]bool
+	seen       map[string]bool
+	refs       []Reference
+}
+
+// walk visits n; rootDot is false inside range and with bodies, where "."
+// is no longer the top-level data and fields cannot be checked.
+func (c *checker) walk(n parse.Node, rootDot bool) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, rootDot)
+		}
+	case *parse.ActionNode:
+		c.walk(n.Pipe, rootDot)
+	case *parse.IfNode:
+		c.branch(&n.BranchNode, rootDot, rootDot)
+	case *parse.RangeNode:
+		c.branch(&n.BranchNode, rootDot, false)
+	case *parse.WithNode:
+		c.branch(&n.BranchNode, rootDot, false)
+	case *parse.TemplateNode:
+		c.walk(n.Pipe, rootDot)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			c.walk(cmd, rootDot)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			c.walk(arg, rootDot)
+		}
+	case *parse.ChainNode:
+		c.walk(n.Node, rootDot)
+	case *parse.FieldNode:
+		if rootDot {
+			c.check(n.Ident)
+		}
+	case *parse.VariableNode:
+		// $ is always the top-level data; $x.y cannot be checked.
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			c.check(n.Ident[1:])
+		}
+	}
+}
+
+func (c *checker) branch(b *parse.BranchNode, rootDot, bodyRootDot bool) {
+	c.walk(b.Pipe, rootDot)
+	c.walk(b.List, bodyRootDot)
+	c.walk(b.ElseList, rootDot)
+}
+
+func (c *checker) check(ident []string) {
+	path := "." + strings.Join(ident, ".")
+	add := func(unknown bool) {
+		if !c.seen[path] {
+			c.seen[path] = true
+			c.refs = append(c.refs, Reference{Path: path, Unknown: unknown})
+		}
+	}
+	known := false
+	for _, name := range Names {
+		if name == ident[0] {
+			known = true
+			break
+		}
+	}
+	if !known {
+		add(true)
+		return
+	}
+	if len(ident) < 2 {
+		return
+	}
+	switch ident[0] {
+	case "graph":
+		if _, ok := c.graphAttrs[ident[1]]; !ok {
+			add(false)
+		}
+	case "outcomes":
+		if !c.nodeIDs[ident[1]] {
+			add(false)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package tmpl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender_VariablesConditionalsLoopsAndIncludes(t *testing.T) {
+	root := t.TempDir()
+	_ = os.WriteFile(filepath.Join(root, "notes.md"), []byte("remember the edge cases\n"), 0o644)
+	v := Vars{
+		Goal:     "ship",
+		Context:  map[string]string{"loop_restart.from_node": "verify"},
+		Outcomes: map[string]map[string]string{"plan": {"status": "success"}, "test": {"status": "fail", "failure_reason": "2 tests failed"}},
+		Node:     map[string]string{"id": "impl"},
+	}
+	got, err := Render(strings.Join([]string{
+		`{{.node.id}}: {{.goal}}`,
+		`{{range $id, $o := .outcomes}}{{$id}}={{$o.status}} {{end}}`,
+		`{{if eq .outcomes.test.status "fail"}}test: {{.outcomes.test.failure_reason}}{{end}}`,
+		`{{ctx "loop_restart.from_node"}} {{.context.unset | default "none"}} [{{.outcomes.nope.status}}]`,
+		`{{include "notes.md" | trim}}`,
+	}, "\n"), v, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "impl: ship\nplan=success test=fail \ntest: 2 tests failed\nverify none []\nremember the edge cases"
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, path := range []string{"../x", "/etc/passwd", "missing.md"} {
+		if _, err := Render(`{{include "`+path+`"}}`, v, root); err == nil {
+			t.Fatalf("include %q: expected error", path)
+		}
+	}
+	if _, err := Render(`{{include "notes.md"}}`, v, ""); err == nil {
+		t.Fatal("include without a worktree: expected error")
+	}
+}
+
+func TestCheck_ReportsUnresolvedReferences(t *testing.T) {
+	refs, err := Check(
+		`{{.goal}} {{.gaol}} {{.graph.team}} {{.graph.taem}} {{.outcomes.plan.status}} {{.outcomes.nope}} `+
+			`{{range .outcomes}}{{.status}}{{end}} {{with .node}}{{.id}}{{end}} {{$.missing}} {{.context.whatever}}`,
+		map[string]string{"team": "core"}, map[string]bool{"plan": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Reference{
+		{Path: ".gaol", Unknown: true},
+		{Path: ".graph.taem"},
+		{Path: ".missing", Unknown: true},
+		{Path: ".outcomes.nope"},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("refs = %+v", refs)
+	}
+
+	if _, err := Check(`{{if .goal}}`, nil, nil); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
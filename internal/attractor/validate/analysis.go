@@ -0,0 +1,805 @@
+package validate
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+	"github.com/danshapiro/kilroy/internal/attractor/runtime"
+)
+
+// AnalysisRules returns the rules that check how a run moves through the
+// graph rather than how each statement is written: every way a stage can end
+// has a route (RoutingRule), every loop is bounded (CycleBoundRule), and the
+// exits can be reached (ExitReachableRule). Validate runs them after the
+// per-statement rules.
+func AnalysisRules() []LintRule {
+	return []LintRule{RoutingRule{}, CycleBoundRule{}, ExitReachableRule{}}
+}
+
+// RoutingRule warns when a stage can end with an outcome that no outgoing
+// edge is meant for, so the engine falls back to an arbitrary edge; when a
+// prompt asks for a custom outcome that nothing routes, which the engine then
+// treats as a failure; and when a stage other than an exit has no outgoing
+// edges at all.
+type RoutingRule struct{}
+
+func (RoutingRule) Name() string { return "routing_complete" }
+
+func (RoutingRule) Apply(g *model.Graph) []Diagnostic {
+	f := analyzeFlow(g)
+	if f == nil {
+		return nil
+	}
+	var diags []Diagnostic
+	for _, id := range f.ids {
+		n := g.Nodes[id]
+		for _, status := range f.unroutedPrompt[id] {
+			diags = append(diags, Diagnostic{
+				Rule:     "routing_complete",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("prompt of node %q can end with outcome=%s, but no edge routes it; the engine treats it as a failure", id, status),
+				NodeID:   id,
+				Attr:     promptAttr(n),
+				Fix:      fmt.Sprintf("add an edge with condition=\"outcome=%s\"", status),
+			})
+		}
+		if f.deadEnd[id] {
+			diags = append(diags, Diagnostic{
+				Rule:     "routing_complete",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("node %q has no outgoing edges; a run that gets here ends without reaching an exit", id),
+				NodeID:   id,
+				Fix:      "add an edge to the next stage or to the exit node",
+			})
+			continue
+		}
+		var gaps []string
+		var fallback *model.Edge
+		for _, r := range f.routes[id] {
+			if r.gap {
+				gaps = append(gaps, r.class.String())
+				fallback = r.edges[len(r.edges)-1]
+			}
+		}
+		if len(gaps) == 0 {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Rule:     "routing_complete",
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("node %q has no edge for %s; the engine falls back to %s -> %s",
+				id, strings.Join(gaps, ", "), fallback.From, fallback.To),
+			NodeID: id,
+			Fix:    fmt.Sprintf("add an edge with condition=%q, or an unconditional fallback edge", gaps[0]),
+		})
+	}
+	return diags
+}
+
+// CycleBoundRule checks the loops a run can take. A loop with no way out is
+// an error: the run can never reach an exit. A loop with a way out but
+// nothing that bounds it (graph max_node_visits, a loop_restart edge, which
+// max_restarts limits, or a goal_gate stage) is a warning: nothing stops it
+// repeating for as long as its stages keep choosing to.
+type CycleBoundRule struct{}
+
+func (CycleBoundRule) Name() string { return "cycle_bounded" }
+
+func (CycleBoundRule) Apply(g *model.Graph) []Diagnostic {
+	f := analyzeFlow(g)
+	if f == nil {
+		return nil
+	}
+	reach := f.reachable(f.starts)
+	bounded := parseIntDefault(g.Attrs["max_node_visits"], 0) > 0
+	var diags []Diagnostic
+	for _, scc := range f.cycles() {
+		if !reach[scc[0]] {
+			continue // reachability reports it
+		}
+		in := map[string]bool{}
+		for _, id := range scc {
+			in[id] = true
+		}
+		leaves, limited := false, bounded
+		for _, id := range scc {
+			if f.ends[id] || isExitNode(g, id) {
+				leaves = true
+			}
+			for _, to := range f.next[id] {
+				if !in[to] {
+					leaves = true
+				}
+			}
+			if strings.EqualFold(g.Nodes[id].Attr("goal_gate", "false"), "true") {
+				limited = true
+			}
+		}
+		for _, e := range g.Edges {
+			if e != nil && in[e.From] && in[e.To] && strings.EqualFold(e.Attr("loop_restart", "false"), "true") {
+				limited = true
+			}
+		}
+		d := Diagnostic{Rule: "cycle_bounded", NodeID: scc[0]}
+		if e := loopBackEdge(g, in); e != nil {
+			d.NodeID, d.EdgeFrom, d.EdgeTo = "", e.From, e.To
+		}
+		switch {
+		case !leaves:
+			d.Severity = SeverityError
+			d.Message = fmt.Sprintf("stages %s form a loop with no way out; the run can never reach an exit", strings.Join(scc, ", "))
+			d.Fix = "route some outcome of a stage in the loop to a stage outside it"
+		case !limited:
+			d.Severity = SeverityWarning
+			d.Message = fmt.Sprintf("stages %s form a loop with nothing bounding how often it repeats", strings.Join(scc, ", "))
+			d.Fix = "set graph max_node_visits, restart the loop through a loop_restart=true edge, or mark a stage in it goal_gate=true"
+		default:
+			continue
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// loopBackEdge picks the edge to report a loop on: the first one in it that
+// points back to a node declared earlier.
+func loopBackEdge(g *model.Graph, in map[string]bool) *model.Edge {
+	for _, e := range g.Edges {
+		if e == nil || !in[e.From] || !in[e.To] {
+			continue
+		}
+		if g.Nodes[e.To].Order <= g.Nodes[e.From].Order {
+			return e
+		}
+	}
+	return nil
+}
+
+// ExitReachableRule reports exits that a run can never get to. An exit that
+// edges connect but no outcome routes to is a warning; if no exit can be
+// reached from the start node at all it is an error.
+type ExitReachableRule struct{}
+
+func (ExitReachableRule) Name() string { return "exit_reachable" }
+
+func (ExitReachableRule) Apply(g *model.Graph) []Diagnostic {
+	f := analyzeFlow(g)
+	if f == nil || len(f.starts) != 1 {
+		return nil
+	}
+	routed := f.reachable(f.starts)
+	connected := map[string]bool{f.starts[0]: true}
+	queue := []string{f.starts[0]}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Outgoing(cur) {
+			if e != nil && g.Nodes[e.To] != nil && !connected[e.To] {
+				connected[e.To] = true
+				queue = append(queue, e.To)
+			}
+		}
+	}
+
+	var diags []Diagnostic
+	anyRouted, anyConnected := false, false
+	for _, id := range f.ids {
+		if !isExitNode(g, id) {
+			continue
+		}
+		anyRouted = anyRouted || routed[id]
+		anyConnected = anyConnected || connected[id]
+		if connected[id] && !routed[id] {
+			diags = append(diags, Diagnostic{
+				Rule:     "exit_reachable",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("exit node %q is unreachable: edges lead to it, but no outcome of the stages before it selects them", id),
+				NodeID:   id,
+				Fix:      "route an outcome to the exit, e.g. with an edge conditioned on outcome=success",
+			})
+		}
+	}
+	if anyConnected && !anyRouted {
+		diags = append(diags, Diagnostic{
+			Rule:     "exit_reachable",
+			Severity: SeverityError,
+			Message:  "no exit node can be reached from start: every route ends in a dead end or a loop",
+			NodeID:   f.starts[0],
+		})
+	}
+	return diags
+}
+
+// outcomeClass is one way a stage can end, as far as routing can tell the
+// ways apart. retry is not a class of its own: once a stage's retries run
+// out it ends with fail, or partial_success when allow_partial=true.
+type outcomeClass struct {
+	status       string
+	failureClass string // for fail, when the stage's edges route on failure_class
+	label        string // preferred label
+}
+
+// String renders the class as the condition that selects it.
+func (c outcomeClass) String() string {
+	if c.label != "" {
+		return "preferred_label=" + c.label
+	}
+	s := "outcome=" + c.status
+	if c.failureClass != "" {
+		s += " && context.failure_class=" + c.failureClass
+	}
+	return s
+}
+
+// Route is where the engine sends a stage for one way it can end: the
+// stages its edges may lead to, the retry_target it jumps to when no edge
+// applies, and whether the edge taken is only the engine's fallback.
+type Route struct {
+	Status       string
+	FailureClass string // set when the stage's edges route on failure_class
+	Label        string // preferred label
+	Targets      []string
+	Jump         string
+	Fallback     bool
+}
+
+// Outcome renders the way the stage ends as the condition that selects it.
+func (r Route) Outcome() string {
+	return outcomeClass{status: r.Status, failureClass: r.FailureClass, label: r.Label}.String()
+}
+
+// Routes returns, keyed by node ID, the routes of every stage that chooses
+// among its edges, as the routing_complete analysis sees them. Exit and
+// parallel nodes, and stages without edges, have none.
+func Routes(g *model.Graph) map[string][]Route {
+	f := analyzeFlow(g)
+	if f == nil {
+		return nil
+	}
+	out := map[string][]Route{}
+	for id, rs := range f.routes {
+		for _, r := range rs {
+			route := Route{
+				Status:       r.class.status,
+				FailureClass: r.class.failureClass,
+				Label:        r.class.label,
+				Jump:         r.jump,
+				Fallback:     r.gap,
+			}
+			for _, e := range r.edges {
+				if !slices.Contains(route.Targets, e.To) {
+					route.Targets = append(route.Targets, e.To)
+				}
+			}
+			out[id] = append(out[id], route)
+		}
+	}
+	return out
+}
+
+// failureClasses are the values the engine gives context.failure_class.
+var failureClasses = []string{"transient_infra", "deterministic", "canceled", "budget_exhausted", "compilation_loop", "structural"}
+
+// classRoute is where the engine sends a stage for one outcome class.
+type classRoute struct {
+	class outcomeClass
+	edges []*model.Edge // edges the engine may follow
+	jump  string        // retry_target jumped to when no edge applies
+	gap   bool          // no edge is meant for the class; edges ends with the engine's fallback
+}
+
+// flow is the routing of a whole graph: the outcome classes of each stage,
+// where each one leads, and the resulting stage-to-stage moves.
+type flow struct {
+	g      *model.Graph
+	ids    []string // node IDs in declaration order
+	starts []string
+	routes map[string][]classRoute
+	next   map[string][]string // stages a run can move to from each stage
+	ends   map[string]bool     // stages where some outcome ends the run
+
+	deadEnd        map[string]bool
+	unroutedPrompt map[string][]string
+
+	classMemo map[string][]outcomeClass
+}
+
+func analyzeFlow(g *model.Graph) *flow {
+	if g == nil || len(g.Nodes) == 0 {
+		return nil
+	}
+	f := &flow{
+		g:              g,
+		starts:         findAllStartNodeIDs(g),
+		routes:         map[string][]classRoute{},
+		next:           map[string][]string{},
+		ends:           map[string]bool{},
+		deadEnd:        map[string]bool{},
+		unroutedPrompt: map[string][]string{},
+		classMemo:      map[string][]outcomeClass{},
+	}
+	for id, n := range g.Nodes {
+		if n != nil {
+			f.ids = append(f.ids, id)
+		}
+	}
+	sort.Slice(f.ids, func(i, j int) bool {
+		a, b := g.Nodes[f.ids[i]], g.Nodes[f.ids[j]]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return a.ID < b.ID
+	})
+	sort.Strings(f.starts)
+
+	for _, id := range f.ids {
+		n := g.Nodes[id]
+		out := f.outgoing(id)
+		switch handlerType(n) {
+		case "exit":
+			// A run leaves an exit only when a goal gate sends it back.
+			for _, gid := range f.ids {
+				if strings.EqualFold(g.Nodes[gid].Attr("goal_gate", "false"), "true") {
+					if t := retryTargetOf(g, gid); t != "" {
+						f.addNext(id, t)
+					}
+				}
+			}
+			continue
+		case "parallel":
+			// Every branch runs; the run continues at their join.
+			for _, e := range out {
+				f.addNext(id, e.To)
+			}
+			continue
+		}
+		if len(out) == 0 {
+			f.deadEnd[id] = true
+			f.ends[id] = true
+			if t := retryTargetOf(g, id); t != "" {
+				f.addNext(id, t)
+			}
+			continue
+		}
+		for _, c := range f.classesOf(id) {
+			r := f.route(n, out, c)
+			f.routes[id] = append(f.routes[id], r)
+			for _, e := range r.edges {
+				f.addNext(id, e.To)
+			}
+			if r.jump != "" {
+				f.addNext(id, r.jump)
+			}
+			if len(r.edges) == 0 && r.jump == "" {
+				f.ends[id] = true
+			}
+		}
+	}
+	return f
+}
+
+func (f *flow) outgoing(id string) []*model.Edge {
+	var out []*model.Edge
+	for _, e := range f.g.Outgoing(id) {
+		if e != nil && f.g.Nodes[e.To] != nil {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (f *flow) addNext(from, to string) {
+	if f.g.Nodes[to] == nil {
+		return
+	}
+	for _, t := range f.next[from] {
+		if t == to {
+			return
+		}
+	}
+	f.next[from] = append(f.next[from], to)
+}
+
+// classesOf enumerates the ways a stage can end. A conditional node passes
+// on the outcome of the stage before it.
+func (f *flow) classesOf(id string) []outcomeClass {
+	if cs, ok := f.classMemo[id]; ok {
+		return cs
+	}
+	f.classMemo[id] = nil // cut cycles of conditional nodes
+	n := f.g.Nodes[id]
+	out := f.outgoing(id)
+	var cs []outcomeClass
+	add := func(c outcomeClass) {
+		for _, have := range cs {
+			if have == c {
+				return
+			}
+		}
+		cs = append(cs, c)
+	}
+	switch t := handlerType(n); t {
+	case "start":
+		add(outcomeClass{status: "success"})
+	case "conditional":
+		for _, e := range f.g.Incoming(id) {
+			if e == nil || f.g.Nodes[e.From] == nil {
+				continue
+			}
+			for _, c := range f.classesOf(e.From) {
+				c.failureClass = ""
+				add(c)
+			}
+		}
+		if len(cs) == 0 {
+			add(outcomeClass{status: "success"})
+		}
+	case "wait.human":
+		// The answer is the label of the chosen edge.
+		for _, e := range out {
+			if l := strings.TrimSpace(e.Label()); l != "" {
+				add(outcomeClass{status: "success", label: l})
+			}
+		}
+		if len(cs) == 0 {
+			add(outcomeClass{status: "success"})
+		}
+	default:
+		add(outcomeClass{status: "success"})
+		add(outcomeClass{status: "fail"})
+		if t == "parallel.fan_in" || strings.EqualFold(n.Attr("allow_partial", "false"), "true") {
+			add(outcomeClass{status: "partial_success"})
+		}
+		for _, e := range out {
+			for _, s := range outcomeEqualsStatuses(e.Condition()) {
+				if s != runtime.StatusRetry {
+					add(outcomeClass{status: string(s)})
+				}
+			}
+		}
+		// Outcomes the prompt asks for. A custom one that no edge matches
+		// is not a route: the engine treats it as a failure.
+		for _, m := range outcomeAssignmentPattern.FindAllStringSubmatch(promptOf(n), -1) {
+			s, err := runtime.ParseStageStatus(m[1])
+			if err != nil || s == runtime.StatusRetry {
+				continue
+			}
+			c := outcomeClass{status: string(s)}
+			if !s.IsCanonical() && !anyConditionMatches(out, c) {
+				f.notePromptOutcome(id, string(s))
+				continue
+			}
+			add(c)
+		}
+		if t != "tool" {
+			// An LLM stage may pick an edge by its label.
+			for _, e := range out {
+				if l := strings.TrimSpace(e.Label()); l != "" {
+					add(outcomeClass{status: "success", label: l})
+				}
+			}
+		}
+	}
+
+	// Split fail by failure_class where the edges tell them apart.
+	for _, e := range out {
+		if !conditionReferencesFailureClass(e.Condition()) {
+			continue
+		}
+		var split []outcomeClass
+		for _, c := range cs {
+			if c.status != "fail" || c.failureClass != "" {
+				split = append(split, c)
+				continue
+			}
+			for _, fc := range failureClasses {
+				c.failureClass = fc
+				split = append(split, c)
+			}
+		}
+		cs = split
+		break
+	}
+	f.classMemo[id] = cs
+	return cs
+}
+
+func (f *flow) notePromptOutcome(id, status string) {
+	for _, s := range f.unroutedPrompt[id] {
+		if s == status {
+			return
+		}
+	}
+	f.unroutedPrompt[id] = append(f.unroutedPrompt[id], status)
+}
+
+func anyConditionMatches(edges []*model.Edge, c outcomeClass) bool {
+	for _, e := range edges {
+		if cond := strings.TrimSpace(e.Condition()); cond != "" && matchCondition(cond, c) != noMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// route follows the engine's edge selection for one outcome class:
+// conditions first, then the preferred label, then unconditional edges, and
+// as a last resort the best of all edges. A fan-in that fails only follows
+// a matching condition or its retry_target.
+func (f *flow) route(n *model.Node, out []*model.Edge, c outcomeClass) classRoute {
+	r := classRoute{class: c}
+	var must, may []*model.Edge
+	for _, e := range out {
+		cond := strings.TrimSpace(e.Condition())
+		if cond == "" {
+			continue
+		}
+		switch matchCondition(cond, c) {
+		case mustMatch:
+			must = append(must, e)
+		case mayMatch:
+			may = append(may, e)
+		}
+	}
+	if handlerType(n) == "parallel.fan_in" && c.status == "fail" {
+		r.edges = append(must, may...)
+		if len(must) == 0 && c.failureClass != "deterministic" {
+			r.jump = retryTargetOf(f.g, n.ID)
+		}
+		return r
+	}
+	if len(must) > 0 {
+		r.edges = append(must, may...)
+		return r
+	}
+	// Conditions that depend on context may or may not hold; whatever
+	// follows applies when they do not.
+	r.edges = may
+	if c.label != "" {
+		for _, e := range out {
+			if strings.EqualFold(strings.TrimSpace(e.Label()), c.label) {
+				r.edges = append(r.edges, e)
+				return r
+			}
+		}
+	}
+	var uncond []*model.Edge
+	for _, e := range out {
+		if strings.TrimSpace(e.Condition()) == "" {
+			uncond = append(uncond, e)
+		}
+	}
+	if len(uncond) > 0 {
+		r.edges = append(r.edges, uncond...)
+		return r
+	}
+	r.gap = len(may) == 0
+	r.edges = append(r.edges, fallbackEdge(out))
+	return r
+}
+
+// fallbackEdge is the edge the engine takes when none is eligible: highest
+// weight, then lowest target ID, then first declared.
+func fallbackEdge(edges []*model.Edge) *model.Edge {
+	best := edges[0]
+	for _, e := range edges[1:] {
+		we, wb := parseIntDefault(e.Attr("weight", "0"), 0), parseIntDefault(best.Attr("weight", "0"), 0)
+		if we > wb || (we == wb && (e.To < best.To || (e.To == best.To && e.Order < best.Order))) {
+			best = e
+		}
+	}
+	return best
+}
+
+type match int
+
+const (
+	noMatch match = iota
+	mayMatch
+	mustMatch
+)
+
+// matchCondition decides statically whether a condition holds for an outcome
+// class. Clauses on context keys other than failure_class cannot be decided
+// and make the result mayMatch.
+func matchCondition(expr string, c outcomeClass) match {
+	result := mustMatch
+	for _, clause := range strings.Split(expr, "&&") {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+		op := "="
+		if strings.Contains(clause, "!=") {
+			op = "!="
+		} else if !strings.Contains(clause, "=") {
+			result = mayMatch
+			continue
+		}
+		parts := strings.SplitN(clause, op, 2)
+		key, want := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var got string
+		switch key {
+		case "outcome":
+			got = c.status
+			if s, err := runtime.ParseStageStatus(want); err == nil {
+				want = string(s)
+			}
+		case "preferred_label":
+			got = c.label
+		case "context.failure_class", "failure_class":
+			if c.status == "fail" && c.failureClass == "" {
+				result = mayMatch
+				continue
+			}
+			got = c.failureClass
+		default:
+			result = mayMatch
+			continue
+		}
+		if (got == want) != (op == "=") {
+			return noMatch
+		}
+	}
+	return result
+}
+
+// reachable returns the stages a run can get to from the given ones.
+func (f *flow) reachable(from []string) map[string]bool {
+	seen := map[string]bool{}
+	queue := append([]string{}, from...)
+	for _, id := range from {
+		seen[id] = true
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, to := range f.next[cur] {
+			if !seen[to] {
+				seen[to] = true
+				queue = append(queue, to)
+			}
+		}
+	}
+	return seen
+}
+
+// cycles returns the loops in the flow (strongly connected components with
+// more than one stage, or a stage that can repeat itself), each in
+// declaration order.
+func (f *flow) cycles() [][]string {
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var out [][]string
+	var visit func(id string)
+	visit = func(id string) {
+		index[id], low[id] = len(index), len(index)
+		stack = append(stack, id)
+		onStack[id] = true
+		for _, to := range f.next[id] {
+			if _, ok := index[to]; !ok {
+				visit(to)
+				low[id] = min(low[id], low[to])
+			} else if onStack[to] {
+				low[id] = min(low[id], index[to])
+			}
+		}
+		if low[id] != index[id] {
+			return
+		}
+		var scc []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			scc = append(scc, top)
+			if top == id {
+				break
+			}
+		}
+		if len(scc) > 1 || f.repeats(id) {
+			out = append(out, scc)
+		}
+	}
+	for _, id := range f.ids {
+		if _, ok := index[id]; !ok {
+			visit(id)
+		}
+	}
+	pos := map[string]int{}
+	for i, id := range f.ids {
+		pos[id] = i
+	}
+	for _, scc := range out {
+		sort.Slice(scc, func(i, j int) bool { return pos[scc[i]] < pos[scc[j]] })
+	}
+	sort.Slice(out, func(i, j int) bool { return pos[out[i][0]] < pos[out[j][0]] })
+	return out
+}
+
+func (f *flow) repeats(id string) bool {
+	for _, to := range f.next[id] {
+		if to == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerType is the handler a node resolves to: its type attr, else the
+// one its shape selects.
+func handlerType(n *model.Node) string {
+	if t := strings.TrimSpace(n.TypeOverride()); t != "" {
+		return t
+	}
+	switch n.Shape() {
+	case "Mdiamond", "circle":
+		return "start"
+	case "Msquare", "doublecircle":
+		return "exit"
+	case "hexagon":
+		return "wait.human"
+	case "diamond":
+		return "conditional"
+	case "component":
+		return "parallel"
+	case "tripleoctagon":
+		return "parallel.fan_in"
+	case "parallelogram":
+		return "tool"
+	case "house":
+		return "stack.manager_loop"
+	default:
+		return "codergen"
+	}
+}
+
+func isExitNode(g *model.Graph, id string) bool {
+	for _, exit := range findAllExitNodeIDs(g) {
+		if exit == id {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTargetOf resolves where the engine jumps when a stage fails with no
+// route, as it does for goal gates: the node's retry targets, then the
+// graph's.
+func retryTargetOf(g *model.Graph, id string) string {
+	n := g.Nodes[id]
+	for _, t := range []string{n.Attr("retry_target", ""), n.Attr("fallback_retry_target", ""), g.Attrs["retry_target"], g.Attrs["fallback_retry_target"]} {
+		if t = strings.TrimSpace(t); t != "" {
+			return t
+		}
+	}
+	return ""
+}
+
+func promptOf(n *model.Node) string {
+	if p := n.Attr("prompt", ""); p != "" {
+		return p
+	}
+	return n.Attr("llm_prompt", "")
+}
+
+func promptAttr(n *model.Node) string {
+	if n.Attr("prompt", "") == "" && n.Attr("llm_prompt", "") != "" {
+		return "llm_prompt"
+	}
+	return "prompt"
+}
+
+func parseIntDefault(s string, def int) int {
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,191 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+func parseAnalysisGraph(t *testing.T, src string) *model.Graph {
+	t.Helper()
+	g, err := dot.Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return g
+}
+
+func rulesNamed(diags []Diagnostic, rule string) []Diagnostic {
+	var out []Diagnostic
+	for _, d := range diags {
+		if d.Rule == rule {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func TestRoutingRule_ReportsOutcomesWithNoEdge(t *testing.T) {
+	g := parseAnalysisGraph(t, `
+digraph G {
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  impl [shape=box, llm_provider=openai, prompt="Set outcome=blocked when stuck"]
+  fix [shape=box, llm_provider=openai, prompt="fix"]
+  start -> impl
+  impl -> exit [condition="outcome=success"]
+  impl -> fix [condition="outcome=fail && context.failure_class=transient_infra"]
+  fix -> impl
+}
+`)
+	diags := rulesNamed(RoutingRule{}.Apply(g), "routing_complete")
+	if len(diags) != 2 {
+		t.Fatalf("diags=%+v", diags)
+	}
+	if d := diags[0]; d.Attr != "prompt" || !strings.Contains(d.Message, "outcome=blocked") {
+		t.Fatalf("prompt outcome diag=%+v", d)
+	}
+	gap := diags[1].Message
+	if !strings.Contains(gap, "outcome=fail && context.failure_class=deterministic") || strings.Contains(gap, "transient_infra") || strings.Contains(gap, "outcome=success") {
+		t.Fatalf("gap message=%q", gap)
+	}
+	if !strings.Contains(gap, "falls back to impl -> exit") {
+		t.Fatalf("gap message=%q should name the fallback edge", gap)
+	}
+}
+
+func TestRoutingRule_UnconditionalFallbackAndContextConditionsRoute(t *testing.T) {
+	g := parseAnalysisGraph(t, `
+digraph G {
+  graph [max_node_visits=3]
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  a [shape=box, llm_provider=openai, prompt="a"]
+  b [shape=box, llm_provider=openai, prompt="b"]
+  gate [shape=diamond]
+  start -> a
+  a -> b [condition="context.ready=true"]
+  a -> gate
+  b -> gate
+  gate -> exit [condition="outcome=success"]
+  gate -> exit [condition="outcome=fail"]
+}
+`)
+	assertNoRule(t, Validate(g), "routing_complete")
+}
+
+func TestRoutingRule_DeadEndStage(t *testing.T) {
+	g := parseAnalysisGraph(t, `
+digraph G {
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  a [shape=box, llm_provider=openai, prompt="a"]
+  b [shape=box, llm_provider=openai, prompt="b"]
+  start -> a
+  a -> exit [condition="outcome=success"]
+  a -> b [condition="outcome=fail"]
+}
+`)
+	diags := rulesNamed(Validate(g), "routing_complete")
+	if len(diags) != 1 || diags[0].NodeID != "b" || !strings.Contains(diags[0].Message, "no outgoing edges") {
+		t.Fatalf("diags=%+v", diags)
+	}
+}
+
+func TestCycleBoundRule_UnboundedLoopWarns(t *testing.T) {
+	src := `
+digraph G {
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  impl [shape=box, llm_provider=openai, prompt="impl"]
+  check [shape=box, llm_provider=openai, prompt="check"]
+  start -> impl -> check
+  check -> exit [condition="outcome=success"]
+  check -> impl
+}
+`
+	diags := rulesNamed(Validate(parseAnalysisGraph(t, src)), "cycle_bounded")
+	if len(diags) != 1 || diags[0].Severity != SeverityWarning || diags[0].EdgeFrom != "check" || diags[0].EdgeTo != "impl" {
+		t.Fatalf("diags=%+v", diags)
+	}
+	if !strings.Contains(diags[0].Message, "impl, check") {
+		t.Fatalf("message=%q", diags[0].Message)
+	}
+
+	for name, edge := range map[string]string{
+		"max_node_visits": "  check -> impl\n  graph [max_node_visits=4]\n",
+		"goal_gate":       "  check -> impl\n  check [goal_gate=true, retry_target=impl]\n",
+		"loop_restart":    "  check -> impl [loop_restart=true]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := parseAnalysisGraph(t, strings.Replace(src, "  check -> impl\n", edge, 1))
+			assertNoRule(t, Validate(g), "cycle_bounded")
+		})
+	}
+}
+
+func TestCycleBoundRule_LoopWithNoWayOutIsAnError(t *testing.T) {
+	g := parseAnalysisGraph(t, `
+digraph G {
+  graph [max_node_visits=5]
+  start [shape=Mdiamond]
+  exit [shape=Msquare]
+  a [shape=box, llm_provider=openai, prompt="a"]
+  b [shape=box, llm_provider=openai, prompt="b"]
+  start -> a
+  start -> exit [condition="outcome=fail"]
+  a -> b
+  b -> a
+}
+`)
+	diags := Validate(g)
+	assertHasRule(t, diags, "cycle_bounded", SeverityError)
+	assertHasRule(t, diags, "exit_reachable", SeverityError)
+}
+
+func TestExitReachableRule_ExitNoOutcomeSelects(t *testing.T) {
+	g := parseAnalysisGraph(t, `
+digraph G {
+  graph [max_node_visits=5]
+  start [shape=Mdiamond]
+  done [shape=Msquare]
+  abandoned [shape=Msquare]
+  work [shape=parallelogram, tool_command="make"]
+  start -> work
+  work -> done [condition="outcome=success"]
+  work -> work [condition="outcome=fail"]
+  work -> abandoned
+}
+`)
+	diags := rulesNamed(Validate(g), "exit_reachable")
+	if len(diags) != 1 || diags[0].NodeID != "abandoned" || diags[0].Severity != SeverityWarning {
+		t.Fatalf("diags=%+v", diags)
+	}
+}
+
+func TestMatchCondition_DecidesOutcomeLabelAndFailureClass(t *testing.T) {
+	fail := outcomeClass{status: "fail"}
+	transient := outcomeClass{status: "fail", failureClass: "transient_infra"}
+	cases := []struct {
+		cond string
+		c    outcomeClass
+		want match
+	}{
+		{"outcome=success", outcomeClass{status: "success"}, mustMatch},
+		{"outcome=failure", fail, mustMatch},
+		{"outcome!=success", fail, mustMatch},
+		{"outcome=success", fail, noMatch},
+		{"outcome=fail && context.failure_class=transient_infra", fail, mayMatch},
+		{"outcome=fail && context.failure_class=transient_infra", transient, mustMatch},
+		{"context.failure_class!=transient_infra", transient, noMatch},
+		{"outcome=fail && context.tests=red", fail, mayMatch},
+		{"preferred_label=Approve", outcomeClass{status: "success", label: "Approve"}, mustMatch},
+	}
+	for _, tc := range cases {
+		if got := matchCondition(tc.cond, tc.c); got != tc.want {
+			t.Errorf("matchCondition(%q, %v)=%d want %d", tc.cond, tc.c, got, tc.want)
+		}
+	}
+}
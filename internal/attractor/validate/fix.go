@@ -0,0 +1,247 @@
+package validate
+
+import (
+	"strings"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// Rules attach Edits to a diagnostic only when the fix is unambiguous; the
+// edits are built from the source spans the parser recorded, so they keep
+// the rest of the file (layout, comments) as written.
+
+// ApplyFixes applies to src the edits of diags that belong to file. A
+// diagnostic's edits apply together or not at all; one whose edits overlap
+// an earlier diagnostic's is left for the next pass (re-validate and call
+// ApplyFixes again). It returns the new source and the diagnostics fixed.
+func ApplyFixes(src []byte, file string, diags []Diagnostic) ([]byte, []Diagnostic, error) {
+	var chosen []dot.Edit
+	var fixed []Diagnostic
+next:
+	for _, d := range diags {
+		if len(d.Edits) == 0 {
+			continue
+		}
+		for _, e := range d.Edits {
+			if e.Span.File != file {
+				continue next
+			}
+			for _, c := range chosen {
+				if e.Overlaps(c) {
+					continue next
+				}
+			}
+		}
+		chosen = append(chosen, d.Edits...)
+		fixed = append(fixed, d)
+	}
+	if len(chosen) == 0 {
+		return src, nil, nil
+	}
+	out, err := dot.ApplyEdits(src, chosen)
+	if err != nil {
+		return src, nil, err
+	}
+	return out, fixed, nil
+}
+
+// setNodeAttr returns the edits that set key=value in the last statement
+// declaring n, whose attrs win over earlier ones: replacing the pair when
+// that statement sets key, else adding it to the statement's attr list (or
+// giving the statement one).
+func setNodeAttr(n *model.Node, key, value string) []dot.Edit {
+	if n == nil || n.Span.IsZero() {
+		return nil
+	}
+	stmt, list := n.Span, n.AttrList
+	if !n.LastSpan.IsZero() {
+		stmt, list = n.LastSpan, n.LastAttrList
+	}
+	pair := key + "=" + dot.Value(value)
+	if s, ok := n.AttrSpans[key]; ok && within(s, stmt) {
+		return []dot.Edit{{Span: s, NewText: pair}}
+	}
+	return []dot.Edit{insertAttr(stmt, list, n.AttrSpans, pair)}
+}
+
+// setEdgeAttr is setNodeAttr for an edge. Edges of a chained statement
+// (a -> b -> c) share their attr list, so they are never edited.
+func setEdgeAttr(g *model.Graph, e *model.Edge, key, value string) []dot.Edit {
+	if e == nil || e.Span.IsZero() || chained(g, e) {
+		return nil
+	}
+	pair := key + "=" + dot.Value(value)
+	if s, ok := e.AttrSpans[key]; ok && within(s, e.AttrList) {
+		return []dot.Edit{{Span: s, NewText: pair}}
+	}
+	return []dot.Edit{insertAttr(e.Span, e.AttrList, e.AttrSpans, pair)}
+}
+
+// retargetEdge returns the edit that points e at to instead.
+func retargetEdge(g *model.Graph, e *model.Edge, to string) []dot.Edit {
+	if e == nil || e.Span.IsZero() || chained(g, e) {
+		return nil
+	}
+	return []dot.Edit{{Span: e.Span, NewText: dot.Value(e.From) + " -> " + dot.Value(to)}}
+}
+
+// addEdge returns the edit that adds "from -> to [k=v, ...]" as the last
+// statement of the graph. attrs alternates keys and values.
+func addEdge(g *model.Graph, from, to string, attrs ...string) []dot.Edit {
+	if g == nil || g.Span.IsZero() {
+		return nil
+	}
+	stmt := "  " + dot.Value(from) + " -> " + dot.Value(to)
+	var pairs []string
+	for i := 0; i+1 < len(attrs); i += 2 {
+		pairs = append(pairs, attrs[i]+"="+dot.Value(attrs[i+1]))
+	}
+	if len(pairs) > 0 {
+		stmt += " [" + strings.Join(pairs, ", ") + "]"
+	}
+	closing := model.Position{Line: g.Span.End.Line, Column: g.Span.End.Column - 1}
+	if closing.Column > 1 {
+		stmt = "\n" + stmt
+	}
+	return []dot.Edit{dot.Insert(g.Span.File, closing, stmt+"\n")}
+}
+
+// insertAttr adds pair after the last attr of list, or into an empty list,
+// or as a new list at the end of stmt when there is none.
+func insertAttr(stmt, list model.Span, spans map[string]model.Span, pair string) dot.Edit {
+	if list.IsZero() {
+		return dot.Insert(stmt.File, stmt.End, " ["+pair+"]")
+	}
+	var last model.Span
+	for _, s := range spans {
+		if within(s, list) && (last.IsZero() || posBefore(last.End, s.End)) {
+			last = s
+		}
+	}
+	if !last.IsZero() {
+		return dot.Insert(list.File, last.End, ", "+pair)
+	}
+	return dot.Insert(list.File, model.Position{Line: list.End.Line, Column: list.End.Column - 1}, pair)
+}
+
+// chained reports whether e shares its statement with another edge.
+func chained(g *model.Graph, e *model.Edge) bool {
+	for _, o := range g.Edges {
+		if o == nil || o == e || o.Span.File != e.Span.File {
+			continue
+		}
+		if o.Span.Start == e.Span.Start || inside(o.Span.Start, e.Span) || inside(e.Span.Start, o.Span) {
+			return true
+		}
+		if !e.AttrList.IsZero() && o.AttrList == e.AttrList {
+			return true
+		}
+	}
+	return false
+}
+
+func within(inner, outer model.Span) bool {
+	if inner.IsZero() || outer.IsZero() || inner.File != outer.File {
+		return false
+	}
+	return !posBefore(inner.Start, outer.Start) && !posBefore(outer.End, inner.End)
+}
+
+// inside reports whether p lies strictly within s.
+func inside(p model.Position, s model.Span) bool {
+	return posBefore(s.Start, p) && posBefore(p, s.End)
+}
+
+func posBefore(a, b model.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// solePredecessor returns the one stage (other than the start node) with an
+// edge into id, or "". Only stages that run before id on a path from start
+// count, so loop-back edges from downstream stages are ignored.
+func solePredecessor(g *model.Graph, id string) string {
+	before := reachableAvoiding(g, findAllStartNodeIDs(g), id)
+	pred := ""
+	for _, e := range g.Incoming(id) {
+		if e == nil || e.From == id || !before[e.From] || handlerType(g.Nodes[e.From]) == "start" {
+			continue
+		}
+		if pred != "" && pred != e.From {
+			return ""
+		}
+		pred = e.From
+	}
+	if g.Nodes[pred] == nil {
+		return ""
+	}
+	return pred
+}
+
+// reachableAvoiding returns the nodes reachable from the given roots without
+// passing through avoid.
+func reachableAvoiding(g *model.Graph, roots []string, avoid string) map[string]bool {
+	seen := map[string]bool{}
+	var queue []string
+	for _, id := range roots {
+		if id != avoid && !seen[id] {
+			seen[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Outgoing(cur) {
+			if e == nil || e.To == avoid || seen[e.To] {
+				continue
+			}
+			seen[e.To] = true
+			queue = append(queue, e.To)
+		}
+	}
+	return seen
+}
+
+// guardTransient narrows a failure condition to transient infrastructure
+// failures.
+func guardTransient(condExpr string) string {
+	if strings.TrimSpace(condExpr) == "" {
+		condExpr = "outcome=fail"
+	}
+	return condExpr + " && context.failure_class=transient_infra"
+}
+
+// hasForwardFailureRoute reports whether the source of the failure
+// back-edge loop has another edge, not looping back, that failures can
+// take: an unconditional edge or one routing fail.
+func hasForwardFailureRoute(g *model.Graph, loop *model.Edge) bool {
+	for _, e := range g.Outgoing(loop.From) {
+		if e == nil || e == loop || graphReachable(g, e.To, loop.From) {
+			continue
+		}
+		c := strings.TrimSpace(e.Condition())
+		if c == "" || (conditionRoutesFailOutcome(c) && !conditionHasTransientInfraGuard(c)) {
+			return true
+		}
+	}
+	return false
+}
+
+// nearest returns the one candidate within two edits of name, or "" when
+// there is none or the closest are tied.
+func nearest(name string, candidates []string) string {
+	best, bestDist, tied := "", 3, false
+	for _, c := range candidates {
+		switch d := editDistance(name, c); {
+		case d < bestDist:
+			best, bestDist, tied = c, d, false
+		case d == bestDist:
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return best
+}
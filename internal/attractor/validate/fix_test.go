@@ -0,0 +1,128 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+)
+
+func fixOnce(t *testing.T, src string) (string, []Diagnostic) {
+	t.Helper()
+	g, err := dot.ParseFile("g.dot", []byte(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, fixed, err := ApplyFixes([]byte(src), "g.dot", Validate(g))
+	if err != nil {
+		t.Fatalf("ApplyFixes: %v", err)
+	}
+	if _, err := dot.ParseFile("g.dot", out); err != nil {
+		t.Fatalf("fixed source does not parse: %v\n%s", err, out)
+	}
+	return string(out), fixed
+}
+
+func TestApplyFixes_EditsInPlaceAndKeepsComments(t *testing.T) {
+	src := `digraph G {
+  // The plan.
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl  [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="do it"]
+  check [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="check", goal_gate=true, retry_target=implx] // typo
+  start -> impl -> check -> exit
+}
+`
+	out, fixed := fixOnce(t, src)
+	if !strings.Contains(out, "retry_target=impl] // typo") {
+		t.Fatalf("retry_target not fixed in place:\n%s", out)
+	}
+	if !strings.Contains(out, "  // The plan.\n") {
+		t.Fatalf("comment lost:\n%s", out)
+	}
+	if len(fixed) != 1 || fixed[0].Rule != "retry_target_exists" {
+		t.Fatalf("fixed: %+v", fixed)
+	}
+}
+
+func TestApplyFixes_EditsLastDeclarationOfRedeclaredNode(t *testing.T) {
+	src := `digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl  [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="do it"]
+  check [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="check", goal_gate=true]
+  check [retry_target=implx]
+  start -> impl -> check -> exit
+}
+`
+	out, fixed := fixOnce(t, src)
+	if !strings.Contains(out, "  check [retry_target=impl]\n") || strings.Count(out, "retry_target") != 1 {
+		t.Fatalf("redeclaration not fixed in place:\n%s", out)
+	}
+	if len(fixed) != 1 || fixed[0].Rule != "retry_target_exists" {
+		t.Fatalf("fixed: %+v", fixed)
+	}
+	g, err := dot.ParseFile("g.dot", []byte(out))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, d := range Validate(g) {
+		if d.Rule == "retry_target_exists" {
+			t.Fatalf("diagnostic survived the fix: %+v", d)
+		}
+	}
+}
+
+func TestApplyFixes_GoalGateGetsSolePredecessorAsRetryTarget(t *testing.T) {
+	src := `digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl  [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="do it"]
+  check [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="check", goal_gate=true]
+  start -> impl -> check -> exit
+}
+`
+	out, _ := fixOnce(t, src)
+	if !strings.Contains(out, `goal_gate=true, retry_target=impl]`) {
+		t.Fatalf("retry_target not added:\n%s", out)
+	}
+}
+
+func TestApplyFixes_GoalGateIgnoresLoopBackPredecessors(t *testing.T) {
+	src := `digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  impl  [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="do it", goal_gate=true]
+  check [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="check"]
+  start -> impl -> check
+  check -> impl [condition="outcome=fail"]
+  check -> exit [condition="outcome=success"]
+}
+`
+	out, _ := fixOnce(t, src)
+	if strings.Contains(out, "retry_target") {
+		t.Fatalf("retry_target must not point downstream of the gate:\n%s", out)
+	}
+}
+
+func TestApplyFixes_DoesNotEditChainedEdges(t *testing.T) {
+	src := `digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="a"]
+  b [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="b"]
+  start -> a
+  a -> b -> a [loop_restart=true, condition="outcome=fail"]
+  b -> exit
+}
+`
+	g, err := dot.ParseFile("g.dot", []byte(src))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for _, e := range g.Edges {
+		if edits := setEdgeAttr(g, e, "condition", "x"); e.From != "start" && e.To != "exit" && edits != nil {
+			t.Fatalf("edge %s -> %s is chained but got edits %+v", e.From, e.To, edits)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+// Report formats accepted by WriteReport.
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+)
+
+// ParseErrorDiagnostic converts an error from dot.Parse into an error
+// diagnostic, located at the offending token when the error carries one.
+func ParseErrorDiagnostic(err error) Diagnostic {
+	d := Diagnostic{Rule: "dot_syntax", Severity: SeverityError, Message: err.Error()}
+	var se *dot.SyntaxError
+	if errors.As(err, &se) {
+		d.Message = se.Msg
+		d.Span = se.Span
+	}
+	return d
+}
+
+// HasErrors reports whether any diagnostic is error severity.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteReport writes diags for the graph in file using format
+// (text, json or sarif). An empty format means text.
+func WriteReport(w io.Writer, format string, file string, diags []Diagnostic) error {
+	switch format {
+	case "", FormatText:
+		return writeText(w, diags)
+	case FormatJSON:
+		return writeJSON(w, file, diags)
+	case FormatSARIF:
+		return writeSARIF(w, diags)
+	default:
+		return fmt.Errorf("unknown format %q (want text|json|sarif)", format)
+	}
+}
+
+// writeText prints one "file:line:col: SEVERITY: message (rule)" line per
+// diagnostic, the shape compilers use so editors can jump to the location.
+func writeText(w io.Writer, diags []Diagnostic) error {
+	for _, d := range diags {
+		loc := ""
+		if !d.Span.IsZero() {
+			loc = d.Span.String() + ": "
+		}
+		if _, err := fmt.Fprintf(w, "%s%s: %s (%s)\n", loc, d.Severity, d.Message, d.Rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, file string, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{
+		"file":        file,
+		"ok":          !HasErrors(diags),
+		"diagnostics": diags,
+	})
+}
+
+// writeSARIF emits a SARIF 2.1.0 log, the format CI code-scanning uploads
+// accept for inline pull request annotations.
+func writeSARIF(w io.Writer, diags []Diagnostic) error {
+	src := sarifSources{}
+	ruleSet := map[string]bool{}
+	results := make([]map[string]any, 0, len(diags))
+	for _, d := range diags {
+		ruleSet[d.Rule] = true
+		res := map[string]any{
+			"ruleId":  d.Rule,
+			"level":   sarifLevel(d.Severity),
+			"message": map[string]any{"text": d.Message},
+		}
+		if !d.Span.IsZero() {
+			res["locations"] = []any{map[string]any{
+				"physicalLocation": map[string]any{
+					"artifactLocation": map[string]any{"uri": filepath.ToSlash(d.Span.File)},
+					"region":           src.region(d.Span),
+				},
+			}}
+		}
+		if d.Fix != "" {
+			res["properties"] = map[string]any{"fix": d.Fix}
+		}
+		if len(d.Edits) > 0 {
+			res["fixes"] = []any{sarifFix(src, d)}
+		}
+		results = append(results, res)
+	}
+	ruleIDs := make([]string, 0, len(ruleSet))
+	for id := range ruleSet {
+		ruleIDs = append(ruleIDs, id)
+	}
+	sort.Strings(ruleIDs)
+	rules := make([]map[string]any, 0, len(ruleIDs))
+	for _, id := range ruleIDs {
+		rules = append(rules, map[string]any{"id": id})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{"driver": map[string]any{
+				"name":           "kilroy",
+				"informationUri": "https://github.com/danshapiro/kilroy",
+				"rules":          rules,
+			}},
+			"columnKind": "unicodeCodePoints",
+			"results":    results,
+		}},
+	})
+}
+
+// sarifSources holds the lines of each file diagnostics point into, read
+// once, so byte columns can be reported as the code point columns SARIF
+// consumers count. A file that cannot be read keeps byte columns.
+type sarifSources map[string][]string
+
+func (src sarifSources) region(s model.Span) map[string]any {
+	region := map[string]any{
+		"startLine":   s.Start.Line,
+		"startColumn": src.column(s.File, s.Start),
+	}
+	if !s.End.IsZero() {
+		region["endLine"] = s.End.Line
+		region["endColumn"] = src.column(s.File, s.End)
+	}
+	return region
+}
+
+func (src sarifSources) column(file string, p model.Position) int {
+	lines, ok := src[file]
+	if !ok {
+		if b, err := os.ReadFile(file); err == nil {
+			lines = strings.Split(string(b), "\n")
+		}
+		src[file] = lines
+	}
+	if p.Line < 1 || p.Line > len(lines) {
+		return p.Column
+	}
+	line := lines[p.Line-1]
+	b := min(max(p.Column-1, 0), len(line))
+	return utf8.RuneCountInString(line[:b]) + 1 + max(p.Column-1-len(line), 0)
+}
+
+// sarifFix renders a diagnostic's edits as a SARIF fix, one artifact
+// change per file; an insertion deletes an empty region.
+func sarifFix(src sarifSources, d Diagnostic) map[string]any {
+	var files []string
+	byFile := map[string][]any{}
+	for _, e := range d.Edits {
+		if _, ok := byFile[e.Span.File]; !ok {
+			files = append(files, e.Span.File)
+		}
+		byFile[e.Span.File] = append(byFile[e.Span.File], map[string]any{
+			"deletedRegion":   src.region(e.Span),
+			"insertedContent": map[string]any{"text": e.NewText},
+		})
+	}
+	changes := make([]any, 0, len(files))
+	for _, f := range files {
+		changes = append(changes, map[string]any{
+			"artifactLocation": map[string]any{"uri": filepath.ToSlash(f)},
+			"replacements":     byFile[f],
+		})
+	}
+	return map[string]any{
+		"description":     map[string]any{"text": d.Fix},
+		"artifactChanges": changes,
+	}
+}
+
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
@@ -0,0 +1,188 @@
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
+	"github.com/danshapiro/kilroy/internal/attractor/model"
+)
+
+func TestValidate_LocatesDiagnosticsInSource(t *testing.T) {
+	g, err := dot.ParseFile("pipelines/p.dot", []byte(`digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="x", fidelity=bogus]
+  orphan [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="y"]
+  start -> a
+  a -> exit [condition="outcome="]
+}
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := map[string]model.Span{}
+	for _, d := range Validate(g) {
+		if d.Span.IsZero() {
+			t.Fatalf("diagnostic without span: %+v", d)
+		}
+		got[d.Rule] = d.Span
+	}
+	want := map[string]string{
+		"fidelity_valid":   "pipelines/p.dot:4:69", // the fidelity=bogus pair
+		"reachability":     "pipelines/p.dot:5:3",  // the orphan node statement
+		"condition_syntax": "pipelines/p.dot:7:14", // the condition pair on the edge
+	}
+	for rule, loc := range want {
+		if got[rule].String() != loc {
+			t.Fatalf("%s: got %s want %s (all %+v)", rule, got[rule], loc, got)
+		}
+	}
+}
+
+func TestWriteReport_TextJSONAndSARIF(t *testing.T) {
+	span := model.Span{File: "p.dot", Start: model.Position{Line: 4, Column: 3}, End: model.Position{Line: 4, Column: 9}}
+	diags := []Diagnostic{
+		{Rule: "reachability", Severity: SeverityError, Message: "node unreachable", NodeID: "a", Span: span,
+			Fix: "rename it", Edits: []dot.Edit{{Span: span, NewText: "b"}}},
+		{Rule: "start_node", Severity: SeverityWarning, Message: "no position"},
+	}
+
+	var text bytes.Buffer
+	if err := WriteReport(&text, FormatText, "p.dot", diags); err != nil {
+		t.Fatal(err)
+	}
+	if text.String() != "p.dot:4:3: ERROR: node unreachable (reachability)\nWARNING: no position (start_node)\n" {
+		t.Fatalf("text:\n%s", text.String())
+	}
+
+	var js bytes.Buffer
+	if err := WriteReport(&js, FormatJSON, "p.dot", diags); err != nil {
+		t.Fatal(err)
+	}
+	var report struct {
+		OK          bool         `json:"ok"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(js.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.OK || len(report.Diagnostics) != 2 || report.Diagnostics[0].Span != span || strings.Contains(js.String(), `"line": 0`) {
+		t.Fatalf("json:\n%s", js.String())
+	}
+
+	var sarif bytes.Buffer
+	if err := WriteReport(&sarif, FormatSARIF, "p.dot", diags); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine   int `json:"startLine"`
+							StartColumn int `json:"startColumn"`
+							EndColumn   int `json:"endColumn"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+				Fixes []struct {
+					ArtifactChanges []struct {
+						Replacements []struct {
+							InsertedContent struct {
+								Text string `json:"text"`
+							} `json:"insertedContent"`
+						} `json:"replacements"`
+					} `json:"artifactChanges"`
+				} `json:"fixes"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(sarif.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	res := log.Runs[0].Results
+	if log.Version != "2.1.0" || len(res) != 2 || res[0].Level != "error" || res[1].Level != "warning" || len(res[1].Locations) != 0 {
+		t.Fatalf("sarif:\n%s", sarif.String())
+	}
+	loc := res[0].Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "p.dot" || loc.Region.StartLine != 4 || loc.Region.StartColumn != 3 || loc.Region.EndColumn != 9 {
+		t.Fatalf("sarif location: %+v", loc)
+	}
+	if len(res[0].Fixes) != 1 || res[0].Fixes[0].ArtifactChanges[0].Replacements[0].InsertedContent.Text != "b" || len(res[1].Fixes) != 0 {
+		t.Fatalf("sarif fixes:\n%s", sarif.String())
+	}
+
+	if err := WriteReport(&text, "xml", "p.dot", diags); err == nil {
+		t.Fatalf("expected unknown format error")
+	}
+}
+
+func TestWriteReport_SARIFColumnsCountCodePoints(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "p.dot")
+	if err := os.WriteFile(file, []byte("digraph G {\n  a [label=\"→→→\", x=1]\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// x=1 spans bytes 25-28 of line 2 but characters 19-22.
+	span := model.Span{File: file, Start: model.Position{Line: 2, Column: 25}, End: model.Position{Line: 2, Column: 28}}
+	diags := []Diagnostic{{Rule: "r", Severity: SeverityWarning, Message: "m", Span: span,
+		Fix: "drop it", Edits: []dot.Edit{{Span: span}}}}
+	var sarif bytes.Buffer
+	if err := WriteReport(&sarif, FormatSARIF, file, diags); err != nil {
+		t.Fatal(err)
+	}
+	type region struct {
+		StartColumn int `json:"startColumn"`
+		EndColumn   int `json:"endColumn"`
+	}
+	var log struct {
+		Runs []struct {
+			ColumnKind string `json:"columnKind"`
+			Results    []struct {
+				Locations []struct {
+					PhysicalLocation struct {
+						Region region `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+				Fixes []struct {
+					ArtifactChanges []struct {
+						Replacements []struct {
+							DeletedRegion region `json:"deletedRegion"`
+						} `json:"replacements"`
+					} `json:"artifactChanges"`
+				} `json:"fixes"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(sarif.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	res := log.Runs[0].Results[0]
+	want := region{StartColumn: 19, EndColumn: 22}
+	if log.Runs[0].ColumnKind != "unicodeCodePoints" || res.Locations[0].PhysicalLocation.Region != want ||
+		res.Fixes[0].ArtifactChanges[0].Replacements[0].DeletedRegion != want {
+		t.Fatalf("sarif:\n%s", sarif.String())
+	}
+}
+
+func TestParseErrorDiagnostic_CarriesSyntaxErrorSpan(t *testing.T) {
+	_, err := dot.ParseFile("p.dot", []byte("digraph G {\n  a -- b\n}\n"))
+	if err == nil {
+		t.Fatal("expected parse error")
+	}
+	d := ParseErrorDiagnostic(err)
+	if d.Rule != "dot_syntax" || d.Severity != SeverityError || d.Span.String() != "p.dot:2:5" || strings.Contains(d.Message, " at ") {
+		t.Fatalf("diagnostic: %+v", d)
+	}
+}
@@ -1,14 +1,20 @@
 package validate
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/danshapiro/kilroy/internal/attractor/cond"
+	"github.com/danshapiro/kilroy/internal/attractor/dot"
 	"github.com/danshapiro/kilroy/internal/attractor/model"
 	"github.com/danshapiro/kilroy/internal/attractor/runtime"
 	"github.com/danshapiro/kilroy/internal/attractor/style"
+	"github.com/danshapiro/kilroy/internal/attractor/tmpl"
+	"github.com/danshapiro/kilroy/internal/llm"
 )
 
 type Severity string
@@ -26,7 +32,13 @@ type Diagnostic struct {
 	NodeID   string   `json:"node_id,omitempty"`
 	EdgeFrom string   `json:"edge_from,omitempty"`
 	EdgeTo   string   `json:"edge_to,omitempty"`
-	Fix      string   `json:"fix,omitempty"`
+	// Attr names the attribute the diagnostic is about, when there is one;
+	// it narrows Span to that attribute's "key=value" pair.
+	Attr string     `json:"attr,omitempty"`
+	Span model.Span `json:"span,omitzero"`
+	Fix  string     `json:"fix,omitempty"`
+	// Edits apply Fix to the DOT source, when it is machine-applicable.
+	Edits []dot.Edit `json:"edits,omitempty"`
 }
 
 // LintRule is the interface for custom lint rules that can be passed to
@@ -52,6 +64,7 @@ func Validate(g *model.Graph, extraRules ...LintRule) []Diagnostic {
 	diags = append(diags, lintReachability(g)...)
 	diags = append(diags, lintConditionSyntax(g)...)
 	diags = append(diags, lintStylesheetSyntax(g)...)
+	diags = append(diags, lintStylesheetProperties(g)...)
 	diags = append(diags, lintRetryTargetsExist(g)...)
 	diags = append(diags, lintGoalGateHasRetry(g)...)
 	diags = append(diags, lintGoalGateExitStatusContract(g)...)
@@ -60,13 +73,21 @@ func Validate(g *model.Graph, extraRules ...LintRule) []Diagnostic {
 	diags = append(diags, lintPromptOnCodergenNodes(g)...)
 	diags = append(diags, lintPromptOnConditionalNodes(g)...)
 	diags = append(diags, lintPromptFileConflict(g)...)
+	diags = append(diags, lintPromptTemplates(g)...)
 	diags = append(diags, lintToolCommandRequired(g)...)
 	diags = append(diags, lintLLMProviderPresent(g)...)
+	diags = append(diags, lintLLMModeValid(g)...)
+	diags = append(diags, lintAgentToolAttrs(g)...)
+	diags = append(diags, lintToolPolicyAttrs(g)...)
+	diags = append(diags, lintSubagentAttrs(g)...)
 	diags = append(diags, lintLoopRestartFailureClassGuard(g)...)
 	diags = append(diags, lintFailLoopFailureClassGuard(g)...)
 	diags = append(diags, lintEscalationModelsSyntax(g)...)
 	diags = append(diags, lintAllConditionalEdges(g)...)
 	diags = append(diags, lintTemplatePostmortemRecoveryRouting(g)...)
+	for _, rule := range AnalysisRules() {
+		diags = append(diags, rule.Apply(g)...)
+	}
 
 	// Run custom lint rules (spec §7.3: extra_rules appended after built-in rules).
 	for _, rule := range extraRules {
@@ -74,9 +95,46 @@ func Validate(g *model.Graph, extraRules ...LintRule) []Diagnostic {
 			diags = append(diags, rule.Apply(g)...)
 		}
 	}
+	Locate(g, diags)
 	return diags
 }
 
+// Locate fills in Span for diagnostics that do not carry one, using the
+// source positions the parser recorded: the named attr when set, else the
+// edge, node, or graph statement the diagnostic refers to.
+func Locate(g *model.Graph, diags []Diagnostic) {
+	if g == nil {
+		return
+	}
+	for i := range diags {
+		d := &diags[i]
+		if !d.Span.IsZero() {
+			continue
+		}
+		d.Span = locate(g, *d)
+	}
+}
+
+func locate(g *model.Graph, d Diagnostic) model.Span {
+	attrSpan := func(spans map[string]model.Span, fallback model.Span) model.Span {
+		if s, ok := spans[d.Attr]; ok && d.Attr != "" {
+			return s
+		}
+		return fallback
+	}
+	if d.EdgeFrom != "" || d.EdgeTo != "" {
+		for _, e := range g.Edges {
+			if e != nil && e.From == d.EdgeFrom && e.To == d.EdgeTo {
+				return attrSpan(e.AttrSpans, e.Span)
+			}
+		}
+	}
+	if n := g.Nodes[d.NodeID]; n != nil && d.NodeID != "" {
+		return attrSpan(n.AttrSpans, n.Span)
+	}
+	return attrSpan(g.AttrSpans, g.Span)
+}
+
 func ValidateOrError(g *model.Graph, extraRules ...LintRule) error {
 	diags := Validate(g, extraRules...)
 	var errs []string
@@ -309,6 +367,7 @@ func lintConditionSyntax(g *model.Graph) []Diagnostic {
 				Message:  err.Error(),
 				EdgeFrom: e.From,
 				EdgeTo:   e.To,
+				Attr:     "condition",
 			})
 			continue
 		}
@@ -410,11 +469,69 @@ func lintStylesheetSyntax(g *model.Graph) []Diagnostic {
 			Rule:     "stylesheet_syntax",
 			Severity: SeverityError,
 			Message:  err.Error(),
+			Attr:     "model_stylesheet",
 		}}
 	}
 	return nil
 }
 
+// lintStylesheetProperties reports declarations of properties the
+// stylesheet cannot set; they would otherwise be silently ignored.
+func lintStylesheetProperties(g *model.Graph) []Diagnostic {
+	raw := strings.TrimSpace(g.Attrs["model_stylesheet"])
+	if raw == "" {
+		return nil
+	}
+	rules, err := style.ParseStylesheet(raw)
+	if err != nil {
+		return nil // reported by stylesheet_syntax
+	}
+	var diags []Diagnostic
+	for _, r := range rules {
+		props := make([]string, 0, len(r.Decls))
+		for prop := range r.Decls {
+			props = append(props, prop)
+		}
+		sort.Strings(props)
+		for _, prop := range props {
+			if style.IsKnownProperty(prop) {
+				continue
+			}
+			d := Diagnostic{
+				Rule:     "stylesheet_property",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("stylesheet rule %q sets unknown property %q", r.Selector, prop),
+				Attr:     "model_stylesheet",
+			}
+			if near := nearest(prop, style.KnownProperties); near != "" {
+				d.Fix = fmt.Sprintf("did you mean %q?", near)
+			}
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
 func lintRetryTargetsExist(g *model.Graph) []Diagnostic {
 	var diags []Diagnostic
 	for id, n := range g.Nodes {
@@ -427,12 +544,18 @@ func lintRetryTargetsExist(g *model.Graph) []Diagnostic {
 				continue
 			}
 			if _, ok := g.Nodes[t]; !ok {
-				diags = append(diags, Diagnostic{
+				d := Diagnostic{
 					Rule:     "retry_target_exists",
 					Severity: SeverityWarning,
 					Message:  fmt.Sprintf("%s references missing node %q", k, t),
 					NodeID:   id,
-				})
+					Attr:     k,
+				}
+				if near := nearest(t, g.AllNodeIDs()); near != "" {
+					d.Fix = fmt.Sprintf("set %s=%s", k, near)
+					d.Edits = setNodeAttr(n, k, near)
+				}
+				diags = append(diags, d)
 			}
 		}
 	}
@@ -448,12 +571,19 @@ func lintGoalGateHasRetry(g *model.Graph) []Diagnostic {
 		if strings.EqualFold(n.Attr("goal_gate", "false"), "true") {
 			if strings.TrimSpace(n.Attr("retry_target", "")) == "" && strings.TrimSpace(n.Attr("fallback_retry_target", "")) == "" &&
 				strings.TrimSpace(g.Attrs["retry_target"]) == "" && strings.TrimSpace(g.Attrs["fallback_retry_target"]) == "" {
-				diags = append(diags, Diagnostic{
+				d := Diagnostic{
 					Rule:     "goal_gate_has_retry",
 					Severity: SeverityWarning,
 					Message:  "goal_gate node has no retry_target/fallback_retry_target (node or graph)",
 					NodeID:   id,
-				})
+				}
+				// With a single stage leading into the gate, that stage is
+				// the one to redo.
+				if pred := solePredecessor(g, id); pred != "" {
+					d.Fix = "set retry_target=" + pred
+					d.Edits = setNodeAttr(n, "retry_target", pred)
+				}
+				diags = append(diags, d)
 			}
 		}
 	}
@@ -601,6 +731,7 @@ func lintFidelityValid(g *model.Graph) []Diagnostic {
 				Severity: SeverityWarning,
 				Message:  fmt.Sprintf("invalid fidelity value %q", f),
 				NodeID:   id,
+				Attr:     "fidelity",
 			})
 		}
 	}
@@ -615,6 +746,7 @@ func lintFidelityValid(g *model.Graph) []Diagnostic {
 				Message:  fmt.Sprintf("invalid fidelity value %q", f),
 				EdgeFrom: e.From,
 				EdgeTo:   e.To,
+				Attr:     "fidelity",
 			})
 		}
 	}
@@ -632,12 +764,18 @@ func lintPromptOnCodergenNodes(g *model.Graph) []Diagnostic {
 			continue
 		}
 		if strings.TrimSpace(n.Prompt()) == "" {
-			diags = append(diags, Diagnostic{
+			d := Diagnostic{
 				Rule:     "prompt_on_llm_nodes",
 				Severity: SeverityWarning,
 				Message:  "codergen node has empty prompt (label will be used)",
 				NodeID:   id,
-			})
+			}
+			// Spelling out the label keeps what the stage does today.
+			if handlerType(n) == "codergen" && strings.TrimSpace(n.Attr("prompt_file", "")) == "" {
+				d.Fix = fmt.Sprintf("set prompt=%q", n.Label())
+				d.Edits = setNodeAttr(n, "prompt", n.Label())
+			}
+			diags = append(diags, d)
 		}
 	}
 	return diags
@@ -690,6 +828,213 @@ func lintLLMProviderPresent(g *model.Graph) []Diagnostic {
 	return diags
 }
 
+func lintLLMModeValid(g *model.Graph) []Diagnostic {
+	var diags []Diagnostic
+	for id, n := range g.Nodes {
+		if n == nil {
+			continue
+		}
+		mode := strings.ToLower(strings.TrimSpace(n.Attr("llm_mode", "")))
+		switch mode {
+		case "", "sync":
+			continue
+		case "batch":
+			// Batch submission is one request per stage; the agent loop needs
+			// interactive tool round-trips.
+			if cm := strings.ToLower(strings.TrimSpace(n.Attr("codergen_mode", ""))); cm != "" && cm != "one_shot" {
+				diags = append(diags, Diagnostic{
+					Rule:     "llm_mode_valid",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("llm_mode=batch requires codergen_mode=one_shot (got %q)", cm),
+					NodeID:   id,
+					Attr:     "codergen_mode",
+				})
+			}
+		default:
+			diags = append(diags, Diagnostic{
+				Rule:     "llm_mode_valid",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("invalid llm_mode value %q (want sync|batch)", mode),
+				NodeID:   id,
+				Attr:     "llm_mode",
+			})
+		}
+	}
+	return diags
+}
+
+// lintAgentToolAttrs checks agent_tool.<name>.<field> declarations on the
+// graph and nodes. The engine builds the tools when the stage starts; this
+// reports mistakes before the run.
+func lintAgentToolAttrs(g *model.Graph) []Diagnostic {
+	var diags []Diagnostic
+	check := func(nodeID string, attrs map[string]string) {
+		hasCommand := map[string]bool{}
+		keys := make([]string, 0, len(attrs))
+		for k := range attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			rest, ok := strings.CutPrefix(key, "agent_tool.")
+			if !ok {
+				continue
+			}
+			bad := func(msg string) {
+				diags = append(diags, Diagnostic{
+					Rule:     "agent_tool_valid",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("%s: %s", key, msg),
+					NodeID:   nodeID,
+					Attr:     key,
+				})
+			}
+			dot := strings.LastIndex(rest, ".")
+			if dot <= 0 {
+				bad("want agent_tool.<name>.<field>")
+				continue
+			}
+			name, field := rest[:dot], rest[dot+1:]
+			if _, seen := hasCommand[name]; !seen {
+				hasCommand[name] = false
+				if err := llm.ValidateToolName(name); err != nil {
+					bad(err.Error())
+				}
+			}
+			val := attrs[key]
+			switch field {
+			case "command":
+				hasCommand[name] = strings.TrimSpace(val) != ""
+			case "description":
+			case "parameters":
+				var schema map[string]any
+				if err := json.Unmarshal([]byte(val), &schema); err != nil {
+					bad(fmt.Sprintf("parameters must be a JSON schema object: %v", err))
+				}
+			case "timeout_ms", "max_chars", "max_lines":
+				if n, err := strconv.Atoi(strings.TrimSpace(val)); err != nil || n < 0 {
+					bad(fmt.Sprintf("%s must be a non-negative integer", field))
+				}
+			case "truncation":
+				if v := strings.ToLower(strings.TrimSpace(val)); v != "head_tail" && v != "tail" {
+					bad("truncation must be head_tail or tail")
+				}
+			default:
+				bad(fmt.Sprintf("unknown field %q (want command|description|parameters|timeout_ms|max_chars|max_lines|truncation)", field))
+			}
+		}
+		names := make([]string, 0, len(hasCommand))
+		for name := range hasCommand {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !hasCommand[name] {
+				diags = append(diags, Diagnostic{
+					Rule:     "agent_tool_valid",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("agent tool %s has no command", name),
+					NodeID:   nodeID,
+					Fix:      fmt.Sprintf("set agent_tool.%s.command=\"...\"", name),
+				})
+			}
+		}
+	}
+	check("", g.Attrs)
+	for id, n := range g.Nodes {
+		if n != nil {
+			check(id, n.Attrs)
+		}
+	}
+	return diags
+}
+
+// lintToolPolicyAttrs checks tool_policy.<field> overrides on the graph and
+// nodes. Named policies (tool_policy=<name>) live in the run config and are
+// checked when the run starts.
+func lintToolPolicyAttrs(g *model.Graph) []Diagnostic {
+	var diags []Diagnostic
+	check := func(nodeID string, attrs map[string]string) {
+		keys := make([]string, 0, len(attrs))
+		for k := range attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := strings.CutPrefix(key, "tool_policy.")
+			if !ok {
+				continue
+			}
+			msg := ""
+			switch field {
+			case "deny_tools", "deny_commands", "deny_paths":
+			case "max_writes":
+				if n, err := strconv.Atoi(strings.TrimSpace(attrs[key])); err != nil || n < 0 {
+					msg = "max_writes must be a non-negative integer"
+				}
+			default:
+				msg = fmt.Sprintf("unknown field %q (want deny_tools|deny_commands|deny_paths|max_writes)", field)
+			}
+			if msg != "" {
+				diags = append(diags, Diagnostic{
+					Rule:     "tool_policy_valid",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("%s: %s", key, msg),
+					NodeID:   nodeID,
+					Attr:     key,
+				})
+			}
+		}
+	}
+	check("", g.Attrs)
+	for id, n := range g.Nodes {
+		if n != nil {
+			check(id, n.Attrs)
+		}
+	}
+	return diags
+}
+
+// lintSubagentAttrs checks subagent_isolation and max_subagent_depth on the
+// graph and nodes.
+func lintSubagentAttrs(g *model.Graph) []Diagnostic {
+	var diags []Diagnostic
+	check := func(nodeID string, attrs map[string]string) {
+		if v, ok := attrs["subagent_isolation"]; ok {
+			switch strings.TrimSpace(v) {
+			case "shared", "worktree":
+			default:
+				diags = append(diags, Diagnostic{
+					Rule:     "subagent_attrs_valid",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("subagent_isolation=%q (want shared|worktree)", v),
+					NodeID:   nodeID,
+					Attr:     "subagent_isolation",
+					Fix:      "set subagent_isolation=shared or subagent_isolation=worktree",
+				})
+			}
+		}
+		if v, ok := attrs["max_subagent_depth"]; ok {
+			if n, err := strconv.Atoi(strings.TrimSpace(v)); err != nil || n < 1 {
+				diags = append(diags, Diagnostic{
+					Rule:     "subagent_attrs_valid",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("max_subagent_depth=%q must be a positive integer", v),
+					NodeID:   nodeID,
+					Attr:     "max_subagent_depth",
+				})
+			}
+		}
+	}
+	check("", g.Attrs)
+	for id, n := range g.Nodes {
+		if n != nil {
+			check(id, n.Attrs)
+		}
+	}
+	return diags
+}
+
 func lintToolCommandRequired(g *model.Graph) []Diagnostic {
 	var diags []Diagnostic
 	for id, n := range g.Nodes {
@@ -705,9 +1050,13 @@ func lintToolCommandRequired(g *model.Graph) []Diagnostic {
 
 		msg := "tool node missing tool_command attribute"
 		fix := "set tool_command=\"...\""
-		if strings.TrimSpace(n.Attr("command", "")) != "" {
+		var edits []dot.Edit
+		if cmd := n.Attr("command", ""); strings.TrimSpace(cmd) != "" {
 			msg = "tool node uses command attribute; expected tool_command"
 			fix = "rename command=... to tool_command=..."
+			if s, ok := n.AttrSpans["command"]; ok && within(s, n.Span) {
+				edits = []dot.Edit{{Span: s, NewText: "tool_command=" + dot.Value(cmd)}}
+			}
 		}
 
 		diags = append(diags, Diagnostic{
@@ -716,6 +1065,7 @@ func lintToolCommandRequired(g *model.Graph) []Diagnostic {
 			Message:  msg,
 			NodeID:   id,
 			Fix:      fix,
+			Edits:    edits,
 		})
 	}
 	return diags
@@ -731,8 +1081,9 @@ func nodeResolvesToTool(n *model.Node) bool {
 
 func lintLoopRestartFailureClassGuard(g *model.Graph) []Diagnostic {
 	var diags []Diagnostic
-	// Track nodes that have a properly-guarded transient restart edge.
-	guardedRestartSources := map[string]bool{}
+	// Track nodes that have a properly-guarded transient restart edge, and
+	// where it restarts.
+	guardedRestartSources := map[string]string{}
 	for _, e := range g.Edges {
 		if e == nil {
 			continue
@@ -746,21 +1097,30 @@ func lintLoopRestartFailureClassGuard(g *model.Graph) []Diagnostic {
 			continue
 		}
 		if conditionHasTransientInfraGuard(condExpr) {
-			guardedRestartSources[e.From] = true
+			if _, ok := guardedRestartSources[e.From]; !ok {
+				guardedRestartSources[e.From] = e.To
+			}
 			continue
 		}
-		diags = append(diags, Diagnostic{
+		d := Diagnostic{
 			Rule:     "loop_restart_failure_class_guard",
 			Severity: SeverityWarning,
 			Message:  "loop_restart=true requires condition guarded by context.failure_class=transient_infra",
 			EdgeFrom: e.From,
 			EdgeTo:   e.To,
 			Fix:      "add condition with context.failure_class=transient_infra or remove loop_restart=true",
-		})
+		}
+		if !conditionReferencesFailureClass(condExpr) {
+			guarded := guardTransient(condExpr)
+			if d.Edits = setEdgeAttr(g, e, "condition", guarded); d.Edits != nil {
+				d.Fix = fmt.Sprintf("set condition=%q", guarded)
+			}
+		}
+		diags = append(diags, d)
 	}
 	// Second pass: nodes with a guarded transient restart must also have a
 	// companion non-restart edge for deterministic failures.
-	for from := range guardedRestartSources {
+	for from, restartTo := range guardedRestartSources {
 		hasDeterministicFallback := false
 		for _, e := range g.Edges {
 			if e == nil || e.From != from {
@@ -776,13 +1136,20 @@ func lintLoopRestartFailureClassGuard(g *model.Graph) []Diagnostic {
 			}
 		}
 		if !hasDeterministicFallback {
-			diags = append(diags, Diagnostic{
+			// Deterministic failures keep going where they went before,
+			// just without restarting the run.
+			deterministic := "outcome=fail && context.failure_class!=transient_infra"
+			d := Diagnostic{
 				Rule:     "loop_restart_failure_class_guard",
 				Severity: SeverityWarning,
 				Message:  "node with transient-infra loop_restart must also have a non-restart edge for deterministic failures",
 				EdgeFrom: from,
 				Fix:      "add an edge for outcome=fail && context.failure_class!=transient_infra without loop_restart",
-			})
+			}
+			if d.Edits = addEdge(g, from, restartTo, "condition", deterministic); d.Edits != nil {
+				d.Fix = fmt.Sprintf("add %s -> %s [condition=%q]", from, restartTo, deterministic)
+			}
+			diags = append(diags, d)
 		}
 	}
 	return diags
@@ -809,14 +1176,23 @@ func lintFailLoopFailureClassGuard(g *model.Graph) []Diagnostic {
 		if conditionReferencesFailureClass(condExpr) {
 			continue
 		}
-		diags = append(diags, Diagnostic{
+		d := Diagnostic{
 			Rule:     "fail_loop_failure_class_guard",
 			Severity: SeverityWarning,
 			Message:  "failure back-edge from conditional node should guard retry path with context.failure_class and provide deterministic fallback routing",
 			EdgeFrom: e.From,
 			EdgeTo:   e.To,
 			Fix:      "split fail loop edge into failure_class-aware routes",
-		})
+		}
+		// Guarding the loop is only safe when deterministic failures
+		// already have somewhere else to go.
+		if hasForwardFailureRoute(g, e) {
+			guarded := guardTransient(condExpr)
+			if d.Edits = setEdgeAttr(g, e, "condition", guarded); d.Edits != nil {
+				d.Fix = fmt.Sprintf("set condition=%q; other failures take the existing forward route", guarded)
+			}
+		}
+		diags = append(diags, d)
 	}
 	return diags
 }
@@ -1005,6 +1381,7 @@ func lintPromptFileConflict(g *model.Graph) []Diagnostic {
 				Severity: SeverityError,
 				Message:  fmt.Sprintf("node has both prompt_file and prompt/llm_prompt — use one or the other"),
 				NodeID:   id,
+				Attr:     "prompt_file",
 				Fix:      "remove either prompt_file or prompt",
 			})
 		}
@@ -1012,6 +1389,56 @@ func lintPromptFileConflict(g *model.Graph) []Diagnostic {
 	return diags
 }
 
+// lintPromptTemplates parses the prompts of nodes with prompt_template
+// enabled and flags
+// variables the template cannot resolve. Unknown top-level names are errors
+// (they always render empty); graph attrs and node IDs that do not exist
+// are warnings.
+func lintPromptTemplates(g *model.Graph) []Diagnostic {
+	var diags []Diagnostic
+	nodeIDs := map[string]bool{}
+	for id := range g.Nodes {
+		nodeIDs[id] = true
+	}
+	for _, id := range g.AllNodeIDs() {
+		n := g.Nodes[id]
+		if !tmpl.Enabled(n.Attr(tmpl.Attr, ""), g.Attrs[tmpl.Attr]) {
+			continue
+		}
+		for _, attr := range []string{"prompt", "llm_prompt"} {
+			text := n.Attr(attr, "")
+			if !strings.Contains(text, "{{") {
+				continue
+			}
+			refs, err := tmpl.Check(text, g.Attrs, nodeIDs)
+			if err != nil {
+				diags = append(diags, Diagnostic{
+					Rule:     "prompt_template",
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("prompt template: %v", err),
+					NodeID:   id,
+					Attr:     attr,
+					Fix:      `write a literal "{{" as {{"{{"}}`,
+				})
+				continue
+			}
+			for _, ref := range refs {
+				d := Diagnostic{Rule: "prompt_template", NodeID: id, Attr: attr}
+				if ref.Unknown {
+					d.Severity = SeverityError
+					d.Message = fmt.Sprintf("prompt template references unknown variable %s", ref.Path)
+					d.Fix = "use one of ." + strings.Join(tmpl.Names, ", .")
+				} else {
+					d.Severity = SeverityWarning
+					d.Message = fmt.Sprintf("prompt template references %s, which is not in the graph and will render empty", ref.Path)
+				}
+				diags = append(diags, d)
+			}
+		}
+	}
+	return diags
+}
+
 func lintEscalationModelsSyntax(g *model.Graph) []Diagnostic {
 	var diags []Diagnostic
 	for id, n := range g.Nodes {
@@ -1034,6 +1461,7 @@ func lintEscalationModelsSyntax(g *model.Graph) []Diagnostic {
 					Severity: SeverityWarning,
 					Message:  fmt.Sprintf("escalation_models entry %q missing colon separator (expected provider:model)", entry),
 					NodeID:   id,
+					Attr:     "escalation_models",
 					Fix:      "use provider:model format, e.g. \"anthropic:claude-opus-4-6\"",
 				})
 				continue
@@ -1046,6 +1474,7 @@ func lintEscalationModelsSyntax(g *model.Graph) []Diagnostic {
 					Severity: SeverityWarning,
 					Message:  fmt.Sprintf("escalation_models entry %q has empty provider", entry),
 					NodeID:   id,
+					Attr:     "escalation_models",
 				})
 			}
 			if mod == "" {
@@ -1054,6 +1483,7 @@ func lintEscalationModelsSyntax(g *model.Graph) []Diagnostic {
 					Severity: SeverityWarning,
 					Message:  fmt.Sprintf("escalation_models entry %q has empty model", entry),
 					NodeID:   id,
+					Attr:     "escalation_models",
 				})
 			}
 		}
@@ -1095,6 +1525,7 @@ func (r *TypeKnownRule) Apply(g *model.Graph) []Diagnostic {
 				Severity: SeverityWarning,
 				Message:  fmt.Sprintf("node type %q is not recognized by the handler registry", t),
 				NodeID:   id,
+				Attr:     "type",
 			})
 		}
 	}
@@ -1183,7 +1614,7 @@ func lintTemplatePostmortemRecoveryRouting(g *model.Graph) []Diagnostic {
 		if cond == "outcome=needs_replan" {
 			hasAnyNeedsReplan = true
 			if to != "plan_fanout" {
-				diags = append(diags, Diagnostic{
+				d := Diagnostic{
 					Rule:     "template_postmortem_replan_entry",
 					Severity: SeverityWarning,
 					NodeID:   "postmortem",
@@ -1191,7 +1622,11 @@ func lintTemplatePostmortemRecoveryRouting(g *model.Graph) []Diagnostic {
 					EdgeTo:   e.To,
 					Message:  "template-provenance graph routes needs_replan to a non-planning-entry node; route to plan_fanout",
 					Fix:      "set postmortem -> plan_fanout [condition=\"outcome=needs_replan\"]",
-				})
+				}
+				if g.Nodes["plan_fanout"] != nil {
+					d.Edits = retargetEdge(g, e, "plan_fanout")
+				}
+				diags = append(diags, d)
 			}
 		}
 
@@ -1209,13 +1644,17 @@ func lintTemplatePostmortemRecoveryRouting(g *model.Graph) []Diagnostic {
 	}
 
 	if !hasAnyNeedsReplan {
-		diags = append(diags, Diagnostic{
+		d := Diagnostic{
 			Rule:     "template_postmortem_replan_entry",
 			Severity: SeverityWarning,
 			NodeID:   "postmortem",
 			Message:  "template-provenance graph is missing postmortem needs_replan route to plan_fanout",
 			Fix:      "add postmortem -> plan_fanout [condition=\"outcome=needs_replan\"]",
-		})
+		}
+		if g.Nodes["plan_fanout"] != nil {
+			d.Edits = addEdge(g, "postmortem", "plan_fanout", "condition", "outcome=needs_replan")
+		}
+		diags = append(diags, d)
 	}
 
 	return diags
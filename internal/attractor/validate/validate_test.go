@@ -100,6 +100,29 @@ digraph G {
 	assertHasRule(t, diags, "condition_syntax", SeverityError)
 }
 
+func TestValidate_StylesheetUnknownProperty(t *testing.T) {
+	g, err := dot.Parse([]byte(`
+digraph G {
+  graph [model_stylesheet="[type=tool] { timout: 60s; max_retries: 2; tool_hooks.pre: ./lint.sh; }"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  start -> exit
+}
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var got []Diagnostic
+	for _, d := range Validate(g) {
+		if d.Rule == "stylesheet_property" {
+			got = append(got, d)
+		}
+	}
+	if len(got) != 1 || got[0].Severity != SeverityError || !strings.Contains(got[0].Message, `"timout"`) || got[0].Fix != `did you mean "timeout"?` {
+		t.Fatalf("stylesheet_property diagnostics: %+v", got)
+	}
+}
+
 func TestValidate_LLMProviderRequired_Metaspec(t *testing.T) {
 	g, err := dot.Parse([]byte(`
 digraph G {
@@ -116,6 +139,204 @@ digraph G {
 	assertHasRule(t, diags, "llm_provider_required", SeverityError)
 }
 
+func TestValidate_AgentToolAttrs(t *testing.T) {
+	cases := []struct {
+		attrs string
+		want  bool
+	}{
+		{`agent_tool.run_tests.command="go test ./{{pkg}}/..."`, false},
+		{`agent_tool.run_tests.command="make", agent_tool.run_tests.parameters="{\"type\":\"object\"}", agent_tool.run_tests.timeout_ms=60000`, false},
+		{`agent_tool.run_tests.description="no command"`, true},
+		{`agent_tool.run_tests.command="make", agent_tool.run_tests.parameters="not json"`, true},
+		{`agent_tool.run_tests.command="make", agent_tool.run_tests.timeout="5"`, true},
+		{`agent_tool.run_tests.command="make", agent_tool.run_tests.max_chars=-1`, true},
+	}
+	for _, tc := range cases {
+		g, err := dot.Parse([]byte(`
+digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, ` + tc.attrs + `]
+  start -> a -> exit
+}
+`))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		diags := Validate(g)
+		if tc.want {
+			assertHasRule(t, diags, "agent_tool_valid", SeverityError)
+		} else {
+			assertNoRule(t, diags, "agent_tool_valid")
+		}
+	}
+}
+
+func TestValidate_ToolPolicyAttrs(t *testing.T) {
+	cases := []struct {
+		attrs string
+		want  bool
+	}{
+		{`tool_policy=strict, tool_policy.deny_commands="git push", tool_policy.max_writes=5`, false},
+		{`tool_policy.deny_paths=".github/**", tool_policy.deny_tools="mcp__*"`, false},
+		{`tool_policy.max_writes=lots`, true},
+		{`tool_policy.allow_tools="shell"`, true},
+	}
+	for _, tc := range cases {
+		g, err := dot.Parse([]byte(`
+digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, ` + tc.attrs + `]
+  start -> a -> exit
+}
+`))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		diags := Validate(g)
+		if tc.want {
+			assertHasRule(t, diags, "tool_policy_valid", SeverityError)
+		} else {
+			assertNoRule(t, diags, "tool_policy_valid")
+		}
+	}
+}
+
+func TestValidate_SubagentAttrs(t *testing.T) {
+	cases := []struct {
+		attrs string
+		want  bool
+	}{
+		{`subagent_isolation=worktree, max_subagent_depth=2`, false},
+		{`subagent_isolation=shared`, false},
+		{`subagent_isolation=container`, true},
+		{`max_subagent_depth=0`, true},
+	}
+	for _, tc := range cases {
+		g, err := dot.Parse([]byte(`
+digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, ` + tc.attrs + `]
+  start -> a -> exit
+}
+`))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		diags := Validate(g)
+		if tc.want {
+			assertHasRule(t, diags, "subagent_attrs_valid", SeverityError)
+		} else {
+			assertNoRule(t, diags, "subagent_attrs_valid")
+		}
+	}
+}
+
+func TestValidate_PromptTemplates(t *testing.T) {
+	cases := []struct {
+		prompt string
+		sev    Severity // "" means no prompt_template diagnostic
+	}{
+		{`Fix it. {{if .retry_failure_reason}}Last failure: {{.retry_failure_reason}}{{end}}`, ""},
+		{`{{.outcomes.a.status}} {{.graph.goal}} {{.context.anything}} {{range .completed_nodes}}{{.}}{{end}}`, ""},
+		{`no template here, just $goal`, ""},
+		{`{{.failure_reson}}`, SeverityError},
+		{`{{if .retry_count}}unclosed`, SeverityError},
+		{`{{nope 1}}`, SeverityError},
+		{`{{.graph.gaol}}`, SeverityWarning},
+		{`{{.outcomes.missing.status}}`, SeverityWarning},
+	}
+	for _, tc := range cases {
+		g, err := dot.Parse([]byte(`
+digraph G {
+  graph [goal="ship"]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt_template=true, prompt="` + tc.prompt + `"]
+  start -> a -> exit
+}
+`))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		diags := Validate(g)
+		if tc.sev == "" {
+			assertNoRule(t, diags, "prompt_template")
+		} else {
+			assertHasRule(t, diags, "prompt_template", tc.sev)
+		}
+	}
+}
+
+func TestValidate_PromptTemplates_OptIn(t *testing.T) {
+	for _, attrs := range []string{``, `, prompt_template=false`} {
+		g, err := dot.Parse([]byte(`
+digraph G {
+  graph [prompt_template=true]
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2` + attrs + `, prompt="Set NODE_AUTH_TOKEN: ${{ secrets.NPM_TOKEN }}"]
+  start -> a -> exit
+}
+`))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		diags := Validate(g)
+		if attrs == "" {
+			assertHasRule(t, diags, "prompt_template", SeverityError)
+		} else {
+			assertNoRule(t, diags, "prompt_template")
+		}
+	}
+	g, err := dot.Parse([]byte(`
+digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, prompt="Set NODE_AUTH_TOKEN: ${{ secrets.NPM_TOKEN }}"]
+  start -> a -> exit
+}
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	assertNoRule(t, Validate(g), "prompt_template")
+}
+
+func TestValidate_LLMModeValid(t *testing.T) {
+	cases := []struct {
+		attrs string
+		want  bool
+	}{
+		{`llm_mode=batch`, false},
+		{`llm_mode=batch, codergen_mode=one_shot`, false},
+		{`llm_mode=sync, codergen_mode=agent_loop`, false},
+		{`llm_mode=batch, codergen_mode=agent_loop`, true},
+		{`llm_mode=async`, true},
+	}
+	for _, tc := range cases {
+		g, err := dot.Parse([]byte(`
+digraph G {
+  start [shape=Mdiamond]
+  exit  [shape=Msquare]
+  a [shape=box, llm_provider=openai, llm_model=gpt-5.2, ` + tc.attrs + `]
+  start -> a -> exit
+}
+`))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		diags := Validate(g)
+		if tc.want {
+			assertHasRule(t, diags, "llm_mode_valid", SeverityError)
+		} else {
+			assertNoRule(t, diags, "llm_mode_valid")
+		}
+	}
+}
+
 func TestValidate_ToolCommandRequired_ParallelogramWithToolCommand_NoError(t *testing.T) {
 	g, err := dot.Parse([]byte(`
 digraph G {
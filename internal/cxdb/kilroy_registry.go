@@ -82,6 +82,25 @@ func KilroyAttractorRegistryBundle() (bundleID string, bundle RegistryBundle, sh
 				"5": field("output", "string", opt()),
 				"6": field("is_error", "bool", opt()),
 			}),
+			"com.kilroy.attractor.ToolCallDenied": typeDef(map[string]any{
+				"1": field("run_id", "string"),
+				"2": field("node_id", "string", opt()),
+				"3": field("tool_name", "string"),
+				"4": field("call_id", "string"),
+				"5": field("rule", "string", opt()),
+				"6": field("reason", "string", opt()),
+			}),
+			"com.kilroy.attractor.FileChanged": typeDef(map[string]any{
+				"1": field("run_id", "string"),
+				"2": field("node_id", "string", opt()),
+				"3": field("tool_name", "string"),
+				"4": field("call_id", "string"),
+				"5": field("path", "string"),
+				"6": field("op", "string"),
+				"7": field("before_sha256", "string", opt()),
+				"8": field("after_sha256", "string", opt()),
+				"9": field("diff", "string", opt()),
+			}),
 			"com.kilroy.attractor.Blob": typeDef(map[string]any{
 				"1": field("bytes", "bytes"),
 			}),
@@ -204,6 +223,15 @@ func KilroyAttractorRegistryBundle() (bundleID string, bundle RegistryBundle, sh
 				"4": field("question_text", "string", opt()),
 				"5": fieldSemantic("duration_ms", "u64", "duration_ms", opt()),
 			}),
+			"com.kilroy.attractor.MemoryWritten": typeDef(map[string]any{
+				"1": field("run_id", "string"),
+				"2": field("node_id", "string", opt()),
+				"3": fieldSemantic("timestamp_ms", "u64", "unix_ms"),
+				"4": field("namespace", "string"),
+				"5": field("key", "string"),
+				"6": field("value", "string", opt()),
+				"7": field("deleted", "bool", opt()),
+			}),
 		},
 		Enums: map[string]any{},
 	}
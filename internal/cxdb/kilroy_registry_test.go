@@ -22,6 +22,7 @@ func TestKilroyAttractorRegistryBundle_IncludesRequiredTypes(t *testing.T) {
 		"com.kilroy.attractor.StageFinished",
 		"com.kilroy.attractor.ToolCall",
 		"com.kilroy.attractor.ToolResult",
+		"com.kilroy.attractor.ToolCallDenied",
 		"com.kilroy.attractor.Artifact",
 		"com.kilroy.attractor.GitCheckpoint",
 		"com.kilroy.attractor.CheckpointSaved",
@@ -29,6 +30,8 @@ func TestKilroyAttractorRegistryBundle_IncludesRequiredTypes(t *testing.T) {
 		"com.kilroy.attractor.Blob",
 		"com.kilroy.attractor.AssistantMessage",
 		"com.kilroy.attractor.Prompt",
+		"com.kilroy.attractor.MemoryWritten",
+		"com.kilroy.attractor.FileChanged",
 	}
 	for _, typ := range required {
 		if _, ok := bundle.Types[typ]; !ok {
@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// BatchAdapter is an optional ProviderAdapter extension for providers that
+// expose an asynchronous batch endpoint. Batches trade latency (minutes to
+// hours) for lower per-token pricing and suit non-interactive one-shot calls.
+//
+// Callers discover support with a type assertion (or Client.BatchAdapter) and
+// must fall back to Complete when it is absent.
+type BatchAdapter interface {
+	// BatchSupported reports whether this adapter instance can use the batch
+	// endpoint. Adapters shared by compatible third-party providers return
+	// false for providers that do not expose it.
+	BatchSupported() bool
+	// SubmitBatch enqueues the requests and returns the provider's job handle.
+	SubmitBatch(ctx context.Context, reqs []BatchRequest) (BatchJob, error)
+	// GetBatch refreshes the job status.
+	GetBatch(ctx context.Context, id string) (BatchJob, error)
+	// BatchResults fetches per-request results for a completed job.
+	BatchResults(ctx context.Context, job BatchJob) ([]BatchResult, error)
+}
+
+type BatchRequest struct {
+	// CustomID correlates results with requests; it must be unique within a batch.
+	CustomID string  `json:"custom_id"`
+	Request  Request `json:"request"`
+}
+
+type BatchStatus string
+
+const (
+	// BatchStatusPending covers every non-terminal provider state
+	// (validating, queued, in_progress, finalizing, canceling).
+	BatchStatusPending   BatchStatus = "pending"
+	BatchStatusCompleted BatchStatus = "completed"
+	// BatchStatusFailed covers terminal states without usable results
+	// (failed, expired, cancelled).
+	BatchStatusFailed BatchStatus = "failed"
+)
+
+// BatchJob is a provider batch handle. It is JSON-serializable so callers can
+// persist it and re-attach after a restart.
+type BatchJob struct {
+	ID        string      `json:"id"`
+	Provider  string      `json:"provider"`
+	Status    BatchStatus `json:"status"`
+	RawStatus string      `json:"raw_status,omitempty"`
+	// ResultsRef locates results once completed (OpenAI output file ID,
+	// Anthropic results URL).
+	ResultsRef string `json:"results_ref,omitempty"`
+	// ErrorsRef locates per-request errors when the provider reports them
+	// separately (OpenAI error file ID).
+	ErrorsRef string         `json:"errors_ref,omitempty"`
+	Raw       map[string]any `json:"raw,omitempty"`
+}
+
+func (j BatchJob) Done() bool {
+	return j.Status == BatchStatusCompleted || j.Status == BatchStatusFailed
+}
+
+type BatchResult struct {
+	CustomID string
+	Response *Response
+	Err      error
+}
+
+// BatchAdapter returns the batch-capable adapter registered for provider, if any.
+func (c *Client) BatchAdapter(provider string) (BatchAdapter, bool) {
+	if c == nil {
+		return nil, false
+	}
+	prov := normalizeProviderName(provider)
+	if prov == "" {
+		prov = c.defaultProvider
+	}
+	adapter, ok := c.providers[prov]
+	if !ok {
+		return nil, false
+	}
+	ba, ok := adapter.(BatchAdapter)
+	if !ok || !ba.BatchSupported() {
+		return nil, false
+	}
+	return ba, true
+}
+
+// FindBatchResult returns the result with the given custom ID. A missing
+// result or a per-request error is returned as an error.
+func FindBatchResult(results []BatchResult, customID string) (Response, error) {
+	for _, r := range results {
+		if r.CustomID != customID {
+			continue
+		}
+		if r.Err != nil {
+			return Response{}, r.Err
+		}
+		if r.Response == nil {
+			return Response{}, fmt.Errorf("batch result %s has no response", customID)
+		}
+		return *r.Response, nil
+	}
+	return Response{}, fmt.Errorf("batch results missing custom_id %s", strings.TrimSpace(customID))
+}
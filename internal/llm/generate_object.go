@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/santhosh-tekuri/jsonschema/v5"
 
@@ -21,40 +22,63 @@ func GenerateObject(ctx context.Context, opts GenerateObjectOptions) (*GenerateR
 	if opts.Schema == nil {
 		return nil, &ConfigurationError{Message: "schema is required"}
 	}
+	res, err := Generate(ctx, objectGenerateOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := compileJSONSchema(opts.Schema)
+	if err != nil {
+		return nil, err
+	}
+	out, err := parseAndValidateObject(res.Text, schema)
+	if err != nil {
+		return nil, err
+	}
+	res.Output = out
+	return res, nil
+}
+
+// objectGenerateOptions returns the GenerateOptions for a structured-output
+// call. Provider-specific structured output configuration is handled at the
+// adapter layer.
+func objectGenerateOptions(opts GenerateObjectOptions) GenerateOptions {
 	strict := opts.Strict
 	if !opts.Strict {
 		// default
 		strict = true
 	}
-
-	// Provider-specific structured output configuration is handled at the adapter layer.
 	ro := opts.GenerateOptions
 	ro.ResponseFormat = &ResponseFormat{
 		Type:       "json_schema",
 		JSONSchema: opts.Schema,
 		Strict:     strict,
 	}
-	res, err := Generate(ctx, ro)
-	if err != nil {
-		return nil, err
-	}
+	return ro
+}
 
+func parseAndValidateObject(text string, schema *jsonschema.Schema) (any, error) {
 	var out any
-	dec := json.NewDecoder(bytes.NewReader([]byte(res.Text)))
+	dec := json.NewDecoder(bytes.NewReader([]byte(text)))
 	dec.UseNumber()
 	if err := dec.Decode(&out); err != nil {
-		return nil, NewNoObjectGeneratedError(fmt.Sprintf("failed to parse JSON output: %v", err), res.Text)
-	}
-
-	schema, err := compileJSONSchema(opts.Schema)
-	if err != nil {
-		return nil, err
+		return nil, NewNoObjectGeneratedError(fmt.Sprintf("failed to parse JSON output: %v", err), text)
 	}
 	if err := schema.Validate(out); err != nil {
-		return nil, NewNoObjectGeneratedError(fmt.Sprintf("JSON output failed schema validation: %v", err), res.Text)
+		return nil, NewNoObjectGeneratedError(fmt.Sprintf("JSON output failed schema validation: %v", err), text)
 	}
-	res.Output = out
-	return res, nil
+	return out, nil
+}
+
+// objectRepairMessages builds the follow-up turn for a repair attempt: the
+// invalid output as the assistant turn and the error as user feedback.
+func objectRepairMessages(rawText string, err error) []Message {
+	var msgs []Message
+	if strings.TrimSpace(rawText) != "" {
+		msgs = append(msgs, Assistant(rawText))
+	}
+	msgs = append(msgs, User(fmt.Sprintf("Your previous response was not a valid JSON value for the required schema: %v\nRespond again with only the corrected JSON.", err)))
+	return msgs
 }
 
 func compileJSONSchema(schema map[string]any) (*jsonschema.Schema, error) {
@@ -0,0 +1,279 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// partialJSON is the best-effort view of a JSON document that is still being
+// streamed.
+type partialJSON struct {
+	// Value is the document prefix repaired into valid JSON (open strings and
+	// containers closed, dangling keys/commas/incomplete literals dropped) and
+	// decoded with UseNumber. Nil until the prefix contains a usable value.
+	Value any
+	// Completed lists JSON pointers ("/name", "/0") of direct children of the
+	// root container whose values are fully received, in document order.
+	Completed []string
+	// Done is true once the root value is closed.
+	Done bool
+}
+
+type partialFrame struct {
+	kind  byte // '{' or '['
+	state int
+	key   string // current key (objects)
+	index int    // current element index (arrays)
+}
+
+const (
+	pfExpectKeyOrEnd   = iota // after '{'
+	pfExpectKey               // after ',' in an object
+	pfExpectColon             // after a key
+	pfExpectValue             // after ':' or ',' in an array
+	pfExpectValueOrEnd        // after '['
+	pfExpectCommaOrEnd        // after a member value
+)
+
+// parsePartialJSON repairs and decodes a streamed JSON prefix. It returns an
+// error only when text can no longer become valid JSON (leading prose, a
+// stray character, trailing content after the root value); an incomplete but
+// consistent prefix is never an error.
+func parsePartialJSON(text string) (partialJSON, error) {
+	var out partialJSON
+	var stack []partialFrame
+	cut, suffix := -1, ""
+	rootDone := false
+
+	closers := func() string {
+		var b strings.Builder
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].kind == '{' {
+				b.WriteByte('}')
+			} else {
+				b.WriteByte(']')
+			}
+		}
+		return b.String()
+	}
+	markSafe := func(pos int, extra string) {
+		cut, suffix = pos, extra+closers()
+	}
+	// valueDone transitions the parent after a complete value and records
+	// completion of root children.
+	valueDone := func(end int) {
+		if len(stack) == 0 {
+			rootDone = true
+			markSafe(end, "")
+			return
+		}
+		top := &stack[len(stack)-1]
+		top.state = pfExpectCommaOrEnd
+		if len(stack) == 1 {
+			if top.kind == '{' {
+				out.Completed = append(out.Completed, "/"+escapeJSONPointer(top.key))
+			} else {
+				out.Completed = append(out.Completed, "/"+strconv.Itoa(top.index))
+			}
+		}
+		markSafe(end, "")
+	}
+	expectingValue := func() bool {
+		if len(stack) == 0 {
+			return !rootDone
+		}
+		s := stack[len(stack)-1].state
+		return s == pfExpectValue || s == pfExpectValueOrEnd
+	}
+	unexpected := func(i int) error {
+		return fmt.Errorf("unexpected %q at offset %d", text[i], i)
+	}
+
+	i := 0
+	n := len(text)
+	for i < n {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case rootDone:
+			return out, unexpected(i)
+		}
+
+		var top *partialFrame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+
+		switch c {
+		case '{', '[':
+			if !expectingValue() {
+				return out, unexpected(i)
+			}
+			f := partialFrame{kind: c, state: pfExpectKeyOrEnd}
+			if c == '[' {
+				f.state = pfExpectValueOrEnd
+			}
+			stack = append(stack, f)
+			i++
+			markSafe(i, "")
+		case '}', ']':
+			if top == nil || (c == '}') != (top.kind == '{') {
+				return out, unexpected(i)
+			}
+			if top.state != pfExpectCommaOrEnd && top.state != pfExpectKeyOrEnd && top.state != pfExpectValueOrEnd {
+				return out, unexpected(i)
+			}
+			stack = stack[:len(stack)-1]
+			i++
+			valueDone(i)
+		case ',':
+			if top == nil || top.state != pfExpectCommaOrEnd {
+				return out, unexpected(i)
+			}
+			if top.kind == '{' {
+				top.state = pfExpectKey
+			} else {
+				top.state = pfExpectValue
+				top.index++
+			}
+			i++
+		case ':':
+			if top == nil || top.state != pfExpectColon {
+				return out, unexpected(i)
+			}
+			top.state = pfExpectValue
+			i++
+		case '"':
+			isKey := top != nil && top.kind == '{' && (top.state == pfExpectKeyOrEnd || top.state == pfExpectKey)
+			if !isKey && !expectingValue() {
+				return out, unexpected(i)
+			}
+			start := i
+			i++
+			closed := false
+			for i < n {
+				ch := text[i]
+				if ch == '\\' {
+					if i+1 >= n {
+						break
+					}
+					if text[i+1] == 'u' {
+						if i+6 > n {
+							i = n
+							break
+						}
+						i += 6
+					} else {
+						i += 2
+					}
+				} else if ch == '"' {
+					closed = true
+					i++
+					break
+				} else {
+					i++
+				}
+				if !isKey && (i == n || utf8.RuneStart(text[i])) {
+					markSafe(i, `"`)
+				}
+			}
+			if !closed {
+				if !isKey && i == start+1 {
+					markSafe(i, `"`)
+				}
+				i = n
+				break
+			}
+			if isKey {
+				var k string
+				if err := json.Unmarshal([]byte(text[start:i]), &k); err != nil {
+					return out, fmt.Errorf("invalid object key at offset %d: %v", start, err)
+				}
+				top.key = k
+				top.state = pfExpectColon
+				continue
+			}
+			valueDone(i)
+		default:
+			if !expectingValue() {
+				return out, unexpected(i)
+			}
+			start := i
+			for i < n && strings.IndexByte(" \t\r\n,]}", text[i]) < 0 {
+				i++
+			}
+			tok := text[start:i]
+			if i == n {
+				// Still streaming: keep the token only if it is already a
+				// complete literal/number; otherwise it must be a valid prefix.
+				if !isJSONScalarPrefix(tok) {
+					return out, fmt.Errorf("invalid token %q at offset %d", tok, start)
+				}
+				if isJSONScalar(tok) && len(stack) > 0 {
+					markSafe(i, "")
+				}
+				break
+			}
+			if !isJSONScalar(tok) {
+				return out, fmt.Errorf("invalid token %q at offset %d", tok, start)
+			}
+			valueDone(i)
+		}
+	}
+
+	out.Done = rootDone
+	if cut < 0 {
+		return out, nil
+	}
+	repaired := text[:cut] + suffix
+	dec := json.NewDecoder(bytes.NewReader([]byte(repaired)))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		// The repair is best-effort; an undecodable prefix just means no
+		// partial value yet.
+		return out, nil
+	}
+	out.Value = v
+	return out, nil
+}
+
+func isJSONScalar(tok string) bool {
+	switch tok {
+	case "true", "false", "null":
+		return true
+	}
+	var n json.Number
+	if err := json.Unmarshal([]byte(tok), &n); err != nil {
+		return false
+	}
+	return true
+}
+
+func isJSONScalarPrefix(tok string) bool {
+	for _, lit := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(lit, tok) {
+			return true
+		}
+	}
+	for j := 0; j < len(tok); j++ {
+		if strings.IndexByte("0123456789+-.eE", tok[j]) < 0 {
+			return false
+		}
+	}
+	return tok != ""
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func unescapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
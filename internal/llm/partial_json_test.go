@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePartialJSON_RepairsPrefixes(t *testing.T) {
+	cases := []struct {
+		text      string
+		want      string // JSON of the expected repaired value; "" means nil
+		completed []string
+	}{
+		{``, ``, nil},
+		{`{`, `{}`, nil},
+		{`{"name": "Al`, `{"name":"Al"}`, nil},
+		{`{"name": "Alice", "ag`, `{"name":"Alice"}`, []string{"/name"}},
+		{`{"name": "Alice", "age": 3`, `{"name":"Alice","age":3}`, []string{"/name"}},
+		{`{"name": "Alice", "age": 30,`, `{"name":"Alice","age":30}`, []string{"/name", "/age"}},
+		{`{"ok": tr`, `{}`, nil},
+		{`{"steps": [{"id": 1}, {"id": 2`, `{"steps":[{"id":1},{"id":2}]}`, nil},
+		{`{"steps": [{"id": 1}], "a/b": "x"}`, `{"steps":[{"id":1}],"a/b":"x"}`, []string{"/steps", "/a~1b"}},
+		{`["a", "b`, `["a","b"]`, []string{"/0"}},
+		{`{"s": "esc \"q\" \u00e`, `{"s":"esc \"q\" "}`, nil},
+	}
+	for _, tc := range cases {
+		got, err := parsePartialJSON(tc.text)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tc.text, err)
+		}
+		if tc.want == "" {
+			if got.Value != nil {
+				t.Fatalf("%q: expected nil value, got %#v", tc.text, got.Value)
+			}
+		} else {
+			var want any
+			if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got.Value)
+			var gotNorm any
+			_ = json.Unmarshal(gotJSON, &gotNorm)
+			if !reflect.DeepEqual(gotNorm, want) {
+				t.Fatalf("%q: got %s want %s", tc.text, gotJSON, tc.want)
+			}
+		}
+		if !reflect.DeepEqual(got.Completed, tc.completed) {
+			t.Fatalf("%q: completed got %v want %v", tc.text, got.Completed, tc.completed)
+		}
+	}
+}
+
+func TestParsePartialJSON_RejectsMalformedPrefixes(t *testing.T) {
+	for _, text := range []string{
+		`Here is the JSON: {`,
+		`{"a" 1}`,
+		`{"a": 1,}`,
+		`{"a": nope}`,
+		`{"a": 1} trailing`,
+		`[1, 2}`,
+	} {
+		if _, err := parsePartialJSON(text); err == nil {
+			t.Fatalf("%q: expected error", text)
+		}
+	}
+}
+
+func TestParsePartialJSON_DoneOnlyWhenRootCloses(t *testing.T) {
+	got, err := parsePartialJSON(`{"a": [1, 2]}`)
+	if err != nil || !got.Done {
+		t.Fatalf("expected done, got %+v err=%v", got, err)
+	}
+	got, err = parsePartialJSON(`{"a": [1, 2]`)
+	if err != nil || got.Done {
+		t.Fatalf("expected not done, got %+v err=%v", got, err)
+	}
+}
@@ -84,14 +84,55 @@ func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response,
 		return a.completeViaStream(ctx, req)
 	}
 
-	system, messages, err := toAnthropicMessages(req.Messages)
+	body, beta, err := a.buildMessagesBody(req)
 	if err != nil {
 		return llm.Response{}, err
 	}
-	system, err = applyAnthropicResponseFormat(system, req.ResponseFormat)
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BaseURL+"/v1/messages", bytes.NewReader(b))
+	if err != nil {
+		return llm.Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.APIKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	if strings.TrimSpace(beta) != "" {
+		httpReq.Header.Set("anthropic-beta", beta)
+	}
+
+	resp, err := a.Client.Do(httpReq)
 	if err != nil {
 		return llm.Response{}, err
 	}
+	defer func() { _ = resp.Body.Close() }()
+
+	rawBytes, _ := io.ReadAll(resp.Body)
+	var raw map[string]any
+	_ = json.Unmarshal(rawBytes, &raw)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		msg := fmt.Sprintf("messages.create failed: %s", strings.TrimSpace(string(rawBytes)))
+		return llm.Response{}, llm.ErrorFromHTTPStatus(a.Name(), resp.StatusCode, msg, raw, ra)
+	}
+
+	return fromAnthropicResponse(a.Name(), raw, req.Model), nil
+}
+
+// buildMessagesBody maps req to a /v1/messages body and the anthropic-beta
+// header value. It is shared by Complete and batch submission.
+func (a *Adapter) buildMessagesBody(req llm.Request) (map[string]any, string, error) {
+	system, messages, err := toAnthropicMessages(req.Messages)
+	if err != nil {
+		return nil, "", err
+	}
+	system, err = applyAnthropicResponseFormat(system, req.ResponseFormat)
+	if err != nil {
+		return nil, "", err
+	}
 	autoCache := anthropicAutoCacheEnabled(a.Name(), req.ProviderOptions)
 
 	maxTokens := 4096
@@ -141,13 +182,13 @@ func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response,
 			}
 		case "named":
 			if strings.TrimSpace(req.ToolChoice.Name) == "" {
-				return llm.Response{}, &llm.ConfigurationError{Message: "tool_choice mode=named requires name"}
+				return nil, "", &llm.ConfigurationError{Message: "tool_choice mode=named requires name"}
 			}
 			if includeTools {
 				body["tool_choice"] = map[string]any{"type": "tool", "name": req.ToolChoice.Name}
 			}
 		default:
-			return llm.Response{}, llm.NewUnsupportedToolChoiceError("anthropic", req.ToolChoice.Mode)
+			return nil, "", llm.NewUnsupportedToolChoiceError("anthropic", req.ToolChoice.Mode)
 		}
 	}
 	if includeTools && len(req.Tools) > 0 {
@@ -173,42 +214,11 @@ func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response,
 	if autoCache {
 		addCacheControlBreakpoint(messages)
 	}
-
-	b, err := json.Marshal(body)
-	if err != nil {
-		return llm.Response{}, err
-	}
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BaseURL+"/v1/messages", bytes.NewReader(b))
-	if err != nil {
-		return llm.Response{}, err
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", a.APIKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
 	beta := betaHeaderFromProviderOptions(req.ProviderOptions)
 	if autoCache {
 		beta = appendBetaHeader(beta, "prompt-caching-2024-07-31")
 	}
-	if strings.TrimSpace(beta) != "" {
-		httpReq.Header.Set("anthropic-beta", beta)
-	}
-
-	resp, err := a.Client.Do(httpReq)
-	if err != nil {
-		return llm.Response{}, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	rawBytes, _ := io.ReadAll(resp.Body)
-	var raw map[string]any
-	_ = json.Unmarshal(rawBytes, &raw)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
-		msg := fmt.Sprintf("messages.create failed: %s", strings.TrimSpace(string(rawBytes)))
-		return llm.Response{}, llm.ErrorFromHTTPStatus(a.Name(), resp.StatusCode, msg, raw, ra)
-	}
-
-	return fromAnthropicResponse(a.Name(), raw, req.Model), nil
+	return body, beta, nil
 }
 
 func (a *Adapter) completeViaStream(ctx context.Context, req llm.Request) (llm.Response, error) {
@@ -0,0 +1,188 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+var _ llm.BatchAdapter = (*Adapter)(nil)
+
+// SubmitBatch creates a Message Batch. Only first-party Anthropic exposes the
+// batch endpoint; Anthropic-compatible providers (kimi, ...) are rejected so
+// callers fall back to Complete.
+func (a *Adapter) SubmitBatch(ctx context.Context, reqs []llm.BatchRequest) (llm.BatchJob, error) {
+	if err := a.requireBatchSupport(); err != nil {
+		return llm.BatchJob{}, err
+	}
+	if len(reqs) == 0 {
+		return llm.BatchJob{}, &llm.ConfigurationError{Message: "batch requires at least one request"}
+	}
+	items := make([]map[string]any, 0, len(reqs))
+	var beta string
+	for _, br := range reqs {
+		req := llm.ApplyExecutionPolicy(br.Request, llm.ExecutionPolicy(a.Name()))
+		params, b, err := a.buildMessagesBody(req)
+		if err != nil {
+			return llm.BatchJob{}, err
+		}
+		beta = b
+		items = append(items, map[string]any{"custom_id": br.CustomID, "params": params})
+	}
+	payload, err := json.Marshal(map[string]any{"requests": items})
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	b, err := a.doJSONRequest(ctx, http.MethodPost, a.BaseURL+"/v1/messages/batches", bytes.NewReader(payload), beta, "messages.batches.create failed")
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	return a.batchJobFromBytes(b)
+}
+
+func (a *Adapter) GetBatch(ctx context.Context, id string) (llm.BatchJob, error) {
+	if err := a.requireBatchSupport(); err != nil {
+		return llm.BatchJob{}, err
+	}
+	b, err := a.doJSONRequest(ctx, http.MethodGet, a.BaseURL+"/v1/messages/batches/"+strings.TrimSpace(id), nil, "", "messages.batches.retrieve failed")
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	return a.batchJobFromBytes(b)
+}
+
+// BatchResults streams the JSONL results file referenced by results_url.
+func (a *Adapter) BatchResults(ctx context.Context, job llm.BatchJob) ([]llm.BatchResult, error) {
+	if err := a.requireBatchSupport(); err != nil {
+		return nil, err
+	}
+	if job.Status != llm.BatchStatusCompleted {
+		return nil, fmt.Errorf("batch %s is not completed (status=%s)", job.ID, job.RawStatus)
+	}
+	url := strings.TrimSpace(job.ResultsRef)
+	if url == "" {
+		url = a.BaseURL + "/v1/messages/batches/" + job.ID + "/results"
+	}
+	b, err := a.doJSONRequest(ctx, http.MethodGet, url, nil, "", "messages.batches.results failed")
+	if err != nil {
+		return nil, err
+	}
+	var out []llm.BatchResult
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 0, 64*1024), 32<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry map[string]any
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		out = append(out, a.batchResultFromLine(entry))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BatchSupported is true only for first-party Anthropic.
+func (a *Adapter) BatchSupported() bool {
+	return a.Name() == "anthropic"
+}
+
+func (a *Adapter) requireBatchSupport() error {
+	if !a.BatchSupported() {
+		return &llm.ConfigurationError{Message: fmt.Sprintf("provider %s does not support message batches", a.Name())}
+	}
+	return nil
+}
+
+func (a *Adapter) batchResultFromLine(entry map[string]any) llm.BatchResult {
+	res := llm.BatchResult{CustomID: fmt.Sprint(entry["custom_id"])}
+	result, _ := entry["result"].(map[string]any)
+	typ, _ := result["type"].(string)
+	switch typ {
+	case "succeeded":
+		msg, _ := result["message"].(map[string]any)
+		model, _ := msg["model"].(string)
+		r := fromAnthropicResponse(a.Name(), msg, model)
+		res.Response = &r
+	case "errored":
+		errObj, _ := result["error"].(map[string]any)
+		if inner, ok := errObj["error"].(map[string]any); ok {
+			errObj = inner
+		}
+		res.Err = llm.ErrorFromHTTPStatus(a.Name(), http.StatusBadRequest, fmt.Sprintf("batch request errored: %v", errObj["message"]), errObj, nil)
+	default:
+		res.Err = fmt.Errorf("batch request %s: result type %q", res.CustomID, typ)
+	}
+	return res
+}
+
+func (a *Adapter) batchJobFromBytes(b []byte) (llm.BatchJob, error) {
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return llm.BatchJob{}, err
+	}
+	job := llm.BatchJob{Provider: a.Name(), Raw: raw}
+	job.ID, _ = raw["id"].(string)
+	job.RawStatus, _ = raw["processing_status"].(string)
+	job.ResultsRef, _ = raw["results_url"].(string)
+	switch job.RawStatus {
+	case "ended":
+		job.Status = llm.BatchStatusCompleted
+	default:
+		job.Status = llm.BatchStatusPending
+	}
+	return job, nil
+}
+
+// doJSONRequest issues a non-streaming JSON request against the Anthropic API with
+// the standard auth/version headers.
+func (a *Adapter) doJSONRequest(ctx context.Context, method, url string, body io.Reader, beta, failMsg string) ([]byte, error) {
+	if a.Client == nil {
+		a.Client = &http.Client{Timeout: 0}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("x-api-key", a.APIKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	if strings.TrimSpace(beta) != "" {
+		httpReq.Header.Set("anthropic-beta", beta)
+	}
+	resp, err := a.Client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var raw map[string]any
+		_ = json.Unmarshal(b, &raw)
+		ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, llm.ErrorFromHTTPStatus(a.Name(), resp.StatusCode, fmt.Sprintf("%s: %s", failMsg, strings.TrimSpace(string(b))), raw, ra)
+	}
+	return b, nil
+}
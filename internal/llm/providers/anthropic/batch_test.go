@@ -0,0 +1,92 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestAdapter_Batch_SubmitPollAndResults(t *testing.T) {
+	var created map[string]any
+	var srvURL string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "k" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("missing auth headers on %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/messages/batches":
+			b, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(b, &created)
+			_, _ = w.Write([]byte(`{"id":"msgbatch_1","processing_status":"in_progress"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_1":
+			_, _ = w.Write([]byte(`{"id":"msgbatch_1","processing_status":"ended","results_url":"` + srvURL + `/results/msgbatch_1"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/results/msgbatch_1":
+			_, _ = w.Write([]byte(`{"custom_id":"a","result":{"type":"succeeded","message":{"id":"msg_a","model":"claude-sonnet-4-5","content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}}}
+{"custom_id":"b","result":{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}}}
+{"custom_id":"c","result":{"type":"expired"}}
+`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	srvURL = srv.URL
+
+	a := NewWithProvider("anthropic", "k", srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	job, err := a.SubmitBatch(ctx, []llm.BatchRequest{{
+		CustomID: "a",
+		Request:  llm.Request{Model: "claude-sonnet-4-5", Messages: []llm.Message{llm.System("sys"), llm.User("hi")}},
+	}})
+	if err != nil {
+		t.Fatalf("SubmitBatch: %v", err)
+	}
+	if job.ID != "msgbatch_1" || job.Status != llm.BatchStatusPending {
+		t.Fatalf("job: %+v", job)
+	}
+	reqs, _ := created["requests"].([]any)
+	if len(reqs) != 1 {
+		t.Fatalf("requests: %#v", created)
+	}
+	item, _ := reqs[0].(map[string]any)
+	params, _ := item["params"].(map[string]any)
+	if item["custom_id"] != "a" || params["model"] != "claude-sonnet-4-5" || params["system"] == nil {
+		t.Fatalf("request item: %#v", item)
+	}
+
+	job, err = a.GetBatch(ctx, job.ID)
+	if err != nil || job.Status != llm.BatchStatusCompleted {
+		t.Fatalf("GetBatch: job=%+v err=%v", job, err)
+	}
+	results, err := a.BatchResults(ctx, job)
+	if err != nil {
+		t.Fatalf("BatchResults: %v", err)
+	}
+	resp, err := llm.FindBatchResult(results, "a")
+	if err != nil || resp.Text() != "Hello" {
+		t.Fatalf("result a: resp=%+v err=%v", resp, err)
+	}
+	for _, id := range []string{"b", "c"} {
+		if _, err := llm.FindBatchResult(results, id); err == nil {
+			t.Fatalf("result %s: expected error", id)
+		}
+	}
+}
+
+func TestAdapter_Batch_RejectsCompatibleProviders(t *testing.T) {
+	a := NewWithProvider("kimi", "k", "http://127.0.0.1:1")
+	_, err := a.SubmitBatch(context.Background(), []llm.BatchRequest{{CustomID: "a", Request: llm.Request{Model: "m"}}})
+	if err == nil {
+		t.Fatalf("expected kimi batch submission to be rejected")
+	}
+}
@@ -0,0 +1,55 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+var _ llm.TokenCounter = (*Adapter)(nil)
+
+// countTokensFields are the /v1/messages body fields accepted by
+// /v1/messages/count_tokens.
+var countTokensFields = []string{"model", "messages", "system", "tools", "tool_choice", "thinking"}
+
+// TokenCountSupported is true only for first-party Anthropic.
+func (a *Adapter) TokenCountSupported() bool {
+	return a.Name() == "anthropic"
+}
+
+// CountTokens calls /v1/messages/count_tokens.
+func (a *Adapter) CountTokens(ctx context.Context, req llm.Request) (int, error) {
+	if !a.TokenCountSupported() {
+		return 0, &llm.ConfigurationError{Message: fmt.Sprintf("provider %s does not support token counting", a.Name())}
+	}
+	req = llm.ApplyExecutionPolicy(req, llm.ExecutionPolicy(a.Name()))
+	body, beta, err := a.buildMessagesBody(req)
+	if err != nil {
+		return 0, err
+	}
+	params := map[string]any{}
+	for _, k := range countTokensFields {
+		if v, ok := body[k]; ok {
+			params[k] = v
+		}
+	}
+	payload, err := json.Marshal(params)
+	if err != nil {
+		return 0, err
+	}
+	b, err := a.doJSONRequest(ctx, http.MethodPost, a.BaseURL+"/v1/messages/count_tokens", bytes.NewReader(payload), beta, "messages.count_tokens failed")
+	if err != nil {
+		return 0, err
+	}
+	var out struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return 0, err
+	}
+	return out.InputTokens, nil
+}
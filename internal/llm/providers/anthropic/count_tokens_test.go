@@ -0,0 +1,57 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestAdapter_CountTokens_PostsCountableFields(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/messages/count_tokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &got)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"input_tokens":1234}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	a := NewWithProvider("anthropic", "k", srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	n, err := a.CountTokens(ctx, llm.Request{
+		Model:    "claude-sonnet-4-5",
+		Messages: []llm.Message{llm.System("sys"), llm.User("hi")},
+	})
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+	if n != 1234 {
+		t.Fatalf("tokens: got %d want 1234", n)
+	}
+	if got["model"] != "claude-sonnet-4-5" || got["system"] == nil || got["messages"] == nil {
+		t.Fatalf("body: %#v", got)
+	}
+	for _, k := range []string{"max_tokens", "stream"} {
+		if _, ok := got[k]; ok {
+			t.Fatalf("count_tokens body must not include %q: %#v", k, got)
+		}
+	}
+}
+
+func TestAdapter_CountTokens_RejectsCompatibleProviders(t *testing.T) {
+	a := NewWithProvider("kimi", "k", "http://127.0.0.1:0")
+	if _, err := a.CountTokens(context.Background(), llm.Request{Model: "m", Messages: []llm.Message{llm.User("hi")}}); err == nil {
+		t.Fatalf("expected error for non-first-party provider")
+	}
+}
@@ -0,0 +1,775 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+	"github.com/danshapiro/kilroy/internal/providerspec"
+)
+
+const (
+	defaultBaseURL        = "http://localhost:11434"
+	defaultRequestTimeout = 10 * time.Minute
+	// Local model loads can be slow on first use; discovery only touches
+	// metadata endpoints so it gets a much shorter budget.
+	defaultDiscoveryTimeout = 10 * time.Second
+)
+
+type Config struct {
+	Provider     string
+	APIKey       string
+	BaseURL      string
+	Path         string
+	OptionsKey   string
+	KeepAlive    string
+	ExtraHeaders map[string]string
+}
+
+type Adapter struct {
+	cfg    Config
+	client *http.Client
+}
+
+func init() {
+	llm.RegisterEnvAdapterFactory(func() (llm.ProviderAdapter, bool, error) {
+		if strings.TrimSpace(os.Getenv("OLLAMA_HOST")) == "" {
+			return nil, false, nil
+		}
+		return NewFromEnv(), true, nil
+	})
+}
+
+// NewFromEnv constructs an adapter for the Ollama server named by OLLAMA_HOST
+// (falling back to the default local endpoint).
+func NewFromEnv() *Adapter {
+	return NewAdapter(Config{
+		Provider:  "ollama",
+		APIKey:    os.Getenv("OLLAMA_API_KEY"),
+		BaseURL:   NormalizeHost(os.Getenv("OLLAMA_HOST")),
+		KeepAlive: os.Getenv("OLLAMA_KEEP_ALIVE"),
+	})
+}
+
+func NewAdapter(cfg Config) *Adapter {
+	cfg.Provider = providerspec.CanonicalProviderKey(cfg.Provider)
+	if cfg.Provider == "" {
+		cfg.Provider = "ollama"
+	}
+	cfg.APIKey = strings.TrimSpace(cfg.APIKey)
+	cfg.BaseURL = strings.TrimRight(strings.TrimSpace(cfg.BaseURL), "/")
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultBaseURL
+	}
+	if strings.TrimSpace(cfg.Path) == "" {
+		cfg.Path = "/api/chat"
+	}
+	if strings.TrimSpace(cfg.OptionsKey) == "" {
+		cfg.OptionsKey = "ollama"
+	}
+	cfg.KeepAlive = strings.TrimSpace(cfg.KeepAlive)
+	return &Adapter{
+		cfg: cfg,
+		// Avoid short client-level timeouts; rely on request context deadlines instead.
+		client: &http.Client{Timeout: 0},
+	}
+}
+
+// NormalizeHost accepts OLLAMA_HOST-style values ("127.0.0.1:11434",
+// "0.0.0.0", "http://gpu-box:11434") and returns a base URL.
+func NormalizeHost(host string) string {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if host == "" {
+		return ""
+	}
+	hasScheme := strings.Contains(host, "://")
+	if !hasScheme {
+		host = "http://" + host
+	}
+	scheme, rest, _ := strings.Cut(host, "://")
+	// A bare host means the Ollama default port; an explicit URL keeps its own.
+	if !hasScheme && !strings.Contains(rest, ":") && !strings.Contains(rest, "/") {
+		rest += ":11434"
+	}
+	if strings.HasPrefix(rest, "0.0.0.0") {
+		// 0.0.0.0 is a bind address; clients must dial loopback.
+		rest = "127.0.0.1" + strings.TrimPrefix(rest, "0.0.0.0")
+	}
+	return scheme + "://" + rest
+}
+
+func (a *Adapter) Name() string { return a.cfg.Provider }
+
+func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	requestCtx, cancel := withDefaultRequestDeadline(ctx)
+	defer cancel()
+
+	body, err := a.toChatBody(req, false)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	resp, err := a.post(requestCtx, a.cfg.Path, body)
+	if err != nil {
+		return llm.Response{}, err
+	}
+	defer resp.Body.Close()
+
+	rawBytes, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return llm.Response{}, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return llm.Response{}, a.httpError(resp, rawBytes, "chat failed")
+	}
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(rawBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return llm.Response{}, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	if msg := asString(raw["error"]); msg != "" {
+		return llm.Response{}, llm.ErrorFromHTTPStatus(a.cfg.Provider, http.StatusBadRequest, msg, raw, nil)
+	}
+	return fromChatResponse(a.cfg.Provider, req.Model, raw), nil
+}
+
+func (a *Adapter) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
+	baseCtx, baseCancel := withDefaultRequestDeadline(ctx)
+	sctx, cancel := context.WithCancel(baseCtx)
+	cancelAll := func() {
+		cancel()
+		baseCancel()
+	}
+	body, err := a.toChatBody(req, true)
+	if err != nil {
+		cancelAll()
+		return nil, err
+	}
+	resp, err := a.post(sctx, a.cfg.Path, body)
+	if err != nil {
+		cancelAll()
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		cancelAll()
+		rawBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+		return nil, a.httpError(resp, rawBytes, "chat stream failed")
+	}
+
+	s := llm.NewChanStream(cancelAll)
+	go func() {
+		defer cancelAll()
+		defer resp.Body.Close()
+		defer s.CloseSend()
+
+		s.Send(llm.StreamEvent{Type: llm.StreamEventStreamStart})
+		st := &streamState{Provider: a.cfg.Provider, Model: req.Model, TextID: "assistant_text"}
+
+		// Ollama streams newline-delimited JSON objects rather than SSE.
+		sc := bufio.NewScanner(resp.Body)
+		sc.Buffer(make([]byte, 0, 64*1024), 8<<20)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var chunk map[string]any
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.UseNumber()
+			if err := dec.Decode(&chunk); err != nil {
+				s.Send(llm.StreamEvent{Type: llm.StreamEventError, Err: llm.NewStreamError(a.cfg.Provider, err.Error())})
+				return
+			}
+			if msg := asString(chunk["error"]); msg != "" {
+				s.Send(llm.StreamEvent{Type: llm.StreamEventError, Err: llm.NewStreamError(a.cfg.Provider, msg)})
+				return
+			}
+			if st.apply(s, chunk) {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil && !errors.Is(err, context.Canceled) && sctx.Err() == nil {
+			s.Send(llm.StreamEvent{Type: llm.StreamEventError, Err: llm.NewStreamError(a.cfg.Provider, err.Error())})
+			return
+		}
+		if sctx.Err() != nil {
+			return
+		}
+		// The server closed the body without a done=true chunk.
+		s.Send(llm.StreamEvent{Type: llm.StreamEventError, Err: llm.NewStreamError(a.cfg.Provider, "stream ended before done")})
+	}()
+	return s, nil
+}
+
+// ListModels returns the models installed on the Ollama server together with
+// the context length and capabilities reported by /api/show.
+func (a *Adapter) ListModels(ctx context.Context) ([]llm.ModelInfo, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDiscoveryTimeout)
+		defer cancel()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.BaseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	a.setHeaders(httpReq)
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return nil, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	defer resp.Body.Close()
+	rawBytes, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, a.httpError(resp, rawBytes, "list models failed")
+	}
+	var tags struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(rawBytes, &tags); err != nil {
+		return nil, llm.WrapContextError(a.cfg.Provider, err)
+	}
+
+	out := make([]llm.ModelInfo, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		name := firstNonEmpty(m.Name, m.Model)
+		if name == "" {
+			continue
+		}
+		info := llm.ModelInfo{
+			ID:          name,
+			Provider:    a.cfg.Provider,
+			DisplayName: name,
+		}
+		if show, err := a.showModel(ctx, name); err == nil {
+			info.ContextWindow = show.contextLength()
+			info.SupportsTools = containsFold(show.Capabilities, "tools")
+			info.SupportsVision = containsFold(show.Capabilities, "vision")
+			info.SupportsReasoning = containsFold(show.Capabilities, "thinking")
+		}
+		// Local inference has no per-token price; record zero rather than unknown.
+		zero := 0.0
+		info.InputCostPerMillion = &zero
+		info.OutputCostPerMillion = &zero
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+type showResponse struct {
+	ModelInfo    map[string]any `json:"model_info"`
+	Capabilities []string       `json:"capabilities"`
+	Parameters   string         `json:"parameters"`
+}
+
+// contextLength prefers an explicit num_ctx from the Modelfile (what the
+// server will actually allocate) over the architecture's trained maximum.
+func (r showResponse) contextLength() int {
+	for _, line := range strings.Split(r.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	arch := asString(r.ModelInfo["general.architecture"])
+	if arch != "" {
+		if n := intFromAny(r.ModelInfo[arch+".context_length"]); n > 0 {
+			return n
+		}
+	}
+	for k, v := range r.ModelInfo {
+		if strings.HasSuffix(k, ".context_length") {
+			if n := intFromAny(v); n > 0 {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+func (a *Adapter) showModel(ctx context.Context, name string) (showResponse, error) {
+	body, err := json.Marshal(map[string]any{"model": name})
+	if err != nil {
+		return showResponse{}, err
+	}
+	resp, err := a.post(ctx, "/api/show", body)
+	if err != nil {
+		return showResponse{}, err
+	}
+	defer resp.Body.Close()
+	rawBytes, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return showResponse{}, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return showResponse{}, a.httpError(resp, rawBytes, "show model failed")
+	}
+	var out showResponse
+	dec := json.NewDecoder(bytes.NewReader(rawBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return showResponse{}, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	return out, nil
+}
+
+func (a *Adapter) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	a.setHeaders(httpReq)
+	resp, err := a.client.Do(httpReq)
+	if err != nil {
+		return nil, llm.WrapContextError(a.cfg.Provider, err)
+	}
+	return resp, nil
+}
+
+func (a *Adapter) setHeaders(httpReq *http.Request) {
+	if a.cfg.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+a.cfg.APIKey)
+	}
+	for k, v := range a.cfg.ExtraHeaders {
+		httpReq.Header.Set(k, v)
+	}
+}
+
+func (a *Adapter) httpError(resp *http.Response, rawBytes []byte, message string) error {
+	raw := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(rawBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		raw["raw_body"] = string(rawBytes)
+	}
+	if msg := asString(raw["error"]); msg != "" {
+		message = message + ": " + msg
+	}
+	ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return llm.ErrorFromHTTPStatus(a.cfg.Provider, resp.StatusCode, message, raw, ra)
+}
+
+func (a *Adapter) toChatBody(req llm.Request, stream bool) ([]byte, error) {
+	msgs, err := toChatMessages(req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	body := map[string]any{
+		"model":    req.Model,
+		"messages": msgs,
+		"stream":   stream,
+	}
+	if len(req.Tools) > 0 {
+		toolChoice := ""
+		if req.ToolChoice != nil {
+			toolChoice = strings.ToLower(strings.TrimSpace(req.ToolChoice.Mode))
+		}
+		switch toolChoice {
+		case "", "auto":
+			body["tools"] = toChatTools(req.Tools)
+		case "none":
+			// Ollama has no tool_choice; omitting tools is the only way to forbid calls.
+		default:
+			return nil, llm.NewUnsupportedToolChoiceError(a.cfg.Provider, toolChoice)
+		}
+	}
+	if req.ResponseFormat != nil {
+		switch strings.ToLower(strings.TrimSpace(req.ResponseFormat.Type)) {
+		case "json":
+			body["format"] = "json"
+		case "json_schema":
+			if len(req.ResponseFormat.JSONSchema) > 0 {
+				body["format"] = req.ResponseFormat.JSONSchema
+			} else {
+				body["format"] = "json"
+			}
+		}
+	}
+	if req.ReasoningEffort != nil {
+		switch strings.ToLower(strings.TrimSpace(*req.ReasoningEffort)) {
+		case "":
+		case "none":
+			body["think"] = false
+		default:
+			body["think"] = true
+		}
+	}
+	options := map[string]any{}
+	if req.Temperature != nil {
+		options["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		options["top_p"] = *req.TopP
+	}
+	if req.MaxTokens != nil && *req.MaxTokens > 0 {
+		options["num_predict"] = *req.MaxTokens
+	}
+	if len(req.StopSequences) > 0 {
+		options["stop"] = req.StopSequences
+	}
+	if a.cfg.KeepAlive != "" {
+		body["keep_alive"] = a.cfg.KeepAlive
+	}
+	if req.ProviderOptions != nil {
+		if ov, ok := req.ProviderOptions[a.cfg.OptionsKey].(map[string]any); ok {
+			for k, v := range ov {
+				if k == "options" {
+					if m, ok := v.(map[string]any); ok {
+						for optKey, optVal := range m {
+							options[optKey] = optVal
+						}
+						continue
+					}
+				}
+				body[k] = v
+			}
+		}
+	}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+	return json.Marshal(body)
+}
+
+func toChatMessages(msgs []llm.Message) ([]map[string]any, error) {
+	out := make([]map[string]any, 0, len(msgs))
+	for _, m := range msgs {
+		role := string(m.Role)
+		if m.Role == llm.RoleDeveloper {
+			role = string(llm.RoleSystem)
+		}
+		entry := map[string]any{"role": role}
+		textParts := []string{}
+		images := []string{}
+		toolCalls := []map[string]any{}
+		// Ollama takes one tool message per result, so each result becomes
+		// its own entry.
+		toolResults := []map[string]any{}
+		for _, p := range m.Content {
+			switch p.Kind {
+			case llm.ContentText:
+				if strings.TrimSpace(p.Text) != "" {
+					textParts = append(textParts, p.Text)
+				}
+			case llm.ContentImage:
+				if p.Image == nil {
+					continue
+				}
+				if len(p.Image.Data) == 0 {
+					return nil, &llm.ConfigurationError{Message: "ollama: image parts must carry inline data (urls are not supported)"}
+				}
+				images = append(images, base64.StdEncoding.EncodeToString(p.Image.Data))
+			case llm.ContentToolCall:
+				if p.ToolCall == nil {
+					continue
+				}
+				args := json.RawMessage(bytes.TrimSpace(p.ToolCall.Arguments))
+				if len(args) == 0 {
+					args = json.RawMessage("{}")
+				}
+				toolCalls = append(toolCalls, map[string]any{
+					"function": map[string]any{
+						"name":      p.ToolCall.Name,
+						"arguments": args,
+					},
+				})
+			case llm.ContentToolResult:
+				if p.ToolResult == nil {
+					continue
+				}
+				result := map[string]any{
+					"role":    string(llm.RoleTool),
+					"content": renderAnyAsText(p.ToolResult.Content),
+				}
+				if name := strings.TrimSpace(p.ToolResult.Name); name != "" {
+					result["tool_name"] = name
+				}
+				if len(p.ToolResult.ImageData) > 0 {
+					result["images"] = []string{base64.StdEncoding.EncodeToString(p.ToolResult.ImageData)}
+				}
+				toolResults = append(toolResults, result)
+			case llm.ContentThinking:
+				if p.Thinking != nil && strings.TrimSpace(p.Thinking.Text) != "" {
+					entry["thinking"] = p.Thinking.Text
+				}
+			}
+		}
+		if len(toolResults) > 0 && len(textParts) == 0 && len(images) == 0 && len(toolCalls) == 0 {
+			out = append(out, toolResults...)
+			continue
+		}
+		entry["content"] = strings.Join(textParts, "\n")
+		if len(images) > 0 {
+			entry["images"] = images
+		}
+		if len(toolCalls) > 0 {
+			entry["tool_calls"] = toolCalls
+		}
+		out = append(out, entry)
+		out = append(out, toolResults...)
+	}
+	return out, nil
+}
+
+func toChatTools(tools []llm.ToolDefinition) []map[string]any {
+	out := make([]map[string]any, 0, len(tools))
+	for _, td := range tools {
+		out = append(out, map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        td.Name,
+				"description": td.Description,
+				"parameters":  td.Parameters,
+			},
+		})
+	}
+	return out
+}
+
+func fromChatResponse(provider, model string, raw map[string]any) llm.Response {
+	msgMap, _ := raw["message"].(map[string]any)
+	msg := llm.Assistant(asString(msgMap["content"]))
+	if thinking := asString(msgMap["thinking"]); thinking != "" {
+		msg.Content = append([]llm.ContentPart{{
+			Kind:     llm.ContentThinking,
+			Thinking: &llm.ThinkingData{Text: thinking},
+		}}, msg.Content...)
+	}
+	calls := parseToolCalls(msgMap["tool_calls"], 0)
+	for i := range calls {
+		msg.Content = append(msg.Content, llm.ContentPart{Kind: llm.ContentToolCall, ToolCall: &calls[i]})
+	}
+	return llm.Response{
+		Model:    firstNonEmpty(model, asString(raw["model"])),
+		Provider: provider,
+		Message:  msg,
+		Finish:   finishReason(provider, asString(raw["done_reason"]), len(calls) > 0),
+		Usage:    usageFromChunk(raw),
+		Raw:      raw,
+	}
+}
+
+// parseToolCalls maps Ollama tool calls, which carry no IDs, onto llm tool
+// calls with synthetic, per-response unique IDs.
+func parseToolCalls(v any, offset int) []llm.ToolCallData {
+	callsAny, _ := v.([]any)
+	out := make([]llm.ToolCallData, 0, len(callsAny))
+	for _, c := range callsAny {
+		cm, _ := c.(map[string]any)
+		fn, _ := cm["function"].(map[string]any)
+		if fn == nil {
+			continue
+		}
+		id := asString(cm["id"])
+		if id == "" {
+			id = fmt.Sprintf("call_%d", offset+len(out)+1)
+		}
+		args := "{}"
+		switch x := fn["arguments"].(type) {
+		case string:
+			if strings.TrimSpace(x) != "" {
+				args = x
+			}
+		case nil:
+		default:
+			args = renderAnyAsText(x)
+		}
+		out = append(out, llm.ToolCallData{
+			ID:        id,
+			Type:      "function",
+			Name:      asString(fn["name"]),
+			Arguments: json.RawMessage(args),
+		})
+	}
+	return out
+}
+
+func finishReason(provider, raw string, hasToolCalls bool) llm.FinishReason {
+	if hasToolCalls {
+		return llm.FinishReason{Reason: llm.FinishReasonToolCalls, Raw: raw}
+	}
+	return llm.NormalizeFinishReason(provider, raw)
+}
+
+func usageFromChunk(raw map[string]any) llm.Usage {
+	in := intFromAny(raw["prompt_eval_count"])
+	out := intFromAny(raw["eval_count"])
+	return llm.Usage{InputTokens: in, OutputTokens: out, TotalTokens: in + out}
+}
+
+type streamState struct {
+	Provider string
+	Model    string
+	TextID   string
+
+	Text      strings.Builder
+	TextOpen  bool
+	Reasoning strings.Builder
+	Thinking  bool
+	ToolCalls []llm.ToolCallData
+}
+
+// apply emits events for one NDJSON chunk and reports whether the stream is done.
+func (st *streamState) apply(s *llm.ChanStream, chunk map[string]any) bool {
+	msgMap, _ := chunk["message"].(map[string]any)
+	if delta := asString(msgMap["thinking"]); delta != "" {
+		if !st.Thinking {
+			st.Thinking = true
+			s.Send(llm.StreamEvent{Type: llm.StreamEventReasoningStart})
+		}
+		st.Reasoning.WriteString(delta)
+		s.Send(llm.StreamEvent{Type: llm.StreamEventReasoningDelta, ReasoningDelta: delta})
+	}
+	if delta := asString(msgMap["content"]); delta != "" {
+		st.closeReasoning(s)
+		if !st.TextOpen {
+			st.TextOpen = true
+			s.Send(llm.StreamEvent{Type: llm.StreamEventTextStart, TextID: st.TextID})
+		}
+		st.Text.WriteString(delta)
+		s.Send(llm.StreamEvent{Type: llm.StreamEventTextDelta, TextID: st.TextID, Delta: delta})
+	}
+	// Ollama delivers each tool call whole, so start/delta/end are emitted together.
+	for _, tc := range parseToolCalls(msgMap["tool_calls"], len(st.ToolCalls)) {
+		call := tc
+		st.ToolCalls = append(st.ToolCalls, call)
+		start := llm.ToolCallData{ID: call.ID, Type: call.Type, Name: call.Name}
+		s.Send(llm.StreamEvent{Type: llm.StreamEventToolCallStart, ToolCall: &start})
+		delta := call
+		s.Send(llm.StreamEvent{Type: llm.StreamEventToolCallDelta, ToolCall: &delta})
+		end := call
+		s.Send(llm.StreamEvent{Type: llm.StreamEventToolCallEnd, ToolCall: &end})
+	}
+
+	done, _ := chunk["done"].(bool)
+	if !done {
+		return false
+	}
+	st.closeReasoning(s)
+	if st.TextOpen {
+		s.Send(llm.StreamEvent{Type: llm.StreamEventTextEnd, TextID: st.TextID})
+		st.TextOpen = false
+	}
+	final := st.finalResponse(chunk)
+	s.Send(llm.StreamEvent{Type: llm.StreamEventStepFinish, FinishReason: &final.Finish})
+	s.Send(llm.StreamEvent{
+		Type:         llm.StreamEventFinish,
+		FinishReason: &final.Finish,
+		Usage:        &final.Usage,
+		Response:     &final,
+	})
+	return true
+}
+
+func (st *streamState) closeReasoning(s *llm.ChanStream) {
+	if st.Thinking {
+		s.Send(llm.StreamEvent{Type: llm.StreamEventReasoningEnd})
+		st.Thinking = false
+	}
+}
+
+func (st *streamState) finalResponse(last map[string]any) llm.Response {
+	msg := llm.Assistant(st.Text.String())
+	if st.Reasoning.Len() > 0 {
+		msg.Content = append([]llm.ContentPart{{
+			Kind:     llm.ContentThinking,
+			Thinking: &llm.ThinkingData{Text: st.Reasoning.String()},
+		}}, msg.Content...)
+	}
+	for i := range st.ToolCalls {
+		msg.Content = append(msg.Content, llm.ContentPart{Kind: llm.ContentToolCall, ToolCall: &st.ToolCalls[i]})
+	}
+	return llm.Response{
+		Model:    firstNonEmpty(st.Model, asString(last["model"])),
+		Provider: st.Provider,
+		Message:  msg,
+		Finish:   finishReason(st.Provider, asString(last["done_reason"]), len(st.ToolCalls) > 0),
+		Usage:    usageFromChunk(last),
+		Raw:      last,
+	}
+}
+
+func renderAnyAsText(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+func asString(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	default:
+		return ""
+	}
+}
+
+func intFromAny(v any) int {
+	switch x := v.(type) {
+	case int:
+		return x
+	case int64:
+		return int(x)
+	case float64:
+		return int(x)
+	case json.Number:
+		i, _ := x.Int64()
+		return int(i)
+	default:
+		return 0
+	}
+}
+
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), target) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstNonEmpty(a, b string) string {
+	if strings.TrimSpace(a) != "" {
+		return strings.TrimSpace(a)
+	}
+	return strings.TrimSpace(b)
+}
+
+func withDefaultRequestDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		return context.WithTimeout(context.Background(), defaultRequestTimeout)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, defaultRequestTimeout)
+}
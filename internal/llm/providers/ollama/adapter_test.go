@@ -0,0 +1,248 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestAdapter_Complete_MapsToolCallsAndUsage(t *testing.T) {
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Fatalf("path: %s", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Fatalf("expected no auth header without api key, got %q", got)
+		}
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &gotBody)
+		_, _ = w.Write([]byte(`{"model":"qwen3:8b","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"read_file","arguments":{"file_path":"README.md"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":5}`))
+	}))
+	defer srv.Close()
+
+	a := NewAdapter(Config{BaseURL: srv.URL, KeepAlive: "30m"})
+	maxTokens := 64
+	resp, err := a.Complete(context.Background(), llm.Request{
+		Model:     "qwen3:8b",
+		Messages:  []llm.Message{llm.User("hi")},
+		Tools:     []llm.ToolDefinition{{Name: "read_file", Parameters: map[string]any{"type": "object"}}},
+		MaxTokens: &maxTokens,
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if gotBody["stream"] != false || gotBody["keep_alive"] != "30m" {
+		t.Fatalf("unexpected request body: %+v", gotBody)
+	}
+	if opts, _ := gotBody["options"].(map[string]any); opts["num_predict"] != float64(64) {
+		t.Fatalf("max tokens should map to options.num_predict: %+v", gotBody["options"])
+	}
+	calls := resp.ToolCalls()
+	if len(calls) != 1 || calls[0].Name != "read_file" || calls[0].ID == "" {
+		t.Fatalf("tool call mapping failed: %+v", calls)
+	}
+	if string(calls[0].Arguments) != `{"file_path":"README.md"}` {
+		t.Fatalf("arguments: %s", calls[0].Arguments)
+	}
+	if resp.Finish.Reason != llm.FinishReasonToolCalls {
+		t.Fatalf("finish: %+v", resp.Finish)
+	}
+	if resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 5 || resp.Usage.TotalTokens != 17 {
+		t.Fatalf("usage: %+v", resp.Usage)
+	}
+}
+
+func TestAdapter_Complete_SendsToolResultsWithToolName(t *testing.T) {
+	var gotBody struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, &gotBody)
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"done"},"done":true,"done_reason":"stop"}`))
+	}))
+	defer srv.Close()
+
+	a := NewAdapter(Config{BaseURL: srv.URL})
+	_, err := a.Complete(context.Background(), llm.Request{
+		Model: "qwen3:8b",
+		Messages: []llm.Message{
+			llm.User("read it"),
+			{Role: llm.RoleAssistant, Content: []llm.ContentPart{{
+				Kind:     llm.ContentToolCall,
+				ToolCall: &llm.ToolCallData{ID: "call_1", Name: "read_file", Arguments: json.RawMessage(`{"file_path":"a"}`)},
+			}}},
+			llm.ToolResultNamed("call_1", "read_file", "contents", false),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if len(gotBody.Messages) != 3 {
+		t.Fatalf("messages: %+v", gotBody.Messages)
+	}
+	assistant := gotBody.Messages[1]
+	calls, _ := assistant["tool_calls"].([]any)
+	fn, _ := calls[0].(map[string]any)["function"].(map[string]any)
+	if args, _ := fn["arguments"].(map[string]any); args["file_path"] != "a" {
+		t.Fatalf("tool call arguments should be sent as an object: %+v", fn)
+	}
+	tool := gotBody.Messages[2]
+	if tool["role"] != "tool" || tool["tool_name"] != "read_file" || tool["content"] != "contents" {
+		t.Fatalf("tool result message: %+v", tool)
+	}
+}
+
+func TestToChatMessages_EmitsOneToolMessagePerResult(t *testing.T) {
+	a := llm.ToolResultNamed("call_1", "read_file", "first", false)
+	b := llm.ToolResultNamed("call_2", "grep", "second", false)
+	msg := llm.Message{Role: llm.RoleTool, Content: append(a.Content, b.Content...)}
+	got, err := toChatMessages([]llm.Message{msg})
+	if err != nil {
+		t.Fatalf("toChatMessages: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("messages: %+v", got)
+	}
+	for i, want := range []struct{ name, content string }{{"read_file", "first"}, {"grep", "second"}} {
+		if got[i]["role"] != "tool" || got[i]["tool_name"] != want.name || got[i]["content"] != want.content {
+			t.Fatalf("message %d: %+v", i, got[i])
+		}
+	}
+}
+
+func TestAdapter_Complete_RejectsRequiredToolChoice(t *testing.T) {
+	a := NewAdapter(Config{BaseURL: "http://127.0.0.1:0"})
+	_, err := a.Complete(context.Background(), llm.Request{
+		Model:      "qwen3:8b",
+		Messages:   []llm.Message{llm.User("hi")},
+		Tools:      []llm.ToolDefinition{{Name: "t"}},
+		ToolChoice: &llm.ToolChoice{Mode: "required"},
+	})
+	if err == nil {
+		t.Fatalf("expected unsupported tool_choice error")
+	}
+}
+
+func TestAdapter_Stream_ParsesNDJSONChunks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","thinking":"hmm"},"done":false}` + "\n"))
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n"))
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"lo"},"done":false}` + "\n"))
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":2}` + "\n"))
+	}))
+	defer srv.Close()
+
+	a := NewAdapter(Config{BaseURL: srv.URL})
+	stream, err := a.Stream(context.Background(), llm.Request{
+		Model:    "qwen3:8b",
+		Messages: []llm.Message{llm.User("hi")},
+	})
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+
+	var final *llm.Response
+	sawReasoning := false
+	for ev := range stream.Events() {
+		switch ev.Type {
+		case llm.StreamEventReasoningDelta:
+			sawReasoning = true
+		case llm.StreamEventError:
+			t.Fatalf("stream error: %v", ev.Err)
+		case llm.StreamEventFinish:
+			final = ev.Response
+		}
+	}
+	if !sawReasoning {
+		t.Fatalf("expected reasoning delta event")
+	}
+	if final == nil {
+		t.Fatalf("expected finish event")
+	}
+	if final.Text() != "Hello" || final.Finish.Reason != llm.FinishReasonStop || final.Usage.TotalTokens != 5 {
+		t.Fatalf("final response: text=%q finish=%+v usage=%+v", final.Text(), final.Finish, final.Usage)
+	}
+}
+
+func TestAdapter_Stream_ErrorsWhenBodyEndsBeforeDone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"partial"},"done":false}` + "\n"))
+	}))
+	defer srv.Close()
+
+	a := NewAdapter(Config{BaseURL: srv.URL})
+	stream, err := a.Stream(context.Background(), llm.Request{Model: "m", Messages: []llm.Message{llm.User("hi")}})
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	defer stream.Close()
+	sawErr := false
+	for ev := range stream.Events() {
+		if ev.Type == llm.StreamEventError {
+			sawErr = true
+		}
+	}
+	if !sawErr {
+		t.Fatalf("expected stream error for truncated body")
+	}
+}
+
+func TestAdapter_ListModels_ReadsContextLengthAndCapabilities(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"qwen3:8b"},{"name":"tuned:latest"}]}`))
+		case "/api/show":
+			var req map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req["model"] == "tuned:latest" {
+				_, _ = w.Write([]byte(`{"parameters":"num_ctx                        16384\nstop \"<|im_end|>\"","model_info":{"general.architecture":"llama","llama.context_length":131072},"capabilities":["completion"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"model_info":{"general.architecture":"qwen3","qwen3.context_length":40960},"capabilities":["completion","tools","thinking"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	models, err := NewAdapter(Config{BaseURL: srv.URL}).ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if len(models) != 2 {
+		t.Fatalf("models: %+v", models)
+	}
+	if m := models[0]; m.ID != "qwen3:8b" || m.Provider != "ollama" || m.ContextWindow != 40960 || !m.SupportsTools || !m.SupportsReasoning || m.SupportsVision {
+		t.Fatalf("qwen3 metadata: %+v", m)
+	}
+	if m := models[1]; m.ContextWindow != 16384 || m.SupportsTools {
+		t.Fatalf("num_ctx from Modelfile should win over trained context: %+v", m)
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	cases := map[string]string{
+		"":                    "",
+		"127.0.0.1:11434":     "http://127.0.0.1:11434",
+		"0.0.0.0":             "http://127.0.0.1:11434",
+		"gpu-box":             "http://gpu-box:11434",
+		"https://ollama.lan/": "https://ollama.lan",
+		"http://gpu-box:8080": "http://gpu-box:8080",
+		"http://proxy/ollama": "http://proxy/ollama",
+	}
+	for in, want := range cases {
+		if got := NormalizeHost(in); got != want {
+			t.Fatalf("NormalizeHost(%q)=%q want %q", in, got, want)
+		}
+	}
+}
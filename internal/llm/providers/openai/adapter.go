@@ -74,10 +74,51 @@ func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response,
 		a.Client = &http.Client{Timeout: 0}
 	}
 
-	instructions, inputItems, err := toResponsesInput(req.Messages)
+	body, err := toResponsesBody(req)
+	if err != nil {
+		return llm.Response{}, err
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return llm.Response{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BaseURL+"/v1/responses", bytes.NewReader(b))
+	if err != nil {
+		return llm.Response{}, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+a.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.Client.Do(httpReq)
 	if err != nil {
 		return llm.Response{}, err
 	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var raw map[string]any
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return llm.Response{}, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		msg := fmt.Sprintf("responses.create failed: %v", raw)
+		return llm.Response{}, llm.ErrorFromHTTPStatus(a.Name(), resp.StatusCode, msg, raw, ra)
+	}
+
+	return fromResponses(a.Name(), raw, req.Model), nil
+}
+
+// toResponsesBody builds a non-streaming /v1/responses request body. It is
+// shared by Complete and batch submission.
+func toResponsesBody(req llm.Request) (map[string]any, error) {
+	instructions, inputItems, err := toResponsesInput(req.Messages)
+	if err != nil {
+		return nil, err
+	}
 
 	body := map[string]any{
 		"model":               req.Model,
@@ -93,7 +134,7 @@ func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response,
 	if req.ToolChoice != nil {
 		tc, err := toResponsesToolChoice(*req.ToolChoice)
 		if err != nil {
-			return llm.Response{}, err
+			return nil, err
 		}
 		body["tool_choice"] = tc
 	}
@@ -125,38 +166,7 @@ func (a *Adapter) Complete(ctx context.Context, req llm.Request) (llm.Response,
 			}
 		}
 	}
-
-	b, err := json.Marshal(body)
-	if err != nil {
-		return llm.Response{}, err
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.BaseURL+"/v1/responses", bytes.NewReader(b))
-	if err != nil {
-		return llm.Response{}, err
-	}
-	httpReq.Header.Set("Authorization", "Bearer "+a.APIKey)
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := a.Client.Do(httpReq)
-	if err != nil {
-		return llm.Response{}, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	var raw map[string]any
-	dec := json.NewDecoder(resp.Body)
-	dec.UseNumber()
-	if err := dec.Decode(&raw); err != nil {
-		return llm.Response{}, err
-	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
-		msg := fmt.Sprintf("responses.create failed: %v", raw)
-		return llm.Response{}, llm.ErrorFromHTTPStatus(a.Name(), resp.StatusCode, msg, raw, ra)
-	}
-
-	return fromResponses(a.Name(), raw, req.Model), nil
+	return body, nil
 }
 
 func (a *Adapter) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
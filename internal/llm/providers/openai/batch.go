@@ -0,0 +1,234 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+var _ llm.BatchAdapter = (*Adapter)(nil)
+
+// BatchSupported is true only for first-party OpenAI; other providers routed
+// through the Responses adapter are not assumed to expose /v1/batches.
+func (a *Adapter) BatchSupported() bool {
+	return a.Name() == "openai"
+}
+
+// SubmitBatch uploads the requests as a JSONL file and creates a /v1/responses
+// batch with a 24h completion window.
+func (a *Adapter) SubmitBatch(ctx context.Context, reqs []llm.BatchRequest) (llm.BatchJob, error) {
+	if len(reqs) == 0 {
+		return llm.BatchJob{}, &llm.ConfigurationError{Message: "batch requires at least one request"}
+	}
+	var jsonl bytes.Buffer
+	for _, br := range reqs {
+		body, err := toResponsesBody(br.Request)
+		if err != nil {
+			return llm.BatchJob{}, err
+		}
+		line, err := json.Marshal(map[string]any{
+			"custom_id": br.CustomID,
+			"method":    http.MethodPost,
+			"url":       "/v1/responses",
+			"body":      body,
+		})
+		if err != nil {
+			return llm.BatchJob{}, err
+		}
+		jsonl.Write(line)
+		jsonl.WriteByte('\n')
+	}
+
+	fileID, err := a.uploadBatchFile(ctx, jsonl.Bytes())
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	payload, err := json.Marshal(map[string]any{
+		"input_file_id":     fileID,
+		"endpoint":          "/v1/responses",
+		"completion_window": "24h",
+	})
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	raw, err := a.doBatchJSON(ctx, http.MethodPost, "/v1/batches", bytes.NewReader(payload), "application/json", "batches.create failed")
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	return a.batchJobFromRaw(raw), nil
+}
+
+func (a *Adapter) GetBatch(ctx context.Context, id string) (llm.BatchJob, error) {
+	raw, err := a.doBatchJSON(ctx, http.MethodGet, "/v1/batches/"+strings.TrimSpace(id), nil, "", "batches.retrieve failed")
+	if err != nil {
+		return llm.BatchJob{}, err
+	}
+	return a.batchJobFromRaw(raw), nil
+}
+
+// BatchResults downloads the output file (and error file, when present) and
+// maps each line back to its custom_id.
+func (a *Adapter) BatchResults(ctx context.Context, job llm.BatchJob) ([]llm.BatchResult, error) {
+	if job.Status != llm.BatchStatusCompleted {
+		return nil, fmt.Errorf("batch %s is not completed (status=%s)", job.ID, job.RawStatus)
+	}
+	var out []llm.BatchResult
+	for _, fileID := range []string{job.ResultsRef, job.ErrorsRef} {
+		if strings.TrimSpace(fileID) == "" {
+			continue
+		}
+		b, err := a.doBatchRaw(ctx, http.MethodGet, "/v1/files/"+fileID+"/content", nil, "", "files.content failed")
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		sc.Buffer(make([]byte, 0, 64*1024), 32<<20)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var entry map[string]any
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.UseNumber()
+			if err := dec.Decode(&entry); err != nil {
+				return nil, err
+			}
+			out = append(out, a.batchResultFromLine(entry))
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (a *Adapter) batchResultFromLine(entry map[string]any) llm.BatchResult {
+	res := llm.BatchResult{CustomID: fmt.Sprint(entry["custom_id"])}
+	if e, ok := entry["error"].(map[string]any); ok && len(e) > 0 {
+		res.Err = llm.ErrorFromHTTPStatus(a.Name(), http.StatusBadRequest, fmt.Sprintf("batch request failed: %v", e["message"]), e, nil)
+		return res
+	}
+	resp, _ := entry["response"].(map[string]any)
+	status := intFromJSON(resp["status_code"])
+	body, _ := resp["body"].(map[string]any)
+	if status < 200 || status >= 300 {
+		res.Err = llm.ErrorFromHTTPStatus(a.Name(), status, fmt.Sprintf("batch request failed: %v", body), body, nil)
+		return res
+	}
+	model, _ := body["model"].(string)
+	r := fromResponses(a.Name(), body, model)
+	res.Response = &r
+	return res
+}
+
+func (a *Adapter) batchJobFromRaw(raw map[string]any) llm.BatchJob {
+	job := llm.BatchJob{Provider: a.Name(), Raw: raw}
+	job.ID, _ = raw["id"].(string)
+	job.RawStatus, _ = raw["status"].(string)
+	job.ResultsRef, _ = raw["output_file_id"].(string)
+	job.ErrorsRef, _ = raw["error_file_id"].(string)
+	switch job.RawStatus {
+	case "completed":
+		job.Status = llm.BatchStatusCompleted
+	case "failed", "expired", "cancelled":
+		job.Status = llm.BatchStatusFailed
+	default:
+		job.Status = llm.BatchStatusPending
+	}
+	return job
+}
+
+func (a *Adapter) uploadBatchFile(ctx context.Context, content []byte) (string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("purpose", "batch"); err != nil {
+		return "", err
+	}
+	fw, err := mw.CreateFormFile("file", "batch.jsonl")
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(content); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	raw, err := a.doBatchJSON(ctx, http.MethodPost, "/v1/files", &buf, mw.FormDataContentType(), "files.create failed")
+	if err != nil {
+		return "", err
+	}
+	id, _ := raw["id"].(string)
+	if strings.TrimSpace(id) == "" {
+		return "", fmt.Errorf("files.create response missing id")
+	}
+	return id, nil
+}
+
+func (a *Adapter) doBatchJSON(ctx context.Context, method, path string, body io.Reader, contentType, failMsg string) (map[string]any, error) {
+	b, err := a.doBatchRaw(ctx, method, path, body, contentType, failMsg)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func (a *Adapter) doBatchRaw(ctx context.Context, method, path string, body io.Reader, contentType, failMsg string) ([]byte, error) {
+	if a.Client == nil {
+		a.Client = &http.Client{Timeout: 0}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, a.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+a.APIKey)
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	resp, err := a.Client.Do(httpReq)
+	if err != nil {
+		return nil, llm.WrapContextError(a.Name(), err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, llm.WrapContextError(a.Name(), err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var raw map[string]any
+		_ = json.Unmarshal(b, &raw)
+		ra := llm.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, llm.ErrorFromHTTPStatus(a.Name(), resp.StatusCode, fmt.Sprintf("%s: %s", failMsg, strings.TrimSpace(string(b))), raw, ra)
+	}
+	return b, nil
+}
+
+func intFromJSON(v any) int {
+	switch x := v.(type) {
+	case json.Number:
+		n, _ := x.Int64()
+		return int(n)
+	case float64:
+		return int(x)
+	case int:
+		return x
+	default:
+		return 0
+	}
+}
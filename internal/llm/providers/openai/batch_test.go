@@ -0,0 +1,121 @@
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestAdapter_Batch_SubmitPollAndResults(t *testing.T) {
+	var uploaded []map[string]any
+	var created map[string]any
+	polls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/files":
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("parse multipart: %v", err)
+			}
+			if got := r.FormValue("purpose"); got != "batch" {
+				t.Errorf("purpose: got %q", got)
+			}
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("form file: %v", err)
+				return
+			}
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				var line map[string]any
+				_ = json.Unmarshal(sc.Bytes(), &line)
+				uploaded = append(uploaded, line)
+			}
+			_, _ = w.Write([]byte(`{"id":"file_in"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/batches":
+			b, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(b, &created)
+			_, _ = w.Write([]byte(`{"id":"batch_1","status":"validating"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/batches/batch_1":
+			polls++
+			if polls < 2 {
+				_, _ = w.Write([]byte(`{"id":"batch_1","status":"in_progress"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"batch_1","status":"completed","output_file_id":"file_out","error_file_id":"file_err"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/files/file_out/content":
+			_, _ = w.Write([]byte(`{"custom_id":"a","response":{"status_code":200,"body":{"id":"resp_a","model":"gpt-5.2","output":[{"type":"message","content":[{"type":"output_text","text":"Hello"}]}],"usage":{"input_tokens":1,"output_tokens":2}}},"error":null}` + "\n"))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/files/file_err/content":
+			_, _ = w.Write([]byte(`{"custom_id":"b","response":{"status_code":400,"body":{"error":{"message":"bad"}}},"error":null}` + "\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	a := &Adapter{APIKey: "k", BaseURL: srv.URL, Client: srv.Client()}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	job, err := a.SubmitBatch(ctx, []llm.BatchRequest{
+		{CustomID: "a", Request: llm.Request{Model: "gpt-5.2", Messages: []llm.Message{llm.User("hi")}}},
+		{CustomID: "b", Request: llm.Request{Model: "gpt-5.2", Messages: []llm.Message{llm.User("yo")}}},
+	})
+	if err != nil {
+		t.Fatalf("SubmitBatch: %v", err)
+	}
+	if job.ID != "batch_1" || job.Status != llm.BatchStatusPending || job.Provider != "openai" {
+		t.Fatalf("job: %+v", job)
+	}
+	if len(uploaded) != 2 || uploaded[0]["custom_id"] != "a" || uploaded[0]["url"] != "/v1/responses" {
+		t.Fatalf("uploaded: %#v", uploaded)
+	}
+	if body, _ := uploaded[0]["body"].(map[string]any); body["model"] != "gpt-5.2" {
+		t.Fatalf("uploaded body: %#v", uploaded[0]["body"])
+	}
+	if created["input_file_id"] != "file_in" || created["endpoint"] != "/v1/responses" || created["completion_window"] != "24h" {
+		t.Fatalf("create body: %#v", created)
+	}
+
+	job, err = a.GetBatch(ctx, job.ID)
+	if err != nil || job.Done() {
+		t.Fatalf("first poll: job=%+v err=%v", job, err)
+	}
+	job, err = a.GetBatch(ctx, job.ID)
+	if err != nil || job.Status != llm.BatchStatusCompleted || job.ResultsRef != "file_out" {
+		t.Fatalf("second poll: job=%+v err=%v", job, err)
+	}
+
+	results, err := a.BatchResults(ctx, job)
+	if err != nil {
+		t.Fatalf("BatchResults: %v", err)
+	}
+	resp, err := llm.FindBatchResult(results, "a")
+	if err != nil {
+		t.Fatalf("result a: %v", err)
+	}
+	if resp.Text() != "Hello" || resp.Usage.OutputTokens != 2 {
+		t.Fatalf("result a: %+v", resp)
+	}
+	if _, err := llm.FindBatchResult(results, "b"); err == nil || !strings.Contains(err.Error(), "bad") {
+		t.Fatalf("result b: expected error, got %v", err)
+	}
+}
+
+func TestAdapter_GetBatch_MapsTerminalFailures(t *testing.T) {
+	for _, status := range []string{"failed", "expired", "cancelled"} {
+		job := (&Adapter{}).batchJobFromRaw(map[string]any{"id": "b", "status": status})
+		if job.Status != llm.BatchStatusFailed || !job.Done() {
+			t.Fatalf("%s: got %+v", status, job)
+		}
+	}
+}
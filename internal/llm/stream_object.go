@@ -0,0 +1,274 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type ObjectStreamEventType string
+
+const (
+	// ObjectStreamEventPartial carries the best-effort repaired object parsed
+	// from the text received so far. Emitted only when the value changes.
+	ObjectStreamEventPartial ObjectStreamEventType = "PARTIAL"
+	// ObjectStreamEventFieldComplete reports that a direct child of the root
+	// object/array (Path, as a JSON pointer) has been fully received.
+	ObjectStreamEventFieldComplete ObjectStreamEventType = "FIELD_COMPLETE"
+	// ObjectStreamEventRepair reports that the previous attempt produced no
+	// valid object and a repair attempt is starting.
+	ObjectStreamEventRepair ObjectStreamEventType = "REPAIR"
+	// ObjectStreamEventFinish carries the validated result.
+	ObjectStreamEventFinish ObjectStreamEventType = "FINISH"
+	ObjectStreamEventError  ObjectStreamEventType = "ERROR"
+)
+
+type ObjectStreamEvent struct {
+	Type ObjectStreamEventType
+
+	// Attempt is 0 for the initial generation and increments per repair.
+	Attempt int
+
+	// Partial and field events.
+	Partial any
+	Path    string
+	Value   any
+
+	// Finish event.
+	Result *GenerateResult
+
+	// Repair and error events.
+	Err error
+}
+
+type StreamObjectOptions struct {
+	GenerateObjectOptions
+
+	// MaxRepairAttempts is the number of follow-up generations made after a
+	// NoObjectGeneratedError. Each repair replays the conversation with the
+	// invalid output and the parse/validation error appended. Zero disables
+	// repair.
+	MaxRepairAttempts int
+}
+
+// ObjectStreamResult yields ObjectStreamEvent values over Events() and exposes
+// the validated result once the stream ends. Callers must drain Events() or
+// call Close(); the producer blocks once the event buffer is full.
+type ObjectStreamResult struct {
+	events  chan ObjectStreamEvent
+	cancel  context.CancelFunc
+	closing chan struct{}
+	once    sync.Once
+
+	mu      sync.Mutex
+	result  *GenerateResult
+	partial any
+	err     error
+
+	done chan struct{}
+}
+
+func (r *ObjectStreamResult) Events() <-chan ObjectStreamEvent { return r.events }
+
+// Close cancels generation and waits for the producer to stop. Pending events
+// are dropped.
+func (r *ObjectStreamResult) Close() error {
+	r.once.Do(func() {
+		r.cancel()
+		close(r.closing)
+	})
+	<-r.done
+	return nil
+}
+
+// Result blocks until the stream ends and returns the validated result.
+func (r *ObjectStreamResult) Result() (*GenerateResult, error) {
+	if r == nil {
+		return nil, fmt.Errorf("object stream result is nil")
+	}
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.result, r.err
+}
+
+// PartialObject returns the latest best-effort partial object (may be nil).
+func (r *ObjectStreamResult) PartialObject() any {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.partial
+}
+
+func (r *ObjectStreamResult) send(ev ObjectStreamEvent) {
+	select {
+	case r.events <- ev:
+	case <-r.closing:
+	}
+}
+
+// StreamObject is the streaming form of GenerateObject. It emits partial-object
+// events while text streams in, aborts an attempt as soon as the text can no
+// longer become valid JSON, validates the final object against the schema, and
+// optionally repairs on NoObjectGeneratedError.
+func StreamObject(ctx context.Context, opts StreamObjectOptions) (*ObjectStreamResult, error) {
+	if opts.Schema == nil {
+		return nil, &ConfigurationError{Message: "schema is required"}
+	}
+	schema, err := compileJSONSchema(opts.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxRepairAttempts < 0 {
+		return nil, &ConfigurationError{Message: "max repair attempts must be >= 0"}
+	}
+	base := objectGenerateOptions(opts.GenerateObjectOptions)
+	if opts.Prompt != nil && len(opts.Messages) > 0 {
+		return nil, &ConfigurationError{Message: "provide either prompt or messages, not both"}
+	}
+	if opts.Prompt != nil {
+		base.Messages = []Message{User(*opts.Prompt)}
+		base.Prompt = nil
+	}
+
+	sctx, cancel := context.WithCancel(ctx)
+	res := &ObjectStreamResult{
+		events:  make(chan ObjectStreamEvent, 128),
+		cancel:  cancel,
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	fail := func(err error) {
+		res.mu.Lock()
+		res.err = err
+		res.mu.Unlock()
+		res.send(ObjectStreamEvent{Type: ObjectStreamEventError, Err: err})
+	}
+
+	go func() {
+		defer close(res.done)
+		defer close(res.events)
+		defer cancel()
+
+		messages := append([]Message{}, base.Messages...)
+		for attempt := 0; ; attempt++ {
+			ro := base
+			ro.Messages = messages
+			gen, text, err := streamObjectAttempt(sctx, ro, attempt, res)
+			if err == nil {
+				var out any
+				out, err = parseAndValidateObject(gen.Text, schema)
+				if err == nil {
+					gen.Output = out
+					res.mu.Lock()
+					res.result = gen
+					res.partial = out
+					res.mu.Unlock()
+					res.send(ObjectStreamEvent{Type: ObjectStreamEventFinish, Attempt: attempt, Result: gen})
+					return
+				}
+			}
+			var noe *NoObjectGeneratedError
+			if !errors.As(err, &noe) || attempt >= opts.MaxRepairAttempts {
+				fail(err)
+				return
+			}
+			res.send(ObjectStreamEvent{Type: ObjectStreamEventRepair, Attempt: attempt + 1, Err: err})
+			messages = append(messages, objectRepairMessages(text, err)...)
+		}
+	}()
+	return res, nil
+}
+
+// streamObjectAttempt runs one streaming generation, forwarding partial and
+// field-completion events. It returns the raw text alongside any error so a
+// repair attempt can quote it.
+func streamObjectAttempt(ctx context.Context, opts GenerateOptions, attempt int, res *ObjectStreamResult) (*GenerateResult, string, error) {
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	st, err := StreamGenerate(actx, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = st.Close() }()
+
+	acc := NewStreamAccumulator()
+	var lastPartial any
+	emitted := 0
+	var text string
+	for ev := range st.Events() {
+		switch ev.Type {
+		case StreamEventStepFinish:
+			// Tool rounds precede the object; only the final step's text counts.
+			acc = NewStreamAccumulator()
+			lastPartial, emitted = nil, 0
+			continue
+		case StreamEventTextDelta:
+		default:
+			continue
+		}
+		acc.Process(ev)
+		pr := acc.PartialResponse()
+		if pr == nil {
+			continue
+		}
+		text = pr.Text()
+		pj, perr := parsePartialJSON(text)
+		if perr != nil {
+			cancel()
+			return nil, text, NewNoObjectGeneratedError(fmt.Sprintf("malformed JSON output: %v", perr), text)
+		}
+		if pj.Value != nil && !reflect.DeepEqual(pj.Value, lastPartial) {
+			lastPartial = pj.Value
+			res.mu.Lock()
+			res.partial = pj.Value
+			res.mu.Unlock()
+			res.send(ObjectStreamEvent{Type: ObjectStreamEventPartial, Attempt: attempt, Partial: pj.Value})
+		}
+		for ; emitted < len(pj.Completed); emitted++ {
+			path := pj.Completed[emitted]
+			res.send(ObjectStreamEvent{
+				Type:    ObjectStreamEventFieldComplete,
+				Attempt: attempt,
+				Path:    path,
+				Value:   jsonPointerChild(pj.Value, path),
+			})
+		}
+	}
+	resp, err := st.Response()
+	if err != nil {
+		return nil, text, err
+	}
+	if resp == nil {
+		return nil, text, NewStreamError(opts.Provider, "stream ended without response")
+	}
+	return &GenerateResult{
+		Text:         resp.Text(),
+		Reasoning:    resp.ReasoningText(),
+		ToolCalls:    resp.ToolCalls(),
+		FinishReason: resp.Finish,
+		Usage:        resp.Usage,
+		TotalUsage:   resp.Usage,
+		Response:     *resp,
+	}, resp.Text(), nil
+}
+
+// jsonPointerChild resolves a single-segment pointer produced by
+// parsePartialJSON against v.
+func jsonPointerChild(v any, path string) any {
+	seg := unescapeJSONPointer(path[1:])
+	switch x := v.(type) {
+	case map[string]any:
+		return x[seg]
+	case []any:
+		var idx int
+		if _, err := fmt.Sscanf(seg, "%d", &idx); err == nil && idx >= 0 && idx < len(x) {
+			return x[idx]
+		}
+	}
+	return nil
+}
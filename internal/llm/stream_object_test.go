@@ -0,0 +1,174 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scriptedTextStream(deltas ...string) func(ctx context.Context, req Request) (Stream, error) {
+	return func(ctx context.Context, req Request) (Stream, error) {
+		sctx, cancel := context.WithCancel(ctx)
+		st := NewChanStream(cancel)
+		go func() {
+			defer st.CloseSend()
+			st.Send(StreamEvent{Type: StreamEventStreamStart})
+			var full strings.Builder
+			for _, d := range deltas {
+				if sctx.Err() != nil {
+					return
+				}
+				full.WriteString(d)
+				st.Send(StreamEvent{Type: StreamEventTextDelta, TextID: "t", Delta: d})
+			}
+			resp := Response{Provider: "openai", Model: req.Model, Message: Assistant(full.String()), Finish: FinishReason{Reason: "stop"}}
+			st.Send(StreamEvent{Type: StreamEventFinish, FinishReason: &resp.Finish, Response: &resp})
+		}()
+		return st, nil
+	}
+}
+
+func personSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+			"age":  map[string]any{"type": "integer"},
+		},
+		"required": []string{"name", "age"},
+	}
+}
+
+func TestStreamObject_EmitsPartialsFieldCompletionAndFinish(t *testing.T) {
+	c := NewClient()
+	c.Register(&scriptedStreamAdapter{
+		name:    "openai",
+		scripts: []func(ctx context.Context, req Request) (Stream, error){scriptedTextStream(`{"na`, `me": "Al`, `ice", "age"`, `: 30}`)},
+	})
+	prompt := "extract"
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	res, err := StreamObject(ctx, StreamObjectOptions{GenerateObjectOptions: GenerateObjectOptions{
+		GenerateOptions: GenerateOptions{Client: c, Model: "m", Prompt: &prompt},
+		Schema:          personSchema(),
+	}})
+	if err != nil {
+		t.Fatalf("StreamObject: %v", err)
+	}
+	defer res.Close()
+
+	var partials []any
+	var fields []string
+	var finished bool
+	for ev := range res.Events() {
+		switch ev.Type {
+		case ObjectStreamEventPartial:
+			partials = append(partials, ev.Partial)
+		case ObjectStreamEventFieldComplete:
+			fields = append(fields, ev.Path)
+		case ObjectStreamEventFinish:
+			finished = true
+		case ObjectStreamEventError:
+			t.Fatalf("unexpected error event: %v", ev.Err)
+		}
+	}
+	if !finished {
+		t.Fatalf("missing finish event")
+	}
+	if len(partials) < 2 {
+		t.Fatalf("expected incremental partials, got %#v", partials)
+	}
+	if first, _ := partials[0].(map[string]any); len(first) != 0 {
+		t.Fatalf("first partial should be empty object, got %#v", partials[0])
+	}
+	if strings.Join(fields, ",") != "/name,/age" {
+		t.Fatalf("field completions: %v", fields)
+	}
+	out, err := res.Result()
+	if err != nil {
+		t.Fatalf("Result: %v", err)
+	}
+	if m, _ := out.Output.(map[string]any); m["name"] != "Alice" {
+		t.Fatalf("output: %#v", out.Output)
+	}
+}
+
+func TestStreamObject_MalformedOutputFailsEarlyAndRepairs(t *testing.T) {
+	c := NewClient()
+	a := &scriptedStreamAdapter{
+		name: "openai",
+		scripts: []func(ctx context.Context, req Request) (Stream, error){
+			scriptedTextStream(`Sure! `, `Here is {"name": "Alice"}`),
+			scriptedTextStream(`{"name": "Alice"}`),
+			scriptedTextStream(`{"name": "Alice", "age": 30}`),
+		},
+	}
+	c.Register(a)
+	prompt := "extract"
+	res, err := StreamObject(context.Background(), StreamObjectOptions{
+		GenerateObjectOptions: GenerateObjectOptions{
+			GenerateOptions: GenerateOptions{Client: c, Model: "m", Prompt: &prompt},
+			Schema:          personSchema(),
+		},
+		MaxRepairAttempts: 2,
+	})
+	if err != nil {
+		t.Fatalf("StreamObject: %v", err)
+	}
+	var repairs []int
+	for ev := range res.Events() {
+		if ev.Type == ObjectStreamEventRepair {
+			var noe *NoObjectGeneratedError
+			if !errors.As(ev.Err, &noe) {
+				t.Fatalf("repair cause: %T %v", ev.Err, ev.Err)
+			}
+			repairs = append(repairs, ev.Attempt)
+		}
+	}
+	out, err := res.Result()
+	if err != nil {
+		t.Fatalf("Result: %v", err)
+	}
+	if len(repairs) != 2 || repairs[0] != 1 || repairs[1] != 2 {
+		t.Fatalf("repairs: %v", repairs)
+	}
+	if m, _ := out.Output.(map[string]any); m["age"] == nil {
+		t.Fatalf("output: %#v", out.Output)
+	}
+
+	reqs := a.Requests()
+	if len(reqs) != 3 {
+		t.Fatalf("requests: %d", len(reqs))
+	}
+	// The first attempt is aborted at the leading prose, so only that prefix is quoted back.
+	msgs := reqs[1].Messages
+	if len(msgs) != 3 || msgs[1].Role != RoleAssistant || msgs[1].Text() != "Sure! " || msgs[2].Role != RoleUser {
+		t.Fatalf("repair messages: %#v", msgs)
+	}
+	if !strings.Contains(reqs[2].Messages[4].Text(), "schema validation") {
+		t.Fatalf("second repair feedback: %q", reqs[2].Messages[4].Text())
+	}
+}
+
+func TestStreamObject_ExhaustedRepairsReturnNoObjectGeneratedError(t *testing.T) {
+	c := NewClient()
+	c.Register(&scriptedStreamAdapter{
+		name:    "openai",
+		scripts: []func(ctx context.Context, req Request) (Stream, error){scriptedTextStream(`{"name": 1, "age": 2}`)},
+	})
+	prompt := "extract"
+	res, err := StreamObject(context.Background(), StreamObjectOptions{GenerateObjectOptions: GenerateObjectOptions{
+		GenerateOptions: GenerateOptions{Client: c, Model: "m", Prompt: &prompt},
+		Schema:          personSchema(),
+	}})
+	if err != nil {
+		t.Fatalf("StreamObject: %v", err)
+	}
+	_, err = res.Result()
+	var noe *NoObjectGeneratedError
+	if !errors.As(err, &noe) {
+		t.Fatalf("expected NoObjectGeneratedError, got %T (%v)", err, err)
+	}
+}
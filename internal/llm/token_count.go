@@ -0,0 +1,35 @@
+package llm
+
+import "context"
+
+// TokenCounter is an optional ProviderAdapter extension for providers that
+// expose an exact input-token counting endpoint. Callers discover support with
+// a type assertion (or Client.TokenCounter) and fall back to an estimate when it
+// is absent or fails.
+type TokenCounter interface {
+	// CountTokens returns the number of input tokens req would consume.
+	CountTokens(ctx context.Context, req Request) (int, error)
+	// TokenCountSupported reports whether this adapter instance can count
+	// (adapters shared by compatible providers may not).
+	TokenCountSupported() bool
+}
+
+// TokenCounter returns the token-counting adapter registered for provider, if any.
+func (c *Client) TokenCounter(provider string) (TokenCounter, bool) {
+	if c == nil {
+		return nil, false
+	}
+	prov := normalizeProviderName(provider)
+	if prov == "" {
+		prov = c.defaultProvider
+	}
+	adapter, ok := c.providers[prov]
+	if !ok {
+		return nil, false
+	}
+	tc, ok := adapter.(TokenCounter)
+	if !ok || !tc.TokenCountSupported() {
+		return nil, false
+	}
+	return tc, true
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/danshapiro/kilroy/internal/llm"
 	_ "github.com/danshapiro/kilroy/internal/llm/providers/anthropic"
 	_ "github.com/danshapiro/kilroy/internal/llm/providers/google"
+	_ "github.com/danshapiro/kilroy/internal/llm/providers/ollama"
 	_ "github.com/danshapiro/kilroy/internal/llm/providers/openai"
 )
 
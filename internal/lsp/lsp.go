@@ -0,0 +1,608 @@
+// Package lsp is a small Language Server Protocol client for code navigation.
+// It starts a server (gopls, rust-analyzer, pyright...) as a subprocess
+// speaking Content-Length framed JSON-RPC over stdio, keeps the documents it
+// is asked about in sync with the files on disk, and answers definition,
+// references, document symbol and diagnostics queries.
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/danshapiro/kilroy/internal/version"
+)
+
+const (
+	defaultRequestTimeout = 60 * time.Second
+	shutdownTimeout       = 2 * time.Second
+)
+
+// ServerConfig describes one language server started over stdio.
+type ServerConfig struct {
+	Name    string
+	Command string
+	Args    []string
+	Env     map[string]string
+	// RootDir is the workspace root sent to the server and its working
+	// directory.
+	RootDir string
+	// Stderr receives the server's stderr. Nil discards it (the tail is still
+	// included in errors when the server exits).
+	Stderr io.Writer
+	// RequestTimeout bounds each request whose context has no deadline
+	// (default 60s). Servers index the workspace on the first request, so
+	// keep it generous.
+	RequestTimeout time.Duration
+}
+
+// Position is a zero-based line and UTF-16 code unit offset, as on the wire.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Path returns the local file path of the location's URI.
+func (l Location) Path() string { return PathFromURI(l.URI) }
+
+// DiagnosticSeverity is 1 (error) to 4 (hint).
+type DiagnosticSeverity int
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case 1:
+		return "error"
+	case 2:
+		return "warning"
+	case 3:
+		return "info"
+	case 4:
+		return "hint"
+	}
+	return "error"
+}
+
+type Diagnostic struct {
+	Range    Range              `json:"range"`
+	Severity DiagnosticSeverity `json:"severity,omitempty"`
+	Code     json.RawMessage    `json:"code,omitempty"`
+	Source   string             `json:"source,omitempty"`
+	Message  string             `json:"message"`
+}
+
+// SymbolKind is the LSP symbol kind (1 = file ... 26 = type parameter).
+type SymbolKind int
+
+var symbolKindNames = [...]string{
+	"", "file", "module", "namespace", "package", "class", "method", "property",
+	"field", "constructor", "enum", "interface", "function", "variable",
+	"constant", "string", "number", "boolean", "array", "object", "key",
+	"null", "enum member", "struct", "event", "operator", "type parameter",
+}
+
+func (k SymbolKind) String() string {
+	if k > 0 && int(k) < len(symbolKindNames) {
+		return symbolKindNames[k]
+	}
+	return fmt.Sprintf("kind %d", int(k))
+}
+
+// DocumentSymbol is a symbol in a document outline. Servers that answer with
+// flat SymbolInformation get Range and SelectionRange set to the symbol's
+// location and Detail set to its container.
+type DocumentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           SymbolKind       `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+// RPCError is a JSON-RPC error returned by the server.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("lsp error %d: %s", e.Code, e.Message)
+}
+
+// ErrClosed is returned for requests on a closed client or after the server
+// went away.
+var ErrClosed = errors.New("lsp: connection closed")
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+func (m *message) isResponse() bool { return m.Method == "" && len(m.ID) > 0 }
+
+// document is an open text document.
+type document struct {
+	version int
+	text    string
+	// diagnostics from the latest publish; fresh is closed once a publish
+	// arrives after the latest change.
+	diags     []Diagnostic
+	published bool
+	fresh     chan struct{}
+}
+
+// Client is a started, initialized language server. It is safe for
+// concurrent use.
+type Client struct {
+	name    string
+	conn    *conn
+	timeout time.Duration
+	nextID  atomic.Int64
+
+	serverInfo struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	mu   sync.Mutex
+	docs map[string]*document // by URI
+}
+
+// Start launches the server and performs the initialize handshake.
+func Start(ctx context.Context, cfg ServerConfig) (*Client, error) {
+	if strings.TrimSpace(cfg.Command) == "" {
+		return nil, fmt.Errorf("language server %q: command is required", cfg.Name)
+	}
+	root, err := filepath.Abs(cfg.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RootDir = root
+	c := &Client{name: cfg.Name, timeout: cfg.RequestTimeout, docs: map[string]*document{}}
+	if c.timeout <= 0 {
+		c.timeout = defaultRequestTimeout
+	}
+	c.conn = &conn{onNotify: c.handleNotification, onRequest: handleServerRequest}
+	if err := startConn(cfg, c.conn); err != nil {
+		return nil, fmt.Errorf("language server %q: %w", cfg.Name, err)
+	}
+	if err := c.initialize(ctx, root); err != nil {
+		c.conn.close()
+		return nil, fmt.Errorf("language server %q: initialize: %w", cfg.Name, err)
+	}
+	return c, nil
+}
+
+func (c *Client) initialize(ctx context.Context, root string) error {
+	rootURI := URIFromPath(root)
+	var res struct {
+		ServerInfo json.RawMessage `json:"serverInfo"`
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"processId":  os.Getpid(),
+		"clientInfo": map[string]any{"name": "kilroy", "version": version.Version},
+		"rootUri":    rootURI,
+		"rootPath":   root,
+		"workspaceFolders": []map[string]any{
+			{"uri": rootURI, "name": filepath.Base(root)},
+		},
+		"capabilities": map[string]any{
+			"general": map[string]any{"positionEncodings": []string{"utf-16"}},
+			"textDocument": map[string]any{
+				"synchronization":    map[string]any{"didSave": false},
+				"definition":         map[string]any{"linkSupport": true},
+				"references":         map[string]any{},
+				"documentSymbol":     map[string]any{"hierarchicalDocumentSymbolSupport": true},
+				"publishDiagnostics": map[string]any{"versionSupport": true},
+			},
+			"workspace": map[string]any{"workspaceFolders": true, "configuration": true},
+		},
+	}, &res)
+	if err != nil {
+		return err
+	}
+	if len(res.ServerInfo) > 0 {
+		_ = json.Unmarshal(res.ServerInfo, &c.serverInfo)
+	}
+	return c.conn.notify(&message{JSONRPC: "2.0", Method: "initialized", Params: json.RawMessage("{}")})
+}
+
+// Name is the configured server name.
+func (c *Client) Name() string { return c.name }
+
+// ServerName is the name the server reported, if any.
+func (c *Client) ServerName() string { return c.serverInfo.Name }
+
+func (c *Client) call(ctx context.Context, method string, params any, out any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req := &message{JSONRPC: "2.0", ID: json.RawMessage(fmt.Sprint(c.nextID.Add(1))), Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = b
+	}
+	resp, err := c.conn.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return fmt.Errorf("decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+func (c *Client) notify(method string, params any) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.conn.notify(&message{JSONRPC: "2.0", Method: method, Params: b})
+}
+
+// Sync opens path (languageID as in didOpen, e.g. "go") or, when it is
+// already open and changed on disk, sends its new content. It returns the
+// text the server now has.
+func (c *Client) Sync(path, languageID string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	text := string(b)
+	uri := URIFromPath(path)
+	c.mu.Lock()
+	doc := c.docs[uri]
+	switch {
+	case doc == nil:
+		doc = &document{version: 1, text: text, fresh: make(chan struct{})}
+		c.docs[uri] = doc
+		c.mu.Unlock()
+		return text, c.notify("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": languageID, "version": doc.version, "text": text},
+		})
+	case doc.text != text:
+		doc.version++
+		doc.text = text
+		doc.published = false
+		doc.fresh = make(chan struct{})
+		v := doc.version
+		c.mu.Unlock()
+		return text, c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": v},
+			"contentChanges": []map[string]any{{"text": text}},
+		})
+	}
+	c.mu.Unlock()
+	return text, nil
+}
+
+func textDocumentPosition(path string, pos Position) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": URIFromPath(path)},
+		"position":     pos,
+	}
+}
+
+// Definition returns where the symbol at pos in path is defined. The
+// document must have been synced.
+func (c *Client) Definition(ctx context.Context, path string, pos Position) ([]Location, error) {
+	var raw json.RawMessage
+	if err := c.call(ctx, "textDocument/definition", textDocumentPosition(path, pos), &raw); err != nil {
+		return nil, err
+	}
+	return decodeLocations(raw)
+}
+
+// References returns the uses of the symbol at pos in path, including its
+// declaration when includeDeclaration is set.
+func (c *Client) References(ctx context.Context, path string, pos Position, includeDeclaration bool) ([]Location, error) {
+	params := textDocumentPosition(path, pos)
+	params["context"] = map[string]any{"includeDeclaration": includeDeclaration}
+	var raw json.RawMessage
+	if err := c.call(ctx, "textDocument/references", params, &raw); err != nil {
+		return nil, err
+	}
+	return decodeLocations(raw)
+}
+
+// decodeLocations accepts a Location, a Location array or a LocationLink
+// array.
+func decodeLocations(raw json.RawMessage) ([]Location, error) {
+	s := strings.TrimSpace(string(raw))
+	if s == "" || s == "null" {
+		return nil, nil
+	}
+	if strings.HasPrefix(s, "{") {
+		var l Location
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return nil, err
+		}
+		return []Location{l}, nil
+	}
+	var items []struct {
+		Location
+		TargetURI            string `json:"targetUri"`
+		TargetSelectionRange *Range `json:"targetSelectionRange"`
+	}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	out := make([]Location, 0, len(items))
+	for _, it := range items {
+		if it.TargetURI != "" {
+			l := Location{URI: it.TargetURI}
+			if it.TargetSelectionRange != nil {
+				l.Range = *it.TargetSelectionRange
+			}
+			out = append(out, l)
+			continue
+		}
+		out = append(out, it.Location)
+	}
+	return out, nil
+}
+
+// DocumentSymbols returns the outline of path. The document must have been
+// synced.
+func (c *Client) DocumentSymbols(ctx context.Context, path string) ([]DocumentSymbol, error) {
+	var raw []json.RawMessage
+	params := map[string]any{"textDocument": map[string]any{"uri": URIFromPath(path)}}
+	if err := c.call(ctx, "textDocument/documentSymbol", params, &raw); err != nil {
+		return nil, err
+	}
+	out := make([]DocumentSymbol, 0, len(raw))
+	for _, r := range raw {
+		var probe struct {
+			Location      *Location `json:"location"`
+			ContainerName string    `json:"containerName"`
+		}
+		if err := json.Unmarshal(r, &probe); err != nil {
+			return nil, err
+		}
+		var sym DocumentSymbol
+		if err := json.Unmarshal(r, &sym); err != nil {
+			return nil, err
+		}
+		if probe.Location != nil {
+			sym.Range, sym.SelectionRange, sym.Detail = probe.Location.Range, probe.Location.Range, probe.ContainerName
+		}
+		out = append(out, sym)
+	}
+	return out, nil
+}
+
+// Diagnostics returns the diagnostics the server published for path's
+// current content, waiting up to wait for a publish after the latest change.
+// When none arrives in time the last known diagnostics are returned with
+// ok=false.
+func (c *Client) Diagnostics(ctx context.Context, path string, wait time.Duration) (diags []Diagnostic, ok bool, err error) {
+	uri := URIFromPath(path)
+	c.mu.Lock()
+	doc := c.docs[uri]
+	if doc == nil {
+		c.mu.Unlock()
+		return nil, false, fmt.Errorf("%s is not open", path)
+	}
+	fresh := doc.fresh
+	c.mu.Unlock()
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-fresh:
+		ok = true
+	case <-t.C:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	case <-c.conn.done:
+		return nil, false, c.conn.closedErr(ErrClosed)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Diagnostic(nil), doc.diags...), ok, nil
+}
+
+func (c *Client) handleNotification(method string, params json.RawMessage) {
+	if method != "textDocument/publishDiagnostics" {
+		return // progress, logging, showMessage
+	}
+	var p struct {
+		URI         string       `json:"uri"`
+		Version     *int         `json:"version"`
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}
+	if json.Unmarshal(params, &p) != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc := c.docs[p.URI]
+	if doc == nil {
+		return // a file we have not opened
+	}
+	if p.Version != nil && *p.Version != doc.version {
+		return // stale
+	}
+	doc.diags = p.Diagnostics
+	if !doc.published {
+		doc.published = true
+		close(doc.fresh)
+	}
+}
+
+// handleServerRequest answers requests the server sends to the client with
+// neutral defaults: no configuration, and acknowledgements for progress and
+// capability registration.
+func handleServerRequest(method string, params json.RawMessage) (any, *RPCError) {
+	switch method {
+	case "workspace/configuration":
+		var p struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		_ = json.Unmarshal(params, &p)
+		return make([]any, len(p.Items)), nil
+	case "workspace/workspaceFolders":
+		return nil, nil
+	case "window/workDoneProgress/create", "client/registerCapability", "client/unregisterCapability", "window/showMessageRequest":
+		return nil, nil
+	}
+	return nil, &RPCError{Code: -32601, Message: "method not found: " + method}
+}
+
+// Close asks the server to shut down and exit, killing it if it does not.
+func (c *Client) Close() error {
+	if c == nil || c.conn == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if c.call(ctx, "shutdown", nil, nil) == nil {
+		_ = c.conn.notify(&message{JSONRPC: "2.0", Method: "exit"})
+	}
+	c.conn.close()
+	return nil
+}
+
+// URIFromPath returns the file:// URI of path (made absolute).
+func URIFromPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	p := filepath.ToSlash(path)
+	if runtime.GOOS == "windows" {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// PathFromURI returns the local path of a file:// URI, or the URI itself
+// for other schemes.
+func PathFromURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	p := u.Path
+	if runtime.GOOS == "windows" {
+		p = strings.TrimPrefix(p, "/")
+	}
+	return filepath.FromSlash(p)
+}
+
+// PositionFor converts a 1-based line and 1-based column counted in
+// characters (runes) of text to a wire position. Columns past the end of the
+// line are clamped.
+func PositionFor(text string, line, col int) Position {
+	pos := Position{Line: line - 1}
+	l := lineText(text, line-1)
+	n := 0
+	for i := 1; i < col && l != ""; i++ {
+		r, size := utf8.DecodeRuneInString(l)
+		l = l[size:]
+		n += len(utf16.Encode([]rune{r}))
+	}
+	pos.Character = n
+	return pos
+}
+
+// LineCol converts a wire position to a 1-based line and 1-based character
+// column of text.
+func LineCol(text string, pos Position) (line, col int) {
+	l := lineText(text, pos.Line)
+	units := 0
+	col = 1
+	for _, r := range l {
+		if units >= pos.Character {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+		col++
+	}
+	return pos.Line + 1, col
+}
+
+// LineText returns the zero-based line of text without its line ending.
+func LineText(text string, line int) string { return lineText(text, line) }
+
+func lineText(text string, line int) string {
+	for i := 0; i < line; i++ {
+		nl := strings.IndexByte(text, '\n')
+		if nl < 0 {
+			return ""
+		}
+		text = text[nl+1:]
+	}
+	if nl := strings.IndexByte(text, '\n'); nl >= 0 {
+		text = text[:nl]
+	}
+	return strings.TrimSuffix(text, "\r")
+}
+
+// LanguageID guesses the didOpen language identifier from path's extension.
+func LanguageID(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".go":
+		return "go"
+	case ".rs":
+		return "rust"
+	case ".py", ".pyi":
+		return "python"
+	case ".ts":
+		return "typescript"
+	case ".tsx":
+		return "typescriptreact"
+	case ".js", ".mjs", ".cjs":
+		return "javascript"
+	case ".jsx":
+		return "javascriptreact"
+	case ".c", ".h":
+		return "c"
+	case ".cc", ".cpp", ".cxx", ".hpp", ".hh":
+		return "cpp"
+	case ".java":
+		return "java"
+	case ".rb":
+		return "ruby"
+	case ".sh", ".bash":
+		return "shellscript"
+	}
+	return strings.TrimPrefix(ext, ".")
+}
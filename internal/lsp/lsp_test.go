@@ -0,0 +1,106 @@
+package lsp_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/lsp"
+	"github.com/danshapiro/kilroy/internal/lsp/lsptest"
+)
+
+func TestLSPFakeServerHelper(t *testing.T) {
+	lsptest.ServeIfHelper()
+	t.Skip("helper process for LSP tests")
+}
+
+func TestClient_NavigatesAndTracksDiagnostics(t *testing.T) {
+	root := t.TempDir()
+	mainPath := filepath.Join(root, "main.toy")
+	libPath := filepath.Join(root, "lib.toy")
+	if err := os.WriteFile(mainPath, []byte("func main\n  helper()\n  helper()\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(libPath, []byte("type Thing\nfunc helper\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c, err := lsp.Start(ctx, lsptest.HelperConfig("toy", "TestLSPFakeServerHelper", root))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+	if c.ServerName() != "lsptest" {
+		t.Fatalf("server name %q", c.ServerName())
+	}
+
+	text, err := c.Sync(mainPath, "toy")
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	pos := lsp.PositionFor(text, 2, 4) // "helper" on line 2
+	defs, err := c.Definition(ctx, mainPath, pos)
+	if err != nil {
+		t.Fatalf("Definition: %v", err)
+	}
+	if len(defs) != 1 || defs[0].Path() != libPath || defs[0].Range.Start != (lsp.Position{Line: 1, Character: 5}) {
+		t.Fatalf("definition: %+v", defs)
+	}
+	refs, err := c.References(ctx, mainPath, pos, false)
+	if err != nil || len(refs) != 2 {
+		t.Fatalf("references without declaration: %+v err=%v", refs, err)
+	}
+	if refs, _ = c.References(ctx, mainPath, pos, true); len(refs) != 3 {
+		t.Fatalf("references with declaration: %+v", refs)
+	}
+
+	if _, err := c.Sync(libPath, "toy"); err != nil {
+		t.Fatal(err)
+	}
+	syms, err := c.DocumentSymbols(ctx, libPath)
+	if err != nil || len(syms) != 2 || syms[0].Name != "Thing" || syms[0].Kind.String() != "struct" || syms[1].Kind.String() != "function" {
+		t.Fatalf("symbols: %+v err=%v", syms, err)
+	}
+
+	diags, ok, err := c.Diagnostics(ctx, mainPath, 5*time.Second)
+	if err != nil || !ok || len(diags) != 0 {
+		t.Fatalf("clean diagnostics: %+v ok=%v err=%v", diags, ok, err)
+	}
+	if err := os.WriteFile(mainPath, []byte("func main\n  BUG\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Sync(mainPath, "toy"); err != nil {
+		t.Fatal(err)
+	}
+	diags, ok, err = c.Diagnostics(ctx, mainPath, 5*time.Second)
+	if err != nil || !ok || len(diags) != 1 || diags[0].Range.Start.Line != 1 || diags[0].Severity.String() != "error" {
+		t.Fatalf("diagnostics after change: %+v ok=%v err=%v", diags, ok, err)
+	}
+}
+
+func TestClient_StartFailsWhenServerExits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := lsp.Start(ctx, lsp.ServerConfig{Name: "bad", Command: "sh", Args: []string{"-c", "echo boom >&2; exit 3"}, RootDir: t.TempDir()})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if got := err.Error(); !strings.Contains(got, "boom") {
+		t.Fatalf("error lacks stderr tail: %v", err)
+	}
+}
+
+func TestPositionFor_CountsUTF16Units(t *testing.T) {
+	text := "a\nx😀yz\n"
+	pos := lsp.PositionFor(text, 2, 3) // 'y'
+	if pos != (lsp.Position{Line: 1, Character: 3}) {
+		t.Fatalf("PositionFor = %+v", pos)
+	}
+	if line, col := lsp.LineCol(text, pos); line != 2 || col != 3 {
+		t.Fatalf("LineCol = %d:%d", line, col)
+	}
+}
@@ -0,0 +1,344 @@
+// Package lsptest provides a fake language server for tests. It serves over
+// stdio by re-executing the test binary and understands a toy language:
+//
+//   - "func <name>" and "type <name>" lines declare symbols (function and
+//     struct), listed by textDocument/documentSymbol;
+//   - textDocument/definition finds the declaration of the word under the
+//     cursor in any file under the workspace root (open documents win over
+//     disk);
+//   - textDocument/references lists whole-word uses across those files;
+//   - each line containing "BUG" gets an error diagnostic, published on
+//     didOpen/didChange with the document version.
+//
+// Positions are byte offsets, so tests should stick to ASCII.
+package lsptest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danshapiro/kilroy/internal/lsp"
+)
+
+// HelperEnv marks a re-executed test binary as the fake server.
+const HelperEnv = "KILROY_LSP_FAKE_SERVER"
+
+// HelperConfig returns a server config that re-executes the running test
+// binary, running only testName. That test must call ServeIfHelper first.
+func HelperConfig(name, testName, rootDir string) lsp.ServerConfig {
+	return lsp.ServerConfig{
+		Name:    name,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^" + testName + "$"},
+		Env:     map[string]string{HelperEnv: "1"},
+		RootDir: rootDir,
+	}
+}
+
+// HelperCommand returns the command line HelperConfig uses.
+func HelperCommand(testName string) (string, []string, map[string]string) {
+	cfg := HelperConfig("", testName, "")
+	return cfg.Command, cfg.Args, cfg.Env
+}
+
+// ServeIfHelper serves the fake over stdin/stdout and exits the process when
+// started via HelperConfig; otherwise it returns immediately.
+func ServeIfHelper() {
+	if os.Getenv(HelperEnv) != "1" {
+		return
+	}
+	_ = Serve(os.Stdin, os.Stdout)
+	os.Exit(0)
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *lsp.RPCError   `json:"error,omitempty"`
+}
+
+type server struct {
+	mu   sync.Mutex
+	w    io.Writer
+	root string
+	docs map[string]string // uri -> text
+	next int
+}
+
+// Serve answers framed JSON-RPC on r/w until r is closed or "exit" arrives.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{w: w, docs: map[string]string{}}
+	br := bufio.NewReader(r)
+	for {
+		hdr, err := textproto.NewReader(br).ReadMIMEHeader()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n, err := strconv.Atoi(hdr.Get("Content-Length"))
+		if err != nil {
+			return err
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return err
+		}
+		var msg rpcMessage
+		if json.Unmarshal(body, &msg) != nil || msg.Method == "" {
+			continue // replies to our requests
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, rpcErr := s.handle(msg.Method, msg.Params)
+		if len(msg.ID) == 0 {
+			continue
+		}
+		resp := &rpcMessage{JSONRPC: "2.0", ID: msg.ID, Error: rpcErr}
+		if rpcErr == nil {
+			if result == nil {
+				result = json.RawMessage("null")
+			}
+			resp.Result = result
+		}
+		s.send(resp)
+	}
+}
+
+func (s *server) send(msg *rpcMessage) {
+	b, _ := json.Marshal(msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+type docPos struct {
+	TextDocument struct {
+		URI     string `json:"uri"`
+		Text    string `json:"text"`
+		Version int    `json:"version"`
+	} `json:"textDocument"`
+	Position       lsp.Position `json:"position"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Context struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+	RootURI string `json:"rootUri"`
+}
+
+func (s *server) handle(method string, raw json.RawMessage) (any, *lsp.RPCError) {
+	var p docPos
+	_ = json.Unmarshal(raw, &p)
+	switch method {
+	case "initialize":
+		s.root = lsp.PathFromURI(p.RootURI)
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "lsptest"},
+		}, nil
+	case "initialized":
+		// Exercise the client's handling of server requests.
+		s.next++
+		s.send(&rpcMessage{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(s.next)), Method: "workspace/configuration",
+			Params: json.RawMessage(`{"items":[{"section":"fake"}]}`)})
+		return nil, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		s.setDoc(p.TextDocument.URI, p.TextDocument.Text, p.TextDocument.Version)
+		return nil, nil
+	case "textDocument/didChange":
+		if len(p.ContentChanges) > 0 {
+			s.setDoc(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text, p.TextDocument.Version)
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		return symbols(s.text(p.TextDocument.URI)), nil
+	case "textDocument/definition":
+		word := wordAt(s.text(p.TextDocument.URI), p.Position)
+		if word == "" {
+			return nil, nil
+		}
+		var out []lsp.Location
+		for _, f := range s.files() {
+			for i, l := range strings.Split(f.text, "\n") {
+				for _, kw := range []string{"func ", "type "} {
+					if strings.HasPrefix(l, kw+word) && !isWordByte(byteAt(l, len(kw)+len(word))) {
+						out = append(out, lsp.Location{URI: f.uri, Range: wordRange(i, len(kw), word)})
+					}
+				}
+			}
+		}
+		return out, nil
+	case "textDocument/references":
+		word := wordAt(s.text(p.TextDocument.URI), p.Position)
+		var out []lsp.Location
+		for _, f := range s.files() {
+			for i, l := range strings.Split(f.text, "\n") {
+				for col := 0; ; {
+					j := strings.Index(l[col:], word)
+					if j < 0 || word == "" {
+						break
+					}
+					at := col + j
+					col = at + len(word)
+					if isWordByte(byteAt(l, at-1)) || isWordByte(byteAt(l, col)) {
+						continue
+					}
+					decl := strings.HasPrefix(l, "func "+word) || strings.HasPrefix(l, "type "+word)
+					if decl && at == 5 && !p.Context.IncludeDeclaration {
+						continue
+					}
+					out = append(out, lsp.Location{URI: f.uri, Range: wordRange(i, at, word)})
+				}
+			}
+		}
+		return out, nil
+	}
+	if strings.HasPrefix(method, "$/") || strings.HasPrefix(method, "textDocument/did") {
+		return nil, nil
+	}
+	return nil, &lsp.RPCError{Code: -32601, Message: "method not found: " + method}
+}
+
+func (s *server) setDoc(uri, text string, version int) {
+	s.mu.Lock()
+	s.docs[uri] = text
+	s.mu.Unlock()
+	diags := []map[string]any{}
+	for i, l := range strings.Split(text, "\n") {
+		if at := strings.Index(l, "BUG"); at >= 0 {
+			diags = append(diags, map[string]any{
+				"range":    wordRange(i, at, "BUG"),
+				"severity": 1,
+				"source":   "lsptest",
+				"code":     "bug",
+				"message":  "found a bug",
+			})
+		}
+	}
+	params, _ := json.Marshal(map[string]any{"uri": uri, "version": version, "diagnostics": diags})
+	s.send(&rpcMessage{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+func (s *server) text(uri string) string {
+	s.mu.Lock()
+	t, ok := s.docs[uri]
+	s.mu.Unlock()
+	if ok {
+		return t
+	}
+	b, _ := os.ReadFile(lsp.PathFromURI(uri))
+	return string(b)
+}
+
+type file struct{ uri, text string }
+
+// files lists the workspace's regular files with open documents overlaid.
+func (s *server) files() []file {
+	seen := map[string]bool{}
+	var out []file
+	if s.root != "" {
+		_ = filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				if d != nil && d.IsDir() && strings.HasPrefix(d.Name(), ".") && p != s.root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			uri := lsp.URIFromPath(p)
+			seen[uri] = true
+			out = append(out, file{uri: uri, text: s.text(uri)})
+			return nil
+		})
+	}
+	s.mu.Lock()
+	for uri, t := range s.docs {
+		if !seen[uri] {
+			out = append(out, file{uri: uri, text: t})
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].uri < out[j].uri })
+	return out
+}
+
+func symbols(text string) []map[string]any {
+	out := []map[string]any{}
+	for i, l := range strings.Split(text, "\n") {
+		for kw, kind := range map[string]int{"func ": 12, "type ": 23} {
+			if !strings.HasPrefix(l, kw) {
+				continue
+			}
+			name := l[len(kw):]
+			end := 0
+			for end < len(name) && isWordByte(name[end]) {
+				end++
+			}
+			name = name[:end]
+			sym := map[string]any{
+				"name":           name,
+				"kind":           kind,
+				"range":          lsp.Range{Start: lsp.Position{Line: i}, End: lsp.Position{Line: i, Character: len(l)}},
+				"selectionRange": wordRange(i, len(kw), name),
+			}
+			if kind == 23 {
+				sym["children"] = []map[string]any{}
+			}
+			out = append(out, sym)
+		}
+	}
+	return out
+}
+
+func wordAt(text string, pos lsp.Position) string {
+	l := lsp.LineText(text, pos.Line)
+	if pos.Character > len(l) {
+		return ""
+	}
+	start, end := pos.Character, pos.Character
+	for start > 0 && isWordByte(l[start-1]) {
+		start--
+	}
+	for end < len(l) && isWordByte(l[end]) {
+		end++
+	}
+	return l[start:end]
+}
+
+func wordRange(line, col int, word string) lsp.Range {
+	return lsp.Range{Start: lsp.Position{Line: line, Character: col}, End: lsp.Position{Line: line, Character: col + len(word)}}
+}
+
+func byteAt(s string, i int) byte {
+	if i < 0 || i >= len(s) {
+		return 0
+	}
+	return s[i]
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
+}
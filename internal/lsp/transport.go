@@ -0,0 +1,252 @@
+package lsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const stderrTailBytes = 2048
+
+// conn speaks Content-Length framed JSON-RPC over a subprocess's
+// stdin/stdout.
+type conn struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *message
+	err     error // set once the read loop stops
+	done    chan struct{}
+
+	stderr *tailBuffer
+
+	// onNotify receives server notifications; onRequest answers server
+	// requests. Both run on the read loop.
+	onNotify  func(method string, params json.RawMessage)
+	onRequest func(method string, params json.RawMessage) (any, *RPCError)
+}
+
+func startConn(cfg ServerConfig, c *conn) error {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = cfg.RootDir
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	c.cmd = cmd
+	c.pending = map[string]chan *message{}
+	c.done = make(chan struct{})
+	c.stderr = &tailBuffer{max: stderrTailBytes}
+	if cfg.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cfg.Stderr, c.stderr)
+	} else {
+		cmd.Stderr = c.stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.stdin = stdin
+	go c.readLoop(stdout)
+	return nil
+}
+
+// readMessage reads one framed message: headers, a blank line, then
+// Content-Length bytes of JSON.
+func readMessage(br *bufio.Reader) ([]byte, error) {
+	hdr, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(hdr.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", hdr.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func writeMessage(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+func (c *conn) readLoop(r io.Reader) {
+	br := bufio.NewReader(r)
+	var readErr error
+	for {
+		body, err := readMessage(br)
+		if err != nil {
+			readErr = err
+			break
+		}
+		var msg message
+		if json.Unmarshal(body, &msg) == nil {
+			c.dispatch(&msg)
+		}
+	}
+	// Reap the process so the exit status and stderr tail are complete.
+	_ = c.cmd.Wait()
+	c.mu.Lock()
+	c.err = fmt.Errorf("%w: server exited (%v)%s", ErrClosed, readErr, c.stderr.suffix())
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func (c *conn) dispatch(msg *message) {
+	switch {
+	case msg.isResponse():
+		c.mu.Lock()
+		ch, ok := c.pending[string(msg.ID)]
+		delete(c.pending, string(msg.ID))
+		c.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	case msg.Method != "" && len(msg.ID) > 0:
+		resp := &message{JSONRPC: "2.0", ID: msg.ID}
+		result, rpcErr := c.onRequest(msg.Method, msg.Params)
+		if rpcErr != nil {
+			resp.Error = rpcErr
+		} else {
+			b, _ := json.Marshal(result)
+			resp.Result = b
+		}
+		go func() { _ = c.write(resp) }()
+	case msg.Method != "":
+		c.onNotify(msg.Method, msg.Params)
+	}
+}
+
+func (c *conn) write(msg *message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeMessage(c.stdin, msg)
+}
+
+func (c *conn) roundTrip(ctx context.Context, req *message) (*message, error) {
+	ch := make(chan *message, 1)
+	key := string(req.ID)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.pending[key] = ch
+	c.mu.Unlock()
+
+	if err := c.write(req); err != nil {
+		c.forget(key)
+		return nil, c.closedErr(err)
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, c.closedErr(ErrClosed)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		c.forget(key)
+		// Tell the server to stop working on it.
+		_ = c.notify(&message{JSONRPC: "2.0", Method: "$/cancelRequest", Params: json.RawMessage(`{"id":` + key + `}`)})
+		return nil, ctx.Err()
+	}
+}
+
+func (c *conn) notify(msg *message) error {
+	if err := c.write(msg); err != nil {
+		return c.closedErr(err)
+	}
+	return nil
+}
+
+func (c *conn) forget(key string) {
+	c.mu.Lock()
+	delete(c.pending, key)
+	c.mu.Unlock()
+}
+
+// closedErr prefers the read loop's exit error, which carries the stderr tail.
+func (c *conn) closedErr(fallback error) error {
+	select {
+	case <-c.done:
+	case <-time.After(100 * time.Millisecond):
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return fallback
+}
+
+// close closes stdin and kills the server if it does not exit promptly.
+func (c *conn) close() {
+	_ = c.stdin.Close()
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+		if c.cmd.Process != nil {
+			_ = c.cmd.Process.Kill()
+		}
+		<-c.done
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) suffix() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := strings.TrimSpace(string(b.buf))
+	if s == "" {
+		return ""
+	}
+	return "; stderr: " + s
+}
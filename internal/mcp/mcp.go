@@ -0,0 +1,379 @@
+// Package mcp is a Model Context Protocol client. It connects to tool servers
+// over stdio (a spawned subprocess speaking newline-delimited JSON-RPC) or the
+// streamable HTTP transport, and exposes their tools and resources.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/version"
+)
+
+// ProtocolVersion is the MCP revision requested during initialization.
+const ProtocolVersion = "2025-06-18"
+
+const defaultRequestTimeout = 2 * time.Minute
+
+// ServerConfig describes one MCP server. Exactly one of Command (stdio) or URL
+// (streamable HTTP) must be set.
+type ServerConfig struct {
+	Name string
+
+	// stdio
+	Command string
+	Args    []string
+	Env     map[string]string
+	Dir     string
+	// Stderr receives the server's stderr. Nil discards it (the tail is still
+	// included in errors when the server exits).
+	Stderr io.Writer
+
+	// streamable HTTP
+	URL     string
+	Headers map[string]string
+
+	// RequestTimeout bounds each request whose context has no deadline
+	// (default 2m).
+	RequestTimeout time.Duration
+}
+
+func (c ServerConfig) validate() error {
+	hasCmd := strings.TrimSpace(c.Command) != ""
+	hasURL := strings.TrimSpace(c.URL) != ""
+	switch {
+	case hasCmd && hasURL:
+		return fmt.Errorf("mcp server %q: command and url are mutually exclusive", c.Name)
+	case !hasCmd && !hasURL:
+		return fmt.Errorf("mcp server %q: command or url is required", c.Name)
+	}
+	return nil
+}
+
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type ServerCapabilities struct {
+	Tools     *struct{} `json:"tools,omitempty"`
+	Resources *struct{} `json:"resources,omitempty"`
+	Prompts   *struct{} `json:"prompts,omitempty"`
+}
+
+type Tool struct {
+	Name        string         `json:"name"`
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema,omitempty"`
+}
+
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"` // base64
+}
+
+// Content is one block of a tool result.
+type Content struct {
+	Type     string            `json:"type"` // text | image | audio | resource | resource_link
+	Text     string            `json:"text,omitempty"`
+	Data     string            `json:"data,omitempty"` // base64 (image, audio)
+	MimeType string            `json:"mimeType,omitempty"`
+	URI      string            `json:"uri,omitempty"` // resource_link
+	Resource *ResourceContents `json:"resource,omitempty"`
+}
+
+type CallToolResult struct {
+	Content           []Content `json:"content"`
+	StructuredContent any       `json:"structuredContent,omitempty"`
+	IsError           bool      `json:"isError,omitempty"`
+}
+
+// Text renders the result for a text-only consumer: text blocks verbatim,
+// embedded resources by their text, and binary blocks as placeholders.
+// Structured content is used when there are no content blocks.
+func (r *CallToolResult) Text() string {
+	if r == nil {
+		return ""
+	}
+	var parts []string
+	for _, c := range r.Content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "resource":
+			if c.Resource == nil {
+				continue
+			}
+			if c.Resource.Text != "" {
+				parts = append(parts, c.Resource.Text)
+			} else {
+				parts = append(parts, fmt.Sprintf("[resource %s (%s), %d base64 bytes]", c.Resource.URI, c.Resource.MimeType, len(c.Resource.Blob)))
+			}
+		case "resource_link":
+			parts = append(parts, fmt.Sprintf("[resource link %s]", c.URI))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s %s, %d base64 bytes]", c.Type, c.MimeType, len(c.Data)))
+		}
+	}
+	if len(parts) == 0 && r.StructuredContent != nil {
+		b, err := json.MarshalIndent(r.StructuredContent, "", "  ")
+		if err == nil {
+			return string(b)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// RPCError is a JSON-RPC error returned by the server.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("mcp error %d: %s", e.Code, e.Message)
+}
+
+// ErrClosed is returned for requests on a closed client or after the server
+// went away.
+var ErrClosed = errors.New("mcp: connection closed")
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+func (m *message) isResponse() bool { return m.Method == "" && len(m.ID) > 0 }
+
+type transport interface {
+	// roundTrip sends a request and waits for the response with the same id.
+	roundTrip(ctx context.Context, req *message) (*message, error)
+	notify(ctx context.Context, msg *message) error
+	close() error
+}
+
+// Client is a connected, initialized MCP session. It is safe for concurrent
+// use.
+type Client struct {
+	name    string
+	t       transport
+	timeout time.Duration
+	nextID  atomic.Int64
+
+	protocolVersion string
+	serverInfo      Implementation
+	caps            ServerCapabilities
+	instructions    string
+}
+
+// Connect starts (stdio) or contacts (HTTP) the server and performs the
+// initialize handshake.
+func Connect(ctx context.Context, cfg ServerConfig) (*Client, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	var (
+		t   transport
+		err error
+	)
+	if strings.TrimSpace(cfg.Command) != "" {
+		t, err = newStdioTransport(cfg)
+	} else {
+		t, err = newHTTPTransport(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mcp server %q: %w", cfg.Name, err)
+	}
+	c := &Client{name: cfg.Name, t: t, timeout: cfg.RequestTimeout}
+	if c.timeout <= 0 {
+		c.timeout = defaultRequestTimeout
+	}
+	if err := c.initialize(ctx); err != nil {
+		_ = t.close()
+		return nil, fmt.Errorf("mcp server %q: initialize: %w", cfg.Name, err)
+	}
+	return c, nil
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	var res struct {
+		ProtocolVersion string             `json:"protocolVersion"`
+		Capabilities    ServerCapabilities `json:"capabilities"`
+		ServerInfo      Implementation     `json:"serverInfo"`
+		Instructions    string             `json:"instructions,omitempty"`
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      Implementation{Name: "kilroy", Version: version.Version},
+	}, &res)
+	if err != nil {
+		return err
+	}
+	c.protocolVersion = res.ProtocolVersion
+	c.caps = res.Capabilities
+	c.serverInfo = res.ServerInfo
+	c.instructions = res.Instructions
+	if ht, ok := c.t.(*httpTransport); ok {
+		ht.setProtocolVersion(res.ProtocolVersion)
+	}
+	return c.t.notify(ctx, &message{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// Name is the configured server name.
+func (c *Client) Name() string { return c.name }
+
+func (c *Client) ServerInfo() Implementation { return c.serverInfo }
+
+func (c *Client) Capabilities() ServerCapabilities { return c.caps }
+
+// Instructions is the server's optional usage hint for the model.
+func (c *Client) Instructions() string { return c.instructions }
+
+func (c *Client) call(ctx context.Context, method string, params any, out any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	req := &message{JSONRPC: "2.0", ID: json.RawMessage(fmt.Sprint(c.nextID.Add(1))), Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = b
+	}
+	resp, err := c.t.roundTrip(ctx, req)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, out); err != nil {
+		return fmt.Errorf("decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// maxListPages bounds cursor pagination against servers that never stop.
+const maxListPages = 100
+
+// ListTools returns every tool the server offers, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var out []Tool
+	cursor := ""
+	for i := 0; i < maxListPages; i++ {
+		var page struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "tools/list", cursorParams(cursor), &page); err != nil {
+			return nil, err
+		}
+		out = append(out, page.Tools...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	return out, nil
+}
+
+// CallTool invokes a tool. A tool-level failure is reported in the result's
+// IsError, not as an error.
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]any) (*CallToolResult, error) {
+	if args == nil {
+		args = map[string]any{}
+	}
+	var res CallToolResult
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// ListResources returns every resource the server offers, following
+// pagination.
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	var out []Resource
+	cursor := ""
+	for i := 0; i < maxListPages; i++ {
+		var page struct {
+			Resources  []Resource `json:"resources"`
+			NextCursor string     `json:"nextCursor,omitempty"`
+		}
+		if err := c.call(ctx, "resources/list", cursorParams(cursor), &page); err != nil {
+			return nil, err
+		}
+		out = append(out, page.Resources...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	return out, nil
+}
+
+func (c *Client) ReadResource(ctx context.Context, uri string) ([]ResourceContents, error) {
+	var res struct {
+		Contents []ResourceContents `json:"contents"`
+	}
+	if err := c.call(ctx, "resources/read", map[string]any{"uri": uri}, &res); err != nil {
+		return nil, err
+	}
+	return res.Contents, nil
+}
+
+// Close ends the session: stdio servers get stdin closed and are killed if
+// they do not exit promptly; HTTP sessions are deleted best-effort.
+func (c *Client) Close() error {
+	if c == nil || c.t == nil {
+		return nil
+	}
+	return c.t.close()
+}
+
+func cursorParams(cursor string) any {
+	if cursor == "" {
+		return map[string]any{}
+	}
+	return map[string]any{"cursor": cursor}
+}
+
+// replyToServerRequest answers requests the server sends to the client. Only
+// ping is supported; everything else gets method-not-found.
+func replyToServerRequest(req *message) *message {
+	resp := &message{JSONRPC: "2.0", ID: req.ID}
+	if req.Method == "ping" {
+		resp.Result = json.RawMessage("{}")
+	} else {
+		resp.Error = &RPCError{Code: -32601, Message: "method not found: " + req.Method}
+	}
+	return resp
+}
@@ -0,0 +1,116 @@
+package mcp_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/mcp"
+	"github.com/danshapiro/kilroy/internal/mcp/mcptest"
+)
+
+func TestMCPStubServerHelper(t *testing.T) {
+	mcptest.ServeIfHelper()
+	t.Skip("helper process for stdio tests")
+}
+
+func exerciseClient(t *testing.T, c *mcp.Client) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if c.ServerInfo().Name != "mcptest" || c.Capabilities().Tools == nil || c.Capabilities().Resources == nil {
+		t.Fatalf("handshake: info=%+v caps=%+v", c.ServerInfo(), c.Capabilities())
+	}
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools: %v", err)
+	}
+	if len(tools) != 3 || tools[0].Name != "echo" || tools[0].InputSchema["type"] != "object" {
+		t.Fatalf("tools: %+v", tools)
+	}
+	res, err := c.CallTool(ctx, "echo", map[string]any{"text": "hi"})
+	if err != nil {
+		t.Fatalf("CallTool: %v", err)
+	}
+	if res.IsError || res.Text() != "echo: hi" {
+		t.Fatalf("echo result: %+v", res)
+	}
+	res, err = c.CallTool(ctx, "fail", nil)
+	if err != nil || !res.IsError {
+		t.Fatalf("fail result: %+v err=%v", res, err)
+	}
+	var rpcErr *mcp.RPCError
+	if _, err := c.CallTool(ctx, "nope", nil); !errors.As(err, &rpcErr) || rpcErr.Code != -32602 {
+		t.Fatalf("unknown tool: %v", err)
+	}
+	resources, err := c.ListResources(ctx)
+	if err != nil || len(resources) != 1 || resources[0].URI != "stub://readme" {
+		t.Fatalf("ListResources: %+v err=%v", resources, err)
+	}
+	contents, err := c.ReadResource(ctx, "stub://readme")
+	if err != nil || len(contents) != 1 || contents[0].Text != mcptest.ReadmeText {
+		t.Fatalf("ReadResource: %+v err=%v", contents, err)
+	}
+}
+
+func TestClient_Stdio(t *testing.T) {
+	cfg := mcptest.HelperConfig("stub", "TestMCPStubServerHelper")
+	cfg.Dir = t.TempDir()
+	c, err := mcp.Connect(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	exerciseClient(t, c)
+
+	res, err := c.CallTool(context.Background(), "cwd", nil)
+	if err != nil {
+		t.Fatalf("cwd: %v", err)
+	}
+	got, _ := filepath.EvalSymlinks(res.Text())
+	want, _ := filepath.EvalSymlinks(cfg.Dir)
+	if got != want {
+		t.Fatalf("server cwd: got %q want %q", got, want)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := c.ListTools(context.Background()); !errors.Is(err, mcp.ErrClosed) {
+		t.Fatalf("after close: %v", err)
+	}
+}
+
+func TestClient_Stdio_ServerExitReportsStderr(t *testing.T) {
+	_, err := mcp.Connect(context.Background(), mcp.ServerConfig{
+		Name:    "broken",
+		Command: "sh",
+		Args:    []string{"-c", "echo 'missing token' >&2; exit 3"},
+	})
+	if err == nil || !errors.Is(err, mcp.ErrClosed) || !strings.Contains(err.Error(), "missing token") {
+		t.Fatalf("expected closed error with stderr tail, got %v", err)
+	}
+}
+
+func TestClient_StreamableHTTP(t *testing.T) {
+	srv := httptest.NewServer(mcptest.NewHTTPHandler())
+	t.Cleanup(srv.Close)
+	c, err := mcp.Connect(context.Background(), mcp.ServerConfig{Name: "stub", URL: srv.URL})
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+	exerciseClient(t, c)
+}
+
+func TestServerConfig_RequiresExactlyOneTransport(t *testing.T) {
+	if _, err := mcp.Connect(context.Background(), mcp.ServerConfig{Name: "x"}); err == nil {
+		t.Fatalf("expected error without command or url")
+	}
+	if _, err := mcp.Connect(context.Background(), mcp.ServerConfig{Name: "x", Command: "a", URL: "http://b"}); err == nil {
+		t.Fatalf("expected error with both command and url")
+	}
+}
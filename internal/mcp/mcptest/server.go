@@ -0,0 +1,194 @@
+// Package mcptest provides a stub MCP server for tests. It serves over stdio
+// (by re-executing the test binary) or as an http.Handler, and offers:
+//
+//   - tool "echo" {text}: returns "echo: <text>"
+//   - tool "fail": returns a tool-level error
+//   - tool "cwd": returns the server's working directory
+//   - resource "stub://readme" (text/plain)
+package mcptest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/danshapiro/kilroy/internal/mcp"
+)
+
+// HelperEnv marks a re-executed test binary as the stub server.
+const HelperEnv = "KILROY_MCP_STUB_SERVER"
+
+// ReadmeText is the content of the stub://readme resource.
+const ReadmeText = "stub readme"
+
+// HelperConfig returns a stdio server config that re-executes the running
+// test binary, running only testName. That test must call ServeIfHelper
+// first.
+func HelperConfig(name, testName string) mcp.ServerConfig {
+	return mcp.ServerConfig{
+		Name:    name,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^" + testName + "$"},
+		Env:     map[string]string{HelperEnv: "1"},
+	}
+}
+
+// ServeIfHelper serves the stub over stdin/stdout and exits the process when
+// started via HelperConfig; otherwise it returns immediately.
+func ServeIfHelper() {
+	if os.Getenv(HelperEnv) != "1" {
+		return
+	}
+	_ = ServeStdio(os.Stdin, os.Stdout)
+	os.Exit(0)
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *mcp.RPCError   `json:"error,omitempty"`
+}
+
+// ServeStdio answers newline-delimited JSON-RPC on r/w until r is closed.
+func ServeStdio(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var req rpcMessage
+			if jerr := json.Unmarshal(line, &req); jerr == nil && req.Method != "" && len(req.ID) > 0 {
+				resp := handle(&req)
+				mu.Lock()
+				_ = enc.Encode(resp)
+				mu.Unlock()
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// NewHTTPHandler returns a streamable-HTTP endpoint. tools/call responses are
+// sent as an SSE stream preceded by a server ping; everything else is JSON.
+func NewHTTPHandler() http.Handler {
+	const sessionID = "stub-session"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req rpcMessage
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Method != "initialize" && r.Header.Get("Mcp-Session-Id") != sessionID {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+		if req.Method == "" || len(req.ID) == 0 {
+			// Notification or a reply to our ping.
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		resp := handle(&req)
+		if req.Method == "initialize" {
+			w.Header().Set("Mcp-Session-Id", sessionID)
+		}
+		if req.Method != "tools/call" {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		ping, _ := json.Marshal(rpcMessage{JSONRPC: "2.0", ID: json.RawMessage(`"srv-1"`), Method: "ping"})
+		body, _ := json.Marshal(resp)
+		_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", ping)
+		_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", body)
+	})
+}
+
+func handle(req *rpcMessage) *rpcMessage {
+	resp := &rpcMessage{JSONRPC: "2.0", ID: req.ID}
+	var params map[string]any
+	_ = json.Unmarshal(req.Params, &params)
+	switch req.Method {
+	case "initialize":
+		resp.Result = map[string]any{
+			"protocolVersion": mcp.ProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}, "resources": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "mcptest", "version": "0.0.1"},
+		}
+	case "ping":
+		resp.Result = map[string]any{}
+	case "tools/list":
+		resp.Result = map[string]any{"tools": []map[string]any{
+			{
+				"name":        "echo",
+				"description": "Echo the input text.",
+				"inputSchema": map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"text": map[string]any{"type": "string"}},
+					"required":   []string{"text"},
+				},
+			},
+			{"name": "fail", "description": "Always fails.", "inputSchema": map[string]any{"type": "object"}},
+			{"name": "cwd", "description": "Report the working directory.", "inputSchema": map[string]any{"type": "object"}},
+		}}
+	case "tools/call":
+		name, _ := params["name"].(string)
+		args, _ := params["arguments"].(map[string]any)
+		switch name {
+		case "echo":
+			resp.Result = textResult(fmt.Sprintf("echo: %v", args["text"]), false)
+		case "fail":
+			resp.Result = textResult("stub failure", true)
+		case "cwd":
+			wd, _ := os.Getwd()
+			resp.Result = textResult(wd, false)
+		default:
+			resp.Error = &mcp.RPCError{Code: -32602, Message: "unknown tool: " + name}
+		}
+	case "resources/list":
+		resp.Result = map[string]any{"resources": []map[string]any{
+			{"uri": "stub://readme", "name": "readme", "mimeType": "text/plain"},
+		}}
+	case "resources/read":
+		uri, _ := params["uri"].(string)
+		if uri != "stub://readme" {
+			resp.Error = &mcp.RPCError{Code: -32002, Message: "resource not found: " + uri}
+			break
+		}
+		resp.Result = map[string]any{"contents": []map[string]any{
+			{"uri": uri, "mimeType": "text/plain", "text": ReadmeText},
+		}}
+	default:
+		resp.Error = &mcp.RPCError{Code: -32601, Message: "method not found: " + req.Method}
+	}
+	return resp
+}
+
+func textResult(text string, isError bool) map[string]any {
+	return map[string]any{
+		"content": []map[string]any{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
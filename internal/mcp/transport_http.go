@@ -0,0 +1,221 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpTransport implements the streamable HTTP transport: every client
+// message is a POST, answered with either a JSON body or an SSE stream that
+// ends with the response.
+type httpTransport struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+
+	mu              sync.Mutex
+	sessionID       string
+	protocolVersion string
+	closed          bool
+}
+
+func newHTTPTransport(cfg ServerConfig) (*httpTransport, error) {
+	u, err := url.Parse(strings.TrimSpace(cfg.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", cfg.URL)
+	}
+	return &httpTransport{
+		url:     u.String(),
+		headers: cfg.Headers,
+		// Per-request deadlines come from the context; streams may be long.
+		client: &http.Client{},
+	}, nil
+}
+
+func (t *httpTransport) setProtocolVersion(v string) {
+	t.mu.Lock()
+	t.protocolVersion = v
+	t.mu.Unlock()
+}
+
+func (t *httpTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.url, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set("MCP-Protocol-Version", t.protocolVersion)
+	}
+	t.mu.Unlock()
+	return req, nil
+}
+
+func (t *httpTransport) post(ctx context.Context, msg *message) (*http.Response, error) {
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, b)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" && msg.Method == "initialize" {
+		t.mu.Lock()
+		t.sessionID = sid
+		t.mu.Unlock()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound && t.hasSession() {
+			return nil, fmt.Errorf("%w: session expired (%s)", ErrClosed, resp.Status)
+		}
+		return nil, fmt.Errorf("http %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (t *httpTransport) hasSession() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.sessionID != ""
+}
+
+func (t *httpTransport) roundTrip(ctx context.Context, req *message) (*message, error) {
+	resp, err := t.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == "text/event-stream" {
+		return t.readSSE(ctx, resp.Body, req.ID)
+	}
+	var msg message
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	if !msg.isResponse() || string(msg.ID) != string(req.ID) {
+		return nil, fmt.Errorf("unexpected response to request %s", req.ID)
+	}
+	return &msg, nil
+}
+
+// readSSE consumes events until the response to id arrives. Server requests
+// on the stream are answered with a separate POST.
+func (t *httpTransport) readSSE(ctx context.Context, body io.Reader, id json.RawMessage) (*message, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	var data strings.Builder
+	flush := func() *message {
+		defer data.Reset()
+		if data.Len() == 0 {
+			return nil
+		}
+		var msg message
+		if err := json.Unmarshal([]byte(data.String()), &msg); err != nil {
+			return nil
+		}
+		switch {
+		case msg.isResponse() && string(msg.ID) == string(id):
+			return &msg
+		case msg.Method != "" && len(msg.ID) > 0:
+			reply := replyToServerRequest(&msg)
+			go func() {
+				if resp, err := t.post(context.WithoutCancel(ctx), reply); err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+		}
+		return nil
+	}
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if msg := flush(); msg != nil {
+				return msg, nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if msg := flush(); msg != nil {
+		return msg, nil
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("event stream ended without a response to request %s", id)
+}
+
+func (t *httpTransport) notify(ctx context.Context, msg *message) error {
+	resp, err := t.post(ctx, msg)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return resp.Body.Close()
+}
+
+func (t *httpTransport) close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	hasSession := t.sessionID != ""
+	t.mu.Unlock()
+	if !hasSession {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := t.newRequest(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return nil
+	}
+	if resp, err := t.client.Do(req); err == nil {
+		_ = resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const stderrTailBytes = 2048
+
+// stdioTransport speaks newline-delimited JSON-RPC over a subprocess's
+// stdin/stdout.
+type stdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *message
+	err     error // set once the read loop stops
+	done    chan struct{}
+
+	stderr *tailBuffer
+}
+
+func newStdioTransport(cfg ServerConfig) (*stdioTransport, error) {
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	t := &stdioTransport{
+		cmd:     cmd,
+		pending: map[string]chan *message{},
+		done:    make(chan struct{}),
+		stderr:  &tailBuffer{max: stderrTailBytes},
+	}
+	if cfg.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cfg.Stderr, t.stderr)
+	} else {
+		cmd.Stderr = t.stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	t.stdin = stdin
+	go t.readLoop(stdout)
+	return t, nil
+}
+
+func (t *stdioTransport) readLoop(r io.Reader) {
+	br := bufio.NewReader(r)
+	var readErr error
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var msg message
+			if jerr := json.Unmarshal(line, &msg); jerr == nil {
+				t.dispatch(&msg)
+			}
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	// Reap the process so the exit status and stderr tail are complete.
+	_ = t.cmd.Wait()
+	t.mu.Lock()
+	t.err = fmt.Errorf("%w: server exited (%v)%s", ErrClosed, readErr, t.stderr.suffix())
+	for id, ch := range t.pending {
+		close(ch)
+		delete(t.pending, id)
+	}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *stdioTransport) dispatch(msg *message) {
+	switch {
+	case msg.isResponse():
+		t.mu.Lock()
+		ch, ok := t.pending[string(msg.ID)]
+		delete(t.pending, string(msg.ID))
+		t.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	case msg.Method != "" && len(msg.ID) > 0:
+		go func() { _ = t.write(replyToServerRequest(msg)) }()
+	}
+	// Notifications (logging, list_changed) are ignored.
+}
+
+func (t *stdioTransport) write(msg *message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(b, '\n'))
+	return err
+}
+
+func (t *stdioTransport) roundTrip(ctx context.Context, req *message) (*message, error) {
+	ch := make(chan *message, 1)
+	key := string(req.ID)
+	t.mu.Lock()
+	if t.err != nil {
+		err := t.err
+		t.mu.Unlock()
+		return nil, err
+	}
+	t.pending[key] = ch
+	t.mu.Unlock()
+
+	if err := t.write(req); err != nil {
+		t.forget(key)
+		return nil, t.closedErr(err)
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, t.closedErr(ErrClosed)
+		}
+		return resp, nil
+	case <-ctx.Done():
+		t.forget(key)
+		return nil, ctx.Err()
+	}
+}
+
+func (t *stdioTransport) notify(_ context.Context, msg *message) error {
+	if err := t.write(msg); err != nil {
+		return t.closedErr(err)
+	}
+	return nil
+}
+
+func (t *stdioTransport) forget(key string) {
+	t.mu.Lock()
+	delete(t.pending, key)
+	t.mu.Unlock()
+}
+
+// closedErr prefers the read loop's exit error, which carries the stderr tail.
+func (t *stdioTransport) closedErr(fallback error) error {
+	select {
+	case <-t.done:
+	case <-time.After(100 * time.Millisecond):
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return t.err
+	}
+	return fallback
+}
+
+func (t *stdioTransport) close() error {
+	_ = t.stdin.Close()
+	select {
+	case <-t.done:
+	case <-time.After(2 * time.Second):
+		if t.cmd.Process != nil {
+			_ = t.cmd.Process.Kill()
+		}
+		<-t.done
+	}
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) suffix() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := strings.TrimSpace(string(b.buf))
+	if s == "" {
+		return ""
+	}
+	return "; stderr: " + s
+}
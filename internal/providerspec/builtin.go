@@ -112,6 +112,19 @@ var builtinSpecs = map[string]Spec{
 		},
 		Failover: []string{"cerebras"},
 	},
+	"ollama": {
+		Key: "ollama",
+		API: &APISpec{
+			Protocol:           ProtocolOllamaChat,
+			DefaultBaseURL:     "http://localhost:11434",
+			DefaultPath:        "/api/chat",
+			DefaultAPIKeyEnv:   "OLLAMA_API_KEY",
+			ProviderOptionsKey: "ollama",
+			ProfileFamily:      "openai",
+			APIKeyOptional:     true,
+			DiscoverModels:     true,
+		},
+	},
 }
 
 func Builtin(key string) (Spec, bool) {
@@ -12,6 +12,7 @@ const (
 	ProtocolOpenAIChatCompletions APIProtocol = "openai_chat_completions"
 	ProtocolAnthropicMessages     APIProtocol = "anthropic_messages"
 	ProtocolGoogleGenerateContent APIProtocol = "google_generate_content"
+	ProtocolOllamaChat            APIProtocol = "ollama_chat"
 )
 
 type APISpec struct {
@@ -21,6 +22,12 @@ type APISpec struct {
 	DefaultAPIKeyEnv   string
 	ProviderOptionsKey string
 	ProfileFamily      string
+	// APIKeyOptional marks local/self-hosted endpoints that accept requests
+	// without credentials. DefaultAPIKeyEnv is still honored when set.
+	APIKeyOptional bool
+	// DiscoverModels marks providers whose installed models can be listed at
+	// run start and merged into the model catalog.
+	DiscoverModels bool
 }
 
 type CLISpec struct {
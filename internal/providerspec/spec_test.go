@@ -4,7 +4,7 @@ import "testing"
 
 func TestBuiltinSpecsIncludeCoreAndNewProviders(t *testing.T) {
 	s := Builtins()
-	for _, key := range []string{"openai", "anthropic", "google", "kimi", "zai", "cerebras", "minimax"} {
+	for _, key := range []string{"openai", "anthropic", "google", "kimi", "zai", "cerebras", "minimax", "ollama"} {
 		if _, ok := s[key]; !ok {
 			t.Fatalf("missing builtin provider %q", key)
 		}
@@ -118,3 +118,28 @@ func TestBuiltinFailoverDefaultsAreSingleHop(t *testing.T) {
 		}
 	}
 }
+
+func TestBuiltinOllamaDefaultsToLocalKeylessAPI(t *testing.T) {
+	spec, ok := Builtin("ollama")
+	if !ok {
+		t.Fatalf("expected ollama builtin")
+	}
+	if spec.API == nil {
+		t.Fatalf("expected ollama api spec")
+	}
+	if got := spec.API.Protocol; got != ProtocolOllamaChat {
+		t.Fatalf("ollama protocol: got %q want %q", got, ProtocolOllamaChat)
+	}
+	if got := spec.API.DefaultBaseURL; got != "http://localhost:11434" {
+		t.Fatalf("ollama base url: got %q want %q", got, "http://localhost:11434")
+	}
+	if !spec.API.APIKeyOptional {
+		t.Fatalf("ollama should not require an api key")
+	}
+	if !spec.API.DiscoverModels {
+		t.Fatalf("ollama should discover installed models")
+	}
+	if spec.CLI != nil {
+		t.Fatalf("ollama should not declare a cli contract")
+	}
+}
@@ -0,0 +1,226 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExportRequest is the OTLP/JSON ExportTraceServiceRequest body.
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// OTLPSpan is one span in OTLP/JSON form: IDs are lowercase hex and
+// timestamps are decimal strings of Unix nanoseconds.
+type OTLPSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+// Attr returns the attribute value rendered as a string ("" when absent).
+func (s OTLPSpan) Attr(key string) string {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value.String()
+		}
+	}
+	return ""
+}
+
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds exactly one of its fields. intValue is a string, as OTLP/JSON
+// encodes 64-bit integers.
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return fmt.Sprint(*v.DoubleValue)
+	case v.BoolValue != nil:
+		return fmt.Sprint(*v.BoolValue)
+	default:
+		return ""
+	}
+}
+
+// Exporter ships batches of ended spans.
+type Exporter interface {
+	Export(ctx context.Context, req *ExportRequest) error
+	Shutdown(ctx context.Context) error
+}
+
+// FileExporter appends each batch as one line of OTLP/JSON, for offline runs.
+// The file can be replayed to a collector or read with jq.
+type FileExporter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func NewFileExporter(path string) *FileExporter {
+	return &FileExporter{Path: path}
+}
+
+func (e *FileExporter) Export(_ context.Context, req *ExportRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(e.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *FileExporter) Shutdown(context.Context) error { return nil }
+
+// ReadFile decodes every span in a FileExporter output file, in export order.
+func ReadFile(path string) ([]OTLPSpan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []OTLPSpan
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var req ExportRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				out = append(out, ss.Spans...)
+			}
+		}
+	}
+	return out, nil
+}
+
+type OTLPHTTPConfig struct {
+	// Endpoint is the collector base URL (http://localhost:4318) or the full
+	// traces URL. "/v1/traces" is appended when the URL has no path.
+	Endpoint string
+	Headers  map[string]string
+	Timeout  time.Duration
+	Client   *http.Client
+}
+
+// OTLPHTTPExporter posts batches to an OTLP/HTTP collector using the JSON
+// encoding.
+type OTLPHTTPExporter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewOTLPHTTPExporter(cfg OTLPHTTPConfig) (*OTLPHTTPExporter, error) {
+	u, err := url.Parse(strings.TrimSpace(cfg.Endpoint))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q", cfg.Endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	client := cfg.Client
+	if client == nil {
+		timeout := cfg.Timeout
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+	return &OTLPHTTPExporter{url: u.String(), headers: cfg.Headers, client: client}, nil
+}
+
+func (e *OTLPHTTPExporter) Export(ctx context.Context, req *ExportRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		httpReq.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("otlp export: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp export: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (e *OTLPHTTPExporter) Shutdown(context.Context) error { return nil }
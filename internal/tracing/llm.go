@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+// LLMMiddleware records a client span per LLM request, with attributes from
+// the OpenTelemetry GenAI conventions (model, token usage, finish reason) and
+// the observed latency. Requests whose context carries no tracer pass through
+// untouched.
+func LLMMiddleware() llm.Middleware {
+	return llm.MiddlewareFunc{
+		Complete: func(ctx context.Context, req llm.Request, next llm.CompleteFunc) (llm.Response, error) {
+			ctx, span := startLLMSpan(ctx, "llm.complete", req)
+			if span == nil {
+				return next(ctx, req)
+			}
+			start := time.Now()
+			resp, err := next(ctx, req)
+			span.SetAttributes(Int64("llm.latency_ms", time.Since(start).Milliseconds()))
+			if err != nil {
+				span.RecordError(err)
+			} else {
+				setResponseAttributes(span, resp.ID, resp.Model, resp.Finish.Reason, resp.Usage)
+			}
+			span.End()
+			return resp, err
+		},
+		Stream: func(ctx context.Context, req llm.Request, next llm.StreamFunc) (llm.Stream, error) {
+			ctx, span := startLLMSpan(ctx, "llm.stream", req)
+			if span == nil {
+				return next(ctx, req)
+			}
+			st, err := next(ctx, req)
+			if err != nil {
+				span.RecordError(err)
+				span.End()
+				return nil, err
+			}
+			return newTracedStream(st, span), nil
+		},
+	}
+}
+
+func startLLMSpan(ctx context.Context, name string, req llm.Request) (context.Context, *Span) {
+	ctx, span := Start(ctx, name,
+		String("gen_ai.system", req.Provider),
+		String("gen_ai.request.model", req.Model),
+		Int("llm.request.messages", len(req.Messages)),
+		Int("llm.request.tools", len(req.Tools)),
+	)
+	span.SetKind(SpanKindClient)
+	return ctx, span
+}
+
+func setResponseAttributes(span *Span, id, model, finish string, u llm.Usage) {
+	span.SetAttributes(
+		String("gen_ai.response.id", id),
+		String("gen_ai.response.model", model),
+		String("gen_ai.response.finish_reasons", finish),
+		Int("gen_ai.usage.input_tokens", u.InputTokens),
+		Int("gen_ai.usage.output_tokens", u.OutputTokens),
+	)
+	if u.ReasoningTokens != nil {
+		span.SetAttributes(Int("gen_ai.usage.reasoning_tokens", *u.ReasoningTokens))
+	}
+	if u.CacheReadTokens != nil {
+		span.SetAttributes(Int("gen_ai.usage.cache_read_tokens", *u.CacheReadTokens))
+	}
+}
+
+// tracedStream forwards events unchanged and ends the span when the stream
+// finishes or is closed.
+type tracedStream struct {
+	inner  llm.Stream
+	span   *Span
+	start  time.Time
+	events chan llm.StreamEvent
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newTracedStream(inner llm.Stream, span *Span) *tracedStream {
+	s := &tracedStream{
+		inner:   inner,
+		span:    span,
+		start:   time.Now(),
+		events:  make(chan llm.StreamEvent, 128),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.forward()
+	return s
+}
+
+func (s *tracedStream) forward() {
+	defer close(s.done)
+	defer close(s.events)
+	defer s.span.End()
+	first := true
+	for ev := range s.inner.Events() {
+		switch ev.Type {
+		case llm.StreamEventTextDelta, llm.StreamEventReasoningDelta, llm.StreamEventToolCallStart:
+			if first {
+				first = false
+				s.span.SetAttributes(Int64("llm.time_to_first_token_ms", time.Since(s.start).Milliseconds()))
+			}
+		case llm.StreamEventFinish:
+			s.span.SetAttributes(Int64("llm.latency_ms", time.Since(s.start).Milliseconds()))
+			var id, model, finish string
+			var usage llm.Usage
+			if ev.Response != nil {
+				id, model, finish, usage = ev.Response.ID, ev.Response.Model, ev.Response.Finish.Reason, ev.Response.Usage
+			}
+			if ev.FinishReason != nil {
+				finish = ev.FinishReason.Reason
+			}
+			if ev.Usage != nil {
+				usage = *ev.Usage
+			}
+			setResponseAttributes(s.span, id, model, finish, usage)
+		case llm.StreamEventError:
+			s.span.RecordError(ev.Err)
+		}
+		select {
+		case s.events <- ev:
+		case <-s.closing:
+			return
+		}
+	}
+}
+
+func (s *tracedStream) Events() <-chan llm.StreamEvent { return s.events }
+
+func (s *tracedStream) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	err := s.inner.Close()
+	<-s.done
+	return err
+}
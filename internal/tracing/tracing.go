@@ -0,0 +1,376 @@
+// Package tracing records OpenTelemetry-compatible spans for runs, agent
+// sessions, LLM calls and CLI subprocesses, and exports them as OTLP/JSON
+// (over HTTP or to a local file).
+//
+// The active Tracer and Span travel in a context.Context. Every entry point is
+// a no-op when the context carries no tracer, so instrumented code never needs
+// to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+func (id SpanID) IsValid() bool   { return id != SpanID{} }
+
+// TraceIDFromSeed derives a stable trace ID, so separate processes working on
+// the same run (for example a resume) contribute to one trace.
+func TraceIDFromSeed(seed string) TraceID {
+	sum := sha256.Sum256([]byte(seed))
+	var id TraceID
+	copy(id[:], sum[:len(id)])
+	return id
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+// SpanKind values follow the OTLP enum.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode values follow the OTLP enum.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+func String(key, v string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &v}}
+}
+
+func Int(key string, v int) KeyValue { return Int64(key, int64(v)) }
+
+func Int64(key string, v int64) KeyValue {
+	s := strconv.FormatInt(v, 10)
+	return KeyValue{Key: key, Value: AnyValue{IntValue: &s}}
+}
+
+func Float64(key string, v float64) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{DoubleValue: &v}}
+}
+
+func Bool(key string, v bool) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{BoolValue: &v}}
+}
+
+type Options struct {
+	ServiceName    string
+	ServiceVersion string
+	// ResourceAttributes are attached to every exported batch (run ID, graph
+	// name, ...).
+	ResourceAttributes []KeyValue
+	// BatchSize triggers an export once this many spans have ended (default 64).
+	BatchSize int
+	// FlushInterval bounds how long an ended span waits before export
+	// (default 5s).
+	FlushInterval time.Duration
+	// OnError receives export failures. Export errors never fail the caller.
+	OnError func(error)
+}
+
+// Tracer buffers ended spans and exports them in batches from a background
+// goroutine. Call Shutdown to flush the remainder.
+type Tracer struct {
+	exp      Exporter
+	opts     Options
+	resource Resource
+
+	mu      sync.Mutex
+	pending []OTLPSpan
+	closed  bool
+
+	exportMu sync.Mutex
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewTracer(exp Exporter, opts Options) *Tracer {
+	if opts.ServiceName == "" {
+		opts.ServiceName = "kilroy"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 64
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	attrs := []KeyValue{String("service.name", opts.ServiceName)}
+	if opts.ServiceVersion != "" {
+		attrs = append(attrs, String("service.version", opts.ServiceVersion))
+	}
+	attrs = append(attrs, opts.ResourceAttributes...)
+	t := &Tracer{
+		exp:      exp,
+		opts:     opts,
+		resource: Resource{Attributes: attrs},
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.kick:
+		}
+		_ = t.Flush(context.Background())
+	}
+}
+
+func (t *Tracer) enqueue(s OTLPSpan) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.pending = append(t.pending, s)
+	full := len(t.pending) >= t.opts.BatchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush exports all spans that have ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	err := t.exp.Export(ctx, &ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource: t.resource,
+		ScopeSpans: []ScopeSpans{{
+			Scope: Scope{Name: "github.com/danshapiro/kilroy", Version: t.opts.ServiceVersion},
+			Spans: batch,
+		}},
+	}}})
+	if err != nil && t.opts.OnError != nil {
+		t.opts.OnError(err)
+	}
+	return err
+}
+
+// Shutdown stops background export, flushes pending spans and shuts the
+// exporter down. Spans ending afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+	err := t.Flush(ctx)
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	if serr := t.exp.Shutdown(ctx); err == nil {
+		err = serr
+	}
+	return err
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+type traceIDKey struct{}
+
+func WithTracer(ctx context.Context, t *Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, t)
+}
+
+func TracerFromContext(ctx context.Context) *Tracer {
+	t, _ := ctx.Value(tracerKey{}).(*Tracer)
+	return t
+}
+
+// WithTraceID makes root spans started from ctx use id instead of a random
+// trace ID.
+func WithTraceID(ctx context.Context, id TraceID) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start begins a span as a child of the span in ctx (or a new root) and
+// returns a context carrying it. Without a tracer in ctx it returns ctx and a
+// nil *Span, whose methods are all no-ops.
+func Start(ctx context.Context, name string, attrs ...KeyValue) (context.Context, *Span) {
+	t := TracerFromContext(ctx)
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   SpanKindInternal,
+		spanID: newSpanID(),
+		start:  time.Now(),
+		attrs:  append([]KeyValue{}, attrs...),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else if id, ok := ctx.Value(traceIDKey{}).(TraceID); ok && id.IsValid() {
+		s.traceID = id
+	} else {
+		s.traceID = newTraceID()
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+type Span struct {
+	tracer   *Tracer
+	traceID  TraceID
+	spanID   SpanID
+	parentID SpanID
+	name     string
+	start    time.Time
+
+	mu        sync.Mutex
+	kind      SpanKind
+	attrs     []KeyValue
+	status    StatusCode
+	statusMsg string
+	ended     bool
+}
+
+func (s *Span) TraceID() TraceID {
+	if s == nil {
+		return TraceID{}
+	}
+	return s.traceID
+}
+
+func (s *Span) SpanID() SpanID {
+	if s == nil {
+		return SpanID{}
+	}
+	return s.spanID
+}
+
+// Traceparent renders the W3C trace-context header for propagating the span to
+// a child process or remote service. Empty for a nil span.
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + s.traceID.String() + "-" + s.spanID.String() + "-01"
+}
+
+func (s *Span) SetKind(k SpanKind) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.kind = k
+	s.mu.Unlock()
+}
+
+func (s *Span) SetAttributes(attrs ...KeyValue) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+func (s *Span) SetStatus(code StatusCode, msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.status = code
+	s.statusMsg = msg
+	s.mu.Unlock()
+}
+
+// RecordError marks the span failed with err's message. Nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End records the span. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	rec := OTLPSpan{
+		TraceID:           s.traceID.String(),
+		SpanID:            s.spanID.String(),
+		Name:              s.name,
+		Kind:              int(s.kind),
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        s.attrs,
+		Status:            Status{Code: int(s.status), Message: s.statusMsg},
+	}
+	if s.parentID.IsValid() {
+		rec.ParentSpanID = s.parentID.String()
+	}
+	s.mu.Unlock()
+	s.tracer.enqueue(rec)
+}
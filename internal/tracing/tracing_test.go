@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danshapiro/kilroy/internal/llm"
+)
+
+func TestStart_WithoutTracer_IsNoop(t *testing.T) {
+	ctx, span := Start(context.Background(), "x", String("k", "v"))
+	if span != nil {
+		t.Fatalf("expected nil span without tracer")
+	}
+	span.SetAttributes(Int("n", 1))
+	span.RecordError(errors.New("boom"))
+	span.End()
+	if span.Traceparent() != "" || SpanFromContext(ctx) != nil {
+		t.Fatalf("nil span must not propagate")
+	}
+}
+
+func TestTracer_FileExporter_NestsSpansInOneTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	tr := NewTracer(NewFileExporter(path), Options{ServiceName: "test", BatchSize: 2})
+	ctx := WithTraceID(WithTracer(context.Background(), tr), TraceIDFromSeed("run-1"))
+
+	ctx, root := Start(ctx, "root")
+	cctx, child := Start(ctx, "child", String("k", "v"))
+	_, grandchild := Start(cctx, "grandchild")
+	grandchild.RecordError(errors.New("boom"))
+	grandchild.End()
+	child.End()
+	child.End() // second End is ignored
+	root.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	spans, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(spans) != 3 {
+		t.Fatalf("spans: got %d want 3", len(spans))
+	}
+	byName := map[string]OTLPSpan{}
+	for _, s := range spans {
+		if s.TraceID != TraceIDFromSeed("run-1").String() {
+			t.Fatalf("span %s trace id %s not derived from seed", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	if byName["root"].ParentSpanID != "" {
+		t.Fatalf("root has parent %q", byName["root"].ParentSpanID)
+	}
+	if byName["child"].ParentSpanID != byName["root"].SpanID || byName["grandchild"].ParentSpanID != byName["child"].SpanID {
+		t.Fatalf("bad parent chain: %+v", byName)
+	}
+	if byName["child"].Attr("k") != "v" {
+		t.Fatalf("child attrs: %+v", byName["child"].Attributes)
+	}
+	if byName["grandchild"].Status.Code != int(StatusError) || byName["grandchild"].Status.Message != "boom" {
+		t.Fatalf("grandchild status: %+v", byName["grandchild"].Status)
+	}
+	if want := "00-" + root.TraceID().String() + "-" + root.SpanID().String() + "-01"; root.Traceparent() != want {
+		t.Fatalf("traceparent: %q", root.Traceparent())
+	}
+}
+
+func TestOTLPHTTPExporter_PostsJSONToTracesPath(t *testing.T) {
+	got := make(chan ExportRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("x-token") != "secret" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		b, _ := io.ReadAll(r.Body)
+		var req ExportRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		got <- req
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	exp, err := NewOTLPHTTPExporter(OTLPHTTPConfig{Endpoint: srv.URL, Headers: map[string]string{"x-token": "secret"}})
+	if err != nil {
+		t.Fatalf("NewOTLPHTTPExporter: %v", err)
+	}
+	tr := NewTracer(exp, Options{ServiceName: "svc", ServiceVersion: "1.0"})
+	_, span := Start(WithTracer(context.Background(), tr), "op")
+	span.End()
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	select {
+	case req := <-got:
+		rs := req.ResourceSpans[0]
+		if rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value.String() != "svc" {
+			t.Fatalf("resource: %+v", rs.Resource)
+		}
+		if s := rs.ScopeSpans[0].Spans[0]; s.Name != "op" || len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Fatalf("span: %+v", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("collector received nothing")
+	}
+}
+
+func TestOTLPHTTPExporter_ReportsCollectorErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	exp, err := NewOTLPHTTPExporter(OTLPHTTPConfig{Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewOTLPHTTPExporter: %v", err)
+	}
+	var reported error
+	tr := NewTracer(exp, Options{OnError: func(err error) { reported = err }})
+	_, span := Start(WithTracer(context.Background(), tr), "op")
+	span.End()
+	if err := tr.Shutdown(context.Background()); err == nil || reported == nil {
+		t.Fatalf("expected export error (shutdown=%v reported=%v)", err, reported)
+	}
+}
+
+type usageAdapter struct{}
+
+func (usageAdapter) Name() string { return "fake" }
+
+func (usageAdapter) Complete(ctx context.Context, req llm.Request) (llm.Response, error) {
+	return llm.Response{
+		ID:       "resp_1",
+		Model:    req.Model,
+		Provider: "fake",
+		Message:  llm.Assistant("hi"),
+		Finish:   llm.FinishReason{Reason: "stop"},
+		Usage:    llm.Usage{InputTokens: 12, OutputTokens: 3, TotalTokens: 15},
+	}, nil
+}
+
+func (usageAdapter) Stream(ctx context.Context, req llm.Request) (llm.Stream, error) {
+	s := llm.NewChanStream(nil)
+	go func() {
+		defer s.CloseSend()
+		s.Send(llm.StreamEvent{Type: llm.StreamEventTextDelta, Delta: "hi"})
+		u := llm.Usage{InputTokens: 7, OutputTokens: 1}
+		s.Send(llm.StreamEvent{Type: llm.StreamEventFinish, FinishReason: &llm.FinishReason{Reason: "stop"}, Usage: &u})
+	}()
+	return s, nil
+}
+
+func TestLLMMiddleware_RecordsModelUsageAndFinishReason(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	tr := NewTracer(NewFileExporter(path), Options{})
+	ctx := WithTracer(context.Background(), tr)
+
+	c := llm.NewClient()
+	c.Register(usageAdapter{})
+	c.Use(LLMMiddleware())
+	req := llm.Request{Provider: "fake", Model: "m1", Messages: []llm.Message{llm.User("hello")}}
+	if _, err := c.Complete(ctx, req); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	st, err := c.Stream(ctx, req)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	for range st.Events() {
+	}
+	_ = st.Close()
+	// Untraced calls must still work.
+	if _, err := c.Complete(context.Background(), req); err != nil {
+		t.Fatalf("Complete without tracer: %v", err)
+	}
+	if err := tr.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	spans, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("spans: got %d want 2", len(spans))
+	}
+	for _, s := range spans {
+		if s.Kind != int(SpanKindClient) || s.Attr("gen_ai.system") != "fake" || s.Attr("gen_ai.request.model") != "m1" {
+			t.Fatalf("span %s: %+v", s.Name, s)
+		}
+		if s.Attr("gen_ai.response.finish_reasons") != "stop" || s.Attr("llm.latency_ms") == "" {
+			t.Fatalf("span %s missing response attrs: %+v", s.Name, s.Attributes)
+		}
+	}
+	if spans[0].Name != "llm.complete" || spans[0].Attr("gen_ai.usage.input_tokens") != "12" || spans[0].Attr("gen_ai.usage.output_tokens") != "3" {
+		t.Fatalf("complete span: %+v", spans[0])
+	}
+	if spans[1].Name != "llm.stream" || spans[1].Attr("gen_ai.usage.input_tokens") != "7" || spans[1].Attr("llm.time_to_first_token_ms") == "" {
+		t.Fatalf("stream span: %+v", spans[1])
+	}
+}